@@ -0,0 +1,361 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// The three files ACM's import form and ImportCertificate API take
+const (
+	acmCertificateFile = "certificate.pem"
+	acmPrivateKeyFile  = "private_key.pem"
+	acmChainFile       = "certificate_chain.pem"
+)
+
+// awsCredentials are the access keys used to sign AWS API requests
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// runExportACM implements export acm
+func runExportACM(args []string) error {
+	fs := flag.NewFlagSet("export acm", flag.ExitOnError)
+	certFlag := fs.String("cert", "", "Certificate to export")
+	keyFlag := fs.String("key", "", "Private key of the certificate")
+	chainFlag := fs.String("chain", "", "Intermediate and root certificates, in any order")
+	outDirFlag := fs.String("out-dir", ".", "Directory for certificate.pem, private_key.pem, and certificate_chain.pem")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted private key (pass:, env:, file:, or stdin)")
+	importFlag := fs.Bool("import", false, "Also import the certificate into ACM with acm:ImportCertificate")
+	regionFlag := fs.String("region", "", "AWS region to import into (default: $AWS_REGION or $AWS_DEFAULT_REGION)")
+	arnFlag := fs.String("arn", "", "ARN of an imported certificate to replace, keeping its associations")
+	parseArgs(fs, args)
+
+	if *certFlag == "" || *keyFlag == "" {
+		return fmt.Errorf("export acm requires --cert and --key")
+	}
+	var keyPassword string
+	if *passinFlag != "" {
+		var err error
+		if keyPassword, err = readPassphrase(*passinFlag); err != nil {
+			return err
+		}
+	}
+
+	cert, key, chain, err := readExportInputs(*certFlag, *keyFlag, *chainFlag, keyPassword)
+	if err != nil {
+		return err
+	}
+	chain, err = orderIssuerChain(cert, chain)
+	if err != nil {
+		return err
+	}
+
+	// ACM takes an unencrypted RSA or ECDSA key in its traditional encoding
+	var keyBlock *pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if bits := k.N.BitLen(); bits != 1024 && bits != 2048 && bits != 3072 && bits != 4096 {
+			return fmt.Errorf("ACM does not import %d-bit RSA keys (use 2048, 3072, or 4096 bits)", bits)
+		}
+		keyBlock = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return fmt.Errorf("Failed to encode private key: %v", err)
+		}
+		keyBlock = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		return fmt.Errorf("ACM does not import %s keys (use RSA or ECDSA)", certforge.PrivateKeyDescription(key))
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPEM := pem.EncodeToMemory(keyBlock)
+	var chainPEM []byte
+	for _, c := range chain {
+		chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+
+	if err := os.MkdirAll(*outDirFlag, 0755); err != nil {
+		return fmt.Errorf("Error creating output directory: %v", err)
+	}
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{acmCertificateFile, certPEM, 0644},
+		{acmPrivateKeyFile, keyPEM, 0600},
+		{acmChainFile, chainPEM, 0644},
+	}
+	for _, file := range files {
+		path := filepath.Join(*outDirFlag, file.name)
+		// A certificate issued directly by a root has no chain, so a chain left from an earlier export must go
+		if len(file.data) == 0 {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("Failed to remove %s: %v", path, err)
+			}
+			continue
+		}
+		if err := os.WriteFile(path, file.data, file.mode); err != nil {
+			return fmt.Errorf("Failed to write %s: %v", path, err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+	fmt.Printf("  Certificate: %s\n", certforge.FormatName(cert.Subject))
+	fmt.Printf("  Private Key: %s\n", certforge.PrivateKeyDescription(key))
+	for i, c := range chain {
+		fmt.Printf("  Chain %d: %s\n", i+1, certforge.FormatName(c.Subject))
+	}
+	if len(chain) == 0 && !certforge.IsSelfSigned(cert) {
+		fmt.Println("  Warning: no chain given; clients will not be able to build a path to a trusted root")
+	}
+
+	if !*importFlag {
+		if *regionFlag != "" || *arnFlag != "" {
+			return fmt.Errorf("--region and --arn only apply with --import")
+		}
+		return nil
+	}
+
+	region := *regionFlag
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return fmt.Errorf("No AWS region; use --region or set AWS_REGION")
+	}
+	creds, err := loadAWSCredentials()
+	if err != nil {
+		return err
+	}
+	arn, err := importACMCertificate(creds, region, *arnFlag, certPEM, keyPEM, chainPEM)
+	if err != nil {
+		return err
+	}
+	if *arnFlag != "" {
+		fmt.Printf("\nReimported into ACM (%s): %s\n", region, arn)
+	} else {
+		fmt.Printf("\nImported into ACM (%s): %s\n", region, arn)
+	}
+	return nil
+}
+
+// orderIssuerChain orders chain from the issuer of cert up to the root, rejecting certificates that do not belong to it
+func orderIssuerChain(cert *x509.Certificate, chain []*x509.Certificate) ([]*x509.Certificate, error) {
+	var ordered []*x509.Certificate
+	for current := cert; !certforge.IsSelfSigned(current) && len(ordered) < len(chain); {
+		issuer, err := findChainIssuer(current, chain)
+		if issuer == nil {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Chain certificate %s did not sign %s: %v", certforge.FormatName(issuer.Subject), certforge.FormatName(current.Subject), err)
+		}
+		ordered = append(ordered, issuer)
+		current = issuer
+	}
+	for _, c := range chain {
+		if !containsCertificate(ordered, c) {
+			return nil, fmt.Errorf("Chain certificate %s is not an issuer of %s", certforge.FormatName(c.Subject), certforge.FormatName(cert.Subject))
+		}
+	}
+	return ordered, nil
+}
+
+// loadAWSCredentials reads access keys from the environment, or from the shared credentials file
+// for the profile named by AWS_PROFILE (default: "default")
+func loadAWSCredentials() (*awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("No AWS credentials; set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("No AWS credentials; set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or create %s", path)
+	}
+	defer file.Close()
+
+	creds := &awsCredentials{}
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch strings.TrimSpace(name) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("No access keys for profile %q in %s", profile, path)
+	}
+	return creds, nil
+}
+
+// importACMCertificate calls acm:ImportCertificate and returns the ARN of the certificate
+func importACMCertificate(creds *awsCredentials, region, arn string, cert, key, chain []byte) (string, error) {
+	// The JSON protocol carries blobs base64 encoded, as encoding/json does for []byte
+	request := struct {
+		CertificateArn   string `json:",omitempty"`
+		Certificate      []byte
+		PrivateKey       []byte
+		CertificateChain []byte `json:",omitempty"`
+	}{arn, cert, key, chain}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := fmt.Sprintf("https://acm.%s.amazonaws.com/", region)
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "CertificateManager.ImportCertificate")
+	signAWSRequest(req, body, creds, region, "acm", time.Now())
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to call ACM: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read ACM response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &failure) == nil && failure.Type != "" {
+			// The type is sometimes namespaced, like "com.amazonaws.acm#ValidationException"
+			name := failure.Type[strings.LastIndex(failure.Type, "#")+1:]
+			return "", fmt.Errorf("ACM import failed: %s: %s", name, failure.Message)
+		}
+		return "", fmt.Errorf("ACM import failed: %s", resp.Status)
+	}
+
+	var result struct {
+		CertificateArn string
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("Failed to parse ACM response: %v", err)
+	}
+	return result.CertificateArn, nil
+}
+
+// signAWSRequest adds an AWS Signature Version 4 Authorization header to req
+func signAWSRequest(req *http.Request, body []byte, creds *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256.Sum256(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// Canonical request: every header set above is signed, along with the host
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	signingKey := mac(mac(mac(mac([]byte("AWS4"+creds.SecretAccessKey), date), region), service), "aws4_request")
+	signature := hex.EncodeToString(mac(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
@@ -0,0 +1,649 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// Let's Encrypt's staging environment issues untrusted certificates under much higher rate limits
+const acmeStagingURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+// runACME implements the acme command, which obtains a certificate from an ACME CA
+func runACME(args []string) error {
+	if len(args) > 0 && args[0] == "account" {
+		return runACMEAccount(args[1:])
+	}
+
+	fs := flag.NewFlagSet("acme", flag.ExitOnError)
+	domainFlag := fs.String("domain", "", "Comma separated domain names; the first is the common name")
+	emailFlag := fs.String("email", "", "Comma separated contact addresses for the ACME account, used for expiry notices")
+	account := addACMEAccountFlags(fs)
+	agreeTOSFlag := fs.Bool("agree-tos", false, "Agree to the CA's terms of service when registering")
+	eabKIDFlag := fs.String("eab-kid", "", "External Account Binding key ID, for CAs that require one when registering")
+	eabHMACKeyFlag := fs.String("eab-hmac-key", "", "External Account Binding HMAC key, base64url encoded as the CA shows it")
+	challengeFlag := fs.String("challenge", "http-01", "Challenge type: http-01, or tls-alpn-01 when only port 443 is reachable")
+	httpPortFlag := fs.Int("http-port", 80, "Port of the temporary HTTP-01 challenge server")
+	webrootFlag := fs.String("webroot", "", "Serve HTTP-01 challenges by writing them below this document root instead")
+	tlsPortFlag := fs.Int("tls-port", 443, "Port of the temporary TLS-ALPN-01 challenge server")
+	keyFlag := fs.String("key", "", "Existing private key to certify (default: generate a new one)")
+	keyTypeFlag := fs.String("key-type", "rsa", "Type of a new key: rsa, ecdsa, or tpm (ECDSA P-256 kept in the TPM)")
+	keySizeFlag := fs.Int("key-size", 2048, "Size of a new RSA key: 2048, 3072, or 4096")
+	outFlag := fs.String("out", "", "Output file prefix (default: the first domain)")
+	outputDirFlag := fs.String("o", "", "Output directory for generated files (default: current directory)")
+	layoutFlag := fs.String("layout", layoutFlat, "Output layout: flat, or certbot for live/<name>/*.pem linked to archive/<name>")
+	timeoutFlag := fs.Duration("timeout", 5*time.Minute, "Give up when the order is not complete after this long")
+	preHookFlag := fs.String("pre-hook", "", "Command to run before ordering, like stopping a server that holds port 80")
+	postHookFlag := fs.String("post-hook", "", "Command to run after ordering, like systemctl reload nginx")
+	hookTimeoutFlag := fs.Duration("hook-timeout", defaultHookTimeout, "Kill a hook that runs longer than this")
+	mustStapleFlag := fs.Bool("must-staple", false, "Ask for the TLS Feature extension requiring OCSP stapling (Must-Staple)")
+	parseArgs(fs, args)
+
+	var domains []string
+	for _, name := range strings.Split(*domainFlag, ",") {
+		name, err := certforge.ToASCII(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		if name != "" && !contains(domains, name) {
+			if err := certforge.CheckDNSName(name); err != nil {
+				return err
+			}
+			domains = append(domains, name)
+		}
+	}
+	if len(domains) == 0 {
+		return fmt.Errorf("acme requires --domain")
+	}
+	warnCoveredNames(domains)
+	challenge := acmeChallengeOptions{Type: strings.ToLower(*challengeFlag), HTTPPort: *httpPortFlag, Webroot: *webrootFlag, TLSPort: *tlsPortFlag}
+	switch challenge.Type {
+	case "http-01":
+	case "tls-alpn-01":
+		if challenge.Webroot != "" {
+			return fmt.Errorf("--webroot only applies to http-01 challenges")
+		}
+	default:
+		return fmt.Errorf("Invalid --challenge %q (use http-01 or tls-alpn-01)", *challengeFlag)
+	}
+	eab, err := parseACMEEAB(*eabKIDFlag, *eabHMACKeyFlag)
+	if err != nil {
+		return err
+	}
+	layout, err := validateLayout(*layoutFlag)
+	if err != nil {
+		return err
+	}
+	if *hookTimeoutFlag <= 0 {
+		return fmt.Errorf("Invalid --hook-timeout %s", *hookTimeoutFlag)
+	}
+
+	ctx, cancel := commandContext(*timeoutFlag)
+	defer cancel()
+
+	// The certificate key is prepared first so a bad --key fails before any request is made
+	var key crypto.Signer
+	if *keyFlag != "" {
+		existing, err := readPrivateKey(*keyFlag, "")
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if key, ok = existing.(crypto.Signer); !ok {
+			return fmt.Errorf("Unsupported private key in %s", *keyFlag)
+		}
+	} else if key, err = generateACMEKey(ctx, *keyTypeFlag, *keySizeFlag); err != nil {
+		return err
+	}
+	defer closeTPMKey(key)
+
+	client, _, err := account.client(true)
+	if err != nil {
+		return err
+	}
+	if _, _, err := registerACMEAccount(ctx, client, acmeContacts(*emailFlag), *agreeTOSFlag, eab); err != nil {
+		return err
+	}
+
+	prefix := *outFlag
+	if prefix == "" {
+		prefix = strings.TrimPrefix(domains[0], "*.")
+	}
+	paths := newIssuedPaths(layout, *outputDirFlag, prefix)
+	// The certbot layout keeps a copy of the key in every version
+	writeKey := *keyFlag == "" || layout == layoutCertbot
+	if !writeKey {
+		paths.Key = *keyFlag
+	}
+	env := hookEnv(prefix, domains, paths)
+	if *preHookFlag != "" {
+		if err := runHook("pre-hook", *preHookFlag, env, *hookTimeoutFlag); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Ordering a certificate for %s from %s\n", strings.Join(domains, ", "), client.DirectoryURL)
+	var extensions []pkix.Extension
+	if *mustStapleFlag {
+		extensions = append(extensions, certforge.MustStapleExtension())
+	}
+	leaf, err := issueACMEFiles(ctx, client, domains, key, extensions, challenge, layout, *outputDirFlag, prefix, writeKey)
+
+	// The post-hook runs even after a failure, so a server stopped by the pre-hook is started again
+	if *postHookFlag != "" {
+		var notAfter time.Time
+		if leaf != nil {
+			notAfter = leaf.NotAfter
+		}
+		if hookErr := runHook("post-hook", *postHookFlag, postHookEnv(env, notAfter, err), *hookTimeoutFlag); hookErr != nil && err == nil {
+			err = hookErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\nSuccess!")
+	if writeKey {
+		fmt.Printf("Private key saved to: %s\n", paths.Key)
+	}
+	if paths.CSR != "" {
+		fmt.Printf("CSR saved to: %s\n", paths.CSR)
+	}
+	fmt.Printf("Certificate saved to: %s\n", paths.Cert)
+	fmt.Printf("Chain saved to: %s\n", paths.Chain)
+	fmt.Printf("Full chain saved to: %s\n", paths.FullChain)
+	fmt.Printf("Issued by %s, valid until %s\n", certforge.FormatName(leaf.Issuer), leaf.NotAfter.Format("2006-01-02"))
+	return nil
+}
+
+// issueACMEFiles obtains a certificate and writes its files, returning the issued certificate
+func issueACMEFiles(ctx context.Context, client *acme.Client, domains []string, key crypto.Signer, extensions []pkix.Extension, challenge acmeChallengeOptions, layout, dir, prefix string, writeKey bool) (*x509.Certificate, error) {
+	csrDER, chain, err := obtainACMECertificate(ctx, client, domains, key, extensions, challenge)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writeIssuedFiles(layout, dir, prefix, key, csrDER, chain, writeKey); err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse issued certificate: %v", err)
+	}
+	return leaf, nil
+}
+
+// obtainACMECertificate orders a certificate for domains and key, with extensions in the CSR, answering challenges,
+// and returns the CSR and issued chain
+func obtainACMECertificate(ctx context.Context, client *acme.Client, domains []string, key crypto.Signer, extensions []pkix.Extension, challenge acmeChallengeOptions) ([]byte, [][]byte, error) {
+	// Checked before ordering, so authorizations are not used up on a key that cannot be certified
+	if err := certforge.CheckKey(key); err != nil {
+		return nil, nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to create order: %v", err)
+	}
+
+	// Prove control of each name; authorizations still valid from earlier orders are reused
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to fetch authorization: %v", err)
+		}
+		if authz.Status == acme.StatusValid {
+			fmt.Printf("  %s: already authorized\n", authz.Identifier.Value)
+			continue
+		}
+		if err := solveACMEChallenge(ctx, client, authz, challenge); err != nil {
+			return nil, nil, err
+		}
+		fmt.Printf("  %s: authorized\n", authz.Identifier.Value)
+	}
+
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, nil, fmt.Errorf("Order failed: %v", err)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: domains[0]},
+		DNSNames:        domains,
+		ExtraExtensions: extensions,
+	}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating CSR: %v", err)
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csrDER, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to finalize order: %v", err)
+	}
+	return csrDER, chain, nil
+}
+
+// issuedPaths are the files written for an issued certificate
+type issuedPaths struct {
+	Key       string
+	CSR       string
+	Cert      string
+	Chain     string
+	FullChain string
+}
+
+// newIssuedPaths returns the files for prefix in dir, named like generated files or laid out like certbot's
+func newIssuedPaths(layout, dir, prefix string) issuedPaths {
+	if layout == layoutCertbot {
+		return certbotPaths(dir, prefix)
+	}
+	base := filepath.Join(dir, prefix)
+	return issuedPaths{
+		Key:       base + ".key",
+		CSR:       base + ".csr",
+		Cert:      base + ".crt",
+		Chain:     base + ".chain.pem",
+		FullChain: base + ".fullchain.pem",
+	}
+}
+
+// writeIssuedFiles saves the key, CSR, certificate, chain, and full chain with the same names as generated files,
+// or a new version of the key and certificates in the certbot layout
+func writeIssuedFiles(layout, dir, prefix string, key crypto.Signer, csrDER []byte, chain [][]byte, writeKey bool) (issuedPaths, error) {
+	if layout == layoutCertbot {
+		paths, err := writeCertbotFiles(dir, prefix, key, chain)
+		if err == nil {
+			auditLogIssued(chain, csrDER, paths)
+		}
+		return paths, err
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return issuedPaths{}, fmt.Errorf("Error creating output directory: %v", err)
+		}
+	}
+	paths := newIssuedPaths(layout, dir, prefix)
+
+	var intermediates, fullchain bytes.Buffer
+	for i, der := range chain {
+		block := &pem.Block{Type: "CERTIFICATE", Bytes: der}
+		pem.Encode(&fullchain, block)
+		if i > 0 {
+			pem.Encode(&intermediates, block)
+		}
+	}
+
+	files := []struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}{
+		{paths.CSR, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}), 0644},
+		{paths.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[0]}), 0644},
+		{paths.Chain, intermediates.Bytes(), 0644},
+		{paths.FullChain, fullchain.Bytes(), 0644},
+	}
+	if writeKey {
+		block, err := marshalACMEKey(key)
+		if err != nil {
+			return issuedPaths{}, err
+		}
+		keyPEM := pem.EncodeToMemory(block)
+		certforge.Zeroize(block.Bytes)
+		defer certforge.Zeroize(keyPEM)
+		files = append([]struct {
+			path string
+			data []byte
+			mode os.FileMode
+		}{{paths.Key, keyPEM, 0600}}, files...)
+	}
+	for _, file := range files {
+		if err := os.WriteFile(file.path, file.data, file.mode); err != nil {
+			return issuedPaths{}, fmt.Errorf("Failed to write %s: %v", file.path, err)
+		}
+	}
+	auditLogIssued(chain, csrDER, paths)
+	return paths, nil
+}
+
+// acmeChallengeOptions selects how challenges are answered
+type acmeChallengeOptions struct {
+	Type     string
+	HTTPPort int
+	Webroot  string
+	TLSPort  int
+}
+
+// solveACMEChallenge answers the selected challenge of authz and waits for the CA to validate it
+func solveACMEChallenge(ctx context.Context, client *acme.Client, authz *acme.Authorization, opts acmeChallengeOptions) error {
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == opts.Type {
+			chal = c
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("The CA offers no %s challenge for %s", opts.Type, authz.Identifier.Value)
+	}
+
+	var cleanup func()
+	var err error
+	switch opts.Type {
+	case "http-01":
+		cleanup, err = presentACMEHTTP01(client, chal.Token, opts.HTTPPort, opts.Webroot)
+	case "tls-alpn-01":
+		cleanup, err = presentACMETLSALPN01(client, chal.Token, authz.Identifier.Value, opts.TLSPort)
+	}
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("Failed to accept challenge for %s: %v", authz.Identifier.Value, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("Validation of %s failed: %v", authz.Identifier.Value, err)
+	}
+	return nil
+}
+
+// presentACMEHTTP01 serves the HTTP-01 response for token until the returned cleanup is called
+func presentACMEHTTP01(client *acme.Client, token string, port int, webroot string) (func(), error) {
+	response, err := client.HTTP01ChallengeResponse(token)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to compute challenge response: %v", err)
+	}
+	path := client.HTTP01ChallengePath(token)
+
+	if webroot != "" {
+		// The web server already running on port 80 serves the response from its document root
+		file := filepath.Join(webroot, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return nil, fmt.Errorf("Failed to create challenge directory: %v", err)
+		}
+		if err := os.WriteFile(file, []byte(response), 0644); err != nil {
+			return nil, fmt.Errorf("Failed to write challenge file: %v", err)
+		}
+		return func() { os.Remove(file) }, nil
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen for HTTP-01 challenges on port %d (use --webroot if a web server is running): %v", port, err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != path {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(response))
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go server.Serve(listener)
+	return func() { server.Close() }, nil
+}
+
+// presentACMETLSALPN01 answers TLS-ALPN-01 handshakes for domain until the returned cleanup is called
+func presentACMETLSALPN01(client *acme.Client, token, domain string, port int) (func(), error) {
+	cert, err := client.TLSALPN01ChallengeCert(token, domain)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create challenge certificate: %v", err)
+	}
+	// RFC 8737: the validation handshake negotiates acme-tls/1 and carries no application data
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{acme.ALPNProto},
+		MinVersion:   tls.VersionTLS12,
+	}
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", port), config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen for TLS-ALPN-01 challenges on port %d: %v", port, err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+	return func() { listener.Close() }, nil
+}
+
+// acmeAccountFlags are the flags choosing the CA and account key, shared by acme and its account subcommands
+type acmeAccountFlags struct {
+	staging    *bool
+	directory  *string
+	accountKey *string
+}
+
+// addACMEAccountFlags defines the CA and account key flags on fs
+func addACMEAccountFlags(fs *flag.FlagSet) *acmeAccountFlags {
+	return &acmeAccountFlags{
+		staging:    fs.Bool("staging", false, "Use Let's Encrypt's staging environment, for testing"),
+		directory:  fs.String("directory", "", "Directory URL of another ACME CA (default: Let's Encrypt)"),
+		accountKey: fs.String("account-key", "", "Account key file (default: in the user config directory)"),
+	}
+}
+
+// client returns an ACME client for the chosen CA and the path of its account key, creating the key if create is set
+func (f *acmeAccountFlags) client(create bool) (*acme.Client, string, error) {
+	if *f.staging && *f.directory != "" {
+		return nil, "", fmt.Errorf("--staging and --directory cannot be combined")
+	}
+	directory := acme.LetsEncryptURL
+	if *f.staging {
+		directory = acmeStagingURL
+	} else if *f.directory != "" {
+		directory = *f.directory
+	}
+
+	path := *f.accountKey
+	if path == "" {
+		var err error
+		if path, err = defaultACMEAccountKeyPath(directory); err != nil {
+			return nil, "", err
+		}
+	}
+	if _, err := os.Stat(path); err != nil && !create {
+		return nil, "", fmt.Errorf("No account key at %s (run acme account register first)", path)
+	}
+	key, created, err := loadACMEAccountKey(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if created {
+		fmt.Printf("Created account key: %s\n", path)
+	}
+	return &acme.Client{Key: key, DirectoryURL: directory, UserAgent: "certforge/" + version}, path, nil
+}
+
+// registerACMEAccount creates the account of the client's key, or finds the existing one, which is reported by the bool
+func registerACMEAccount(ctx context.Context, client *acme.Client, contacts []string, agreeTOS bool, eab *acme.ExternalAccountBinding) (*acme.Account, bool, error) {
+	var tosURL string
+	prompt := func(url string) bool {
+		tosURL = url
+		return agreeTOS
+	}
+	account, err := client.Register(ctx, &acme.Account{Contact: contacts, ExternalAccountBinding: eab}, prompt)
+	switch {
+	case err == nil:
+		fmt.Println("Registered a new ACME account")
+		entry := auditLogEntry{Operation: auditLogAccountRegistered, Actor: account.URI, Names: contacts, Issuer: client.DirectoryURL}
+		entry.KeyType, entry.KeyFingerprint = auditLogPublicKey(client.Key.Public())
+		auditLog(entry)
+		return account, false, nil
+	case errors.Is(err, acme.ErrAccountAlreadyExists):
+		if account, err = client.GetReg(ctx, ""); err != nil {
+			return nil, false, fmt.Errorf("Failed to fetch ACME account: %v", err)
+		}
+		return account, true, nil
+	case !agreeTOS && tosURL != "":
+		return nil, false, fmt.Errorf("Registering requires agreeing to the terms of service at %s (use --agree-tos)", tosURL)
+	}
+	return nil, false, fmt.Errorf("Failed to register ACME account: %v", err)
+}
+
+// acmeContacts turns a comma separated list of email addresses into mailto: contact URLs
+func acmeContacts(list string) []string {
+	var contacts []string
+	for _, email := range strings.Split(list, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			contacts = append(contacts, "mailto:"+strings.TrimPrefix(email, "mailto:"))
+		}
+	}
+	return contacts
+}
+
+// parseACMEEAB builds an External Account Binding from the key ID and HMAC key a CA issues, or returns nil when neither is given
+func parseACMEEAB(kid, hmacKey string) (*acme.ExternalAccountBinding, error) {
+	if kid == "" && hmacKey == "" {
+		return nil, nil
+	}
+	if kid == "" || hmacKey == "" {
+		return nil, fmt.Errorf("External Account Binding requires both --eab-kid and --eab-hmac-key")
+	}
+	// CAs show the key base64url encoded, some with padding
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(hmacKey, "="))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --eab-hmac-key: %v", err)
+	}
+	return &acme.ExternalAccountBinding{KID: kid, Key: key}, nil
+}
+
+// defaultACMEAccountKeyPath returns where the account key for a CA is kept, one account per directory host
+func defaultACMEAccountKeyPath(directory string) (string, error) {
+	u, err := url.Parse(directory)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("Invalid ACME directory URL %q", directory)
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("Cannot find the user config directory (use --account-key): %v", err)
+	}
+	return filepath.Join(config, "certforge", "acme", u.Host, "account.key"), nil
+}
+
+// loadACMEAccountKey reads the account key at path, creating an ECDSA P-256 key if there is none
+func loadACMEAccountKey(path string) (crypto.Signer, bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		key, err := readPrivateKey(path, "")
+		if err != nil {
+			return nil, false, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, false, fmt.Errorf("Unsupported account key in %s", path)
+		}
+		return signer, false, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to generate account key: %v", err)
+	}
+	if err := writeACMEAccountKey(path, key); err != nil {
+		return nil, false, err
+	}
+	auditLog(auditLogKey(key.Public(), path))
+	return key, true, nil
+}
+
+// writeACMEAccountKey saves an account key readable only by its owner, creating its directory
+func writeACMEAccountKey(path string, key crypto.Signer) error {
+	block, err := marshalACMEKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("Failed to create %s: %v", filepath.Dir(path), err)
+	}
+	keyPEM := pem.EncodeToMemory(block)
+	certforge.Zeroize(block.Bytes)
+	defer certforge.Zeroize(keyPEM)
+	if err := os.WriteFile(path, keyPEM, 0600); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// generateACMEKey generates the key of a new certificate
+func generateACMEKey(ctx context.Context, keyType string, size int) (crypto.Signer, error) {
+	switch strings.ToLower(keyType) {
+	case "rsa":
+		if size != 2048 && size != 3072 && size != 4096 {
+			return nil, fmt.Errorf("Invalid --key-size %d (use 2048, 3072, or 4096)", size)
+		}
+		fmt.Printf("Generating RSA private key (%d bits)...\n", size)
+	case "ecdsa":
+		fmt.Println("Generating ECDSA private key (P-256)...")
+		size = 256
+	case "tpm":
+		fmt.Println("Generating ECDSA private key (P-256) in the TPM...")
+		key, err := generateTPMKey(certforge.ECDSA)
+		if err != nil {
+			return nil, err
+		}
+		auditLog(auditLogKey(key.Public()))
+		return key, nil
+	default:
+		return nil, fmt.Errorf("Invalid --key-type %q (use rsa, ecdsa, or tpm)", keyType)
+	}
+	key, err := certforge.GenerateKeyContext(ctx, certforge.KeyType(strings.ToLower(keyType)), size)
+	if err != nil {
+		return nil, err
+	}
+	auditLog(auditLogKey(key.Public()))
+	return key, nil
+}
+
+// marshalACMEKey encodes an RSA or ECDSA key in its traditional PEM form, as generated keys are, and a TPM key as a
+// TPM key file
+func marshalACMEKey(key crypto.Signer) (*pem.Block, error) {
+	switch k := key.(type) {
+	case *tpmKey:
+		return k.pemBlock()
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode private key: %v", err)
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+	}
+	return nil, fmt.Errorf("Unsupported key type %T", key)
+}
@@ -0,0 +1,204 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// acmeAccountCommands maps the subcommands of acme account to their implementations
+var acmeAccountCommands = map[string]func(args []string) error{
+	"register":   runACMEAccountRegister,
+	"show":       runACMEAccountShow,
+	"rotate-key": runACMEAccountRotateKey,
+	"deactivate": runACMEAccountDeactivate,
+}
+
+// runACMEAccount implements acme account, which dispatches on the subcommand
+func runACMEAccount(args []string) error {
+	var names []string
+	for name := range acmeAccountCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return fmt.Errorf("acme account requires a subcommand: %s", strings.Join(names, ", "))
+	}
+	command, ok := acmeAccountCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown acme account subcommand %q (use %s)", args[0], strings.Join(names, ", "))
+	}
+	return command(args[1:])
+}
+
+// runACMEAccountRegister implements acme account register, which creates an account or updates its contacts
+func runACMEAccountRegister(args []string) error {
+	fs := flag.NewFlagSet("acme account register", flag.ExitOnError)
+	emailFlag := fs.String("email", "", "Comma separated contact addresses, replacing those of an existing account")
+	account := addACMEAccountFlags(fs)
+	agreeTOSFlag := fs.Bool("agree-tos", false, "Agree to the CA's terms of service")
+	eabKIDFlag := fs.String("eab-kid", "", "External Account Binding key ID, for CAs that require one")
+	eabHMACKeyFlag := fs.String("eab-hmac-key", "", "External Account Binding HMAC key, base64url encoded as the CA shows it")
+	parseArgs(fs, args)
+
+	eab, err := parseACMEEAB(*eabKIDFlag, *eabHMACKeyFlag)
+	if err != nil {
+		return err
+	}
+	client, _, err := account.client(true)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext(time.Minute)
+	defer cancel()
+
+	contacts := acmeContacts(*emailFlag)
+	acct, existing, err := registerACMEAccount(ctx, client, contacts, *agreeTOSFlag, eab)
+	if err != nil {
+		return err
+	}
+	if existing {
+		fmt.Println("The account key is already registered")
+		if contacts != nil && strings.Join(contacts, ",") != strings.Join(acct.Contact, ",") {
+			acct.Contact = contacts
+			uri := acct.URI
+			if acct, err = client.UpdateReg(ctx, acct); err != nil {
+				return fmt.Errorf("Failed to update contacts: %v", err)
+			}
+			// The update response has no Location header to fill in the account URL from
+			acct.URI = uri
+			fmt.Println("Updated the account contacts")
+		}
+	}
+	return printACMEAccount(client, acct)
+}
+
+// runACMEAccountShow implements acme account show
+func runACMEAccountShow(args []string) error {
+	fs := flag.NewFlagSet("acme account show", flag.ExitOnError)
+	account := addACMEAccountFlags(fs)
+	parseArgs(fs, args)
+
+	client, _, err := account.client(false)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext(time.Minute)
+	defer cancel()
+
+	acct, err := client.GetReg(ctx, "")
+	if err != nil {
+		return fmt.Errorf("Failed to fetch ACME account: %v", err)
+	}
+	return printACMEAccount(client, acct)
+}
+
+// runACMEAccountRotateKey implements acme account rotate-key, which replaces the account key
+func runACMEAccountRotateKey(args []string) error {
+	fs := flag.NewFlagSet("acme account rotate-key", flag.ExitOnError)
+	account := addACMEAccountFlags(fs)
+	parseArgs(fs, args)
+
+	client, path, err := account.client(false)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext(time.Minute)
+	defer cancel()
+
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("Failed to generate account key: %v", err)
+	}
+	// The new key is saved before the rollover, so an interrupted run never loses the key the CA knows
+	pending := path + ".new"
+	if err := writeACMEAccountKey(pending, newKey); err != nil {
+		return err
+	}
+	auditLog(auditLogKey(newKey.Public(), pending))
+	if err := client.AccountKeyRollover(ctx, newKey); err != nil {
+		os.Remove(pending)
+		return fmt.Errorf("Failed to roll over account key: %v", err)
+	}
+	if err := os.Rename(pending, path); err != nil {
+		return fmt.Errorf("The CA now uses the key in %s, but it could not replace %s: %v", pending, path, err)
+	}
+	auditLog(auditLogACMEAccount(auditLogAccountKeyChanged, client, path))
+
+	thumbprint, _ := acme.JWKThumbprint(newKey.Public())
+	fmt.Printf("Rotated the account key in %s\n", path)
+	fmt.Printf("New key thumbprint: %s\n", thumbprint)
+	return nil
+}
+
+// runACMEAccountDeactivate implements acme account deactivate, which permanently disables the account
+func runACMEAccountDeactivate(args []string) error {
+	fs := flag.NewFlagSet("acme account deactivate", flag.ExitOnError)
+	account := addACMEAccountFlags(fs)
+	yesFlag := fs.Bool("yes", false, "Confirm deactivation, which cannot be undone")
+	parseArgs(fs, args)
+
+	if !*yesFlag {
+		return fmt.Errorf("Deactivating an account cannot be undone; confirm with --yes")
+	}
+	client, path, err := account.client(false)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext(time.Minute)
+	defer cancel()
+
+	if err := client.DeactivateReg(ctx); err != nil {
+		return fmt.Errorf("Failed to deactivate ACME account: %v", err)
+	}
+	auditLog(auditLogACMEAccount(auditLogAccountDeactivated, client, path))
+	fmt.Printf("Deactivated the account of %s\n", path)
+	fmt.Println("Certificates already issued stay valid; the key cannot be used for a new account.")
+	return nil
+}
+
+// auditLogACMEAccount describes a change to the account of client, whose key is kept in path, for an audit entry
+func auditLogACMEAccount(operation string, client *acme.Client, path string) auditLogEntry {
+	entry := auditLogEntry{Operation: operation, Actor: string(client.KID), Issuer: client.DirectoryURL, Outputs: []string{path}}
+	entry.KeyType, entry.KeyFingerprint = auditLogPublicKey(client.Key.Public())
+	return entry
+}
+
+// printACMEAccount prints the details of an account
+func printACMEAccount(client *acme.Client, acct *acme.Account) error {
+	thumbprint, err := acme.JWKThumbprint(client.Key.Public())
+	if err != nil {
+		return fmt.Errorf("Failed to compute key thumbprint: %v", err)
+	}
+
+	fmt.Println("\n=== ACME Account ===")
+	fmt.Printf("CA:             %s\n", client.DirectoryURL)
+	fmt.Printf("URL:            %s\n", acct.URI)
+	fmt.Printf("Status:         %s\n", acct.Status)
+	if len(acct.Contact) > 0 {
+		fmt.Printf("Contacts:       %s\n", strings.Join(acct.Contact, ", "))
+	} else {
+		fmt.Println("Contacts:       none")
+	}
+	if acct.OrdersURL != "" {
+		fmt.Printf("Orders:         %s\n", acct.OrdersURL)
+	}
+	fmt.Printf("Key:            %s\n", certforge.PrivateKeyDescription(client.Key))
+	fmt.Printf("Key Thumbprint: %s\n", thumbprint)
+	return nil
+}
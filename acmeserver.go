@@ -0,0 +1,1222 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// oidACMEIdentifier is the extension of TLS-ALPN-01 validation certificates (RFC 8737)
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// acmeDNSName matches a DNS identifier, optionally with a wildcard as its first label
+var acmeDNSName = regexp.MustCompile(`^(\*\.)?([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// acmeServer is an in-memory ACME CA (RFC 8555) issuing certificates from a local CA, for testing ACME clients
+type acmeServer struct {
+	mu         sync.Mutex
+	nonceMu    sync.Mutex
+	ca         *certforge.CA
+	days       int
+	options    acmeServerOptions
+	nonces     map[string]time.Time // issue times, guarded by nonceMu, so responses can be written while mu is held
+	nonceRing  []string             // the last acmeMaxNonces nonces issued, oldest at nonceNext
+	nonceNext  int
+	accounts   map[string]*acmeServerAccount
+	orders     map[string]*acmeServerOrder
+	authzs     map[string]*acmeServerAuthz
+	challenges map[string]*acmeServerChallenge
+	certs      map[string]*acmeServerCert
+}
+
+// acmeServerOptions control how challenges are validated
+type acmeServerOptions struct {
+	HTTPPort       int
+	TLSPort        int
+	Resolver       *net.Resolver
+	SkipValidation bool
+}
+
+// acmeServerAccount is a registered account, identified by its key
+type acmeServerAccount struct {
+	ID         string
+	Key        crypto.PublicKey
+	Thumbprint string
+	Status     string
+	Contact    []string
+	Orders     []string
+}
+
+// acmeServerOrder is a request for a certificate covering its identifiers
+type acmeServerOrder struct {
+	ID          string
+	Account     string
+	Identifiers []acme.AuthzID
+	Authzs      []string
+	Expires     time.Time
+	Processing  bool
+	Cert        string
+	Error       *acmeProblem
+}
+
+// acmeServerAuthz is the authorization of an account for one identifier
+type acmeServerAuthz struct {
+	ID         string
+	Identifier acme.AuthzID
+	Wildcard   bool
+	Expires    time.Time
+	Challenges []string
+}
+
+// acmeServerChallenge is one way of proving control of an authorization's identifier
+type acmeServerChallenge struct {
+	ID        string
+	Authz     string
+	Type      string
+	Token     string
+	Status    string
+	Validated time.Time
+	Error     *acmeProblem
+}
+
+// acmeServerCert is an issued certificate and its chain
+type acmeServerCert struct {
+	ID      string
+	Account string
+	Chain   [][]byte
+	Revoked bool
+}
+
+// acmeProblem is an ACME error document (RFC 7807)
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status,omitempty"`
+}
+
+// acmeJWS is a request body in the flattened JWS JSON serialization
+type acmeJWS struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// acmeJWSHeader is the protected header of a request
+type acmeJWSHeader struct {
+	Alg   string      `json:"alg"`
+	Nonce string      `json:"nonce"`
+	URL   string      `json:"url"`
+	Kid   string      `json:"kid"`
+	JWK   *jsonWebKey `json:"jwk"`
+}
+
+// acmeRequest is a verified request: its payload, and the account or key that signed it
+type acmeRequest struct {
+	Payload []byte
+	Account *acmeServerAccount
+	Key     crypto.PublicKey
+	Header  acmeJWSHeader
+}
+
+// runACMEServer implements the acme-server command, which serves a minimal ACME CA backed by a local CA
+func runACMEServer(args []string) error {
+	fs := flag.NewFlagSet("acme-server", flag.ExitOnError)
+	rootFlag := fs.String("root", "", "CA certificate that signs issued certificates, followed by any chain")
+	rootKeyFlag := fs.String("root-key", "", "Private key of the CA, or a comma separated list of its shares (default: --root with a .key extension)")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted CA key (pass:, env:, file:, or stdin)")
+	listenFlag := fs.String("listen", ":14000", "Address to serve the ACME API on")
+	hostnameFlag := fs.String("hostname", "localhost,127.0.0.1,::1", "Comma separated names of the server's own TLS certificate")
+	plainFlag := fs.Bool("http", false, "Serve plain HTTP instead of HTTPS, for clients that allow it")
+	daysFlag := fs.Int("days", 90, "Validity of issued certificates in days")
+	httpPortFlag := fs.Int("http-port", 80, "Port HTTP-01 challenges are validated on")
+	tlsPortFlag := fs.Int("tls-port", 443, "Port TLS-ALPN-01 challenges are validated on")
+	dnsServerFlag := fs.String("dns-server", "", "DNS server (host:port) to look DNS-01 records up with (default: the system resolver)")
+	skipFlag := fs.Bool("skip-validation", false, "Mark every challenge valid without checking it")
+	insecureFlag := fs.Bool("insecure-allow", false, "Sign CSRs for RSA keys under 2048 bits and ECDSA keys under 256 bits, which are refused by default")
+	parseArgs(fs, args)
+
+	if *rootFlag == "" {
+		return fmt.Errorf("acme-server requires --root")
+	}
+	rootKey := *rootKeyFlag
+	if rootKey == "" {
+		rootKey = strings.TrimSuffix(*rootFlag, ".crt") + ".key"
+	}
+	if *daysFlag < 1 {
+		return fmt.Errorf("Invalid --days %d", *daysFlag)
+	}
+	ca, err := loadLocalCA(&managedCA{Cert: *rootFlag, Key: rootKey, Passin: *passinFlag})
+	if err != nil {
+		return err
+	}
+	ca.AllowInsecure = *insecureFlag
+
+	server := &acmeServer{
+		ca:         ca,
+		days:       *daysFlag,
+		options:    acmeServerOptions{HTTPPort: *httpPortFlag, TLSPort: *tlsPortFlag, SkipValidation: *skipFlag, Resolver: net.DefaultResolver},
+		nonces:     map[string]time.Time{},
+		accounts:   map[string]*acmeServerAccount{},
+		orders:     map[string]*acmeServerOrder{},
+		authzs:     map[string]*acmeServerAuthz{},
+		challenges: map[string]*acmeServerChallenge{},
+		certs:      map[string]*acmeServerCert{},
+	}
+	if *dnsServerFlag != "" {
+		if _, _, err := net.SplitHostPort(*dnsServerFlag); err != nil {
+			return fmt.Errorf("Invalid --dns-server: %v", err)
+		}
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		server.options.Resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, *dnsServerFlag)
+		}}
+	}
+
+	var hostnames []string
+	for _, name := range strings.Split(*hostnameFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" && !contains(hostnames, name) {
+			hostnames = append(hostnames, name)
+		}
+	}
+	if len(hostnames) == 0 {
+		return fmt.Errorf("acme-server requires a --hostname")
+	}
+	listener, err := net.Listen("tcp", *listenFlag)
+	if err != nil {
+		return fmt.Errorf("Failed to listen on %s: %v", *listenFlag, err)
+	}
+
+	scheme := "http"
+	if !*plainFlag {
+		// The API is served with a certificate from the same CA, so clients trusting it for issued certificates trust the API too
+		tlsKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return fmt.Errorf("Error generating private key: %v", err)
+		}
+		auditLog(auditLogKey(tlsKey.Public()))
+		chain, err := ca.IssueServer(tlsKey.Public(), hostnames, time.Duration(*daysFlag)*24*time.Hour)
+		if err != nil {
+			return err
+		}
+		if leaf, err := x509.ParseCertificate(chain[0]); err == nil {
+			entry := auditLogCertificate(auditLogCertSigned, leaf)
+			entry.Inputs = auditLogInputs(*rootFlag)
+			auditLog(entry)
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{{Certificate: chain, PrivateKey: tlsKey}}})
+		scheme = "https"
+	}
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	fmt.Println("=== ACME Server ===")
+	fmt.Printf("Directory: %s://%s/dir\n", scheme, net.JoinHostPort(hostnames[0], port))
+	fmt.Printf("Issuing CA: %s\n", certforge.FormatName(ca.Certificates[0].Subject))
+	fmt.Printf("Certificates valid for: %d days\n", *daysFlag)
+	if *skipFlag {
+		fmt.Println("Challenges: all accepted without validation")
+	} else {
+		fmt.Printf("Challenges: http-01 on port %d, tls-alpn-01 on port %d, dns-01\n", *httpPortFlag, *tlsPortFlag)
+	}
+	fmt.Println("State is kept in memory and lost when the server stops")
+	fmt.Println()
+	return http.Serve(listener, server.handler())
+}
+
+// handler routes the ACME API
+func (s *acmeServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dir", s.handleDirectory)
+	mux.HandleFunc("GET /root", s.handleRoot)
+	mux.HandleFunc("HEAD /nonce", s.handleNonce)
+	mux.HandleFunc("GET /nonce", s.handleNonce)
+	mux.HandleFunc("POST /new-account", s.handleNewAccount)
+	mux.HandleFunc("POST /account/{id}", s.handleAccount)
+	mux.HandleFunc("POST /account/{id}/orders", s.handleAccountOrders)
+	mux.HandleFunc("POST /new-order", s.handleNewOrder)
+	mux.HandleFunc("POST /order/{id}", s.handleOrder)
+	mux.HandleFunc("POST /authz/{id}", s.handleAuthz)
+	mux.HandleFunc("POST /chall/{id}", s.handleChallenge)
+	mux.HandleFunc("POST /finalize/{id}", s.handleFinalize)
+	mux.HandleFunc("POST /cert/{id}", s.handleCert)
+	mux.HandleFunc("POST /revoke-cert", s.handleRevoke)
+	mux.HandleFunc("POST /key-change", s.handleKeyChange)
+	return mux
+}
+
+// baseURL returns the scheme and host the client reached the server at, which all URLs are built from
+func baseURL(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// handleDirectory serves the directory listing the other endpoints
+func (s *acmeServer) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"newNonce":   base + "/nonce",
+		"newAccount": base + "/new-account",
+		"newOrder":   base + "/new-order",
+		"revokeCert": base + "/revoke-cert",
+		"keyChange":  base + "/key-change",
+		"meta": map[string]interface{}{
+			"website":                 base + "/root",
+			"externalAccountRequired": false,
+		},
+	}, "")
+}
+
+// handleRoot serves the root certificate clients must trust
+func (s *acmeServer) handleRoot(w http.ResponseWriter, r *http.Request) {
+	root := s.ca.Certificates[len(s.ca.Certificates)-1]
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+}
+
+// handleNonce returns a fresh nonce in the Replay-Nonce header
+func (s *acmeServer) handleNonce(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleNewAccount registers an account, or finds the account of the signing key
+func (s *acmeServer) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, false)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	var payload struct {
+		Contact            []string `json:"contact"`
+		TermsAgreed        bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		s.writeProblem(w, r, acmeError("malformed", "Invalid new-account payload: %v", err))
+		return
+	}
+	thumbprint, _ := jwkThumbprint(*req.Header.JWK)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, acct := range s.accounts {
+		if acct.Thumbprint == thumbprint {
+			s.writeJSON(w, r, http.StatusOK, s.accountJSON(r, acct), baseURL(r)+"/account/"+acct.ID)
+			return
+		}
+	}
+	if payload.OnlyReturnExisting {
+		s.writeProblem(w, r, acmeError("accountDoesNotExist", "No account exists for this key"))
+		return
+	}
+	if prob := checkACMEContacts(payload.Contact); prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	acct := &acmeServerAccount{ID: acmeServerID(), Key: req.Key, Thumbprint: thumbprint, Status: "valid", Contact: payload.Contact}
+	s.accounts[acct.ID] = acct
+	if len(acct.Contact) > 0 {
+		daemonLogf("Account %s registered for %s", acct.ID, strings.Join(acct.Contact, ", "))
+	} else {
+		daemonLogf("Account %s registered", acct.ID)
+	}
+	s.writeJSON(w, r, http.StatusCreated, s.accountJSON(r, acct), baseURL(r)+"/account/"+acct.ID)
+}
+
+// handleAccount returns, updates the contacts of, or deactivates an account
+func (s *acmeServer) handleAccount(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, true)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	if req.Account.ID != r.PathValue("id") {
+		s.writeProblem(w, r, acmeError("unauthorized", "Accounts can only be managed by their own key"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(req.Payload) > 0 {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if err := json.Unmarshal(req.Payload, &payload); err != nil {
+			s.writeProblem(w, r, acmeError("malformed", "Invalid account payload: %v", err))
+			return
+		}
+		switch payload.Status {
+		case "":
+		case "deactivated":
+			req.Account.Status = "deactivated"
+			daemonLogf("Account %s deactivated", req.Account.ID)
+		default:
+			s.writeProblem(w, r, acmeError("malformed", "Accounts can only be set to deactivated"))
+			return
+		}
+		if payload.Contact != nil {
+			if prob := checkACMEContacts(payload.Contact); prob != nil {
+				s.writeProblem(w, r, prob)
+				return
+			}
+			req.Account.Contact = payload.Contact
+		}
+	}
+	s.writeJSON(w, r, http.StatusOK, s.accountJSON(r, req.Account), baseURL(r)+"/account/"+req.Account.ID)
+}
+
+// handleAccountOrders lists the orders of an account
+func (s *acmeServer) handleAccountOrders(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, true)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	if req.Account.ID != r.PathValue("id") {
+		s.writeProblem(w, r, acmeError("unauthorized", "Orders can only be listed by their account"))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	orders := []string{}
+	for _, id := range req.Account.Orders {
+		orders = append(orders, baseURL(r)+"/order/"+id)
+	}
+	s.writeJSON(w, r, http.StatusOK, map[string][]string{"orders": orders}, "")
+}
+
+// handleNewOrder creates an order, with a pending authorization for each identifier
+func (s *acmeServer) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, true)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	var payload struct {
+		Identifiers []acme.AuthzID `json:"identifiers"`
+		NotBefore   string         `json:"notBefore"`
+		NotAfter    string         `json:"notAfter"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		s.writeProblem(w, r, acmeError("malformed", "Invalid new-order payload: %v", err))
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		s.writeProblem(w, r, acmeError("malformed", "An order needs at least one identifier"))
+		return
+	}
+	if payload.NotBefore != "" || payload.NotAfter != "" {
+		s.writeProblem(w, r, acmeError("malformed", "This server does not support notBefore and notAfter"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order := &acmeServerOrder{ID: acmeServerID(), Account: req.Account.ID, Expires: time.Now().Add(24 * time.Hour)}
+	var seen []string
+	for _, id := range payload.Identifiers {
+		id.Value = strings.ToLower(strings.TrimSuffix(id.Value, "."))
+		if prob := checkACMEIdentifier(id); prob != nil {
+			s.writeProblem(w, r, prob)
+			return
+		}
+		if contains(seen, id.Type+":"+id.Value) {
+			continue
+		}
+		seen = append(seen, id.Type+":"+id.Value)
+		order.Identifiers = append(order.Identifiers, id)
+		order.Authzs = append(order.Authzs, s.newAuthz(id, order.Expires))
+	}
+	s.orders[order.ID] = order
+	req.Account.Orders = append(req.Account.Orders, order.ID)
+	daemonLogf("Order %s for %s", order.ID, describeACMEIdentifiers(order.Identifiers))
+	s.writeJSON(w, r, http.StatusCreated, s.orderJSON(r, order), baseURL(r)+"/order/"+order.ID)
+}
+
+// newAuthz creates the authorization of id with its challenges; s.mu must be held
+func (s *acmeServer) newAuthz(id acme.AuthzID, expires time.Time) string {
+	authz := &acmeServerAuthz{ID: acmeServerID(), Identifier: id, Expires: expires}
+	types := []string{"http-01", "tls-alpn-01", "dns-01"}
+	switch {
+	case strings.HasPrefix(id.Value, "*."):
+		// Wildcards can only be proven in DNS; the authorization is for the base domain
+		authz.Identifier.Value = strings.TrimPrefix(id.Value, "*.")
+		authz.Wildcard = true
+		types = []string{"dns-01"}
+	case id.Type == "ip":
+		types = []string{"http-01"}
+	}
+	for _, typ := range types {
+		token := make([]byte, 32)
+		rand.Read(token)
+		chal := &acmeServerChallenge{ID: acmeServerID(), Authz: authz.ID, Type: typ, Token: base64.RawURLEncoding.EncodeToString(token), Status: "pending"}
+		s.challenges[chal.ID] = chal
+		authz.Challenges = append(authz.Challenges, chal.ID)
+	}
+	s.authzs[authz.ID] = authz
+	return authz.ID
+}
+
+// handleOrder returns an order of the signing account
+func (s *acmeServer) handleOrder(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, true)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[r.PathValue("id")]
+	if !ok || order.Account != req.Account.ID {
+		s.writeProblem(w, r, acmeError("malformed", "No such order"))
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, s.orderJSON(r, order), "")
+}
+
+// handleAuthz returns an authorization
+func (s *acmeServer) handleAuthz(w http.ResponseWriter, r *http.Request) {
+	if _, prob := s.verify(r, true); prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	authz, ok := s.authzs[r.PathValue("id")]
+	if !ok {
+		s.writeProblem(w, r, acmeError("malformed", "No such authorization"))
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, s.authzJSON(r, authz), "")
+}
+
+// handleChallenge returns a challenge, or starts validating it when the client posts {}
+func (s *acmeServer) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, true)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chal, ok := s.challenges[r.PathValue("id")]
+	if !ok {
+		s.writeProblem(w, r, acmeError("malformed", "No such challenge"))
+		return
+	}
+	authz := s.authzs[chal.Authz]
+
+	// Only one challenge of an authorization is validated
+	if len(req.Payload) > 0 && chal.Status == "pending" && s.authzStatus(authz) == "pending" {
+		chal.Status = "processing"
+		keyAuth := chal.Token + "." + req.Account.Thumbprint
+		go s.validate(chal, authz, keyAuth)
+	}
+	w.Header().Add("Link", fmt.Sprintf(`<%s/authz/%s>;rel="up"`, baseURL(r), authz.ID))
+	s.writeJSON(w, r, http.StatusOK, s.challengeJSON(r, chal), "")
+}
+
+// validate checks a challenge and records the result
+func (s *acmeServer) validate(chal *acmeServerChallenge, authz *acmeServerAuthz, keyAuth string) {
+	var err error
+	if !s.options.SkipValidation {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		switch chal.Type {
+		case "http-01":
+			err = s.validateHTTP01(ctx, authz.Identifier.Value, chal.Token, keyAuth)
+		case "tls-alpn-01":
+			err = s.validateTLSALPN01(ctx, authz.Identifier.Value, keyAuth)
+		case "dns-01":
+			err = s.validateDNS01(ctx, authz.Identifier.Value, keyAuth)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		chal.Status = "invalid"
+		chal.Error = acmeError("unauthorized", "%v", err)
+		chal.Error.Status = http.StatusForbidden
+		daemonLogf("Challenge %s for %s FAILED: %v", chal.Type, authz.Identifier.Value, err)
+		return
+	}
+	chal.Status = "valid"
+	chal.Validated = time.Now()
+	daemonLogf("Challenge %s for %s valid", chal.Type, authz.Identifier.Value)
+}
+
+// validateHTTP01 fetches the key authorization from the well-known path on the HTTP-01 port
+func (s *acmeServer) validateHTTP01(ctx context.Context, name, token, keyAuth string) error {
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", net.JoinHostPort(name, strconv.Itoa(s.options.HTTPPort)), token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Fetching %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Fetching %s: %s", url, resp.Status)
+	}
+	if strings.TrimSpace(string(body)) != keyAuth {
+		return fmt.Errorf("%s returned %q, expected %q", url, strings.TrimSpace(string(body)), keyAuth)
+	}
+	return nil
+}
+
+// validateTLSALPN01 connects with the acme-tls/1 protocol and checks the validation certificate
+func (s *acmeServer) validateTLSALPN01(ctx context.Context, name, keyAuth string) error {
+	addr := net.JoinHostPort(name, strconv.Itoa(s.options.TLSPort))
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: name, NextProtos: []string{acme.ALPNProto}, InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Connecting to %s: %v", addr, err)
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	if state.NegotiatedProtocol != acme.ALPNProto {
+		return fmt.Errorf("%s did not negotiate %s", addr, acme.ALPNProto)
+	}
+	cert := state.PeerCertificates[0]
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != name || len(cert.IPAddresses) > 0 {
+		return fmt.Errorf("%s presented a certificate for %s, expected only %s", addr, strings.Join(cert.DNSNames, ", "), name)
+	}
+	want := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidACMEIdentifier) {
+			continue
+		}
+		var got []byte
+		if _, err := asn1.Unmarshal(ext.Value, &got); err != nil || !ext.Critical || !bytes.Equal(got, want[:]) {
+			return fmt.Errorf("%s presented a wrong acmeIdentifier extension", addr)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s presented a certificate without the acmeIdentifier extension", addr)
+}
+
+// validateDNS01 looks for the digest of the key authorization in the _acme-challenge TXT records
+func (s *acmeServer) validateDNS01(ctx context.Context, name, keyAuth string) error {
+	sum := sha256.Sum256([]byte(keyAuth))
+	want := base64.RawURLEncoding.EncodeToString(sum[:])
+	records, err := s.options.Resolver.LookupTXT(ctx, "_acme-challenge."+name)
+	if err != nil {
+		return fmt.Errorf("Looking up _acme-challenge.%s: %v", name, err)
+	}
+	for _, record := range records {
+		if record == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("No TXT record _acme-challenge.%s holds %q", name, want)
+}
+
+// handleFinalize issues the certificate of a ready order for the submitted CSR
+func (s *acmeServer) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, true)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		s.writeProblem(w, r, acmeError("malformed", "Invalid finalize payload: %v", err))
+		return
+	}
+	order, csr, names, prob := s.startFinalize(r.PathValue("id"), req.Account, payload.CSR)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+
+	// Signing, with any CT log submission, runs without s.mu, so a slow log does not stall every other request
+	chain, err := s.ca.IssueServer(csr.PublicKey, names, time.Duration(s.days)*24*time.Hour, csrMustStaple(csr)...)
+	if err == nil {
+		if leaf, err := x509.ParseCertificate(chain[0]); err == nil {
+			entry := auditLogCertificate(auditLogCertSigned, leaf)
+			entry.Actor, entry.Inputs = "account "+req.Account.ID, []auditLogInput{auditLogData("CSR", csr.Raw)}
+			auditLog(entry)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order.Processing = false
+	if err != nil {
+		order.Error = acmeError("serverInternal", "%v", err)
+		s.writeProblem(w, r, order.Error)
+		return
+	}
+	cert := &acmeServerCert{ID: acmeServerID(), Account: req.Account.ID, Chain: chain}
+	s.certs[cert.ID] = cert
+	order.Cert = cert.ID
+	daemonLogf("Order %s issued for %s", order.ID, strings.Join(names, ", "))
+	s.writeJSON(w, r, http.StatusOK, s.orderJSON(r, order), baseURL(r)+"/order/"+order.ID)
+}
+
+// startFinalize checks that an order of the account is ready and that the base64url CSR asks for exactly its names,
+// and marks the order processing until it is issued. It returns the names to issue, the common name first.
+func (s *acmeServer) startFinalize(id string, account *acmeServerAccount, encodedCSR string) (*acmeServerOrder, *x509.CertificateRequest, []string, *acmeProblem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[id]
+	if !ok || order.Account != account.ID {
+		return nil, nil, nil, acmeError("malformed", "No such order")
+	}
+	if status := s.orderStatus(order); status != "ready" {
+		return nil, nil, nil, &acmeProblem{Type: "urn:ietf:params:acme:error:orderNotReady", Detail: "The order is " + status, Status: http.StatusForbidden}
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(encodedCSR)
+	if err != nil {
+		return nil, nil, nil, acmeError("badCSR", "CSR is not base64url encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		return nil, nil, nil, acmeError("badCSR", "Invalid CSR: %v", err)
+	}
+	if certforge.SamePublicKey(csr.PublicKey, account.Key) {
+		return nil, nil, nil, acmeError("badCSR", "The certificate key must differ from the account key")
+	}
+
+	// The CSR must ask for exactly the names of the order, with the common name among them
+	var requested, ordered []string
+	for _, name := range csr.DNSNames {
+		requested = append(requested, strings.ToLower(name))
+	}
+	for _, ip := range csr.IPAddresses {
+		requested = append(requested, ip.String())
+	}
+	for _, id := range order.Identifiers {
+		ordered = append(ordered, id.Value)
+	}
+	names := append([]string{}, requested...)
+	sort.Strings(requested)
+	sort.Strings(ordered)
+	if strings.Join(requested, ",") != strings.Join(ordered, ",") {
+		return nil, nil, nil, acmeError("badCSR", "CSR names %s do not match the order's %s", strings.Join(requested, ", "), strings.Join(ordered, ", "))
+	}
+	if cn := strings.ToLower(csr.Subject.CommonName); cn != "" {
+		if !contains(names, cn) {
+			return nil, nil, nil, acmeError("badCSR", "CSR common name %s is not one of the order's names", cn)
+		}
+		// The common name is issued first, so it is kept as the subject
+		names = append([]string{cn}, names...)
+		for i := 1; i < len(names); i++ {
+			if names[i] == cn {
+				names = append(names[:i], names[i+1:]...)
+				break
+			}
+		}
+	}
+	order.Processing = true
+	return order, csr, names, nil
+}
+
+// handleCert returns an issued certificate followed by its chain
+func (s *acmeServer) handleCert(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, true)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	s.mu.Lock()
+	cert, ok := s.certs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok || cert.Account != req.Account.ID {
+		s.writeProblem(w, r, acmeError("malformed", "No such certificate"))
+		return
+	}
+	var buf bytes.Buffer
+	for _, der := range cert.Chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Write(buf.Bytes())
+}
+
+// handleRevoke revokes a certificate, when signed by its account or by its own key
+func (s *acmeServer) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, false)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	var payload struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		s.writeProblem(w, r, acmeError("malformed", "Invalid revocation payload: %v", err))
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	if err != nil {
+		s.writeProblem(w, r, acmeError("malformed", "Certificate is not base64url encoded"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cert := range s.certs {
+		if !bytes.Equal(cert.Chain[0], der) {
+			continue
+		}
+		leaf, _ := x509.ParseCertificate(der)
+		if (req.Account == nil || req.Account.ID != cert.Account) && !certforge.SamePublicKey(leaf.PublicKey, req.Key) {
+			s.writeProblem(w, r, acmeError("unauthorized", "Only the certificate's account or key can revoke it"))
+			return
+		}
+		if cert.Revoked {
+			s.writeProblem(w, r, acmeError("alreadyRevoked", "The certificate is already revoked"))
+			return
+		}
+		cert.Revoked = true
+		entry := auditLogCertificate(auditLogCertRevoked, leaf)
+		entry.Reason = crlReasonName(payload.Reason)
+		if req.Account != nil {
+			entry.Actor = "account " + req.Account.ID
+		}
+		auditLog(entry)
+		daemonLogf("Certificate %s for %s revoked (reason %d)", leaf.SerialNumber.Text(16), strings.Join(leaf.DNSNames, ", "), payload.Reason)
+		w.Header().Set("Replay-Nonce", s.newNonce())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	s.writeProblem(w, r, acmeError("malformed", "The certificate was not issued by this server"))
+}
+
+// handleKeyChange replaces an account's key; the inner JWS proves possession of the new key
+func (s *acmeServer) handleKeyChange(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verify(r, true)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	var inner acmeJWS
+	if err := json.Unmarshal(req.Payload, &inner); err != nil {
+		s.writeProblem(w, r, acmeError("malformed", "Invalid key-change payload: %v", err))
+		return
+	}
+	header, payloadJSON, newKey, prob := verifyACMEJWS(inner)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	var payload struct {
+		Account string     `json:"account"`
+		OldKey  jsonWebKey `json:"oldKey"`
+	}
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		s.writeProblem(w, r, acmeError("malformed", "Invalid key-change payload: %v", err))
+		return
+	}
+	oldKey, _, err := jwkKeys(payload.OldKey)
+	if header.JWK == nil || header.URL != req.Header.URL || payload.Account != req.Header.Kid || err != nil || !certforge.SamePublicKey(oldKey, req.Account.Key) {
+		s.writeProblem(w, r, acmeError("malformed", "The key-change request does not match the account"))
+		return
+	}
+	thumbprint, _ := jwkThumbprint(*header.JWK)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, acct := range s.accounts {
+		if acct.Thumbprint == thumbprint {
+			w.Header().Set("Location", baseURL(r)+"/account/"+acct.ID)
+			s.writeProblem(w, r, &acmeProblem{Type: "urn:ietf:params:acme:error:malformed", Detail: "The new key is already in use", Status: http.StatusConflict})
+			return
+		}
+	}
+	req.Account.Key, req.Account.Thumbprint = newKey, thumbprint
+	daemonLogf("Account %s changed its key", req.Account.ID)
+	s.writeJSON(w, r, http.StatusOK, s.accountJSON(r, req.Account), baseURL(r)+"/account/"+req.Account.ID)
+}
+
+// verify checks a request's JWS, nonce, and URL; requests signed by an account (kid) are required when byAccount
+// is set, and otherwise allowed alongside those carrying their key (jwk)
+func (s *acmeServer) verify(r *http.Request, byAccount bool) (*acmeRequest, *acmeProblem) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, acmeError("malformed", "Failed to read request: %v", err)
+	}
+	var jws acmeJWS
+	if err := json.Unmarshal(body, &jws); err != nil {
+		return nil, acmeError("malformed", "Request is not a flattened JWS: %v", err)
+	}
+	header, payload, key, prob := verifyACMEJWS(jws)
+	if prob != nil {
+		return nil, prob
+	}
+	if header.JWK == nil && header.Kid == "" {
+		return nil, acmeError("malformed", "The JWS header needs jwk or kid")
+	}
+	if header.JWK != nil && header.Kid != "" {
+		return nil, acmeError("malformed", "The JWS header must not have both jwk and kid")
+	}
+	if header.URL != baseURL(r)+r.URL.Path {
+		return nil, acmeError("unauthorized", "The JWS url %q does not match the request", header.URL)
+	}
+
+	s.nonceMu.Lock()
+	issued, known := s.nonces[header.Nonce]
+	delete(s.nonces, header.Nonce)
+	s.nonceMu.Unlock()
+	if !known || time.Since(issued) > acmeNonceLifetime {
+		return nil, acmeError("badNonce", "Unknown or reused nonce")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req := &acmeRequest{Payload: payload, Header: header, Key: key}
+	if header.Kid == "" {
+		if byAccount {
+			return nil, acmeError("malformed", "This request must be signed by an account (kid)")
+		}
+		return req, nil
+	}
+
+	acct, ok := s.accounts[strings.TrimPrefix(header.Kid, baseURL(r)+"/account/")]
+	if !ok || header.Kid != baseURL(r)+"/account/"+acct.ID {
+		return nil, acmeError("accountDoesNotExist", "No account %s", header.Kid)
+	}
+	if key, prob = acct.Key, verifyACMESignature(jws, header.Alg, acct.Key); prob != nil {
+		return nil, prob
+	}
+	if acct.Status != "valid" {
+		return nil, acmeError("unauthorized", "The account is %s", acct.Status)
+	}
+	req.Account, req.Key = acct, key
+	return req, nil
+}
+
+// verifyACMEJWS decodes a JWS, checking its signature when it carries its key (jwk)
+func verifyACMEJWS(jws acmeJWS) (acmeJWSHeader, []byte, crypto.PublicKey, *acmeProblem) {
+	var header acmeJWSHeader
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err == nil {
+		err = json.Unmarshal(protected, &header)
+	}
+	if err != nil {
+		return header, nil, nil, acmeError("malformed", "Invalid JWS protected header")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return header, nil, nil, acmeError("malformed", "Invalid JWS payload")
+	}
+	if header.JWK == nil {
+		return header, payload, nil, nil
+	}
+	key, _, err := jwkKeys(*header.JWK)
+	if err != nil {
+		return header, nil, nil, acmeError("badPublicKey", "%v", err)
+	}
+	if prob := verifyACMESignature(jws, header.Alg, key); prob != nil {
+		return header, nil, nil, prob
+	}
+	return header, payload, key, nil
+}
+
+// verifyACMESignature checks a JWS signature made with key using alg
+func verifyACMESignature(jws acmeJWS, alg string, key crypto.PublicKey) *acmeProblem {
+	signature, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil {
+		return acmeError("malformed", "Invalid JWS signature encoding")
+	}
+	input := []byte(jws.Protected + "." + jws.Payload)
+
+	hashes := map[string]crypto.Hash{"RS256": crypto.SHA256, "ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512}
+	valid := false
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg == "RS256" {
+			digest := sha256.Sum256(input)
+			valid = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if hash, ok := hashes[alg]; ok && strings.HasPrefix(alg, "ES") && len(signature) == 2*size {
+			h := hash.New()
+			h.Write(input)
+			r, sig := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+			valid = ecdsa.Verify(k, h.Sum(nil), r, sig)
+		}
+	case ed25519.PublicKey:
+		valid = alg == "EdDSA" && ed25519.Verify(k, input, signature)
+	default:
+		return acmeError("badPublicKey", "Unsupported key type %T", key)
+	}
+	if !valid {
+		return acmeError("badSignatureAlgorithm", "Invalid %s signature", alg)
+	}
+	return nil
+}
+
+// acmeMaxNonces is how many unused nonces are kept, and acmeNonceLifetime how long one may be used. Anyone can ask
+// for nonces, so the oldest is forgotten once the limit is reached; a client whose nonce is forgotten gets badNonce
+// and retries with the fresh nonce of that response.
+const (
+	acmeMaxNonces     = 10000
+	acmeNonceLifetime = time.Hour
+)
+
+// newNonce returns a nonce the next request may use once
+func (s *acmeServer) newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	s.nonceMu.Lock()
+	defer s.nonceMu.Unlock()
+	if len(s.nonceRing) < acmeMaxNonces {
+		s.nonceRing = append(s.nonceRing, nonce)
+	} else {
+		delete(s.nonces, s.nonceRing[s.nonceNext])
+		s.nonceRing[s.nonceNext] = nonce
+		s.nonceNext = (s.nonceNext + 1) % acmeMaxNonces
+	}
+	s.nonces[nonce] = time.Now()
+	return nonce
+}
+
+// writeJSON sends a response with a fresh nonce, and the resource's URL when location is set
+func (s *acmeServer) writeJSON(w http.ResponseWriter, r *http.Request, status int, value interface{}, location string) {
+	body, _ := json.MarshalIndent(value, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Add("Link", fmt.Sprintf(`<%s/dir>;rel="index"`, baseURL(r)))
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeProblem sends an ACME error document
+func (s *acmeServer) writeProblem(w http.ResponseWriter, r *http.Request, prob *acmeProblem) {
+	if prob.Status == 0 {
+		prob.Status = http.StatusBadRequest
+		if prob.Type == "urn:ietf:params:acme:error:unauthorized" {
+			prob.Status = http.StatusForbidden
+		}
+	}
+	body, _ := json.MarshalIndent(prob, "", "  ")
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Add("Link", fmt.Sprintf(`<%s/dir>;rel="index"`, baseURL(r)))
+	w.WriteHeader(prob.Status)
+	w.Write(body)
+}
+
+// accountJSON is the account object of acct
+func (s *acmeServer) accountJSON(r *http.Request, acct *acmeServerAccount) map[string]interface{} {
+	contact := acct.Contact
+	if contact == nil {
+		contact = []string{}
+	}
+	return map[string]interface{}{
+		"status":  acct.Status,
+		"contact": contact,
+		"orders":  baseURL(r) + "/account/" + acct.ID + "/orders",
+	}
+}
+
+// orderJSON is the order object of order; s.mu must be held
+func (s *acmeServer) orderJSON(r *http.Request, order *acmeServerOrder) map[string]interface{} {
+	var authzs []string
+	for _, id := range order.Authzs {
+		authzs = append(authzs, baseURL(r)+"/authz/"+id)
+	}
+	value := map[string]interface{}{
+		"status":         s.orderStatus(order),
+		"expires":        order.Expires.UTC().Format(time.RFC3339),
+		"identifiers":    order.Identifiers,
+		"authorizations": authzs,
+		"finalize":       baseURL(r) + "/finalize/" + order.ID,
+	}
+	if order.Cert != "" {
+		value["certificate"] = baseURL(r) + "/cert/" + order.Cert
+	}
+	if order.Error != nil {
+		value["error"] = order.Error
+	}
+	return value
+}
+
+// orderStatus derives an order's status from its authorizations; s.mu must be held
+func (s *acmeServer) orderStatus(order *acmeServerOrder) string {
+	if order.Cert != "" {
+		return "valid"
+	}
+	if order.Error != nil {
+		return "invalid"
+	}
+	if order.Processing {
+		return "processing"
+	}
+	ready := true
+	for _, id := range order.Authzs {
+		switch s.authzStatus(s.authzs[id]) {
+		case "invalid":
+			return "invalid"
+		case "pending":
+			ready = false
+		}
+	}
+	if !ready && time.Now().After(order.Expires) {
+		return "invalid"
+	}
+	if ready {
+		return "ready"
+	}
+	return "pending"
+}
+
+// authzStatus derives an authorization's status from its challenges; s.mu must be held
+func (s *acmeServer) authzStatus(authz *acmeServerAuthz) string {
+	for _, id := range authz.Challenges {
+		switch s.challenges[id].Status {
+		case "valid":
+			return "valid"
+		case "invalid":
+			return "invalid"
+		}
+	}
+	if time.Now().After(authz.Expires) {
+		return "expired"
+	}
+	return "pending"
+}
+
+// authzJSON is the authorization object of authz; s.mu must be held
+func (s *acmeServer) authzJSON(r *http.Request, authz *acmeServerAuthz) map[string]interface{} {
+	var challenges []map[string]interface{}
+	for _, id := range authz.Challenges {
+		challenges = append(challenges, s.challengeJSON(r, s.challenges[id]))
+	}
+	value := map[string]interface{}{
+		"status":     s.authzStatus(authz),
+		"expires":    authz.Expires.UTC().Format(time.RFC3339),
+		"identifier": authz.Identifier,
+		"challenges": challenges,
+	}
+	if authz.Wildcard {
+		value["wildcard"] = true
+	}
+	return value
+}
+
+// challengeJSON is the challenge object of chal
+func (s *acmeServer) challengeJSON(r *http.Request, chal *acmeServerChallenge) map[string]interface{} {
+	value := map[string]interface{}{
+		"type":   chal.Type,
+		"url":    baseURL(r) + "/chall/" + chal.ID,
+		"token":  chal.Token,
+		"status": chal.Status,
+	}
+	if !chal.Validated.IsZero() {
+		value["validated"] = chal.Validated.UTC().Format(time.RFC3339)
+	}
+	if chal.Error != nil {
+		value["error"] = chal.Error
+	}
+	return value
+}
+
+// acmeError builds a problem of an ACME error type, like badNonce
+func acmeError(typ, format string, args ...interface{}) *acmeProblem {
+	return &acmeProblem{Type: "urn:ietf:params:acme:error:" + typ, Detail: fmt.Sprintf(format, args...)}
+}
+
+// checkACMEContacts accepts only mailto: contacts, as Let's Encrypt does
+func checkACMEContacts(contacts []string) *acmeProblem {
+	for _, contact := range contacts {
+		if !strings.HasPrefix(contact, "mailto:") || !strings.Contains(contact, "@") {
+			return acmeError("invalidContact", "Unsupported contact %q (use mailto:)", contact)
+		}
+	}
+	return nil
+}
+
+// checkACMEIdentifier accepts DNS names, including wildcards, and IP addresses (RFC 8738)
+func checkACMEIdentifier(id acme.AuthzID) *acmeProblem {
+	switch id.Type {
+	case "dns":
+		if net.ParseIP(id.Value) != nil || len(id.Value) > 253 || !acmeDNSName.MatchString(id.Value) {
+			return acmeError("rejectedIdentifier", "Invalid DNS name %q", id.Value)
+		}
+	case "ip":
+		ip := net.ParseIP(id.Value)
+		if ip == nil || ip.String() != id.Value {
+			return acmeError("rejectedIdentifier", "Invalid IP address %q", id.Value)
+		}
+	default:
+		return acmeError("unsupportedIdentifier", "Unsupported identifier type %q", id.Type)
+	}
+	return nil
+}
+
+// describeACMEIdentifiers lists the values of identifiers for logs
+func describeACMEIdentifiers(ids []acme.AuthzID) string {
+	var values []string
+	for _, id := range ids {
+		values = append(values, id.Value)
+	}
+	return strings.Join(values, ", ")
+}
+
+// acmeServerID returns a random identifier for a new resource
+func acmeServerID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
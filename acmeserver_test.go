@@ -0,0 +1,45 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestACMEServerNonceLimit(t *testing.T) {
+	s := &acmeServer{nonces: map[string]time.Time{}}
+	first := s.newNonce()
+	for i := 0; i < acmeMaxNonces+10; i++ {
+		s.newNonce()
+	}
+	if len(s.nonces) != acmeMaxNonces || len(s.nonceRing) != acmeMaxNonces {
+		t.Errorf("kept %d nonces in a ring of %d, want %d", len(s.nonces), len(s.nonceRing), acmeMaxNonces)
+	}
+	if _, ok := s.nonces[first]; ok {
+		t.Error("the oldest nonce was kept past the limit")
+	}
+	last := s.newNonce()
+	if _, ok := s.nonces[last]; !ok {
+		t.Error("the newest nonce was not kept")
+	}
+}
+
+func TestACMEServerOrderProcessing(t *testing.T) {
+	s := &acmeServer{authzs: map[string]*acmeServerAuthz{}}
+	order := &acmeServerOrder{Expires: time.Now().Add(time.Hour)}
+	if got := s.orderStatus(order); got != "ready" {
+		t.Fatalf("got status %s for an order without authorizations", got)
+	}
+	// A second finalize while the first signs finds the order processing rather than ready
+	order.Processing = true
+	if got := s.orderStatus(order); got != "processing" {
+		t.Errorf("got status %s, want processing", got)
+	}
+	order.Processing, order.Cert = false, "cert"
+	if got := s.orderStatus(order); got != "valid" {
+		t.Errorf("got status %s, want valid", got)
+	}
+}
@@ -0,0 +1,188 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// Ansible Vault derives its keys with PBKDF2-HMAC-SHA256 over a 32-byte salt
+const ansibleVaultIterations = 10000
+
+// ansibleVaultLabel matches the vault IDs Ansible accepts in a 1.2 header
+var ansibleVaultLabel = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// runExportAnsibleVault implements export ansible-vault
+func runExportAnsibleVault(args []string) error {
+	fs := flag.NewFlagSet("export ansible-vault", flag.ExitOnError)
+	keyFlag := fs.String("key", "", "Private key to encrypt")
+	certFlag := fs.String("cert", "", "Certificate, and any chain, to include")
+	outFlag := fs.String("out", "", "File to write")
+	passwordFileFlag := fs.String("vault-password-file", "", "File holding the vault password, as given to ansible-vault")
+	vaultIDFlag := fs.String("vault-id", "", "Vault ID label to record in the header, for playbooks using several vault passwords")
+	varsFlag := fs.Bool("vars", false, "Write a YAML vars file with !vault encrypted strings instead of an encrypted file")
+	nameFlag := fs.String("name", "tls", "Prefix of the variable names with --vars")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted private key (pass:, env:, file:, or stdin)")
+	parseArgs(fs, args)
+
+	if *keyFlag == "" || *outFlag == "" || *passwordFileFlag == "" {
+		return fmt.Errorf("export ansible-vault requires --key, --out, and --vault-password-file")
+	}
+	if *vaultIDFlag != "" && !ansibleVaultLabel.MatchString(*vaultIDFlag) {
+		return fmt.Errorf("Invalid --vault-id %q: use letters, digits, '_', '.', and '-'", *vaultIDFlag)
+	}
+	if !terraformIdentifier.MatchString(*nameFlag) || strings.Contains(*nameFlag, "-") {
+		return fmt.Errorf("Invalid --name %q: use letters, digits, and '_', starting with a letter or '_'", *nameFlag)
+	}
+
+	// Like ansible-vault, the password is the file's contents without surrounding whitespace
+	data, err := os.ReadFile(*passwordFileFlag)
+	if err != nil {
+		return fmt.Errorf("Error reading file: %v", err)
+	}
+	password := strings.TrimSpace(string(data))
+	if password == "" {
+		return fmt.Errorf("Vault password file %s is empty", *passwordFileFlag)
+	}
+
+	var keyPassword string
+	if *passinFlag != "" {
+		if keyPassword, err = readPassphrase(*passinFlag); err != nil {
+			return err
+		}
+	}
+	key, err := readPrivateKey(*keyFlag, keyPassword)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("Failed to encode private key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	var certPEM []byte
+	if *certFlag != "" {
+		certs, err := readCertificates(*certFlag)
+		if err != nil {
+			return err
+		}
+		if signer, ok := key.(crypto.Signer); !ok || !certforge.SamePublicKey(certs[0].PublicKey, signer.Public()) {
+			return fmt.Errorf("Private key %s does not match certificate %s", *keyFlag, *certFlag)
+		}
+		for _, cert := range certs {
+			certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+		}
+	}
+
+	var out []byte
+	if *varsFlag {
+		// The key is a !vault string; the certificate is public and stays readable
+		vault, err := encryptAnsibleVault(keyPEM, password, *vaultIDFlag)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		buf.WriteString("---\n# Generated by CertForge\n")
+		fmt.Fprintf(&buf, "%s_private_key: !vault |\n%s", *nameFlag, yamlIndent(vault, "  "))
+		if certPEM != nil {
+			fmt.Fprintf(&buf, "%s_certificate: |\n%s", *nameFlag, yamlIndent(certPEM, "  "))
+		}
+		out = buf.Bytes()
+	} else {
+		// The certificate comes first, as in the combined PEM files servers read
+		if out, err = encryptAnsibleVault(append(certPEM, keyPEM...), password, *vaultIDFlag); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(*outFlag, out, 0600); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+	}
+
+	fmt.Printf("Wrote %s\n", *outFlag)
+	if *varsFlag {
+		fmt.Printf("  %s_private_key: %s (encrypted)\n", *nameFlag, certforge.PrivateKeyDescription(key))
+		if certPEM != nil {
+			fmt.Printf("  %s_certificate: %s\n", *nameFlag, *certFlag)
+		}
+	} else {
+		fmt.Printf("  Private Key: %s\n", certforge.PrivateKeyDescription(key))
+		if certPEM != nil {
+			fmt.Printf("  Certificate: %s\n", *certFlag)
+		}
+	}
+	return nil
+}
+
+// encryptAnsibleVault encrypts plaintext in the Ansible Vault 1.1 format, or 1.2 when a vault ID is given
+func encryptAnsibleVault(plaintext []byte, password, vaultID string) ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	// 32 bytes of AES key, 32 bytes of HMAC key, and the 16-byte counter block
+	derived, err := pbkdf2.Key(sha256.New, password, salt, ansibleVaultIterations, 80)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive vault key: %v", err)
+	}
+	block, err := aes.NewCipher(derived[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	// Ansible pads to the AES block size even though CTR mode does not need it
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCTR(block, derived[64:80]).XORKeyStream(ciphertext, padded)
+
+	mac := hmac.New(sha256.New, derived[32:64])
+	mac.Write(ciphertext)
+
+	// The payload is hex encoded twice: the hex fields joined by newlines, then the whole
+	inner := hex.EncodeToString(salt) + "\n" + hex.EncodeToString(mac.Sum(nil)) + "\n" + hex.EncodeToString(ciphertext)
+	payload := hex.EncodeToString([]byte(inner))
+
+	var buf bytes.Buffer
+	if vaultID != "" {
+		fmt.Fprintf(&buf, "$ANSIBLE_VAULT;1.2;AES256;%s\n", vaultID)
+	} else {
+		buf.WriteString("$ANSIBLE_VAULT;1.1;AES256\n")
+	}
+	for len(payload) > 80 {
+		buf.WriteString(payload[:80] + "\n")
+		payload = payload[80:]
+	}
+	buf.WriteString(payload + "\n")
+	return buf.Bytes(), nil
+}
+
+// yamlIndent indents every line of text for use in a YAML block scalar
+func yamlIndent(text []byte, indent string) string {
+	var buf strings.Builder
+	for _, line := range strings.SplitAfter(string(text), "\n") {
+		if line != "" {
+			buf.WriteString(indent + line)
+		}
+	}
+	return buf.String()
+}
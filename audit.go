@@ -0,0 +1,441 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// auditSeverity orders audit problems from most to least urgent
+type auditSeverity int
+
+const (
+	severityCritical auditSeverity = iota
+	severityHigh
+	severityWarning
+	severityInfo
+)
+
+// auditSeverityNames maps severities to the labels shown in the report
+var auditSeverityNames = map[auditSeverity]string{
+	severityCritical: "CRITICAL",
+	severityHigh:     "HIGH",
+	severityWarning:  "WARNING",
+	severityInfo:     "INFO",
+}
+
+// String returns the label of a severity, as templates print it
+func (s auditSeverity) String() string {
+	return auditSeverityNames[s]
+}
+
+// auditCert is a certificate found during an audit
+type auditCert struct {
+	Path string
+	Cert *x509.Certificate
+}
+
+// auditKey is a private key found during an audit
+type auditKey struct {
+	Path   string
+	Public crypto.PublicKey
+}
+
+// auditProblem is a single finding in the audit report
+type auditProblem struct {
+	Severity auditSeverity
+	Path     string
+	Message  string
+
+	// Expires orders expiry problems so the soonest comes first
+	Expires time.Time
+}
+
+// auditReport collects everything found while scanning
+type auditReport struct {
+	Files     int
+	Certs     []auditCert
+	Keys      []auditKey
+	Encrypted []string
+	Problems  []auditProblem
+}
+
+// maxAuditFileSize skips files too large to be certificates or keys, unless they are PEM bundles
+const maxAuditFileSize = 1 << 20
+
+// runAudit implements the audit command
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	recursiveFlag := fs.Bool("recursive", false, "Scan subdirectories")
+	warnDaysFlag := fs.Int("warn-days", 30, "Flag certificates expiring within this many days")
+	weakKeysFlag := fs.String("weak-keys", "", "Debian weak key blocklist file or directory")
+	keyDBFlag := fs.String("key-db", "", "Database of seen public keys used to detect key reuse")
+	notifyFlag := fs.String("notify", "", "Notification config; expiring and expired certificates are sent to its targets")
+	templateFlag := fs.String("template", "", "Print the report through this text/template instead of the usual layout")
+	dirs := parseArgs(fs, args)
+
+	if len(dirs) == 0 {
+		return fmt.Errorf("audit requires a directory")
+	}
+
+	checker, err := newKeyChecker(*weakKeysFlag, *keyDBFlag)
+	if err != nil {
+		return err
+	}
+	var tmpl *template.Template
+	if *templateFlag != "" {
+		if tmpl, err = loadTemplate(*templateFlag); err != nil {
+			return err
+		}
+	}
+	var notify *notifyConfig
+	if *notifyFlag != "" {
+		if notify, err = loadNotifyConfig(*notifyFlag); err != nil {
+			return err
+		}
+	}
+
+	report := &auditReport{}
+	for _, dir := range dirs {
+		if err := scanAuditDir(dir, *recursiveFlag, report); err != nil {
+			return err
+		}
+	}
+	checkAudit(report, *warnDaysFlag, time.Now())
+	checkAuditKeys(report, checker)
+	if tmpl != nil {
+		if err := tmpl.Execute(os.Stdout, report); err != nil {
+			return fmt.Errorf("Failed to execute template: %v", err)
+		}
+	} else {
+		printAuditReport(report)
+	}
+	if err := checker.save(); err != nil {
+		return err
+	}
+	if notify != nil {
+		if err := notifyAudit(report, notify, *warnDaysFlag, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	for _, problem := range report.Problems {
+		if problem.Severity != severityInfo {
+			return fmt.Errorf("Audit found problems")
+		}
+	}
+	return nil
+}
+
+// notifyAudit sends a notification for every certificate the audit found expiring or expired
+func notifyAudit(report *auditReport, notify *notifyConfig, warnDays int, now time.Time) error {
+	sent := 0
+	for _, c := range report.Certs {
+		if _, level := describeExpiry(c.Cert, now, warnDays); level != expiryExpired && level != expiryWarning {
+			continue
+		}
+		notice := newExpiryNotice("audit", "", c.Path, c.Cert, time.Duration(warnDays)*24*time.Hour, now)
+		if err := notify.send(notice); err != nil {
+			return err
+		}
+		sent++
+	}
+	if sent > 0 {
+		fmt.Printf("\nSent %d expiry notifications\n", sent)
+	}
+	return nil
+}
+
+// scanAuditDir collects the certificates and keys under dir
+func scanAuditDir(dir string, recursive bool, report *auditReport) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return fmt.Errorf("Error reading directory: %v", err)
+			}
+			report.Problems = append(report.Problems, auditProblem{Severity: severityWarning, Path: path, Message: fmt.Sprintf("cannot be read: %v", err)})
+			return nil
+		}
+		if entry.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if info.Size() > maxAuditFileSize {
+			return scanAuditBundle(path, report)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			report.Problems = append(report.Problems, auditProblem{Severity: severityWarning, Path: path, Message: fmt.Sprintf("cannot be read: %v", err)})
+			return nil
+		}
+		report.Files++
+		scanAuditFile(path, data, report)
+		return nil
+	})
+}
+
+// scanAuditBundle streams a file too large to read at once, such as a CT dump, when it is a PEM bundle
+func scanAuditBundle(path string, report *auditReport) error {
+	file, err := os.Open(path)
+	if err != nil {
+		report.Problems = append(report.Problems, auditProblem{Severity: severityWarning, Path: path, Message: fmt.Sprintf("cannot be read: %v", err)})
+		return nil
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	if !looksLikePEM(reader) {
+		return nil
+	}
+
+	report.Files++
+	scanner := certforge.NewPEMScanner(reader)
+	for scanner.Scan() {
+		scanAuditBlock(path, scanner.Block(), report)
+	}
+	if err := scanner.Err(); err != nil {
+		report.Problems = append(report.Problems, auditProblem{Severity: severityWarning, Path: path, Message: fmt.Sprintf("cannot be read: %v", err)})
+	}
+	return nil
+}
+
+// scanAuditFile adds every certificate and private key in data to the report
+func scanAuditFile(path string, data []byte, report *auditReport) {
+	found := false
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		found = true
+		scanAuditBlock(path, block, report)
+	}
+
+	// DER certificates have no PEM armor
+	if !found {
+		if cert, err := x509.ParseCertificate(data); err == nil {
+			report.Certs = append(report.Certs, auditCert{Path: path, Cert: cert})
+		}
+	}
+}
+
+// scanAuditBlock adds the certificate or private key in a PEM block to the report
+func scanAuditBlock(path string, block *pem.Block, report *auditReport) {
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			report.Problems = append(report.Problems, auditProblem{Severity: severityWarning, Path: path, Message: fmt.Sprintf("unparseable certificate: %v", err)})
+			return
+		}
+		report.Certs = append(report.Certs, auditCert{Path: path, Cert: cert})
+	case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY":
+		// Legacy OpenSSL encryption is marked in the PEM headers
+		if strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
+			report.Encrypted = append(report.Encrypted, path)
+			return
+		}
+		key, err := certforge.ParsePrivateKeyBlock(block)
+		if err != nil {
+			report.Problems = append(report.Problems, auditProblem{Severity: severityWarning, Path: path, Message: fmt.Sprintf("unparseable private key: %v", err)})
+			return
+		}
+		if signer, ok := key.(crypto.Signer); ok {
+			report.Keys = append(report.Keys, auditKey{Path: path, Public: signer.Public()})
+		}
+	case "ENCRYPTED PRIVATE KEY":
+		report.Encrypted = append(report.Encrypted, path)
+	}
+}
+
+// checkAudit records the problems found in the collected certificates and keys
+func checkAudit(report *auditReport, warnDays int, now time.Time) {
+	for _, c := range report.Certs {
+		cert := c.Cert
+		subject := certforge.FormatName(cert.Subject)
+
+		text, level := describeExpiry(cert, now, warnDays)
+		switch level {
+		case expiryExpired:
+			report.Problems = append(report.Problems, auditProblem{Severity: severityCritical, Path: c.Path, Message: fmt.Sprintf("%s %s", subject, text), Expires: cert.NotAfter})
+		case expiryWarning:
+			report.Problems = append(report.Problems, auditProblem{Severity: severityWarning, Path: c.Path, Message: fmt.Sprintf("%s %s", subject, text), Expires: cert.NotAfter})
+		}
+
+		if weak := weakKeyReason(cert.PublicKey); weak != "" {
+			report.Problems = append(report.Problems, auditProblem{Severity: severityHigh, Path: c.Path, Message: fmt.Sprintf("%s uses a weak key (%s)", subject, weak)})
+		}
+		if certforge.IsWeakSignature(cert.SignatureAlgorithm) && !certforge.IsSelfSigned(cert) {
+			report.Problems = append(report.Problems, auditProblem{Severity: severityHigh, Path: c.Path, Message: fmt.Sprintf("%s is signed with %s", subject, cert.SignatureAlgorithm)})
+		}
+	}
+
+	// A key next to a certificate with the same file name stem must match it
+	for _, k := range report.Keys {
+		if weak := weakKeyReason(k.Public); weak != "" {
+			report.Problems = append(report.Problems, auditProblem{Severity: severityHigh, Path: k.Path, Message: fmt.Sprintf("weak private key (%s)", weak)})
+		}
+
+		matched := false
+		for _, c := range report.Certs {
+			if certforge.SamePublicKey(k.Public, c.Cert.PublicKey) {
+				matched = true
+				continue
+			}
+			if c.Path != k.Path && fileStem(c.Path) == fileStem(k.Path) && !hasMatchingKey(c.Cert, report.Keys) {
+				report.Problems = append(report.Problems, auditProblem{Severity: severityCritical, Path: k.Path, Message: fmt.Sprintf("does not match certificate %s", c.Path)})
+			}
+		}
+		if !matched {
+			report.Problems = append(report.Problems, auditProblem{Severity: severityInfo, Path: k.Path, Message: "no matching certificate found"})
+		}
+	}
+
+	sortAuditProblems(report)
+}
+
+// checkAuditKeys records Debian weak keys and public keys shared by different certificates
+func checkAuditKeys(report *auditReport, checker *keyChecker) {
+	for _, k := range report.Keys {
+		if checker.isDebianWeak(k.Public) {
+			report.Problems = append(report.Problems, auditProblem{Severity: severityCritical, Path: k.Path, Message: "Debian weak private key (CVE-2008-0166)"})
+		}
+	}
+
+	subjectsByKey := make(map[string][]auditCert)
+	for _, c := range report.Certs {
+		if checker.isDebianWeak(c.Cert.PublicKey) {
+			report.Problems = append(report.Problems, auditProblem{Severity: severityCritical, Path: c.Path, Message: fmt.Sprintf("%s uses a Debian weak key (CVE-2008-0166)", certforge.FormatName(c.Cert.Subject))})
+		}
+		for _, other := range checker.reusedBy(c.Cert, c.Path) {
+			report.Problems = append(report.Problems, auditProblem{Severity: severityWarning, Path: c.Path, Message: fmt.Sprintf("%s shares its key with %s (%s, first seen %s)", certforge.FormatName(c.Cert.Subject), other.Subject, other.Source, other.FirstSeen)})
+		}
+
+		// Within one scan, the same key under different subjects is reuse rather than a renewal
+		spki := string(c.Cert.RawSubjectPublicKeyInfo)
+		for _, seen := range subjectsByKey[spki] {
+			if !bytes.Equal(seen.Cert.RawSubject, c.Cert.RawSubject) {
+				report.Problems = append(report.Problems, auditProblem{Severity: severityWarning, Path: c.Path, Message: fmt.Sprintf("%s shares its key with %s in %s", certforge.FormatName(c.Cert.Subject), certforge.FormatName(seen.Cert.Subject), seen.Path)})
+				break
+			}
+		}
+		subjectsByKey[spki] = append(subjectsByKey[spki], c)
+	}
+	sortAuditProblems(report)
+}
+
+// sortAuditProblems orders problems by severity, then by how soon they expire
+func sortAuditProblems(report *auditReport) {
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		a, b := report.Problems[i], report.Problems[j]
+		if a.Severity != b.Severity {
+			return a.Severity < b.Severity
+		}
+		return a.Expires.Before(b.Expires)
+	})
+}
+
+// hasMatchingKey reports whether any of keys is the private key for cert
+func hasMatchingKey(cert *x509.Certificate, keys []auditKey) bool {
+	for _, k := range keys {
+		if certforge.SamePublicKey(k.Public, cert.PublicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileStem returns path without its extension, so site.crt and site.key pair up
+func fileStem(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// weakKeyReason describes why a public key is too weak, or returns "" if it is acceptable
+func weakKeyReason(pub crypto.PublicKey) string {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return fmt.Sprintf("RSA %d bits", key.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		if key.Curve.Params().BitSize < 256 {
+			return fmt.Sprintf("ECDSA %s", key.Curve.Params().Name)
+		}
+	}
+	return ""
+}
+
+// printAuditReport displays the inventory and the problems sorted by urgency
+func printAuditReport(report *auditReport) {
+	fmt.Println("=== Certificate Audit ===")
+	fmt.Println()
+	fmt.Printf("Scanned %d files: %d certificates, %d private keys", report.Files, len(report.Certs), len(report.Keys))
+	if len(report.Encrypted) > 0 {
+		fmt.Printf(", %d encrypted keys (not checked)", len(report.Encrypted))
+	}
+	fmt.Println()
+
+	if len(report.Certs) > 0 {
+		fmt.Println("\nCertificates:")
+		certs := append([]auditCert(nil), report.Certs...)
+		sort.SliceStable(certs, func(i, j int) bool { return certs[i].Cert.NotAfter.Before(certs[j].Cert.NotAfter) })
+		for _, c := range certs {
+			fmt.Printf("  %s  %s\n", c.Cert.NotAfter.Format("2006-01-02"), c.Path)
+			fmt.Printf("      %s | %s | %s\n", certforge.FormatName(c.Cert.Subject), certforge.PublicKeyDescription(c.Cert.PublicKey), c.Cert.SignatureAlgorithm)
+		}
+	}
+
+	if len(report.Keys) > 0 {
+		fmt.Println("\nPrivate Keys:")
+		for _, k := range report.Keys {
+			fmt.Printf("  %s (%s)\n", k.Path, certforge.PublicKeyDescription(k.Public))
+		}
+	}
+
+	fmt.Println()
+	if len(report.Problems) == 0 {
+		fmt.Println(colorize("No problems found", expiryOK))
+		return
+	}
+	fmt.Printf("Problems (%d):\n", len(report.Problems))
+	for _, problem := range report.Problems {
+		label := fmt.Sprintf("%-8s", auditSeverityNames[problem.Severity])
+		switch problem.Severity {
+		case severityCritical, severityHigh:
+			label = colorize(label, expiryExpired)
+		case severityWarning:
+			label = colorize(label, expiryWarning)
+		}
+		fmt.Printf("  %s %s: %s\n", label, problem.Path, problem.Message)
+	}
+}
@@ -0,0 +1,477 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// auditLogEnv names the audit log file; "off" disables the log
+const auditLogEnv = "CERTFORGE_AUDIT_LOG"
+
+// Operations recorded in the audit log
+const (
+	auditLogKeyGenerated       = "key_generated"
+	auditLogCSRCreated         = "csr_created"
+	auditLogCertIssued         = "certificate_issued"
+	auditLogCertSigned         = "certificate_signed"
+	auditLogCertRevoked        = "certificate_revoked"
+	auditLogSSHCertSigned      = "ssh_certificate_signed"
+	auditLogAccountRegistered  = "account_registered"
+	auditLogAccountKeyChanged  = "account_key_changed"
+	auditLogAccountDeactivated = "account_deactivated"
+)
+
+// auditLogCommandWord matches the words naming a command, like "ssh sign", as opposed to its files and flags
+var auditLogCommandWord = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// auditLogMu serializes the entries of one process, so each links to the one before it
+var auditLogMu sync.Mutex
+
+// auditLogEntry is one line of the audit log: who did what, when, to which certificate or key
+type auditLogEntry struct {
+	Time           time.Time       `json:"time"`
+	Operation      string          `json:"operation"`
+	Command        string          `json:"command"`
+	User           string          `json:"user"`
+	Host           string          `json:"host"`
+	PID            int             `json:"pid"`
+	Actor          string          `json:"actor,omitempty"`
+	Subject        string          `json:"subject,omitempty"`
+	Issuer         string          `json:"issuer,omitempty"`
+	Names          []string        `json:"names,omitempty"`
+	Serial         string          `json:"serial,omitempty"`
+	Fingerprint    string          `json:"fingerprint,omitempty"`
+	NotAfter       *time.Time      `json:"not_after,omitempty"`
+	KeyType        string          `json:"key_type,omitempty"`
+	KeyFingerprint string          `json:"key_fingerprint,omitempty"`
+	Reason         string          `json:"reason,omitempty"`
+	Inputs         []auditLogInput `json:"inputs,omitempty"`
+	Outputs        []string        `json:"outputs,omitempty"`
+	Prev           string          `json:"prev"`
+}
+
+// auditLogInput is an input, such as a file or a submitted CSR, and the SHA-256 of its contents at the time of the operation
+type auditLogInput struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// auditLogPath returns the audit log file, or "" when it is disabled
+func auditLogPath() (string, error) {
+	path := os.Getenv(auditLogEnv)
+	if path == "off" {
+		return "", nil
+	}
+	if path != "" {
+		return path, nil
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("No user config directory for the audit log; set %s", auditLogEnv)
+	}
+	return filepath.Join(config, "certforge", "audit.jsonl"), nil
+}
+
+// auditLogCertificate describes cert for an audit entry
+func auditLogCertificate(operation string, cert *x509.Certificate) auditLogEntry {
+	sum := sha256.Sum256(cert.Raw)
+	notAfter := cert.NotAfter.UTC()
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	entry := auditLogEntry{
+		Operation:   operation,
+		Subject:     certforge.FormatName(cert.Subject),
+		Issuer:      certforge.FormatName(cert.Issuer),
+		Names:       names,
+		Serial:      cert.SerialNumber.Text(16),
+		Fingerprint: hex.EncodeToString(sum[:]),
+		NotAfter:    &notAfter,
+	}
+	entry.KeyType, entry.KeyFingerprint = auditLogPublicKey(cert.PublicKey)
+	return entry
+}
+
+// auditLogKey describes a generated key, by its public half, for an audit entry
+func auditLogKey(pub crypto.PublicKey, outputs ...string) auditLogEntry {
+	entry := auditLogEntry{Operation: auditLogKeyGenerated, Outputs: outputs}
+	entry.KeyType, entry.KeyFingerprint = auditLogPublicKey(pub)
+	return entry
+}
+
+// auditLogPublicKey returns the description and SHA-256 SPKI fingerprint of a public key
+func auditLogPublicKey(pub crypto.PublicKey) (string, string) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return certforge.PublicKeyDescription(pub), ""
+	}
+	sum := sha256.Sum256(der)
+	return certforge.PublicKeyDescription(pub), hex.EncodeToString(sum[:])
+}
+
+// auditLogData describes an input that is not a file, like a CSR received over the network
+func auditLogData(name string, data []byte) auditLogInput {
+	sum := sha256.Sum256(data)
+	return auditLogInput{Name: name, SHA256: hex.EncodeToString(sum[:])}
+}
+
+// auditLogCSR describes a created CSR for an audit entry
+func auditLogCSR(der []byte, outputs ...string) auditLogEntry {
+	entry := auditLogEntry{Operation: auditLogCSRCreated, Outputs: outputs, Inputs: []auditLogInput{auditLogData("CSR", der)}}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return entry
+	}
+	entry.Subject = certforge.FormatName(csr.Subject)
+	entry.Names = append(entry.Names, csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		entry.Names = append(entry.Names, ip.String())
+	}
+	entry.KeyType, entry.KeyFingerprint = auditLogPublicKey(csr.PublicKey)
+	return entry
+}
+
+// auditLogIssued records a certificate obtained from a CA and saved to paths, with the CSR it was requested with
+func auditLogIssued(chain [][]byte, csrDER []byte, paths issuedPaths) {
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return
+	}
+	entry := auditLogCertificate(auditLogCertIssued, cert)
+	if csrDER != nil {
+		entry.Inputs = []auditLogInput{auditLogData("CSR", csrDER)}
+	}
+	for _, path := range []string{paths.Cert, paths.Chain, paths.FullChain} {
+		if path != "" {
+			entry.Outputs = append(entry.Outputs, path)
+		}
+	}
+	auditLog(entry)
+}
+
+// auditLogInputs hashes the input files of an operation, skipping those that cannot be read
+func auditLogInputs(paths ...string) []auditLogInput {
+	var inputs []auditLogInput
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		inputs = append(inputs, auditLogInput{Name: path, SHA256: hex.EncodeToString(sum[:])})
+	}
+	return inputs
+}
+
+// auditLog appends an entry to the audit log; a log that cannot be written is reported but does not fail the operation,
+// which has already happened
+func auditLog(entry auditLogEntry) {
+	if err := writeAuditEntry(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to write audit log: %v\n", err)
+	}
+}
+
+// writeAuditEntry fills in who, when, and the link to the previous entry, then appends the entry as one line
+func writeAuditEntry(entry auditLogEntry) error {
+	path, err := auditLogPath()
+	if err != nil || path == "" {
+		return err
+	}
+	auditLogMu.Lock()
+	defer auditLogMu.Unlock()
+
+	entry.Time = time.Now().UTC()
+	entry.Command = auditLogCommand(os.Args[1:])
+	if current, err := user.Current(); err == nil {
+		entry.User = current.Username
+	} else {
+		entry.User = os.Getenv("USER")
+	}
+	entry.Host, _ = os.Hostname()
+	entry.PID = os.Getpid()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	last, err := lastAuditLine(file)
+	if err != nil {
+		return err
+	}
+	// Each entry carries the hash of the one before, so edits and deletions break the chain
+	if last != nil {
+		sum := sha256.Sum256(last)
+		entry.Prev = hex.EncodeToString(sum[:])
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// lastAuditLine returns the last line of the log, or nil when it is empty
+func lastAuditLine(file *os.File) ([]byte, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// Entries are far shorter than the tail read, which keeps appends fast however long the log grows
+	offset := info.Size() - 64*1024
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	tail = bytes.TrimRight(tail, "\n")
+	if len(tail) == 0 {
+		return nil, nil
+	}
+	return tail[bytes.LastIndexByte(tail, '\n')+1:], nil
+}
+
+// auditLogCommand names the command being run from its leading words, or "generate" for the top-level generator
+func auditLogCommand(args []string) string {
+	var words []string
+	for _, arg := range args {
+		if len(words) == 3 || !auditLogCommandWord.MatchString(arg) {
+			break
+		}
+		words = append(words, arg)
+	}
+	if len(words) == 0 {
+		return "generate"
+	}
+	return strings.Join(words, " ")
+}
+
+// auditLogCommands are the audit-log subcommands
+var auditLogCommands = map[string]func(args []string) error{
+	"show": runAuditLogShow,
+}
+
+// runAuditLog implements the audit-log command, which dispatches to its subcommands
+func runAuditLog(args []string) error {
+	var names []string
+	for name := range auditLogCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(args) == 0 {
+		return fmt.Errorf("audit-log requires a subcommand: %s", strings.Join(names, ", "))
+	}
+	command, ok := auditLogCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown audit-log subcommand %q (use %s)", args[0], strings.Join(names, ", "))
+	}
+	return command(args[1:])
+}
+
+// runAuditLogShow implements audit-log show, which lists entries and checks the hash chain
+func runAuditLogShow(args []string) error {
+	fs := flag.NewFlagSet("audit-log show", flag.ExitOnError)
+	fileFlag := fs.String("file", "", "Audit log to read (default: $"+auditLogEnv+", or audit.jsonl in the user config directory)")
+	sinceFlag := fs.String("since", "", "Only show entries newer than this, like 24h or 30d")
+	operationFlag := fs.String("operation", "", "Only show entries of this operation, like certificate_signed")
+	serialFlag := fs.String("serial", "", "Only show entries for this certificate serial (hex)")
+	nameFlag := fs.String("name", "", "Only show entries whose subject or names contain this")
+	userFlag := fs.String("user", "", "Only show entries by this user or API client")
+	jsonFlag := fs.Bool("json", false, "Print the matching entries as JSON lines instead of a table")
+	verboseFlag := fs.Bool("verbose", false, "Print every field of the matching entries")
+	parseArgs(fs, args)
+
+	path := *fileFlag
+	if path == "" {
+		var err error
+		if path, err = auditLogPath(); err != nil {
+			return err
+		}
+		if path == "" {
+			return fmt.Errorf("The audit log is disabled (%s=off); pass --file", auditLogEnv)
+		}
+	}
+	var since time.Time
+	if *sinceFlag != "" {
+		age, err := parseThreshold(*sinceFlag)
+		if err != nil {
+			return fmt.Errorf("Invalid --since: %v", err)
+		}
+		since = time.Now().Add(-age)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error reading file: %v", err)
+	}
+	defer file.Close()
+
+	var matches []auditLogEntry
+	var raw [][]byte
+	var problems []string
+	var prev []byte
+	total := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		total++
+		var entry auditLogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			problems = append(problems, fmt.Sprintf("line %d is not a valid entry", total))
+			prev = append(prev[:0], line...)
+			continue
+		}
+		want := ""
+		if prev != nil {
+			sum := sha256.Sum256(prev)
+			want = hex.EncodeToString(sum[:])
+		}
+		if entry.Prev != want {
+			problems = append(problems, fmt.Sprintf("line %d does not follow line %d (entries were changed, removed, or inserted)", total, total-1))
+		}
+		prev = append(prev[:0], line...)
+
+		if !since.IsZero() && entry.Time.Before(since) ||
+			*operationFlag != "" && entry.Operation != *operationFlag ||
+			*serialFlag != "" && !strings.EqualFold(strings.TrimLeft(entry.Serial, "0"), strings.TrimLeft(strings.ReplaceAll(*serialFlag, ":", ""), "0")) ||
+			*userFlag != "" && entry.User != *userFlag && entry.Actor != *userFlag ||
+			*nameFlag != "" && !strings.Contains(strings.ToLower(entry.Subject+" "+strings.Join(entry.Names, " ")), strings.ToLower(*nameFlag)) {
+			continue
+		}
+		matches = append(matches, entry)
+		raw = append(raw, append([]byte{}, line...))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Failed to read %s: %v", path, err)
+	}
+
+	if *jsonFlag {
+		for _, line := range raw {
+			fmt.Println(string(line))
+		}
+	} else {
+		printAuditEntries(path, matches, total, *verboseFlag)
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, colorize("Hash chain BROKEN:", expiryExpired))
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "  %s\n", problem)
+		}
+		return fmt.Errorf("The audit log %s has been tampered with or corrupted", path)
+	}
+	return nil
+}
+
+// printAuditEntries displays the entries as a table, or with every field when verbose
+func printAuditEntries(path string, entries []auditLogEntry, total int, verbose bool) {
+	fmt.Println("=== Audit Log ===")
+	fmt.Println()
+	fmt.Printf("File: %s\n", path)
+	fmt.Printf("Entries: %d shown of %d\n", len(entries), total)
+	fmt.Println()
+	if len(entries) == 0 {
+		return
+	}
+
+	if verbose {
+		for _, entry := range entries {
+			fmt.Printf("%s  %s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Operation)
+			fmt.Printf("  Command: %s (pid %d)\n", entry.Command, entry.PID)
+			fmt.Printf("  User: %s@%s\n", entry.User, entry.Host)
+			if entry.Actor != "" {
+				fmt.Printf("  Actor: %s\n", entry.Actor)
+			}
+			if entry.Subject != "" {
+				fmt.Printf("  Subject: %s\n", entry.Subject)
+			}
+			if entry.Issuer != "" {
+				fmt.Printf("  Issuer: %s\n", entry.Issuer)
+			}
+			if len(entry.Names) > 0 {
+				fmt.Printf("  Names: %s\n", strings.Join(entry.Names, ", "))
+			}
+			if entry.Serial != "" {
+				fmt.Printf("  Serial: %s\n", entry.Serial)
+			}
+			if entry.Fingerprint != "" {
+				fmt.Printf("  Fingerprint: %s\n", entry.Fingerprint)
+			}
+			if entry.NotAfter != nil {
+				fmt.Printf("  Not After: %s\n", entry.NotAfter.Format(time.RFC3339))
+			}
+			if entry.KeyType != "" {
+				fmt.Printf("  Key: %s (%s)\n", entry.KeyType, entry.KeyFingerprint)
+			}
+			if entry.Reason != "" {
+				fmt.Printf("  Reason: %s\n", entry.Reason)
+			}
+			for _, input := range entry.Inputs {
+				fmt.Printf("  Input: %s (SHA-256 %s)\n", input.Name, input.SHA256)
+			}
+			for _, output := range entry.Outputs {
+				fmt.Printf("  Output: %s\n", output)
+			}
+			fmt.Println()
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tOPERATION\tCOMMAND\tUSER\tSUBJECT\tSERIAL")
+	for _, entry := range entries {
+		user := entry.User
+		if entry.Actor != "" {
+			user += " (" + entry.Actor + ")"
+		}
+		subject := entry.Subject
+		if subject == "" && len(entry.Names) > 0 {
+			subject = strings.Join(entry.Names, ",")
+		} else if subject == "" && entry.KeyType != "" {
+			subject = entry.KeyType + " key " + entry.KeyFingerprint[:min(16, len(entry.KeyFingerprint))]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Operation, entry.Command, user, subject, entry.Serial)
+	}
+	w.Flush()
+}
@@ -0,0 +1,200 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// bulkProfiles map the profiles of bulk to the extended key usages of their certificates
+var bulkProfiles = map[string][]string{
+	"server": nil,
+	"client": {"clientAuth"},
+	"mtls":   {"serverAuth", "clientAuth"},
+}
+
+// bulkResult is the outcome for one line of the domain list
+type bulkResult struct {
+	Domain string
+	Status string
+	Detail string
+}
+
+// runBulk implements the bulk command, which issues a certificate from a local CA for each domain in a list
+func runBulk(args []string) error {
+	fs := flag.NewFlagSet("bulk", flag.ExitOnError)
+	domainsFlag := fs.String("domains", "", "File listing one domain per line, optionally followed by further names for its SANs")
+	profileFlag := fs.String("profile", "server", "Certificate profile: server, client, or mtls (both)")
+	caFlag := fs.String("ca", "", "CA certificate that signs the certificates, followed by any chain")
+	caKeyFlag := fs.String("ca-key", "", "Private key of the CA, or a comma separated list of its shares (default: --ca with a .key extension)")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted CA key (pass:, env:, file:, or stdin)")
+	daysFlag := fs.Int("days", 90, "Validity of the certificates in days")
+	keyTypeFlag := fs.String("key-type", "rsa", "Type of the new keys: rsa or ecdsa")
+	keySizeFlag := fs.Int("key-size", 2048, "Size of new RSA keys: 2048, 3072, or 4096")
+	outDirFlag := fs.String("out-dir", ".", "Directory the per-domain directories are created in")
+	forceFlag := fs.Bool("force", false, "Reissue certificates for domains that already have one in --out-dir")
+	parseArgs(fs, args)
+
+	if *domainsFlag == "" || *caFlag == "" {
+		return fmt.Errorf("bulk requires --domains and --ca")
+	}
+	usages, ok := bulkProfiles[strings.ToLower(*profileFlag)]
+	if !ok {
+		return fmt.Errorf("Invalid --profile %q (use server, client, or mtls)", *profileFlag)
+	}
+	if *daysFlag < 1 {
+		return fmt.Errorf("Invalid --days %d", *daysFlag)
+	}
+	keyType := certforge.KeyType(strings.ToLower(*keyTypeFlag))
+	switch {
+	case keyType != certforge.RSA && keyType != certforge.ECDSA:
+		return fmt.Errorf("Invalid --key-type %q (use rsa or ecdsa)", *keyTypeFlag)
+	case keyType == certforge.RSA && *keySizeFlag != 2048 && *keySizeFlag != 3072 && *keySizeFlag != 4096:
+		return fmt.Errorf("Invalid --key-size %d (use 2048, 3072, or 4096)", *keySizeFlag)
+	case keyType == certforge.ECDSA:
+		*keySizeFlag = 256
+	}
+	var extensions []pkix.Extension
+	if usages != nil {
+		ext, err := certforge.ExtKeyUsageExtension(usages...)
+		if err != nil {
+			return err
+		}
+		extensions = append(extensions, ext)
+	}
+	caKey := *caKeyFlag
+	if caKey == "" {
+		caKey = strings.TrimSuffix(*caFlag, ".crt") + ".key"
+	}
+
+	lines, err := readBulkDomains(*domainsFlag)
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("No domains found in %s", *domainsFlag)
+	}
+	// The CA is loaded once, and its key decrypted once, for every certificate
+	ca := &managedCA{Cert: *caFlag, Key: caKey, Passin: *passinFlag, Days: *daysFlag}
+	if _, err := localCAs.get(ca); err != nil {
+		return err
+	}
+
+	ctx, cancel := commandContext(0)
+	defer cancel()
+	var results []bulkResult
+	failed := 0
+	for _, names := range lines {
+		if ctx.Err() != nil {
+			results = append(results, bulkResult{names[0], "skipped", "interrupted"})
+			continue
+		}
+		result := issueBulkCertificate(names, ca, keyType, *keySizeFlag, extensions, *outDirFlag, *forceFlag)
+		if result.Status == "failed" {
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	fmt.Printf("=== Bulk Issuance: %s ===\n\n", *domainsFlag)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DOMAIN\tSTATUS\tDETAIL")
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.Domain, result.Status, result.Detail)
+	}
+	w.Flush()
+	fmt.Printf("\nIssued: %d, Skipped: %d, Failed: %d\n", counts["issued"], counts["skipped"], counts["failed"])
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("Interrupted")
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to issue %d of %d certificates", failed, len(results))
+	}
+	return nil
+}
+
+// readBulkDomains reads a domain list: one certificate per line, its domain first, followed by any further names
+// separated by spaces. Blank lines and lines starting with # are skipped.
+func readBulkDomains(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	defer f.Close()
+	var lines [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.Fields(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	return lines, nil
+}
+
+// issueBulkCertificate issues a certificate for names, the first of which is the domain naming its directory, and
+// writes its files there
+func issueBulkCertificate(names []string, ca *managedCA, keyType certforge.KeyType, keySize int, extensions []pkix.Extension, outDir string, force bool) bulkResult {
+	domain := strings.ToLower(names[0])
+	result := bulkResult{Domain: domain}
+	// The domain names a directory, so it must be a DNS name or IP address rather than a path
+	if net.ParseIP(domain) == nil {
+		if err := certforge.CheckDNSName(domain); err != nil {
+			result.Status, result.Detail = "failed", err.Error()
+			return result
+		}
+	}
+	// Wildcard directories are named with an underscore, as * is awkward in shells
+	name := strings.ReplaceAll(domain, "*", "_")
+	dir := filepath.Join(outDir, name)
+	paths := newIssuedPaths("", dir, name)
+	if _, err := os.Stat(paths.Cert); err == nil && !force {
+		result.Status, result.Detail = "skipped", paths.Cert+" exists"
+		return result
+	}
+
+	key, err := certforge.GenerateKey(keyType, keySize)
+	if err != nil {
+		result.Status, result.Detail = "failed", err.Error()
+		return result
+	}
+	defer certforge.ZeroizeKey(key)
+	auditLog(auditLogKey(key.Public()))
+	csrDER, chain, err := issueFromLocalCA(append([]string{domain}, names[1:]...), key, extensions, ca)
+	if err != nil {
+		result.Status, result.Detail = "failed", err.Error()
+		return result
+	}
+	if _, err := writeIssuedFiles("", dir, name, key, csrDER, chain, true); err != nil {
+		result.Status, result.Detail = "failed", err.Error()
+		return result
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		result.Status, result.Detail = "failed", err.Error()
+		return result
+	}
+	result.Status = "issued"
+	result.Detail = fmt.Sprintf("%s, valid until %s", dir, cert.NotAfter.Format("2006-01-02"))
+	return result
+}
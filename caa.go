@@ -0,0 +1,189 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// caaIssuer is a certificate authority and the domain it recognizes in CAA records
+type caaIssuer struct {
+	Names     []string
+	CAADomain string
+}
+
+// caaIssuers maps common public CAs to their CAA issuer domains. Names are matched
+// case-insensitively against the --ca value and the issuer's organization and common name.
+var caaIssuers = []caaIssuer{
+	{[]string{"let's encrypt", "letsencrypt"}, "letsencrypt.org"},
+	{[]string{"digicert", "rapidssl", "geotrust", "thawte"}, "digicert.com"},
+	{[]string{"sectigo", "comodo", "zerossl"}, "sectigo.com"},
+	{[]string{"globalsign"}, "globalsign.com"},
+	{[]string{"google trust services", "google"}, "pki.goog"},
+	{[]string{"amazon"}, "amazon.com"},
+	{[]string{"entrust"}, "entrust.net"},
+	{[]string{"godaddy", "starfield"}, "godaddy.com"},
+	{[]string{"buypass"}, "buypass.com"},
+	{[]string{"ssl.com", "ssl corporation"}, "ssl.com"},
+	{[]string{"microsoft"}, "microsoft.com"},
+}
+
+// runCAA implements the caa command
+func runCAA(args []string) error {
+	fs := flag.NewFlagSet("caa", flag.ExitOnError)
+	certFlag := fs.String("cert", "", "Certificate whose issuer and names are used")
+	caFlag := fs.String("ca", "", "Comma separated CAs to allow, by name or CAA domain (default: the certificate's issuer)")
+	domainFlag := fs.String("domain", "", "Comma separated domains to protect (default: the certificate's names)")
+	iodefFlag := fs.String("iodef", "", "Where CAs report refused requests (default: mailto:security@<domain>)")
+	parseArgs(fs, args)
+
+	if *certFlag == "" && (*caFlag == "" || *domainFlag == "") {
+		return fmt.Errorf("caa requires --cert, or --ca and --domain")
+	}
+
+	var cert *x509.Certificate
+	if *certFlag != "" {
+		certs, err := readCertificates(*certFlag)
+		if err != nil {
+			return err
+		}
+		cert = certs[0]
+	}
+
+	// CAs to allow
+	var issuers []string
+	if *caFlag != "" {
+		for _, name := range strings.Split(*caFlag, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				issuers = append(issuers, caaDomainFor(name))
+			}
+		}
+	} else {
+		if certforge.IsSelfSigned(cert) {
+			return fmt.Errorf("Certificate is self-signed; name the CAs to allow with --ca")
+		}
+		domain := caaDomainForIssuer(cert)
+		if domain == "" {
+			return fmt.Errorf("Unknown CA %q; name its CAA domain with --ca", certforge.FormatName(cert.Issuer))
+		}
+		issuers = append(issuers, domain)
+	}
+
+	// Names covered by the certificate, and whether wildcards are needed
+	var names, wildcards []string
+	if *domainFlag != "" {
+		for _, name := range strings.Split(*domainFlag, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	} else {
+		names = append([]string{}, cert.DNSNames...)
+		if len(names) == 0 && cert.Subject.CommonName != "" {
+			names = []string{cert.Subject.CommonName}
+		}
+	}
+	for i, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if strings.HasPrefix(name, "*.") {
+			name = strings.TrimPrefix(name, "*.")
+			wildcards = append(wildcards, name)
+		}
+		names[i] = name
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("No domain names found; use --domain")
+	}
+
+	fmt.Println("=== Suggested CAA Records ===")
+	fmt.Println()
+	fmt.Printf("Allowed CAs: %s\n", strings.Join(issuers, ", "))
+
+	// CAA records apply to the name and everything below it
+	for _, owner := range caaOwners(names) {
+		iodef := *iodefFlag
+		if iodef == "" {
+			iodef = "mailto:security@" + owner
+		}
+		// Wildcard issuance is only allowed where a wildcard name needs it
+		wildcard := false
+		for _, name := range wildcards {
+			if name == owner || strings.HasSuffix(name, "."+owner) {
+				wildcard = true
+			}
+		}
+
+		fmt.Println()
+		for _, issuer := range issuers {
+			fmt.Printf("%s. IN CAA 0 issue \"%s\"\n", owner, issuer)
+		}
+		if wildcard {
+			for _, issuer := range issuers {
+				fmt.Printf("%s. IN CAA 0 issuewild \"%s\"\n", owner, issuer)
+			}
+		} else {
+			fmt.Printf("%s. IN CAA 0 issuewild \";\"\n", owner)
+		}
+		fmt.Printf("%s. IN CAA 0 iodef \"%s\"\n", owner, iodef)
+	}
+
+	fmt.Println()
+	fmt.Println("Note: records at a name also cover its subdomains; add records closer to a host only to override them.")
+	return nil
+}
+
+// caaDomainFor returns the CAA domain of a CA given by name, or the value itself when it is already a domain
+func caaDomainFor(name string) string {
+	lower := strings.ToLower(name)
+	for _, issuer := range caaIssuers {
+		if lower == issuer.CAADomain {
+			return issuer.CAADomain
+		}
+		for _, n := range issuer.Names {
+			if lower == n {
+				return issuer.CAADomain
+			}
+		}
+	}
+	return lower
+}
+
+// caaDomainForIssuer identifies the CA that issued cert, returning "" when it is not known
+func caaDomainForIssuer(cert *x509.Certificate) string {
+	issuer := strings.ToLower(strings.Join(append(cert.Issuer.Organization, cert.Issuer.CommonName), " "))
+	for _, ca := range caaIssuers {
+		for _, name := range ca.Names {
+			if strings.Contains(issuer, name) {
+				return ca.CAADomain
+			}
+		}
+	}
+	return ""
+}
+
+// caaOwners reduces names to the ones that are not below another name in the list
+func caaOwners(names []string) []string {
+	var owners []string
+	for _, name := range names {
+		covered := false
+		for _, other := range names {
+			if other != name && strings.HasSuffix(name, "."+other) {
+				covered = true
+				break
+			}
+		}
+		if !covered && !contains(owners, name) {
+			owners = append(owners, name)
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
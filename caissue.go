@@ -0,0 +1,105 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// runCAIssue implements ca issue, which signs a certificate for a public key without a CSR, for key holders such as
+// devices that can only export their public key
+func runCAIssue(args []string) error {
+	fs := flag.NewFlagSet("ca issue", flag.ExitOnError)
+	caFlag := fs.String("ca", "", "CA certificate that signs the certificate, followed by any chain")
+	caKeyFlag := fs.String("ca-key", "", "Private key of the CA, or a comma separated list of its shares (default: --ca with a .key extension)")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted CA key (pass:, env:, file:, or stdin)")
+	pubkeyFlag := fs.String("pubkey", "", "Public key to certify, in PEM or DER")
+	nameFlag := fs.String("name", "", "Comma separated names of the certificate; the first is also the common name")
+	daysFlag := fs.Int("days", 90, "Validity of the certificate in days")
+	ekuFlag := fs.String("eku", "", "Comma separated extended key usages, by name or OID (default: serverAuth)")
+	outFlag := fs.String("out", "", "File to write the certificate and its chain to (default: --pubkey with a .crt extension)")
+	parseArgs(fs, args)
+
+	if *caFlag == "" || *pubkeyFlag == "" || *nameFlag == "" {
+		return fmt.Errorf("ca issue requires --ca, --pubkey, and --name")
+	}
+	if *daysFlag < 1 {
+		return fmt.Errorf("Invalid --days %d", *daysFlag)
+	}
+	caKey := *caKeyFlag
+	if caKey == "" {
+		caKey = strings.TrimSuffix(*caFlag, ".crt") + ".key"
+	}
+	out := *outFlag
+	if out == "" {
+		out = strings.TrimSuffix(*pubkeyFlag, filepath.Ext(*pubkeyFlag)) + ".crt"
+	}
+	var names []string
+	for _, name := range strings.Split(*nameFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	var extensions []pkix.Extension
+	if *ekuFlag != "" {
+		ext, err := certforge.ExtKeyUsageExtension(strings.Split(*ekuFlag, ",")...)
+		if err != nil {
+			return err
+		}
+		extensions = append(extensions, ext)
+	}
+
+	data, err := os.ReadFile(*pubkeyFlag)
+	if err != nil {
+		return fmt.Errorf("Error reading file: %v", err)
+	}
+	pub, err := certforge.ParsePublicKey(data)
+	if err != nil {
+		return fmt.Errorf("%v in %s", err, *pubkeyFlag)
+	}
+	ca, err := loadLocalCA(&managedCA{Cert: *caFlag, Key: caKey, Passin: *passinFlag})
+	if err != nil {
+		return err
+	}
+	defer certforge.ZeroizeKey(ca.Key)
+
+	chain, err := ca.IssueServer(pub, names, time.Duration(*daysFlag)*24*time.Hour, extensions...)
+	if err != nil {
+		return err
+	}
+	var encoded []byte
+	for _, der := range chain {
+		encoded = append(encoded, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	if err := os.WriteFile(out, encoded, 0644); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", out, err)
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return err
+	}
+	entry := auditLogCertificate(auditLogCertSigned, cert)
+	entry.Inputs, entry.Outputs = auditLogInputs(*caFlag, *pubkeyFlag), []string{out}
+	auditLog(entry)
+
+	fmt.Printf("Wrote %s\n", out)
+	fmt.Printf("  Subject: %s\n", certforge.FormatName(cert.Subject))
+	fmt.Printf("  Names: %s\n", strings.Join(certforge.CertificateNames(cert), ", "))
+	fmt.Printf("  Public Key: %s\n", certforge.PublicKeyDescription(pub))
+	fmt.Printf("  Serial Number: %s\n", formatSerial(cert.SerialNumber))
+	fmt.Printf("  Valid Until: %s\n", cert.NotAfter.Format("2006-01-02"))
+	fmt.Printf("  Chain Certificates: %d\n", len(chain)-1)
+	return nil
+}
@@ -0,0 +1,182 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// caCommands are the subcommands of the ca command
+var caCommands = map[string]func(args []string) error{
+	"split-key": runCASplitKey,
+	"issue":     runCAIssue,
+}
+
+// runCA implements the ca command, which dispatches on the subcommand
+func runCA(args []string) error {
+	var names []string
+	for name := range caCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return fmt.Errorf("ca requires a subcommand: %s", strings.Join(names, ", "))
+	}
+	command, ok := caCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown ca subcommand %q (use %s)", args[0], strings.Join(names, ", "))
+	}
+	return command(args[1:])
+}
+
+// runCASplitKey implements ca split-key, which splits a CA key into shares so no single operator holds it
+func runCASplitKey(args []string) error {
+	fs := flag.NewFlagSet("ca split-key", flag.ExitOnError)
+	keyFlag := fs.String("key", "", "Private key of the CA to split")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted key (pass:, env:, file:, or stdin)")
+	sharesFlag := fs.Int("shares", 5, "Number of shares to write")
+	thresholdFlag := fs.Int("threshold", 3, "Number of shares needed to reassemble the key")
+	outFlag := fs.String("out", "", "Output file prefix for <out>-1.pem, <out>-2.pem, ... (default: --key without .key, followed by -share)")
+	parseArgs(fs, args)
+
+	if *keyFlag == "" {
+		return fmt.Errorf("ca split-key requires --key")
+	}
+	out := *outFlag
+	if out == "" {
+		out = strings.TrimSuffix(*keyFlag, ".key") + "-share"
+	}
+	var password string
+	var err error
+	if *passinFlag != "" {
+		if password, err = readPassphrase(*passinFlag); err != nil {
+			return err
+		}
+	}
+	key, err := readPrivateKey(*keyFlag, password)
+	if err != nil {
+		return err
+	}
+	defer certforge.ZeroizeKey(key)
+
+	shares, err := certforge.SplitKey(key, *sharesFlag, *thresholdFlag)
+	if err != nil {
+		return err
+	}
+	// Every path is checked first, so an existing share is never replaced by one of another split
+	paths := make([]string, len(shares))
+	for i := range shares {
+		paths[i] = fmt.Sprintf("%s-%d.pem", out, i+1)
+		if _, err := os.Stat(paths[i]); err == nil {
+			return fmt.Errorf("%s already exists; remove it or choose another --out", paths[i])
+		}
+	}
+	for i, share := range shares {
+		data := pem.EncodeToMemory(share)
+		err := os.WriteFile(paths[i], data, 0600)
+		certforge.Zeroize(data)
+		if err != nil {
+			return fmt.Errorf("Failed to write %s: %v", paths[i], err)
+		}
+	}
+
+	fmt.Printf("=== Split %s into %d shares ===\n", *keyFlag, len(shares))
+	for _, path := range paths {
+		fmt.Printf("  %s\n", path)
+	}
+	fmt.Printf("Any %d of the shares reassemble the key; fewer reveal nothing about it.\n", *thresholdFlag)
+	fmt.Println("Give each share to a different operator, check that the shares sign, then remove the key:")
+	fmt.Printf("  certforge shred %s\n", *keyFlag)
+	return nil
+}
+
+// readCAKey loads a CA's private key from a key file, or from a comma separated list of the share files of ca
+// split-key. Shares are reassembled once here to check them, then again for each signature, so the key is never held
+// in memory between signatures, even by the daemon and servers that sign for months.
+func readCAKey(spec, password string) (crypto.PrivateKey, error) {
+	paths := strings.Split(spec, ",")
+	if len(paths) == 1 && !isKeyShareFile(spec) {
+		return readPrivateKey(spec, password)
+	}
+
+	for i := range paths {
+		paths[i] = strings.TrimSpace(paths[i])
+	}
+	key, err := combineKeyShares(paths)
+	if err != nil {
+		return nil, err
+	}
+	defer certforge.ZeroizeKey(key)
+	return &shareKey{paths: paths, public: key.Public()}, nil
+}
+
+// shareKey is a CA key kept as the paths of its share files
+type shareKey struct {
+	paths  []string
+	public crypto.PublicKey
+}
+
+// Public returns the public half of the key
+func (k *shareKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign reassembles the key from its shares, signs with it, and wipes it
+func (k *shareKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	key, err := combineKeyShares(k.paths)
+	if err != nil {
+		return nil, err
+	}
+	defer certforge.ZeroizeKey(key)
+	if !certforge.SamePublicKey(key.Public(), k.public) {
+		return nil, fmt.Errorf("The CA key shares have changed since they were loaded")
+	}
+	return key.Sign(rand, digest, opts)
+}
+
+// combineKeyShares reads share files and reassembles the key they were split from
+func combineKeyShares(paths []string) (crypto.Signer, error) {
+	var shares []*pem.Block
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading file: %v", err)
+		}
+		block, _ := pem.Decode(data)
+		certforge.Zeroize(data)
+		if block == nil || block.Type != certforge.KeySharePEMType {
+			return nil, fmt.Errorf("%s is not a key share from ca split-key", path)
+		}
+		defer certforge.Zeroize(block.Bytes)
+		shares = append(shares, block)
+	}
+	key, err := certforge.CombineKey(shares)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to reassemble CA key: %v", err)
+	}
+	return key, nil
+}
+
+// isKeyShareFile reports whether a file holds a key share, so a single share gets a clear error rather than
+// "No private key found"
+func isKeyShareFile(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	defer certforge.Zeroize(data)
+	return bytes.Contains(data, []byte("-----BEGIN "+certforge.KeySharePEMType+"-----"))
+}
@@ -0,0 +1,111 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// writeKeyShares splits a new key into 3 shares with a threshold of 2 and returns the key and the share paths
+func writeKeyShares(t *testing.T, dir string) (crypto.Signer, []string) {
+	t.Helper()
+	key, err := certforge.GenerateKey(certforge.ECDSA, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := certforge.SplitKey(key, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for i, share := range shares {
+		path := filepath.Join(dir, fmt.Sprintf("share-%d.pem", i+1))
+		if err := os.WriteFile(path, pem.EncodeToMemory(share), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return key, paths
+}
+
+func TestReadCAKeyShares(t *testing.T) {
+	dir := t.TempDir()
+	key, paths := writeKeyShares(t, dir)
+	caKey, err := readCAKey(paths[0]+", "+paths[2], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The key is reassembled for each signature rather than kept
+	signer, ok := caKey.(*shareKey)
+	if !ok {
+		t.Fatalf("got a %T, want a key that reassembles its shares", caKey)
+	}
+	if !certforge.SamePublicKey(signer.Public(), key.Public()) {
+		t.Fatal("the shares reassemble a different key")
+	}
+	digest := sha256.Sum256([]byte("certforge"))
+	for i := 0; i < 2; i++ {
+		sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ecdsa.VerifyASN1(key.Public().(*ecdsa.PublicKey), digest[:], sig) {
+			t.Error("the signature does not verify")
+		}
+	}
+
+	// Shares replaced by those of another split are refused when signing
+	_, other := writeKeyShares(t, t.TempDir())
+	for i, path := range paths {
+		data, err := os.ReadFile(other[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("got error %v for shares of another key", err)
+	}
+	os.Remove(paths[0])
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err == nil {
+		t.Error("signed without a share file")
+	}
+}
+
+func TestReadCAKeySharesErrors(t *testing.T) {
+	_, paths := writeKeyShares(t, t.TempDir())
+	_, other := writeKeyShares(t, t.TempDir())
+	tests := []struct {
+		name string
+		spec string
+		want string
+	}{
+		{"below the threshold", paths[0], "the threshold is 2"},
+		{"duplicate share", paths[0] + "," + paths[0], "Duplicate"},
+		{"mixed splits", paths[0] + "," + other[1], "different splits"},
+		{"missing file", paths[0] + "," + paths[1] + "x", "Error reading file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readCAKey(tt.spec, "")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
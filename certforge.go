@@ -6,20 +6,27 @@ package main
 
 import (
 	"bufio"
-	"crypto/rand"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
-	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/asn1"
 	"encoding/pem"
 	"flag"
 	"fmt"
+	"io"
 	"math/big"
+	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
 )
 
 // version is set during build using -ldflags="-X main.version=v1.x.x"
@@ -35,83 +42,308 @@ func contains(slice []string, str string) bool {
 	return false
 }
 
+// decodeOptions holds the settings that control how files are decoded
+type decodeOptions struct {
+	// Password is used to open encrypted containers such as PKCS#12 files
+	Password string
+
+	// Text selects the full openssl-style output for certificates and CSRs
+	Text bool
+
+	// WarnDays is the window in which an approaching expiry is flagged
+	WarnDays int
+
+	// Chain holds the other certificates from the same input, used to find issuers
+	Chain []*x509.Certificate
+
+	// CTLogs maps base64 log IDs to known Certificate Transparency logs
+	CTLogs map[string]ctLog
+
+	// ToPEM also prints keys from formats such as JWK in PEM form
+	ToPEM bool
+
+	// KeyCheck looks for Debian weak keys and keys reused across certificates
+	KeyCheck *keyChecker
+
+	// Source is the file being decoded, recorded in the key database
+	Source string
+}
+
+// maxDecodeSize is the largest file decoded whole; larger PEM bundles are decoded block by block
+const maxDecodeSize = 16 << 20
+
 // decodeFile decodes and displays information about certificate, CSR, or key files
-func decodeFile(filePath string) error {
-// Read file
+func decodeFile(filePath string, opts decodeOptions) error {
+	if info, err := os.Stat(filePath); err == nil && info.Size() > maxDecodeSize {
+		return decodeStream(filePath, info.Size(), opts)
+	}
+
+	// Read file
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("Error reading file: %v", err)
 	}
-	
-	// Decode PEM
-	block, _ := pem.Decode(data)
-	if block == nil {
-		return fmt.Errorf("Failed to parse PEM block from file")
+	opts.Source = filePath
+
+	artifact, err := certforge.ParseAny(data)
+	if err == certforge.ErrUnrecognized {
+		if looksLikeSSHPublicKey(data) {
+			return decodeSSHPublicKeys(data, opts)
+		}
+		if looksLikeJSON(data) {
+			return decodeJWK(data, opts)
+		}
+		return decodeDER(data, opts)
 	}
-	
-	// Process based on block type
-	switch block.Type {
-	case "CERTIFICATE":
-		cert, err := x509.ParseCertificate(block.Bytes)
-		if err != nil {
-			return fmt.Errorf("Failed to parse certificate: %v", err)
+	if err != nil {
+		// A single PEM block the library does not parse, such as PKCS#7 or an OpenSSH key
+		block, _ := pem.Decode(data)
+		return decodeBlock(block, opts)
+	}
+	return printArtifact(artifact, opts)
+}
+
+// printArtifact displays parsed material in the format selected by the decode options
+func printArtifact(artifact *certforge.Artifact, opts decodeOptions) error {
+	switch artifact.Kind {
+	case certforge.KindCertificate:
+		printCertificate(artifact.Certificate, opts)
+	case certforge.KindCSR:
+		printCSR(artifact.CSR, opts)
+	case certforge.KindCRL:
+		printCRLInfo(artifact.CRL, opts)
+	case certforge.KindKey:
+		printKeyInfo(artifact.Key)
+	case certforge.KindBundle:
+		printBundle(artifact.Bundle, opts)
+	}
+	return nil
+}
+
+// printBundle displays every block of a PEM file with several, since bundles such as fullchain.pem hold more than one
+func printBundle(bundle *certforge.Bundle, opts decodeOptions) {
+	fmt.Printf("Found %d PEM blocks in %s\n\n", len(bundle.Blocks), opts.Source)
+
+	opts.Chain = nil
+	for _, cert := range bundle.Certificates {
+		opts.Chain = append(opts.Chain, cert.Certificate)
+	}
+
+	for i, block := range bundle.Blocks {
+		fmt.Printf("--- Block %d of %d (%s) ---\n\n", i+1, len(bundle.Blocks), block.Type)
+		var err error
+		if bundle.Artifacts[i] != nil {
+			err = printArtifact(bundle.Artifacts[i], opts)
+		} else {
+			err = decodeBlock(block, opts)
 		}
-		printCertificateInfo(cert)
-		
-	case "CERTIFICATE REQUEST":
-		csr, err := x509.ParseCertificateRequest(block.Bytes)
 		if err != nil {
-			return fmt.Errorf("Failed to parse CSR: %v", err)
+			fmt.Printf("Error: %v\n", err)
 		}
-		printCSRInfo(csr)
-		
-	case "RSA PRIVATE KEY":
-		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-		if err != nil {
-			return fmt.Errorf("Failed to parse RSA private key: %v", err)
+		fmt.Println()
+	}
+
+	if len(opts.Chain) > 1 {
+		printChainSummary(opts.Chain)
+	}
+}
+
+// decodeStream displays the blocks of a PEM bundle too large to read at once, such as a CT dump, holding one block
+// in memory at a time. Without the whole bundle at hand there is no chain summary.
+func decodeStream(filePath string, size int64, opts decodeOptions) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("Error reading file: %v", err)
+	}
+	defer file.Close()
+	opts.Source = filePath
+
+	reader := bufio.NewReader(file)
+	if !looksLikePEM(reader) {
+		return fmt.Errorf("%s is too large to decode (%d MB) unless it is a PEM bundle", filePath, size>>20)
+	}
+
+	fmt.Printf("Decoding PEM blocks of %s (%d MB) one at a time\n\n", filePath, size>>20)
+	scanner := certforge.NewPEMScanner(reader)
+	n := 0
+	for scanner.Scan() {
+		n++
+		block := scanner.Block()
+		fmt.Printf("--- Block %d (%s) ---\n\n", n, block.Type)
+		if err := decodeBlock(block, opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
 		}
-		printRSAKeyInfo(key)
-		
-	case "PRIVATE KEY":
-		// This might be a PKCS8 key
-		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		fmt.Println()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Failed to read %s after %d blocks: %v", filePath, n, err)
+	}
+	fmt.Printf("Decoded %d PEM blocks\n", n)
+	return nil
+}
+
+// looksLikePEM reports whether a PEM block begins within the first few kilobytes of r, without consuming them
+func looksLikePEM(r *bufio.Reader) bool {
+	head, _ := r.Peek(4096)
+	return bytes.Contains(head, []byte("-----BEGIN "))
+}
+
+// writeEncoded writes a block as PEM, or as its raw DER bytes when outform is "der"
+func writeEncoded(w io.Writer, block *pem.Block, outform string) error {
+	if outform == "der" {
+		_, err := w.Write(block.Bytes)
+		return err
+	}
+	return pem.Encode(w, block)
+}
+
+// decodeDER decodes the DER formats that certforge.ParseAny does not recognize
+func decodeDER(data []byte, opts decodeOptions) error {
+	if bundle, err := parsePKCS7(data); err == nil {
+		opts.Chain = bundle.Certificates
+		printPKCS7Info(bundle, opts)
+		return nil
+	}
+
+	// PKCS#12 is tried last since opening it requires the password
+	contents, err := decodePKCS12(data, opts.Password)
+	if err == nil {
+		for _, cert := range contents.Certs {
+			opts.Chain = append(opts.Chain, cert.Cert)
+		}
+		printPKCS12Info(contents, opts)
+		return nil
+	}
+	if err == errPKCS12Password {
+		return err
+	}
+	return fmt.Errorf("Failed to parse PEM block from file")
+}
+
+// decodeBlock decodes and displays information about a single PEM block
+func decodeBlock(block *pem.Block, opts decodeOptions) error {
+	// PKCS#7 bundles and OpenSSH keys are decoded here; the library parses the rest
+	switch block.Type {
+	case "PKCS7":
+		bundle, err := parsePKCS7(block.Bytes)
 		if err != nil {
-			return fmt.Errorf("Failed to parse private key: %v", err)
+			return err
 		}
-		if rsaKey, ok := key.(*rsa.PrivateKey); ok {
-			printRSAKeyInfo(rsaKey)
-		} else {
-			return fmt.Errorf("Unsupported private key type")
+		opts.Chain = bundle.Certificates
+		printPKCS7Info(bundle, opts)
+		return nil
+
+	case "OPENSSH PRIVATE KEY":
+		return decodeSSHPrivateKey(block, opts)
+	}
+
+	artifact, err := certforge.ParseBlock(block)
+	if err != nil {
+		return err
+	}
+	return printArtifact(artifact, opts)
+}
+
+// printChainSummary describes how the certificates of a bundle relate to each other
+func printChainSummary(certs []*x509.Certificate) {
+	fmt.Println("=== Chain Summary ===")
+	fmt.Println()
+
+	for i, cert := range certs {
+		fmt.Printf("[%d] %s\n", i+1, certforge.FormatName(cert.Subject))
+
+		switch {
+		case i+1 < len(certs) && cert.CheckSignatureFrom(certs[i+1]) == nil:
+			fmt.Printf("    Issued by [%d]\n", i+2)
+		case i+1 < len(certs) && cert.Issuer.String() == certs[i+1].Subject.String():
+			fmt.Printf("    Issuer matches [%d] but the signature does not verify\n", i+2)
+		case certforge.IsSelfSigned(cert):
+			fmt.Println("    Self-signed root")
+		case i+1 < len(certs):
+			fmt.Printf("    Not issued by [%d] (bundle is out of order or incomplete)\n", i+2)
+		default:
+			fmt.Printf("    Issued by %s (not included in bundle)\n", certforge.FormatName(cert.Issuer))
 		}
-		
-	default:
-		return fmt.Errorf("Unsupported PEM block type: %s", block.Type)
 	}
-	
-	return nil
+}
+
+// printCertificate displays a certificate in the format selected by the decode options
+func printCertificate(cert *certforge.Certificate, opts decodeOptions) {
+	if opts.Text {
+		printCertificateText(cert.Certificate)
+		return
+	}
+	printCertificateInfo(cert, opts)
+}
+
+// printCSR displays a CSR in the format selected by the decode options
+func printCSR(csr *certforge.CSR, opts decodeOptions) {
+	if opts.Text {
+		printCSRText(csr.CertificateRequest)
+		return
+	}
+	printCSRInfo(csr)
 }
 
 // printCertificateInfo displays information about an X.509 certificate
-func printCertificateInfo(cert *x509.Certificate) {
-	fmt.Println("=== Certificate Information ===\n")
-	fmt.Printf("Subject: %s\n", formatName(cert.Subject))
-	fmt.Printf("Issuer: %s\n", formatName(cert.Issuer))
-	fmt.Printf("Serial Number: %s\n", cert.SerialNumber)
+func printCertificateInfo(cert *certforge.Certificate, opts decodeOptions) {
+	fmt.Println("=== Certificate Information ===")
+	fmt.Println()
+	fmt.Printf("Subject: %s\n", certforge.FormatName(cert.Subject))
+	fmt.Printf("Issuer: %s\n", certforge.FormatName(cert.Issuer))
+	fmt.Printf("Serial Number: %s\n", formatSerial(cert.SerialNumber))
 	fmt.Printf("Not Before: %s\n", cert.NotBefore.Format(time.RFC3339))
 	fmt.Printf("Not After: %s\n", cert.NotAfter.Format(time.RFC3339))
-	fmt.Printf("Signature Algorithm: %s\n", cert.SignatureAlgorithm)
+	status, level := describeExpiry(cert.Certificate, time.Now(), opts.WarnDays)
+	fmt.Printf("Validity: %s\n", colorize(status, level))
+	fmt.Printf("Signature Algorithm: %s\n", describeSignatureAlgorithm(cert.SignatureAlgorithm, certforge.IsSelfSigned(cert.Certificate)))
+	printPublicKeyInfo(cert.PublicKeyInfo)
+	printKeyChecks(cert.Certificate, opts)
 	
-	// Display DNS names (Subject Alternative Names)
-	if len(cert.DNSNames) > 0 {
-		fmt.Println("\nSubject Alternative Names:")
-		for _, name := range cert.DNSNames {
-			fmt.Printf("  DNS: %s\n", name)
+	// Display Subject Alternative Names of every type
+	printSubjectAltNames(cert.SANs)
+
+	// Display where revocation and chain information is published
+	if len(cert.OCSPServer) > 0 || len(cert.IssuingCertificateURL) > 0 {
+		fmt.Println("\nAuthority Information Access:")
+		for _, url := range cert.OCSPServer {
+			fmt.Printf("  OCSP: %s\n", url)
+		}
+		for _, url := range cert.IssuingCertificateURL {
+			fmt.Printf("  CA Issuers: %s\n", url)
 		}
 	}
-	
-	// Check if self-signed
-	isSelfSigned := cert.Subject.String() == cert.Issuer.String()
-	fmt.Printf("\nSelf-signed: %t\n", isSelfSigned)
+	if certforge.HasMustStaple(cert.Extensions) {
+		fmt.Println("\nMust-Staple: yes (servers must staple a valid OCSP response, or clients that enforce it reject the certificate)")
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtCertPolicies) {
+			continue
+		}
+		if lines, err := certificatePolicies(ext.Value); err == nil && len(lines) > 0 {
+			fmt.Println("\nCertificate Policies:")
+			for _, line := range lines {
+				fmt.Printf("  %s\n", line)
+			}
+		}
+	}
+	if len(cert.CRLDistributionPoints) > 0 {
+		fmt.Println("\nCRL Distribution Points:")
+		for _, url := range cert.CRLDistributionPoints {
+			fmt.Printf("  %s\n", url)
+		}
+	}
+
+	// Check if self-signed by verifying the signature with the certificate's own key
+	namesMatch := bytes.Equal(cert.RawSubject, cert.RawIssuer)
+	switch {
+	case cert.SelfSigned:
+		fmt.Println("\nSelf-signed: true (signature verifies with its own public key)")
+	case namesMatch:
+		fmt.Println("\nSelf-signed: false (issuer matches subject, but the signature does not verify with its own key)")
+	default:
+		fmt.Println("\nSelf-signed: false")
+	}
 	
 	// Display key usage
 	fmt.Println("\nKey Usage:")
@@ -163,103 +395,165 @@ func printCertificateInfo(cert *x509.Certificate) {
 			}
 		}
 	}
+
+	printSCTs(cert.Certificate, opts)
+	printExtensionsSummary(cert.Extensions)
+}
+
+// describeSignatureAlgorithm returns the name of a signature algorithm, flagged when it relies on MD5 or SHA-1. Only
+// the signature of a self-signed root is not relied on, so it is not flagged.
+func describeSignatureAlgorithm(alg x509.SignatureAlgorithm, selfSigned bool) string {
+	if !certforge.IsWeakSignature(alg) || selfSigned {
+		return alg.String()
+	}
+	return alg.String() + " " + colorize("(INSECURE: MD5 and SHA-1 signatures can be forged)", expiryExpired)
 }
 
 // printCSRInfo displays information about a Certificate Signing Request
-func printCSRInfo(csr *x509.CertificateRequest) {
-	fmt.Println("=== Certificate Signing Request Information ===\n")
-	fmt.Printf("Subject: %s\n", formatName(csr.Subject))
-	fmt.Printf("Signature Algorithm: %s\n", csr.SignatureAlgorithm)
+func printCSRInfo(csr *certforge.CSR) {
+	fmt.Println("=== Certificate Signing Request Information ===")
+	fmt.Println()
+	fmt.Printf("Subject: %s\n", certforge.FormatName(csr.Subject))
+	fmt.Printf("Signature Algorithm: %s\n", describeSignatureAlgorithm(csr.SignatureAlgorithm, false))
+	printPublicKeyInfo(csr.PublicKeyInfo)
 	
-	// Extract DNS names from SANs extension
-	var dnsNames []string
-	
-	for _, ext := range csr.Extensions {
-		// OID for subjectAltName extension
-		if ext.Id.Equal([]int{2, 5, 29, 17}) {
-			var seq asn1.RawValue
-			if rest, err := asn1.Unmarshal(ext.Value, &seq); err == nil && len(rest) == 0 {
-				if seq.Class == asn1.ClassUniversal && seq.Tag == asn1.TagSequence {
-					var rawValues []asn1.RawValue
-					if rest, err := asn1.Unmarshal(seq.Bytes, &rawValues); err == nil && len(rest) == 0 {
-						for _, rv := range rawValues {
-							if rv.Class == 2 && rv.Tag == 2 { // DNS name
-								dnsNames = append(dnsNames, string(rv.Bytes))
-							}
-						}
-					}
-				}
-			}
+	// Display Subject Alternative Names of every type
+	printSubjectAltNames(csr.SANs)
+
+	if certforge.HasMustStaple(csr.Extensions) {
+		fmt.Println("\nMust-Staple: requested (the certificate will require a stapled OCSP response)")
+	}
+
+	printExtensionsSummary(csr.Extensions)
+
+	// Display signature validity
+	fmt.Printf("\nSignature Valid: %t\n", csr.SignatureError == nil)
+	if csr.SignatureError != nil {
+		fmt.Printf("Signature Error: %v\n", csr.SignatureError)
+	}
+}
+
+// printPublicKeyInfo displays the algorithm, size or curve, and fingerprint of a public key
+func printPublicKeyInfo(info certforge.PublicKeyInfo) {
+	fmt.Printf("Public Key Algorithm: %s\n", info.Algorithm)
+	switch key := info.PublicKey.(type) {
+	case *rsa.PublicKey:
+		fmt.Printf("  Key Size: %d bits\n", info.Bits)
+		fmt.Printf("  Exponent: %d\n", info.Exponent)
+	case *ecdsa.PublicKey:
+		fmt.Printf("  Curve: %s (%s)\n", info.Curve, curveOpenSSLName(key.Curve))
+		fmt.Printf("  Key Size: %d bits\n", info.Bits)
+	case ed25519.PublicKey:
+		fmt.Printf("  Key Size: %d bits\n", info.Bits)
+	}
+
+	if info.Fingerprint != "" {
+		fmt.Printf("  Fingerprint (SHA-256): %s\n", info.Fingerprint)
+	}
+}
+
+// warnCoveredNames warns about DNS names that a wildcard among them already covers, which are allowed but redundant
+func warnCoveredNames(names []string) {
+	covered := certforge.CoveredNames(names)
+	for _, name := range names {
+		if wildcard, ok := covered[name]; ok {
+			fmt.Printf("Warning: %s is already covered by %s; listing both is redundant\n", name, wildcard)
 		}
 	}
-	
-	// Display DNS names
-	if len(dnsNames) > 0 {
-		fmt.Println("\nSubject Alternative Names:")
-		for _, name := range dnsNames {
+}
+
+// printSubjectAltNames displays the DNS, IP, email, URI, and UPN Subject Alternative Names
+func printSubjectAltNames(sans certforge.SubjectAltNames) {
+	if len(sans.DNSNames)+len(sans.IPAddresses)+len(sans.EmailAddresses)+len(sans.URIs)+len(sans.UPNs) == 0 {
+		return
+	}
+
+	fmt.Println("\nSubject Alternative Names:")
+	for _, name := range sans.DNSNames {
+		if unicode := certforge.ToUnicode(name); unicode != name {
+			fmt.Printf("  DNS: %s (%s)\n", name, unicode)
+		} else {
 			fmt.Printf("  DNS: %s\n", name)
 		}
 	}
-	
-	// Display signature validity
-	err := csr.CheckSignature()
-	fmt.Printf("\nSignature Valid: %t\n", err == nil)
-	if err != nil {
-		fmt.Printf("Signature Error: %v\n", err)
+	for _, ip := range sans.IPAddresses {
+		fmt.Printf("  IP: %s\n", ip)
 	}
-}
-
-// printRSAKeyInfo displays information about an RSA private key
-func printRSAKeyInfo(key *rsa.PrivateKey) {
-	fmt.Println("=== RSA Private Key Information ===\n")
-	fmt.Printf("Key Size: %d bits\n", key.N.BitLen())
-	fmt.Printf("Public Exponent: %d\n", key.E)
-	
-	// Calculate fingerprint of public key
-	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
-	if err == nil {
-		fmt.Printf("Public Key Fingerprint (SHA-256): %x\n", sha256.Sum256(pubDER))
+	for _, email := range sans.EmailAddresses {
+		fmt.Printf("  Email: %s\n", email)
 	}
-	
-	// Validate key
-	if err := key.Validate(); err != nil {
-		fmt.Printf("\nKey Validation Error: %v\n", err)
-	} else {
-		fmt.Println("\nKey is valid")
+	for _, uri := range sans.URIs {
+		fmt.Printf("  URI: %s\n", uri)
+	}
+	for _, upn := range sans.UPNs {
+		fmt.Printf("  UPN: %s\n", upn)
 	}
 }
 
-// formatName converts a Distinguished Name to a readable string
-func formatName(name pkix.Name) string {
-	var parts []string
-	
-	if name.CommonName != "" {
-		parts = append(parts, fmt.Sprintf("CN=%s", name.CommonName))
+// printKeyInfo displays information about a private key
+func printKeyInfo(key *certforge.Key) {
+	info := key.PublicKeyInfo
+	fmt.Printf("=== %s Private Key Information ===\n", info.Algorithm)
+	fmt.Println()
+	if ecKey, ok := info.PublicKey.(*ecdsa.PublicKey); ok {
+		fmt.Printf("Curve: %s (%s)\n", info.Curve, curveOpenSSLName(ecKey.Curve))
 	}
-	
-	for _, org := range name.Organization {
-		parts = append(parts, fmt.Sprintf("O=%s", org))
+	fmt.Printf("Key Size: %d bits\n", info.Bits)
+	if info.Exponent != 0 {
+		fmt.Printf("Public Exponent: %d\n", info.Exponent)
 	}
-	
-	for _, ou := range name.OrganizationalUnit {
-		parts = append(parts, fmt.Sprintf("OU=%s", ou))
+	if info.Fingerprint != "" {
+		fmt.Printf("Public Key Fingerprint (SHA-256): %s\n", info.Fingerprint)
 	}
-	
-	for _, country := range name.Country {
-		parts = append(parts, fmt.Sprintf("C=%s", country))
+
+	// Only RSA keys carry values that can be inconsistent
+	if _, isRSA := key.PrivateKey.(*rsa.PrivateKey); !isRSA {
+		return
 	}
-	
-	for _, province := range name.Province {
-		parts = append(parts, fmt.Sprintf("ST=%s", province))
+	if key.ValidationError != nil {
+		fmt.Printf("\nKey Validation Error: %v\n", key.ValidationError)
+	} else {
+		fmt.Println("\nKey is valid")
 	}
-	
-	for _, locality := range name.Locality {
-		parts = append(parts, fmt.Sprintf("L=%s", locality))
+}
+
+// attributeNames maps distinguished name attribute OIDs to their short names
+var attributeNames = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.4":                    "SN",
+	"2.5.4.5":                    "serialNumber",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.9":                    "street",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"2.5.4.12":                   "title",
+	"2.5.4.17":                   "postalCode",
+	"2.5.4.42":                   "GN",
+	"2.5.4.43":                   "initials",
+	"2.5.4.46":                   "dnQualifier",
+	"2.5.4.65":                   "pseudonym",
+	"0.9.2342.19200300.100.1.1":  "UID",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+}
+
+// formatFullName converts a Distinguished Name to a string that includes every
+// attribute in the order it appears in the certificate
+func formatFullName(name pkix.Name) string {
+	var parts []string
+	for _, attr := range name.Names {
+		label, ok := attributeNames[attr.Type.String()]
+		if !ok {
+			label = attr.Type.String()
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", label, attr.Value))
 	}
-	
 	return strings.Join(parts, ", ")
 }
 
+
 // printHelp displays the usage information for CertForge
 func printHelp() {
 	fmt.Println("CertForge - TLS Certificate Generator")
@@ -271,23 +565,101 @@ func printHelp() {
 	
 	fmt.Println("\nUsage:")
 	fmt.Println("  certforge [options]")
-	fmt.Println("  certforge --decode <file>")
+	fmt.Println("  certforge --decode <file> [<file>...] [--template <file>]")
+	fmt.Println("  certforge csr --from-cert <file> --key <file> [--passin <src>] [--out <file>] [--outform der]")
+	fmt.Println("  certforge verify --cert <file> [--csr <file>] [--ca <file>] [--intermediates <file>] [--system-roots]")
+	fmt.Println("  certforge check-expiry --cert <file> [--warn 30d] [--crit 7d]")
+	fmt.Println("  certforge audit <directory> [--recursive] [--warn-days=<n>] [--notify <file>] [--template <file>]")
+	fmt.Println("  certforge inspect <host[:port]> [--save <directory>] [--servername <name>] [--tls-audit]")
+	fmt.Println("  certforge ct-search <domain> [--unexpired] [--issuer <text>] [--subdomains]")
+	fmt.Println("  certforge lint <file>...")
+	fmt.Println("  certforge export p12 --cert <file> --key <file> [--chain <file>] --out <file> --passout <src> [--legacy] [--allow-weak-pass]")
+	fmt.Println("  certforge export jks --cert <file> --key <file> [--chain <file>] --out <file> --passout <src> [--alias <name>] [--truststore <file>]")
+	fmt.Println("  certforge export p7b --cert <file> [--chain <file>] --out <file> [--outform der]")
+	fmt.Println("  certforge export jwk --key <file> [--cert <file>] [--public] [--set] [--out <file>]")
+	fmt.Println("  certforge export acm --cert <file> --key <file> [--chain <file>] [--out-dir <dir>] [--import [--region <name>] [--arn <arn>]]")
+	fmt.Println("  certforge export terraform [--cert <file>] [--key <file>] [--chain <file>] [--csr <file>] [--name tls] [--format tfvars|json] [--out-dir <dir>]")
+	fmt.Println("  certforge export ansible-vault --key <file> [--cert <file>] --vault-password-file <file> --out <file> [--vars] [--vault-id <label>]")
+	fmt.Println("  certforge export env [--cert <file>] [--key <file>] [--chain <file>] [--prefix TLS] [--out <file>]")
+	fmt.Println("  certforge export <format> --cert <file> [--key <file>] [--chain <file>] [--out <file>] [-- <plugin args>]")
+	fmt.Println("  certforge convert --in <file> --out <file> [--outform <fmt>] [--passin <src>] [--passout <src>]")
+	fmt.Println("  certforge dane --cert <file> [--usage 3] [--selector 1] [--mtype 1] [--port 443] [--host <name>]")
+	fmt.Println("  certforge caa --cert <file> | --ca <list> --domain <list> [--iodef <url>]")
+	fmt.Println("  certforge spiffe ca --trust-domain <name> [--days 365] [--out <prefix>]")
+	fmt.Println("  certforge spiffe svid --id spiffe://<trust-domain>/<path> --ca <file> --ca-key <file> [--ttl 1h] [--dns <list>] [--out <prefix>]")
+	fmt.Println("  certforge nss add|remove --cert <file> [--name <nickname>] [--db <list>]")
+	fmt.Println("  certforge acme --domain <list> --email <addr> --agree-tos [--staging] [--webroot <dir> | --challenge tls-alpn-01] [--key-type rsa|ecdsa|tpm] [-o <dir>] [--layout certbot] [--pre-hook <cmd>] [--post-hook <cmd>] [--must-staple]")
+	fmt.Println("  certforge acme account register|show|rotate-key|deactivate [--email <list>] [--eab-kid <id> --eab-hmac-key <key>] [--staging]")
+	fmt.Println("  certforge daemon --watch <renewals.yaml> [--metrics <addr>] [--metrics-files <list>] [--parallel <n>] [--shred-old]")
+	fmt.Println("  certforge renew-all [--config <renewals.yaml>] [--min-remaining 30d] [--verbose] [--parallel <n>] [--shred-old]")
+	fmt.Println("  certforge ca issue --ca <ca.crt> [--ca-key <file>] --pubkey <file> --name <list> [--days 90] [--eku <list>] [--out <file>]")
+	fmt.Println("  certforge bulk --domains <file> --ca <ca.crt> [--ca-key <file>] [--profile server|client|mtls] [--days 90] [--key-type rsa|ecdsa] [--out-dir <dir>] [--force]")
+	fmt.Println("  certforge ca split-key --key <file> [--shares 5] [--threshold 3] [--out <prefix>]")
+	fmt.Println("  certforge shred [--passes 3] [--force] <file>...")
+	fmt.Println("  certforge tpm keygen [--type ecdsa|rsa] [--out <file>]")
+	fmt.Println("  certforge systemd [--config <renewals.yaml>] [--out-dir <dir>] [--on-calendar <spec>] [--user <name>]")
+	fmt.Println("  certforge metrics [--listen :9101] [--config <renewals.yaml>] [--files <list>]")
+	fmt.Println("  certforge scep cacert --url <url> [--out <file>]")
+	fmt.Println("  certforge scep enroll --url <url> --cn <name> [--domain <list>] --challenge-password <src> [--ca-fingerprint <hex>] [--key <file>] [-o <dir>]")
+	fmt.Println("  certforge acme-server --root <ca.crt> [--root-key <file>] [--listen :14000] [--http] [--days 90] [--http-port 80] [--skip-validation] [--insecure-allow]")
+	fmt.Println("  certforge serve --ca <ca.crt> --clients <clients.yaml> [--data <dir>] [--listen :8443] [--days 90] [--max-days 397] [--tls-cert <file> --tls-key <file>] [--events <file>] [--ct-log <urls>] [--sequential-serials] [--insecure-allow]")
+	fmt.Println("  certforge step-ca root|provisioners --ca-url <url> --root <file> | --fingerprint <hex> [--out <file>]")
+	fmt.Println("  certforge step-ca certificate --ca-url <url> --root <file> [--provisioner <name>] [--provisioner-password <src> | --token <src>] [--domain <list>] [--not-after 24h] [-o <dir>]")
+	fmt.Println("  certforge k8s csr --signer <name> [--node <name> | --cn <name> --org <list>] [--domain <list>] [--approve] [--kubeconfig <file>] [--context <name>] [-o <dir>]")
+	fmt.Println("  certforge audit-log show [--file <file>] [--since 7d] [--operation <name>] [--serial <hex>] [--name <text>] [--user <name>] [--json] [--verbose]")
+	fmt.Println("  certforge ssh keygen [--type ed25519|ecdsa|rsa] [--bits <n>] [--out <file>] [--comment <text>] [--passout <src>]")
+	fmt.Println("  certforge ssh sign --ca <key> --key <file.pub> --principals <list> [--host] [--validity 52w] [--force-command <cmd>] [--source-address <list>]")
 	
 	fmt.Println("\nOptions:")
 	fmt.Println("  -h, --help      Show this help message and exit")
 	fmt.Println("  -v, --version   Show version information")
 	fmt.Println("  -s              Create a self-signed certificate instead of just CSR")
 	fmt.Println("  -days=<number>  Validity period in days for self-signed certificates (default: 365)")
+	fmt.Println("  --copy-from=<file> Start from the subject, SANs, key usages, and basic constraints of an existing certificate")
+	fmt.Println("  --san-zonefile=<file> Offer the A, AAAA, and CNAME names of a BIND zone file as SANs")
+	fmt.Println("  --non-interactive Never prompt; also the case when standard input is not a terminal")
+	fmt.Println("  --cn, --org, --ou, --country, --state, --locality, --email, --key-size, --prefix, --san=<a,b>")
+	fmt.Println("                  Answer these prompts ahead of time (or set CERTFORGE_CN, CERTFORGE_ORG, ...)")
+	fmt.Println("  --serial=<hex>  Serial number of the self-signed certificate (default: random 128-bit)")
+	fmt.Println("  --email-dn      Also put the email address in the subject as the legacy emailAddress attribute")
+	fmt.Println("  --serial-number, --street, --postal-code, --title, --given-name, --surname")
+	fmt.Println("                  Add these attributes to the subject")
+	fmt.Println("  --subject-oid=<oid=value,...> Add subject attributes by OID, such as 1.3.6.1.4.1.99999.1=DeviceID-1234")
+	fmt.Println("  --is-ca         Write CA:TRUE in basic constraints, making the self-signed certificate a CA (default: CA:FALSE)")
+	fmt.Println("  --basic-constraints-critical=<bool> Mark basic constraints critical (default: true)")
+	fmt.Println("  --eku=<list>    Extended key usages, by name (serverAuth, clientAuth, codeSigning, emailProtection, timeStamping, ...) or OID (default: serverAuth)")
+	fmt.Println("  --must-staple   Add the TLS Feature extension requiring OCSP stapling to the CSR and certificate")
+	fmt.Println("  --ext=<oid:[critical:]value,...> Add extensions to the CSR and certificate, with DER values in hex or base64")
 	fmt.Println("  -o=<directory>  Output directory for generated files (default: current directory)")
-	fmt.Println("  --decode <file> Decode and display information about a certificate, CSR, or key file")
+	fmt.Println("  --outform=<fmt> Encoding of generated key, CSR, and certificate: pem or der (default: pem)")
+	fmt.Println("  --snippets=<list> Print nginx, apache, haproxy, and/or caddy configuration for the generated files (or all)")
+	fmt.Println("  --decode <file> Decode and display information about a certificate, CSR, key, CRL, PKCS#7, PKCS#12, SSH key/certificate, or JWK/JWKS file")
+	fmt.Println("  --text          With --decode, print the full structure like `openssl x509 -text`")
+	fmt.Println("  --warn-days=<n> With --decode, flag certificates expiring within n days (default: 30)")
+	fmt.Println("  --ct-logs <file> With --decode, CT log list used to name logs and verify embedded SCTs")
+	fmt.Println("  --to-pem        With --decode, also print JWK keys as PEM")
+	fmt.Println("  --details       With several files or a glob, print full details after the summary table")
+	fmt.Println("  --weak-keys <path> Debian weak key blocklist file or directory (default: /usr/share/openssl-blacklist)")
+	fmt.Println("  --key-db <file> Record public keys in this database and report keys reused across certificates")
+	fmt.Println("  --passin <src>  Passphrase for encrypted input: pass:<pw>, env:<var>, file:<path>, or stdin")
+	fmt.Println("  --template <file> With --decode, print each file through this Go text/template")
+	fmt.Println("  --fips          With any command, allow only FIPS-approved keys and algorithms (or set CERTFORGE_FIPS=1)")
+	fmt.Println("  Key sizes, validity, and algorithms are also limited by /etc/certforge/policy.yaml when it exists")
 	
 	fmt.Println("\nFeatures:")
 	fmt.Println("  - RSA private key generation with customizable key size")
 	fmt.Println("  - Certificate Signing Request (CSR) creation")
 	fmt.Println("  - Self-signed certificate generation")
-	fmt.Println("  - Subject Alternative Names (SANs) support")
+	fmt.Println("  - Subject Alternative Names (SANs): DNS names, IP addresses, emails, URIs, and UPNs (prefix with uri: or upn:)")
+	fmt.Println("  - Wildcard name validation, with warnings for names a wildcard already covers")
+	fmt.Println("  - Internationalized domain names, encoded as punycode")
+	fmt.Println("  - Subject serialNumber, street, postalCode, title, givenName, and surname attributes")
 	fmt.Println("  - Interactive prompts for all required certificate fields")
 	fmt.Println("  - Decoding of certificate, CSR, and key files")
+	fmt.Println("  - Decoding of multi-certificate PEM bundles with chain summary")
+	fmt.Println("  - Decoding of PKCS#7 (.p7b/.p7c) bundles in PEM or DER form")
+	fmt.Println("  - Decoding of PKCS#12 (.p12/.pfx) containers")
+	fmt.Println("  - Decoding of Certificate Revocation Lists (CRLs) in PEM or DER form")
 	
 	fmt.Println("\nOutput Files:")
 	fmt.Println("  - <prefix>.key  Private key file")
@@ -307,6 +679,12 @@ func printHelp() {
 	fmt.Println("  # Generate a self-signed certificate in a specific directory")
 	fmt.Println("  certforge -s -o=/path/to/certs")
 	
+	fmt.Println("  # Write the key, CSR, and certificate in binary DER form")
+	fmt.Println("  certforge -s --outform der")
+
+	fmt.Println("  # Generate a self-signed certificate and print nginx and HAProxy configuration for it")
+	fmt.Println("  certforge -s --snippets=nginx,haproxy")
+
 	fmt.Println("  # Decode and display information about a certificate")
 	fmt.Println("  certforge --decode cert.crt")
 	
@@ -315,7 +693,114 @@ func printHelp() {
 	
 	fmt.Println("  # Decode and display information about a private key")
 	fmt.Println("  certforge --decode cert.key")
+
+	fmt.Println("  # Decode a password protected PKCS#12 bundle")
+	fmt.Println("  certforge --decode bundle.p12 --passin env:P12_PASSWORD")
+
+	fmt.Println("  # Print a CSV row for each certificate through a template")
+	fmt.Println("  certforge --decode \"certs/*.crt\" --template row.tmpl")
 	
+	fmt.Println("  # Bundle a certificate, key, and chain for import into IIS or Java")
+	fmt.Println("  certforge export p12 --cert cert.crt --key cert.key --chain chain.pem --out cert.p12 --passout generate:cert.p12.pass")
+
+	fmt.Println("  # Write the files AWS Certificate Manager imports and import them")
+	fmt.Println("  certforge export acm --cert cert.crt --key cert.key --chain chain.pem --import --region us-east-1")
+
+	fmt.Println("  # Write a certificate and key as Terraform variables")
+	fmt.Println("  certforge export terraform --cert cert.crt --key cert.key --out-dir infra/")
+
+	fmt.Println("  # Encrypt a key into an Ansible vars file")
+	fmt.Println("  certforge export ansible-vault --key cert.key --vault-password-file ~/.vault_pass --vars --out tls.yml")
+
+	fmt.Println("  # Append a certificate and key to a .env file as base64 variables")
+	fmt.Println("  certforge export env --cert cert.crt --key cert.key >> .env")
+
+	fmt.Println("  # Export with a certforge-export-k8s-secret plugin on PATH")
+	fmt.Println("  certforge export k8s-secret --cert cert.crt --key cert.key --out secret.yaml -- web-tls")
+
+	fmt.Println("  # Convert a PKCS#12 bundle to PEM")
+	fmt.Println("  certforge convert --in bundle.p12 --passin pass:secret --out bundle.pem")
+
+	fmt.Println("  # Issue a one hour SPIFFE SVID for a workload")
+	fmt.Println("  certforge spiffe svid --id spiffe://example.org/web --ca ca.crt --ca-key ca.key")
+
+	fmt.Println("  # Trust a development CA in Chrome and Firefox")
+	fmt.Println("  certforge nss add --cert dev-ca.crt")
+
+	fmt.Println("  # Obtain a Let's Encrypt staging certificate, answering HTTP-01 challenges on port 80")
+	fmt.Println("  certforge acme --domain example.com,www.example.com --email ops@example.com --agree-tos --staging")
+
+	fmt.Println("  # Register an ACME account with ZeroSSL using External Account Binding")
+	fmt.Println("  certforge acme account register --directory https://acme.zerossl.com/v2/DV90 --email ops@example.com --agree-tos --eab-kid <kid> --eab-hmac-key <key>")
+
+	fmt.Println("  # Reload nginx once a new certificate is written")
+	fmt.Println("  certforge acme --domain example.com --email ops@example.com --agree-tos --webroot /var/www/html --post-hook \"systemctl reload nginx\"")
+
+	fmt.Println("  # Write certbot's live/ and archive/ files, for servers configured for certbot")
+	fmt.Println("  certforge acme --domain example.com --email ops@example.com --agree-tos --webroot /var/www/html -o /etc/letsencrypt --layout certbot")
+
+	fmt.Println("  # Keep the certificates listed in renewals.yaml renewed")
+	fmt.Println("  certforge daemon --watch /etc/certforge/renewals.yaml")
+
+	fmt.Println("  # Audit certificates and send those expiring to Slack")
+	fmt.Println("  certforge audit /etc/ssl --recursive --notify notify.yaml")
+
+	fmt.Println("  # Renew due certificates from cron, silently when nothing is due")
+	fmt.Println("  certforge renew-all --config /etc/certforge/renewals.yaml --min-remaining 30d")
+
+	fmt.Println("  # Renew with FIPS-approved algorithms only, using the Go FIPS 140-3 module")
+	fmt.Println("  GODEBUG=fips140=on certforge --fips renew-all --config /etc/certforge/renewals.yaml")
+
+	fmt.Println("  # Issue the local CA certificates of a large lab config eight at a time")
+	fmt.Println("  certforge renew-all --config lab-renewals.yaml --parallel 8")
+
+	fmt.Println("  # Renew with new keys and shred the key files they replace")
+	fmt.Println("  certforge renew-all --config /etc/certforge/renewals.yaml --shred-old")
+
+	fmt.Println("  # Overwrite and remove a private key that is no longer used")
+	fmt.Println("  certforge shred old-server.key")
+
+	fmt.Println("  # Split a root key so any 3 of 5 operators are needed to sign with it")
+	fmt.Println("  certforge ca split-key --key root.key --shares 5 --threshold 3")
+	fmt.Println("  certforge spiffe svid --id spiffe://example.org/web --ca root.crt --ca-key root-share-1.pem,root-share-3.pem,root-share-4.pem")
+
+	fmt.Println("  # Keep a machine's key in its TPM and request a certificate for it")
+	fmt.Println("  certforge tpm keygen --out host.key")
+	fmt.Println("  certforge acme --key host.key --domain host1.example.com --email ops@example.com --agree-tos")
+
+	fmt.Println("  # Install a systemd timer that runs renew-all twice a day")
+	fmt.Println("  certforge systemd --config /etc/certforge/renewals.yaml --out-dir /etc/systemd/system")
+
+	fmt.Println("  # Export certificate expiry for Prometheus")
+	fmt.Println("  certforge metrics --listen :9101 --files /etc/ssl/private,/etc/haproxy/certs")
+
+	fmt.Println("  # Enroll a device with Microsoft NDES using its one-time challenge password")
+	fmt.Println("  certforge scep enroll --url http://ndes.example.com/certsrv/mscep/mscep.dll --cn printer-7 --challenge-password env:NDES_PASSWORD --ca-fingerprint <sha1>")
+
+	fmt.Println("  # Test ACME automation against a local CA instead of Let's Encrypt staging")
+	fmt.Println("  certforge acme-server --root ca.crt --http-port 5002")
+
+	fmt.Println("  # Run an internal CA service issuing certificates over a REST API")
+	fmt.Println("  certforge serve --ca ca.crt --clients clients.yaml --data /var/lib/certforge-ca")
+
+	fmt.Println("  # Request a certificate from step-ca through a JWK provisioner")
+	fmt.Println("  certforge step-ca certificate --ca-url https://ca.internal:9000 --root root_ca.crt --provisioner admin --provisioner-password env:STEP_PASSWORD --domain api.internal")
+
+	fmt.Println("  # Show the certificates revoked in the last week from the audit log")
+	fmt.Println("  certforge audit-log show --operation certificate_revoked --since 7d")
+
+	fmt.Println("  # Generate a passphrase protected Ed25519 SSH key pair")
+	fmt.Println("  certforge ssh keygen --out id_ed25519 --passout stdin")
+
+	fmt.Println("  # Protect a key with a random passphrase, printed once")
+	fmt.Println("  certforge ssh keygen --out deploy_key --passout generate")
+
+	fmt.Println("  # Issue a 12 hour SSH user certificate from an SSH CA key")
+	fmt.Println("  certforge ssh sign --ca ssh_ca --key id_ed25519.pub --principals alice --validity 12h")
+
+	fmt.Println("  # Print every field and extension of a certificate")
+	fmt.Println("  certforge --decode cert.crt --text")
+
 	fmt.Println("  # Check the details of a generated certificate using OpenSSL")
 	fmt.Println("  openssl x509 -in cert.crt -text -noout")
 	
@@ -323,7 +808,96 @@ func printHelp() {
 	fmt.Println("  openssl req -in cert.csr -text -noout")
 }
 
+// commands maps subcommand names to their implementations. Each parses its own flags.
+var commands = map[string]func(args []string) error{
+	"verify":       runVerify,
+	"csr":          runCSR,
+	"check-expiry": runCheckExpiry,
+	"audit":        runAudit,
+	"inspect":      runInspect,
+	"ct-search":    runCTSearch,
+	"lint":         runLint,
+	"export":       runExport,
+	"convert":      runConvert,
+	"dane":         runDANE,
+	"caa":          runCAA,
+	"ssh":          runSSH,
+	"spiffe":       runSPIFFE,
+	"nss":          runNSS,
+	"daemon":       runDaemon,
+	"metrics":      runMetrics,
+	"renew-all":    runRenewAll,
+	"systemd":      runSystemd,
+	"scep":         runSCEP,
+	"acme":         runACME,
+	"acme-server":  runACMEServer,
+	"serve":        runServe,
+	"audit-log":    runAuditLog,
+	"step-ca":      runStepCA,
+	"shred":        runShred,
+	"ca":           runCA,
+	"bulk":         runBulk,
+	"k8s":          runK8s,
+	"tpm":          runTPM,
+}
+
+// commandContext returns a context that is cancelled when the command is interrupted with Ctrl-C or SIGTERM, and
+// after timeout unless it is zero, so network operations stop instead of running on
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout == 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// parseArgs parses flags that may appear before or after positional arguments,
+// since the flag package stops at the first positional one
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	positional, _ := parseArgsError(fs, args)
+	return positional
+}
+
+// parseArgsError is parseArgs for flag sets that continue on error, returning the first error, or flag.ErrHelp
+// for -h
+func parseArgsError(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return positional, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
 func main() {
+	// --fips applies to every command, so it is taken out before any flags are parsed
+	os.Args = append(os.Args[:1], enableFIPS(os.Args[1:])...)
+	if err := enforcePolicy(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Dispatch subcommands before the top-level flags are parsed
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// Define command-line flags
 	helpFlag := flag.Bool("help", false, "Show help information")
 	shortHelpFlag := flag.Bool("h", false, "Show help information")
@@ -331,11 +905,42 @@ func main() {
 	shortVersionFlag := flag.Bool("v", false, "Show version information")
 	selfSignedFlag := flag.Bool("s", false, "Create a self-signed certificate instead of just CSR")
 	daysFlag := flag.Int("days", 365, "Validity period in days for self-signed certificates")
+	copyFromFlag := flag.String("copy-from", "", "Existing certificate whose subject, SANs, key usages, and basic constraints the new one starts from")
+	sanZonefileFlag := flag.String("san-zonefile", "", "BIND zone file whose A, AAAA, and CNAME names are offered as SANs")
+	nonInteractiveFlag := flag.Bool("non-interactive", false, "Never prompt: take the certificate details from flags and CERTFORGE_ variables, and defaults for the rest")
+	var inputs generateInputs
+	inputs.register(flag.CommandLine)
+	serialFlag := flag.String("serial", "", "Serial number of the self-signed certificate in hex (default: random 128-bit)")
+	emailDNFlag := flag.Bool("email-dn", false, "Also put the email address in the subject as the legacy emailAddress attribute")
+	var attrs subjectAttributes
+	flag.StringVar(&attrs.SerialNumber, "serial-number", "", "Subject serialNumber attribute, such as a registration or device number")
+	flag.StringVar(&attrs.Street, "street", "", "Subject streetAddress attribute")
+	flag.StringVar(&attrs.PostalCode, "postal-code", "", "Subject postalCode attribute")
+	flag.StringVar(&attrs.Title, "title", "", "Subject title attribute")
+	flag.StringVar(&attrs.GivenName, "given-name", "", "Subject givenName attribute")
+	flag.StringVar(&attrs.Surname, "surname", "", "Subject surname attribute")
+	isCAFlag := flag.Bool("is-ca", false, "Write CA:TRUE in basic constraints, making the self-signed certificate a CA, instead of CA:FALSE")
+	bcCriticalFlag := flag.Bool("basic-constraints-critical", true, "Mark the basic constraints extension critical")
+	ekuFlag := flag.String("eku", "", "Comma-separated extended key usages, by name (serverAuth, clientAuth, codeSigning, ...) or OID, instead of serverAuth")
+	mustStapleFlag := flag.Bool("must-staple", false, "Add the TLS Feature extension requiring OCSP stapling (Must-Staple) to the CSR and certificate")
+	extFlag := flag.String("ext", "", "Comma-separated extensions to add to the CSR and certificate, as oid:[critical:]value with the DER value in hex or base64")
+	subjectOIDFlag := flag.String("subject-oid", "", "Comma-separated subject attributes by OID, such as 1.3.6.1.4.1.99999.1=DeviceID-1234")
 	outputDirFlag := flag.String("o", "", "Output directory for generated files (default: current directory)")
+	outformFlag := flag.String("outform", "pem", "Encoding of generated files: pem or der")
+	snippetsFlag := flag.String("snippets", "", "Print configuration snippets for these servers: nginx, apache, haproxy, caddy, or all")
 	decodeFlag := flag.String("decode", "", "Decode and display information about a certificate, CSR, or key file")
+	warnDaysFlag := flag.Int("warn-days", 30, "Flag certificates expiring within this many days when decoding")
+	textFlag := flag.Bool("text", false, "Print the full certificate or CSR structure when decoding")
+	ctLogsFlag := flag.String("ct-logs", "", "CT log list (log_list.json) used to name logs and verify embedded SCTs")
+	toPEMFlag := flag.Bool("to-pem", false, "Also print JWK keys as PEM when decoding")
+	weakKeysFlag := flag.String("weak-keys", "", "Debian weak key blocklist file or directory (default: "+defaultWeakKeyDir+" if installed)")
+	detailsFlag := flag.Bool("details", false, "Print full details after the summary table when decoding several files")
+	keyDBFlag := flag.String("key-db", "", "Database of seen public keys used to detect key reuse across certificates")
+	passinFlag := flag.String("passin", "", "Passphrase source for encrypted input files (pass:, env:, file:, or stdin)")
+	templateFlag := flag.String("template", "", "Print decoded files through this text/template instead of the usual display")
 	
-	// Parse command-line flags
-	flag.Parse()
+	// Parse command-line flags, keeping any extra files given to --decode
+	positional := parseArgs(flag.CommandLine, os.Args[1:])
 	
 	// Show help if requested
 	if *helpFlag || *shortHelpFlag {
@@ -346,12 +951,89 @@ func main() {
 	// Show version if requested
 	if *versionFlag || *shortVersionFlag {
 		fmt.Printf("CertForge %s\n", version)
+		if certforge.FIPS() {
+			module := fipsModule()
+			if module == "" {
+				module = "none; run with GODEBUG=fips140=on for a validated module"
+			}
+			fmt.Printf("FIPS mode: on, cryptographic module: %s\n", module)
+		}
+		if certforge.ActivePolicy() != nil {
+			fmt.Printf("Policy: %s\n", policyPath)
+		}
 		return
 	}
+
+	outform := strings.ToLower(*outformFlag)
+	if outform != "pem" && outform != "der" {
+		fmt.Printf("Error: Invalid --outform %q (use pem or der)\n", *outformFlag)
+		os.Exit(1)
+	}
+	snippetTargets, err := parseSnippetServers(*snippetsFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(snippetTargets) > 0 && outform == "der" {
+		fmt.Println("Error: Web servers need PEM files; --snippets cannot be combined with --outform der")
+		os.Exit(1)
+	}
 	
 	// Handle decode mode
+	if *decodeFlag != "" && *templateFlag != "" {
+		tmpl, err := loadTemplate(*templateFlag)
+		if err == nil {
+			var files []string
+			if files, err = expandDecodeArgs(append([]string{*decodeFlag}, positional...)); err == nil {
+				err = decodeTemplate(tmpl, files)
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if *decodeFlag != "" {
-		if err := decodeFile(*decodeFlag); err != nil {
+		opts := decodeOptions{Text: *textFlag, WarnDays: *warnDaysFlag, ToPEM: *toPEMFlag}
+		if *ctLogsFlag != "" {
+			logs, err := loadCTLogs(*ctLogsFlag)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.CTLogs = logs
+		}
+		if *passinFlag != "" {
+			password, err := readPassphrase(*passinFlag)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.Password = password
+		}
+		checker, err := newKeyChecker(*weakKeysFlag, *keyDBFlag)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.KeyCheck = checker
+
+		// Several files or a glob pattern are shown as a summary table
+		args := append([]string{*decodeFlag}, positional...)
+		if len(args) == 1 && !strings.ContainsAny(*decodeFlag, "*?[") {
+			err = decodeFile(*decodeFlag, opts)
+		} else {
+			var files []string
+			if files, err = expandDecodeArgs(args); err == nil {
+				err = decodeFiles(files, opts, *detailsFlag)
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := checker.save(); err != nil {
 			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -361,73 +1043,134 @@ func main() {
 	fmt.Println("CertForge - TLS Certificate Generator")
 	fmt.Println("----------------------------------")
 
+	// Custom subject attributes and extensions are checked before asking for anything
+	subjectOIDOpts, err := parseSubjectOIDs(*subjectOIDFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	customExtensions, err := parseExtensionList(*extFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	var copied *copySource
+	if *copyFromFlag != "" {
+		if copied, err = readCopySource(*copyFromFlag); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Copying %s from %s; press Enter to keep each copied value\n", certforge.FormatName(copied.cert.Subject), *copyFromFlag)
+		copied.attributes(&attrs)
+		// Basic constraints follow the copied certificate unless --is-ca is given
+		isCASet := false
+		flag.Visit(func(f *flag.Flag) { isCASet = isCASet || f.Name == "is-ca" })
+		if !isCASet {
+			*isCAFlag = copied.cert.IsCA
+		}
+	}
+	var zoneNames []string
+	if *sanZonefileFlag != "" {
+		if zoneNames, err = readZoneNames(*sanZonefileFlag); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	inputs.fromEnv()
+	givenKeySize, err := inputs.keySize(0)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Without a terminal, prompts would read empty answers, so the common name at least must be given
+	interactive := !*nonInteractiveFlag && stdinIsTerminal()
+	if !interactive && inputs.CommonName == "" && (copied == nil || copied.cert.Subject.CommonName == "") {
+		if *nonInteractiveFlag {
+			fmt.Println("Error: --non-interactive needs the common name: give --cn or set CERTFORGE_CN")
+		} else {
+			fmt.Println("Error: Standard input is not a terminal, so certforge cannot ask for the certificate details; give at least the common name with --cn or CERTFORGE_CN, or run it in a terminal (such as docker run -it)")
+		}
+		os.Exit(1)
+	}
+	var serial *big.Int
+	if *serialFlag != "" {
+		if serial, err = certforge.ParseSerial(*serialFlag); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var extKeyUsages []string
+	if *ekuFlag != "" {
+		for _, usage := range strings.Split(*ekuFlag, ",") {
+			if _, err := certforge.ParseExtKeyUsage(usage); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			extKeyUsages = append(extKeyUsages, usage)
+		}
+	}
+
 	// Get user input for CSR details
 	reader := bufio.NewReader(os.Stdin)
+	p := &prompter{reader: reader, interactive: interactive}
+
+	// The subject of a copied certificate is offered as the default of each prompt
+	var copiedSubject pkix.Name
+	if copied != nil {
+		copiedSubject = copied.cert.Subject
+	}
 
 	// Common Name (CN) - typically the domain name
-	fmt.Print("Common Name (domain name, e.g. example.com): ")
-	commonName, _ := reader.ReadString('\n')
-	commonName = strings.TrimSpace(commonName)
+	commonName := p.value("Common Name (domain name, e.g. example.com)", inputs.CommonName, copiedSubject.CommonName)
 
 	// Organization
-	fmt.Print("Organization (e.g. Company Inc): ")
-	organization, _ := reader.ReadString('\n')
-	organization = strings.TrimSpace(organization)
+	organization := p.value("Organization (e.g. Company Inc)", inputs.Organization, first(copiedSubject.Organization))
 
 	// Organizational Unit
-	fmt.Print("Organizational Unit (e.g. IT Department): ")
-	organizationalUnit, _ := reader.ReadString('\n')
-	organizationalUnit = strings.TrimSpace(organizationalUnit)
+	organizationalUnit := p.value("Organizational Unit (e.g. IT Department)", inputs.OrganizationalUnit, first(copiedSubject.OrganizationalUnit))
 
 	// Country
-	fmt.Print("Country (2 letter code, e.g. US): ")
-	country, _ := reader.ReadString('\n')
-	country = strings.TrimSpace(country)
+	country := p.value("Country (2 letter code, e.g. US)", inputs.Country, first(copiedSubject.Country))
 
 	// State/Province
-	fmt.Print("State/Province (e.g. California): ")
-	state, _ := reader.ReadString('\n')
-	state = strings.TrimSpace(state)
+	state := p.value("State/Province (e.g. California)", inputs.State, first(copiedSubject.Province))
 
 	// Locality/City
-	fmt.Print("Locality/City (e.g. San Francisco): ")
-	locality, _ := reader.ReadString('\n')
-	locality = strings.TrimSpace(locality)
+	locality := p.value("Locality/City (e.g. San Francisco)", inputs.Locality, first(copiedSubject.Locality))
 
 	// Email
-	fmt.Print("Email Address: ")
-	emailAddress, _ := reader.ReadString('\n')
-	emailAddress = strings.TrimSpace(emailAddress)
+	emailAddress := p.value("Email Address", inputs.Email, copied.email())
 
 	// Key size
-	fmt.Print("RSA Key Size (2048, 3072, or 4096) [default: 2048]: ")
-	keySizeStr, _ := reader.ReadString('\n')
-	keySizeStr = strings.TrimSpace(keySizeStr)
-	keySize := 2048 // default value
-	if keySizeStr != "" {
-		fmt.Sscanf(keySizeStr, "%d", &keySize)
-		// Validate key size
-		validSizes := map[int]bool{2048: true, 3072: true, 4096: true}
-		if !validSizes[keySize] {
-			fmt.Println("Invalid key size. Using default: 2048")
-			keySize = 2048
+	defaultKeySize := copied.keySize(2048)
+	keySize := defaultKeySize
+	if givenKeySize != 0 {
+		keySize = givenKeySize
+	} else if interactive {
+		fmt.Printf("RSA Key Size (2048, 3072, or 4096) [default: %d]: ", defaultKeySize)
+		keySizeStr, _ := reader.ReadString('\n')
+		keySizeStr = strings.TrimSpace(keySizeStr)
+		if keySizeStr != "" {
+			fmt.Sscanf(keySizeStr, "%d", &keySize)
+			// Validate key size
+			validSizes := map[int]bool{2048: true, 3072: true, 4096: true}
+			if !validSizes[keySize] {
+				fmt.Printf("Invalid key size. Using default: %d\n", defaultKeySize)
+				keySize = defaultKeySize
+			}
 		}
 	}
 
 	// Output file prefix
-	fmt.Print("Output file prefix [default: cert]: ")
-	filePrefix, _ := reader.ReadString('\n')
-	filePrefix = strings.TrimSpace(filePrefix)
-	if filePrefix == "" {
-		filePrefix = "cert"
-	}
+	filePrefix := p.value("Output file prefix", inputs.Prefix, "cert")
 	
 	// Get self-signed preference from command line or ask user
 	createSelfsigned := *selfSignedFlag
 	validDays := *daysFlag
 	
 	// If not specified via command line flag, ask the user
-	if !*selfSignedFlag {
+	if !*selfSignedFlag && interactive {
 		fmt.Print("\nDo you want to create a self-signed certificate? [y/N]: ")
 		selfSigned, _ := reader.ReadString('\n')
 		selfSigned = strings.TrimSpace(strings.ToLower(selfSigned))
@@ -449,75 +1192,134 @@ func main() {
 	}
 
 	// Get domain name alternatives
-	fmt.Println("\nDo you want to add Subject Alternative Names (SANs)? [y/N]: ")
-	addSANs, _ := reader.ReadString('\n')
-	addSANs = strings.TrimSpace(strings.ToLower(addSANs))
-	
-	var sans []string
-	if addSANs == "y" || addSANs == "yes" {
-		fmt.Println("Enter Subject Alternative Names (one per line, blank line to finish):")
-		for {
-			san, _ := reader.ReadString('\n')
-			san = strings.TrimSpace(san)
-			if san == "" {
-				break
+	sans := copied.names()
+	if len(sans) > 0 {
+		fmt.Printf("\nCopied Subject Alternative Names: %s\n", strings.Join(sans, ", "))
+	}
+	if *sanZonefileFlag != "" {
+		for _, name := range selectZoneNames(p, *sanZonefileFlag, zoneNames) {
+			if !contains(sans, name) {
+				sans = append(sans, name)
 			}
-			sans = append(sans, san)
 		}
 	}
+	for _, name := range inputs.sans() {
+		if !contains(sans, name) {
+			sans = append(sans, name)
+		}
+	}
+	if interactive {
+		if len(sans) > 0 {
+			fmt.Println("Do you want to add more Subject Alternative Names (SANs)? [y/N]: ")
+		} else {
+			fmt.Println("\nDo you want to add Subject Alternative Names (SANs)? [y/N]: ")
+		}
+		addSANs, _ := reader.ReadString('\n')
+		addSANs = strings.TrimSpace(strings.ToLower(addSANs))
 
-	// Generate private key
-	fmt.Printf("\nGenerating RSA private key (%d bits)...\n", keySize)
-	privateKey, err := rsa.GenerateKey(rand.Reader, keySize)
-	if err != nil {
-		fmt.Printf("Error generating private key: %v\n", err)
-		os.Exit(1)
+		if addSANs == "y" || addSANs == "yes" {
+			fmt.Println("Enter Subject Alternative Names (one per line, blank line to finish):")
+			for {
+				san, _ := reader.ReadString('\n')
+				san = strings.TrimSpace(san)
+				if san == "" {
+					break
+				}
+				sans = append(sans, san)
+			}
+		}
+	}
+
+	// Further subject attributes are asked for only when none was given as a flag
+	if attrs.empty() && interactive {
+		fmt.Println("\nDo you want to add more subject attributes (serial number, street, postal code, title, name)? [y/N]: ")
+		addAttrs, _ := reader.ReadString('\n')
+		addAttrs = strings.TrimSpace(strings.ToLower(addAttrs))
+		if addAttrs == "y" || addAttrs == "yes" {
+			attrs.prompt(reader)
+		}
 	}
 
 	// Create CSR template
 	subj := pkix.Name{
 		CommonName:         commonName,
-		Organization:       []string{organization},
-		OrganizationalUnit: []string{organizationalUnit},
-		Country:            []string{country},
-		Province:           []string{state},
-		Locality:           []string{locality},
+		Organization:       subjectValues(organization, copiedSubject.Organization),
+		OrganizationalUnit: subjectValues(organizationalUnit, copiedSubject.OrganizationalUnit),
+		Country:            subjectValues(country, copiedSubject.Country),
+		Province:           subjectValues(state, copiedSubject.Province),
+		Locality:           subjectValues(locality, copiedSubject.Locality),
 	}
+	attrs.apply(&subj)
 
-	// Create CSR template with SAN if provided
-	template := &x509.CertificateRequest{
-		Subject:            subj,
-		SignatureAlgorithm: x509.SHA256WithRSA,
+	// An internationalized domain name is kept in the common name in the same ASCII form as in its SAN
+	if strings.Contains(commonName, ".") && !strings.ContainsAny(commonName, "@/ ") {
+		if ascii, err := certforge.ToASCII(commonName); err == nil && ascii != strings.ToLower(commonName) {
+			fmt.Printf("Using %s for the internationalized domain name %s\n", ascii, commonName)
+			commonName = ascii
+			subj.CommonName = ascii
+		}
 	}
 
-	// Add SANs if provided
-	if len(sans) > 0 {
-		sanExtension := pkix.Extension{}
-		sanExtension.Id = []int{2, 5, 29, 17} // SubjectAltName OID
+	// If common name looks like a domain name or is an IP address, add it to the SANs as well
+	names := sans
+	if !contains(names, commonName) && (strings.Contains(commonName, ".") || net.ParseIP(commonName) != nil) {
+		names = append([]string{commonName}, names...)
+	}
 
-		// Create a new extension value to hold all DNS names
-		var rawValues []asn1.RawValue
-		for _, san := range sans {
-			rawValues = append(rawValues, asn1.RawValue{Tag: 2, Class: 2, Bytes: []byte(san)})
+	opts := []certforge.Option{
+		certforge.WithSubject(subj),
+		certforge.WithNames(names...),
+		certforge.WithKeyType(certforge.RSA, keySize),
+		certforge.WithValidity(time.Duration(validDays)*24*time.Hour),
+	}
+	// The email address is a SAN, and only in the subject as well when asked for
+	if emailAddress != "" {
+		if !contains(names, emailAddress) {
+			opts = append(opts, certforge.WithEmail(emailAddress))
 		}
-
-		sequence, err := asn1.Marshal(rawValues)
-		if err != nil {
-			fmt.Printf("Error encoding SANs: %v\n", err)
-			os.Exit(1)
+		if *emailDNFlag {
+			opts = append(opts, certforge.WithEmailInSubject(emailAddress))
 		}
-
-		sanExtension.Value = sequence
-		template.ExtraExtensions = []pkix.Extension{sanExtension}
-		fmt.Printf("Added %d Subject Alternative Names to the CSR\n", len(sans))
 	}
+	opts = append(opts, copied.subjectOptions(*emailDNFlag)...)
+	opts = append(opts, subjectOIDOpts...)
+	opts = append(opts, certforge.WithExtension(customExtensions...))
+	opts = append(opts, certforge.WithExtension(copied.extensions(customExtensions, len(extKeyUsages) > 0)...))
+	if serial != nil {
+		opts = append(opts, certforge.WithSerial(serial))
+	}
+	if len(extKeyUsages) > 0 {
+		opts = append(opts, certforge.WithExtKeyUsage(extKeyUsages...))
+	}
+	if *mustStapleFlag {
+		opts = append(opts, certforge.WithMustStaple())
+	}
+	// Basic constraints are always written explicitly, also to the CSR, for validators that reject leaves without them
+	opts = append(opts, certforge.WithBasicConstraints(*isCAFlag, *bcCriticalFlag))
+	request, err := certforge.NewRequest(opts...)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	warnCoveredNames(request.SANs.DNSNames)
 
-	// Create CSR
-	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, template, privateKey)
+	// Generate private key, CSR, and self-signed certificate if requested
+	fmt.Printf("\nGenerating RSA private key (%d bits)...\n", keySize)
+	var artifacts *certforge.Artifacts
+	if createSelfsigned {
+		artifacts, err = request.SelfSign()
+	} else {
+		artifacts, err = request.CSR()
+	}
 	if err != nil {
-		fmt.Printf("Error creating CSR: %v\n", err)
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
+	if len(sans) > 0 {
+		fmt.Printf("Added %d Subject Alternative Names to the CSR\n", len(sans))
+	}
+	privateKey := artifacts.Key
+	csrBytes := artifacts.CSR
 
 	// Create output directory if specified and doesn't exist
 	outputDir := *outputDirFlag
@@ -540,8 +1342,8 @@ func main() {
 		crtPath = filepath.Join(outputDir, crtPath)
 	}
 	
-	// Save private key to file
-	keyFile, err := os.Create(keyPath)
+	// Save private key to file, readable only by its owner
+	keyFile, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		fmt.Printf("Error creating key file: %v\n", err)
 		os.Exit(1)
@@ -549,14 +1351,14 @@ func main() {
 	defer keyFile.Close()
 
 	// Encode private key to PEM format
-	keyPEM := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	}
-	if err := pem.Encode(keyFile, keyPEM); err != nil {
+	keyPEM, _ := pem.Decode(artifacts.KeyPEM)
+	if err := writeEncoded(keyFile, keyPEM, outform); err != nil {
 		fmt.Printf("Error encoding private key: %v\n", err)
 		os.Exit(1)
 	}
+	// The CSR and any certificate are signed already, so the key is wiped from memory once it is on disk
+	certforge.Zeroize(keyPEM.Bytes)
+	artifacts.ZeroizeKey()
 
 	// Save CSR to file
 	csrFile, err := os.Create(csrPath)
@@ -571,56 +1373,23 @@ func main() {
 		Type:  "CERTIFICATE REQUEST",
 		Bytes: csrBytes,
 	}
-	if err := pem.Encode(csrFile, csrPEM); err != nil {
+	if err := writeEncoded(csrFile, csrPEM, outform); err != nil {
 		fmt.Printf("Error encoding CSR: %v\n", err)
 		os.Exit(1)
 	}
 
+	auditLog(auditLogKey(privateKey.Public(), keyPath))
+	auditLog(auditLogCSR(csrBytes, csrPath))
+
 	fmt.Println("\nSuccess!")
 	fmt.Printf("Private key saved to: %s\n", keyPath)
 	fmt.Printf("CSR saved to: %s\n", csrPath)
 	
 	// Generate self-signed certificate if requested
 	if createSelfsigned {
-		// Create a self-signed certificate template
-		notBefore := time.Now()
-		notAfter := notBefore.Add(time.Duration(validDays) * 24 * time.Hour)
-		
-		serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-		serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
-		if err != nil {
-			fmt.Printf("Failed to generate serial number: %v\n", err)
-			os.Exit(1)
-		}
-		
-		certTemplate := x509.Certificate{
-			SerialNumber:          serialNumber,
-			Subject:               subj,
-			NotBefore:             notBefore,
-			NotAfter:              notAfter,
-			KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-			BasicConstraintsValid: true,
-		}
-		
-		// Add DNS names if SANs were provided
-		if len(sans) > 0 {
-			certTemplate.DNSNames = sans
-		}
-		
-		// If common name looks like a domain name, add it to DNS names as well
-		if !contains(certTemplate.DNSNames, commonName) && strings.Contains(commonName, ".") {
-			certTemplate.DNSNames = append(certTemplate.DNSNames, commonName)
-		}
-		
-		// Create the certificate
-		derBytes, err := x509.CreateCertificate(
-			rand.Reader, &certTemplate, &certTemplate, &privateKey.PublicKey, privateKey)
-		if err != nil {
-			fmt.Printf("Failed to create certificate: %v\n", err)
-			os.Exit(1)
-		}
-		
+		derBytes := artifacts.Certificate
+		notAfter := time.Now().Add(request.Validity)
+
 		// Save the certificate to file
 		certFile, err := os.Create(crtPath)
 		if err != nil {
@@ -634,17 +1403,49 @@ func main() {
 			Type:  "CERTIFICATE",
 			Bytes: derBytes,
 		}
-		if err := pem.Encode(certFile, certPEM); err != nil {
+		if err := writeEncoded(certFile, certPEM, outform); err != nil {
 			fmt.Printf("Failed to encode certificate: %v\n", err)
 			os.Exit(1)
 		}
 		
+		if cert, err := x509.ParseCertificate(derBytes); err == nil {
+			entry := auditLogCertificate(auditLogCertSigned, cert)
+			entry.Outputs = []string{crtPath}
+			auditLog(entry)
+		}
+
 		fmt.Printf("Self-signed certificate saved to: %s\n", crtPath)
 		fmt.Printf("Certificate is valid for %d days (until %s)\n", 
 			validDays, notAfter.Format("2006-01-02"))
 	} else {
 		fmt.Println("\nYou can now submit the CSR file to your Certificate Authority.")
 	}
+
+	// Print web server configuration referring to the generated files
+	if len(snippetTargets) > 0 {
+		combinedPath := strings.TrimSuffix(crtPath, ".crt") + ".pem"
+		if contains(snippetTargets, "haproxy") {
+			if createSelfsigned {
+				if err := writeCombinedPEM(combinedPath, crtPath, keyPath); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Printf("Combined certificate and key for HAProxy saved to: %s\n", combinedPath)
+			} else {
+				fmt.Printf("Once issued, save the certificate as %s and combine it for HAProxy with:\n", crtPath)
+				fmt.Printf("  cat %s %s > %s\n", crtPath, keyPath, combinedPath)
+			}
+		}
+
+		names := []string{commonName}
+		for _, san := range sans {
+			if !contains(names, san) {
+				names = append(names, san)
+			}
+		}
+		printServerSnippets(snippetTargets, names, snippetPaths{Cert: crtPath, Key: keyPath, Combined: combinedPath})
+		fmt.Println()
+	}
 	
 	fmt.Println("Keep your private key file secure and do not share it with anyone.")
 }
@@ -0,0 +1,129 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// Exit codes used by check-expiry, following the Nagios plugin convention
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+// checkStatusNames maps check-expiry exit codes to their Nagios status names
+var checkStatusNames = map[int]string{
+	checkOK:       "OK",
+	checkWarning:  "WARNING",
+	checkCritical: "CRITICAL",
+	checkUnknown:  "UNKNOWN",
+}
+
+// runCheckExpiry implements the check-expiry command. It exits with the Nagios status code itself,
+// so that failures to read the certificate report UNKNOWN rather than WARNING.
+func runCheckExpiry(args []string) error {
+	// The flag package exits with 2, CRITICAL to Nagios, on a bad flag and with 0, OK, for -h, so errors are
+	// reported as UNKNOWN here instead
+	fs := flag.NewFlagSet("check-expiry", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	certFlag := fs.String("cert", "", "Certificate to check")
+	warnFlag := fs.String("warn", "30d", "Remaining validity below which the status is WARNING")
+	critFlag := fs.String("crit", "7d", "Remaining validity below which the status is CRITICAL")
+	if _, err := parseArgsError(fs, args); err != nil {
+		fmt.Printf("%s - %v\n", checkStatusNames[checkUnknown], err)
+		os.Exit(checkUnknown)
+	}
+
+	status, message := checkExpiry(*certFlag, *warnFlag, *critFlag, time.Now())
+	fmt.Printf("%s - %s\n", checkStatusNames[status], message)
+	if status != checkOK {
+		os.Exit(status)
+	}
+	return nil
+}
+
+// checkExpiry evaluates every certificate in path and returns the worst status with a summary
+func checkExpiry(path, warn, crit string, now time.Time) (int, string) {
+	if path == "" {
+		return checkUnknown, "check-expiry requires --cert"
+	}
+	warnAfter, err := parseThreshold(warn)
+	if err != nil {
+		return checkUnknown, fmt.Sprintf("invalid --warn: %v", err)
+	}
+	critAfter, err := parseThreshold(crit)
+	if err != nil {
+		return checkUnknown, fmt.Sprintf("invalid --crit: %v", err)
+	}
+	if critAfter > warnAfter {
+		return checkUnknown, "--crit must not be longer than --warn"
+	}
+
+	certs, err := readCertificates(path)
+	if err != nil {
+		return checkUnknown, err.Error()
+	}
+
+	// The certificate that expires first decides the status
+	var soonest *x509.Certificate
+	for _, cert := range certs {
+		if soonest == nil || cert.NotAfter.Before(soonest.NotAfter) {
+			soonest = cert
+		}
+	}
+
+	remaining := soonest.NotAfter.Sub(now)
+	subject := certforge.FormatName(soonest.Subject)
+	expires := soonest.NotAfter.UTC().Format(time.RFC3339)
+	switch {
+	case remaining <= 0:
+		return checkCritical, fmt.Sprintf("%s EXPIRED %d days ago (%s)", subject, -certforge.DaysUntil(soonest.NotAfter, now), expires)
+	case now.Before(soonest.NotBefore):
+		return checkCritical, fmt.Sprintf("%s is not valid until %s", subject, soonest.NotBefore.UTC().Format(time.RFC3339))
+	case remaining < critAfter:
+		return checkCritical, fmt.Sprintf("%s expires in %d days (%s)", subject, certforge.DaysUntil(soonest.NotAfter, now), expires)
+	case remaining < warnAfter:
+		return checkWarning, fmt.Sprintf("%s expires in %d days (%s)", subject, certforge.DaysUntil(soonest.NotAfter, now), expires)
+	}
+	return checkOK, fmt.Sprintf("%s expires in %d days (%s)", subject, certforge.DaysUntil(soonest.NotAfter, now), expires)
+}
+
+// parseThreshold parses a duration such as "30d", "2w", or "12h". A bare number means days.
+func parseThreshold(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+
+	unit := 24 * time.Hour
+	number := value
+	switch value[len(value)-1] {
+	case 'd':
+		number = value[:len(value)-1]
+	case 'w':
+		unit = 7 * 24 * time.Hour
+		number = value[:len(value)-1]
+	case 'h':
+		unit = time.Hour
+		number = value[:len(value)-1]
+	}
+
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a duration like 30d, 2w, or 12h", value)
+	}
+	return time.Duration(n) * unit, nil
+}
@@ -0,0 +1,60 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestCheckExpiryProcess runs check-expiry with the arguments after -- when started by TestCheckExpiryExitCodes
+func TestCheckExpiryProcess(t *testing.T) {
+	if os.Getenv("CERTFORGE_TEST_CHECK_EXPIRY") != "1" {
+		t.Skip("run by TestCheckExpiryExitCodes")
+	}
+	for i, arg := range os.Args {
+		if arg == "--" {
+			runCheckExpiry(os.Args[i+1:])
+			os.Exit(checkOK)
+		}
+	}
+	os.Exit(100)
+}
+
+func TestCheckExpiryExitCodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		output string
+	}{
+		{"valid certificate", []string{"--cert", "testdata/pkcs12/cert.pem", "--warn", "10d", "--crit", "1d"}, checkOK, "OK - "},
+		{"warning", []string{"--cert", "testdata/pkcs12/cert.pem", "--warn", "5000d", "--crit", "1d"}, checkWarning, "WARNING - "},
+		{"missing file", []string{"--cert", "testdata/missing.pem"}, checkUnknown, "UNKNOWN - "},
+		{"unknown flag", []string{"--bogus"}, checkUnknown, "UNKNOWN - flag provided but not defined"},
+		{"flag without value", []string{"--warn"}, checkUnknown, "UNKNOWN - flag needs an argument"},
+		{"help", []string{"-h"}, checkUnknown, "UNKNOWN - "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestCheckExpiryProcess$", "--"}, tt.args...)...)
+			cmd.Env = append(os.Environ(), "CERTFORGE_TEST_CHECK_EXPIRY=1")
+			out, err := cmd.Output()
+			status := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				status = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.status || !strings.HasPrefix(string(out), tt.output) {
+				t.Errorf("exited with %d and printed %q, want %d and %q", status, out, tt.status, tt.output)
+			}
+		})
+	}
+}
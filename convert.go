@@ -0,0 +1,300 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// convertBundle is the private key and certificates read from any supported input format
+type convertBundle struct {
+	Format string
+	Key    crypto.PrivateKey
+	Certs  []*x509.Certificate
+	Alias  string
+}
+
+// convertOutformExtensions maps output file extensions to the format written
+var convertOutformExtensions = map[string]string{
+	".pem": "pem",
+	".crt": "pem",
+	".key": "pem",
+	".der": "der",
+	".cer": "der",
+	".p7b": "p7b",
+	".p7c": "p7b",
+	".p12": "p12",
+	".pfx": "p12",
+	".jks": "jks",
+}
+
+// runConvert implements the convert command
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	inFlag := fs.String("in", "", "Input file (PEM, DER, PKCS#7, PKCS#12, or JKS)")
+	outFlag := fs.String("out", "", "Output file")
+	outformFlag := fs.String("outform", "", "Output format: pem, der, p7b, p12, or jks (default: from the --out extension)")
+	passinFlag := fs.String("passin", "", "Passphrase source for the input (pass:, env:, file:, or stdin)")
+	passoutFlag := fs.String("passout", "", "Passphrase source for a PKCS#12 or JKS output (pass:, env:, file:, stdin, generate, or generate:<file>)")
+	allowWeakFlag := fs.Bool("allow-weak-pass", false, "Accept a --passout passphrase that is easy to guess")
+	noKeysFlag := fs.Bool("nokeys", false, "Do not output the private key")
+	noCertsFlag := fs.Bool("nocerts", false, "Do not output certificates")
+	aliasFlag := fs.String("alias", "", "Alias of the JKS key entry or PKCS#12 friendly name (default: from the input)")
+	legacyFlag := fs.Bool("legacy", false, "Use 3DES, RC2, and SHA-1 for a PKCS#12 output")
+	parseArgs(fs, args)
+
+	if *inFlag == "" || *outFlag == "" {
+		return fmt.Errorf("convert requires --in and --out")
+	}
+	outform := strings.ToLower(*outformFlag)
+	if outform == "" {
+		outform = convertOutformExtensions[strings.ToLower(filepath.Ext(*outFlag))]
+		if outform == "" {
+			return fmt.Errorf("Cannot tell the output format from %s (use --outform)", *outFlag)
+		}
+	}
+	if outform == "jks" {
+		if err := certforge.CheckAlgorithms("JKS keystores, whose integrity check uses SHA-1 (use --outform p12)", "sha1"); err != nil {
+			return err
+		}
+	}
+	if outform == "p12" && *legacyFlag {
+		if err := certforge.CheckAlgorithms("--legacy PKCS#12 files, encrypted with 3DES and RC2 and checked with SHA-1", "3des", "rc2", "sha1"); err != nil {
+			return err
+		}
+	}
+
+	var password string
+	if *passinFlag != "" {
+		var err error
+		if password, err = readPassphrase(*passinFlag); err != nil {
+			return err
+		}
+	}
+	data, err := os.ReadFile(*inFlag)
+	if err != nil {
+		return fmt.Errorf("Error reading file: %v", err)
+	}
+	bundle, err := readConvertInput(data, password)
+	if err != nil {
+		return err
+	}
+
+	// Certificate bundles cannot hold a private key
+	if *noKeysFlag || outform == "p7b" {
+		bundle.Key = nil
+	}
+	if *noCertsFlag {
+		bundle.Certs = nil
+	}
+	if bundle.Key == nil && len(bundle.Certs) == 0 {
+		return fmt.Errorf("Nothing to convert in %s", *inFlag)
+	}
+	if *aliasFlag != "" {
+		bundle.Alias = *aliasFlag
+	}
+
+	var passout string
+	if outform == "p12" || outform == "jks" {
+		if *passoutFlag == "" {
+			return fmt.Errorf("A %s output requires --passout", outform)
+		}
+		if passout, err = readNewPassphrase(*passoutFlag, *allowWeakFlag); err != nil {
+			return err
+		}
+	}
+
+	out, err := writeConvertOutput(bundle, outform, passout, *legacyFlag)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if bundle.Key != nil {
+		mode = 0600
+	}
+	if err := os.WriteFile(*outFlag, out, mode); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+	}
+
+	fmt.Printf("Converted %s (%s) to %s (%s)\n", *inFlag, bundle.Format, *outFlag, outform)
+	if bundle.Key != nil {
+		fmt.Printf("  Private Key: %s\n", certforge.PrivateKeyDescription(bundle.Key))
+	}
+	for i, cert := range bundle.Certs {
+		fmt.Printf("  Certificate %d: %s\n", i+1, certforge.FormatName(cert.Subject))
+	}
+	return nil
+}
+
+// readConvertInput detects the format of data and extracts its private key and certificates
+func readConvertInput(data []byte, password string) (*convertBundle, error) {
+	bundle := &convertBundle{}
+
+	switch {
+	case isJKS(data):
+		bundle.Format = "jks"
+		entries, err := decodeJKS(data, password)
+		if err != nil {
+			return nil, err
+		}
+		// The first key entry and its chain are converted, or every trusted certificate
+		for _, entry := range entries {
+			if entry.Key != nil {
+				bundle.Key, bundle.Certs, bundle.Alias = entry.Key, entry.Chain, entry.Alias
+				break
+			}
+			bundle.Certs = append(bundle.Certs, entry.Chain...)
+		}
+
+	case bytes.Contains(data, []byte("-----BEGIN ")):
+		bundle.Format = "pem"
+		for rest := data; ; {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			switch block.Type {
+			case "CERTIFICATE":
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, fmt.Errorf("Failed to parse certificate: %v", err)
+				}
+				bundle.Certs = append(bundle.Certs, cert)
+			case "PKCS7":
+				p7, err := parsePKCS7(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				bundle.Certs = append(bundle.Certs, p7.Certificates...)
+			}
+		}
+		key, err := parsePrivateKeyData(data, password)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Key = key
+
+	default:
+		if p7, err := parsePKCS7(data); err == nil {
+			bundle.Format = "p7b"
+			bundle.Certs = p7.Certificates
+		} else if certs, err := x509.ParseCertificates(data); err == nil {
+			bundle.Format = "der"
+			bundle.Certs = certs
+		} else if key, _ := parsePrivateKeyData(data, password); key != nil {
+			bundle.Format = "der"
+			bundle.Key = key
+		} else if contents, err := decodePKCS12(data, password); err == nil {
+			bundle.Format = "p12"
+			if len(contents.Keys) > 0 {
+				bundle.Key = contents.Keys[0].Key
+				bundle.Alias = contents.Keys[0].FriendlyName
+			}
+			for _, c := range contents.Certs {
+				bundle.Certs = append(bundle.Certs, c.Cert)
+			}
+		} else if err == errPKCS12Password {
+			return nil, err
+		} else {
+			return nil, fmt.Errorf("Unrecognized input format")
+		}
+	}
+
+	// Put the certificate of the key first so the outputs pair them correctly
+	if bundle.Key != nil {
+		if signer, ok := bundle.Key.(crypto.Signer); ok {
+			for i, cert := range bundle.Certs {
+				if certforge.SamePublicKey(cert.PublicKey, signer.Public()) {
+					bundle.Certs[0], bundle.Certs[i] = bundle.Certs[i], bundle.Certs[0]
+					break
+				}
+			}
+		}
+	}
+	return bundle, nil
+}
+
+// writeConvertOutput encodes a bundle in the requested format
+func writeConvertOutput(bundle *convertBundle, outform, password string, legacy bool) ([]byte, error) {
+	switch outform {
+	case "pem":
+		var buf bytes.Buffer
+		if bundle.Key != nil {
+			der, err := x509.MarshalPKCS8PrivateKey(bundle.Key)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to encode private key: %v", err)
+			}
+			pem.Encode(&buf, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		}
+		for _, cert := range bundle.Certs {
+			pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+		return buf.Bytes(), nil
+
+	case "der":
+		// DER holds a single object
+		switch {
+		case bundle.Key != nil && len(bundle.Certs) > 0:
+			return nil, fmt.Errorf("DER holds a single object; use --nokeys or --nocerts")
+		case bundle.Key != nil:
+			return x509.MarshalPKCS8PrivateKey(bundle.Key)
+		case len(bundle.Certs) > 1:
+			return nil, fmt.Errorf("DER holds a single certificate; convert the %d certificates to p7b instead", len(bundle.Certs))
+		}
+		return bundle.Certs[0].Raw, nil
+
+	case "p7b":
+		if len(bundle.Certs) == 0 {
+			return nil, fmt.Errorf("A PKCS#7 bundle needs certificates")
+		}
+		der, err := encodePKCS7(bundle.Certs)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode PKCS#7 bundle: %v", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: der}), nil
+
+	case "p12":
+		if bundle.Key == nil || len(bundle.Certs) == 0 {
+			return nil, fmt.Errorf("A PKCS#12 file needs a private key and its certificate")
+		}
+		if signer, ok := bundle.Key.(crypto.Signer); !ok || !certforge.SamePublicKey(bundle.Certs[0].PublicKey, signer.Public()) {
+			return nil, fmt.Errorf("No certificate matches the private key")
+		}
+		return encodePKCS12(bundle.Key, bundle.Certs[0], bundle.Certs[1:], password, bundle.Alias, legacy)
+
+	case "jks":
+		if len(password) < 6 {
+			return nil, fmt.Errorf("Keystore password must be at least 6 characters")
+		}
+		if bundle.Key == nil {
+			// Certificates alone become a truststore
+			var entries []jksEntry
+			for i, cert := range bundle.Certs {
+				entries = append(entries, jksEntry{Alias: fmt.Sprintf("ca-%d", i+1), Chain: []*x509.Certificate{cert}})
+			}
+			return encodeJKS(entries, password)
+		}
+		if len(bundle.Certs) == 0 {
+			return nil, fmt.Errorf("A JKS key entry needs the certificate of the key")
+		}
+		alias := bundle.Alias
+		if alias == "" {
+			alias = "mykey"
+		}
+		return encodeJKS([]jksEntry{{Alias: alias, Key: bundle.Key, Chain: bundle.Certs}}, password)
+	}
+	return nil, fmt.Errorf("Unknown output format %q (use pem, der, p7b, p12, or jks)", outform)
+}
@@ -0,0 +1,200 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// oidAttrEmailAddress is the legacy emailAddress subject attribute
+var oidAttrEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// copySource is an existing certificate that --copy-from starts a new one from
+type copySource struct {
+	cert *x509.Certificate
+}
+
+// readCopySource loads the first certificate of a PEM or DER file for --copy-from
+func readCopySource(path string) (*copySource, error) {
+	certs, err := readCertificates(path)
+	if err != nil {
+		return nil, err
+	}
+	return &copySource{cert: certs[0]}, nil
+}
+
+// promptDefault asks for a value, showing def as the default taken for a blank answer when it is set
+func promptDefault(reader *bufio.Reader, label, def string) string {
+	if def != "" {
+		fmt.Printf("%s [default: %s]: ", label, def)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	answer, _ := reader.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return def
+	}
+	return answer
+}
+
+// first returns the first of values, or an empty string
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// subjectValues returns the values of a subject field for answer, keeping all the copied values, of which only the
+// first is offered as the default, when the answer is that default. A blank answer leaves the field out rather than
+// writing an empty attribute.
+func subjectValues(answer string, copied []string) []string {
+	if len(copied) > 0 && answer == copied[0] {
+		return copied
+	}
+	if answer == "" {
+		return nil
+	}
+	return []string{answer}
+}
+
+// keySize returns the size of the copied RSA key when it is one certforge generates, and otherwise def
+func (c *copySource) keySize(def int) int {
+	if c == nil {
+		return def
+	}
+	if pub, ok := c.cert.PublicKey.(*rsa.PublicKey); ok {
+		switch size := pub.N.BitLen(); size {
+		case 2048, 3072, 4096:
+			return size
+		}
+	}
+	return def
+}
+
+// email returns the first email address SAN, which is offered as the default email address
+func (c *copySource) email() string {
+	if c == nil {
+		return ""
+	}
+	return first(c.cert.EmailAddresses)
+}
+
+// names returns the SANs to copy, without the default email address, which the email prompt adds
+func (c *copySource) names() []string {
+	if c == nil {
+		return nil
+	}
+	var names []string
+	for _, name := range certforge.CertificateNames(c.cert) {
+		if name != c.email() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// attributes fills the subject attributes not given as flags from the copied subject
+func (c *copySource) attributes(attrs *subjectAttributes) {
+	if c == nil {
+		return
+	}
+	subject := c.cert.Subject
+	for _, field := range []struct {
+		value  *string
+		copied string
+	}{
+		{&attrs.SerialNumber, subject.SerialNumber},
+		{&attrs.Street, first(subject.StreetAddress)},
+		{&attrs.PostalCode, first(subject.PostalCode)},
+		{&attrs.Title, nameAttribute(subject, oidAttrTitle)},
+		{&attrs.GivenName, nameAttribute(subject, oidAttrGivenName)},
+		{&attrs.Surname, nameAttribute(subject, oidAttrSurname)},
+	} {
+		if *field.value == "" {
+			*field.value = field.copied
+		}
+	}
+}
+
+// nameAttribute returns the first string value of an attribute of a subject
+func nameAttribute(name pkix.Name, oid asn1.ObjectIdentifier) string {
+	for _, attr := range name.Names {
+		if value, ok := attr.Value.(string); ok && attr.Type.Equal(oid) {
+			return value
+		}
+	}
+	return ""
+}
+
+// subjectOptions returns options adding the copied subject attributes that neither the prompts nor the subject
+// attribute flags cover, such as domain components or proprietary identifiers, including a legacy emailAddress
+// unless emailDN adds one
+func (c *copySource) subjectOptions(emailDN bool) []certforge.Option {
+	if c == nil {
+		return nil
+	}
+	var opts []certforge.Option
+	for _, attr := range c.cert.Subject.Names {
+		value, ok := attr.Value.(string)
+		if !ok || isPromptedAttribute(attr.Type) {
+			continue
+		}
+		if attr.Type.Equal(oidAttrEmailAddress) {
+			if !emailDN {
+				opts = append(opts, certforge.WithEmailInSubject(value))
+			}
+			continue
+		}
+		opts = append(opts, certforge.WithSubjectAttribute(attr.Type, value))
+	}
+	return opts
+}
+
+// isPromptedAttribute reports whether a subject attribute is asked for or set by a flag
+func isPromptedAttribute(oid asn1.ObjectIdentifier) bool {
+	for _, prompted := range []asn1.ObjectIdentifier{
+		{2, 5, 4, 3}, {2, 5, 4, 5}, {2, 5, 4, 6}, {2, 5, 4, 7}, {2, 5, 4, 8}, {2, 5, 4, 9}, {2, 5, 4, 10},
+		{2, 5, 4, 11}, {2, 5, 4, 17}, oidAttrTitle, oidAttrGivenName, oidAttrSurname,
+	} {
+		if oid.Equal(prompted) {
+			return true
+		}
+	}
+	return false
+}
+
+// extensions returns the copied key usage and extended key usage extensions, except those given by flags: key
+// usage when custom has it, and extended key usage when ekuGiven is set or custom has it
+func (c *copySource) extensions(custom []pkix.Extension, ekuGiven bool) []pkix.Extension {
+	if c == nil {
+		return nil
+	}
+	var exts []pkix.Extension
+	for _, ext := range c.cert.Extensions {
+		if !ext.Id.Equal(oidExtKeyUsage) && !ext.Id.Equal(oidExtExtendedKeyUsage) {
+			continue
+		}
+		if ext.Id.Equal(oidExtExtendedKeyUsage) && ekuGiven {
+			continue
+		}
+		given := false
+		for _, other := range custom {
+			given = given || other.Id.Equal(ext.Id)
+		}
+		if !given {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
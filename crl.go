@@ -0,0 +1,79 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// crlReasonNames maps CRL reason codes (RFC 5280 section 5.3.1) to their names
+var crlReasonNames = map[int]string{
+	0:  "unspecified",
+	1:  "keyCompromise",
+	2:  "cACompromise",
+	3:  "affiliationChanged",
+	4:  "superseded",
+	5:  "cessationOfOperation",
+	6:  "certificateHold",
+	8:  "removeFromCRL",
+	9:  "privilegeWithdrawn",
+	10: "aACompromise",
+}
+
+// crlReasonName returns the name of a CRL reason code
+func crlReasonName(code int) string {
+	if name, ok := crlReasonNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", code)
+}
+
+// printCRLInfo displays information about a Certificate Revocation List
+func printCRLInfo(crl *certforge.CRL, opts decodeOptions) {
+	fmt.Println("=== Certificate Revocation List Information ===")
+	fmt.Println()
+	fmt.Printf("Issuer: %s\n", certforge.FormatName(crl.Issuer))
+	if crl.Number != nil {
+		fmt.Printf("CRL Number: %s\n", crl.Number)
+	}
+	fmt.Printf("This Update: %s\n", crl.ThisUpdate.Format(time.RFC3339))
+	if crl.NextUpdate.IsZero() {
+		fmt.Println("Next Update: not set")
+	} else {
+		fmt.Printf("Next Update: %s\n", crl.NextUpdate.Format(time.RFC3339))
+		if crl.Stale {
+			fmt.Println(colorize("CRL is STALE: next update is in the past", expiryExpired))
+		}
+	}
+	fmt.Printf("Signature Algorithm: %s\n", crl.SignatureAlgorithm)
+
+	// Verify the signature when the issuer is part of the same input
+	for _, cert := range opts.Chain {
+		if bytes.Equal(cert.RawSubject, crl.RawIssuer) {
+			if err := crl.CheckSignatureFrom(cert); err != nil {
+				fmt.Printf("Signature: INVALID (%v)\n", err)
+			} else {
+				fmt.Printf("Signature: valid (issued by %s)\n", certforge.FormatName(cert.Subject))
+			}
+			break
+		}
+	}
+
+	fmt.Printf("\nRevoked Certificates: %d\n", len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		fmt.Printf("  Serial: %s\n", strings.ToLower(colonHex(entry.SerialNumber.Bytes())))
+		fmt.Printf("    Revoked: %s\n", entry.RevocationTime.Format(time.RFC3339))
+		if entry.ReasonCode != 0 {
+			fmt.Printf("    Reason: %s\n", crlReasonName(entry.ReasonCode))
+		}
+	}
+
+	printExtensionsSummary(crl.Extensions)
+}
@@ -0,0 +1,91 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// runCSR implements the csr command, which creates a renewal CSR reproducing the subject and SANs of an existing
+// certificate
+func runCSR(args []string) error {
+	fs := flag.NewFlagSet("csr", flag.ExitOnError)
+	fromCertFlag := fs.String("from-cert", "", "Certificate to renew, whose subject and SAN extension the CSR reproduces")
+	keyFlag := fs.String("key", "", "Private key to sign the CSR with, usually the certificate's own")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted private key (pass:, env:, file:, or stdin)")
+	outFlag := fs.String("out", "", "CSR file to write (default: --from-cert with a .csr extension)")
+	outformFlag := fs.String("outform", "pem", "Encoding of the CSR: pem or der")
+	parseArgs(fs, args)
+
+	if *fromCertFlag == "" || *keyFlag == "" {
+		return fmt.Errorf("csr requires --from-cert and --key")
+	}
+	outform := strings.ToLower(*outformFlag)
+	if outform != "pem" && outform != "der" {
+		return fmt.Errorf("Invalid --outform %q (use pem or der)", *outformFlag)
+	}
+	out := *outFlag
+	if out == "" {
+		out = strings.TrimSuffix(*fromCertFlag, filepath.Ext(*fromCertFlag)) + ".csr"
+	}
+	var password string
+	var err error
+	if *passinFlag != "" {
+		if password, err = readPassphrase(*passinFlag); err != nil {
+			return err
+		}
+	}
+
+	certs, err := readCertificates(*fromCertFlag)
+	if err != nil {
+		return err
+	}
+	cert := certs[0]
+	privateKey, err := readPrivateKey(*keyFlag, password)
+	if err != nil {
+		return err
+	}
+	defer releaseKey(privateKey)
+	key, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("The key in %s cannot sign", *keyFlag)
+	}
+
+	der, err := certforge.RenewalCSR(cert, key)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("Failed to write %s: %v", out, err)
+	}
+	defer f.Close()
+	if err := writeEncoded(f, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}, outform); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", out, err)
+	}
+	entry := auditLogCSR(der, out)
+	entry.Inputs = append(entry.Inputs, auditLogInputs(*fromCertFlag, *keyFlag)...)
+	auditLog(entry)
+
+	fmt.Printf("Wrote %s\n", out)
+	fmt.Printf("  Subject: %s\n", certforge.FormatName(cert.Subject))
+	if names := certforge.CertificateNames(cert); len(names) > 0 {
+		fmt.Printf("  Names: %s\n", strings.Join(names, ", "))
+	}
+	if certforge.SamePublicKey(cert.PublicKey, key.Public()) {
+		fmt.Println("  Key: the certificate's own key")
+	} else {
+		fmt.Println("  Key: a new key, not the certificate's")
+	}
+	return nil
+}
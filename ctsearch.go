@@ -0,0 +1,141 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultCTSearchURL is the crt.sh endpoint queried by ct-search
+const defaultCTSearchURL = "https://crt.sh/"
+
+// ctSearchEntry is one certificate from a crt.sh JSON response
+type ctSearchEntry struct {
+	ID           int64  `json:"id"`
+	IssuerName   string `json:"issuer_name"`
+	CommonName   string `json:"common_name"`
+	NameValue    string `json:"name_value"`
+	SerialNumber string `json:"serial_number"`
+	NotBefore    string `json:"not_before"`
+	NotAfter     string `json:"not_after"`
+}
+
+// crtshTimeLayout is the timestamp format used in crt.sh responses
+const crtshTimeLayout = "2006-01-02T15:04:05"
+
+// runCTSearch implements the ct-search command
+func runCTSearch(args []string) error {
+	fs := flag.NewFlagSet("ct-search", flag.ExitOnError)
+	unexpiredFlag := fs.Bool("unexpired", false, "Only list certificates that have not expired")
+	issuerFlag := fs.String("issuer", "", "Only list certificates whose issuer contains this text")
+	subdomainsFlag := fs.Bool("subdomains", false, "Also search all subdomains of the domain")
+	urlFlag := fs.String("url", defaultCTSearchURL, "crt.sh compatible search endpoint")
+	timeoutFlag := fs.Duration("timeout", 60*time.Second, "Request timeout")
+	domains := parseArgs(fs, args)
+
+	if len(domains) != 1 {
+		return fmt.Errorf("ct-search requires exactly one domain")
+	}
+	domain := strings.ToLower(strings.TrimSpace(domains[0]))
+
+	query := domain
+	if *subdomainsFlag {
+		query = "%." + domain
+	}
+	entries, err := queryCTSearch(*urlFlag, query, *unexpiredFlag, *timeoutFlag)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	entries = filterCTSearch(entries, *issuerFlag, *unexpiredFlag, now)
+	printCTSearch(domain, entries, now)
+	return nil
+}
+
+// queryCTSearch fetches the certificates logged for query from a crt.sh compatible endpoint
+func queryCTSearch(endpoint, query string, unexpired bool, timeout time.Duration) ([]ctSearchEntry, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("output", "json")
+	if unexpired {
+		params.Set("exclude", "expired")
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(endpoint + "?" + params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to query %s: %s", endpoint, resp.Status)
+	}
+
+	var entries []ctSearchEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("Failed to parse search results: %v", err)
+	}
+	return entries, nil
+}
+
+// filterCTSearch drops duplicate precertificate entries and applies the issuer and expiry filters
+func filterCTSearch(entries []ctSearchEntry, issuer string, unexpired bool, now time.Time) []ctSearchEntry {
+	seen := make(map[string]bool)
+	var filtered []ctSearchEntry
+	for _, entry := range entries {
+		// A precertificate and its final certificate share an issuer and serial number
+		key := entry.IssuerName + "/" + entry.SerialNumber
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if issuer != "" && !strings.Contains(strings.ToLower(entry.IssuerName), strings.ToLower(issuer)) {
+			continue
+		}
+		if unexpired {
+			if notAfter, err := time.Parse(crtshTimeLayout, entry.NotAfter); err == nil && now.After(notAfter) {
+				continue
+			}
+		}
+		filtered = append(filtered, entry)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].NotBefore > filtered[j].NotBefore })
+	return filtered
+}
+
+// printCTSearch lists the certificates found, newest first
+func printCTSearch(domain string, entries []ctSearchEntry, now time.Time) {
+	fmt.Printf("=== Certificate Transparency: %s ===\n", domain)
+	fmt.Println()
+	fmt.Printf("Certificates Found: %d\n", len(entries))
+
+	for _, entry := range entries {
+		fmt.Println()
+		fmt.Printf("[%d] %s\n", entry.ID, entry.CommonName)
+		fmt.Printf("    Issuer: %s\n", entry.IssuerName)
+		fmt.Printf("    Serial: %s\n", entry.SerialNumber)
+
+		validity := fmt.Sprintf("%s to %s", entry.NotBefore, entry.NotAfter)
+		if notAfter, err := time.Parse(crtshTimeLayout, entry.NotAfter); err == nil && now.After(notAfter) {
+			validity += " (expired)"
+		}
+		fmt.Printf("    Validity: %s\n", validity)
+
+		names := strings.Fields(entry.NameValue)
+		if len(names) > 0 {
+			fmt.Printf("    Names: %s\n", strings.Join(names, ", "))
+		}
+	}
+}
@@ -0,0 +1,755 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// renewalConfig is the file listing the certificates certforge manages
+type renewalConfig struct {
+	CheckInterval string               `yaml:"check_interval"`
+	RenewBefore   string               `yaml:"renew_before"`
+	PreHook       string               `yaml:"pre_hook"`
+	PostHook      string               `yaml:"post_hook"`
+	HookTimeout   string               `yaml:"hook_timeout"`
+	Layout        string               `yaml:"layout"`
+	Notify        *notifyConfig        `yaml:"notify"`
+	Events        *eventsConfig        `yaml:"events"`
+	Certificates  []managedCertificate `yaml:"certificates"`
+
+	interval    time.Duration
+	hookTimeout time.Duration
+}
+
+// managedCertificate is a certificate kept renewed, issued via ACME or by a local CA
+type managedCertificate struct {
+	Name        string       `yaml:"name"`
+	Domains     []string     `yaml:"domains"`
+	OutDir      string       `yaml:"out_dir"`
+	Out         string       `yaml:"out"`
+	KeyType     string       `yaml:"key_type"`
+	KeySize     int          `yaml:"key_size"`
+	ReuseKey    bool         `yaml:"reuse_key"`
+	RenewBefore string       `yaml:"renew_before"`
+	Reload      string       `yaml:"reload"`
+	PreHook     string       `yaml:"pre_hook"`
+	PostHook    string       `yaml:"post_hook"`
+	Layout      string       `yaml:"layout"`
+	MustStaple  bool         `yaml:"must_staple"`
+	ACME        *managedACME `yaml:"acme"`
+	CA          *managedCA   `yaml:"ca"`
+
+	renewBefore time.Duration
+}
+
+// managedACME holds the acme command's options for a managed certificate
+type managedACME struct {
+	Email      string `yaml:"email"`
+	Staging    bool   `yaml:"staging"`
+	Directory  string `yaml:"directory"`
+	AccountKey string `yaml:"account_key"`
+	AgreeTOS   bool   `yaml:"agree_tos"`
+	EABKID     string `yaml:"eab_kid"`
+	EABHMACKey string `yaml:"eab_hmac_key"`
+	Challenge  string `yaml:"challenge"`
+	HTTPPort   int    `yaml:"http_port"`
+	Webroot    string `yaml:"webroot"`
+	TLSPort    int    `yaml:"tls_port"`
+}
+
+// managedCA is a local CA that signs a managed certificate
+type managedCA struct {
+	Cert      string   `yaml:"cert"`
+	Key       string   `yaml:"key"`
+	Passin    string   `yaml:"passin"`
+	Days      int      `yaml:"days"`
+	CTLogs    []string `yaml:"ct_logs"`
+	CTMinSCTs int      `yaml:"ct_min_scts"`
+}
+
+// ctSubmission returns how the CA logs the certificates it issues, or nil when it logs none
+func (ca *managedCA) ctSubmission() *certforge.CTSubmission {
+	if len(ca.CTLogs) == 0 {
+		return nil
+	}
+	return &certforge.CTSubmission{Logs: ca.CTLogs, MinSCTs: ca.CTMinSCTs}
+}
+
+// checkCTLogs checks the URLs of CT logs and how many of them must return an SCT
+func checkCTLogs(logs []string, minSCTs int) error {
+	for _, log := range logs {
+		if u, err := url.Parse(log); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			return fmt.Errorf("Invalid CT log URL %q", log)
+		}
+	}
+	if minSCTs < 0 || minSCTs > len(logs) {
+		return fmt.Errorf("Invalid minimum of %d SCTs from %d CT logs", minSCTs, len(logs))
+	}
+	return nil
+}
+
+// runDaemon implements the daemon command, which keeps the certificates in a renewal config renewed
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	watchFlag := fs.String("watch", "", "Renewal config listing the managed certificates, reloaded when it changes")
+	metricsFlag := fs.String("metrics", "", "Address to serve Prometheus metrics on, like :9101")
+	metricsFilesFlag := fs.String("metrics-files", "", "Comma separated certificate files and directories to export metrics for, besides the managed certificates")
+	parallelFlag := fs.Int("parallel", 1, "Number of local CA certificates to renew at once")
+	shredOldFlag := fs.Bool("shred-old", false, "Overwrite and remove the key files that renewals with new keys replace")
+	parseArgs(fs, args)
+
+	if *watchFlag == "" {
+		return fmt.Errorf("daemon requires --watch")
+	}
+	if *parallelFlag < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+	cfg, err := loadRenewalConfig(*watchFlag)
+	if err != nil {
+		return err
+	}
+	modTime := configModTime(*watchFlag)
+	daemonLogf("Managing %d certificates from %s, checking every %s", len(cfg.Certificates), *watchFlag, cfg.interval)
+
+	var metrics *certMetrics
+	if *metricsFlag != "" {
+		metrics = &certMetrics{managed: map[string]*managedMetric{}, trackIssuance: true}
+		for _, path := range strings.Split(*metricsFilesFlag, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				metrics.watch = append(metrics.watch, path)
+			}
+		}
+		metrics.setConfig(cfg)
+		listener, err := net.Listen("tcp", *metricsFlag)
+		if err != nil {
+			return fmt.Errorf("Failed to listen on %s: %v", *metricsFlag, err)
+		}
+		daemonLogf("Serving metrics on http://%s/metrics", listener.Addr())
+		go http.Serve(listener, metricsHandler(metrics, nil))
+	}
+
+	// Stopping also interrupts a renewal pass that is under way
+	ctx, stop := commandContext(0)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	poll := time.NewTicker(time.Minute)
+	defer poll.Stop()
+
+	notifier := &expiryNotifier{notified: map[string]int{}}
+	events := newEventEmitter(true)
+	next := time.Now()
+	for {
+		if !time.Now().Before(next) {
+			runRenewalPass(ctx, cfg, renewalOptions{Metrics: metrics, Notifier: notifier, Events: events, Parallel: *parallelFlag, ShredOld: *shredOldFlag})
+			next = time.Now().Add(cfg.interval)
+		}
+
+		reload := false
+		select {
+		case <-ctx.Done():
+			daemonLogf("Stopping")
+			return nil
+		case <-hup:
+			reload = true
+		case <-poll.C:
+			if t := configModTime(*watchFlag); !t.Equal(modTime) {
+				modTime, reload = t, true
+			}
+		}
+		if reload {
+			// A broken edit keeps the daemon running on the last good configuration
+			updated, err := loadRenewalConfig(*watchFlag)
+			if err != nil {
+				daemonLogf("Keeping the previous configuration: %v", err)
+				continue
+			}
+			cfg, next = updated, time.Now()
+			metrics.setConfig(cfg)
+			daemonLogf("Reloaded %s: %d certificates", *watchFlag, len(cfg.Certificates))
+		}
+	}
+}
+
+// renewalOptions adjust a renewal pass to the command running it
+type renewalOptions struct {
+	// Metrics records checks, renewals, and failures; Notifier is given the certificates still expiring; Events
+	// reports issuance, renewal, and expiry to the configured webhooks. Each may be nil.
+	Metrics  *certMetrics
+	Notifier *expiryNotifier
+	Events   *eventEmitter
+
+	// Quiet leaves out certificates that are not due, so a pass with nothing to do prints nothing
+	Quiet bool
+
+	// Parallel is how many local CA certificates are renewed at once; ACME certificates are always renewed in turn
+	Parallel int
+
+	// ShredOld overwrites and removes the key files that new keys replace
+	ShredOld bool
+}
+
+// renewalOutcome is what a renewal pass did with one managed certificate
+type renewalOutcome int
+
+const (
+	renewalSkipped renewalOutcome = iota
+	renewalRenewed
+	renewalFailed
+)
+
+// runRenewalPass renews every due certificate, then runs the reload commands of those renewed, and returns the counts.
+// Once ctx is done, the certificates being renewed fail and the rest are left for the next pass.
+func runRenewalPass(ctx context.Context, cfg *renewalConfig, opts renewalOptions) (renewed, failed int) {
+	notifier, events := opts.Notifier, opts.Events
+	outcomes := make([]renewalOutcome, len(cfg.Certificates))
+
+	// Local CA certificates are spread over the workers, since generating their keys dominates a large pass
+	jobs := make(chan int)
+	var workers sync.WaitGroup
+	if opts.Parallel > 1 {
+		for w := 0; w < opts.Parallel; w++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for i := range jobs {
+					outcomes[i] = renewManaged(ctx, cfg, &cfg.Certificates[i], opts)
+				}
+			}()
+		}
+	}
+	for i := range cfg.Certificates {
+		if ctx.Err() != nil {
+			daemonLogf("Renewal pass interrupted, %d certificates left unchecked", len(cfg.Certificates)-i)
+			break
+		}
+		mc := &cfg.Certificates[i]
+		// ACME certificates share challenge listeners and the CA's rate limits, so they are renewed one at a time
+		if opts.Parallel > 1 && mc.ACME == nil {
+			jobs <- i
+			continue
+		}
+		outcomes[i] = renewManaged(ctx, cfg, mc, opts)
+	}
+	close(jobs)
+	workers.Wait()
+
+	var reloads []string
+	for i, outcome := range outcomes {
+		mc := &cfg.Certificates[i]
+		switch outcome {
+		case renewalRenewed:
+			renewed++
+			if mc.Reload != "" && !contains(reloads, mc.Reload) {
+				reloads = append(reloads, mc.Reload)
+			}
+		case renewalFailed:
+			failed++
+		}
+	}
+
+	// Certificates renew well before the thresholds, so notifications mean renewals keep failing
+	now := time.Now()
+	for i := range cfg.Certificates {
+		mc := &cfg.Certificates[i]
+		if certs, err := readCertificates(mc.paths().Cert); err == nil {
+			notifier.check(cfg.Notify, "managed", mc.Name, mc.paths().Cert, certs[0], now)
+			events.checkExpiry(cfg.Events, "managed", mc.Name, mc.paths().Cert, certs[0], now)
+		}
+	}
+
+	for _, command := range reloads {
+		output, err := runReload(command, cfg.hookTimeout)
+		if err != nil {
+			daemonLogf("Reload %q FAILED: %v: %s", command, err, strings.TrimSpace(string(output)))
+			continue
+		}
+		daemonLogf("Reloaded: %s", command)
+	}
+	return renewed, failed
+}
+
+// renewManaged renews one managed certificate if it is due, running its hooks and recording the result
+func renewManaged(ctx context.Context, cfg *renewalConfig, mc *managedCertificate, opts renewalOptions) renewalOutcome {
+	metrics, events := opts.Metrics, opts.Events
+	reason, due := mc.renewalDue()
+	if !due {
+		if !opts.Quiet {
+			daemonLogf("%s: %s", mc.Name, reason)
+		}
+		metrics.checked(mc)
+		return renewalSkipped
+	}
+	daemonLogf("%s: renewing (%s)", mc.Name, reason)
+	env := hookEnv(mc.Name, mc.Domains, mc.paths())
+	if mc.PreHook != "" {
+		if err := runHook("pre-hook", mc.PreHook, env, cfg.hookTimeout); err != nil {
+			daemonLogf("%s: renewal skipped: %v", mc.Name, err)
+			metrics.failed(mc)
+			metrics.checked(mc)
+			return renewalFailed
+		}
+	}
+	_, statErr := os.Stat(mc.paths().Cert)
+	notAfter, err := mc.renew(ctx, opts.ShredOld)
+	if mc.PostHook != "" {
+		if hookErr := runHook("post-hook", mc.PostHook, postHookEnv(env, notAfter, err), cfg.hookTimeout); hookErr != nil {
+			daemonLogf("%s: %v", mc.Name, hookErr)
+		}
+	}
+	metrics.checked(mc)
+	if err != nil {
+		daemonLogf("%s: renewal FAILED: %v", mc.Name, err)
+		metrics.failed(mc)
+		return renewalFailed
+	}
+	daemonLogf("%s: renewed, valid until %s", mc.Name, notAfter.Format("2006-01-02"))
+	metrics.issued(mc)
+	if certs, err := readCertificates(mc.paths().Cert); err == nil {
+		event := newCertEvent("certificate_renewed", "managed", mc.Name, certs[0])
+		if os.IsNotExist(statErr) {
+			event.Event = "certificate_issued"
+		}
+		event.Path = mc.paths().Cert
+		events.emit(cfg.Events, event)
+	}
+	return renewalRenewed
+}
+
+// paths returns the files of the managed certificate
+func (mc *managedCertificate) paths() issuedPaths {
+	return newIssuedPaths(mc.Layout, mc.OutDir, mc.Out)
+}
+
+// renewalDue reports whether the certificate must be issued or renewed, and why
+func (mc *managedCertificate) renewalDue() (string, bool) {
+	path := mc.paths().Cert
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "no certificate yet", true
+	}
+	certs, err := readCertificates(path)
+	if err != nil {
+		return fmt.Sprintf("existing certificate unreadable: %v", err), true
+	}
+	cert := certs[0]
+
+	// Certificates are reissued when the configured names change
+	have := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		have = append(have, ip.String())
+	}
+	want := append([]string{}, mc.Domains...)
+	sort.Strings(have)
+	sort.Strings(want)
+	if strings.Join(have, ",") != strings.Join(want, ",") {
+		return "domains changed", true
+	}
+
+	remaining := time.Until(cert.NotAfter)
+	days := int(remaining.Hours() / 24)
+	if remaining < mc.renewBefore {
+		return fmt.Sprintf("expires in %d days", days), true
+	}
+	return fmt.Sprintf("valid for %d more days, renewing %d days before expiry", days, int(mc.renewBefore.Hours()/24)), false
+}
+
+// renew issues a new certificate and writes its files, returning its expiry. With shredOld the key file a new key
+// replaces is shredded once the new files are written.
+func (mc *managedCertificate) renew(ctx context.Context, shredOld bool) (time.Time, error) {
+	paths := mc.paths()
+
+	var key crypto.Signer
+	writeKey := true
+	if mc.ReuseKey {
+		if existing, err := readPrivateKey(paths.Key, ""); err == nil {
+			signer, ok := existing.(crypto.Signer)
+			if !ok {
+				return time.Time{}, fmt.Errorf("Unsupported private key in %s", paths.Key)
+			}
+			key, writeKey = signer, false
+		}
+	}
+	if key == nil {
+		var err error
+		if key, err = generateACMEKey(ctx, mc.KeyType, mc.KeySize); err != nil {
+			return time.Time{}, err
+		}
+	}
+	// The daemon runs for months, so the key, or its TPM connection, does not outlive the renewal that wrote it
+	defer releaseKey(key)
+
+	var csrDER []byte
+	var chain [][]byte
+	var err error
+	if mc.ACME != nil {
+		csrDER, chain, err = mc.issueACME(ctx, key)
+	} else {
+		csrDER, chain, err = issueFromLocalCA(mc.Domains, key, mc.extensions(), mc.CA)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var oldKey string
+	if shredOld && writeKey {
+		if oldKey, err = setAsideKey(mc.Layout, paths.Key); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if _, err := writeIssuedFiles(mc.Layout, mc.OutDir, mc.Out, key, csrDER, chain, writeKey); err != nil {
+		restoreKey(mc.Layout, paths.Key, oldKey)
+		return time.Time{}, err
+	}
+	if oldKey != "" {
+		// The new certificate is in place either way, so a key that cannot be shredded does not fail the renewal
+		if err := shredFile(oldKey, defaultShredPasses); err != nil {
+			daemonLogf("%s: %v", mc.Name, err)
+		} else {
+			daemonLogf("%s: shredded the old key %s", mc.Name, oldKey)
+		}
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to parse issued certificate: %v", err)
+	}
+	return leaf.NotAfter, nil
+}
+
+// issueACME obtains the certificate from the managed certificate's ACME CA
+func (mc *managedCertificate) issueACME(ctx context.Context, key crypto.Signer) ([]byte, [][]byte, error) {
+	a := mc.ACME
+	eab, err := parseACMEEAB(a.EABKID, a.EABHMACKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	account := &acmeAccountFlags{staging: &a.Staging, directory: &a.Directory, accountKey: &a.AccountKey}
+	client, _, err := account.client(true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	if _, _, err := registerACMEAccount(ctx, client, acmeContacts(a.Email), a.AgreeTOS, eab); err != nil {
+		return nil, nil, err
+	}
+	challenge := acmeChallengeOptions{Type: a.Challenge, HTTPPort: a.HTTPPort, Webroot: a.Webroot, TLSPort: a.TLSPort}
+	return obtainACMECertificate(ctx, client, mc.Domains, key, mc.extensions(), challenge)
+}
+
+// extensions returns the extensions the managed certificate asks for in its CSR
+func (mc *managedCertificate) extensions() []pkix.Extension {
+	if mc.MustStaple {
+		return []pkix.Extension{certforge.MustStapleExtension()}
+	}
+	return nil
+}
+
+// issueFromLocalCA signs a server certificate for names and extensions with a local CA, returning the CSR and the chain
+// without the root
+func issueFromLocalCA(names []string, key crypto.Signer, extensions []pkix.Extension, ca *managedCA) ([]byte, [][]byte, error) {
+	issuer, err := localCAs.get(ca)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	request := &x509.CertificateRequest{Subject: pkix.Name{CommonName: names[0]}, ExtraExtensions: extensions}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			request.IPAddresses = append(request.IPAddresses, ip)
+		} else {
+			request.DNSNames = append(request.DNSNames, name)
+		}
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, request, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating CSR: %v", err)
+	}
+	chain, err := issuer.IssueServer(key.Public(), names, time.Duration(ca.Days)*24*time.Hour, extensions...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cert, err := x509.ParseCertificate(chain[0]); err == nil {
+		entry := auditLogCertificate(auditLogCertSigned, cert)
+		entry.Inputs = append(auditLogInputs(ca.Cert), auditLogData("CSR", csrDER))
+		auditLog(entry)
+	}
+	return csrDER, chain, nil
+}
+
+// loadLocalCA reads the certificate, with any chain, and the private key of a local CA
+func loadLocalCA(ca *managedCA) (*certforge.CA, error) {
+	caCerts, err := readCertificates(ca.Cert)
+	if err != nil {
+		return nil, err
+	}
+	caCert := caCerts[0]
+	if !caCert.IsCA || caCert.KeyUsage != 0 && caCert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("%s is not a CA certificate that may sign certificates", ca.Cert)
+	}
+
+	var password string
+	if ca.Passin != "" {
+		if password, err = readPassphrase(ca.Passin); err != nil {
+			return nil, err
+		}
+	}
+	caKey, err := readCAKey(ca.Key, password)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := caKey.(crypto.Signer)
+	if !ok || !certforge.SamePublicKey(caCert.PublicKey, signer.Public()) {
+		certforge.ZeroizeKey(caKey)
+		return nil, fmt.Errorf("%s is not the private key of %s", ca.Key, ca.Cert)
+	}
+	loaded, err := certforge.NewCA(caCerts, signer)
+	if err != nil {
+		certforge.ZeroizeKey(caKey)
+		return nil, err
+	}
+	loaded.CT = ca.ctSubmission()
+	return loaded, nil
+}
+
+// localCACache keeps local CAs loaded across certificates and renewal passes, so the CA files are read, the key
+// decrypted, and its passin resolved once rather than for each certificate. A CA is loaded again when its files change.
+type localCACache struct {
+	mu      sync.Mutex
+	entries map[localCAKey]*cachedCA
+}
+
+// localCAKey identifies a cached local CA by its files and how it logs certificates
+type localCAKey struct {
+	cert, key, passin string
+	ctLogs            string
+	ctMinSCTs         int
+}
+
+// cachedCA is a loaded local CA and the modification times of its files when it was loaded
+type cachedCA struct {
+	ca      *certforge.CA
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// localCAs is the cache of the local CAs of renewal configs
+var localCAs = &localCACache{entries: map[localCAKey]*cachedCA{}}
+
+// get returns the loaded local CA, loading it on first use or when its files have changed
+func (c *localCACache) get(ca *managedCA) (*certforge.CA, error) {
+	key := localCAKey{cert: ca.Cert, key: ca.Key, passin: ca.Passin, ctLogs: strings.Join(ca.CTLogs, "\n"), ctMinSCTs: ca.CTMinSCTs}
+	certMod, keyMod := configModTime(ca.Cert), configModTime(ca.Key)
+
+	// Holding the lock while loading makes parallel renewals wait for one load instead of each loading the CA
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.entries[key]; ok && cached.certMod.Equal(certMod) && cached.keyMod.Equal(keyMod) {
+		return cached.ca, nil
+	}
+	loaded, err := loadLocalCA(ca)
+	if err != nil {
+		return nil, err
+	}
+	c.entries[key] = &cachedCA{ca: loaded, certMod: certMod, keyMod: keyMod}
+	return loaded, nil
+}
+
+// loadRenewalConfig reads and validates a renewal config; relative paths in it are relative to the file
+func loadRenewalConfig(path string) (*renewalConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	cfg := &renewalConfig{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
+
+	if cfg.CheckInterval == "" {
+		cfg.CheckInterval = "12h"
+	}
+	if cfg.interval, err = parseThreshold(cfg.CheckInterval); err != nil || cfg.interval < time.Hour {
+		return nil, fmt.Errorf("Invalid check_interval %q: use at least 1h", cfg.CheckInterval)
+	}
+	cfg.hookTimeout = defaultHookTimeout
+	if cfg.HookTimeout != "" {
+		if cfg.hookTimeout, err = time.ParseDuration(cfg.HookTimeout); err != nil || cfg.hookTimeout <= 0 {
+			return nil, fmt.Errorf("Invalid hook_timeout %q: use a duration like 5m or 30s", cfg.HookTimeout)
+		}
+	}
+	if cfg.RenewBefore == "" {
+		cfg.RenewBefore = "30d"
+	}
+	if len(cfg.Certificates) == 0 {
+		return nil, fmt.Errorf("%s lists no certificates", path)
+	}
+	if cfg.Notify != nil {
+		if err := cfg.Notify.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if cfg.Events != nil {
+		if err := cfg.Events.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	base := filepath.Dir(path)
+	if abs, err := filepath.Abs(base); err == nil {
+		base = abs
+	}
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(base, p)
+	}
+
+	var names []string
+	for i := range cfg.Certificates {
+		mc := &cfg.Certificates[i]
+		var domains []string
+		for _, name := range mc.Domains {
+			name, err := certforge.ToASCII(strings.TrimSpace(name))
+			if ip := net.ParseIP(strings.Trim(name, "[]")); ip != nil {
+				name = ip.String()
+			} else if err == nil && name != "" {
+				err = certforge.CheckDNSName(name)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Certificate %d in %s: %v", i+1, path, err)
+			}
+			if name != "" && !contains(domains, name) {
+				domains = append(domains, name)
+			}
+		}
+		if len(domains) == 0 {
+			return nil, fmt.Errorf("Certificate %d in %s has no domains", i+1, path)
+		}
+		mc.Domains = domains
+		if mc.Name == "" {
+			mc.Name = domains[0]
+		}
+		if contains(names, mc.Name) {
+			return nil, fmt.Errorf("Certificate name %q is used twice in %s", mc.Name, path)
+		}
+		names = append(names, mc.Name)
+		invalid := func(format string, args ...interface{}) error {
+			return fmt.Errorf("Certificate %s: %s", mc.Name, fmt.Sprintf(format, args...))
+		}
+
+		if mc.Out == "" {
+			mc.Out = strings.TrimPrefix(domains[0], "*.")
+		}
+		if mc.OutDir = resolve(mc.OutDir); mc.OutDir == "" {
+			mc.OutDir = base
+		}
+		if mc.KeyType == "" {
+			mc.KeyType = "rsa"
+		}
+		if mc.KeySize == 0 {
+			mc.KeySize = 2048
+		}
+		if mc.RenewBefore == "" {
+			mc.RenewBefore = cfg.RenewBefore
+		}
+		if mc.PreHook == "" {
+			mc.PreHook = cfg.PreHook
+		}
+		if mc.PostHook == "" {
+			mc.PostHook = cfg.PostHook
+		}
+		if mc.Layout == "" {
+			mc.Layout = cfg.Layout
+		}
+		if mc.Layout, err = validateLayout(mc.Layout); err != nil {
+			return nil, invalid("%v", err)
+		}
+		if mc.renewBefore, err = parseThreshold(mc.RenewBefore); err != nil {
+			return nil, invalid("invalid renew_before: %v", err)
+		}
+
+		switch {
+		case (mc.ACME == nil) == (mc.CA == nil):
+			return nil, invalid("set exactly one of acme or ca")
+		case mc.ACME != nil:
+			a := mc.ACME
+			if a.Challenge == "" {
+				a.Challenge = "http-01"
+			}
+			if a.Challenge != "http-01" && a.Challenge != "tls-alpn-01" {
+				return nil, invalid("invalid challenge %q (use http-01 or tls-alpn-01)", a.Challenge)
+			}
+			if a.HTTPPort == 0 {
+				a.HTTPPort = 80
+			}
+			if a.TLSPort == 0 {
+				a.TLSPort = 443
+			}
+			a.AccountKey, a.Webroot = resolve(a.AccountKey), resolve(a.Webroot)
+		default:
+			ca := mc.CA
+			if ca.Cert == "" || ca.Key == "" {
+				return nil, invalid("ca requires cert and key")
+			}
+			if ca.Days == 0 {
+				ca.Days = 90
+			}
+			if ca.Days < 0 || time.Duration(ca.Days)*24*time.Hour <= mc.renewBefore {
+				return nil, invalid("ca days must be longer than renew_before")
+			}
+			if err := checkCTLogs(ca.CTLogs, ca.CTMinSCTs); err != nil {
+				return nil, invalid("%v", err)
+			}
+			// A key reassembled from shares lists their files, each resolved on its own
+			shares := strings.Split(ca.Key, ",")
+			for i, share := range shares {
+				shares[i] = resolve(strings.TrimSpace(share))
+			}
+			ca.Cert, ca.Key = resolve(ca.Cert), strings.Join(shares, ",")
+		}
+	}
+	return cfg, nil
+}
+
+// configModTime returns the modification time of the renewal config, or the zero time when it cannot be read
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// daemonLogf prints a timestamped line, for logs collected by journald or a log file
+func daemonLogf(format string, args ...interface{}) {
+	fmt.Printf("%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,129 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// TLSA certificate usages (RFC 6698, with the acronyms of RFC 7218)
+var tlsaUsageNames = map[int]string{
+	0: "PKIX-TA: CA constraint, validated against public roots",
+	1: "PKIX-EE: service certificate constraint, validated against public roots",
+	2: "DANE-TA: trust anchor assertion",
+	3: "DANE-EE: domain-issued certificate",
+}
+
+// TLSA selectors
+var tlsaSelectorNames = map[int]string{
+	0: "Cert: full certificate",
+	1: "SPKI: subject public key info",
+}
+
+// TLSA matching types
+var tlsaMatchingTypeNames = map[int]string{
+	0: "Full: exact match",
+	1: "SHA2-256",
+	2: "SHA2-512",
+}
+
+// runDANE implements the dane command
+func runDANE(args []string) error {
+	fs := flag.NewFlagSet("dane", flag.ExitOnError)
+	certFlag := fs.String("cert", "", "Certificate, or chain for trust anchor usages")
+	usageFlag := fs.Int("usage", 3, "Certificate usage: 0 PKIX-TA, 1 PKIX-EE, 2 DANE-TA, 3 DANE-EE")
+	selectorFlag := fs.Int("selector", 1, "Selector: 0 full certificate, 1 public key")
+	mtypeFlag := fs.Int("mtype", 1, "Matching type: 0 exact, 1 SHA-256, 2 SHA-512")
+	portFlag := fs.Int("port", 443, "Port of the service")
+	protoFlag := fs.String("proto", "tcp", "Transport protocol of the service")
+	hostFlag := fs.String("host", "", "Host name of the service (default: the certificate's first DNS name)")
+	parseArgs(fs, args)
+
+	if *certFlag == "" {
+		return fmt.Errorf("dane requires --cert")
+	}
+	if _, ok := tlsaUsageNames[*usageFlag]; !ok {
+		return fmt.Errorf("Invalid --usage %d (use 0-3)", *usageFlag)
+	}
+	if _, ok := tlsaSelectorNames[*selectorFlag]; !ok {
+		return fmt.Errorf("Invalid --selector %d (use 0 or 1)", *selectorFlag)
+	}
+	if _, ok := tlsaMatchingTypeNames[*mtypeFlag]; !ok {
+		return fmt.Errorf("Invalid --mtype %d (use 0-2)", *mtypeFlag)
+	}
+	if *portFlag < 1 || *portFlag > 65535 {
+		return fmt.Errorf("Invalid --port %d", *portFlag)
+	}
+
+	certs, err := readCertificates(*certFlag)
+	if err != nil {
+		return err
+	}
+	leaf := certs[0]
+
+	// Trust anchor usages pin the CA, which is the last certificate of a chain
+	cert := leaf
+	if *usageFlag == 0 || *usageFlag == 2 {
+		if len(certs) == 1 && !leaf.IsCA {
+			return fmt.Errorf("Usage %d pins a CA certificate; pass the chain or the CA certificate with --cert", *usageFlag)
+		}
+		cert = certs[len(certs)-1]
+	}
+
+	host := strings.TrimSuffix(*hostFlag, ".")
+	if host == "" {
+		if len(leaf.DNSNames) > 0 {
+			host = leaf.DNSNames[0]
+		} else {
+			host = leaf.Subject.CommonName
+		}
+	}
+	if host == "" || strings.Contains(host, "*") {
+		return fmt.Errorf("Cannot use %q as the TLSA owner name (use --host)", host)
+	}
+
+	data := tlsaAssociationData(cert, *selectorFlag, *mtypeFlag)
+	owner := fmt.Sprintf("_%d._%s.%s.", *portFlag, strings.ToLower(*protoFlag), host)
+
+	fmt.Println("=== TLSA Record ===")
+	fmt.Println()
+	fmt.Printf("Certificate: %s\n", certforge.FormatName(cert.Subject))
+	fmt.Printf("Usage: %d (%s)\n", *usageFlag, tlsaUsageNames[*usageFlag])
+	fmt.Printf("Selector: %d (%s)\n", *selectorFlag, tlsaSelectorNames[*selectorFlag])
+	fmt.Printf("Matching Type: %d (%s)\n", *mtypeFlag, tlsaMatchingTypeNames[*mtypeFlag])
+	fmt.Println()
+	fmt.Printf("%s IN TLSA %d %d %d %s\n", owner, *usageFlag, *selectorFlag, *mtypeFlag, data)
+
+	// A certificate pin breaks on every renewal, while a key pin survives renewals that reuse the key
+	if *usageFlag == 3 && *selectorFlag == 0 {
+		fmt.Println("\nNote: this record changes with every renewal; selector 1 only changes when the key does.")
+	}
+	return nil
+}
+
+// tlsaAssociationData computes the certificate association data of a TLSA record in hex
+func tlsaAssociationData(cert *x509.Certificate, selector, mtype int) string {
+	data := cert.Raw
+	if selector == 1 {
+		data = cert.RawSubjectPublicKeyInfo
+	}
+	switch mtype {
+	case 1:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case 2:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	}
+	return strings.ToUpper(hex.EncodeToString(data))
+}
@@ -0,0 +1,121 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// envPrefix matches the prefixes allowed for environment variable names
+var envPrefix = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// runExportEnv implements export env, which prints PEM files as single-line base64 environment variables
+func runExportEnv(args []string) error {
+	fs := flag.NewFlagSet("export env", flag.ExitOnError)
+	certFlag := fs.String("cert", "", "Certificate to export as <prefix>_CERT")
+	keyFlag := fs.String("key", "", "Private key to export as <prefix>_KEY")
+	chainFlag := fs.String("chain", "", "Intermediate and root certificates to export as <prefix>_CA")
+	prefixFlag := fs.String("prefix", "TLS", "Prefix of the variable names")
+	outFlag := fs.String("out", "", "File to write, like .env (default: standard output)")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted private key (pass:, env:, file:, or stdin)")
+	parseArgs(fs, args)
+
+	if *certFlag == "" && *keyFlag == "" && *chainFlag == "" {
+		return fmt.Errorf("export env requires --cert, --key, or --chain")
+	}
+	prefix := strings.ToUpper(*prefixFlag)
+	if !envPrefix.MatchString(prefix) {
+		return fmt.Errorf("Invalid --prefix %q: use letters, digits, and '_', not starting with a digit", *prefixFlag)
+	}
+
+	var buf bytes.Buffer
+	// Values are base64 of the PEM text, so `base64 -d` restores the original file
+	emit := func(name string, blocks []*pem.Block) {
+		var text []byte
+		for _, block := range blocks {
+			text = append(text, pem.EncodeToMemory(block)...)
+		}
+		fmt.Fprintf(&buf, "%s_%s=%s\n", prefix, name, base64.StdEncoding.EncodeToString(text))
+	}
+
+	if *certFlag != "" {
+		certs, err := readCertificates(*certFlag)
+		if err != nil {
+			return err
+		}
+		var blocks []*pem.Block
+		for _, cert := range certs {
+			blocks = append(blocks, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+		emit("CERT", blocks)
+	}
+
+	if *keyFlag != "" {
+		var password string
+		if *passinFlag != "" {
+			var err error
+			if password, err = readPassphrase(*passinFlag); err != nil {
+				return err
+			}
+		}
+		key, err := readPrivateKey(*keyFlag, password)
+		if err != nil {
+			return err
+		}
+		// Refuse to emit a pair that servers would reject at startup
+		if *certFlag != "" {
+			certs, err := readCertificates(*certFlag)
+			if err != nil {
+				return err
+			}
+			if signer, ok := key.(crypto.Signer); !ok || !certforge.SamePublicKey(certs[0].PublicKey, signer.Public()) {
+				return fmt.Errorf("Private key %s does not match certificate %s", *keyFlag, *certFlag)
+			}
+		}
+		// The key is always written decrypted, in PKCS#8
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("Failed to encode private key: %v", err)
+		}
+		emit("KEY", []*pem.Block{{Type: "PRIVATE KEY", Bytes: der}})
+	}
+
+	if *chainFlag != "" {
+		certs, err := readCertificates(*chainFlag)
+		if err != nil {
+			return err
+		}
+		var blocks []*pem.Block
+		for _, cert := range certs {
+			blocks = append(blocks, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+		emit("CA", blocks)
+	}
+
+	if *outFlag == "" {
+		os.Stdout.Write(buf.Bytes())
+		return nil
+	}
+	mode := os.FileMode(0644)
+	if *keyFlag != "" {
+		mode = 0600
+	}
+	if err := os.WriteFile(*outFlag, buf.Bytes(), mode); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+	}
+	fmt.Printf("Wrote %s\n", *outFlag)
+	return nil
+}
@@ -0,0 +1,259 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// Certificate lifecycle events posted to webhooks
+var certEventTypes = []string{
+	"certificate_issued",
+	"certificate_renewed",
+	"certificate_revoked",
+	"certificate_expiring",
+	"certificate_expired",
+}
+
+// eventDelays are the waits before retrying a failed delivery
+var eventDelays = []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute}
+
+// eventsConfig lists the webhooks certificate lifecycle events are posted to
+type eventsConfig struct {
+	Thresholds []string        `yaml:"thresholds"`
+	Webhooks   []*eventWebhook `yaml:"webhooks"`
+
+	thresholds []time.Duration
+}
+
+// eventWebhook is an endpoint receiving events, such as an inventory system or a SIEM collector
+type eventWebhook struct {
+	URL     string            `yaml:"url"`
+	Events  []string          `yaml:"events"`
+	Secret  string            `yaml:"secret"`
+	Headers map[string]string `yaml:"headers"`
+
+	secret []byte
+}
+
+// certEvent is the payload posted for an event
+type certEvent struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"`
+	Host      string    `json:"host"`
+	Name      string    `json:"name,omitempty"`
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	Domains   []string  `json:"domains,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	DaysLeft  int       `json:"days_left"`
+	Path      string    `json:"path,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// loadEventsConfig reads a file holding only an events config, as used by serve --events
+func loadEventsConfig(path string) (*eventsConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	cfg := &eventsConfig{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// validate checks the webhooks, reads their secrets, and parses the expiry thresholds
+func (c *eventsConfig) validate() error {
+	if len(c.Webhooks) == 0 {
+		return fmt.Errorf("events needs at least one webhook")
+	}
+	for _, hook := range c.Webhooks {
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid events webhook url %q", hook.URL)
+		}
+		for _, event := range hook.Events {
+			if !contains(certEventTypes, event) {
+				return fmt.Errorf("Unknown event %q for %s (use %s)", event, hook.URL, strings.Join(certEventTypes, ", "))
+			}
+		}
+		// Secrets are read like passphrases, so the config need not hold them
+		if hook.Secret != "" {
+			secret, err := readPassphrase(hook.Secret)
+			if err != nil {
+				return fmt.Errorf("Secret of %s: %v", hook.URL, err)
+			}
+			hook.secret = []byte(secret)
+		}
+	}
+	var err error
+	if c.thresholds, err = parseExpiryThresholds(c.Thresholds); err != nil {
+		return fmt.Errorf("Invalid events threshold: %v", err)
+	}
+	return nil
+}
+
+// newCertEvent describes cert for an event raised by the source command
+func newCertEvent(event, source, name string, cert *x509.Certificate) certEvent {
+	host, _ := os.Hostname()
+	id := make([]byte, 16)
+	rand.Read(id)
+	domains := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		domains = append(domains, ip.String())
+	}
+	now := time.Now().UTC()
+	return certEvent{
+		ID:        hex.EncodeToString(id),
+		Event:     event,
+		Time:      now,
+		Source:    source,
+		Host:      host,
+		Name:      name,
+		Subject:   certforge.FormatName(cert.Subject),
+		Issuer:    certforge.FormatName(cert.Issuer),
+		Serial:    cert.SerialNumber.Text(16),
+		Domains:   domains,
+		NotBefore: cert.NotBefore.UTC(),
+		NotAfter:  cert.NotAfter.UTC(),
+		DaysLeft:  int(cert.NotAfter.Sub(now).Hours() / 24),
+	}
+}
+
+// eventEmitter delivers events in the background, and remembers the expiry thresholds already reported
+type eventEmitter struct {
+	mu       sync.Mutex
+	expiring map[string]int
+	pending  sync.WaitGroup
+}
+
+// newEventEmitter returns an emitter with nothing reported yet; one that tracks no expiry, as for a single renewal
+// pass that would repeat the report at every run, only delivers the events it is given
+func newEventEmitter(trackExpiry bool) *eventEmitter {
+	if !trackExpiry {
+		return &eventEmitter{}
+	}
+	return &eventEmitter{expiring: map[string]int{}}
+}
+
+// emit posts the event to every webhook subscribed to it, retrying failures without blocking the caller
+func (e *eventEmitter) emit(cfg *eventsConfig, event certEvent) {
+	if e == nil || cfg == nil {
+		return
+	}
+	payload, _ := json.Marshal(event)
+	for _, hook := range cfg.Webhooks {
+		if len(hook.Events) > 0 && !contains(hook.Events, event.Event) {
+			continue
+		}
+		e.pending.Add(1)
+		go func(hook *eventWebhook) {
+			defer e.pending.Done()
+			err := hook.post(event, payload)
+			for _, delay := range eventDelays {
+				if err == nil {
+					return
+				}
+				time.Sleep(delay)
+				err = hook.post(event, payload)
+			}
+			if err != nil {
+				daemonLogf("Event %s for %s not delivered to %s: %v", event.Event, event.Subject, hook.URL, err)
+			}
+		}(hook)
+	}
+}
+
+// checkExpiry emits certificate_expiring, or certificate_expired, when cert has crossed a threshold not yet reported
+func (e *eventEmitter) checkExpiry(cfg *eventsConfig, source, name, path string, cert *x509.Certificate, now time.Time) {
+	if e == nil || cfg == nil || e.expiring == nil {
+		return
+	}
+	// A renewed certificate has a new serial, and starts over
+	id := path + "\x00" + cert.SerialNumber.String()
+	level := thresholdLevel(cfg.thresholds, cert.NotAfter.Sub(now))
+	e.mu.Lock()
+	reported := e.expiring[id]
+	if level > reported {
+		e.expiring[id] = level
+	}
+	e.mu.Unlock()
+	if level == 0 || level <= reported {
+		return
+	}
+
+	event := newCertEvent("certificate_expiring", source, name, cert)
+	if !now.Before(cert.NotAfter) {
+		event.Event = "certificate_expired"
+	}
+	event.Path = path
+	e.emit(cfg, event)
+}
+
+// wait blocks until every event has been delivered or given up on, for commands that exit after a pass
+func (e *eventEmitter) wait() {
+	if e != nil {
+		e.pending.Wait()
+	}
+}
+
+// post sends one event, signed with HMAC-SHA256 of the body when the webhook has a secret
+func (hook *eventWebhook) post(event certEvent, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "certforge")
+	req.Header.Set("X-Certforge-Event", event.Event)
+	req.Header.Set("X-Certforge-Delivery", event.ID)
+	if hook.secret != nil {
+		mac := hmac.New(sha256.New, hook.secret)
+		mac.Write(payload)
+		req.Header.Set("X-Certforge-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	for name, value := range hook.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", hook.URL, resp.Status)
+	}
+	return nil
+}
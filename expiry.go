@@ -0,0 +1,70 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// expiryLevel classifies how urgent a certificate's expiry is
+type expiryLevel int
+
+const (
+	expiryOK expiryLevel = iota
+	expiryWarning
+	expiryExpired
+)
+
+// ANSI color codes used to highlight expiry status on terminals
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorGreen  = "\033[32m"
+)
+
+// describeExpiry returns a readable remaining validity and how urgent it is
+func describeExpiry(cert *x509.Certificate, now time.Time, warnDays int) (string, expiryLevel) {
+	if now.Before(cert.NotBefore) {
+		return fmt.Sprintf("NOT YET VALID (starts in %d days)", certforge.DaysUntil(cert.NotBefore, now)), expiryWarning
+	}
+
+	days := certforge.DaysUntil(cert.NotAfter, now)
+	switch {
+	case now.After(cert.NotAfter):
+		return fmt.Sprintf("EXPIRED %d days ago", -days), expiryExpired
+	case days < warnDays:
+		return fmt.Sprintf("expires in %d days (within the %d day warning window)", days, warnDays), expiryWarning
+	}
+	return fmt.Sprintf("expires in %d days", days), expiryOK
+}
+
+// colorize wraps text in the color for the given level when stdout is a terminal
+func colorize(text string, level expiryLevel) string {
+	if !useColor() {
+		return text
+	}
+	switch level {
+	case expiryExpired:
+		return colorRed + text + colorReset
+	case expiryWarning:
+		return colorYellow + text + colorReset
+	}
+	return colorGreen + text + colorReset
+}
+
+// useColor reports whether output should be colored. NO_COLOR disables it.
+func useColor() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
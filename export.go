@@ -0,0 +1,473 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// exportFormats maps the formats of the export command to their implementations
+var exportFormats = map[string]func(args []string) error{
+	"p12":           runExportPKCS12,
+	"jks":           runExportJKS,
+	"p7b":           runExportPKCS7,
+	"jwk":           runExportJWK,
+	"acm":           runExportACM,
+	"terraform":     runExportTerraform,
+	"ansible-vault": runExportAnsibleVault,
+	"env":           runExportEnv,
+}
+
+// runExport implements the export command, which dispatches on the output format, falling back to the formats
+// registered with the library and export plugins
+func runExport(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("export requires a format: %s", strings.Join(exportFormatNames(), ", "))
+	}
+	export, ok := exportFormats[args[0]]
+	if !ok {
+		return runExportFormat(args[0], args[1:])
+	}
+	return export(args[1:])
+}
+
+// runExportPKCS12 implements export p12
+func runExportPKCS12(args []string) error {
+	fs := flag.NewFlagSet("export p12", flag.ExitOnError)
+	certFlag := fs.String("cert", "", "Certificate to export")
+	keyFlag := fs.String("key", "", "Private key of the certificate")
+	chainFlag := fs.String("chain", "", "Intermediate and root certificates to include")
+	outFlag := fs.String("out", "", "PKCS#12 file to write")
+	nameFlag := fs.String("name", "", "Friendly name shown by the importing application")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted private key (pass:, env:, file:, or stdin)")
+	passoutFlag := fs.String("passout", "", "Passphrase source for the PKCS#12 file (pass:, env:, file:, stdin, generate, or generate:<file>)")
+	allowWeakFlag := fs.Bool("allow-weak-pass", false, "Accept a --passout passphrase that is easy to guess")
+	legacyFlag := fs.Bool("legacy", false, "Use 3DES, RC2, and SHA-1 for older Windows and Java versions")
+	parseArgs(fs, args)
+
+	if *certFlag == "" || *keyFlag == "" || *outFlag == "" {
+		return fmt.Errorf("export p12 requires --cert, --key, and --out")
+	}
+	if *passoutFlag == "" {
+		return fmt.Errorf("export p12 requires --passout (use pass: and --allow-weak-pass for an empty password)")
+	}
+	if *legacyFlag {
+		if err := certforge.CheckAlgorithms("--legacy PKCS#12 files, encrypted with 3DES and RC2 and checked with SHA-1", "3des", "rc2", "sha1"); err != nil {
+			return err
+		}
+	}
+	var keyPassword string
+	var err error
+	if *passinFlag != "" {
+		if keyPassword, err = readPassphrase(*passinFlag); err != nil {
+			return err
+		}
+	}
+
+	cert, key, chain, err := readExportInputs(*certFlag, *keyFlag, *chainFlag, keyPassword)
+	if err != nil {
+		return err
+	}
+	// The inputs are read first, so a generated passphrase is not shown or saved for a file that is never written
+	password, err := readNewPassphrase(*passoutFlag, *allowWeakFlag)
+	if err != nil {
+		return err
+	}
+
+	data, err := encodePKCS12(key, cert, chain, password, *nameFlag, *legacyFlag)
+	if err != nil {
+		return fmt.Errorf("Failed to encode PKCS#12 file: %v", err)
+	}
+	if err := os.WriteFile(*outFlag, data, 0600); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+	}
+
+	encryption := "AES-256-CBC, HMAC-SHA256"
+	if *legacyFlag {
+		encryption = "3DES and RC2-40, HMAC-SHA1 (legacy)"
+	}
+	fmt.Printf("Wrote %s\n", *outFlag)
+	fmt.Printf("  Certificate: %s\n", certforge.FormatName(cert.Subject))
+	fmt.Printf("  Chain Certificates: %d\n", len(chain))
+	fmt.Printf("  Encryption: %s\n", encryption)
+	return nil
+}
+
+// runExportJKS implements export jks
+func runExportJKS(args []string) error {
+	fs := flag.NewFlagSet("export jks", flag.ExitOnError)
+	certFlag := fs.String("cert", "", "Certificate to export")
+	keyFlag := fs.String("key", "", "Private key of the certificate")
+	chainFlag := fs.String("chain", "", "Intermediate and root certificates to include")
+	outFlag := fs.String("out", "", "Keystore file to write")
+	aliasFlag := fs.String("alias", "mykey", "Alias of the private key entry")
+	truststoreFlag := fs.String("truststore", "", "Also write a truststore holding the chain certificates")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted private key (pass:, env:, file:, or stdin)")
+	passoutFlag := fs.String("passout", "", "Passphrase source for the keystore (pass:, env:, file:, stdin, generate, or generate:<file>)")
+	allowWeakFlag := fs.Bool("allow-weak-pass", false, "Accept a --passout passphrase that is easy to guess")
+	parseArgs(fs, args)
+
+	if *certFlag == "" || *keyFlag == "" || *outFlag == "" {
+		return fmt.Errorf("export jks requires --cert, --key, and --out")
+	}
+	if err := certforge.CheckAlgorithms("JKS keystores, whose integrity check uses SHA-1 (use export p12)", "sha1"); err != nil {
+		return err
+	}
+	if *passoutFlag == "" {
+		return fmt.Errorf("export jks requires --passout")
+	}
+	var keyPassword string
+	var err error
+	if *passinFlag != "" {
+		if keyPassword, err = readPassphrase(*passinFlag); err != nil {
+			return err
+		}
+	}
+
+	cert, key, chain, err := readExportInputs(*certFlag, *keyFlag, *chainFlag, keyPassword)
+	if err != nil {
+		return err
+	}
+	password, err := readNewPassphrase(*passoutFlag, *allowWeakFlag)
+	if err != nil {
+		return err
+	}
+	// keytool refuses keystore passwords shorter than six characters
+	if len(password) < 6 {
+		return fmt.Errorf("Keystore password must be at least 6 characters")
+	}
+
+	keystore, err := encodeJKS([]jksEntry{{Alias: *aliasFlag, Key: key, Chain: append([]*x509.Certificate{cert}, chain...)}}, password)
+	if err != nil {
+		return fmt.Errorf("Failed to encode keystore: %v", err)
+	}
+	if err := os.WriteFile(*outFlag, keystore, 0600); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+	}
+	fmt.Printf("Wrote %s\n", *outFlag)
+	fmt.Printf("  Alias: %s\n", strings.ToLower(*aliasFlag))
+	fmt.Printf("  Certificate: %s\n", certforge.FormatName(cert.Subject))
+	fmt.Printf("  Chain Certificates: %d\n", len(chain))
+
+	if *truststoreFlag == "" {
+		return nil
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("A truststore needs CA certificates (use --chain)")
+	}
+	var trusted []jksEntry
+	for i, c := range chain {
+		trusted = append(trusted, jksEntry{Alias: fmt.Sprintf("ca-%d", i+1), Chain: []*x509.Certificate{c}})
+	}
+	truststore, err := encodeJKS(trusted, password)
+	if err != nil {
+		return fmt.Errorf("Failed to encode truststore: %v", err)
+	}
+	if err := os.WriteFile(*truststoreFlag, truststore, 0644); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", *truststoreFlag, err)
+	}
+	fmt.Printf("Wrote %s\n", *truststoreFlag)
+	for _, entry := range trusted {
+		fmt.Printf("  %s: %s\n", entry.Alias, certforge.FormatName(entry.Chain[0].Subject))
+	}
+	return nil
+}
+
+// runExportPKCS7 implements export p7b
+func runExportPKCS7(args []string) error {
+	fs := flag.NewFlagSet("export p7b", flag.ExitOnError)
+	certFlag := fs.String("cert", "", "Certificate to export")
+	chainFlag := fs.String("chain", "", "Intermediate and root certificates to include")
+	outFlag := fs.String("out", "", "PKCS#7 file to write")
+	outformFlag := fs.String("outform", "pem", "Encoding of the bundle: pem or der")
+	parseArgs(fs, args)
+
+	if *certFlag == "" || *outFlag == "" {
+		return fmt.Errorf("export p7b requires --cert and --out")
+	}
+	outform := strings.ToLower(*outformFlag)
+	if outform != "pem" && outform != "der" {
+		return fmt.Errorf("Invalid --outform %q (use pem or der)", *outformFlag)
+	}
+
+	certs, err := readCertificates(*certFlag)
+	if err != nil {
+		return err
+	}
+	if *chainFlag != "" {
+		chain, err := readCertificates(*chainFlag)
+		if err != nil {
+			return err
+		}
+		for _, c := range chain {
+			if !containsCertificate(certs, c) {
+				certs = append(certs, c)
+			}
+		}
+	}
+
+	der, err := encodePKCS7(certs)
+	if err != nil {
+		return fmt.Errorf("Failed to encode PKCS#7 bundle: %v", err)
+	}
+	f, err := os.Create(*outFlag)
+	if err != nil {
+		return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+	}
+	defer f.Close()
+	if err := writeEncoded(f, &pem.Block{Type: "PKCS7", Bytes: der}, outform); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+	}
+
+	fmt.Printf("Wrote %s\n", *outFlag)
+	for i, cert := range certs {
+		fmt.Printf("  %d: %s\n", i+1, certforge.FormatName(cert.Subject))
+	}
+	return nil
+}
+
+// runExportJWK implements export jwk
+func runExportJWK(args []string) error {
+	fs := flag.NewFlagSet("export jwk", flag.ExitOnError)
+	keyFlag := fs.String("key", "", "Private key to export")
+	certFlag := fs.String("cert", "", "Certificate of the key, added as x5c, x5t, and x5t#S256")
+	chainFlag := fs.String("chain", "", "Intermediate certificates to append to x5c")
+	outFlag := fs.String("out", "", "File to write (default: standard output)")
+	kidFlag := fs.String("kid", "", "Key ID (default: the RFC 7638 thumbprint)")
+	useFlag := fs.String("use", "sig", "Public key use: sig, enc, or empty to omit")
+	algFlag := fs.String("alg", "", "Algorithm (default: derived from the key when use is sig)")
+	publicFlag := fs.Bool("public", false, "Only export the public key")
+	setFlag := fs.Bool("set", false, "Wrap the key in a JWK Set ({\"keys\": [...]})")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted private key (pass:, env:, file:, or stdin)")
+	parseArgs(fs, args)
+
+	if *keyFlag == "" && *certFlag == "" {
+		return fmt.Errorf("export jwk requires --key or --cert")
+	}
+	var keyPassword string
+	if *passinFlag != "" {
+		var err error
+		if keyPassword, err = readPassphrase(*passinFlag); err != nil {
+			return err
+		}
+	}
+
+	var pub crypto.PublicKey
+	var priv crypto.PrivateKey
+	var certs []*x509.Certificate
+	switch {
+	case *keyFlag != "" && *certFlag != "":
+		cert, key, chain, err := readExportInputs(*certFlag, *keyFlag, *chainFlag, keyPassword)
+		if err != nil {
+			return err
+		}
+		pub, priv, certs = cert.PublicKey, key, append([]*x509.Certificate{cert}, chain...)
+	case *keyFlag != "":
+		key, err := readPrivateKey(*keyFlag, keyPassword)
+		if err != nil {
+			return err
+		}
+		signer, ok := key.(interface{ Public() crypto.PublicKey })
+		if !ok {
+			return fmt.Errorf("Unsupported private key type: %T", key)
+		}
+		pub, priv = signer.Public(), key
+	default:
+		// Without a key only the public JWK of the certificate can be exported
+		var err error
+		if certs, err = readCertificates(*certFlag); err != nil {
+			return err
+		}
+		if *chainFlag != "" {
+			chain, err := readCertificates(*chainFlag)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, chain...)
+		}
+		pub = certs[0].PublicKey
+	}
+	if *publicFlag {
+		priv = nil
+	}
+
+	jwk, err := encodeJWK(pub, priv)
+	if err != nil {
+		return err
+	}
+	jwk.Use = *useFlag
+	jwk.Alg = *algFlag
+	if jwk.Alg == "" && jwk.Use == "sig" {
+		jwk.Alg = jwkSigningAlgorithm(jwk)
+	}
+	jwk.Kid = *kidFlag
+	if jwk.Kid == "" {
+		if jwk.Kid, err = jwkThumbprint(jwk); err != nil {
+			return err
+		}
+	}
+	if len(certs) > 0 {
+		for _, cert := range certs {
+			jwk.X5c = append(jwk.X5c, base64.StdEncoding.EncodeToString(cert.Raw))
+		}
+		sha1Sum := sha1.Sum(certs[0].Raw)
+		sha256Sum := sha256.Sum256(certs[0].Raw)
+		jwk.X5t = base64.RawURLEncoding.EncodeToString(sha1Sum[:])
+		jwk.X5tS256 = base64.RawURLEncoding.EncodeToString(sha256Sum[:])
+	}
+
+	var value interface{} = jwk
+	if *setFlag {
+		value = struct {
+			Keys []jsonWebKey `json:"keys"`
+		}{[]jsonWebKey{jwk}}
+	}
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if *outFlag == "" {
+		os.Stdout.Write(data)
+		return nil
+	}
+	mode := os.FileMode(0644)
+	if priv != nil {
+		mode = 0600
+	}
+	if err := os.WriteFile(*outFlag, data, mode); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+	}
+	fmt.Printf("Wrote %s\n", *outFlag)
+	fmt.Printf("  Key ID: %s\n", jwk.Kid)
+	if priv != nil {
+		fmt.Println("  Private Key: included")
+	} else {
+		fmt.Println("  Private Key: not included")
+	}
+	return nil
+}
+
+// readExportInputs loads a certificate, its private key, and an optional chain, and checks that the key matches
+func readExportInputs(certPath, keyPath, chainPath, keyPassword string) (*x509.Certificate, crypto.PrivateKey, []*x509.Certificate, error) {
+	certs, err := readCertificates(certPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert := certs[0]
+	// Extra certificates in the certificate file are treated as the chain
+	chain := certs[1:]
+
+	key, err := readPrivateKey(keyPath, keyPassword)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || !certforge.SamePublicKey(cert.PublicKey, signer.Public()) {
+		return nil, nil, nil, fmt.Errorf("Private key %s does not match certificate %s", keyPath, certPath)
+	}
+
+	if chainPath != "" {
+		extra, err := readCertificates(chainPath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, c := range extra {
+			if !c.Equal(cert) && !containsCertificate(chain, c) {
+				chain = append(chain, c)
+			}
+		}
+	}
+	return cert, key, chain, nil
+}
+
+// readPrivateKey loads a PEM or DER private key, decrypting PKCS#8 encrypted keys with password
+func readPrivateKey(path, password string) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	defer certforge.Zeroize(data)
+	key, err := parsePrivateKeyData(data, password)
+	if err == errPKCS12Password {
+		return nil, fmt.Errorf("Incorrect passphrase for %s (use --passin to supply one)", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to parse private key in %s: %v", path, err)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("No private key found in %s", path)
+	}
+	return key, nil
+}
+
+// parsePrivateKeyData returns the first private key in PEM data, or the key in DER data.
+// It returns nil without an error when there is no private key.
+func parsePrivateKeyData(data []byte, password string) (crypto.PrivateKey, error) {
+	found := false
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		found = true
+
+		switch block.Type {
+		case "ENCRYPTED PRIVATE KEY":
+			return decryptPKCS8(block.Bytes, password)
+		case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY":
+			defer certforge.Zeroize(block.Bytes)
+			return certforge.ParsePrivateKeyBlock(block)
+		case tpmKeyPEMType:
+			return parseTPMKey(block.Bytes)
+		}
+	}
+	if found {
+		return nil, nil
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(data); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(data); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(data); err == nil {
+		return key, nil
+	}
+	return nil, nil
+}
+
+// decryptPKCS8 decrypts a DER encoded PKCS#8 EncryptedPrivateKeyInfo
+func decryptPKCS8(der []byte, password string) (crypto.PrivateKey, error) {
+	var info pkcs8EncryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("Failed to parse encrypted private key: %v", err)
+	}
+	plain, err := pbeDecrypt(info.Algorithm, info.Data, password, bmpString(password))
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(plain)
+	certforge.Zeroize(plain)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse private key: %v", err)
+	}
+	return key, nil
+}
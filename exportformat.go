@@ -0,0 +1,198 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// exportPluginPrefix starts the names of executables on PATH that add export formats, like certforge-export-vault
+const exportPluginPrefix = "certforge-export-"
+
+// exportPlugin is an export format implemented by an external executable. It is run with the arguments given after
+// --, reads the artifacts as JSON on standard input, and writes the formatted output to standard output.
+type exportPlugin struct {
+	name string
+	path string
+	args []string
+}
+
+// exportPluginInput is the JSON an export plugin reads; every field is PEM, and empty when not given
+type exportPluginInput struct {
+	Format      string `json:"format"`
+	Certificate string `json:"certificate,omitempty"`
+	Chain       string `json:"chain,omitempty"`
+	Key         string `json:"key,omitempty"`
+}
+
+func (p exportPlugin) Name() string         { return p.name }
+func (p exportPlugin) Extensions() []string { return nil }
+
+func (p exportPlugin) Write(w io.Writer, artifacts *certforge.Artifacts) error {
+	input, err := json.Marshal(exportPluginInput{
+		Format:      p.name,
+		Certificate: string(artifacts.CertificatePEM),
+		Chain:       string(certforge.EncodeChain(artifacts.Chain)),
+		Key:         string(artifacts.KeyPEM),
+	})
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(p.path, p.args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Export plugin %s failed: %v", p.path, err)
+	}
+	return nil
+}
+
+// exportPluginNames lists the formats of the export plugins on PATH
+func exportPluginNames() []string {
+	var names []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		matches, _ := filepath.Glob(filepath.Join(dir, exportPluginPrefix+"*"))
+		for _, match := range matches {
+			name := strings.TrimPrefix(filepath.Base(match), exportPluginPrefix)
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 && !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupExportFormat finds a format registered with the library or, failing that, an export plugin on PATH
+func lookupExportFormat(name string, pluginArgs []string) (certforge.Formatter, bool) {
+	if f, ok := certforge.LookupFormat(name); ok {
+		return f, true
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return nil, false
+	}
+	path, err := exec.LookPath(exportPluginPrefix + name)
+	if err != nil {
+		return nil, false
+	}
+	return exportPlugin{name: name, path: path, args: pluginArgs}, true
+}
+
+// runExportFormat implements export for formats registered with the library and export plugins. Arguments after
+// -- are passed to plugins.
+func runExportFormat(name string, args []string) error {
+	var pluginArgs []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, pluginArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+	f, ok := lookupExportFormat(name, pluginArgs)
+	if !ok {
+		return fmt.Errorf("Unknown export format %q (use %s)", name, strings.Join(exportFormatNames(), ", "))
+	}
+
+	fs := flag.NewFlagSet("export "+name, flag.ExitOnError)
+	certFlag := fs.String("cert", "", "Certificate to export; further certificates in the file are its chain")
+	keyFlag := fs.String("key", "", "Private key of the certificate, for formats that include it")
+	chainFlag := fs.String("chain", "", "Intermediate and root certificates to include")
+	outFlag := fs.String("out", "", "File to write (default: standard output)")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted private key (pass:, env:, file:, or stdin)")
+	parseArgs(fs, args)
+
+	if *certFlag == "" {
+		return fmt.Errorf("export %s requires --cert", name)
+	}
+	artifacts := &certforge.Artifacts{}
+	var cert *x509.Certificate
+	var chain []*x509.Certificate
+	if *keyFlag != "" {
+		var password string
+		var err error
+		if *passinFlag != "" {
+			if password, err = readPassphrase(*passinFlag); err != nil {
+				return err
+			}
+		}
+		var key crypto.PrivateKey
+		if cert, key, chain, err = readExportInputs(*certFlag, *keyFlag, *chainFlag, password); err != nil {
+			return err
+		}
+		if artifacts.KeyPEM, err = certforge.MarshalPrivateKey(key); err != nil {
+			return err
+		}
+		artifacts.Key = key.(crypto.Signer)
+	} else {
+		certs, err := readCertificates(*certFlag)
+		if err != nil {
+			return err
+		}
+		cert, chain = certs[0], certs[1:]
+		if *chainFlag != "" {
+			extra, err := readCertificates(*chainFlag)
+			if err != nil {
+				return err
+			}
+			for _, c := range extra {
+				if !c.Equal(cert) && !containsCertificate(chain, c) {
+					chain = append(chain, c)
+				}
+			}
+		}
+	}
+	artifacts.Certificate = cert.Raw
+	for _, c := range chain {
+		artifacts.Chain = append(artifacts.Chain, c.Raw)
+	}
+	artifacts.CertificatePEM = certforge.EncodeChain([][]byte{artifacts.Certificate})
+
+	var buf bytes.Buffer
+	if err := f.Write(&buf, artifacts); err != nil {
+		return err
+	}
+	if *outFlag == "" {
+		os.Stdout.Write(buf.Bytes())
+		return nil
+	}
+	mode := os.FileMode(0644)
+	if *keyFlag != "" {
+		mode = 0600
+	}
+	if err := os.WriteFile(*outFlag, buf.Bytes(), mode); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+	}
+	fmt.Printf("Wrote %s (%s)\n", *outFlag, name)
+	return nil
+}
+
+// exportFormatNames lists the built-in export formats, those registered with the library, and the plugins on PATH
+func exportFormatNames() []string {
+	var names []string
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	for _, name := range append(certforge.Formats(), exportPluginNames()...) {
+		if !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,567 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// OIDs of the X.509 extensions certforge knows how to describe
+var (
+	oidExtSubjectKeyID      = asn1.ObjectIdentifier{2, 5, 29, 14}
+	oidExtKeyUsage          = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtSubjectAltName    = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidExtIssuerAltName     = asn1.ObjectIdentifier{2, 5, 29, 18}
+	oidExtBasicConstraints  = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidExtNameConstraints   = asn1.ObjectIdentifier{2, 5, 29, 30}
+	oidExtCRLNumber         = asn1.ObjectIdentifier{2, 5, 29, 20}
+	oidExtDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidExtIssuingDistPoint  = asn1.ObjectIdentifier{2, 5, 29, 28}
+	oidExtCRLDistribution   = asn1.ObjectIdentifier{2, 5, 29, 31}
+	oidExtCertPolicies      = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidExtAuthorityKeyID    = asn1.ObjectIdentifier{2, 5, 29, 35}
+	oidExtExtendedKeyUsage  = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidExtAuthorityInfo     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 1}
+	oidExtSubjectInfoAccess = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 11}
+	oidExtTLSFeature        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	oidExtOCSPNoCheck       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+	oidExtSCTList           = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	oidExtCTPoison          = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+
+	// oidUserPrincipalName is the otherName type of Microsoft user principal names
+	oidUserPrincipalName = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+)
+
+// extensionNames maps extension OIDs to the labels OpenSSL uses for them
+var extensionNames = map[string]string{
+	oidExtSubjectKeyID.String():      "X509v3 Subject Key Identifier",
+	oidExtKeyUsage.String():          "X509v3 Key Usage",
+	oidExtSubjectAltName.String():    "X509v3 Subject Alternative Name",
+	oidExtIssuerAltName.String():     "X509v3 Issuer Alternative Name",
+	oidExtBasicConstraints.String():  "X509v3 Basic Constraints",
+	oidExtNameConstraints.String():   "X509v3 Name Constraints",
+	oidExtCRLNumber.String():         "X509v3 CRL Number",
+	oidExtDeltaCRLIndicator.String(): "X509v3 Delta CRL Indicator",
+	oidExtIssuingDistPoint.String():  "X509v3 Issuing Distribution Point",
+	oidExtCRLDistribution.String():   "X509v3 CRL Distribution Points",
+	oidExtCertPolicies.String():      "X509v3 Certificate Policies",
+	oidExtAuthorityKeyID.String():    "X509v3 Authority Key Identifier",
+	oidExtExtendedKeyUsage.String():  "X509v3 Extended Key Usage",
+	oidExtAuthorityInfo.String():     "Authority Information Access",
+	oidExtSubjectInfoAccess.String(): "Subject Information Access",
+	oidExtTLSFeature.String():        "TLS Feature",
+	oidExtOCSPNoCheck.String():       "OCSP No Check",
+	oidExtSCTList.String():           "CT Precertificate SCTs",
+	oidExtCTPoison.String():          "CT Precertificate Poison",
+}
+
+// extKeyUsageNames maps extended key usage OIDs to readable names
+var extKeyUsageNames = map[string]string{
+	"2.5.29.37.0":             "Any Extended Key Usage",
+	"1.3.6.1.5.5.7.3.1":       "TLS Web Server Authentication",
+	"1.3.6.1.5.5.7.3.2":       "TLS Web Client Authentication",
+	"1.3.6.1.5.5.7.3.3":       "Code Signing",
+	"1.3.6.1.5.5.7.3.4":       "E-mail Protection",
+	"1.3.6.1.5.5.7.3.5":       "IPSec End System",
+	"1.3.6.1.5.5.7.3.6":       "IPSec Tunnel",
+	"1.3.6.1.5.5.7.3.7":       "IPSec User",
+	"1.3.6.1.5.5.7.3.8":       "Time Stamping",
+	"1.3.6.1.5.5.7.3.9":       "OCSP Signing",
+	"1.3.6.1.4.1.311.20.2.2":  "Microsoft Smartcard Login",
+	"1.3.6.1.4.1.311.10.3.3":  "Microsoft Server Gated Crypto",
+	"2.16.840.1.113730.4.1":   "Netscape Server Gated Crypto",
+	"1.3.6.1.4.1.311.10.3.12": "Microsoft Document Signing",
+}
+
+// extKeyUsageLabels maps crypto/x509 extended key usages to their RFC 5280 names
+var extKeyUsageLabels = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "anyExtendedKeyUsage",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageIPSECEndSystem:  "ipsecEndSystem",
+	x509.ExtKeyUsageIPSECTunnel:     "ipsecTunnel",
+	x509.ExtKeyUsageIPSECUser:       "ipsecUser",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+// policyNames maps well-known certificate policy OIDs to readable labels
+var policyNames = map[string]string{
+	"2.5.29.32.0":                "anyPolicy",
+	"2.23.140.1.1":               "CA/B Forum Extended Validation (EV)",
+	"2.23.140.1.2.1":             "CA/B Forum Domain Validated (DV)",
+	"2.23.140.1.2.2":             "CA/B Forum Organization Validated (OV)",
+	"2.23.140.1.2.3":             "CA/B Forum Individual Validated (IV)",
+	"2.23.140.1.3":               "CA/B Forum EV Code Signing",
+	"2.23.140.1.4.1":             "CA/B Forum Code Signing",
+	"2.23.140.1.5.1.1":           "CA/B Forum S/MIME Mailbox Validated (legacy)",
+	"2.23.140.1.5.1.2":           "CA/B Forum S/MIME Mailbox Validated (multipurpose)",
+	"2.23.140.1.5.1.3":           "CA/B Forum S/MIME Mailbox Validated (strict)",
+	"2.23.140.1.5.2.1":           "CA/B Forum S/MIME Organization Validated (legacy)",
+	"2.23.140.1.5.2.2":           "CA/B Forum S/MIME Organization Validated (multipurpose)",
+	"2.23.140.1.5.2.3":           "CA/B Forum S/MIME Organization Validated (strict)",
+	"2.23.140.1.5.3.1":           "CA/B Forum S/MIME Sponsor Validated (legacy)",
+	"2.23.140.1.5.3.2":           "CA/B Forum S/MIME Sponsor Validated (multipurpose)",
+	"2.23.140.1.5.3.3":           "CA/B Forum S/MIME Sponsor Validated (strict)",
+	"2.23.140.1.5.4.1":           "CA/B Forum S/MIME Individual Validated (legacy)",
+	"2.23.140.1.5.4.2":           "CA/B Forum S/MIME Individual Validated (multipurpose)",
+	"2.23.140.1.5.4.3":           "CA/B Forum S/MIME Individual Validated (strict)",
+	"2.23.140.1.31":              "CA/B Forum Onion EV",
+	"1.3.6.1.4.1.44947.1.1.1":    "ISRG Domain Validated (Let's Encrypt)",
+	"1.3.6.1.4.1.11129.2.5.3":    "Google Trust Services",
+	"2.16.840.1.114412.1.1":      "DigiCert Organization Validated",
+	"2.16.840.1.114412.2.1":      "DigiCert Extended Validation",
+	"1.3.6.1.4.1.6449.1.2.1.5.1": "Sectigo Extended Validation",
+	"1.3.6.1.4.1.4146.1.1":       "GlobalSign Extended Validation",
+}
+
+// policyName returns the readable label of a certificate policy OID, or the OID itself
+func policyName(oid asn1.ObjectIdentifier) string {
+	if name, ok := policyNames[oid.String()]; ok {
+		return fmt.Sprintf("%s (%s)", name, oid)
+	}
+	return oid.String()
+}
+
+// certificatePolicies decodes the certificate policies extension into policy
+// labels, each followed by its CPS URIs and other qualifiers
+func certificatePolicies(value []byte) ([]string, error) {
+	var policies []struct {
+		Policy     asn1.ObjectIdentifier
+		Qualifiers []struct {
+			ID    asn1.ObjectIdentifier
+			Value asn1.RawValue
+		} `asn1:"optional"`
+	}
+	if _, err := asn1.Unmarshal(value, &policies); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, policy := range policies {
+		lines = append(lines, "Policy: "+policyName(policy.Policy))
+		for _, q := range policy.Qualifiers {
+			switch q.ID.String() {
+			case "1.3.6.1.5.5.7.2.1":
+				lines = append(lines, "  CPS: "+string(q.Value.Bytes))
+			case "1.3.6.1.5.5.7.2.2":
+				lines = append(lines, "  User Notice")
+			default:
+				lines = append(lines, "  Qualifier: "+q.ID.String())
+			}
+		}
+	}
+	return lines, nil
+}
+
+// keyUsageNames lists the key usage bits in the order they are defined
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Non Repudiation"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+// extensionName returns the readable name of an extension, or its OID if unknown
+func extensionName(oid asn1.ObjectIdentifier) string {
+	if name, ok := extensionNames[oid.String()]; ok {
+		return name
+	}
+	return oid.String()
+}
+
+// describeExtension renders the value of an extension as lines of text. The
+// second return value is false when the extension is not understood, in which
+// case the lines contain a hex dump of the raw value.
+func describeExtension(ext pkix.Extension) ([]string, bool) {
+	lines, err := decodeExtensionValue(ext)
+	if err != nil || lines == nil {
+		return hexDumpLines(ext.Value, 18), false
+	}
+	return lines, true
+}
+
+// decodeExtensionValue decodes the extensions certforge understands.
+// It returns nil lines for unknown extensions.
+func decodeExtensionValue(ext pkix.Extension) ([]string, error) {
+	switch {
+	case ext.Id.Equal(oidExtSubjectKeyID):
+		var keyID []byte
+		if _, err := asn1.Unmarshal(ext.Value, &keyID); err != nil {
+			return nil, err
+		}
+		return []string{colonHex(keyID)}, nil
+
+	case ext.Id.Equal(oidExtAuthorityKeyID):
+		var aki struct {
+			KeyID  []byte        `asn1:"optional,tag:0"`
+			Issuer asn1.RawValue `asn1:"optional,tag:1"`
+			Serial asn1.RawValue `asn1:"optional,tag:2"`
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &aki); err != nil {
+			return nil, err
+		}
+		var lines []string
+		if len(aki.KeyID) > 0 {
+			lines = append(lines, colonHex(aki.KeyID))
+		}
+		if len(aki.Issuer.Bytes) > 0 {
+			names, err := parseGeneralNames(aki.Issuer.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, strings.Join(names, ", "))
+		}
+		if len(aki.Serial.Bytes) > 0 {
+			lines = append(lines, "serial:"+colonHex(aki.Serial.Bytes))
+		}
+		return lines, nil
+
+	case ext.Id.Equal(oidExtKeyUsage):
+		var bits asn1.BitString
+		if _, err := asn1.Unmarshal(ext.Value, &bits); err != nil {
+			return nil, err
+		}
+		var usage x509.KeyUsage
+		for i := 0; i < 9; i++ {
+			if bits.At(i) != 0 {
+				usage |= x509.KeyUsage(1 << uint(i))
+			}
+		}
+		return []string{strings.Join(keyUsageList(usage), ", ")}, nil
+
+	case ext.Id.Equal(oidExtExtendedKeyUsage):
+		var oids []asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(ext.Value, &oids); err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, oid := range oids {
+			names = append(names, extKeyUsageName(oid))
+		}
+		return []string{strings.Join(names, ", ")}, nil
+
+	case ext.Id.Equal(oidExtBasicConstraints):
+		var bc struct {
+			IsCA       bool `asn1:"optional"`
+			MaxPathLen int  `asn1:"optional,default:-1"`
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &bc); err != nil {
+			return nil, err
+		}
+		line := fmt.Sprintf("CA:%s", strings.ToUpper(fmt.Sprint(bc.IsCA)))
+		if bc.MaxPathLen >= 0 {
+			line += fmt.Sprintf(", pathlen:%d", bc.MaxPathLen)
+		}
+		return []string{line}, nil
+
+	case ext.Id.Equal(oidExtSubjectAltName), ext.Id.Equal(oidExtIssuerAltName):
+		var seq asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &seq); err != nil {
+			return nil, err
+		}
+		names, err := parseGeneralNames(seq.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return []string{strings.Join(names, ", ")}, nil
+
+	case ext.Id.Equal(oidExtCRLNumber), ext.Id.Equal(oidExtDeltaCRLIndicator):
+		var number *big.Int
+		if _, err := asn1.Unmarshal(ext.Value, &number); err != nil {
+			return nil, err
+		}
+		return []string{number.String()}, nil
+
+	case ext.Id.Equal(oidExtCRLDistribution):
+		var points []struct {
+			DistributionPoint asn1.RawValue  `asn1:"optional,tag:0"`
+			Reasons           asn1.BitString `asn1:"optional,tag:1"`
+			CRLIssuer         asn1.RawValue  `asn1:"optional,tag:2"`
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &points); err != nil {
+			return nil, err
+		}
+		var lines []string
+		for _, point := range points {
+			// Only the fullName form of the distribution point name is decoded
+			var fullName asn1.RawValue
+			if _, err := asn1.Unmarshal(point.DistributionPoint.Bytes, &fullName); err != nil || fullName.Tag != 0 {
+				continue
+			}
+			names, err := parseGeneralNames(fullName.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, "Full Name:")
+			for _, name := range names {
+				lines = append(lines, "  "+name)
+			}
+		}
+		return lines, nil
+
+	case ext.Id.Equal(oidExtAuthorityInfo), ext.Id.Equal(oidExtSubjectInfoAccess):
+		var descriptions []struct {
+			Method   asn1.ObjectIdentifier
+			Location asn1.RawValue
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &descriptions); err != nil {
+			return nil, err
+		}
+		var lines []string
+		for _, desc := range descriptions {
+			lines = append(lines, fmt.Sprintf("%s - %s", accessMethodName(desc.Method), formatGeneralName(desc.Location)))
+		}
+		return lines, nil
+
+	case ext.Id.Equal(oidExtCertPolicies):
+		return certificatePolicies(ext.Value)
+
+	case ext.Id.Equal(oidExtOCSPNoCheck), ext.Id.Equal(oidExtCTPoison):
+		return []string{"NULL"}, nil
+
+	case ext.Id.Equal(oidExtTLSFeature):
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return nil, err
+		}
+		var lines []string
+		for _, feature := range features {
+			lines = append(lines, tlsFeatureName(feature))
+		}
+		return lines, nil
+	}
+
+	return nil, nil
+}
+
+// tlsFeatureName names a TLS extension listed in a TLS Feature extension
+func tlsFeatureName(feature int) string {
+	switch feature {
+	case 5:
+		return "status_request (OCSP Must-Staple)"
+	case 17:
+		return "status_request_v2"
+	}
+	return fmt.Sprintf("TLS extension %d", feature)
+}
+
+// printExtensionsSummary lists every extension with its OID and criticality.
+// Extensions certforge cannot decode are dumped in hex and base64, and
+// unrecognized critical extensions are flagged since validators must reject them.
+func printExtensionsSummary(exts []pkix.Extension) {
+	if len(exts) == 0 {
+		return
+	}
+
+	fmt.Println("\nExtensions:")
+	var unknownCritical []string
+	for _, ext := range exts {
+		name, known := extensionNames[ext.Id.String()]
+		if !known {
+			name = "unrecognized"
+		}
+		critical := ""
+		if ext.Critical {
+			critical = ", critical"
+		}
+		fmt.Printf("  %s (%s%s)\n", ext.Id, name, critical)
+
+		if _, decoded := describeExtension(ext); !decoded {
+			fmt.Printf("    Value (hex): %s\n", strings.ToLower(colonHex(ext.Value)))
+			fmt.Printf("    Value (base64): %s\n", base64.StdEncoding.EncodeToString(ext.Value))
+		}
+		if ext.Critical && !known {
+			unknownCritical = append(unknownCritical, ext.Id.String())
+		}
+	}
+
+	for _, oid := range unknownCritical {
+		fmt.Printf("\n!!! WARNING: unrecognized CRITICAL extension %s\n", oid)
+		fmt.Println("!!! Relying parties that do not understand it must reject this certificate")
+	}
+}
+
+// parseGeneralNames decodes the contents of a GeneralNames SEQUENCE
+func parseGeneralNames(data []byte) ([]string, error) {
+	var names []string
+	for len(data) > 0 {
+		var name asn1.RawValue
+		var err error
+		data, err = asn1.Unmarshal(data, &name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, formatGeneralName(name))
+	}
+	return names, nil
+}
+
+// formatGeneralName formats a single GeneralName the way OpenSSL prints it
+func formatGeneralName(name asn1.RawValue) string {
+	if name.Class != asn1.ClassContextSpecific {
+		return fmt.Sprintf("<unsupported name class %d>", name.Class)
+	}
+
+	switch name.Tag {
+	case 0:
+		var other struct {
+			ID    asn1.ObjectIdentifier
+			Value asn1.RawValue `asn1:"explicit,tag:0"`
+		}
+		if _, err := asn1.Unmarshal(append([]byte{0x30}, name.FullBytes[1:]...), &other); err != nil {
+			return "othername:<unparsable>"
+		}
+		var upn string
+		if other.ID.Equal(oidUserPrincipalName) {
+			if _, err := asn1.UnmarshalWithParams(other.Value.Bytes, &upn, "utf8"); err == nil {
+				return "othername:UPN::" + upn
+			}
+		}
+		return fmt.Sprintf("othername:%s::%s", other.ID, colonHex(other.Value.Bytes))
+	case 1:
+		return "email:" + string(name.Bytes)
+	case 2:
+		return "DNS:" + string(name.Bytes)
+	case 4:
+		var rdns pkix.RDNSequence
+		if _, err := asn1.Unmarshal(name.Bytes, &rdns); err != nil {
+			return "DirName:<unparsable>"
+		}
+		var dn pkix.Name
+		dn.FillFromRDNSequence(&rdns)
+		return "DirName:" + formatFullName(dn)
+	case 6:
+		return "URI:" + string(name.Bytes)
+	case 7:
+		switch len(name.Bytes) {
+		case net.IPv4len, net.IPv6len:
+			return "IP Address:" + net.IP(name.Bytes).String()
+		case 2 * net.IPv4len, 2 * net.IPv6len:
+			// Name constraints carry an address and mask
+			half := len(name.Bytes) / 2
+			return fmt.Sprintf("IP:%s/%s", net.IP(name.Bytes[:half]), net.IP(name.Bytes[half:]))
+		}
+		return "IP Address:<invalid>"
+	case 8:
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(append([]byte{asn1.TagOID}, name.FullBytes[1:]...), &oid); err != nil {
+			return "Registered ID:<unparsable>"
+		}
+		return "Registered ID:" + oid.String()
+	}
+
+	return fmt.Sprintf("<unsupported general name %d>", name.Tag)
+}
+
+// accessMethodName returns the readable name of an AIA/SIA access method
+func accessMethodName(oid asn1.ObjectIdentifier) string {
+	switch oid.String() {
+	case "1.3.6.1.5.5.7.48.1":
+		return "OCSP"
+	case "1.3.6.1.5.5.7.48.2":
+		return "CA Issuers"
+	case "1.3.6.1.5.5.7.48.3":
+		return "Time Stamping"
+	case "1.3.6.1.5.5.7.48.5":
+		return "CA Repository"
+	}
+	return oid.String()
+}
+
+// keyUsageList returns the names of the key usage bits that are set
+func keyUsageList(usage x509.KeyUsage) []string {
+	var names []string
+	for _, ku := range keyUsageNames {
+		if usage&ku.usage != 0 {
+			names = append(names, ku.name)
+		}
+	}
+	return names
+}
+
+// extKeyUsageName returns the readable name of an extended key usage OID
+func extKeyUsageName(oid asn1.ObjectIdentifier) string {
+	if name, ok := extKeyUsageNames[oid.String()]; ok {
+		return name
+	}
+	return oid.String()
+}
+
+// colonHex formats bytes as uppercase hex separated by colons
+func colonHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = fmt.Sprintf("%02X", v)
+	}
+	return strings.Join(parts, ":")
+}
+
+// formatSerial formats a serial number as colon separated hex, followed by its decimal value when it is as short as
+// sequential serials are; zero and negative serials, which RFC 5280 forbids, are shown in decimal
+func formatSerial(serial *big.Int) string {
+	if serial.Sign() <= 0 {
+		return serial.String()
+	}
+	formatted := colonHex(serial.Bytes())
+	if serial.IsInt64() {
+		formatted += fmt.Sprintf(" (%d)", serial.Int64())
+	}
+	return formatted
+}
+
+// hexDumpLines formats bytes as lowercase colon separated hex, perLine bytes per line
+func hexDumpLines(b []byte, perLine int) []string {
+	var lines []string
+	for len(b) > 0 {
+		n := perLine
+		if n > len(b) {
+			n = len(b)
+		}
+		line := strings.ToLower(colonHex(b[:n]))
+		b = b[n:]
+		if len(b) > 0 {
+			line += ":"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseExtensionList parses a comma-separated list of oid:[critical:]value extensions, as certforge.ParseExtension
+// takes them
+func parseExtensionList(list string) ([]pkix.Extension, error) {
+	var exts []pkix.Extension
+	if list == "" {
+		return nil, nil
+	}
+	for _, spec := range strings.Split(list, ",") {
+		ext, err := certforge.ParseExtension(spec)
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
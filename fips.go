@@ -0,0 +1,51 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/fips140"
+	"os"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// fipsEnv turns on FIPS mode like --fips, for daemons and scheduled jobs whose command lines are not at hand
+const fipsEnv = "CERTFORGE_FIPS"
+
+// enableFIPS turns on FIPS mode for --fips anywhere before a "--", a set CERTFORGE_FIPS, or a boringcrypto build, and
+// returns the arguments without --fips so each command's flags need not know it
+func enableFIPS(args []string) []string {
+	on := boringCrypto
+	if value := os.Getenv(fipsEnv); value != "" && value != "0" && value != "off" {
+		on = true
+	}
+	rest := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if arg == "--fips" || arg == "-fips" {
+			on = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if on {
+		certforge.SetFIPS(true)
+	}
+	return rest
+}
+
+// fipsModule names the validated cryptographic module in use, or "" when there is none
+func fipsModule() string {
+	switch {
+	case boringCrypto:
+		return "BoringCrypto"
+	case fips140.Enabled():
+		return "Go Cryptographic Module (GODEBUG=fips140=on)"
+	}
+	return ""
+}
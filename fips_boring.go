@@ -0,0 +1,13 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build boringcrypto
+
+package main
+
+// Restricts TLS connections to FIPS-approved versions, cipher suites, and curves
+import _ "crypto/tls/fipsonly"
+
+// boringCrypto reports a build with GOEXPERIMENT=boringcrypto, which always runs in FIPS mode
+const boringCrypto = true
@@ -0,0 +1,67 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// hookEnv returns the environment variables describing a certificate's files, given to pre- and post-hooks
+func hookEnv(name string, domains []string, paths issuedPaths) []string {
+	return []string{
+		"CERTFORGE_NAME=" + name,
+		"CERTFORGE_DOMAINS=" + strings.Join(domains, " "),
+		"CERTFORGE_KEY=" + paths.Key,
+		"CERTFORGE_CSR=" + paths.CSR,
+		"CERTFORGE_CERT=" + paths.Cert,
+		"CERTFORGE_CHAIN=" + paths.Chain,
+		"CERTFORGE_FULLCHAIN=" + paths.FullChain,
+	}
+}
+
+// postHookEnv adds the outcome of issuance to env: the result, and the expiry or error
+func postHookEnv(env []string, notAfter time.Time, err error) []string {
+	env = append([]string{}, env...)
+	if err != nil {
+		return append(env, "CERTFORGE_RESULT=failure", "CERTFORGE_ERROR="+err.Error())
+	}
+	return append(env, "CERTFORGE_RESULT=success", "CERTFORGE_NOT_AFTER="+notAfter.UTC().Format(time.RFC3339))
+}
+
+// defaultHookTimeout is how long a hook or reload command may run before it is killed, unless configured otherwise
+const defaultHookTimeout = 5 * time.Minute
+
+// runHook runs a hook command with the shell, adding env to certforge's environment, and kills it after timeout, so a
+// hung hook cannot stall the daemon's renewals
+func runHook(kind, command string, env []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s %q did not finish within %s and was killed", kind, command, timeout)
+		}
+		return fmt.Errorf("%s %q failed: %v", kind, command, err)
+	}
+	return nil
+}
+
+// runReload runs a reload command with the shell and returns its combined output, killing it after timeout
+func runReload(command string, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output, err := shellCommand(ctx, command).CombinedOutput()
+	if err != nil && ctx.Err() != nil {
+		return output, fmt.Errorf("did not finish within %s and was killed", timeout)
+	}
+	return output, err
+}
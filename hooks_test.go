@@ -0,0 +1,49 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	if err := runHook("post-hook", `printf %s "$CERTFORGE_RESULT" > `+out, []string{"CERTFORGE_RESULT=success"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(out); err != nil || string(data) != "success" {
+		t.Errorf("the hook wrote %q, %v", data, err)
+	}
+	if err := runHook("pre-hook", "exit 3", nil, time.Minute); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("got error %v for a failing hook", err)
+	}
+}
+
+func TestRunHookTimeout(t *testing.T) {
+	start := time.Now()
+	err := runHook("pre-hook", "sleep 30", nil, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Errorf("got error %v for a hung hook", err)
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("the hung hook ran for %s", elapsed)
+	}
+
+	start = time.Now()
+	// The background child is killed with sh, so it does not hold the output pipe open
+	_, err = runReload("sleep 30 & sleep 30", 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Errorf("got error %v for a hung reload", err)
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("the hung reload ran for %s", elapsed)
+	}
+}
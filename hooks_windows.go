@@ -0,0 +1,24 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// shellCommand runs a command line with cmd /C. The line is passed as is, since cmd does not follow the quoting
+// rules exec uses for arguments.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "cmd")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd /C ` + command}
+	// Killing cmd leaves what it started running; stop waiting for their output soon after
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
@@ -0,0 +1,142 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// runInspect implements the inspect command
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	saveFlag := fs.String("save", "", "Directory to save the presented certificates and fullchain.pem to")
+	serverNameFlag := fs.String("servername", "", "Server name to send via SNI (default: the host)")
+	timeoutFlag := fs.Duration("timeout", 10*time.Second, "Connection timeout")
+	warnDaysFlag := fs.Int("warn-days", 30, "Flag certificates expiring within this many days")
+	textFlag := fs.Bool("text", false, "Print the full structure of each certificate")
+	tlsAuditFlag := fs.Bool("tls-audit", false, "Probe supported protocol versions, cipher suites, and session resumption")
+	targets := parseArgs(fs, args)
+
+	if len(targets) != 1 {
+		return fmt.Errorf("inspect requires exactly one host[:port]")
+	}
+	address, host := inspectAddress(targets[0])
+	serverName := *serverNameFlag
+	if serverName == "" {
+		serverName = host
+	}
+
+	ctx, cancel := commandContext(0)
+	defer cancel()
+	// Verification is done separately below so that untrusted chains can still be shown
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: *timeoutFlag},
+		Config:    &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to %s: %v", address, err)
+	}
+	state := conn.(*tls.Conn).ConnectionState()
+	conn.Close()
+
+	certs := state.PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("%s presented no certificates", address)
+	}
+
+	fmt.Println("=== Connection ===")
+	fmt.Println()
+	fmt.Printf("Address: %s\n", address)
+	fmt.Printf("Server Name: %s\n", serverName)
+	fmt.Printf("Protocol: %s\n", tls.VersionName(state.Version))
+	fmt.Printf("Cipher Suite: %s\n", tls.CipherSuiteName(state.CipherSuite))
+	if state.NegotiatedProtocol != "" {
+		fmt.Printf("ALPN: %s\n", state.NegotiatedProtocol)
+	}
+	fmt.Printf("Certificates Presented: %d\n", len(certs))
+	fmt.Println()
+
+	opts := decodeOptions{Text: *textFlag, WarnDays: *warnDaysFlag, Chain: certs}
+	for i, cert := range certs {
+		fmt.Printf("--- Certificate %d of %d ---\n\n", i+1, len(certs))
+		printCertificate(certforge.NewCertificate(cert), opts)
+		fmt.Println()
+	}
+	if len(certs) > 1 {
+		printChainSummary(certs)
+		fmt.Println()
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates}); err != nil {
+		fmt.Printf("Trusted: %s\n", colorize(fmt.Sprintf("NO (%v)", err), expiryExpired))
+	} else {
+		fmt.Printf("Trusted: %s\n", colorize(fmt.Sprintf("yes (valid for %s via the system roots)", serverName), expiryOK))
+	}
+
+	fmt.Println()
+	printOCSPStaple(state.OCSPResponse, certs)
+
+	if *tlsAuditFlag {
+		fmt.Println()
+		if err := runTLSAudit(ctx, tlsProbeTarget{Address: address, ServerName: serverName, Timeout: *timeoutFlag}); err != nil {
+			return err
+		}
+	}
+
+	if *saveFlag != "" {
+		return saveChain(*saveFlag, certs)
+	}
+	return nil
+}
+
+// inspectAddress adds the default HTTPS port to target when it has none and returns the host
+func inspectAddress(target string) (string, string) {
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return target, host
+	}
+	return net.JoinHostPort(target, "443"), target
+}
+
+// saveChain writes each certificate and the assembled fullchain.pem to dir
+func saveChain(dir string, certs []*x509.Certificate) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Error creating output directory: %v", err)
+	}
+
+	fmt.Println()
+	var fullchain bytes.Buffer
+	for i, cert := range certs {
+		block := &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}
+		path := filepath.Join(dir, fmt.Sprintf("cert%d.pem", i+1))
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0644); err != nil {
+			return fmt.Errorf("Failed to save certificate: %v", err)
+		}
+		fmt.Printf("Certificate %d saved to: %s\n", i+1, path)
+		pem.Encode(&fullchain, block)
+	}
+
+	path := filepath.Join(dir, "fullchain.pem")
+	if err := os.WriteFile(path, fullchain.Bytes(), 0644); err != nil {
+		return fmt.Errorf("Failed to save chain: %v", err)
+	}
+	fmt.Printf("Full chain saved to: %s\n", path)
+	return nil
+}
@@ -0,0 +1,302 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// JKS is the proprietary keystore format of Sun's Java. Private keys are protected with
+// a SHA-1 based XOR stream, so a JKS file is no stronger than its password.
+const (
+	jksMagic   = 0xfeedfeed
+	jksVersion = 2
+
+	jksPrivateKeyEntry  = 1
+	jksTrustedCertEntry = 2
+)
+
+// oidJKSKeyProtector identifies Sun's proprietary key protection algorithm
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// jksEntry is a private key with its certificate chain, or a trusted certificate when Key is nil
+type jksEntry struct {
+	Alias string
+	Key   crypto.PrivateKey
+	Chain []*x509.Certificate
+}
+
+// encodeJKS builds a JKS keystore. Private keys are protected with the store password, as keytool does by default.
+func encodeJKS(entries []jksEntry, password string) ([]byte, error) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(jksMagic))
+	binary.Write(&buf, binary.BigEndian, uint32(jksVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(len(entries)))
+
+	timestamp := time.Now().UnixMilli()
+	for _, entry := range entries {
+		// Java compares aliases case-insensitively and stores them in lower case
+		alias := strings.ToLower(entry.Alias)
+
+		if entry.Key == nil {
+			binary.Write(&buf, binary.BigEndian, uint32(jksTrustedCertEntry))
+			jksWriteUTF(&buf, alias)
+			binary.Write(&buf, binary.BigEndian, timestamp)
+			jksWriteCertificate(&buf, entry.Chain[0])
+			continue
+		}
+
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode private key: %v", err)
+		}
+		protected, err := jksProtectKey(pkcs8, password)
+		if err != nil {
+			return nil, err
+		}
+
+		binary.Write(&buf, binary.BigEndian, uint32(jksPrivateKeyEntry))
+		jksWriteUTF(&buf, alias)
+		binary.Write(&buf, binary.BigEndian, timestamp)
+		binary.Write(&buf, binary.BigEndian, uint32(len(protected)))
+		buf.Write(protected)
+		binary.Write(&buf, binary.BigEndian, uint32(len(entry.Chain)))
+		for _, cert := range entry.Chain {
+			jksWriteCertificate(&buf, cert)
+		}
+	}
+
+	// The integrity check is SHA-1 over the password, a fixed phrase, and the keystore contents
+	h := sha1.New()
+	h.Write(jksPassword(password))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(buf.Bytes())
+	buf.Write(h.Sum(nil))
+	return buf.Bytes(), nil
+}
+
+// errJKSPassword is returned when the integrity check or key protection of a keystore fails
+var errJKSPassword = errors.New("Incorrect keystore password (use --passin to supply one)")
+
+// isJKS reports whether data starts with the JKS magic number
+func isJKS(data []byte) bool {
+	return len(data) >= 4 && binary.BigEndian.Uint32(data) == jksMagic
+}
+
+// decodeJKS reads the entries of a JKS keystore, checking its integrity and decrypting private keys with password
+func decodeJKS(data []byte, password string) ([]jksEntry, error) {
+	if !isJKS(data) || len(data) < 12+sha1.Size {
+		return nil, fmt.Errorf("Not a JKS keystore")
+	}
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	h := sha1.New()
+	h.Write(jksPassword(password))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(body)
+	if !bytes.Equal(h.Sum(nil), digest) {
+		return nil, errJKSPassword
+	}
+
+	r := &jksReader{data: body[4:]}
+	if version := r.uint32(); version != jksVersion {
+		return nil, fmt.Errorf("Unsupported JKS version: %d", version)
+	}
+	count := r.uint32()
+
+	var entries []jksEntry
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		tag := r.uint32()
+		entry := jksEntry{Alias: r.utf()}
+		r.bytes(8) // creation timestamp
+		if r.err != nil {
+			break
+		}
+
+		switch tag {
+		case jksTrustedCertEntry:
+			cert, err := r.certificate()
+			if err != nil {
+				return nil, err
+			}
+			entry.Chain = []*x509.Certificate{cert}
+
+		case jksPrivateKeyEntry:
+			protected := r.bytes(int(r.uint32()))
+			if r.err != nil {
+				break
+			}
+			key, err := jksRecoverKey(protected, password)
+			if err != nil {
+				return nil, err
+			}
+			entry.Key = key
+			for n := r.uint32(); n > 0 && r.err == nil; n-- {
+				cert, err := r.certificate()
+				if err != nil {
+					return nil, err
+				}
+				entry.Chain = append(entry.Chain, cert)
+			}
+
+		default:
+			return nil, fmt.Errorf("Unsupported JKS entry type: %d", tag)
+		}
+		entries = append(entries, entry)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("Failed to parse JKS keystore: %v", r.err)
+	}
+	return entries, nil
+}
+
+// jksRecoverKey reverses jksProtectKey
+func jksRecoverKey(protected []byte, password string) (crypto.PrivateKey, error) {
+	var info pkcs8EncryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(protected, &info); err != nil {
+		return nil, fmt.Errorf("Failed to parse protected key: %v", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidJKSKeyProtector) {
+		return nil, fmt.Errorf("Unsupported key protection algorithm: %s", info.Algorithm.Algorithm)
+	}
+	if len(info.Data) < 2*sha1.Size {
+		return nil, fmt.Errorf("Protected key is too short")
+	}
+
+	pw := jksPassword(password)
+	salt := info.Data[:sha1.Size]
+	encrypted := info.Data[sha1.Size : len(info.Data)-sha1.Size]
+	check := info.Data[len(info.Data)-sha1.Size:]
+
+	plain := jksKeystream(encrypted, pw, salt)
+	sum := sha1.Sum(append(append([]byte{}, pw...), plain...))
+	if !bytes.Equal(sum[:], check) {
+		return nil, errJKSPassword
+	}
+	key, err := x509.ParsePKCS8PrivateKey(plain)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse private key: %v", err)
+	}
+	return key, nil
+}
+
+// jksReader reads the big endian fields of a keystore, remembering the first error
+type jksReader struct {
+	data []byte
+	err  error
+}
+
+// bytes returns the next n bytes
+func (r *jksReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = fmt.Errorf("unexpected end of data")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// uint32 returns the next 32-bit integer
+func (r *jksReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// utf returns the next string written with writeUTF
+func (r *jksReader) utf() string {
+	b := r.bytes(2)
+	if b == nil {
+		return ""
+	}
+	return string(r.bytes(int(binary.BigEndian.Uint16(b))))
+}
+
+// certificate returns the next certificate with its type and length
+func (r *jksReader) certificate() (*x509.Certificate, error) {
+	certType := r.utf()
+	der := r.bytes(int(r.uint32()))
+	if r.err != nil {
+		return nil, fmt.Errorf("Failed to parse JKS keystore: %v", r.err)
+	}
+	if certType != "X.509" {
+		return nil, fmt.Errorf("Unsupported certificate type in keystore: %s", certType)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// jksProtectKey encrypts a PKCS#8 private key with Sun's key protector and wraps it in an EncryptedPrivateKeyInfo
+func jksProtectKey(pkcs8 []byte, password string) ([]byte, error) {
+	pw := jksPassword(password)
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	encrypted := jksKeystream(pkcs8, pw, salt)
+	check := sha1.Sum(append(append([]byte{}, pw...), pkcs8...))
+
+	data := append(append(salt, encrypted...), check[:]...)
+	return asn1.Marshal(pkcs8EncryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		Data:      data,
+	})
+}
+
+// jksKeystream XORs data with the key protector's keystream, SHA-1(password || previous digest) starting from the salt
+func jksKeystream(data, pw, salt []byte) []byte {
+	out := make([]byte, len(data))
+	digest := salt
+	for i := 0; i < len(data); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, pw...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(data); j++ {
+			out[i+j] = data[i+j] ^ digest[j]
+		}
+	}
+	return out
+}
+
+// jksPassword encodes a password as UTF-16 big endian without a terminator, as Java's char[] is hashed
+func jksPassword(password string) []byte {
+	var out []byte
+	for _, r := range utf16.Encode([]rune(password)) {
+		out = append(out, byte(r>>8), byte(r))
+	}
+	return out
+}
+
+// jksWriteUTF writes a string in the length prefixed format of Java's DataOutput.writeUTF
+func jksWriteUTF(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+// jksWriteCertificate writes a certificate with its type and length
+func jksWriteCertificate(buf *bytes.Buffer, cert *x509.Certificate) {
+	jksWriteUTF(buf, "X.509")
+	binary.Write(buf, binary.BigEndian, uint32(len(cert.Raw)))
+	buf.Write(cert.Raw)
+}
@@ -0,0 +1,162 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// sealJKS replaces the integrity digest of a keystore body, so damage to the body is found by the parser
+func sealJKS(body []byte, password string) []byte {
+	h := sha1.New()
+	h.Write(jksPassword(password))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(body)
+	return h.Sum(append([]byte{}, body...))
+}
+
+func TestEncodeJKSRoundTrip(t *testing.T) {
+	ecKey, ecCert := loadPKCS12TestKey(t)
+	rsaKey, rsaCert, ca := loadPKCS7TestKey(t)
+	entries := []jksEntry{
+		{Alias: "EC Key", Key: ecKey, Chain: []*x509.Certificate{ecCert}},
+		{Alias: "rsa", Key: rsaKey, Chain: []*x509.Certificate{rsaCert, ca}},
+		{Alias: "ca", Chain: []*x509.Certificate{ca}},
+	}
+	// A password outside Latin-1 checks the UTF-16 encoding
+	for _, password := range []string{"changeit", "pässwörd ключ"} {
+		data, err := encodeJKS(entries, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeJKS(data, password)
+		if err != nil {
+			t.Fatalf("password %q: %v", password, err)
+		}
+		if len(got) != len(entries) {
+			t.Fatalf("password %q: got %d entries, want %d", password, len(got), len(entries))
+		}
+		for i, entry := range entries {
+			if got[i].Alias != strings.ToLower(entry.Alias) {
+				t.Errorf("entry %d: got alias %q", i, got[i].Alias)
+			}
+			if (got[i].Key == nil) != (entry.Key == nil) {
+				t.Fatalf("entry %d: got key %T", i, got[i].Key)
+			}
+			if entry.Key != nil && !certforge.SamePublicKey(got[i].Key.(crypto.Signer).Public(), entry.Key.(crypto.Signer).Public()) {
+				t.Errorf("entry %d: decoded a different key", i)
+			}
+			if len(got[i].Chain) != len(entry.Chain) {
+				t.Fatalf("entry %d: got %d certificates, want %d", i, len(got[i].Chain), len(entry.Chain))
+			}
+			for j, cert := range entry.Chain {
+				if !got[i].Chain[j].Equal(cert) {
+					t.Errorf("entry %d: certificate %d is %s", i, j, got[i].Chain[j].Subject)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeJKSErrors(t *testing.T) {
+	key, cert := loadPKCS12TestKey(t)
+	data, err := encodeJKS([]jksEntry{{Alias: "test", Key: key, Chain: []*x509.Certificate{cert}}}, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := data[:len(data)-sha1.Size]
+
+	if _, err := decodeJKS(data, "wrong"); !errors.Is(err, errJKSPassword) {
+		t.Errorf("got error %v for a wrong password", err)
+	}
+	// Cut anywhere, the digest no longer matches
+	for _, n := range []int{0, 4, 12, len(data) / 2, len(data) - 1} {
+		if _, err := decodeJKS(data[:n], "changeit"); err == nil {
+			t.Errorf("decoded the first %d of %d bytes", n, len(data))
+		}
+	}
+	// Cut with a digest that matches, the parser finds the end
+	for _, n := range []int{16, 20, 40, len(body) / 2, len(body) - 1} {
+		_, err := decodeJKS(sealJKS(body[:n], "changeit"), "changeit")
+		if err == nil || errors.Is(err, errJKSPassword) {
+			t.Errorf("got error %v for a keystore body cut to %d of %d bytes", err, n, len(body))
+		}
+	}
+	// An entry count past the entries
+	more := append([]byte{}, body...)
+	more[11]++
+	if _, err := decodeJKS(sealJKS(more, "changeit"), "changeit"); err == nil || !strings.Contains(err.Error(), "end of data") {
+		t.Errorf("got error %v for a missing entry", err)
+	}
+}
+
+func TestJKSKeytool(t *testing.T) {
+	keytool, err := exec.LookPath("keytool")
+	if err != nil {
+		t.Skip("keytool is not installed")
+	}
+	dir := t.TempDir()
+	_, _, ca := loadPKCS7TestKey(t)
+
+	// A keystore made by keytool, with a generated key and an imported CA
+	generated := filepath.Join(dir, "keytool.jks")
+	for _, args := range [][]string{
+		{"-genkeypair", "-alias", "generated", "-keyalg", "RSA", "-keysize", "2048", "-dname", "CN=keytool.example.com", "-validity", "30"},
+		{"-importcert", "-noprompt", "-alias", "ca", "-file", "testdata/pkcs7/ca.pem"},
+	} {
+		args = append(args, "-keystore", generated, "-storetype", "JKS", "-storepass", "changeit", "-keypass", "changeit")
+		if out, err := exec.Command(keytool, args...).CombinedOutput(); err != nil {
+			t.Fatalf("keytool %s: %v\n%s", args[0], err, out)
+		}
+	}
+	data, err := os.ReadFile(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := decodeJKS(data, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key, trusted bool
+	for _, entry := range entries {
+		switch {
+		case entry.Alias == "generated" && entry.Key != nil && len(entry.Chain) == 1:
+			key = entry.Chain[0].Subject.CommonName == "keytool.example.com"
+		case entry.Alias == "ca" && entry.Key == nil && len(entry.Chain) == 1:
+			trusted = entry.Chain[0].Equal(ca)
+		}
+	}
+	if !key || !trusted {
+		t.Errorf("decoded %d entries without the generated key and the CA", len(entries))
+	}
+
+	// A keystore made here, listed by keytool
+	ecKey, ecCert := loadPKCS12TestKey(t)
+	data, err = encodeJKS([]jksEntry{{Alias: "certforge", Key: ecKey, Chain: []*x509.Certificate{ecCert}}, {Alias: "ca", Chain: []*x509.Certificate{ca}}}, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "certforge.jks")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(keytool, "-list", "-keystore", path, "-storetype", "JKS", "-storepass", "changeit").CombinedOutput()
+	if err != nil {
+		t.Fatalf("keytool -list: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "certforge, ") || !strings.Contains(string(out), "ca, ") {
+		t.Errorf("keytool -list printed:\n%s", out)
+	}
+}
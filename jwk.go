@@ -0,0 +1,428 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// jsonWebKey holds the members of a JSON Web Key (RFC 7517) that certforge understands
+type jsonWebKey struct {
+	Kty     string   `json:"kty"`
+	Use     string   `json:"use,omitempty"`
+	Alg     string   `json:"alg,omitempty"`
+	Kid     string   `json:"kid,omitempty"`
+	KeyOps  []string `json:"key_ops,omitempty"`
+	Crv     string   `json:"crv,omitempty"`
+	N       string   `json:"n,omitempty"`
+	E       string   `json:"e,omitempty"`
+	X       string   `json:"x,omitempty"`
+	Y       string   `json:"y,omitempty"`
+	D       string   `json:"d,omitempty"`
+	P       string   `json:"p,omitempty"`
+	Q       string   `json:"q,omitempty"`
+	Dp      string   `json:"dp,omitempty"`
+	Dq      string   `json:"dq,omitempty"`
+	Qi      string   `json:"qi,omitempty"`
+	K       string   `json:"k,omitempty"`
+	X5c     []string `json:"x5c,omitempty"`
+	X5t     string   `json:"x5t,omitempty"`
+	X5tS256 string   `json:"x5t#S256,omitempty"`
+}
+
+// looksLikeJSON reports whether data appears to be a JSON object
+func looksLikeJSON(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// decodeJWK decodes a JWK or a JWKS document and displays every key it holds
+func decodeJWK(data []byte, opts decodeOptions) error {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("Failed to parse JSON: %v", err)
+	}
+
+	if set.Keys == nil {
+		var jwk jsonWebKey
+		if err := json.Unmarshal(data, &jwk); err != nil {
+			return fmt.Errorf("Failed to parse JWK: %v", err)
+		}
+		if jwk.Kty == "" {
+			return fmt.Errorf("JSON file is neither a JWK nor a JWKS (missing \"kty\" or \"keys\")")
+		}
+		return printJWKInfo(jwk, opts)
+	}
+
+	fmt.Printf("Found %d keys in JWK Set\n\n", len(set.Keys))
+	for i, jwk := range set.Keys {
+		fmt.Printf("--- Key %d of %d ---\n\n", i+1, len(set.Keys))
+		if err := printJWKInfo(jwk, opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// printJWKInfo displays information about a single JWK and optionally its PEM encoding
+func printJWKInfo(jwk jsonWebKey, opts decodeOptions) error {
+	fmt.Println("=== JSON Web Key Information ===")
+	fmt.Println()
+	fmt.Printf("Key Type: %s\n", jwk.Kty)
+	if jwk.Kid != "" {
+		fmt.Printf("Key ID: %s\n", jwk.Kid)
+	}
+	if jwk.Use != "" {
+		fmt.Printf("Use: %s\n", jwk.Use)
+	}
+	if jwk.Alg != "" {
+		fmt.Printf("Algorithm: %s\n", jwk.Alg)
+	}
+	if len(jwk.KeyOps) > 0 {
+		fmt.Printf("Key Operations: %s\n", strings.Join(jwk.KeyOps, ", "))
+	}
+
+	if thumbprint, err := jwkThumbprint(jwk); err == nil {
+		fmt.Printf("Thumbprint (RFC 7638, SHA-256): %s\n", thumbprint)
+	}
+
+	if jwk.Kty == "oct" {
+		secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil {
+			return fmt.Errorf("Invalid symmetric key value: %v", err)
+		}
+		fmt.Printf("Symmetric Key Size: %d bits\n", len(secret)*8)
+		if opts.ToPEM {
+			fmt.Println("\nSymmetric keys have no PEM representation")
+		}
+		return nil
+	}
+
+	pub, priv, err := jwkKeys(jwk)
+	if err != nil {
+		return err
+	}
+	if priv != nil {
+		fmt.Println("Private Key: present")
+	} else {
+		fmt.Println("Private Key: not present")
+	}
+	fmt.Println()
+	printPublicKeyInfo(certforge.NewPublicKeyInfo(pub))
+
+	if len(jwk.X5c) > 0 {
+		fmt.Printf("\nX.509 Certificate Chain (x5c): %d certificates\n", len(jwk.X5c))
+		for i, encoded := range jwk.X5c {
+			der, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				fmt.Printf("  [%d] invalid base64: %v\n", i+1, err)
+				continue
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				fmt.Printf("  [%d] invalid certificate: %v\n", i+1, err)
+				continue
+			}
+			fmt.Printf("  [%d] %s\n", i+1, certforge.FormatName(cert.Subject))
+			if i == 0 {
+				if certforge.SamePublicKey(pub, cert.PublicKey) {
+					fmt.Println("      Public key matches the JWK")
+				} else {
+					fmt.Println("      WARNING: public key does not match the JWK")
+				}
+			}
+		}
+	}
+
+	if opts.ToPEM {
+		pubDER, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return fmt.Errorf("Failed to encode public key: %v", err)
+		}
+		fmt.Println()
+		pem.Encode(os.Stdout, &pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+		if priv != nil {
+			privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+			if err != nil {
+				return fmt.Errorf("Failed to encode private key: %v", err)
+			}
+			pem.Encode(os.Stdout, &pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+		}
+	}
+	return nil
+}
+
+// jwkKeys converts a JWK into its public key and, when the private members are present, its private key
+func jwkKeys(jwk jsonWebKey) (crypto.PublicKey, crypto.PrivateKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := jwkBigInt(jwk.N, "n")
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := jwkBigInt(jwk.E, "e")
+		if err != nil {
+			return nil, nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, nil, fmt.Errorf("RSA public exponent is too large")
+		}
+		pub := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		if jwk.D == "" {
+			return pub, nil, nil
+		}
+
+		d, err := jwkBigInt(jwk.D, "d")
+		if err != nil {
+			return nil, nil, err
+		}
+		priv := &rsa.PrivateKey{PublicKey: *pub, D: d}
+		if jwk.P != "" && jwk.Q != "" {
+			p, err := jwkBigInt(jwk.P, "p")
+			if err != nil {
+				return nil, nil, err
+			}
+			q, err := jwkBigInt(jwk.Q, "q")
+			if err != nil {
+				return nil, nil, err
+			}
+			priv.Primes = []*big.Int{p, q}
+		}
+		if err := priv.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("Invalid RSA private key: %v", err)
+		}
+		priv.Precompute()
+		return pub, priv, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		var ecdhCurve ecdh.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, nil, fmt.Errorf("Unsupported EC curve: %s", jwk.Crv)
+		}
+		x, err := jwkBigInt(jwk.X, "x")
+		if err != nil {
+			return nil, nil, err
+		}
+		y, err := jwkBigInt(jwk.Y, "y")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Validate the point by loading its uncompressed encoding
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x.Bytes()) > size || len(y.Bytes()) > size {
+			return nil, nil, fmt.Errorf("Invalid EC public key: coordinate too large")
+		}
+		point := append([]byte{4}, x.FillBytes(make([]byte, size))...)
+		point = append(point, y.FillBytes(make([]byte, size))...)
+		if _, err := ecdhCurve.NewPublicKey(point); err != nil {
+			return nil, nil, fmt.Errorf("Invalid EC public key: %v", err)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if jwk.D == "" {
+			return pub, nil, nil
+		}
+
+		d, err := jwkBigInt(jwk.D, "d")
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(d.Bytes()) > size {
+			return nil, nil, fmt.Errorf("Invalid EC private key: scalar too large")
+		}
+		ecdhPriv, err := ecdhCurve.NewPrivateKey(d.FillBytes(make([]byte, size)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid EC private key: %v", err)
+		}
+		if !bytes.Equal(ecdhPriv.PublicKey().Bytes(), point) {
+			return nil, nil, fmt.Errorf("EC private key does not match its public key")
+		}
+		return pub, &ecdsa.PrivateKey{PublicKey: *pub, D: d}, nil
+
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid JWK member \"x\": %v", err)
+		}
+		switch jwk.Crv {
+		case "Ed25519":
+			if len(x) != ed25519.PublicKeySize {
+				return nil, nil, fmt.Errorf("Invalid Ed25519 public key length %d", len(x))
+			}
+			pub := ed25519.PublicKey(x)
+			if jwk.D == "" {
+				return pub, nil, nil
+			}
+			seed, err := base64.RawURLEncoding.DecodeString(jwk.D)
+			if err != nil || len(seed) != ed25519.SeedSize {
+				return nil, nil, fmt.Errorf("Invalid Ed25519 private key")
+			}
+			priv := ed25519.NewKeyFromSeed(seed)
+			if !pub.Equal(priv.Public()) {
+				return nil, nil, fmt.Errorf("Ed25519 private key does not match its public key")
+			}
+			return pub, priv, nil
+		case "X25519":
+			pub, err := ecdh.X25519().NewPublicKey(x)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid X25519 public key: %v", err)
+			}
+			if jwk.D == "" {
+				return pub, nil, nil
+			}
+			d, err := base64.RawURLEncoding.DecodeString(jwk.D)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid JWK member \"d\": %v", err)
+			}
+			priv, err := ecdh.X25519().NewPrivateKey(d)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid X25519 private key: %v", err)
+			}
+			return pub, priv, nil
+		}
+		return nil, nil, fmt.Errorf("Unsupported OKP curve: %s", jwk.Crv)
+	}
+	return nil, nil, fmt.Errorf("Unsupported JWK key type: %s", jwk.Kty)
+}
+
+// encodeJWK converts a public key, and its private key when priv is not nil, into a JWK
+func encodeJWK(pub crypto.PublicKey, priv crypto.PrivateKey) (jsonWebKey, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk := jsonWebKey{Kty: "RSA", N: b64(k.N.Bytes()), E: b64(big.NewInt(int64(k.E)).Bytes())}
+		if key, ok := priv.(*rsa.PrivateKey); ok {
+			if len(key.Primes) != 2 {
+				return jsonWebKey{}, fmt.Errorf("Multi-prime RSA keys are not supported")
+			}
+			key.Precompute()
+			jwk.D = b64(key.D.Bytes())
+			jwk.P = b64(key.Primes[0].Bytes())
+			jwk.Q = b64(key.Primes[1].Bytes())
+			jwk.Dp = b64(key.Precomputed.Dp.Bytes())
+			jwk.Dq = b64(key.Precomputed.Dq.Bytes())
+			jwk.Qi = b64(key.Precomputed.Qinv.Bytes())
+		}
+		return jwk, nil
+
+	case *ecdsa.PublicKey:
+		// Coordinates and the private scalar are padded to the full field size
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk := jsonWebKey{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   b64(k.X.FillBytes(make([]byte, size))),
+			Y:   b64(k.Y.FillBytes(make([]byte, size))),
+		}
+		switch jwk.Crv {
+		case "P-256", "P-384", "P-521":
+		default:
+			return jsonWebKey{}, fmt.Errorf("Unsupported EC curve: %s", jwk.Crv)
+		}
+		if key, ok := priv.(*ecdsa.PrivateKey); ok {
+			jwk.D = b64(key.D.FillBytes(make([]byte, size)))
+		}
+		return jwk, nil
+
+	case ed25519.PublicKey:
+		jwk := jsonWebKey{Kty: "OKP", Crv: "Ed25519", X: b64(k)}
+		if key, ok := priv.(ed25519.PrivateKey); ok {
+			jwk.D = b64(key.Seed())
+		}
+		return jwk, nil
+
+	case *ecdh.PublicKey:
+		if k.Curve() != ecdh.X25519() {
+			return jsonWebKey{}, fmt.Errorf("Unsupported ECDH curve")
+		}
+		jwk := jsonWebKey{Kty: "OKP", Crv: "X25519", X: b64(k.Bytes())}
+		if key, ok := priv.(*ecdh.PrivateKey); ok {
+			jwk.D = b64(key.Bytes())
+		}
+		return jwk, nil
+	}
+	return jsonWebKey{}, fmt.Errorf("Unsupported key type: %T", pub)
+}
+
+// jwkSigningAlgorithm returns the JWS algorithm usually paired with a JWK's key type and curve
+func jwkSigningAlgorithm(jwk jsonWebKey) string {
+	switch {
+	case jwk.Kty == "RSA":
+		return "RS256"
+	case jwk.Crv == "P-256":
+		return "ES256"
+	case jwk.Crv == "P-384":
+		return "ES384"
+	case jwk.Crv == "P-521":
+		return "ES512"
+	case jwk.Crv == "Ed25519":
+		return "EdDSA"
+	}
+	return ""
+}
+
+// jwkBigInt decodes a base64url encoded unsigned integer member of a JWK
+func jwkBigInt(value, name string) (*big.Int, error) {
+	if value == "" {
+		return nil, fmt.Errorf("JWK is missing member %q", name)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JWK member %q: %v", name, err)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// jwkThumbprint computes the RFC 7638 SHA-256 thumbprint of a JWK
+func jwkThumbprint(jwk jsonWebKey) (string, error) {
+	// Only the required members take part, serialized with sorted keys and no whitespace
+	var members map[string]string
+	switch jwk.Kty {
+	case "RSA":
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case "EC":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X, "y": jwk.Y}
+	case "OKP":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	case "oct":
+		members = map[string]string{"k": jwk.K, "kty": jwk.Kty}
+	default:
+		return "", fmt.Errorf("Unsupported JWK key type: %s", jwk.Kty)
+	}
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,642 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// k8sCommands maps the subcommands of k8s to their implementations
+var k8sCommands = map[string]func(args []string) error{
+	"csr": runK8sCSR,
+}
+
+// k8sCSRPath is the collection of CertificateSigningRequest objects in the Kubernetes API
+const k8sCSRPath = "/apis/certificates.k8s.io/v1/certificatesigningrequests"
+
+// k8sServiceAccountDir holds the token and CA certificate of the service account a pod runs as
+const k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// k8sClientSigners are the built-in signers of client certificates; others, such as kubernetes.io/kubelet-serving,
+// default to server certificates
+var k8sClientSigners = map[string]bool{
+	"kubernetes.io/kube-apiserver-client":         true,
+	"kubernetes.io/kube-apiserver-client-kubelet": true,
+}
+
+// k8sUsages are the key usages a CertificateSigningRequest may ask for, by their Kubernetes names
+var k8sUsages = map[string]bool{
+	"signing": true, "digital signature": true, "content commitment": true, "key encipherment": true,
+	"key agreement": true, "data encipherment": true, "cert sign": true, "crl sign": true, "encipher only": true,
+	"decipher only": true, "any": true, "server auth": true, "client auth": true, "code signing": true,
+	"email protection": true, "s/mime": true, "ipsec end system": true, "ipsec tunnel": true, "ipsec user": true,
+	"timestamping": true, "ocsp signing": true, "microsoft sgc": true, "netscape sgc": true,
+}
+
+// k8sNameInvalid matches the characters not allowed in the name of a Kubernetes object
+var k8sNameInvalid = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// kubeconfig is the part of a kubeconfig file needed to reach and authenticate to a cluster
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			TLSServerName            string `yaml:"tls-server-name"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string    `yaml:"token"`
+			TokenFile             string    `yaml:"tokenFile"`
+			ClientCertificate     string    `yaml:"client-certificate"`
+			ClientCertificateData string    `yaml:"client-certificate-data"`
+			ClientKey             string    `yaml:"client-key"`
+			ClientKeyData         string    `yaml:"client-key-data"`
+			Username              string    `yaml:"username"`
+			Password              string    `yaml:"password"`
+			Exec                  yaml.Node `yaml:"exec"`
+			AuthProvider          yaml.Node `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// k8sClient talks to the API server of a Kubernetes cluster
+type k8sClient struct {
+	Server   string
+	Context  string
+	token    string
+	username string
+	password string
+	http     *http.Client
+}
+
+// k8sCSR is a CertificateSigningRequest object; the spec is kept as sent or returned so that updating the approval
+// does not drop fields certforge does not know
+type k8sCSR struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   k8sObjectMeta   `json:"metadata"`
+	Spec       json.RawMessage `json:"spec"`
+	Status     k8sCSRStatus    `json:"status"`
+}
+
+// k8sObjectMeta is the metadata of a Kubernetes object
+type k8sObjectMeta struct {
+	Name            string `json:"name,omitempty"`
+	GenerateName    string `json:"generateName,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	UID             string `json:"uid,omitempty"`
+}
+
+// k8sCSRSpec is the spec of a new CertificateSigningRequest
+type k8sCSRSpec struct {
+	Request           []byte   `json:"request"`
+	SignerName        string   `json:"signerName"`
+	Usages            []string `json:"usages"`
+	ExpirationSeconds int64    `json:"expirationSeconds,omitempty"`
+}
+
+// k8sCSRStatus is the status of a CertificateSigningRequest: its approval conditions and, once signed, the
+// certificate
+type k8sCSRStatus struct {
+	Conditions  []k8sCSRCondition `json:"conditions,omitempty"`
+	Certificate []byte            `json:"certificate,omitempty"`
+}
+
+// k8sCSRCondition is an Approved, Denied, or Failed condition of a CertificateSigningRequest
+type k8sCSRCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastUpdateTime     string `json:"lastUpdateTime,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+// k8sStatusError is an error response of the API server
+type k8sStatusError struct {
+	Method  string
+	Path    string
+	Code    int
+	Status  string
+	Message string
+}
+
+func (e *k8sStatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s %s: %s (%s)", e.Method, e.Path, e.Message, e.Status)
+	}
+	return fmt.Sprintf("%s %s returned %s", e.Method, e.Path, e.Status)
+}
+
+// runK8s implements the k8s command, which dispatches on the subcommand
+func runK8s(args []string) error {
+	var names []string
+	for name := range k8sCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return fmt.Errorf("k8s requires a subcommand: %s", strings.Join(names, ", "))
+	}
+	command, ok := k8sCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown k8s subcommand %q (use %s)", args[0], strings.Join(names, ", "))
+	}
+	return command(args[1:])
+}
+
+// runK8sCSR implements k8s csr, which generates a key, submits a CSR to the CertificateSigningRequest API of a
+// cluster, optionally approves it, and saves the certificate once the signer has issued it
+func runK8sCSR(args []string) error {
+	fs := flag.NewFlagSet("k8s csr", flag.ExitOnError)
+	kubeconfigFlag := fs.String("kubeconfig", "", "Kubeconfig file (default: $KUBECONFIG, ~/.kube/config, or the service account of the pod)")
+	contextFlag := fs.String("context", "", "Kubeconfig context to use (default: the current context)")
+	signerFlag := fs.String("signer", "", "Signer name, such as kubernetes.io/kubelet-serving or kubernetes.io/kube-apiserver-client")
+	nameFlag := fs.String("name", "", "Name of the CertificateSigningRequest object (default: the common name with a random suffix)")
+	nodeFlag := fs.String("node", "", "Node name, making the subject CN=system:node:<node>, O=system:nodes as the kubelet signers require")
+	cnFlag := fs.String("cn", "", "Common name of the certificate")
+	orgFlag := fs.String("org", "", "Comma separated organizations, which Kubernetes client certificates use as groups")
+	domainFlag := fs.String("domain", "", "Comma separated DNS names and IP addresses for the subject alternative names")
+	usagesFlag := fs.String("usages", "", "Comma separated key usages, like \"digital signature,server auth\" (default: by signer)")
+	expirationFlag := fs.Duration("expiration", 0, "Validity requested, like 720h; at least 10m (default: the signer's)")
+	approveFlag := fs.Bool("approve", false, "Approve the request, which needs RBAC permission to approve for the signer")
+	waitFlag := fs.Duration("wait", 5*time.Minute, "How long to wait for the certificate to be issued")
+	keyFlag := fs.String("key", "", "Existing private key to certify (default: generate a new one)")
+	keyTypeFlag := fs.String("key-type", "ecdsa", "Type of a new key: rsa, ecdsa, or tpm (ECDSA P-256 kept in the TPM)")
+	keySizeFlag := fs.Int("key-size", 2048, "Size of a new RSA key: 2048, 3072, or 4096")
+	outFlag := fs.String("out", "", "Output file prefix (default: the node name or common name)")
+	outputDirFlag := fs.String("o", "", "Output directory for generated files (default: current directory)")
+	parseArgs(fs, args)
+
+	if *signerFlag == "" {
+		return fmt.Errorf("k8s csr requires --signer")
+	}
+	cn := *cnFlag
+	var orgs []string
+	for _, org := range strings.Split(*orgFlag, ",") {
+		if org = strings.TrimSpace(org); org != "" {
+			orgs = append(orgs, org)
+		}
+	}
+	if *nodeFlag != "" {
+		if cn != "" || len(orgs) > 0 {
+			return fmt.Errorf("Use either --node or --cn and --org")
+		}
+		cn, orgs = "system:node:"+*nodeFlag, []string{"system:nodes"}
+	}
+	var names []string
+	for _, name := range strings.Split(*domainFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" && !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if cn == "" {
+		if len(names) == 0 {
+			return fmt.Errorf("k8s csr requires --node, --cn, or --domain")
+		}
+		cn = names[0]
+	}
+	if *expirationFlag != 0 && *expirationFlag < 10*time.Minute {
+		return fmt.Errorf("Invalid --expiration %s: Kubernetes requires at least 10m", *expirationFlag)
+	}
+	prefix := *outFlag
+	if prefix == "" {
+		prefix = *nodeFlag
+	}
+	if prefix == "" {
+		prefix = strings.TrimPrefix(cn, "*.")
+	}
+
+	ctx, cancel := commandContext(0)
+	defer cancel()
+	client, err := newK8sClient(*kubeconfigFlag, *contextFlag)
+	if err != nil {
+		return err
+	}
+
+	var key crypto.Signer
+	if *keyFlag != "" {
+		existing, err := readPrivateKey(*keyFlag, "")
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if key, ok = existing.(crypto.Signer); !ok {
+			return fmt.Errorf("Unsupported private key in %s", *keyFlag)
+		}
+	} else if key, err = generateACMEKey(ctx, *keyTypeFlag, *keySizeFlag); err != nil {
+		return err
+	}
+	defer closeTPMKey(key)
+	usages, err := k8sCSRUsages(*usagesFlag, *signerFlag, key)
+	if err != nil {
+		return err
+	}
+
+	csrDER, err := certforge.CreateCSR(key, pkix.Name{CommonName: cn, Organization: orgs}, names)
+	if err != nil {
+		return err
+	}
+	auditLog(auditLogCSR(csrDER))
+	spec := k8sCSRSpec{
+		Request:           pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}),
+		SignerName:        *signerFlag,
+		Usages:            usages,
+		ExpirationSeconds: int64(expirationFlag.Seconds()),
+	}
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	csr := &k8sCSR{APIVersion: "certificates.k8s.io/v1", Kind: "CertificateSigningRequest", Spec: specJSON}
+	if *nameFlag != "" {
+		csr.Metadata.Name = *nameFlag
+	} else {
+		csr.Metadata.GenerateName = k8sGenerateName(prefix)
+	}
+
+	var created k8sCSR
+	if err := client.do(ctx, http.MethodPost, k8sCSRPath, csr, &created); err != nil {
+		if status, ok := err.(*k8sStatusError); ok && status.Code == http.StatusConflict {
+			return fmt.Errorf("%v; choose another --name", err)
+		}
+		return err
+	}
+	fmt.Printf("Submitted CertificateSigningRequest %s to %s for signer %s\n", created.Metadata.Name, client.Server, *signerFlag)
+
+	if *approveFlag {
+		if err := client.approve(ctx, &created); err != nil {
+			if status, ok := err.(*k8sStatusError); ok && status.Code == http.StatusForbidden {
+				return fmt.Errorf("%v\nApproving needs update on certificatesigningrequests/approval and approve on signers named %s; see the RBAC example in the README", err, *signerFlag)
+			}
+			return err
+		}
+		fmt.Printf("Approved %s\n", created.Metadata.Name)
+	} else {
+		fmt.Printf("Waiting for approval; approve with: kubectl certificate approve %s\n", created.Metadata.Name)
+	}
+
+	issued, err := client.waitForCertificate(ctx, created.Metadata.Name, *waitFlag)
+	if err != nil {
+		return err
+	}
+	certs, err := stepCACertificates(string(issued.Status.Certificate))
+	if err != nil || len(certs) == 0 {
+		return fmt.Errorf("Invalid certificate in CertificateSigningRequest %s", created.Metadata.Name)
+	}
+	var chain [][]byte
+	for _, cert := range certs {
+		chain = append(chain, cert.Raw)
+	}
+	if !certforge.SamePublicKey(certs[0].PublicKey, key.Public()) {
+		return fmt.Errorf("The certificate issued for %s is not for the submitted key", created.Metadata.Name)
+	}
+	paths, err := writeIssuedFiles(layoutFlat, *outputDirFlag, prefix, key, csrDER, chain, *keyFlag == "")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\nSuccess!")
+	if *keyFlag == "" {
+		fmt.Printf("Private key saved to: %s\n", paths.Key)
+	}
+	fmt.Printf("CSR saved to: %s\n", paths.CSR)
+	fmt.Printf("Certificate saved to: %s\n", paths.Cert)
+	fmt.Printf("Full chain saved to: %s\n", paths.FullChain)
+	fmt.Printf("Issued by %s, valid until %s\n", certforge.FormatName(certs[0].Issuer), certs[0].NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// k8sCSRUsages returns the usages given, checked against those Kubernetes knows, or the defaults for the signer:
+// digital signature and, for RSA keys, key encipherment, with client auth for the client signers and server auth
+// for the others
+func k8sCSRUsages(given, signer string, key crypto.Signer) ([]string, error) {
+	if given != "" {
+		var usages []string
+		for _, usage := range strings.Split(given, ",") {
+			usage = strings.ToLower(strings.TrimSpace(usage))
+			if !k8sUsages[usage] {
+				return nil, fmt.Errorf("Invalid usage %q: use Kubernetes names like \"digital signature\" or \"server auth\"", usage)
+			}
+			usages = append(usages, usage)
+		}
+		return usages, nil
+	}
+	usages := []string{"digital signature"}
+	if _, ok := key.Public().(*rsa.PublicKey); ok {
+		usages = append(usages, "key encipherment")
+	}
+	if k8sClientSigners[signer] {
+		return append(usages, "client auth"), nil
+	}
+	return append(usages, "server auth"), nil
+}
+
+// k8sGenerateName returns the prefix of a generated CertificateSigningRequest name for prefix, which the API server
+// completes with a random suffix
+func k8sGenerateName(prefix string) string {
+	name := strings.Trim(k8sNameInvalid.ReplaceAllString(strings.ToLower(prefix), "-"), ".-")
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	if name == "" {
+		return "certforge-"
+	}
+	return name + "-"
+}
+
+// newK8sClient connects to the cluster of a kubeconfig context, or to the cluster a pod runs in when there is no
+// kubeconfig and the pod has a service account
+func newK8sClient(path, contextName string) (*k8sClient, error) {
+	if list := filepath.SplitList(os.Getenv("KUBECONFIG")); path == "" && len(list) > 0 {
+		path = list[0]
+	}
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".kube", "config")
+		}
+	}
+	if _, err := os.Stat(path); err != nil && os.Getenv("KUBERNETES_SERVICE_HOST") != "" && contextName == "" {
+		return newK8sInClusterClient()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading kubeconfig: %v", err)
+	}
+	var config kubeconfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("Invalid kubeconfig %s: %v", path, err)
+	}
+	if contextName == "" {
+		contextName = config.CurrentContext
+	}
+	if contextName == "" {
+		return nil, fmt.Errorf("%s has no current context; choose one with --context", path)
+	}
+
+	// Relative file names in a kubeconfig are relative to its directory
+	dir := filepath.Dir(path)
+	resolve := func(name string) string {
+		if name == "" || filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(dir, name)
+	}
+	readData := func(inline, file string) ([]byte, error) {
+		if inline != "" {
+			return base64.StdEncoding.DecodeString(strings.TrimSpace(inline))
+		}
+		if file == "" {
+			return nil, nil
+		}
+		return os.ReadFile(resolve(file))
+	}
+
+	for _, c := range config.Contexts {
+		if c.Name != contextName {
+			continue
+		}
+		client := &k8sClient{Context: contextName}
+		tlsConfig := &tls.Config{}
+		found := false
+		for _, cluster := range config.Clusters {
+			if cluster.Name != c.Context.Cluster {
+				continue
+			}
+			found = true
+			client.Server = strings.TrimRight(cluster.Cluster.Server, "/")
+			tlsConfig.ServerName = cluster.Cluster.TLSServerName
+			tlsConfig.InsecureSkipVerify = cluster.Cluster.InsecureSkipTLSVerify
+			ca, err := readData(cluster.Cluster.CertificateAuthorityData, cluster.Cluster.CertificateAuthority)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read the CA of cluster %s: %v", cluster.Name, err)
+			}
+			if ca != nil {
+				pool := x509.NewCertPool()
+				if !pool.AppendCertsFromPEM(ca) {
+					return nil, fmt.Errorf("No certificates in the CA of cluster %s", cluster.Name)
+				}
+				tlsConfig.RootCAs = pool
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Cluster %s of context %s not found in %s", c.Context.Cluster, contextName, path)
+		}
+
+		for _, user := range config.Users {
+			if user.Name != c.Context.User {
+				continue
+			}
+			if !user.User.Exec.IsZero() || !user.User.AuthProvider.IsZero() {
+				return nil, fmt.Errorf("User %s authenticates with an exec or auth provider plugin, which certforge does not run; use a context with a token or client certificate", user.Name)
+			}
+			client.token, client.username, client.password = user.User.Token, user.User.Username, user.User.Password
+			if client.token == "" && user.User.TokenFile != "" {
+				token, err := os.ReadFile(resolve(user.User.TokenFile))
+				if err != nil {
+					return nil, fmt.Errorf("Failed to read the token of user %s: %v", user.Name, err)
+				}
+				client.token = strings.TrimSpace(string(token))
+			}
+			certPEM, err := readData(user.User.ClientCertificateData, user.User.ClientCertificate)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read the client certificate of user %s: %v", user.Name, err)
+			}
+			keyPEM, err := readData(user.User.ClientKeyData, user.User.ClientKey)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read the client key of user %s: %v", user.Name, err)
+			}
+			if certPEM != nil || keyPEM != nil {
+				cert, err := tls.X509KeyPair(certPEM, keyPEM)
+				certforge.Zeroize(keyPEM)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid client certificate of user %s: %v", user.Name, err)
+				}
+				tlsConfig.Certificates = []tls.Certificate{cert}
+			}
+		}
+
+		if u, err := url.Parse(client.Server); err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("Invalid server %q in context %s: use an https URL", client.Server, contextName)
+		}
+		client.http = &http.Client{Timeout: time.Minute, Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		return client, nil
+	}
+	return nil, fmt.Errorf("Context %s not found in %s", contextName, path)
+}
+
+// newK8sInClusterClient connects to the API server of the cluster a pod runs in, as its service account
+func newK8sInClusterClient() (*k8sClient, error) {
+	token, err := os.ReadFile(filepath.Join(k8sServiceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the service account token: %v", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(k8sServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the service account CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("No certificates in the service account CA")
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if port == "" {
+		port = "443"
+	}
+	return &k8sClient{
+		Server:  "https://" + strings.Trim(host, "[]") + ":" + port,
+		Context: "in-cluster",
+		token:   strings.TrimSpace(string(token)),
+		http:    &http.Client{Timeout: time.Minute, Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+	}, nil
+}
+
+// do sends a JSON request to the API server and decodes the JSON response into out
+func (c *k8sClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.Server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "certforge/"+version)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.username != "":
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("Request to %s failed: %v", c.Server, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return fmt.Errorf("Failed to read response from %s: %v", c.Server, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &status)
+		return &k8sStatusError{Method: method, Path: path, Code: resp.StatusCode, Status: resp.Status, Message: status.Message}
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("Invalid response from %s%s: %v", c.Server, path, err)
+	}
+	return nil
+}
+
+// approve adds the Approved condition to a CertificateSigningRequest through its approval subresource
+func (c *k8sClient) approve(ctx context.Context, csr *k8sCSR) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	csr.Status.Conditions = append(csr.Status.Conditions, k8sCSRCondition{
+		Type:               "Approved",
+		Status:             "True",
+		Reason:             "CertforgeApprove",
+		Message:            "Approved by certforge k8s csr",
+		LastUpdateTime:     now,
+		LastTransitionTime: now,
+	})
+	return c.do(ctx, http.MethodPut, k8sCSRPath+"/"+url.PathEscape(csr.Metadata.Name)+"/approval", csr, csr)
+}
+
+// waitForCertificate polls a CertificateSigningRequest until the signer has issued its certificate, failing when it
+// is denied or fails, or after timeout
+func (c *k8sClient) waitForCertificate(ctx context.Context, name string, timeout time.Duration) (*k8sCSR, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	announced := false
+	for {
+		var csr k8sCSR
+		if err := c.do(ctx, http.MethodGet, k8sCSRPath+"/"+url.PathEscape(name), nil, &csr); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return nil, err
+		}
+		approved := false
+		for _, condition := range csr.Status.Conditions {
+			if condition.Status != "True" {
+				continue
+			}
+			switch condition.Type {
+			case "Denied", "Failed":
+				return nil, fmt.Errorf("CertificateSigningRequest %s was %s: %s %s", name, strings.ToLower(condition.Type), condition.Reason, condition.Message)
+			case "Approved":
+				approved = true
+			}
+		}
+		if len(csr.Status.Certificate) > 0 {
+			return &csr, nil
+		}
+		if approved && !announced {
+			fmt.Println("Approved; waiting for the signer to issue the certificate")
+			announced = true
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(2 * time.Second):
+			continue
+		}
+		break
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("CertificateSigningRequest %s was not issued within %s; it is left in the cluster for later approval", name, timeout)
+	}
+	return nil, fmt.Errorf("Interrupted")
+}
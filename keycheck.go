@@ -0,0 +1,194 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// defaultWeakKeyDir is where the Debian openssl-blacklist package installs its blocklists
+const defaultWeakKeyDir = "/usr/share/openssl-blacklist"
+
+// keyChecker detects Debian weak keys (CVE-2008-0166) and keys reused across certificates
+type keyChecker struct {
+	// weak holds the truncated SHA-1 fingerprints from the openssl-blacklist files
+	weak map[string]bool
+
+	// dbPath is where the database of seen keys is saved, empty if none is used
+	dbPath string
+	db     keyDatabase
+}
+
+// keyDatabase records the certificates each public key has been seen in
+type keyDatabase struct {
+	Keys map[string][]keySighting `json:"keys"`
+}
+
+// keySighting is one certificate a public key was seen in
+type keySighting struct {
+	Certificate string `json:"certificate"`
+	Subject     string `json:"subject"`
+	Source      string `json:"source"`
+	FirstSeen   string `json:"first_seen"`
+}
+
+// newKeyChecker loads the weak key blocklists and the key database. An empty weakKeys
+// uses the openssl-blacklist directory when it is installed.
+func newKeyChecker(weakKeys, dbPath string) (*keyChecker, error) {
+	checker := &keyChecker{weak: make(map[string]bool), dbPath: dbPath, db: keyDatabase{Keys: make(map[string][]keySighting)}}
+
+	if weakKeys == "" {
+		if _, err := os.Stat(defaultWeakKeyDir); err == nil {
+			weakKeys = defaultWeakKeyDir
+		}
+	}
+	if weakKeys != "" {
+		if err := checker.loadBlocklist(weakKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	if dbPath != "" {
+		data, err := os.ReadFile(dbPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Error reading key database: %v", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &checker.db); err != nil {
+				return nil, fmt.Errorf("Failed to parse key database: %v", err)
+			}
+			if checker.db.Keys == nil {
+				checker.db.Keys = make(map[string][]keySighting)
+			}
+		}
+	}
+	return checker, nil
+}
+
+// loadBlocklist reads a blocklist file, or every blacklist.RSA-* file in a directory
+func (c *keyChecker) loadBlocklist(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Error reading weak key blocklist: %v", err)
+	}
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "blacklist.RSA-*")); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("Error reading weak key blocklist: %v", err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			c.weak[strings.ToLower(line)] = true
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("Error reading weak key blocklist: %v", err)
+		}
+	}
+	return nil
+}
+
+// hasBlocklist reports whether any weak key fingerprints were loaded
+func (c *keyChecker) hasBlocklist() bool {
+	return len(c.weak) > 0
+}
+
+// isDebianWeak reports whether pub is one of the predictable keys generated by Debian's broken OpenSSL
+func (c *keyChecker) isDebianWeak(pub crypto.PublicKey) bool {
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok || !c.hasBlocklist() {
+		return false
+	}
+	// openssl-vulnkey format: the last 20 hex digits of SHA-1("Modulus=<HEX>\n")
+	sum := sha1.Sum([]byte(fmt.Sprintf("Modulus=%X\n", key.N)))
+	return c.weak[hex.EncodeToString(sum[:])[20:]]
+}
+
+// reusedBy records cert in the key database and returns the other certificates that share its key
+func (c *keyChecker) reusedBy(cert *x509.Certificate, source string) []keySighting {
+	if c.dbPath == "" {
+		return nil
+	}
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	keyID := hex.EncodeToString(spki[:])
+	certSum := sha256.Sum256(cert.Raw)
+	certID := hex.EncodeToString(certSum[:])
+
+	var others []keySighting
+	known := false
+	for _, sighting := range c.db.Keys[keyID] {
+		if sighting.Certificate == certID {
+			known = true
+			continue
+		}
+		others = append(others, sighting)
+	}
+	if !known {
+		c.db.Keys[keyID] = append(c.db.Keys[keyID], keySighting{
+			Certificate: certID,
+			Subject:     certforge.FormatName(cert.Subject),
+			Source:      source,
+			FirstSeen:   time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	return others
+}
+
+// save writes the key database back to disk
+func (c *keyChecker) save() error {
+	if c.dbPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.db, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.dbPath, data, 0600); err != nil {
+		return fmt.Errorf("Failed to save key database: %v", err)
+	}
+	return nil
+}
+
+// printKeyChecks displays the weak key and key reuse results for a decoded certificate
+func printKeyChecks(cert *x509.Certificate, opts decodeOptions) {
+	if opts.KeyCheck == nil {
+		return
+	}
+	if opts.KeyCheck.hasBlocklist() {
+		if opts.KeyCheck.isDebianWeak(cert.PublicKey) {
+			fmt.Printf("  Debian Weak Key: %s\n", colorize("YES - the private key is predictable (CVE-2008-0166)", expiryExpired))
+		} else {
+			fmt.Println("  Debian Weak Key: no")
+		}
+	}
+	for _, other := range opts.KeyCheck.reusedBy(cert, opts.Source) {
+		fmt.Printf("  %s\n", colorize(fmt.Sprintf("Key Reuse: also used by %s (%s, first seen %s)", other.Subject, other.Source, other.FirstSeen), expiryWarning))
+	}
+}
@@ -0,0 +1,158 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// Output layouts of issued certificates: flat files named after the prefix, or certbot's live and archive directories
+const (
+	layoutFlat    = "flat"
+	layoutCertbot = "certbot"
+)
+
+// certbotREADME is left in each live directory, like certbot does
+const certbotREADME = `This directory contains your keys and certificates.
+
+` + "`privkey.pem`" + `  : the private key for your certificate.
+` + "`fullchain.pem`" + `: the certificate file used in most server software.
+` + "`chain.pem`" + `    : used for OCSP stapling in Nginx >=1.3.7.
+` + "`cert.pem`" + `     : the certificate alone, which many server configurations
+                 reject; use fullchain.pem unless told otherwise.
+
+WARNING: DO NOT MOVE OR RENAME THESE FILES!
+         certforge expects these files to remain in this location in order
+         to function properly!
+
+The files are symlinks to the latest version in the archive directory.
+`
+
+// certbotArchiveVersion matches the numbered certificates in an archive directory
+var certbotArchiveVersion = regexp.MustCompile(`^cert(\d+)\.pem$`)
+
+// validateLayout checks an output layout name, returning the default for an empty one
+func validateLayout(layout string) (string, error) {
+	switch layout {
+	case "", layoutFlat:
+		return layoutFlat, nil
+	case layoutCertbot:
+		return layoutCertbot, nil
+	default:
+		return "", fmt.Errorf("Invalid layout %q (use flat or certbot)", layout)
+	}
+}
+
+// certbotPaths returns the live files of name in dir; certbot keeps no CSR next to them
+func certbotPaths(dir, name string) issuedPaths {
+	live := filepath.Join(dir, "live", name)
+	return issuedPaths{
+		Key:       filepath.Join(live, "privkey.pem"),
+		Cert:      filepath.Join(live, "cert.pem"),
+		Chain:     filepath.Join(live, "chain.pem"),
+		FullChain: filepath.Join(live, "fullchain.pem"),
+	}
+}
+
+// writeCertbotFiles saves a new version of the files to archive/<name> and points the symlinks in live/<name> at it
+func writeCertbotFiles(dir, name string, key crypto.Signer, chain [][]byte) (issuedPaths, error) {
+	archive := filepath.Join(dir, "archive", name)
+	live := filepath.Join(dir, "live", name)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return issuedPaths{}, fmt.Errorf("Error creating output directory: %v", err)
+	}
+	// The top directories are private, like certbot's, as they hold every key ever issued
+	for _, top := range []string{filepath.Join(dir, "archive"), filepath.Join(dir, "live")} {
+		if err := os.MkdirAll(top, 0700); err != nil {
+			return issuedPaths{}, fmt.Errorf("Error creating output directory: %v", err)
+		}
+	}
+	for _, sub := range []string{archive, live} {
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			return issuedPaths{}, fmt.Errorf("Error creating output directory: %v", err)
+		}
+	}
+
+	// Versions continue after the newest in the archive, including those certbot wrote
+	entries, err := os.ReadDir(archive)
+	if err != nil {
+		return issuedPaths{}, fmt.Errorf("Failed to read %s: %v", archive, err)
+	}
+	version := 1
+	for _, entry := range entries {
+		if m := certbotArchiveVersion.FindStringSubmatch(entry.Name()); m != nil {
+			if n, err := strconv.Atoi(m[1]); err == nil && n >= version {
+				version = n + 1
+			}
+		}
+	}
+
+	block, err := marshalACMEKey(key)
+	if err != nil {
+		return issuedPaths{}, err
+	}
+	keyPEM := pem.EncodeToMemory(block)
+	certforge.Zeroize(block.Bytes)
+	defer certforge.Zeroize(keyPEM)
+	var intermediates, fullchain bytes.Buffer
+	for i, der := range chain {
+		block := &pem.Block{Type: "CERTIFICATE", Bytes: der}
+		pem.Encode(&fullchain, block)
+		if i > 0 {
+			pem.Encode(&intermediates, block)
+		}
+	}
+
+	// Certbot writes every file of a version, including an unchanged key
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{"privkey", keyPEM, 0600},
+		{"cert", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[0]}), 0644},
+		{"chain", intermediates.Bytes(), 0644},
+		{"fullchain", fullchain.Bytes(), 0644},
+	}
+	for _, file := range files {
+		path := filepath.Join(archive, fmt.Sprintf("%s%d.pem", file.name, version))
+		if err := os.WriteFile(path, file.data, file.mode); err != nil {
+			return issuedPaths{}, fmt.Errorf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	// Links are relative, so the tree can be moved, and replaced by rename, so servers never see a missing file
+	for _, file := range files {
+		target := filepath.Join("..", "..", "archive", name, fmt.Sprintf("%s%d.pem", file.name, version))
+		link := filepath.Join(live, file.name+".pem")
+		tmp := link + ".tmp"
+		os.Remove(tmp)
+		if err := os.Symlink(target, tmp); err != nil {
+			return issuedPaths{}, fmt.Errorf("Failed to link %s: %v", link, err)
+		}
+		if err := os.Rename(tmp, link); err != nil {
+			os.Remove(tmp)
+			return issuedPaths{}, fmt.Errorf("Failed to link %s: %v", link, err)
+		}
+	}
+
+	readme := filepath.Join(live, "README")
+	if _, err := os.Stat(readme); os.IsNotExist(err) {
+		if err := os.WriteFile(readme, []byte(certbotREADME), 0644); err != nil {
+			return issuedPaths{}, fmt.Errorf("Failed to write %s: %v", readme, err)
+		}
+	}
+	return certbotPaths(dir, name), nil
+}
@@ -0,0 +1,393 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// lintSeverity ranks lint findings, following the levels zlint uses
+type lintSeverity int
+
+const (
+	lintError lintSeverity = iota
+	lintWarn
+	lintNotice
+)
+
+// lintSeverityNames maps lint severities to the labels shown in the report
+var lintSeverityNames = map[lintSeverity]string{
+	lintError:  "ERROR",
+	lintWarn:   "WARN",
+	lintNotice: "NOTICE",
+}
+
+// lintFinding is a single rule violation
+type lintFinding struct {
+	Severity lintSeverity
+	Source   string
+	Message  string
+}
+
+// lintRule checks one aspect of a certificate and returns any findings
+type lintRule func(cert *x509.Certificate) []lintFinding
+
+// lintRules are applied to every certificate in order
+var lintRules = []lintRule{
+	lintVersion,
+	lintSerialNumber,
+	lintValidity,
+	lintSignatureAlgorithm,
+	lintPublicKey,
+	lintKeyIdentifiers,
+	lintBasicConstraints,
+	lintKeyUsage,
+	lintExtKeyUsage,
+	lintSubjectAltName,
+	lintRevocationInfo,
+	lintCriticalExtensions,
+}
+
+// maxSubscriberValidity is the longest subscriber certificate lifetime allowed by the Baseline Requirements
+const maxSubscriberValidity = 398 * 24 * time.Hour
+
+// runLint implements the lint command
+func runLint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	files := parseArgs(fs, args)
+	if len(files) == 0 {
+		return fmt.Errorf("lint requires a certificate file")
+	}
+
+	errorCount := 0
+	for _, file := range files {
+		certs, err := readCertificates(file)
+		if err != nil {
+			return err
+		}
+		for _, cert := range certs {
+			findings := lintCertificate(cert)
+			printLintFindings(file, cert, findings)
+			for _, finding := range findings {
+				if finding.Severity == lintError {
+					errorCount++
+				}
+			}
+		}
+	}
+
+	if errorCount > 0 {
+		return fmt.Errorf("Lint found %d errors", errorCount)
+	}
+	return nil
+}
+
+// lintCertificate applies every rule to cert
+func lintCertificate(cert *x509.Certificate) []lintFinding {
+	var findings []lintFinding
+	for _, rule := range lintRules {
+		findings = append(findings, rule(cert)...)
+	}
+	return findings
+}
+
+// printLintFindings displays the findings for one certificate, most severe first
+func printLintFindings(file string, cert *x509.Certificate, findings []lintFinding) {
+	fmt.Printf("=== Lint: %s ===\n", certforge.FormatName(cert.Subject))
+	fmt.Println()
+	fmt.Printf("File: %s\n", file)
+	if isCertificateAuthority(cert) {
+		fmt.Println("Type: CA certificate")
+	} else {
+		fmt.Println("Type: subscriber certificate")
+	}
+	fmt.Println()
+
+	counts := make(map[lintSeverity]int)
+	for _, severity := range []lintSeverity{lintError, lintWarn, lintNotice} {
+		for _, finding := range findings {
+			if finding.Severity != severity {
+				continue
+			}
+			counts[severity]++
+			label := fmt.Sprintf("%-6s", lintSeverityNames[severity])
+			switch severity {
+			case lintError:
+				label = colorize(label, expiryExpired)
+			case lintWarn:
+				label = colorize(label, expiryWarning)
+			}
+			fmt.Printf("  %s [%s] %s\n", label, finding.Source, finding.Message)
+		}
+	}
+	if len(findings) == 0 {
+		fmt.Println("  " + colorize("No findings", expiryOK))
+	}
+	fmt.Printf("\nResult: %d errors, %d warnings, %d notices\n\n", counts[lintError], counts[lintWarn], counts[lintNotice])
+}
+
+// isCertificateAuthority reports whether cert is a CA certificate
+func isCertificateAuthority(cert *x509.Certificate) bool {
+	return cert.BasicConstraintsValid && cert.IsCA
+}
+
+// hasExtension reports whether cert contains the extension oid and whether it is critical
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) (bool, bool) {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true, ext.Critical
+		}
+	}
+	return false, false
+}
+
+// lintVersion requires X.509 v3
+func lintVersion(cert *x509.Certificate) []lintFinding {
+	if cert.Version != 3 {
+		return []lintFinding{{lintError, "RFC 5280 4.1.2.1", fmt.Sprintf("certificate is version %d, not version 3", cert.Version)}}
+	}
+	return nil
+}
+
+// lintSerialNumber checks the serial number is positive, short enough, and random enough
+func lintSerialNumber(cert *x509.Certificate) []lintFinding {
+	var findings []lintFinding
+	serial := cert.SerialNumber
+	if serial.Sign() <= 0 {
+		findings = append(findings, lintFinding{lintError, "RFC 5280 4.1.2.2", "serial number is not a positive integer"})
+	}
+	// 20 octets including the sign bit
+	if len(serial.Bytes()) > 20 || (len(serial.Bytes()) == 20 && serial.Bytes()[0]&0x80 != 0) {
+		findings = append(findings, lintFinding{lintError, "RFC 5280 4.1.2.2", "serial number is longer than 20 octets"})
+	}
+	if !isCertificateAuthority(cert) && serial.BitLen() < 64 {
+		findings = append(findings, lintFinding{lintWarn, "CABF BR 7.1", fmt.Sprintf("serial number has only %d bits; at least 64 bits of CSPRNG output are required", serial.BitLen())})
+	}
+	return findings
+}
+
+// lintValidity checks the validity period is well formed and within the subscriber maximum
+func lintValidity(cert *x509.Certificate) []lintFinding {
+	if !cert.NotAfter.After(cert.NotBefore) {
+		return []lintFinding{{lintError, "RFC 5280 4.1.2.5", "notAfter is not after notBefore"}}
+	}
+	validity := cert.NotAfter.Sub(cert.NotBefore)
+	if !isCertificateAuthority(cert) && validity > maxSubscriberValidity {
+		return []lintFinding{{lintError, "CABF BR 6.3.2", fmt.Sprintf("validity period of %d days exceeds 398 days", int(validity.Hours()/24))}}
+	}
+	return nil
+}
+
+// lintSignatureAlgorithm rejects MD2, MD5, and SHA-1 signatures
+func lintSignatureAlgorithm(cert *x509.Certificate) []lintFinding {
+	if certforge.IsWeakSignature(cert.SignatureAlgorithm) && !certforge.IsSelfSigned(cert) {
+		return []lintFinding{{lintError, "CABF BR 7.1.3.2", fmt.Sprintf("signed with deprecated algorithm %s", cert.SignatureAlgorithm)}}
+	}
+	return nil
+}
+
+// lintPublicKey checks RSA modulus size and exponent and elliptic curve choice
+func lintPublicKey(cert *x509.Certificate) []lintFinding {
+	var findings []lintFinding
+	if weak := weakKeyReason(cert.PublicKey); weak != "" {
+		findings = append(findings, lintFinding{lintError, "CABF BR 6.1.5", fmt.Sprintf("key is too weak (%s)", weak)})
+	}
+	if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+		if key.N.BitLen()%8 != 0 {
+			findings = append(findings, lintFinding{lintError, "CABF BR 6.1.5", "RSA modulus size is not divisible by 8"})
+		}
+		if key.E%2 == 0 || key.E < 3 {
+			findings = append(findings, lintFinding{lintError, "CABF BR 6.1.6", fmt.Sprintf("RSA public exponent %d must be odd and at least 3", key.E)})
+		}
+	}
+	return findings
+}
+
+// lintKeyIdentifiers checks the subject and authority key identifiers
+func lintKeyIdentifiers(cert *x509.Certificate) []lintFinding {
+	var findings []lintFinding
+	if len(cert.SubjectKeyId) == 0 {
+		if isCertificateAuthority(cert) {
+			findings = append(findings, lintFinding{lintError, "RFC 5280 4.2.1.2", "CA certificate is missing the Subject Key Identifier"})
+		} else {
+			findings = append(findings, lintFinding{lintWarn, "RFC 5280 4.2.1.2", "missing Subject Key Identifier"})
+		}
+	}
+	if len(cert.AuthorityKeyId) == 0 && !certforge.IsSelfSigned(cert) {
+		findings = append(findings, lintFinding{lintError, "RFC 5280 4.2.1.1", "missing Authority Key Identifier"})
+	}
+	return findings
+}
+
+// lintBasicConstraints checks basic constraints presence and criticality
+func lintBasicConstraints(cert *x509.Certificate) []lintFinding {
+	present, critical := hasExtension(cert, oidExtBasicConstraints)
+	if isCertificateAuthority(cert) && !critical {
+		return []lintFinding{{lintError, "RFC 5280 4.2.1.9", "Basic Constraints must be critical in CA certificates"}}
+	}
+	if !present && cert.KeyUsage&x509.KeyUsageCertSign != 0 {
+		return []lintFinding{{lintError, "RFC 5280 4.2.1.9", "keyCertSign is set but Basic Constraints is missing"}}
+	}
+	return nil
+}
+
+// lintKeyUsage checks key usage against the certificate type
+func lintKeyUsage(cert *x509.Certificate) []lintFinding {
+	present, critical := hasExtension(cert, oidExtKeyUsage)
+	if isCertificateAuthority(cert) {
+		switch {
+		case !present:
+			return []lintFinding{{lintError, "CABF BR 7.1.2.10.7", "CA certificate is missing Key Usage"}}
+		case cert.KeyUsage&x509.KeyUsageCertSign == 0:
+			return []lintFinding{{lintError, "RFC 5280 4.2.1.3", "CA certificate lacks keyCertSign"}}
+		case !critical:
+			return []lintFinding{{lintWarn, "RFC 5280 4.2.1.3", "Key Usage should be critical"}}
+		}
+		return nil
+	}
+	if cert.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+		return []lintFinding{{lintError, "CABF BR 7.1.2.7.11", "subscriber certificate asserts keyCertSign or cRLSign"}}
+	}
+	return nil
+}
+
+// lintExtKeyUsage checks the extended key usage combination of subscriber certificates
+func lintExtKeyUsage(cert *x509.Certificate) []lintFinding {
+	if isCertificateAuthority(cert) {
+		return nil
+	}
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return []lintFinding{{lintError, "CABF BR 7.1.2.7.10", "subscriber certificate is missing Extended Key Usage"}}
+	}
+
+	var findings []lintFinding
+	serverAuth := false
+	for _, usage := range cert.ExtKeyUsage {
+		switch usage {
+		case x509.ExtKeyUsageServerAuth:
+			serverAuth = true
+		case x509.ExtKeyUsageClientAuth:
+		case x509.ExtKeyUsageAny:
+			findings = append(findings, lintFinding{lintError, "CABF BR 7.1.2.7.10", "anyExtendedKeyUsage must not be present"})
+		default:
+			findings = append(findings, lintFinding{lintWarn, "CABF BR 7.1.2.7.10", fmt.Sprintf("unexpected Extended Key Usage %s alongside TLS usages", extKeyUsageLabels[usage])})
+		}
+	}
+	if !serverAuth {
+		findings = append(findings, lintFinding{lintNotice, "CABF BR 7.1.2.7.10", "serverAuth is not asserted; the certificate cannot be used for TLS servers"})
+	}
+	return findings
+}
+
+// lintSubjectAltName checks SAN presence and the names it contains
+func lintSubjectAltName(cert *x509.Certificate) []lintFinding {
+	var findings []lintFinding
+	present, critical := hasExtension(cert, oidExtSubjectAltName)
+	emptySubject := len(cert.Subject.Names) == 0
+
+	if isCertificateAuthority(cert) {
+		return nil
+	}
+	switch {
+	case !present && cert.Subject.CommonName != "":
+		findings = append(findings, lintFinding{lintError, "CABF BR 7.1.2.7.12", "Common Name without Subject Alternative Name; clients ignore the CN"})
+	case !present:
+		findings = append(findings, lintFinding{lintError, "CABF BR 7.1.2.7.12", "missing Subject Alternative Name"})
+	case emptySubject && !critical:
+		findings = append(findings, lintFinding{lintError, "RFC 5280 4.2.1.6", "Subject Alternative Name must be critical when the subject is empty"})
+	case !emptySubject && critical:
+		findings = append(findings, lintFinding{lintWarn, "RFC 5280 4.2.1.6", "Subject Alternative Name should not be critical when the subject is present"})
+	}
+
+	if cn := cert.Subject.CommonName; cn != "" && present {
+		names := subjectAltNameList(cert.DNSNames, cert.IPAddresses, nil, nil)
+		if !contains(names, "DNS:"+strings.ToLower(cn)) && !contains(names, "DNS:"+cn) && !contains(names, "IP:"+cn) {
+			findings = append(findings, lintFinding{lintError, "CABF BR 7.1.4.3", fmt.Sprintf("Common Name %q is not one of the Subject Alternative Names", cn)})
+		}
+	}
+
+	for _, name := range cert.DNSNames {
+		if problem := dnsNameProblem(name); problem != "" {
+			findings = append(findings, lintFinding{lintError, "CABF BR 7.1.2.7.12", fmt.Sprintf("DNS name %q %s", name, problem)})
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+			findings = append(findings, lintFinding{lintError, "CABF BR 7.1.2.7.12", fmt.Sprintf("IP address %s is reserved", ip)})
+		}
+	}
+	return findings
+}
+
+// dnsNameProblem describes why name is not a valid publicly trusted DNS name, or returns ""
+func dnsNameProblem(name string) string {
+	if net.ParseIP(name) != nil {
+		return "is an IP address"
+	}
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return "is not fully qualified"
+	}
+	for i, label := range labels {
+		if label == "*" && i == 0 {
+			continue
+		}
+		if strings.Contains(label, "*") {
+			return "has a wildcard that is not the entire leftmost label"
+		}
+		if label == "" || len(label) > 63 {
+			return "has an empty or overlong label"
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "has a label starting or ending with a hyphen"
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return fmt.Sprintf("contains invalid character %q", r)
+			}
+		}
+	}
+	switch strings.ToLower(labels[len(labels)-1]) {
+	case "local", "internal", "localhost", "lan", "corp", "home", "test", "invalid", "example":
+		return "uses a reserved or internal top-level domain"
+	}
+	return ""
+}
+
+// lintRevocationInfo checks that subscriber certificates point to issuer and revocation information
+func lintRevocationInfo(cert *x509.Certificate) []lintFinding {
+	if certforge.IsSelfSigned(cert) {
+		return nil
+	}
+	var findings []lintFinding
+	if len(cert.IssuingCertificateURL) == 0 {
+		findings = append(findings, lintFinding{lintWarn, "CABF BR 7.1.2.7.7", "Authority Information Access has no caIssuers URL"})
+	}
+	if len(cert.OCSPServer) == 0 && len(cert.CRLDistributionPoints) == 0 {
+		findings = append(findings, lintFinding{lintWarn, "CABF BR 7.1.2.11.2", "no OCSP responder or CRL distribution point"})
+	}
+	if len(cert.PolicyIdentifiers) == 0 && !isCertificateAuthority(cert) {
+		findings = append(findings, lintFinding{lintWarn, "CABF BR 7.1.2.7.9", "missing Certificate Policies"})
+	}
+	return findings
+}
+
+// lintCriticalExtensions flags critical extensions that clients cannot process
+func lintCriticalExtensions(cert *x509.Certificate) []lintFinding {
+	var findings []lintFinding
+	for _, oid := range cert.UnhandledCriticalExtensions {
+		findings = append(findings, lintFinding{lintNotice, "RFC 5280 4.2", fmt.Sprintf("critical extension %s may be rejected by clients that do not recognize it", extensionName(oid))})
+	}
+	return findings
+}
@@ -0,0 +1,244 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// certMetrics is the state exported in the Prometheus text format on /metrics
+type certMetrics struct {
+	mu      sync.Mutex
+	managed map[string]*managedMetric
+	watch   []string
+
+	// trackIssuance is set by the daemon, the only mode that issues certificates
+	trackIssuance bool
+}
+
+// managedMetric is what the daemon knows about a managed certificate
+type managedMetric struct {
+	Path      string
+	NotAfter  time.Time
+	LastCheck time.Time
+	Issued    int
+	Failures  int
+}
+
+// runMetrics implements the metrics command, which exports the expiry of certificates for Prometheus
+func runMetrics(args []string) error {
+	fs := flag.NewFlagSet("metrics", flag.ExitOnError)
+	listenFlag := fs.String("listen", ":9101", "Address to serve /metrics on")
+	configFlag := fs.String("config", "", "Renewal config whose managed certificates to export")
+	filesFlag := fs.String("files", "", "Comma separated certificate files and directories to watch")
+	parseArgs(fs, args)
+
+	if *configFlag == "" && *filesFlag == "" {
+		return fmt.Errorf("metrics requires --config or --files")
+	}
+	metrics := &certMetrics{managed: map[string]*managedMetric{}}
+	for _, path := range strings.Split(*filesFlag, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			metrics.watch = append(metrics.watch, path)
+		}
+	}
+
+	// Certificates are read at every scrape, so the config is too
+	refresh := func() {
+		if *configFlag == "" {
+			return
+		}
+		cfg, err := loadRenewalConfig(*configFlag)
+		if err != nil {
+			daemonLogf("Metrics: %v", err)
+			return
+		}
+		metrics.setConfig(cfg)
+		for i := range cfg.Certificates {
+			metrics.checked(&cfg.Certificates[i])
+		}
+	}
+	return serveMetrics(*listenFlag, metrics, refresh)
+}
+
+// serveMetrics serves /metrics on addr, calling refresh before each scrape when it is not nil
+func serveMetrics(addr string, metrics *certMetrics, refresh func()) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Failed to listen on %s: %v", addr, err)
+	}
+	daemonLogf("Serving metrics on http://%s/metrics", listener.Addr())
+	return http.Serve(listener, metricsHandler(metrics, refresh))
+}
+
+// metricsHandler returns the handler of /metrics
+func metricsHandler(metrics *certMetrics, refresh func()) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if refresh != nil {
+			refresh()
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(metrics.render(time.Now()))
+	})
+	return mux
+}
+
+// setConfig makes the managed certificates those of cfg, keeping the counts of certificates still listed
+func (m *certMetrics) setConfig(cfg *renewalConfig) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	managed := map[string]*managedMetric{}
+	for _, mc := range cfg.Certificates {
+		if existing, ok := m.managed[mc.Name]; ok {
+			managed[mc.Name] = existing
+		} else {
+			managed[mc.Name] = &managedMetric{}
+		}
+		managed[mc.Name].Path = mc.paths().Cert
+	}
+	m.managed = managed
+}
+
+// checked records that a managed certificate was checked, reading its current expiry
+func (m *certMetrics) checked(mc *managedCertificate) {
+	if m == nil {
+		return
+	}
+	var notAfter time.Time
+	if certs, err := readCertificates(mc.paths().Cert); err == nil {
+		notAfter = certs[0].NotAfter
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metric := m.metric(mc)
+	metric.NotAfter = notAfter
+	metric.LastCheck = time.Now()
+}
+
+// issued counts a successful issuance or renewal of a managed certificate
+func (m *certMetrics) issued(mc *managedCertificate) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metric(mc).Issued++
+}
+
+// failed counts a failed renewal of a managed certificate
+func (m *certMetrics) failed(mc *managedCertificate) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metric(mc).Failures++
+}
+
+// metric returns the metric of mc, creating it; m.mu must be held
+func (m *certMetrics) metric(mc *managedCertificate) *managedMetric {
+	metric, ok := m.managed[mc.Name]
+	if !ok {
+		metric = &managedMetric{Path: mc.paths().Cert}
+		m.managed[mc.Name] = metric
+	}
+	return metric
+}
+
+// render writes all metrics in the Prometheus text exposition format
+func (m *certMetrics) render(now time.Time) []byte {
+	m.mu.Lock()
+	var names []string
+	for name := range m.managed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	managed := map[string]managedMetric{}
+	for _, name := range names {
+		managed[name] = *m.managed[name]
+	}
+	m.mu.Unlock()
+
+	// Watched files are scanned at every scrape like an audit, so new and replaced files are picked up
+	report := &auditReport{}
+	for _, path := range m.watch {
+		if err := scanAuditDir(path, true, report); err != nil {
+			report.Problems = append(report.Problems, auditProblem{Path: path, Message: err.Error()})
+		}
+	}
+
+	var buf bytes.Buffer
+	metricHeader(&buf, "certforge_certificate_expiry_timestamp_seconds", "gauge", "Expiry (notAfter) of the certificate as a Unix timestamp.")
+	for _, name := range names {
+		if metric := managed[name]; !metric.NotAfter.IsZero() {
+			fmt.Fprintf(&buf, "certforge_certificate_expiry_timestamp_seconds%s %d\n",
+				metricLabels("name", name, "path", metric.Path, "source", "managed"), metric.NotAfter.Unix())
+		}
+	}
+	for _, found := range report.Certs {
+		fmt.Fprintf(&buf, "certforge_certificate_expiry_timestamp_seconds%s %d\n",
+			metricLabels("name", filepath.Base(found.Path), "path", found.Path, "source", "watched",
+				"subject", certforge.FormatName(found.Cert.Subject), "serial", found.Cert.SerialNumber.Text(16)), found.Cert.NotAfter.Unix())
+	}
+
+	metricHeader(&buf, "certforge_certificate_last_check_timestamp_seconds", "gauge", "Time the certificate was last checked as a Unix timestamp.")
+	for _, name := range names {
+		if metric := managed[name]; !metric.LastCheck.IsZero() {
+			fmt.Fprintf(&buf, "certforge_certificate_last_check_timestamp_seconds%s %d\n",
+				metricLabels("name", name, "path", metric.Path, "source", "managed"), metric.LastCheck.Unix())
+		}
+	}
+	for _, found := range report.Certs {
+		fmt.Fprintf(&buf, "certforge_certificate_last_check_timestamp_seconds%s %d\n",
+			metricLabels("name", filepath.Base(found.Path), "path", found.Path, "source", "watched",
+				"subject", certforge.FormatName(found.Cert.Subject), "serial", found.Cert.SerialNumber.Text(16)), now.Unix())
+	}
+
+	if m.trackIssuance {
+		metricHeader(&buf, "certforge_certificate_issued_total", "counter", "Certificates issued or renewed since the daemon started.")
+		for _, name := range names {
+			fmt.Fprintf(&buf, "certforge_certificate_issued_total%s %d\n", metricLabels("name", name), managed[name].Issued)
+		}
+		metricHeader(&buf, "certforge_certificate_renewal_failures_total", "counter", "Failed issuances and renewals since the daemon started.")
+		for _, name := range names {
+			fmt.Fprintf(&buf, "certforge_certificate_renewal_failures_total%s %d\n", metricLabels("name", name), managed[name].Failures)
+		}
+	}
+
+	metricHeader(&buf, "certforge_watch_errors", "gauge", "Watched files that could not be read or parsed at the last scrape.")
+	fmt.Fprintf(&buf, "certforge_watch_errors %d\n", len(report.Problems))
+	return buf.Bytes()
+}
+
+// metricHeader writes the HELP and TYPE lines of a metric
+func metricHeader(buf *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// metricLabels formats name and value pairs as a Prometheus label set
+func metricLabels(pairs ...string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
@@ -0,0 +1,117 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// generateInputs are answers to the prompts for a new certificate given as flags or CERTFORGE_ environment
+// variables, which are then not asked for
+type generateInputs struct {
+	CommonName         string
+	Organization       string
+	OrganizationalUnit string
+	Country            string
+	State              string
+	Locality           string
+	Email              string
+	KeySize            string
+	Prefix             string
+	SANs               string
+}
+
+// generateInput is one of the generateInputs with its flag and environment variable
+type generateInput struct {
+	value *string
+	flag  string
+	env   string
+	usage string
+}
+
+// fields lists the inputs with their flags and environment variables
+func (in *generateInputs) fields() []generateInput {
+	return []generateInput{
+		{&in.CommonName, "cn", "CERTFORGE_CN", "Common name, such as example.com"},
+		{&in.Organization, "org", "CERTFORGE_ORG", "Organization"},
+		{&in.OrganizationalUnit, "ou", "CERTFORGE_OU", "Organizational unit"},
+		{&in.Country, "country", "CERTFORGE_COUNTRY", "Two letter country code"},
+		{&in.State, "state", "CERTFORGE_STATE", "State or province"},
+		{&in.Locality, "locality", "CERTFORGE_LOCALITY", "Locality or city"},
+		{&in.Email, "email", "CERTFORGE_EMAIL", "Email address"},
+		{&in.KeySize, "key-size", "CERTFORGE_KEY_SIZE", "RSA key size: 2048, 3072, or 4096"},
+		{&in.Prefix, "prefix", "CERTFORGE_PREFIX", "Output file prefix"},
+		{&in.SANs, "san", "CERTFORGE_SANS", "Comma-separated Subject Alternative Names"},
+	}
+}
+
+// register defines the flags of the inputs
+func (in *generateInputs) register(fs *flag.FlagSet) {
+	for _, field := range in.fields() {
+		fs.StringVar(field.value, field.flag, "", field.usage+" (or "+field.env+")")
+	}
+}
+
+// fromEnv fills the inputs not given as flags from their environment variables
+func (in *generateInputs) fromEnv() {
+	for _, field := range in.fields() {
+		if *field.value == "" {
+			*field.value = strings.TrimSpace(os.Getenv(field.env))
+		}
+	}
+}
+
+// keySize returns the RSA key size given, or def when none was
+func (in *generateInputs) keySize(def int) (int, error) {
+	if in.KeySize == "" {
+		return def, nil
+	}
+	size, err := strconv.Atoi(in.KeySize)
+	if err != nil || (size != 2048 && size != 3072 && size != 4096) {
+		return 0, fmt.Errorf("Invalid key size %q (use 2048, 3072, or 4096)", in.KeySize)
+	}
+	return size, nil
+}
+
+// sans returns the Subject Alternative Names given
+func (in *generateInputs) sans() []string {
+	var names []string
+	for _, name := range strings.Split(in.SANs, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// stdinIsTerminal reports whether standard input is a terminal that prompts can be answered on. /dev/null, as in
+// Docker builds, is a character device but not a terminal.
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// prompter asks for the inputs that were not given, or takes their defaults when it is not interactive
+type prompter struct {
+	reader      *bufio.Reader
+	interactive bool
+}
+
+// value returns given when it is set, and otherwise asks for the value, or returns def when not interactive
+func (p *prompter) value(label, given, def string) string {
+	if given != "" {
+		return given
+	}
+	if !p.interactive {
+		return def
+	}
+	return promptDefault(p.reader, label, def)
+}
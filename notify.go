@@ -0,0 +1,266 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// notifyConfig lists where expiry notifications are sent, and at which remaining lifetimes
+type notifyConfig struct {
+	Thresholds []string     `yaml:"thresholds"`
+	Webhook    string       `yaml:"webhook"`
+	Slack      string       `yaml:"slack"`
+	Email      *notifyEmail `yaml:"email"`
+
+	thresholds []time.Duration
+}
+
+// notifyEmail is an SMTP server and the addresses notifications are mailed to
+type notifyEmail struct {
+	SMTP     string   `yaml:"smtp"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// expiryNotice is the payload sent when a certificate crosses a warning threshold
+type expiryNotice struct {
+	Event         string    `json:"event"`
+	Source        string    `json:"source"`
+	Name          string    `json:"name,omitempty"`
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	Serial        string    `json:"serial"`
+	Domains       []string  `json:"domains,omitempty"`
+	Path          string    `json:"path"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysLeft      int       `json:"days_left"`
+	ThresholdDays int       `json:"threshold_days"`
+	Host          string    `json:"host"`
+}
+
+// notifyTimeout bounds each delivery, so an unreachable target does not stall renewals
+const notifyTimeout = 30 * time.Second
+
+// loadNotifyConfig reads a file holding only a notification config, as used by audit --notify
+func loadNotifyConfig(path string) (*notifyConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	cfg := &notifyConfig{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// validate checks the targets and parses the thresholds, longest first (default: 30d, 14d, 7d, 1d)
+func (n *notifyConfig) validate() error {
+	if n.Webhook == "" && n.Slack == "" && n.Email == nil {
+		return fmt.Errorf("notify needs a webhook, slack, or email target")
+	}
+	if n.Email != nil {
+		if n.Email.SMTP == "" || n.Email.From == "" || len(n.Email.To) == 0 {
+			return fmt.Errorf("notify email requires smtp, from, and to")
+		}
+		if _, _, err := net.SplitHostPort(n.Email.SMTP); err != nil {
+			return fmt.Errorf("notify email smtp must be host:port: %v", err)
+		}
+	}
+
+	var err error
+	if n.thresholds, err = parseExpiryThresholds(n.Thresholds); err != nil {
+		return fmt.Errorf("Invalid notify threshold: %v", err)
+	}
+	return nil
+}
+
+// parseExpiryThresholds parses warning thresholds, longest first (default: 30d, 14d, 7d, 1d)
+func parseExpiryThresholds(values []string) ([]time.Duration, error) {
+	if len(values) == 0 {
+		values = []string{"30d", "14d", "7d", "1d"}
+	}
+	var thresholds []time.Duration
+	for _, value := range values {
+		threshold, err := parseThreshold(value)
+		if err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] > thresholds[j] })
+	return thresholds, nil
+}
+
+// level returns how many thresholds remaining has crossed, counting expiry as one more
+func (n *notifyConfig) level(remaining time.Duration) int {
+	return thresholdLevel(n.thresholds, remaining)
+}
+
+// thresholdLevel returns how many of thresholds remaining has crossed, counting expiry as one more
+func thresholdLevel(thresholds []time.Duration, remaining time.Duration) int {
+	if remaining <= 0 {
+		return len(thresholds) + 1
+	}
+	level := 0
+	for _, threshold := range thresholds {
+		if remaining <= threshold {
+			level++
+		}
+	}
+	return level
+}
+
+// newExpiryNotice describes cert, found at path, for a notification
+func newExpiryNotice(source, name, path string, cert *x509.Certificate, threshold time.Duration, now time.Time) expiryNotice {
+	host, _ := os.Hostname()
+	notice := expiryNotice{
+		Event:         "certificate_expiring",
+		Source:        source,
+		Name:          name,
+		Subject:       certforge.FormatName(cert.Subject),
+		Issuer:        certforge.FormatName(cert.Issuer),
+		Serial:        cert.SerialNumber.Text(16),
+		Domains:       cert.DNSNames,
+		Path:          path,
+		NotAfter:      cert.NotAfter.UTC(),
+		DaysLeft:      int(cert.NotAfter.Sub(now).Hours() / 24),
+		ThresholdDays: int(threshold.Hours() / 24),
+		Host:          host,
+	}
+	if !now.Before(cert.NotAfter) {
+		notice.Event = "certificate_expired"
+	}
+	return notice
+}
+
+// summary is the one-line description of the notice used for Slack and email
+func (notice expiryNotice) summary() string {
+	if notice.Event == "certificate_expired" {
+		return fmt.Sprintf("Certificate %s EXPIRED on %s (%s on %s)", notice.Subject, notice.NotAfter.Format("2006-01-02"), notice.Path, notice.Host)
+	}
+	return fmt.Sprintf("Certificate %s expires in %d days, on %s (%s on %s)", notice.Subject, notice.DaysLeft, notice.NotAfter.Format("2006-01-02"), notice.Path, notice.Host)
+}
+
+// send delivers the notice to every configured target, trying them all before reporting failures
+func (n *notifyConfig) send(notice expiryNotice) error {
+	var failures []string
+	if n.Webhook != "" {
+		payload, _ := json.Marshal(notice)
+		if err := postNotification(n.Webhook, payload); err != nil {
+			failures = append(failures, fmt.Sprintf("webhook: %v", err))
+		}
+	}
+	if n.Slack != "" {
+		// Slack incoming webhooks take the message as text
+		prefix := ":warning: "
+		if notice.Event == "certificate_expired" {
+			prefix = ":rotating_light: "
+		}
+		payload, _ := json.Marshal(map[string]string{"text": prefix + notice.summary()})
+		if err := postNotification(n.Slack, payload); err != nil {
+			failures = append(failures, fmt.Sprintf("slack: %v", err))
+		}
+	}
+	if n.Email != nil {
+		if err := n.Email.send(notice); err != nil {
+			failures = append(failures, fmt.Sprintf("email: %v", err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Failed to send notification: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// postNotification POSTs a JSON payload and checks for a 2xx response
+func postNotification(url string, payload []byte) error {
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// send mails the notice, authenticating when a username is set
+func (e *notifyEmail) send(notice expiryNotice) error {
+	var auth smtp.Auth
+	if e.Username != "" {
+		password, err := readPassphrase(e.Password)
+		if err != nil {
+			return err
+		}
+		host, _, _ := net.SplitHostPort(e.SMTP)
+		auth = smtp.PlainAuth("", e.Username, password, host)
+	}
+
+	details, _ := json.MarshalIndent(notice, "", "  ")
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [certforge] %s\r\n", notice.summary())
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(notice.summary() + "\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(string(details), "\n", "\r\n") + "\r\n")
+	return smtp.SendMail(e.SMTP, auth, e.From, e.To, msg.Bytes())
+}
+
+// expiryNotifier remembers the thresholds already notified, so each is sent once per certificate
+type expiryNotifier struct {
+	notified map[string]int
+}
+
+// check notifies about cert when it has crossed a threshold not yet notified
+func (en *expiryNotifier) check(cfg *notifyConfig, source, name, path string, cert *x509.Certificate, now time.Time) {
+	if en == nil || cfg == nil {
+		return
+	}
+	// A renewed certificate has a new serial, and starts over
+	id := path + "\x00" + cert.SerialNumber.String()
+	level := cfg.level(cert.NotAfter.Sub(now))
+	if level == 0 || level <= en.notified[id] {
+		return
+	}
+
+	var threshold time.Duration
+	if level <= len(cfg.thresholds) {
+		threshold = cfg.thresholds[level-1]
+	}
+	notice := newExpiryNotice(source, name, path, cert, threshold, now)
+	if err := cfg.send(notice); err != nil {
+		daemonLogf("%s: %v", name, err)
+		return
+	}
+	en.notified[id] = level
+	daemonLogf("%s: notified: %s", name, notice.summary())
+}
@@ -0,0 +1,212 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// nssCommands maps the subcommands of the nss command to their implementations
+var nssCommands = map[string]func(args []string) error{
+	"add":    runNSSAdd,
+	"remove": runNSSRemove,
+}
+
+// nssProfileDirs are the directories, relative to the home directory, holding Firefox profiles
+var nssProfileDirs = []string{
+	".mozilla/firefox",
+	"snap/firefox/common/.mozilla/firefox",
+	".var/app/org.mozilla.firefox/.mozilla/firefox",
+	"Library/Application Support/Firefox/Profiles",
+}
+
+// runNSS implements the nss command, which dispatches on the subcommand
+func runNSS(args []string) error {
+	var names []string
+	for name := range nssCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return fmt.Errorf("nss requires a subcommand: %s", strings.Join(names, ", "))
+	}
+	command, ok := nssCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown nss subcommand %q (use %s)", args[0], strings.Join(names, ", "))
+	}
+	return command(args[1:])
+}
+
+// runNSSAdd implements nss add, which trusts a certificate in NSS databases
+func runNSSAdd(args []string) error {
+	fs := flag.NewFlagSet("nss add", flag.ExitOnError)
+	certFlag := fs.String("cert", "", "CA certificate, or self-signed server certificate, to trust")
+	nameFlag := fs.String("name", "", "Nickname of the certificate in the database (default: its common name)")
+	dbFlag := fs.String("db", "", "Comma separated NSS database directories (default: ~/.pki/nssdb and Firefox profiles)")
+	parseArgs(fs, args)
+
+	if *certFlag == "" {
+		return fmt.Errorf("nss add requires --cert")
+	}
+	certs, err := readCertificates(*certFlag)
+	if err != nil {
+		return err
+	}
+	cert := certs[0]
+
+	// CAs are trusted to issue server certificates; a self-signed server certificate is trusted as a peer
+	trust := "C,,"
+	if !cert.IsCA {
+		if !certforge.IsSelfSigned(cert) {
+			return fmt.Errorf("%s is issued by %s; trust that CA instead", *certFlag, certforge.FormatName(cert.Issuer))
+		}
+		trust = "P,,"
+	}
+
+	certutil, databases, err := nssSetup(*dbFlag)
+	if err != nil {
+		return err
+	}
+	name := nssNickname(cert, *nameFlag)
+
+	fmt.Printf("Trusting %s as %q\n", certforge.FormatName(cert.Subject), name)
+	failed := 0
+	for _, db := range databases {
+		output, err := exec.Command(certutil, "-A", "-d", db, "-t", trust, "-n", name, "-i", *certFlag).CombinedOutput()
+		if err != nil {
+			fmt.Printf("  %s: FAILED (%s)\n", db, nssError(output, err))
+			failed++
+			continue
+		}
+		fmt.Printf("  %s: added\n", db)
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to update %d of %d databases", failed, len(databases))
+	}
+	fmt.Println("\nRestart Firefox and Chrome for them to pick up the change.")
+	return nil
+}
+
+// runNSSRemove implements nss remove, which deletes a certificate from NSS databases
+func runNSSRemove(args []string) error {
+	fs := flag.NewFlagSet("nss remove", flag.ExitOnError)
+	certFlag := fs.String("cert", "", "Certificate to remove, used to find its default nickname")
+	nameFlag := fs.String("name", "", "Nickname of the certificate in the database")
+	dbFlag := fs.String("db", "", "Comma separated NSS database directories (default: ~/.pki/nssdb and Firefox profiles)")
+	parseArgs(fs, args)
+
+	if *certFlag == "" && *nameFlag == "" {
+		return fmt.Errorf("nss remove requires --cert or --name")
+	}
+	name := *nameFlag
+	if name == "" {
+		certs, err := readCertificates(*certFlag)
+		if err != nil {
+			return err
+		}
+		name = nssNickname(certs[0], "")
+	}
+
+	certutil, databases, err := nssSetup(*dbFlag)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removing %q\n", name)
+	for _, db := range databases {
+		// A database without the certificate is not an error
+		if exec.Command(certutil, "-L", "-d", db, "-n", name).Run() != nil {
+			fmt.Printf("  %s: not present\n", db)
+			continue
+		}
+		output, err := exec.Command(certutil, "-D", "-d", db, "-n", name).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Failed to remove %q from %s: %s", name, db, nssError(output, err))
+		}
+		fmt.Printf("  %s: removed\n", db)
+	}
+	return nil
+}
+
+// nssSetup finds certutil and the databases to update, given as a comma separated list or discovered
+func nssSetup(list string) (string, []string, error) {
+	certutil, err := exec.LookPath("certutil")
+	if err != nil {
+		return "", nil, fmt.Errorf("certutil not found; install libnss3-tools (Debian/Ubuntu), nss-tools (Fedora), or nss (Homebrew)")
+	}
+
+	var databases []string
+	if list != "" {
+		for _, dir := range strings.Split(list, ",") {
+			if dir = strings.TrimSpace(dir); dir == "" {
+				continue
+			}
+			db, ok := nssDatabase(dir)
+			if !ok {
+				return "", nil, fmt.Errorf("%s is not an NSS database directory (no cert9.db or cert8.db)", dir)
+			}
+			databases = append(databases, db)
+		}
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil, fmt.Errorf("Cannot find the home directory: %v", err)
+		}
+		candidates := []string{filepath.Join(home, ".pki", "nssdb")}
+		for _, dir := range nssProfileDirs {
+			profiles, _ := filepath.Glob(filepath.Join(home, dir, "*"))
+			candidates = append(candidates, profiles...)
+		}
+		for _, dir := range candidates {
+			if db, ok := nssDatabase(dir); ok {
+				databases = append(databases, db)
+			}
+		}
+	}
+	if len(databases) == 0 {
+		return "", nil, fmt.Errorf("No NSS databases found; start Chrome or Firefox once, or name one with --db")
+	}
+	return certutil, databases, nil
+}
+
+// nssDatabase returns the certutil -d argument for dir, choosing the SQLite or legacy Berkeley DB format
+func nssDatabase(dir string) (string, bool) {
+	if _, err := os.Stat(filepath.Join(dir, "cert9.db")); err == nil {
+		return "sql:" + dir, true
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cert8.db")); err == nil {
+		return "dbm:" + dir, true
+	}
+	return "", false
+}
+
+// nssNickname returns name, or a nickname derived from the certificate's subject
+func nssNickname(cert *x509.Certificate, name string) string {
+	if name != "" {
+		return name
+	}
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return certforge.FormatName(cert.Subject)
+}
+
+// nssError returns the first line of certutil's output, or the error when there is none
+func nssError(output []byte, err error) string {
+	if line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n"); line != "" {
+		return line
+	}
+	return err.Error()
+}
@@ -0,0 +1,109 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// ocspStatusNames maps OCSP certificate statuses to readable names
+var ocspStatusNames = map[int]string{
+	ocsp.Good:    "good",
+	ocsp.Revoked: "REVOKED",
+	ocsp.Unknown: "unknown",
+}
+
+// csrMustStaple returns the Must-Staple extension when csr asks for it, for the certificate signed for it
+func csrMustStaple(csr *x509.CertificateRequest) []pkix.Extension {
+	if certforge.HasMustStaple(csr.Extensions) {
+		return []pkix.Extension{certforge.MustStapleExtension()}
+	}
+	return nil
+}
+
+// printOCSPStaple validates and displays the OCSP response a server stapled to its handshake
+func printOCSPStaple(staple []byte, certs []*x509.Certificate) {
+	fmt.Println("=== OCSP Stapling ===")
+	fmt.Println()
+
+	leaf := certs[0]
+	mustStaple := certforge.HasMustStaple(leaf.Extensions)
+	if mustStaple {
+		fmt.Println("Must-Staple: yes (certificate requires a stapled response)")
+	} else {
+		fmt.Println("Must-Staple: no")
+	}
+
+	if len(staple) == 0 {
+		if mustStaple {
+			fmt.Println("Stapled Response: " + colorize("NONE (Must-Staple is not honored)", expiryExpired))
+		} else {
+			fmt.Println("Stapled Response: none")
+		}
+		return
+	}
+
+	issuer := findIssuer(leaf, certs[1:])
+	var resp *ocsp.Response
+	var err error
+	if issuer != nil {
+		// Also checks that the response is for this certificate and signed for its issuer
+		resp, err = ocsp.ParseResponseForCert(staple, leaf, issuer)
+		if err != nil {
+			// Responses signed by the issuer itself may embed the issuer certificate, which
+			// ParseResponseForCert rejects since it expects a delegated responder
+			if direct, directErr := ocsp.ParseResponseForCert(staple, leaf, nil); directErr == nil && direct.Certificate != nil && direct.Certificate.Equal(issuer) {
+				resp, err = direct, nil
+			}
+		}
+	} else {
+		resp, err = ocsp.ParseResponse(staple, nil)
+	}
+	if err != nil {
+		fmt.Printf("Stapled Response: %s\n", colorize(fmt.Sprintf("INVALID (%v)", err), expiryExpired))
+		return
+	}
+
+	fmt.Println("Stapled Response: yes")
+	status := ocspStatusNames[resp.Status]
+	level := expiryOK
+	if resp.Status != ocsp.Good {
+		level = expiryExpired
+	}
+	fmt.Printf("  Certificate Status: %s\n", colorize(status, level))
+	if resp.Status == ocsp.Revoked {
+		fmt.Printf("  Revoked At: %s (%s)\n", resp.RevokedAt.Format(time.RFC3339), crlReasonName(resp.RevocationReason))
+	}
+	fmt.Printf("  Produced At: %s\n", resp.ProducedAt.Format(time.RFC3339))
+	fmt.Printf("  This Update: %s\n", resp.ThisUpdate.Format(time.RFC3339))
+	if resp.NextUpdate.IsZero() {
+		fmt.Println("  Next Update: not set")
+	} else {
+		fmt.Printf("  Next Update: %s\n", resp.NextUpdate.Format(time.RFC3339))
+	}
+
+	now := time.Now()
+	switch {
+	case now.Before(resp.ThisUpdate):
+		fmt.Printf("  Freshness: %s\n", colorize("NOT YET VALID (thisUpdate is in the future)", expiryWarning))
+	case !resp.NextUpdate.IsZero() && now.After(resp.NextUpdate):
+		fmt.Printf("  Freshness: %s\n", colorize("STALE (nextUpdate has passed)", expiryExpired))
+	default:
+		fmt.Printf("  Freshness: %s\n", colorize(fmt.Sprintf("fresh (produced %s ago)", now.Sub(resp.ProducedAt).Round(time.Minute)), expiryOK))
+	}
+
+	if issuer != nil {
+		fmt.Println("  Signature: valid")
+	} else {
+		fmt.Println("  Signature: not verified (issuer certificate not presented)")
+	}
+}
@@ -0,0 +1,176 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// minPassphraseBits is the estimated entropy below which passphrases for new encrypted files are refused
+const minPassphraseBits = 50
+
+// commonPassphrases are refused whatever their length, as they are the first guesses of any cracking tool. They are
+// compared ignoring case and trailing digits and symbols, so Password123! is refused too.
+var commonPassphrases = []string{
+	"password", "passw0rd", "passphrase", "qwerty", "qwertyuiop", "letmein", "welcome", "admin", "administrator",
+	"changeit", "changeme", "secret", "default", "iloveyou", "monkey", "dragon", "trustno1", "abc", "certforge",
+	"keystore", "private", "privatekey",
+}
+
+// generatedPassphraseAlphabet leaves out characters that are easily mistaken for one another when read aloud or copied
+const generatedPassphraseAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+// readPassphrase resolves an OpenSSL-style passphrase argument:
+//
+//	pass:<password>  the password itself
+//	env:<var>        read from an environment variable
+//	file:<path>      first line of a file
+//	stdin            first line of standard input
+func readPassphrase(spec string) (string, error) {
+	switch {
+	case strings.HasPrefix(spec, "pass:"):
+		return strings.TrimPrefix(spec, "pass:"), nil
+
+	case strings.HasPrefix(spec, "env:"):
+		name := strings.TrimPrefix(spec, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("Environment variable %s is not set", name)
+		}
+		return value, nil
+
+	case strings.HasPrefix(spec, "file:"):
+		data, err := os.ReadFile(strings.TrimPrefix(spec, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("Error reading passphrase file: %v", err)
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		return strings.TrimRight(line, "\r"), nil
+
+	case spec == "stdin":
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("Error reading passphrase from stdin: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	return "", fmt.Errorf("Invalid passphrase source %q (use pass:, env:, file:, or stdin)", spec)
+}
+
+// readNewPassphrase resolves the passphrase a new key or bundle is encrypted with. Besides the sources of
+// readPassphrase it takes generate, which makes a random passphrase and prints it once, and generate:<path>, which
+// writes it to a new file readable only by its owner. Passphrases that are given are refused when they are weak,
+// unless allowWeak is set.
+func readNewPassphrase(spec string, allowWeak bool) (string, error) {
+	if spec == "generate" || strings.HasPrefix(spec, "generate:") {
+		password := generatePassphrase()
+		path, toFile := strings.CutPrefix(spec, "generate:")
+		if !toFile {
+			// Standard error, so the passphrase stays out of output that is redirected to a file
+			fmt.Fprintf(os.Stderr, "Generated passphrase (shown only once, store it now): %s\n", password)
+			return password, nil
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return "", fmt.Errorf("Failed to write passphrase file: %v", err)
+		}
+		_, err = f.WriteString(password + "\n")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", fmt.Errorf("Failed to write passphrase file: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote the generated passphrase to %s\n", path)
+		return password, nil
+	}
+
+	password, err := readPassphrase(spec)
+	if err != nil {
+		return "", err
+	}
+	if !allowWeak {
+		if bits := passphraseBits(password); bits < minPassphraseBits {
+			return "", fmt.Errorf("Weak passphrase, about %d bits of entropy (use at least %d bits, --passout generate for a random one, or --allow-weak-pass)", int(bits), minPassphraseBits)
+		}
+	}
+	return password, nil
+}
+
+// generatePassphrase returns a random passphrase of four groups of six characters, about 120 bits
+func generatePassphrase() string {
+	random := make([]byte, 24)
+	rand.Read(random)
+	var b strings.Builder
+	for i, r := range random {
+		if i > 0 && i%6 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(generatedPassphraseAlphabet[int(r)%len(generatedPassphraseAlphabet)])
+	}
+	return b.String()
+}
+
+// passphraseBits estimates the entropy of a passphrase from the size of the character classes it uses and its length.
+// Characters that repeat or continue a sequence of the previous one, as in aaaa, 1234, or abcd, add one bit, and
+// common passwords add none.
+func passphraseBits(password string) float64 {
+	base := strings.ToLower(strings.TrimRightFunc(password, func(r rune) bool {
+		return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	}))
+	for _, common := range commonPassphrases {
+		if base == common {
+			return 0
+		}
+	}
+
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < 0x80:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(pool))
+	bits := 0.0
+	prev := rune(-1)
+	for i, r := range password {
+		if i > 0 && (r == prev || r == prev+1 || r == prev-1) {
+			bits++
+		} else {
+			bits += perChar
+		}
+		prev = r
+	}
+	return bits
+}
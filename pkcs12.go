@@ -0,0 +1,967 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// OIDs used by PKCS#12 (RFC 7292) and the password based encryption schemes it relies on
+var (
+	oidPKCS7Data          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7EncryptedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidShroudedKeyBag   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidSafeContentsBag  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 6}
+	oidCertTypeX509     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidAttrFriendlyName = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidAttrLocalKeyID   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHA3DES   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHA128RC2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+	oidPBEWithSHA40RC2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBES2            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1     = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDigestSHA1       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+// maxPKCS12Iterations bounds the iteration counts read from PKCS#12 files, so that a crafted file cannot keep the
+// key derivation running indefinitely. Real files use a few thousand, and at most a few hundred thousand.
+const maxPKCS12Iterations = 10000000
+
+// errPKCS12Password is returned when the MAC or decryption of a PKCS#12 file fails
+var errPKCS12Password = errors.New("Incorrect PKCS#12 password (use --passin to supply one)")
+
+// pfxPDU is the outer PFX structure of a PKCS#12 file
+type pfxPDU struct {
+	Version  int
+	AuthSafe pkcs7ContentInfo
+	MacData  pkcs12MacData `asn1:"optional"`
+}
+
+// pkcs12MacData holds the integrity MAC protecting the authenticated safe
+type pkcs12MacData struct {
+	Mac        pkcs12DigestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+// pkcs12DigestInfo is a digest together with its algorithm
+type pkcs12DigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+// pkcs12EncryptedData is the encryptedData content type used for encrypted safes
+type pkcs12EncryptedData struct {
+	Version              int
+	EncryptedContentInfo pkcs12EncryptedContentInfo
+}
+
+// pkcs12EncryptedContentInfo describes the encrypted content of an encrypted safe
+type pkcs12EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+// pkcs12SafeBag is a single bag inside a safe
+type pkcs12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+// pkcs12Attribute is a bag attribute such as friendlyName or localKeyID
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// pkcs12CertBag wraps a DER encoded certificate
+type pkcs12CertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+// pkcs8EncryptedPrivateKeyInfo is the encrypted private key stored in shrouded key bags
+type pkcs8EncryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+// pkcs12PBEParams are the parameters of the PKCS#12 password based encryption schemes
+type pkcs12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// pbes2Params are the parameters of the PKCS#5 PBES2 scheme
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the parameters of the PKCS#5 PBKDF2 key derivation function
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// pkcs12Key is a private key found in a PKCS#12 file
+type pkcs12Key struct {
+	Key          crypto.PrivateKey
+	FriendlyName string
+	LocalKeyID   []byte
+	Encryption   string
+}
+
+// pkcs12Cert is a certificate found in a PKCS#12 file
+type pkcs12Cert struct {
+	Cert         *x509.Certificate
+	FriendlyName string
+	LocalKeyID   []byte
+}
+
+// pkcs12Contents holds everything decoded from a PKCS#12 file
+type pkcs12Contents struct {
+	Keys           []pkcs12Key
+	Certs          []pkcs12Cert
+	MacAlgorithm   string
+	CertEncryption string
+}
+
+// decodePKCS12 decrypts and decodes a PKCS#12 (PFX) file
+func decodePKCS12(data []byte, password string) (*pkcs12Contents, error) {
+	// Windows tooling frequently emits BER with indefinite lengths
+	der, err := berToDER(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse PKCS#12 file: %v", err)
+	}
+
+	var pfx pfxPDU
+	if rest, err := asn1.Unmarshal(der, &pfx); err != nil {
+		return nil, fmt.Errorf("Failed to parse PKCS#12 file: %v", err)
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("Trailing data after PKCS#12 structure")
+	}
+	if pfx.Version != 3 {
+		return nil, fmt.Errorf("Unsupported PKCS#12 version: %d", pfx.Version)
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidPKCS7Data) {
+		return nil, fmt.Errorf("Only password integrity mode PKCS#12 files are supported")
+	}
+
+	var authSafeData []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeData); err != nil {
+		return nil, fmt.Errorf("Failed to parse PKCS#12 authenticated safe: %v", err)
+	}
+
+	contents := &pkcs12Contents{}
+	bmpPassword := bmpString(password)
+
+	if len(pfx.MacData.Mac.Digest) > 0 {
+		newHash, name, err := pkcs12DigestHash(pfx.MacData.Mac.Algorithm.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		contents.MacAlgorithm = "HMAC-" + name
+		if err := checkPKCS12Iterations(pfx.MacData.Iterations); err != nil {
+			return nil, err
+		}
+
+		if !verifyPKCS12MAC(newHash, &pfx.MacData, authSafeData, bmpPassword) {
+			// Some tools encode an empty password as zero bytes rather than a BMP terminator
+			if password != "" || !verifyPKCS12MAC(newHash, &pfx.MacData, authSafeData, nil) {
+				return nil, errPKCS12Password
+			}
+			bmpPassword = nil
+		}
+	}
+
+	var authSafe []pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(authSafeData, &authSafe); err != nil {
+		return nil, fmt.Errorf("Failed to parse PKCS#12 authenticated safe: %v", err)
+	}
+
+	for _, ci := range authSafe {
+		var safeContents []byte
+
+		switch {
+		case ci.ContentType.Equal(oidPKCS7Data):
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &safeContents); err != nil {
+				return nil, fmt.Errorf("Failed to parse PKCS#12 safe: %v", err)
+			}
+
+		case ci.ContentType.Equal(oidPKCS7EncryptedData):
+			var ed pkcs12EncryptedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+				return nil, fmt.Errorf("Failed to parse PKCS#12 encrypted safe: %v", err)
+			}
+			alg := ed.EncryptedContentInfo.ContentEncryptionAlgorithm
+			safeContents, err = pbeDecrypt(alg, ed.EncryptedContentInfo.EncryptedContent, password, bmpPassword)
+			if err != nil {
+				return nil, err
+			}
+			contents.CertEncryption = pbeAlgorithmName(alg)
+
+		default:
+			return nil, fmt.Errorf("Unsupported PKCS#12 safe content type: %s", ci.ContentType)
+		}
+
+		if err := contents.addSafeContents(safeContents, password, bmpPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	return contents, nil
+}
+
+// addSafeContents decodes the bags of a safe and adds the keys and certificates found
+func (c *pkcs12Contents) addSafeContents(data []byte, password string, bmpPassword []byte) error {
+	var bags []pkcs12SafeBag
+	if _, err := asn1.Unmarshal(data, &bags); err != nil {
+		return fmt.Errorf("Failed to parse PKCS#12 safe contents: %v", err)
+	}
+
+	for _, bag := range bags {
+		friendlyName, localKeyID := pkcs12BagAttributes(bag.Attributes)
+
+		switch {
+		case bag.ID.Equal(oidCertBag):
+			var certBag pkcs12CertBag
+			if _, err := asn1.Unmarshal(bag.Value.Bytes, &certBag); err != nil {
+				return fmt.Errorf("Failed to parse PKCS#12 certificate bag: %v", err)
+			}
+			if !certBag.ID.Equal(oidCertTypeX509) {
+				continue
+			}
+			cert, err := x509.ParseCertificate(certBag.Data)
+			if err != nil {
+				return fmt.Errorf("Failed to parse certificate: %v", err)
+			}
+			c.Certs = append(c.Certs, pkcs12Cert{Cert: cert, FriendlyName: friendlyName, LocalKeyID: localKeyID})
+
+		case bag.ID.Equal(oidKeyBag):
+			key, err := x509.ParsePKCS8PrivateKey(bag.Value.Bytes)
+			if err != nil {
+				return fmt.Errorf("Failed to parse private key: %v", err)
+			}
+			c.Keys = append(c.Keys, pkcs12Key{Key: key, FriendlyName: friendlyName, LocalKeyID: localKeyID, Encryption: "none"})
+
+		case bag.ID.Equal(oidShroudedKeyBag):
+			var info pkcs8EncryptedPrivateKeyInfo
+			if _, err := asn1.Unmarshal(bag.Value.Bytes, &info); err != nil {
+				return fmt.Errorf("Failed to parse PKCS#12 shrouded key bag: %v", err)
+			}
+			der, err := pbeDecrypt(info.Algorithm, info.Data, password, bmpPassword)
+			if err != nil {
+				return err
+			}
+			key, err := x509.ParsePKCS8PrivateKey(der)
+			if err != nil {
+				return fmt.Errorf("Failed to parse private key: %v", err)
+			}
+			c.Keys = append(c.Keys, pkcs12Key{Key: key, FriendlyName: friendlyName, LocalKeyID: localKeyID, Encryption: pbeAlgorithmName(info.Algorithm)})
+
+		case bag.ID.Equal(oidSafeContentsBag):
+			if err := c.addSafeContents(bag.Value.Bytes, password, bmpPassword); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// pkcs12Iterations is the iteration count used for key derivation and the MAC when exporting
+const pkcs12Iterations = 2048
+
+// encodePKCS12 builds a password protected PKCS#12 file holding a private key, its certificate,
+// and the rest of the chain. Legacy mode uses the SHA-1, 3DES, and RC2 algorithms that older
+// Windows and Java versions require; otherwise PBES2 with AES-256 and a SHA-256 MAC are used.
+func encodePKCS12(key crypto.PrivateKey, cert *x509.Certificate, chain []*x509.Certificate, password, friendlyName string, legacy bool) ([]byte, error) {
+	bmpPassword := bmpString(password)
+
+	keyAlg, certAlg := pbes2Algorithm, pbes2Algorithm
+	macHash, macOID := sha256.New, oidDigestSHA256
+	if legacy {
+		keyAlg = func() (pkix.AlgorithmIdentifier, error) { return pkcs12PBEAlgorithm(oidPBEWithSHA3DES) }
+		certAlg = func() (pkix.AlgorithmIdentifier, error) { return pkcs12PBEAlgorithm(oidPBEWithSHA40RC2) }
+		macHash, macOID = sha1.New, oidDigestSHA1
+	}
+
+	// The key and its certificate are linked by a localKeyID holding the certificate's SHA-1 hash
+	localKeyID := sha1.Sum(cert.Raw)
+	attrs, err := pkcs12EncodeAttributes(friendlyName, localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Shrouded key bag in its own unencrypted safe
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode private key: %v", err)
+	}
+	alg, err := keyAlg()
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := pbeEncrypt(alg, pkcs8, password, bmpPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyBag, err := pkcs12EncodeBag(oidShroudedKeyBag, pkcs8EncryptedPrivateKeyInfo{Algorithm: alg, Data: encryptedKey}, attrs)
+	if err != nil {
+		return nil, err
+	}
+	keySafe, err := asn1.Marshal([]pkcs12SafeBag{keyBag})
+	if err != nil {
+		return nil, err
+	}
+
+	// Certificate bags, leaf first, in an encrypted safe
+	var certBags []pkcs12SafeBag
+	for i, c := range append([]*x509.Certificate{cert}, chain...) {
+		var bagAttrs []pkcs12Attribute
+		if i == 0 {
+			bagAttrs = attrs
+		}
+		bag, err := pkcs12EncodeBag(oidCertBag, pkcs12CertBag{ID: oidCertTypeX509, Data: c.Raw}, bagAttrs)
+		if err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, bag)
+	}
+	certSafe, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	if alg, err = certAlg(); err != nil {
+		return nil, err
+	}
+	encryptedCerts, err := pbeEncrypt(alg, certSafe, password, bmpPassword)
+	if err != nil {
+		return nil, err
+	}
+	encryptedData, err := asn1.Marshal(pkcs12EncryptedData{
+		EncryptedContentInfo: pkcs12EncryptedContentInfo{
+			ContentType:                oidPKCS7Data,
+			ContentEncryptionAlgorithm: alg,
+			EncryptedContent:           encryptedCerts,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keyData, err := asn1.Marshal(keySafe)
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := asn1.Marshal([]pkcs7ContentInfo{
+		{ContentType: oidPKCS7EncryptedData, Content: explicitTag0(encryptedData)},
+		{ContentType: oidPKCS7Data, Content: explicitTag0(keyData)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// MAC over the authenticated safe
+	macData := pkcs12MacData{MacSalt: make([]byte, 8), Iterations: pkcs12Iterations}
+	if _, err := rand.Read(macData.MacSalt); err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(macHash, 3, bmpPassword, macData.MacSalt, macData.Iterations, macHash().Size())
+	mac := hmac.New(macHash, macKey)
+	mac.Write(authSafe)
+	macData.Mac = pkcs12DigestInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: macOID, Parameters: asn1.NullRawValue},
+		Digest:    mac.Sum(nil),
+	}
+
+	authSafeData, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: pkcs7ContentInfo{ContentType: oidPKCS7Data, Content: explicitTag0(authSafeData)},
+		MacData:  macData,
+	})
+}
+
+// pkcs12EncodeBag wraps a DER encodable value in a safe bag
+func pkcs12EncodeBag(id asn1.ObjectIdentifier, value interface{}, attrs []pkcs12Attribute) (pkcs12SafeBag, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return pkcs12SafeBag{}, err
+	}
+	return pkcs12SafeBag{ID: id, Value: explicitTag0(der), Attributes: attrs}, nil
+}
+
+// explicitTag0 wraps DER in an explicit [0] tag. encoding/asn1 ignores the explicit
+// struct tag when marshalling a RawValue, so the wrapper is built by hand.
+func explicitTag0(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// pkcs12EncodeAttributes builds the friendlyName and localKeyID bag attributes
+func pkcs12EncodeAttributes(friendlyName string, localKeyID []byte) ([]pkcs12Attribute, error) {
+	var attrs []pkcs12Attribute
+	if friendlyName != "" {
+		// bmpString adds a NUL terminator, which is not part of a BMPString value
+		name := bmpString(friendlyName)
+		value, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: name[:len(name)-2]})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, pkcs12Attribute{ID: oidAttrFriendlyName, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value}})
+	}
+	value, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	attrs = append(attrs, pkcs12Attribute{ID: oidAttrLocalKeyID, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value}})
+	return attrs, nil
+}
+
+// pkcs12PBEAlgorithm creates the parameters of a PKCS#12 PBE scheme with a random salt
+func pkcs12PBEAlgorithm(oid asn1.ObjectIdentifier) (pkix.AlgorithmIdentifier, error) {
+	params := pkcs12PBEParams{Salt: make([]byte, 8), Iterations: pkcs12Iterations}
+	if _, err := rand.Read(params.Salt); err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	der, err := asn1.Marshal(params)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.RawValue{FullBytes: der}}, nil
+}
+
+// pbes2Algorithm creates PBES2 parameters for PBKDF2 with HMAC-SHA256 and AES-256-CBC, with a random salt and IV
+func pbes2Algorithm() (pkix.AlgorithmIdentifier, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	kdf, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: pkcs12Iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}, nil
+}
+
+// pkcs12BagAttributes extracts the friendlyName and localKeyID attributes of a bag
+func pkcs12BagAttributes(attrs []pkcs12Attribute) (string, []byte) {
+	var friendlyName string
+	var localKeyID []byte
+
+	for _, attr := range attrs {
+		// Attribute values are a SET holding a single value
+		var value asn1.RawValue
+		if _, err := asn1.Unmarshal(attr.Value.Bytes, &value); err != nil {
+			continue
+		}
+		switch {
+		case attr.ID.Equal(oidAttrFriendlyName):
+			if value.Tag == asn1.TagBMPString {
+				friendlyName = decodeBMPString(value.Bytes)
+			}
+		case attr.ID.Equal(oidAttrLocalKeyID):
+			localKeyID = value.Bytes
+		}
+	}
+
+	return friendlyName, localKeyID
+}
+
+// checkPKCS12Iterations checks an iteration count read from a PKCS#12 file before deriving a key with it
+func checkPKCS12Iterations(iterations int) error {
+	if iterations < 1 || iterations > maxPKCS12Iterations {
+		return fmt.Errorf("Unsupported PKCS#12 iteration count %d (at most %d)", iterations, maxPKCS12Iterations)
+	}
+	return nil
+}
+
+// verifyPKCS12MAC checks the integrity MAC of the authenticated safe
+func verifyPKCS12MAC(newHash func() hash.Hash, macData *pkcs12MacData, message, password []byte) bool {
+	key := pkcs12KDF(newHash, 3, password, macData.MacSalt, macData.Iterations, newHash().Size())
+	mac := hmac.New(newHash, key)
+	mac.Write(message)
+	return hmac.Equal(mac.Sum(nil), macData.Mac.Digest)
+}
+
+// pkcs12DigestHash maps a MAC digest algorithm OID to its hash function and name
+func pkcs12DigestHash(oid asn1.ObjectIdentifier) (func() hash.Hash, string, error) {
+	switch {
+	case oid.Equal(oidDigestSHA1):
+		return sha1.New, "SHA1", nil
+	case oid.Equal(oidDigestSHA256):
+		return sha256.New, "SHA256", nil
+	case oid.Equal(oidDigestSHA384):
+		return sha512.New384, "SHA384", nil
+	case oid.Equal(oidDigestSHA512):
+		return sha512.New, "SHA512", nil
+	}
+	return nil, "", fmt.Errorf("Unsupported PKCS#12 MAC algorithm: %s", oid)
+}
+
+// pbeAlgorithmName returns a readable name for a password based encryption algorithm
+func pbeAlgorithmName(alg pkix.AlgorithmIdentifier) string {
+	switch {
+	case alg.Algorithm.Equal(oidPBEWithSHA3DES):
+		return "pbeWithSHA1And3-KeyTripleDES-CBC"
+	case alg.Algorithm.Equal(oidPBEWithSHA128RC2):
+		return "pbeWithSHA1And128BitRC2-CBC"
+	case alg.Algorithm.Equal(oidPBEWithSHA40RC2):
+		return "pbeWithSHA1And40BitRC2-CBC"
+	case alg.Algorithm.Equal(oidPBES2):
+		var params pbes2Params
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err == nil {
+			switch {
+			case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+				return "PBES2 (PBKDF2, AES-128-CBC)"
+			case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+				return "PBES2 (PBKDF2, AES-192-CBC)"
+			case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+				return "PBES2 (PBKDF2, AES-256-CBC)"
+			}
+		}
+		return "PBES2"
+	}
+	return alg.Algorithm.String()
+}
+
+// pbeCipher derives the block cipher and IV for a PKCS#12 or PBES2 password based encryption scheme
+func pbeCipher(alg pkix.AlgorithmIdentifier, password string, bmpPassword []byte) (cipher.Block, []byte, error) {
+	var block cipher.Block
+	var iv []byte
+
+	switch {
+	case alg.Algorithm.Equal(oidPBEWithSHA3DES), alg.Algorithm.Equal(oidPBEWithSHA128RC2), alg.Algorithm.Equal(oidPBEWithSHA40RC2):
+		var params pkcs12PBEParams
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse PBE parameters: %v", err)
+		}
+		if err := checkPKCS12Iterations(params.Iterations); err != nil {
+			return nil, nil, err
+		}
+
+		var err error
+		switch {
+		case alg.Algorithm.Equal(oidPBEWithSHA3DES):
+			key := pkcs12KDF(sha1.New, 1, bmpPassword, params.Salt, params.Iterations, 24)
+			block, err = des.NewTripleDESCipher(key)
+		case alg.Algorithm.Equal(oidPBEWithSHA128RC2):
+			key := pkcs12KDF(sha1.New, 1, bmpPassword, params.Salt, params.Iterations, 16)
+			block, err = newRC2Cipher(key, 128)
+		default:
+			key := pkcs12KDF(sha1.New, 1, bmpPassword, params.Salt, params.Iterations, 5)
+			block, err = newRC2Cipher(key, 40)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		iv = pkcs12KDF(sha1.New, 2, bmpPassword, params.Salt, params.Iterations, block.BlockSize())
+
+	case alg.Algorithm.Equal(oidPBES2):
+		var params pbes2Params
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse PBES2 parameters: %v", err)
+		}
+		key, err := pbes2Key(params, password)
+		if err != nil {
+			return nil, nil, err
+		}
+		if block, err = aes.NewCipher(key); err != nil {
+			return nil, nil, err
+		}
+		if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse PBES2 IV: %v", err)
+		}
+
+	default:
+		return nil, nil, fmt.Errorf("Unsupported encryption algorithm: %s", alg.Algorithm)
+	}
+
+	if len(iv) != block.BlockSize() {
+		return nil, nil, fmt.Errorf("Invalid IV length")
+	}
+	return block, iv, nil
+}
+
+// pbeDecrypt decrypts data protected with a PKCS#12 or PBES2 password based encryption scheme
+func pbeDecrypt(alg pkix.AlgorithmIdentifier, data []byte, password string, bmpPassword []byte) ([]byte, error) {
+	block, iv, err := pbeCipher(alg, password, bmpPassword)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("Invalid encrypted data length")
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	// Remove and verify PKCS#7 padding; a bad pad almost always means a wrong password
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > block.BlockSize() || !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errPKCS12Password
+	}
+	return out[:len(out)-pad], nil
+}
+
+// pbeEncrypt pads and encrypts data with a PKCS#12 or PBES2 password based encryption scheme
+func pbeEncrypt(alg pkix.AlgorithmIdentifier, data []byte, password string, bmpPassword []byte) ([]byte, error) {
+	block, iv, err := pbeCipher(alg, password, bmpPassword)
+	if err != nil {
+		return nil, err
+	}
+	pad := block.BlockSize() - len(data)%block.BlockSize()
+	out := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+	return out, nil
+}
+
+// pbes2Key derives the AES key for a PBES2 scheme using PBKDF2
+func pbes2Key(params pbes2Params, password string) ([]byte, error) {
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("Unsupported PBES2 key derivation function: %s", params.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("Failed to parse PBKDF2 parameters: %v", err)
+	}
+	if err := checkPKCS12Iterations(kdf.Iterations); err != nil {
+		return nil, err
+	}
+
+	var keyLen int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("Unsupported PBES2 encryption scheme: %s", params.EncryptionScheme.Algorithm)
+	}
+
+	// The PRF defaults to HMAC-SHA1 when absent
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("Unsupported PBKDF2 PRF: %s", kdf.PRF.Algorithm)
+	}
+
+	return pbkdf2.Key(prf, password, kdf.Salt, kdf.Iterations, keyLen)
+}
+
+// pkcs12KDF derives keys, IVs, and MAC keys as described in RFC 7292 appendix B.2.
+// The id selects the purpose: 1 for keys, 2 for IVs, and 3 for MAC keys.
+func pkcs12KDF(newHash func() hash.Hash, id byte, password, salt []byte, iterations, size int) []byte {
+	h := newHash()
+	v := h.BlockSize()
+
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(pkcs12Fill(salt, v), pkcs12Fill(password, v)...)
+
+	var out []byte
+	for len(out) < size {
+		h.Reset()
+		h.Write(d)
+		h.Write(i)
+		a := h.Sum(nil)
+		for n := 1; n < iterations; n++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+
+		// Each v-byte block of I becomes (I_j + B + 1) mod 2^(8v)
+		b := pkcs12Fill(a, v)
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+
+	return out[:size]
+}
+
+// pkcs12Fill repeats data to the next multiple of v bytes
+func pkcs12Fill(data []byte, v int) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+	n := v * ((len(data) + v - 1) / v)
+	out := make([]byte, n)
+	for i := 0; i < n; i += len(data) {
+		copy(out[i:], data)
+	}
+	return out
+}
+
+// bmpString encodes a password as a NUL terminated UTF-16 big endian string
+func bmpString(s string) []byte {
+	var out []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(r>>8), byte(r))
+	}
+	return append(out, 0, 0)
+}
+
+// decodeBMPString decodes a UTF-16 big endian string
+func decodeBMPString(b []byte) string {
+	var units []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	for len(units) > 0 && units[len(units)-1] == 0 {
+		units = units[:len(units)-1]
+	}
+	return string(utf16.Decode(units))
+}
+
+// berToDER converts BER indefinite length encodings into definite length DER
+// so that encoding/asn1 can parse the result
+func berToDER(data []byte) ([]byte, error) {
+	out, rest, err := berElementToDER(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, rest...), nil
+}
+
+// berElementToDER converts a single BER element and returns the remaining input
+func berElementToDER(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, fmt.Errorf("truncated ASN.1 data")
+	}
+
+	// Identifier octets, including high tag number form
+	idLen := 1
+	if data[0]&0x1f == 0x1f {
+		for idLen < len(data) && data[idLen]&0x80 != 0 {
+			idLen++
+		}
+		idLen++
+	}
+	if idLen >= len(data) {
+		return nil, nil, fmt.Errorf("truncated ASN.1 data")
+	}
+	identifier := data[:idLen]
+	constructed := data[0]&0x20 != 0
+	rest := data[idLen:]
+
+	// Definite length elements are copied unless they are constructed and may contain BER
+	if rest[0] != 0x80 {
+		length, n, err := berLength(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) < n+length {
+			return nil, nil, fmt.Errorf("truncated ASN.1 data")
+		}
+		content := rest[n : n+length]
+		if constructed {
+			var converted []byte
+			for len(content) > 0 {
+				var child []byte
+				child, content, err = berElementToDER(content)
+				if err != nil {
+					return nil, nil, err
+				}
+				converted = append(converted, child...)
+			}
+			content = converted
+		}
+		return derElement(identifier, content), rest[n+length:], nil
+	}
+
+	if !constructed {
+		return nil, nil, fmt.Errorf("indefinite length on primitive ASN.1 element")
+	}
+
+	// Indefinite length: children until the end-of-contents marker
+	rest = rest[1:]
+	var content []byte
+	for {
+		if len(rest) < 2 {
+			return nil, nil, fmt.Errorf("missing end-of-contents marker")
+		}
+		if rest[0] == 0 && rest[1] == 0 {
+			rest = rest[2:]
+			break
+		}
+		child, remaining, err := berElementToDER(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		content = append(content, child...)
+		rest = remaining
+	}
+
+	return derElement(identifier, content), rest, nil
+}
+
+// berLength parses a definite length and returns it with the number of bytes consumed
+func berLength(data []byte) (int, int, error) {
+	if data[0]&0x80 == 0 {
+		return int(data[0]), 1, nil
+	}
+	n := int(data[0] & 0x7f)
+	if n == 0 || n > 4 || len(data) < 1+n {
+		return 0, 0, fmt.Errorf("invalid ASN.1 length")
+	}
+	length := 0
+	for _, b := range data[1 : 1+n] {
+		length = length<<8 | int(b)
+	}
+	return length, 1 + n, nil
+}
+
+// derElement assembles an element from its identifier octets and content
+func derElement(identifier, content []byte) []byte {
+	out := append([]byte{}, identifier...)
+	switch l := len(content); {
+	case l < 0x80:
+		out = append(out, byte(l))
+	case l < 0x100:
+		out = append(out, 0x81, byte(l))
+	case l < 0x10000:
+		out = append(out, 0x82, byte(l>>8), byte(l))
+	case l < 0x1000000:
+		out = append(out, 0x83, byte(l>>16), byte(l>>8), byte(l))
+	default:
+		out = append(out, 0x84, byte(l>>24), byte(l>>16), byte(l>>8), byte(l))
+	}
+	return append(out, content...)
+}
+
+// printPKCS12Info displays the certificates and key metadata inside a PKCS#12 file
+func printPKCS12Info(contents *pkcs12Contents, opts decodeOptions) {
+	fmt.Println("=== PKCS#12 Information ===")
+	fmt.Println()
+	if contents.MacAlgorithm != "" {
+		fmt.Printf("MAC Algorithm: %s\n", contents.MacAlgorithm)
+	} else {
+		fmt.Println("MAC Algorithm: none (no integrity protection)")
+	}
+	if contents.CertEncryption != "" {
+		fmt.Printf("Certificate Encryption: %s\n", contents.CertEncryption)
+	} else {
+		fmt.Println("Certificate Encryption: none")
+	}
+	fmt.Printf("Private Keys: %d\n", len(contents.Keys))
+	fmt.Printf("Certificates: %d\n", len(contents.Certs))
+
+	for i, key := range contents.Keys {
+		fmt.Printf("\n--- Private Key %d of %d ---\n\n", i+1, len(contents.Keys))
+		fmt.Printf("Key Type: %s\n", certforge.PrivateKeyDescription(key.Key))
+		fmt.Printf("Encryption: %s\n", key.Encryption)
+		if key.FriendlyName != "" {
+			fmt.Printf("Friendly Name: %s\n", key.FriendlyName)
+		}
+		if len(key.LocalKeyID) > 0 {
+			fmt.Printf("Local Key ID: %X\n", key.LocalKeyID)
+		}
+
+		// Identify the certificate belonging to this key
+		if signer, ok := key.Key.(crypto.Signer); ok {
+			for j, cert := range contents.Certs {
+				if pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool }); ok && pub.Equal(cert.Cert.PublicKey) {
+					fmt.Printf("Matches Certificate: %d (%s)\n", j+1, certforge.FormatName(cert.Cert.Subject))
+				}
+			}
+		}
+	}
+
+	var certs []*x509.Certificate
+	for i, cert := range contents.Certs {
+		fmt.Printf("\n--- Certificate %d of %d ---\n\n", i+1, len(contents.Certs))
+		if cert.FriendlyName != "" {
+			fmt.Printf("Friendly Name: %s\n", cert.FriendlyName)
+		}
+		if len(cert.LocalKeyID) > 0 {
+			fmt.Printf("Local Key ID: %X\n", cert.LocalKeyID)
+		}
+		printCertificate(certforge.NewCertificate(cert.Cert), opts)
+		certs = append(certs, cert.Cert)
+	}
+
+	if len(certs) > 1 {
+		fmt.Println()
+		printChainSummary(certs)
+	}
+}
@@ -0,0 +1,235 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// The files in testdata/pkcs12 were made with OpenSSL 3.0 from key.pem and cert.pem, with the password "password":
+//
+//	openssl pkcs12 -export -inkey key.pem -in cert.pem -name "test key" -passout pass:password -out modern.p12
+//	openssl pkcs12 -export -legacy -inkey key.pem -in cert.pem -name "test key" -passout pass:password -out legacy.p12
+//	openssl pkcs12 -export -inkey key.pem -in cert.pem -name "test key" -passout pass:password \
+//		-keypbe PBE-SHA1-3DES -certpbe PBE-SHA1-3DES -macalg sha1 -out 3des.p12
+
+// loadPKCS12TestKey returns the key and certificate the testdata/pkcs12 files hold
+func loadPKCS12TestKey(t *testing.T) (crypto.Signer, *x509.Certificate) {
+	t.Helper()
+	keyPEM, err := os.ReadFile("testdata/pkcs12/key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := certforge.ParsePrivateKey(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := os.ReadFile("testdata/pkcs12/cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := certforge.ParseCertificates(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, certs[0]
+}
+
+func TestDecodePKCS12OpenSSL(t *testing.T) {
+	key, cert := loadPKCS12TestKey(t)
+	tests := []struct {
+		file           string
+		mac            string
+		certEncryption string
+		keyEncryption  string
+	}{
+		{"modern.p12", "HMAC-SHA256", "PBES2 (PBKDF2, AES-256-CBC)", "PBES2 (PBKDF2, AES-256-CBC)"},
+		{"legacy.p12", "HMAC-SHA1", "pbeWithSHA1And40BitRC2-CBC", "pbeWithSHA1And3-KeyTripleDES-CBC"},
+		{"3des.p12", "HMAC-SHA1", "pbeWithSHA1And3-KeyTripleDES-CBC", "pbeWithSHA1And3-KeyTripleDES-CBC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata/pkcs12", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := decodePKCS12(data, "wrong"); !errors.Is(err, errPKCS12Password) {
+				t.Errorf("got error %v for a wrong password", err)
+			}
+			contents, err := decodePKCS12(data, "password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contents.MacAlgorithm != tt.mac || contents.CertEncryption != tt.certEncryption {
+				t.Errorf("got MAC %s and certificate encryption %s", contents.MacAlgorithm, contents.CertEncryption)
+			}
+			if len(contents.Keys) != 1 || len(contents.Certs) != 1 {
+				t.Fatalf("got %d keys and %d certificates, want 1 each", len(contents.Keys), len(contents.Certs))
+			}
+			got := contents.Keys[0]
+			if !certforge.SamePublicKey(got.Key.(crypto.Signer).Public(), key.Public()) {
+				t.Error("decoded a different key")
+			}
+			if got.Encryption != tt.keyEncryption || got.FriendlyName != "test key" {
+				t.Errorf("got key encryption %s and friendly name %q", got.Encryption, got.FriendlyName)
+			}
+			if !contents.Certs[0].Cert.Equal(cert) || contents.Certs[0].FriendlyName != "test key" {
+				t.Errorf("decoded certificate %s named %q", contents.Certs[0].Cert.Subject, contents.Certs[0].FriendlyName)
+			}
+		})
+	}
+}
+
+func TestEncodePKCS12RoundTrip(t *testing.T) {
+	key, cert := loadPKCS12TestKey(t)
+	for _, legacy := range []bool{false, true} {
+		t.Run(map[bool]string{false: "modern", true: "legacy"}[legacy], func(t *testing.T) {
+			data, err := encodePKCS12(key, cert, nil, "secret", "round trip", legacy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents, err := decodePKCS12(data, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(contents.Keys) != 1 || len(contents.Certs) != 1 {
+				t.Fatalf("got %d keys and %d certificates, want 1 each", len(contents.Keys), len(contents.Certs))
+			}
+			if !certforge.SamePublicKey(contents.Keys[0].Key.(crypto.Signer).Public(), key.Public()) {
+				t.Error("decoded a different key")
+			}
+			if !contents.Certs[0].Cert.Equal(cert) || contents.Certs[0].FriendlyName != "round trip" {
+				t.Errorf("decoded certificate %s named %q", contents.Certs[0].Cert.Subject, contents.Certs[0].FriendlyName)
+			}
+			if string(contents.Keys[0].LocalKeyID) != string(contents.Certs[0].LocalKeyID) {
+				t.Error("the key and certificate have different local key IDs")
+			}
+		})
+	}
+}
+
+func TestEncodePKCS12OpenSSL(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed")
+	}
+	key, cert := loadPKCS12TestKey(t)
+	for _, legacy := range []bool{false, true} {
+		t.Run(map[bool]string{false: "modern", true: "legacy"}[legacy], func(t *testing.T) {
+			data, err := encodePKCS12(key, cert, nil, "secret", "test key", legacy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "out.p12")
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			args := []string{"pkcs12", "-in", path, "-passin", "pass:secret", "-nodes"}
+			if legacy {
+				// OpenSSL 3 needs its legacy provider for RC2
+				args = append(args, "-legacy")
+			}
+			out, err := exec.Command(openssl, args...).CombinedOutput()
+			if err != nil {
+				if legacy && strings.Contains(string(out), "legacy") {
+					t.Skipf("openssl has no legacy provider: %s", out)
+				}
+				t.Fatalf("openssl pkcs12: %v\n%s", err, out)
+			}
+			gotKey, err := certforge.ParsePrivateKey(out)
+			if err != nil {
+				t.Fatalf("openssl printed no key: %v\n%s", err, out)
+			}
+			gotCerts, err := certforge.ParseCertificates(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !certforge.SamePublicKey(gotKey.Public(), key.Public()) || !gotCerts[0].Equal(cert) {
+				t.Error("openssl decoded a different key or certificate")
+			}
+		})
+	}
+}
+
+func TestDecodePKCS12IterationLimit(t *testing.T) {
+	key, cert := loadPKCS12TestKey(t)
+	data, err := encodePKCS12(key, cert, nil, "secret", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pfx pfxPDU
+	if _, err := asn1.Unmarshal(data, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	pfx.MacData.Iterations = 1 << 30
+	crafted, err := asn1.Marshal(pfx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := decodePKCS12(crafted, "secret")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "iteration count") {
+			t.Errorf("got error %v for 2^30 MAC iterations", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("decoding a file with 2^30 MAC iterations did not stop")
+	}
+}
+
+func TestPBEIterationLimit(t *testing.T) {
+	pbeParams, err := asn1.Marshal(pkcs12PBEParams{Salt: make([]byte, 8), Iterations: maxPKCS12Iterations + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdfParams, err := asn1.Marshal(pbkdf2Params{Salt: make([]byte, 8), Iterations: maxPKCS12Iterations + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, err := asn1.Marshal(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbes2, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: iv}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		alg  pkix.AlgorithmIdentifier
+	}{
+		{"PKCS#12 PBE", pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHA3DES, Parameters: asn1.RawValue{FullBytes: pbeParams}}},
+		{"PBES2", pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: pbes2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := pbeDecrypt(tt.alg, make([]byte, 16), "secret", bmpString("secret"))
+			if err == nil || !strings.Contains(err.Error(), "iteration count") {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
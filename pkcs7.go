@@ -0,0 +1,541 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// OIDs of the PKCS#7 content types, attributes, and algorithms used to sign and encrypt messages
+var (
+	oidPKCS7SignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidPKCS7EnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAttrContentType    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidRSAEncryption      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidDESCBC             = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 7}
+	oidDESEDE3CBC         = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// pkcs7ContentInfo is the outer ContentInfo wrapper of a PKCS#7 structure
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// pkcs7SignedData is the signedData content of a PKCS#7 structure. Only the
+// fields needed to extract certificates and CRLs are decoded.
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// pkcs7IssuerAndSerial identifies a certificate by its issuer and serial number
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// pkcs7SignerInfo is the signature of one signer over the content and its signed attributes
+type pkcs7SignerInfo struct {
+	Version            int
+	IssuerAndSerial    pkcs7IssuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// pkcs7Attribute is a signed attribute; Values holds the complete SET of values
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// pkcs7EnvelopedData is the envelopedData content of a PKCS#7 structure, encrypted to its recipients
+type pkcs7EnvelopedData struct {
+	Version              int
+	RecipientInfos       []pkcs7RecipientInfo `asn1:"set"`
+	EncryptedContentInfo pkcs7EncryptedContentInfo
+}
+
+// pkcs7RecipientInfo carries the content encryption key, encrypted to one recipient's RSA key
+type pkcs7RecipientInfo struct {
+	Version                int
+	IssuerAndSerial        pkcs7IssuerAndSerial
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// pkcs7EncryptedContentInfo is the encrypted content; BER encoders may split it into a constructed OCTET STRING
+type pkcs7EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// pkcs7Bundle holds the certificates and raw CRLs contained in a PKCS#7 file
+type pkcs7Bundle struct {
+	Certificates []*x509.Certificate
+	CRLs         [][]byte
+}
+
+// parsePKCS7 extracts the certificates and CRLs from a DER encoded PKCS#7 structure
+func parsePKCS7(der []byte) (*pkcs7Bundle, error) {
+	var info pkcs7ContentInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse PKCS#7 content info: %v", err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("Trailing data after PKCS#7 structure")
+	}
+	if !info.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, fmt.Errorf("Unsupported PKCS#7 content type: %s", info.ContentType)
+	}
+
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("Failed to parse PKCS#7 signed data: %v", err)
+	}
+
+	bundle := &pkcs7Bundle{}
+	if len(sd.Certificates.Bytes) > 0 {
+		certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse PKCS#7 certificates: %v", err)
+		}
+		bundle.Certificates = certs
+	}
+
+	// CRLs are kept as raw DER, one entry per CRL in the set
+	crls := sd.CRLs.Bytes
+	for len(crls) > 0 {
+		var crl asn1.RawValue
+		crls, err = asn1.Unmarshal(crls, &crl)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse PKCS#7 CRLs: %v", err)
+		}
+		bundle.CRLs = append(bundle.CRLs, crl.FullBytes)
+	}
+
+	return bundle, nil
+}
+
+// printPKCS7Info displays every certificate contained in a PKCS#7 bundle
+func printPKCS7Info(bundle *pkcs7Bundle, opts decodeOptions) {
+	fmt.Println("=== PKCS#7 Bundle Information ===")
+	fmt.Println()
+	fmt.Printf("Certificates: %d\n", len(bundle.Certificates))
+	fmt.Printf("CRLs: %d\n", len(bundle.CRLs))
+	fmt.Println()
+
+	for i, cert := range bundle.Certificates {
+		fmt.Printf("--- Certificate %d of %d ---\n\n", i+1, len(bundle.Certificates))
+		printCertificate(certforge.NewCertificate(cert), opts)
+		fmt.Println()
+	}
+
+	if len(bundle.Certificates) > 1 {
+		printChainSummary(bundle.Certificates)
+	}
+
+	for i, der := range bundle.CRLs {
+		fmt.Printf("\n--- CRL %d of %d ---\n\n", i+1, len(bundle.CRLs))
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			fmt.Printf("Error: Failed to parse CRL: %v\n", err)
+			continue
+		}
+		printCRLInfo(certforge.NewCRL(crl), opts)
+	}
+}
+
+// encodePKCS7 builds a DER encoded degenerate signedData structure holding only certificates,
+// the "certs-only" form produced by `openssl crl2pkcs7 -nocrl`
+func encodePKCS7(certs []*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+	empty := asn1.RawValue{Tag: asn1.TagSet, IsCompound: true}
+
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: empty,
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPKCS7Data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      empty,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{ContentType: oidPKCS7SignedData, Content: explicitTag0(signedData)})
+}
+
+// newPKCS7Attribute builds a signed attribute with a single value
+func newPKCS7Attribute(oid asn1.ObjectIdentifier, value interface{}) (pkcs7Attribute, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return pkcs7Attribute{}, err
+	}
+	return pkcs7Attribute{Type: oid, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der}}, nil
+}
+
+// pkcs7AttributeSet encodes attributes as a DER SET OF, sorted as DER requires, and returns its contents
+func pkcs7AttributeSet(attrs []pkcs7Attribute) ([]byte, error) {
+	var encoded [][]byte
+	for _, attr := range attrs {
+		der, err := asn1.Marshal(attr)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, der)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	return bytes.Join(encoded, nil), nil
+}
+
+// pkcs7Octets returns the contents of an OCTET STRING, joining the segments of a constructed (BER) one
+func pkcs7Octets(value asn1.RawValue) ([]byte, error) {
+	if !value.IsCompound {
+		return value.Bytes, nil
+	}
+	var out []byte
+	rest := value.Bytes
+	for len(rest) > 0 {
+		var segment asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &segment); err != nil {
+			return nil, err
+		}
+		data, err := pkcs7Octets(segment)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, data...)
+	}
+	return out, nil
+}
+
+// pkcs7Hash returns the hash function of a digest algorithm OID
+func pkcs7Hash(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidDigestSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidDigestSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidDigestSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidDigestSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("Unsupported digest algorithm: %s", oid)
+}
+
+// signPKCS7 builds a signedData structure holding content as data, signed with an RSA key together with
+// attrs; cert is included so the recipient can verify the signature
+func signPKCS7(content []byte, cert *x509.Certificate, key crypto.Signer, digestOID asn1.ObjectIdentifier, attrs []pkcs7Attribute) ([]byte, error) {
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("PKCS#7 messages can only be signed with RSA keys")
+	}
+	hash, err := pkcs7Hash(digestOID)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(content)
+	contentType, err := newPKCS7Attribute(oidAttrContentType, oidPKCS7Data)
+	if err != nil {
+		return nil, err
+	}
+	messageDigest, err := newPKCS7Attribute(oidAttrMessageDigest, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	signed, err := pkcs7AttributeSet(append([]pkcs7Attribute{contentType, messageDigest}, attrs...))
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature covers the attributes encoded as a SET, not with the [0] tag they are stored under
+	h = hash.New()
+	h.Write(derElement([]byte{0x31}, signed))
+	signature, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign message: %v", err)
+	}
+
+	digestAlgorithm := pkix.AlgorithmIdentifier{Algorithm: digestOID, Parameters: asn1.NullRawValue}
+	signerInfo, err := asn1.Marshal(pkcs7SignerInfo{
+		Version:            1,
+		IssuerAndSerial:    pkcs7IssuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber},
+		DigestAlgorithm:    digestAlgorithm,
+		SignedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+		Signature:          signature,
+	})
+	if err != nil {
+		return nil, err
+	}
+	digestAlgorithms, err := asn1.Marshal(digestAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	data, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: digestAlgorithms},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPKCS7Data, Content: explicitTag0(data)},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos:      asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: signerInfo},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{ContentType: oidPKCS7SignedData, Content: explicitTag0(signedData)})
+}
+
+// verifyPKCS7 checks the signature of a signedData structure made by one of signers, or by a certificate it
+// carries that one of signers issued, and returns the content and signed attributes
+func verifyPKCS7(der []byte, signers []*x509.Certificate) ([]byte, []pkcs7Attribute, error) {
+	der, err := berToDER(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse PKCS#7 signed data: %v", err)
+	}
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse PKCS#7 content info: %v", err)
+	}
+	if !info.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, nil, fmt.Errorf("Unsupported PKCS#7 content type: %s", info.ContentType)
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse PKCS#7 signed data: %v", err)
+	}
+	var content []byte
+	if len(sd.ContentInfo.Content.Bytes) > 0 {
+		var octets asn1.RawValue
+		if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &octets); err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse PKCS#7 content: %v", err)
+		}
+		if content, err = pkcs7Octets(octets); err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse PKCS#7 content: %v", err)
+		}
+	}
+	var si pkcs7SignerInfo
+	if _, err := asn1.Unmarshal(sd.SignerInfos.Bytes, &si); err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse PKCS#7 signer: %v", err)
+	}
+
+	// Find the signer's certificate
+	candidates := append([]*x509.Certificate{}, signers...)
+	if len(sd.Certificates.Bytes) > 0 {
+		included, err := x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse PKCS#7 certificates: %v", err)
+		}
+		for _, cert := range included {
+			for _, trusted := range signers {
+				if cert.CheckSignatureFrom(trusted) == nil {
+					candidates = append(candidates, cert)
+					break
+				}
+			}
+		}
+	}
+	var signer *x509.Certificate
+	for _, cert := range candidates {
+		if bytes.Equal(cert.RawIssuer, si.IssuerAndSerial.Issuer.FullBytes) && cert.SerialNumber.Cmp(si.IssuerAndSerial.SerialNumber) == 0 {
+			signer = cert
+			break
+		}
+	}
+	if signer == nil {
+		return nil, nil, fmt.Errorf("PKCS#7 message is not signed by a trusted certificate")
+	}
+	pub, ok := signer.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("Unsupported PKCS#7 signer key %T", signer.PublicKey)
+	}
+
+	hash, err := pkcs7Hash(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	var attrs []pkcs7Attribute
+	for rest := si.SignedAttributes.Bytes; len(rest) > 0; {
+		var attr pkcs7Attribute
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse PKCS#7 signed attributes: %v", err)
+		}
+		attrs = append(attrs, attr)
+	}
+	h := hash.New()
+	h.Write(content)
+	var digest []byte
+	if err := pkcs7AttributeValue(attrs, oidAttrMessageDigest, &digest); err != nil || !bytes.Equal(digest, h.Sum(nil)) {
+		return nil, nil, fmt.Errorf("PKCS#7 message digest does not match the content")
+	}
+	h = hash.New()
+	h.Write(derElement([]byte{0x31}, si.SignedAttributes.Bytes))
+	if err := rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), si.Signature); err != nil {
+		return nil, nil, fmt.Errorf("Invalid PKCS#7 signature by %s: %v", certforge.FormatName(signer.Subject), err)
+	}
+	return content, attrs, nil
+}
+
+// pkcs7AttributeValue decodes the first value of the attribute oid into value
+func pkcs7AttributeValue(attrs []pkcs7Attribute, oid asn1.ObjectIdentifier, value interface{}) error {
+	for _, attr := range attrs {
+		if attr.Type.Equal(oid) {
+			_, err := asn1.Unmarshal(attr.Values.Bytes, value)
+			return err
+		}
+	}
+	return fmt.Errorf("missing attribute %s", oid)
+}
+
+// envelopePKCS7 encrypts content to recipient's RSA key, with AES-128-CBC or, for older servers, DES-EDE3-CBC
+func envelopePKCS7(content []byte, recipient *x509.Certificate, useAES bool) ([]byte, error) {
+	pub, ok := recipient.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Cannot encrypt to a %T key of %s", recipient.PublicKey, certforge.FormatName(recipient.Subject))
+	}
+
+	algorithm, keySize, newCipher := oidDESEDE3CBC, 24, des.NewTripleDESCipher
+	if useAES {
+		algorithm, keySize, newCipher = oidAES128CBC, 16, aes.NewCipher
+	}
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	padding := block.BlockSize() - len(content)%block.BlockSize()
+	encrypted := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt content key: %v", err)
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	envelopedData, err := asn1.Marshal(pkcs7EnvelopedData{
+		Version: 0,
+		RecipientInfos: []pkcs7RecipientInfo{{
+			Version:                0,
+			IssuerAndSerial:        pkcs7IssuerAndSerial{Issuer: asn1.RawValue{FullBytes: recipient.RawIssuer}, SerialNumber: recipient.SerialNumber},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encryptedKey,
+		}},
+		EncryptedContentInfo: pkcs7EncryptedContentInfo{
+			ContentType:                oidPKCS7Data,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: algorithm, Parameters: asn1.RawValue{FullBytes: ivParam}},
+			EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: encrypted},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{ContentType: oidPKCS7EnvelopedData, Content: explicitTag0(envelopedData)})
+}
+
+// decryptPKCS7 decrypts an envelopedData structure addressed to cert with its RSA key
+func decryptPKCS7(der []byte, cert *x509.Certificate, key crypto.Decrypter) ([]byte, error) {
+	der, err := berToDER(der)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse PKCS#7 enveloped data: %v", err)
+	}
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("Failed to parse PKCS#7 content info: %v", err)
+	}
+	if !info.ContentType.Equal(oidPKCS7EnvelopedData) {
+		return nil, fmt.Errorf("Unsupported PKCS#7 content type: %s", info.ContentType)
+	}
+	var ed pkcs7EnvelopedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &ed); err != nil {
+		return nil, fmt.Errorf("Failed to parse PKCS#7 enveloped data: %v", err)
+	}
+
+	var recipient *pkcs7RecipientInfo
+	for i, ri := range ed.RecipientInfos {
+		if bytes.Equal(ri.IssuerAndSerial.Issuer.FullBytes, cert.RawIssuer) && ri.IssuerAndSerial.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			recipient = &ed.RecipientInfos[i]
+		}
+	}
+	if recipient == nil {
+		return nil, fmt.Errorf("PKCS#7 message is not encrypted to %s", certforge.FormatName(cert.Subject))
+	}
+	contentKey, err := key.Decrypt(rand.Reader, recipient.EncryptedKey, &rsa.PKCS1v15DecryptOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt content key: %v", err)
+	}
+
+	alg := ed.EncryptedContentInfo.ContentEncryptionAlgorithm
+	var block cipher.Block
+	switch {
+	case alg.Algorithm.Equal(oidAES128CBC), alg.Algorithm.Equal(oidAES192CBC), alg.Algorithm.Equal(oidAES256CBC):
+		block, err = aes.NewCipher(contentKey)
+	case alg.Algorithm.Equal(oidDESEDE3CBC):
+		block, err = des.NewTripleDESCipher(contentKey)
+	case alg.Algorithm.Equal(oidDESCBC):
+		block, err = des.NewCipher(contentKey)
+	default:
+		return nil, fmt.Errorf("Unsupported PKCS#7 content encryption: %s", alg.Algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt PKCS#7 content: %v", err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &iv); err != nil || len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("Invalid PKCS#7 content encryption IV")
+	}
+	data, err := pkcs7Octets(ed.EncryptedContentInfo.EncryptedContent)
+	if err != nil || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("Invalid PKCS#7 encrypted content")
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > block.BlockSize() || !bytes.Equal(data[len(data)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("Failed to decrypt PKCS#7 content: bad padding")
+	}
+	return data[:len(data)-padding], nil
+}
@@ -0,0 +1,329 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// The files in testdata/pkcs7 were made with OpenSSL 3.0; cert.pem and key.pem are issued by ca.pem:
+//
+//	openssl cms -sign -binary -nodetach -md sha256 -in content.txt -signer cert.pem -inkey key.pem \
+//		-outform DER -out signed.p7
+//	openssl cms -encrypt -binary -aes256 -in content.txt -outform DER -out aes256.p7 cert.pem
+//	openssl smime -encrypt -binary -des3 -in content.txt -outform DER -out des3.p7 cert.pem
+
+// loadPKCS7TestKey returns the RSA key and certificate in testdata/pkcs7 and the CA that issued them
+func loadPKCS7TestKey(t *testing.T) (*rsa.PrivateKey, *x509.Certificate, *x509.Certificate) {
+	t.Helper()
+	var certs []*x509.Certificate
+	for _, name := range []string{"cert.pem", "ca.pem"} {
+		data, err := os.ReadFile(filepath.Join("testdata/pkcs7", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := certforge.ParseCertificates(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, parsed[0])
+	}
+	data, err := os.ReadFile("testdata/pkcs7/key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := certforge.ParsePrivateKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.(*rsa.PrivateKey), certs[0], certs[1]
+}
+
+// selfSignedRSA returns a new RSA key and a self-signed certificate for it
+func selfSignedRSA(t *testing.T, cn string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+// reencryptPKCS7 decrypts the content of an envelopedData structure made by envelopePKCS7 with AES, passes
+// the plaintext with its padding through change, and encrypts it again with the same key and IV
+func reencryptPKCS7(t *testing.T, der []byte, key *rsa.PrivateKey, change func([]byte) []byte) []byte {
+	t.Helper()
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		t.Fatal(err)
+	}
+	var ed pkcs7EnvelopedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &ed); err != nil {
+		t.Fatal(err)
+	}
+	contentKey, err := rsa.DecryptPKCS1v15(nil, key, ed.RecipientInfos[0].EncryptedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Clone(ed.EncryptedContentInfo.EncryptedContent.Bytes)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+	data = change(data)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	ed.EncryptedContentInfo.EncryptedContent = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: data}
+	envelopedData, err := asn1.Marshal(ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := asn1.Marshal(pkcs7ContentInfo{ContentType: oidPKCS7EnvelopedData, Content: explicitTag0(envelopedData)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSignPKCS7RoundTrip(t *testing.T) {
+	key, cert, ca := loadPKCS7TestKey(t)
+	content := []byte("a round trip message")
+	transactionID, err := newPKCS7Attribute(oidSCEPTransactionID, "0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, digest := range []asn1.ObjectIdentifier{oidDigestSHA1, oidDigestSHA256, oidDigestSHA512} {
+		t.Run(digest.String(), func(t *testing.T) {
+			der, err := signPKCS7(content, cert, key, digest, []pkcs7Attribute{transactionID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Trusting the signer itself or the CA that issued it
+			for _, trusted := range []*x509.Certificate{cert, ca} {
+				got, attrs, err := verifyPKCS7(der, []*x509.Certificate{trusted})
+				if err != nil {
+					t.Fatalf("trusting %s: %v", trusted.Subject, err)
+				}
+				if !bytes.Equal(got, content) {
+					t.Errorf("got content %q", got)
+				}
+				var id string
+				if err := pkcs7AttributeValue(attrs, oidSCEPTransactionID, &id); err != nil || id != "0123456789" {
+					t.Errorf("got transaction ID %q, %v", id, err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyPKCS7Errors(t *testing.T) {
+	key, cert, ca := loadPKCS7TestKey(t)
+	content := []byte("a message that is tampered with")
+	der, err := signPKCS7(content, cert, key, oidDigestSHA256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, otherCert := selfSignedRSA(t, "pkcs7.example.com")
+	unchained, err := signPKCS7(content, otherCert, otherKey, oidDigestSHA256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badSignature := bytes.Clone(der)
+	badSignature[len(badSignature)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		der  []byte
+		want string
+	}{
+		{"tampered content", bytes.Replace(der, []byte("tampered"), []byte("TAMPERED"), 1), "digest does not match"},
+		{"tampered signature", badSignature, "Invalid PKCS#7 signature"},
+		{"signer not chained to the CA", unchained, "not signed by a trusted certificate"},
+		{"truncated", der[:len(der)/2], "Failed to parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := verifyPKCS7(tt.der, []*x509.Certificate{ca})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvelopePKCS7RoundTrip(t *testing.T) {
+	key, cert, _ := loadPKCS7TestKey(t)
+	// Contents of a whole block get a whole block of padding
+	for _, content := range [][]byte{[]byte("x"), []byte("sixteen bytes!!!"), bytes.Repeat([]byte("a longer message "), 20)} {
+		for _, useAES := range []bool{false, true} {
+			der, err := envelopePKCS7(content, cert, useAES)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decryptPKCS7(der, cert, key)
+			if err != nil {
+				t.Fatalf("%d bytes, AES %t: %v", len(content), useAES, err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("%d bytes, AES %t: decrypted %q", len(content), useAES, got)
+			}
+		}
+	}
+}
+
+func TestDecryptPKCS7Errors(t *testing.T) {
+	key, cert, ca := loadPKCS7TestKey(t)
+	der, err := envelopePKCS7([]byte("padded content"), cert, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherCert := selfSignedRSA(t, "other.example.com")
+
+	tests := []struct {
+		name string
+		der  []byte
+		cert *x509.Certificate
+		want string
+	}{
+		{"another recipient", der, otherCert, "not encrypted to"},
+		{"the CA as recipient", der, ca, "not encrypted to"},
+		{"zero padding", reencryptPKCS7(t, der, key, func(data []byte) []byte {
+			data[len(data)-1] = 0
+			return data
+		}), cert, "bad padding"},
+		{"padding longer than a block", reencryptPKCS7(t, der, key, func(data []byte) []byte {
+			data[len(data)-1] = 17
+			return data
+		}), cert, "bad padding"},
+		// Only the last byte is right, so a decrypter that checks only it returns the content
+		{"inconsistent padding", reencryptPKCS7(t, der, key, func(data []byte) []byte {
+			data[len(data)-2] ^= 0xff
+			return data
+		}), cert, "bad padding"},
+		{"unpadded block", reencryptPKCS7(t, der, key, func(data []byte) []byte {
+			return []byte("sixteen bytes!!!")
+		}), cert, "bad padding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decryptPKCS7(tt.der, tt.cert, key)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPKCS7OpenSSLFixtures(t *testing.T) {
+	key, cert, ca := loadPKCS7TestKey(t)
+	content, err := os.ReadFile("testdata/pkcs7/content.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := os.ReadFile("testdata/pkcs7/signed.p7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, attrs, err := verifyPKCS7(signed, []*x509.Certificate{ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contentType asn1.ObjectIdentifier
+	if !bytes.Equal(got, content) || pkcs7AttributeValue(attrs, oidAttrContentType, &contentType) != nil || !contentType.Equal(oidPKCS7Data) {
+		t.Errorf("got content %q of type %s", got, contentType)
+	}
+	if _, _, err := verifyPKCS7(bytes.Replace(signed, []byte("interop"), []byte("INTEROP"), 1), []*x509.Certificate{ca}); err == nil {
+		t.Error("verified tampered content")
+	}
+
+	for _, file := range []string{"aes256.p7", "des3.p7"} {
+		der, err := os.ReadFile(filepath.Join("testdata/pkcs7", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decryptPKCS7(der, cert, key)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%s: decrypted %q", file, got)
+		}
+	}
+}
+
+func TestPKCS7OpenSSL(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed")
+	}
+	key, cert, _ := loadPKCS7TestKey(t)
+	content := []byte("certforge to OpenSSL")
+	dir := t.TempDir()
+
+	signed, err := signPKCS7(content, cert, key, oidDigestSHA256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "signed.p7")
+	if err := os.WriteFile(path, signed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(openssl, "cms", "-verify", "-binary", "-inform", "DER", "-in", path,
+		"-CAfile", "testdata/pkcs7/ca.pem").Output()
+	if err != nil || !bytes.Equal(out, content) {
+		t.Errorf("openssl cms -verify printed %q: %v", out, err)
+	}
+
+	for _, useAES := range []bool{false, true} {
+		enveloped, err := envelopePKCS7(content, cert, useAES)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "enveloped.p7")
+		if err := os.WriteFile(path, enveloped, 0600); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(openssl, "cms", "-decrypt", "-binary", "-inform", "DER", "-in", path,
+			"-recip", "testdata/pkcs7/cert.pem", "-inkey", "testdata/pkcs7/key.pem").Output()
+		if err != nil || !bytes.Equal(out, content) {
+			t.Errorf("AES %t: openssl cms -decrypt printed %q: %v", useAES, out, err)
+		}
+	}
+}
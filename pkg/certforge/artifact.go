@@ -0,0 +1,293 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnrecognized is returned by ParseAny when data is neither PEM nor a DER certificate, CSR, CRL, or private key
+var ErrUnrecognized = errors.New("Unrecognized certificate, CSR, CRL, or key data")
+
+// Kind is the type of material an Artifact holds
+type Kind string
+
+const (
+	KindCertificate Kind = "certificate"
+	KindCSR         Kind = "csr"
+	KindKey         Kind = "key"
+	KindCRL         Kind = "crl"
+	KindBundle      Kind = "bundle"
+)
+
+// Artifact is parsed certificate material; the field named by Kind is set and the others are nil
+type Artifact struct {
+	Kind        Kind
+	Certificate *Certificate
+	CSR         *CSR
+	Key         *Key
+	CRL         *CRL
+	Bundle      *Bundle
+}
+
+// PublicKeyInfo describes a public key
+type PublicKeyInfo struct {
+	PublicKey crypto.PublicKey
+	// Algorithm is "RSA", "ECDSA", or "Ed25519", or the Go type of other keys
+	Algorithm string
+	Bits      int
+	// Exponent is the public exponent of RSA keys
+	Exponent int
+	// Curve is the curve name of ECDSA keys, like "P-256"
+	Curve string
+	// Fingerprint is the SHA-256 fingerprint of the DER SubjectPublicKeyInfo, in lowercase hex
+	Fingerprint string
+}
+
+// Certificate is an X.509 certificate with the details derived from it
+type Certificate struct {
+	*x509.Certificate
+	// Fingerprint and FingerprintSHA1 are the SHA-256 and SHA-1 fingerprints of the DER certificate, in lowercase hex
+	Fingerprint     string
+	FingerprintSHA1 string
+	PublicKeyInfo   PublicKeyInfo
+	SANs            SubjectAltNames
+	// SelfSigned reports whether the signature verifies with the certificate's own public key
+	SelfSigned bool
+	// DaysRemaining is the number of whole days until NotAfter when parsed, negative once it has expired
+	DaysRemaining int
+	Expired       bool
+	NotYetValid   bool
+}
+
+// CSR is a certificate signing request with the details derived from it
+type CSR struct {
+	*x509.CertificateRequest
+	PublicKeyInfo PublicKeyInfo
+	SANs          SubjectAltNames
+	// SignatureError is why the signature does not verify, or nil when it does
+	SignatureError error
+}
+
+// Key is an unencrypted private key with the details derived from it
+type Key struct {
+	PrivateKey    crypto.Signer
+	PublicKeyInfo PublicKeyInfo
+	// ValidationError is why an RSA key is inconsistent, or nil
+	ValidationError error
+}
+
+// CRL is a certificate revocation list with the details derived from it
+type CRL struct {
+	*x509.RevocationList
+	// Stale reports whether the next update was due before the CRL was parsed
+	Stale bool
+}
+
+// Bundle is PEM data with several blocks, such as a fullchain.pem or a certificate followed by its key
+type Bundle struct {
+	// Blocks are the PEM blocks in order. Artifacts and Errors have one entry per block: the parsed block, or nil
+	// and the reason it could not be parsed.
+	Blocks    []*pem.Block
+	Artifacts []*Artifact
+	Errors    []error
+	// Certificates are the certificates of the bundle, in order
+	Certificates []*Certificate
+}
+
+// ParseAny parses PEM or DER data holding a certificate, CSR, CRL, or unencrypted private key. PEM data with more
+// than one block is returned as a Bundle, even when some of its blocks cannot be parsed.
+func ParseAny(data []byte) (*Artifact, error) {
+	var blocks []*pem.Block
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+
+	switch len(blocks) {
+	case 0:
+		return parseDER(data)
+	case 1:
+		return ParseBlock(blocks[0])
+	}
+
+	bundle := &Bundle{Blocks: blocks}
+	for _, block := range blocks {
+		artifact, err := ParseBlock(block)
+		bundle.Artifacts = append(bundle.Artifacts, artifact)
+		bundle.Errors = append(bundle.Errors, err)
+		if err == nil && artifact.Kind == KindCertificate {
+			bundle.Certificates = append(bundle.Certificates, artifact.Certificate)
+		}
+	}
+	return &Artifact{Kind: KindBundle, Bundle: bundle}, nil
+}
+
+// ParseBlock parses a PEM block holding a certificate, CSR, CRL, or unencrypted private key. It returns
+// ErrEncryptedKey for an encrypted PKCS#8 key.
+func ParseBlock(block *pem.Block) (*Artifact, error) {
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse certificate: %v", err)
+		}
+		return &Artifact{Kind: KindCertificate, Certificate: NewCertificate(cert)}, nil
+
+	case "CERTIFICATE REQUEST":
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse CSR: %v", err)
+		}
+		return &Artifact{Kind: KindCSR, CSR: NewCSR(csr)}, nil
+
+	case "X509 CRL":
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse CRL: %v", err)
+		}
+		return &Artifact{Kind: KindCRL, CRL: NewCRL(crl)}, nil
+
+	case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY":
+		key, err := ParsePrivateKeyBlock(block)
+		if err != nil {
+			if block.Type == "RSA PRIVATE KEY" {
+				return nil, fmt.Errorf("Failed to parse RSA private key: %v", err)
+			}
+			return nil, fmt.Errorf("Failed to parse private key: %v", err)
+		}
+		signer, err := asSigner(key)
+		if err != nil {
+			return nil, err
+		}
+		return &Artifact{Kind: KindKey, Key: NewKey(signer)}, nil
+
+	case "ENCRYPTED PRIVATE KEY":
+		return nil, ErrEncryptedKey
+	}
+	return nil, fmt.Errorf("Unsupported PEM block type: %s", block.Type)
+}
+
+// parseDER tries each supported DER form in turn
+func parseDER(data []byte) (*Artifact, error) {
+	if cert, err := x509.ParseCertificate(data); err == nil {
+		return &Artifact{Kind: KindCertificate, Certificate: NewCertificate(cert)}, nil
+	}
+	if csr, err := x509.ParseCertificateRequest(data); err == nil {
+		return &Artifact{Kind: KindCSR, CSR: NewCSR(csr)}, nil
+	}
+	if crl, err := x509.ParseRevocationList(data); err == nil {
+		return &Artifact{Kind: KindCRL, CRL: NewCRL(crl)}, nil
+	}
+	if key, err := ParsePrivateKey(data); err == nil {
+		return &Artifact{Kind: KindKey, Key: NewKey(key)}, nil
+	}
+	return nil, ErrUnrecognized
+}
+
+// NewCertificate derives the details of a parsed certificate, with the days remaining counted from now
+func NewCertificate(cert *x509.Certificate) *Certificate {
+	now := time.Now()
+	sha1Sum := sha1.Sum(cert.Raw)
+	return &Certificate{
+		Certificate:     cert,
+		Fingerprint:     Fingerprint(cert.Raw),
+		FingerprintSHA1: hex.EncodeToString(sha1Sum[:]),
+		PublicKeyInfo:   NewPublicKeyInfo(cert.PublicKey),
+		SANs: SubjectAltNames{
+			DNSNames:       cert.DNSNames,
+			IPAddresses:    cert.IPAddresses,
+			EmailAddresses: cert.EmailAddresses,
+			URIs:           cert.URIs,
+			UPNs:           parseUPNs(cert.Extensions),
+		},
+		SelfSigned:    IsSelfSigned(cert),
+		DaysRemaining: DaysUntil(cert.NotAfter, now),
+		Expired:       now.After(cert.NotAfter),
+		NotYetValid:   now.Before(cert.NotBefore),
+	}
+}
+
+// NewCSR derives the details of a parsed CSR and checks its signature
+func NewCSR(csr *x509.CertificateRequest) *CSR {
+	return &CSR{
+		CertificateRequest: csr,
+		PublicKeyInfo:      NewPublicKeyInfo(csr.PublicKey),
+		SANs: SubjectAltNames{
+			DNSNames:       csr.DNSNames,
+			IPAddresses:    csr.IPAddresses,
+			EmailAddresses: csr.EmailAddresses,
+			URIs:           csr.URIs,
+			UPNs:           parseUPNs(csr.Extensions),
+		},
+		SignatureError: csr.CheckSignature(),
+	}
+}
+
+// NewKey derives the details of a private key, and validates RSA keys
+func NewKey(key crypto.Signer) *Key {
+	k := &Key{PrivateKey: key, PublicKeyInfo: NewPublicKeyInfo(key.Public())}
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		k.ValidationError = rsaKey.Validate()
+	}
+	return k
+}
+
+// NewCRL derives the details of a parsed CRL
+func NewCRL(crl *x509.RevocationList) *CRL {
+	return &CRL{
+		RevocationList: crl,
+		Stale:          !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate),
+	}
+}
+
+// NewPublicKeyInfo describes a public key
+func NewPublicKeyInfo(pub crypto.PublicKey) PublicKeyInfo {
+	info := PublicKeyInfo{PublicKey: pub}
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		info.Algorithm = "RSA"
+		info.Bits = key.N.BitLen()
+		info.Exponent = key.E
+	case *ecdsa.PublicKey:
+		info.Algorithm = "ECDSA"
+		info.Bits = key.Curve.Params().BitSize
+		info.Curve = key.Curve.Params().Name
+	case ed25519.PublicKey:
+		info.Algorithm = "Ed25519"
+		info.Bits = 256
+	default:
+		info.Algorithm = fmt.Sprintf("%T", pub)
+	}
+	if der, err := x509.MarshalPKIXPublicKey(pub); err == nil {
+		sum := sha256.Sum256(der)
+		info.Fingerprint = hex.EncodeToString(sum[:])
+	}
+	return info
+}
+
+// DaysUntil returns the number of whole days from now until t, rounded down, so it is negative once t has passed
+func DaysUntil(t, now time.Time) int {
+	d := t.Sub(now)
+	days := int(d / (24 * time.Hour))
+	if d < 0 && d%(24*time.Hour) != 0 {
+		days--
+	}
+	return days
+}
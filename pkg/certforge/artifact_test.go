@@ -0,0 +1,189 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestParseAny(t *testing.T) {
+	cert, key := testCertificate(t, "www.example.com")
+	caCert, caKey := testCA(t, 30*24*time.Hour)
+	csrDER, err := CreateCSR(key, pkix.Name{CommonName: "www.example.com"}, []string{"www.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+	}, caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want Kind
+	}{
+		{"PEM certificate", pemBlock("CERTIFICATE", cert.Raw), KindCertificate},
+		{"DER certificate", cert.Raw, KindCertificate},
+		{"PEM CSR", pemBlock("CERTIFICATE REQUEST", csrDER), KindCSR},
+		{"DER CSR", csrDER, KindCSR},
+		{"PEM key", pemBlock("PRIVATE KEY", keyDER), KindKey},
+		{"DER key", keyDER, KindKey},
+		{"PEM CRL", pemBlock("X509 CRL", crlDER), KindCRL},
+		{"DER CRL", crlDER, KindCRL},
+		{"bundle", EncodeCertificates(cert, caCert), KindBundle},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			artifact, err := ParseAny(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if artifact.Kind != tt.want {
+				t.Fatalf("got %s, want %s", artifact.Kind, tt.want)
+			}
+			set := 0
+			for _, field := range []bool{artifact.Certificate != nil, artifact.CSR != nil, artifact.Key != nil, artifact.CRL != nil, artifact.Bundle != nil} {
+				if field {
+					set++
+				}
+			}
+			if set != 1 {
+				t.Errorf("%d fields are set, want 1", set)
+			}
+		})
+	}
+}
+
+func TestParseAnyDetails(t *testing.T) {
+	cert, key := testCertificate(t, "www.example.com")
+	artifact, err := ParseAny(cert.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := artifact.Certificate
+	if !c.SelfSigned || c.Expired || c.NotYetValid || c.DaysRemaining != 0 {
+		t.Errorf("got self-signed %t, expired %t, not yet valid %t, %d days remaining", c.SelfSigned, c.Expired, c.NotYetValid, c.DaysRemaining)
+	}
+	if c.Fingerprint != Fingerprint(cert.Raw) || len(c.FingerprintSHA1) != 40 {
+		t.Errorf("got fingerprints %s and %s", c.Fingerprint, c.FingerprintSHA1)
+	}
+	if c.PublicKeyInfo.Algorithm != "ECDSA" || c.PublicKeyInfo.Curve != "P-256" || c.PublicKeyInfo.Bits != 256 {
+		t.Errorf("got public key %+v", c.PublicKeyInfo)
+	}
+	if len(c.SANs.DNSNames) != 1 || c.SANs.DNSNames[0] != "www.example.com" {
+		t.Errorf("got SANs %+v", c.SANs)
+	}
+
+	csrDER, err := CreateCSR(key, pkix.Name{CommonName: "www.example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrDER[len(csrDER)-1] ^= 0xff
+	artifact, err = ParseAny(csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if artifact.CSR.SignatureError == nil {
+		t.Error("a CSR with a broken signature has no SignatureError")
+	}
+}
+
+func TestParseAnyBundle(t *testing.T) {
+	cert, key := testCertificate(t, "www.example.com")
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	data = append(data, pemBlock("CERTIFICATE", cert.Raw)...)
+	data = append(data, pemBlock("CERTIFICATE", []byte("broken"))...)
+	data = append(data, pemBlock("PRIVATE KEY", keyDER)...)
+	data = append(data, pemBlock("ENCRYPTED PRIVATE KEY", []byte{0x30, 0x00})...)
+
+	artifact, err := ParseAny(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := artifact.Bundle
+	if len(bundle.Blocks) != 4 || len(bundle.Artifacts) != 4 || len(bundle.Errors) != 4 {
+		t.Fatalf("got %d blocks, %d artifacts, and %d errors, want 4 each", len(bundle.Blocks), len(bundle.Artifacts), len(bundle.Errors))
+	}
+	if bundle.Errors[0] != nil || bundle.Artifacts[0].Kind != KindCertificate {
+		t.Errorf("block 1: got %v", bundle.Errors[0])
+	}
+	if bundle.Errors[1] == nil || bundle.Artifacts[1] != nil {
+		t.Error("block 2: parsed a broken certificate")
+	}
+	if bundle.Errors[2] != nil || bundle.Artifacts[2].Kind != KindKey {
+		t.Errorf("block 3: got %v", bundle.Errors[2])
+	}
+	if !errors.Is(bundle.Errors[3], ErrEncryptedKey) {
+		t.Errorf("block 4: got %v, want ErrEncryptedKey", bundle.Errors[3])
+	}
+	if len(bundle.Certificates) != 1 || !bundle.Certificates[0].Equal(cert) {
+		t.Errorf("got %d certificates, want 1", len(bundle.Certificates))
+	}
+}
+
+func TestParseAnyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		isErr error
+	}{
+		{"garbage", []byte("not a certificate"), ErrUnrecognized},
+		{"encrypted key", pemBlock("ENCRYPTED PRIVATE KEY", []byte{0x30, 0x00}), ErrEncryptedKey},
+		{"unsupported block", pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: []byte{1}}), nil},
+		{"broken certificate", pemBlock("CERTIFICATE", []byte("broken")), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			artifact, err := ParseAny(tt.data)
+			if err == nil {
+				t.Fatalf("parsed a %s", artifact.Kind)
+			}
+			if tt.isErr != nil && !errors.Is(err, tt.isErr) {
+				t.Errorf("got error %v, want %v", err, tt.isErr)
+			}
+		})
+	}
+}
+
+func TestDaysUntil(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		t    time.Time
+		want int
+	}{
+		{now, 0},
+		{now.Add(23 * time.Hour), 0},
+		{now.Add(24 * time.Hour), 1},
+		{now.Add(47 * time.Hour), 1},
+		{now.Add(-time.Hour), -1},
+		{now.Add(-24 * time.Hour), -1},
+		{now.Add(-25 * time.Hour), -2},
+	}
+	for _, tt := range tests {
+		if got := DaysUntil(tt.t, now); got != tt.want {
+			t.Errorf("DaysUntil(%s) = %d, want %d", tt.t.Sub(now), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SubjectAltNames are the subject alternative names of a certificate or CSR, sorted by type
+type SubjectAltNames struct {
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+	// UPNs are Microsoft user principal names, in otherName SANs, for Windows smart card logon and 802.1X
+	UPNs []string
+}
+
+// SplitNames sorts names into SANs like ParseNames, but never fails: a name it cannot parse is kept lowercased as a
+// DNS name, for CheckDNSName to refuse when it is used.
+func SplitNames(names []string) SubjectAltNames {
+	var sans SubjectAltNames
+	for _, name := range names {
+		if err := sans.add(name); err != nil {
+			sans.DNSNames = append(sans.DNSNames, strings.ToLower(name))
+		}
+	}
+	return sans
+}
+
+// ParseNames sorts names into SANs. A name may be prefixed with its type: dns:, ip:, email:, uri:, or upn:, as in
+// uri:spiffe://example.org/web or uri:urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6. Otherwise IP addresses (IPv4, or
+// IPv6 with or without brackets) are IP addresses, names containing :// are URIs, names containing @ are emails, and
+// anything else is a DNS name, converted with ToASCII.
+func ParseNames(names []string) (SubjectAltNames, error) {
+	var sans SubjectAltNames
+	for _, name := range names {
+		if err := sans.add(name); err != nil {
+			return SubjectAltNames{}, err
+		}
+	}
+	return sans, nil
+}
+
+// CertificateNames returns the subject alternative names of cert as ParseNames takes them, with URIs and UPNs
+// prefixed with their type
+func CertificateNames(cert *x509.Certificate) []string {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, "uri:"+uri.String())
+	}
+	for _, upn := range parseUPNs(cert.Extensions) {
+		names = append(names, "upn:"+upn)
+	}
+	return names
+}
+
+// add adds a name as ParseNames sorts it
+func (sans *SubjectAltNames) add(name string) error {
+	kind, value, typed := "", name, false
+	if prefix, rest, ok := strings.Cut(name, ":"); ok {
+		switch strings.ToLower(prefix) {
+		case "dns", "ip", "email", "uri", "upn":
+			kind, value, typed = strings.ToLower(prefix), rest, true
+		}
+	}
+	if !typed {
+		switch {
+		case parseIPName(name) != nil:
+			kind = "ip"
+		case strings.Contains(name, "://"):
+			kind = "uri"
+		case strings.Contains(name, "@"):
+			kind = "email"
+		default:
+			kind = "dns"
+		}
+	}
+
+	switch kind {
+	case "ip":
+		ip := parseIPName(value)
+		if ip == nil {
+			return fmt.Errorf("Invalid IP address %q", value)
+		}
+		sans.IPAddresses = append(sans.IPAddresses, ip)
+	case "uri":
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" || u.Opaque == "" && u.Host == "" && u.Path == "" {
+			return fmt.Errorf("Invalid URI %q: a scheme and a value are required, as in spiffe://example.org/web", value)
+		}
+		sans.URIs = append(sans.URIs, u)
+	case "email":
+		if local, domain, ok := strings.Cut(value, "@"); !ok || local == "" || domain == "" {
+			return fmt.Errorf("Invalid email address %q", value)
+		}
+		sans.EmailAddresses = append(sans.EmailAddresses, value)
+	case "upn":
+		if local, domain, ok := strings.Cut(value, "@"); !ok || local == "" || domain == "" {
+			return fmt.Errorf("Invalid user principal name %q (use user@domain)", value)
+		}
+		sans.UPNs = append(sans.UPNs, value)
+	default:
+		ascii, err := ToASCII(value)
+		if err != nil {
+			return err
+		}
+		sans.DNSNames = append(sans.DNSNames, ascii)
+	}
+	return nil
+}
+
+// parseIPName parses an IP address literal, accepting IPv6 addresses in brackets as they appear in URLs, and returns
+// IPv4 addresses in their 4-byte form, as iPAddress names encode them. It returns nil for anything else.
+func parseIPName(name string) net.IP {
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		if ip := net.ParseIP(name[1 : len(name)-1]); ip != nil && ip.To4() == nil {
+			return ip
+		}
+		return nil
+	}
+	ip := net.ParseIP(name)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// NewSerial returns a random 128-bit serial number
+func NewSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate serial number: %v", err)
+	}
+	return serial, nil
+}
+
+// ParseSerial parses a serial number given in hex, optionally with a 0x prefix or colons between the bytes, such as
+// 1a2b or 1A:2B, and checks it with CheckSerial
+func ParseSerial(s string) (*big.Int, error) {
+	digits := strings.ReplaceAll(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "0x"), ":", "")
+	serial, ok := new(big.Int).SetString(digits, 16)
+	if !ok || digits == "" || digits[0] == '-' || digits[0] == '+' {
+		return nil, fmt.Errorf("Invalid serial number %q: use hex digits, such as 1a2b or 1A:2B", s)
+	}
+	if err := CheckSerial(serial); err != nil {
+		return nil, err
+	}
+	return serial, nil
+}
+
+// CheckSerial checks a serial number is positive and at most 20 octets long, sign bit included, as RFC 5280 requires
+func CheckSerial(serial *big.Int) error {
+	if serial.Sign() <= 0 {
+		return fmt.Errorf("Invalid serial number %s: it must be positive", serial.Text(16))
+	}
+	if serial.BitLen() > 159 {
+		return fmt.Errorf("Invalid serial number %s: it is longer than 20 octets", serial.Text(16))
+	}
+	return nil
+}
+
+// CreateCSR creates a DER encoded certificate signing request for subject and names, signed by key
+func CreateCSR(key crypto.Signer, subject pkix.Name, names []string) ([]byte, error) {
+	return createCSR(key, subject, SplitNames(names), nil)
+}
+
+// createCSR creates a DER encoded certificate signing request for subject, sans, and custom extensions, signed by key
+func createCSR(key crypto.Signer, subject pkix.Name, sans SubjectAltNames, custom []pkix.Extension) ([]byte, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, err
+	}
+	if err := checkDNSNames(sans.DNSNames); err != nil {
+		return nil, err
+	}
+	extensions, err := extraSANExtensions(subject, sans)
+	if err != nil {
+		return nil, err
+	}
+	if extensions, err = mergeExtensions(extensions, custom); err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:         subject,
+		DNSNames:        sans.DNSNames,
+		IPAddresses:     sans.IPAddresses,
+		EmailAddresses:  sans.EmailAddresses,
+		URIs:            sans.URIs,
+		ExtraExtensions: extensions,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating CSR: %v", err)
+	}
+	return der, nil
+}
+
+// RenewalCSR creates a DER encoded CSR for renewing cert, signed by key, that reproduces the subject and the subject
+// alternative name extension of cert byte for byte, as CAs expect of renewal requests
+func RenewalCSR(cert *x509.Certificate, key crypto.Signer) ([]byte, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, err
+	}
+	template := &x509.CertificateRequest{RawSubject: cert.RawSubject}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidExtSubjectAltName) {
+			template.ExtraExtensions = append(template.ExtraExtensions, ext)
+		}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating CSR: %v", err)
+	}
+	return der, nil
+}
+
+// SelfSign creates a DER encoded self-signed server certificate for subject and names, valid from now for validity
+func SelfSign(key crypto.Signer, subject pkix.Name, names []string, validity time.Duration) ([]byte, error) {
+	return selfSign(key, subject, SplitNames(names), nil, false, nil, validity)
+}
+
+// selfSign creates a DER encoded self-signed server certificate, or CA certificate when isCA is set, for subject,
+// sans, and custom extensions, valid from now for validity, with serial or else a random serial number
+func selfSign(key crypto.Signer, subject pkix.Name, sans SubjectAltNames, custom []pkix.Extension, isCA bool, serial *big.Int, validity time.Duration) ([]byte, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, err
+	}
+	if err := CheckValidity(validity); err != nil {
+		return nil, err
+	}
+	template, err := serverTemplate(subject, sans, custom, key.Public(), validity)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckSignatureAlgorithm(template.SignatureAlgorithm); err != nil {
+		return nil, err
+	}
+	if serial != nil {
+		template.SerialNumber = serial
+	}
+	if isCA {
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = nil
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create certificate: %v", err)
+	}
+	return der, nil
+}
+
+// IssueServerCertificate signs a server certificate for names and pub with a CA, whose certificate comes first in
+// caCerts followed by its chain, and returns the certificate and the chain without the root. The common name is the
+// first name, and the certificate may not outlive the CA.
+func IssueServerCertificate(caCerts []*x509.Certificate, caKey crypto.Signer, pub crypto.PublicKey, names []string, validity time.Duration) ([][]byte, error) {
+	if len(caCerts) == 0 || len(names) == 0 {
+		return nil, fmt.Errorf("A CA certificate and at least one name are required")
+	}
+	return (&CA{Certificates: caCerts, Key: caKey}).IssueServer(pub, names, validity)
+}
+
+// IssueServer signs a TLS server certificate for pub like IssueServerCertificate, reusing the CA's parsed chain, with
+// extensions such as MustStapleExtension added
+func (ca *CA) IssueServer(pub crypto.PublicKey, names []string, validity time.Duration, extensions ...pkix.Extension) ([][]byte, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("At least one name is required")
+	}
+	sans := SplitNames(names)
+	cn := names[0]
+	if ascii, err := ToASCII(cn); err == nil && slices.Contains(sans.DNSNames, ascii) {
+		cn = ascii
+	}
+	template, err := serverTemplate(pkix.Name{CommonName: cn}, sans, extensions, pub, validity)
+	if err != nil {
+		return nil, err
+	}
+	return ca.issue(template, pub)
+}
+
+// serverTemplate returns the template of a TLS server certificate with custom extensions, backdated a minute for
+// clock skew
+func serverTemplate(subject pkix.Name, sans SubjectAltNames, custom []pkix.Extension, pub crypto.PublicKey, validity time.Duration) (*x509.Certificate, error) {
+	if err := checkDNSNames(sans.DNSNames); err != nil {
+		return nil, err
+	}
+	extensions, err := extraSANExtensions(subject, sans)
+	if err != nil {
+		return nil, err
+	}
+	if extensions, err = mergeExtensions(extensions, custom); err != nil {
+		return nil, err
+	}
+	serial, err := NewSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		DNSNames:              sans.DNSNames,
+		IPAddresses:           sans.IPAddresses,
+		EmailAddresses:        sans.EmailAddresses,
+		URIs:                  sans.URIs,
+		ExtraExtensions:       extensions,
+		NotBefore:             time.Now().Add(-time.Minute),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	template.NotAfter = template.NotBefore.Add(validity)
+	// RSA keys may also encrypt the TLS premaster secret
+	if _, isRSA := pub.(*rsa.PublicKey); isRSA {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	return template, nil
+}
@@ -0,0 +1,207 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// CA signs certificates with a CA certificate and its private key. A CA from NewCA or LoadCA keeps the chain it
+// returns with each certificate, so one CA may sign any number of certificates, also concurrently, without
+// examining its certificates again.
+type CA struct {
+	// Certificates holds the CA certificate first, followed by the chain up to its root
+	Certificates []*x509.Certificate
+	Key          crypto.Signer
+	// AllowInsecure signs keys smaller than MinSignedRSABits and MinSignedECDSABits, for legacy devices that cannot
+	// make larger ones. FIPS mode and the policy still apply.
+	AllowInsecure bool
+	// CT, when set, has the CA log each certificate that is not a CA certificate before issuing it, embedding the
+	// SCTs of the logs
+	CT *CTSubmission
+	// NextSerial, when set, returns the serial number of each certificate instead of a random 128-bit one, such as
+	// the next number of a counter for firmware that requires predictable serials
+	NextSerial func() (*big.Int, error)
+
+	// chain is the DER of Certificates without the root
+	chain [][]byte
+}
+
+// Profile describes the certificates a CA signs for CSRs
+type Profile struct {
+	Validity time.Duration
+	// KeyUsage defaults to digital signature, with key encipherment for RSA keys, or certificate and CRL signing
+	// for CAs
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	IsCA        bool
+	// MaxPathLen limits the CAs below a CA certificate: zero allows none, and -1 sets no limit
+	MaxPathLen int
+}
+
+// ServerProfile is the profile of TLS server certificates
+func ServerProfile(validity time.Duration) Profile {
+	return Profile{Validity: validity, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+}
+
+// ClientProfile is the profile of TLS client certificates
+func ClientProfile(validity time.Duration) Profile {
+	return Profile{Validity: validity, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+}
+
+// IntermediateProfile is the profile of intermediate CAs that may not sign other CAs
+func IntermediateProfile(validity time.Duration) Profile {
+	return Profile{Validity: validity, IsCA: true}
+}
+
+// LoadCA parses a PEM CA certificate, optionally followed by its chain, and its unencrypted private key
+func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
+	certs, err := ParseCertificates(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return NewCA(certs, key)
+}
+
+// NewCA returns a CA for certs, the CA certificate followed by its chain, and the CA's key, after checking that the
+// certificate may sign certificates and belongs to the key
+func NewCA(certs []*x509.Certificate, key crypto.Signer) (*CA, error) {
+	if len(certs) == 0 {
+		return nil, ErrNoCertificates
+	}
+	if !certs[0].IsCA || certs[0].KeyUsage != 0 && certs[0].KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("%s is not a CA certificate that may sign certificates", FormatName(certs[0].Subject))
+	}
+	if !SamePublicKey(certs[0].PublicKey, key.Public()) {
+		return nil, fmt.Errorf("The private key does not belong to the CA certificate")
+	}
+	if err := CheckKey(key); err != nil {
+		return nil, err
+	}
+	return &CA{Certificates: certs, Key: key, chain: chainWithoutRoot(certs)}, nil
+}
+
+// chainWithoutRoot returns the DER of the certificates that are not self-signed
+func chainWithoutRoot(certs []*x509.Certificate) [][]byte {
+	chain := [][]byte{}
+	for _, cert := range certs {
+		if !IsSelfSigned(cert) {
+			chain = append(chain, cert.Raw)
+		}
+	}
+	return chain
+}
+
+// Sign signs a certificate for the subject, names, and public key of csr, after checking its signature, and returns
+// the certificate and the chain without the root. The certificate may not outlive the CA, and keeps OCSP
+// Must-Staple when the CSR asks for it.
+func (ca *CA) Sign(csr *x509.CertificateRequest, profile Profile) ([][]byte, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("Invalid CSR signature: %v", err)
+	}
+	if profile.Validity <= 0 {
+		return nil, fmt.Errorf("Invalid validity %s", profile.Validity)
+	}
+	serial, err := NewSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               csr.Subject,
+		DNSNames:              csr.DNSNames,
+		IPAddresses:           csr.IPAddresses,
+		EmailAddresses:        csr.EmailAddresses,
+		URIs:                  csr.URIs,
+		NotBefore:             time.Now().Add(-time.Minute),
+		KeyUsage:              profile.KeyUsage,
+		ExtKeyUsage:           profile.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  profile.IsCA,
+	}
+	template.NotAfter = template.NotBefore.Add(profile.Validity)
+	// crypto/x509 drops the otherName SANs of the CSR, so they are kept by copying its extension
+	if upns := parseUPNs(csr.Extensions); len(upns) > 0 {
+		sans := SubjectAltNames{DNSNames: csr.DNSNames, IPAddresses: csr.IPAddresses, EmailAddresses: csr.EmailAddresses, URIs: csr.URIs, UPNs: upns}
+		if template.ExtraExtensions, err = extraSANExtensions(csr.Subject, sans); err != nil {
+			return nil, err
+		}
+	}
+	// A CSR asking for OCSP Must-Staple gets it, as ACME CAs do
+	template.ExtraExtensions = append(template.ExtraExtensions, mustStapleExtensions(csr.Extensions)...)
+	if profile.IsCA {
+		template.MaxPathLen = profile.MaxPathLen
+		template.MaxPathLenZero = profile.MaxPathLen == 0
+		if template.KeyUsage == 0 {
+			template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		}
+	} else if template.KeyUsage == 0 {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		if _, isRSA := csr.PublicKey.(*rsa.PublicKey); isRSA {
+			template.KeyUsage |= x509.KeyUsageKeyEncipherment
+		}
+	}
+	return ca.issue(template, csr.PublicKey)
+}
+
+// issue signs template for pub and returns the certificate and the CA chain without the root
+func (ca *CA) issue(template *x509.Certificate, pub crypto.PublicKey) ([][]byte, error) {
+	caCert := ca.Certificates[0]
+	if template.NotAfter.After(caCert.NotAfter) {
+		return nil, fmt.Errorf("Certificate would outlive its CA, which expires %s", caCert.NotAfter.Format("2006-01-02"))
+	}
+	// Both keys are checked, since a CA may be built without NewCA
+	if err := CheckKey(ca.Key); err != nil {
+		return nil, err
+	}
+	checkPub := CheckSignedKey
+	if ca.AllowInsecure {
+		checkPub = CheckKey
+	}
+	if err := checkPub(pub); err != nil {
+		return nil, err
+	}
+	if err := CheckValidity(template.NotAfter.Sub(template.NotBefore)); err != nil {
+		return nil, err
+	}
+	if err := CheckSignatureAlgorithm(template.SignatureAlgorithm); err != nil {
+		return nil, err
+	}
+	if ca.NextSerial != nil {
+		serial, err := ca.NextSerial()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to assign a serial number: %v", err)
+		}
+		if err := CheckSerial(serial); err != nil {
+			return nil, err
+		}
+		template.SerialNumber = serial
+	}
+	if ca.CT != nil && len(ca.CT.Logs) > 0 && !template.IsCA {
+		if err := ca.embedSCTs(template, pub); err != nil {
+			return nil, err
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, pub, ca.Key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create certificate: %v", err)
+	}
+
+	chain := ca.chain
+	if chain == nil {
+		chain = chainWithoutRoot(ca.Certificates)
+	}
+	return append([][]byte{der}, chain...), nil
+}
@@ -0,0 +1,157 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA returns a new self-signed CA certificate valid for validity, and its key
+func testCA(t *testing.T, validity time.Duration) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	r, err := NewRequest(WithCN("Test CA"), WithKeyType(ECDSA, 256), WithBasicConstraints(true, true), WithValidity(validity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifacts, err := r.SelfSign()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(artifacts.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, artifacts.Key
+}
+
+// testCSR returns a new CSR for the common name cn and the SANs names
+func testCSR(t *testing.T, cn string, names ...string) *x509.CertificateRequest {
+	t.Helper()
+	der, err := CreateCSR(testKey(t), pkix.Name{CommonName: cn}, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestNewCA(t *testing.T) {
+	caCert, caKey := testCA(t, 30*24*time.Hour)
+	leaf, leafKey := testCertificate(t, "www.example.com")
+
+	tests := []struct {
+		name    string
+		certs   []*x509.Certificate
+		key     crypto.Signer
+		wantErr string
+	}{
+		{"CA", []*x509.Certificate{caCert}, caKey, ""},
+		{"no certificate", nil, caKey, ErrNoCertificates.Error()},
+		{"not a CA", []*x509.Certificate{leaf}, leafKey, "is not a CA certificate"},
+		{"mismatched key", []*x509.Certificate{caCert}, testKey(t), "does not belong"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca, err := NewCA(tt.certs, tt.key)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(ca.Certificates) != 1 {
+					t.Errorf("got %d certificates", len(ca.Certificates))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadCA(t *testing.T) {
+	caCert, caKey := testCA(t, 30*24*time.Hour)
+	keyPEM, err := MarshalPrivateKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := LoadCA(EncodeCertificates(caCert), keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.Certificates[0].Equal(caCert) || !SamePublicKey(ca.Key.Public(), caKey.Public()) {
+		t.Error("LoadCA returned a different CA")
+	}
+	if _, err := LoadCA(keyPEM, keyPEM); !errors.Is(err, ErrNoCertificates) {
+		t.Errorf("got error %v for a key without a certificate", err)
+	}
+}
+
+func TestCASign(t *testing.T) {
+	caCert, caKey := testCA(t, 30*24*time.Hour)
+	ca, err := NewCA([]*x509.Certificate{caCert}, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := testCSR(t, "www.example.com", "www.example.com")
+	tampered.Signature[len(tampered.Signature)-1] ^= 0xff
+
+	tests := []struct {
+		name    string
+		csr     *x509.CertificateRequest
+		profile Profile
+		wantErr string
+	}{
+		{"server", testCSR(t, "www.example.com", "www.example.com"), ServerProfile(24 * time.Hour), ""},
+		{"client", testCSR(t, "client.example.com", "client.example.com"), ClientProfile(24 * time.Hour), ""},
+		{"intermediate", testCSR(t, "Intermediate CA"), IntermediateProfile(24 * time.Hour), ""},
+		{"outlives the CA", testCSR(t, "www.example.com", "www.example.com"), ServerProfile(60 * 24 * time.Hour), "would outlive its CA"},
+		{"zero validity", testCSR(t, "www.example.com", "www.example.com"), ServerProfile(0), "Invalid validity"},
+		{"bad signature", tampered, ServerProfile(24 * time.Hour), "Invalid CSR signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := ca.Sign(tt.csr, tt.profile)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The self-signed root is left out of the chain
+			if len(chain) != 1 {
+				t.Fatalf("got a chain of %d certificates, want 1", len(chain))
+			}
+			cert, err := x509.ParseCertificate(chain[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cert.CheckSignatureFrom(caCert); err != nil {
+				t.Errorf("certificate is not signed by the CA: %v", err)
+			}
+			if cert.Subject.CommonName != tt.csr.Subject.CommonName || !SamePublicKey(cert.PublicKey, tt.csr.PublicKey) {
+				t.Errorf("got certificate for %s", cert.Subject)
+			}
+			if cert.IsCA != tt.profile.IsCA {
+				t.Errorf("got IsCA %t, want %t", cert.IsCA, tt.profile.IsCA)
+			}
+			if len(tt.profile.ExtKeyUsage) > 0 && (len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != tt.profile.ExtKeyUsage[0]) {
+				t.Errorf("got extended key usages %v, want %v", cert.ExtKeyUsage, tt.profile.ExtKeyUsage)
+			}
+		})
+	}
+}
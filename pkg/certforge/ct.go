@@ -0,0 +1,168 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// oidExtCTPoison marks a precertificate, which no client may accept as a certificate (RFC 6962, section 3.1)
+	oidExtCTPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	// oidExtSCTList is the extension of the SCTs embedded in a certificate (RFC 6962, section 3.3)
+	oidExtSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+// ctSubmitTimeout bounds each log submission when CTSubmission has no client
+const ctSubmitTimeout = 30 * time.Second
+
+// CTSubmission has a CA submit a precertificate of each certificate to Certificate Transparency logs and embed the
+// SCTs they return, as browsers require of publicly trusted certificates (RFC 6962)
+type CTSubmission struct {
+	// Logs are the URLs of RFC 6962 logs, such as https://ct.example.com/2026h1/, below which ct/v1/add-pre-chain is
+	// posted to. Logs only accept precertificates chaining to a root they trust.
+	Logs []string
+	// MinSCTs is how many logs must return an SCT for a certificate to be issued; zero requires all of them
+	MinSCTs int
+	// Client posts the precertificates; nil uses one with a 30 second timeout
+	Client *http.Client
+}
+
+// ctSCTResponse is the response of add-pre-chain (RFC 6962, section 4.1)
+type ctSCTResponse struct {
+	Version    uint8  `json:"sct_version"`
+	ID         string `json:"id"`
+	Timestamp  uint64 `json:"timestamp"`
+	Extensions string `json:"extensions"`
+	Signature  string `json:"signature"`
+}
+
+// embedSCTs signs a precertificate for template and pub, submits it to the logs of ca.CT, and adds the SCT list
+// extension with the SCTs they return to template
+func (ca *CA) embedSCTs(template *x509.Certificate, pub crypto.PublicKey) error {
+	logs := ca.CT.Logs
+	need := ca.CT.MinSCTs
+	if need <= 0 || need > len(logs) {
+		need = len(logs)
+	}
+
+	// The precertificate is the certificate with the poison extension in place of the SCT list, so the logs sign the
+	// same TBSCertificate as the certificate has without it
+	precert := *template
+	precert.ExtraExtensions = append(append([]pkix.Extension(nil), template.ExtraExtensions...),
+		pkix.Extension{Id: oidExtCTPoison, Critical: true, Value: asn1.NullBytes})
+	der, err := x509.CreateCertificate(rand.Reader, &precert, ca.Certificates[0], pub, ca.Key)
+	if err != nil {
+		return fmt.Errorf("Failed to create precertificate: %v", err)
+	}
+	chain := []string{base64.StdEncoding.EncodeToString(der)}
+	for _, cert := range ca.Certificates {
+		chain = append(chain, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	body, err := json.Marshal(map[string][]string{"chain": chain})
+	if err != nil {
+		return err
+	}
+
+	client := ca.CT.Client
+	if client == nil {
+		client = &http.Client{Timeout: ctSubmitTimeout}
+	}
+	var scts [][]byte
+	var failures []string
+	for _, log := range logs {
+		sct, err := submitPrecertificate(client, log, body)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		scts = append(scts, sct)
+	}
+	if len(scts) < need {
+		return fmt.Errorf("Only %d of the %d SCTs required were returned: %s", len(scts), need, strings.Join(failures, "; "))
+	}
+
+	value, err := sctListExtension(scts)
+	if err != nil {
+		return err
+	}
+	template.ExtraExtensions = append(append([]pkix.Extension(nil), template.ExtraExtensions...), pkix.Extension{Id: oidExtSCTList, Value: value})
+	return nil
+}
+
+// submitPrecertificate posts a precertificate chain to a log's add-pre-chain endpoint and returns the serialized SCT
+func submitPrecertificate(client *http.Client, log string, body []byte) ([]byte, error) {
+	endpoint := strings.TrimSuffix(log, "/") + "/ct/v1/add-pre-chain"
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to submit to %s: %v", log, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the response of %s: %v", log, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s refused the precertificate: %s %s", log, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var sct ctSCTResponse
+	if err := json.Unmarshal(data, &sct); err != nil {
+		return nil, fmt.Errorf("Invalid response from %s: %v", log, err)
+	}
+	id, err := base64.StdEncoding.DecodeString(sct.ID)
+	if err != nil || len(id) != 32 {
+		return nil, fmt.Errorf("Invalid log ID from %s", log)
+	}
+	extensions, err := base64.StdEncoding.DecodeString(sct.Extensions)
+	if err != nil || len(extensions) > 0xffff {
+		return nil, fmt.Errorf("Invalid SCT extensions from %s", log)
+	}
+	// The signature is already a TLS DigitallySigned struct: hash and signature algorithms, then the signature
+	signature, err := base64.StdEncoding.DecodeString(sct.Signature)
+	if err != nil || len(signature) < 4 || int(binary.BigEndian.Uint16(signature[2:4])) != len(signature)-4 {
+		return nil, fmt.Errorf("Invalid SCT signature from %s", log)
+	}
+	if sct.Version != 0 {
+		return nil, fmt.Errorf("Unsupported SCT version %d from %s", sct.Version, log)
+	}
+
+	// SignedCertificateTimestamp (RFC 6962, section 3.2)
+	var buf bytes.Buffer
+	buf.WriteByte(sct.Version)
+	buf.Write(id)
+	binary.Write(&buf, binary.BigEndian, sct.Timestamp)
+	binary.Write(&buf, binary.BigEndian, uint16(len(extensions)))
+	buf.Write(extensions)
+	buf.Write(signature)
+	return buf.Bytes(), nil
+}
+
+// sctListExtension encodes SCTs as the value of the SCT list extension: an OCTET STRING holding a
+// SignedCertificateTimestampList of length-prefixed SCTs
+func sctListExtension(scts [][]byte) ([]byte, error) {
+	var list []byte
+	for _, sct := range scts {
+		list = binary.BigEndian.AppendUint16(list, uint16(len(sct)))
+		list = append(list, sct...)
+	}
+	if len(list) > 0xffff {
+		return nil, fmt.Errorf("The SCT list is too long")
+	}
+	return asn1.Marshal(append(binary.BigEndian.AppendUint16(nil, uint16(len(list))), list...))
+}
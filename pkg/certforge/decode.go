@@ -0,0 +1,133 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoCertificates is returned when data holds no certificate
+var ErrNoCertificates = errors.New("No certificates found")
+
+// ParseCertificates returns every certificate in PEM data, in order, or the certificate in DER data
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, ErrNoCertificates
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// ParseCSR parses a certificate signing request in PEM or DER form
+func ParseCSR(data []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse CSR: %v", err)
+	}
+	return csr, nil
+}
+
+// EncodeCertificates encodes certificates as a PEM bundle, in order
+func EncodeCertificates(certs ...*x509.Certificate) []byte {
+	var out []byte
+	for _, cert := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out
+}
+
+// IsSelfSigned reports whether a certificate's signature verifies with its own public key.
+// The signature is checked directly rather than through CheckSignatureFrom so that
+// self-signed leaf certificates without the CA flag are also recognized.
+func IsSelfSigned(cert *x509.Certificate) bool {
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of DER data, such as a certificate's Raw bytes, in lowercase hex
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// FormatName converts a Distinguished Name to a readable string
+func FormatName(name pkix.Name) string {
+	var parts []string
+
+	if name.CommonName != "" {
+		parts = append(parts, fmt.Sprintf("CN=%s", name.CommonName))
+	}
+
+	for _, org := range name.Organization {
+		parts = append(parts, fmt.Sprintf("O=%s", org))
+	}
+
+	for _, ou := range name.OrganizationalUnit {
+		parts = append(parts, fmt.Sprintf("OU=%s", ou))
+	}
+
+	for _, country := range name.Country {
+		parts = append(parts, fmt.Sprintf("C=%s", country))
+	}
+
+	for _, province := range name.Province {
+		parts = append(parts, fmt.Sprintf("ST=%s", province))
+	}
+
+	for _, locality := range name.Locality {
+		parts = append(parts, fmt.Sprintf("L=%s", locality))
+	}
+
+	for _, street := range name.StreetAddress {
+		parts = append(parts, fmt.Sprintf("street=%s", street))
+	}
+
+	for _, code := range name.PostalCode {
+		parts = append(parts, fmt.Sprintf("postalCode=%s", code))
+	}
+
+	if name.SerialNumber != "" {
+		parts = append(parts, fmt.Sprintf("serialNumber=%s", name.SerialNumber))
+	}
+
+	for _, attr := range name.Names {
+		label, ok := extraNameLabel(attr.Type)
+		if ok {
+			parts = append(parts, fmt.Sprintf("%s=%v", label, attr.Value))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
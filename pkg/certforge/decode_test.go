@@ -0,0 +1,209 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testKey returns a new P-256 key, which is quick to generate
+func testKey(t *testing.T) crypto.Signer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testCertificate returns a new self-signed server certificate for name
+func testCertificate(t *testing.T, name string) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key := testKey(t)
+	der, err := SelfSign(key, pkix.Name{CommonName: name}, []string{name}, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func pemBlock(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func TestParseCertificates(t *testing.T) {
+	first, firstKey := testCertificate(t, "first.example.com")
+	second, _ := testCertificate(t, "second.example.com")
+	keyDER, err := x509.MarshalPKCS8PrivateKey(firstKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		want  []*x509.Certificate
+		isErr error
+	}{
+		{"PEM", pemBlock("CERTIFICATE", first.Raw), []*x509.Certificate{first}, nil},
+		{"DER", first.Raw, []*x509.Certificate{first}, nil},
+		{"bundle", EncodeCertificates(first, second), []*x509.Certificate{first, second}, nil},
+		{"bundle with key", append(pemBlock("PRIVATE KEY", keyDER), pemBlock("CERTIFICATE", second.Raw)...), []*x509.Certificate{second}, nil},
+		{"no certificate", pemBlock("PRIVATE KEY", keyDER), nil, ErrNoCertificates},
+		{"garbage", []byte("not a certificate"), nil, ErrNoCertificates},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certs, err := ParseCertificates(tt.data)
+			if tt.isErr != nil {
+				if !errors.Is(err, tt.isErr) {
+					t.Fatalf("got error %v, want %v", err, tt.isErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(certs) != len(tt.want) {
+				t.Fatalf("got %d certificates, want %d", len(certs), len(tt.want))
+			}
+			for i := range certs {
+				if !certs[i].Equal(tt.want[i]) {
+					t.Errorf("certificate %d is %s, want %s", i, certs[i].Subject, tt.want[i].Subject)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCSR(t *testing.T) {
+	der, err := CreateCSR(testKey(t), pkix.Name{CommonName: "www.example.com"}, []string{"www.example.com", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"PEM": pemBlock("CERTIFICATE REQUEST", der), "DER": der} {
+		t.Run(name, func(t *testing.T) {
+			csr, err := ParseCSR(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if csr.Subject.CommonName != "www.example.com" || len(csr.DNSNames) != 1 || len(csr.IPAddresses) != 1 {
+				t.Errorf("got subject %s, DNS names %v, IP addresses %v", csr.Subject, csr.DNSNames, csr.IPAddresses)
+			}
+		})
+	}
+	if _, err := ParseCSR([]byte("not a CSR")); err == nil {
+		t.Error("parsed garbage as a CSR")
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey := testKey(t).(*ecdsa.PrivateKey)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8 := func(key crypto.PrivateKey) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	cert, _ := testCertificate(t, "www.example.com")
+
+	tests := []struct {
+		name  string
+		data  []byte
+		want  crypto.Signer
+		isErr error
+	}{
+		{"PKCS#1 PEM", pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), rsaKey, nil},
+		{"PKCS#1 DER", x509.MarshalPKCS1PrivateKey(rsaKey), rsaKey, nil},
+		{"SEC 1 PEM", pemBlock("EC PRIVATE KEY", ecDER), ecKey, nil},
+		{"SEC 1 DER", ecDER, ecKey, nil},
+		{"PKCS#8 PEM", pemBlock("PRIVATE KEY", pkcs8(edKey)), edKey, nil},
+		{"PKCS#8 DER", pkcs8(ecKey), ecKey, nil},
+		{"after a certificate", append(pemBlock("CERTIFICATE", cert.Raw), pemBlock("PRIVATE KEY", pkcs8(ecKey))...), ecKey, nil},
+		{"encrypted", pemBlock("ENCRYPTED PRIVATE KEY", []byte{0x30, 0x00}), nil, ErrEncryptedKey},
+		{"certificate only", pemBlock("CERTIFICATE", cert.Raw), nil, nil},
+		{"garbage", []byte("not a key"), nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKey(tt.data)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("parsed a %T", key)
+				}
+				if tt.isErr != nil && !errors.Is(err, tt.isErr) {
+					t.Fatalf("got error %v, want %v", err, tt.isErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !SamePublicKey(key.Public(), tt.want.Public()) {
+				t.Error("parsed a different key")
+			}
+		})
+	}
+}
+
+func TestMarshalPrivateKey(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key       crypto.Signer
+		blockType string
+	}{
+		{testKey(t), "EC PRIVATE KEY"},
+		{edKey, "PRIVATE KEY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.blockType, func(t *testing.T) {
+			data, err := MarshalPrivateKey(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block, _ := pem.Decode(data); block == nil || block.Type != tt.blockType {
+				t.Fatalf("got %q, want a %s block", data, tt.blockType)
+			}
+			key, err := ParsePrivateKey(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !SamePublicKey(key.Public(), tt.key.Public()) {
+				t.Error("parsed a different key")
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package certforge is the library behind the certforge command. It generates keys, builds certificate signing
+// requests and certificates, decodes PEM and DER material, and verifies certificate chains, so other Go programs
+// can do what the command does without running it.
+//
+// Functions return errors rather than printing, and never read or write files; the command adds those around them.
+// They take and return byte slices, and the Read and Write functions take an io.Reader or io.Writer instead, so keys
+// can be generated, parsed, and handed on entirely in memory.
+package certforge
@@ -0,0 +1,76 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+)
+
+// oidExtExtendedKeyUsage is the extended key usage extension (RFC 5280, section 4.2.1.12)
+var oidExtExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+// oidTimeStamping is the id-kp-timeStamping key purpose
+var oidTimeStamping = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+
+// extKeyUsageOIDs maps the names of extended key usages, in lower case, to their OIDs
+var extKeyUsageOIDs = map[string]asn1.ObjectIdentifier{
+	"anyextendedkeyusage": {2, 5, 29, 37, 0},
+	"serverauth":          {1, 3, 6, 1, 5, 5, 7, 3, 1},
+	"clientauth":          {1, 3, 6, 1, 5, 5, 7, 3, 2},
+	"codesigning":         {1, 3, 6, 1, 5, 5, 7, 3, 3},
+	"emailprotection":     {1, 3, 6, 1, 5, 5, 7, 3, 4},
+	"ipsecendsystem":      {1, 3, 6, 1, 5, 5, 7, 3, 5},
+	"ipsectunnel":         {1, 3, 6, 1, 5, 5, 7, 3, 6},
+	"ipsecuser":           {1, 3, 6, 1, 5, 5, 7, 3, 7},
+	"timestamping":        oidTimeStamping,
+	"ocspsigning":         {1, 3, 6, 1, 5, 5, 7, 3, 9},
+	"ipsecike":            {1, 3, 6, 1, 5, 5, 7, 3, 17},
+	"smartcardlogon":      {1, 3, 6, 1, 4, 1, 311, 20, 2, 2},
+	"documentsigning":     {1, 3, 6, 1, 4, 1, 311, 10, 3, 12},
+}
+
+// ParseExtKeyUsage parses an extended key usage given by its RFC 5280 name, such as serverAuth, clientAuth,
+// codeSigning, emailProtection, timeStamping, or OCSPSigning, by one of ipsecIKE, smartcardLogon, and
+// documentSigning, or as a dotted OID
+func ParseExtKeyUsage(name string) (asn1.ObjectIdentifier, error) {
+	name = strings.TrimSpace(name)
+	if oid, ok := extKeyUsageOIDs[strings.ToLower(name)]; ok {
+		return oid, nil
+	}
+	if strings.Contains(name, ".") {
+		return ParseOID(name)
+	}
+	return nil, fmt.Errorf("Unknown extended key usage %q (use a name like serverAuth, clientAuth, or codeSigning, or an OID)", name)
+}
+
+// ExtKeyUsageExtension returns the extended key usage extension for usages parsed with ParseExtKeyUsage, for
+// CA.IssueServer and WithExtension, which is critical for time stamping certificates, as RFC 3161 requires of them
+func ExtKeyUsageExtension(usages ...string) (pkix.Extension, error) {
+	if len(usages) == 0 {
+		return pkix.Extension{}, fmt.Errorf("At least one extended key usage is required")
+	}
+	var oids []asn1.ObjectIdentifier
+	for _, usage := range usages {
+		oid, err := ParseExtKeyUsage(usage)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		oids = append(oids, oid)
+	}
+	return extKeyUsageExtension(oids)
+}
+
+// extKeyUsageExtension returns the extended key usage extension for OIDs
+func extKeyUsageExtension(usages []asn1.ObjectIdentifier) (pkix.Extension, error) {
+	der, err := asn1.Marshal(usages)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("Failed to encode extended key usages: %v", err)
+	}
+	critical := len(usages) == 1 && usages[0].Equal(oidTimeStamping)
+	return pkix.Extension{Id: oidExtExtendedKeyUsage, Critical: critical, Value: der}, nil
+}
@@ -0,0 +1,126 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ParseExtension parses an extension given as oid:[critical:]value, where value is its DER encoded contents in hex
+// or base64, such as 1.3.6.1.4.1.99999.2:critical:0c0474657374. A value of an even number of hex digits is read as
+// hex, and anything else as base64. The value must be a single well-formed DER element.
+func ParseExtension(spec string) (pkix.Extension, error) {
+	dotted, rest, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	if !ok {
+		return pkix.Extension{}, fmt.Errorf("Invalid extension %q: use oid:[critical:]value, with the value in hex or base64", spec)
+	}
+	oid, err := ParseOID(dotted)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	ext := pkix.Extension{Id: oid}
+	if value, ok := strings.CutPrefix(rest, "critical:"); ok {
+		ext.Critical, rest = true, value
+	}
+	if ext.Value, err = decodeExtensionValue(rest); err != nil {
+		return pkix.Extension{}, fmt.Errorf("Invalid extension %q: %v", spec, err)
+	}
+	var element asn1.RawValue
+	if trailing, err := asn1.Unmarshal(ext.Value, &element); err != nil || len(trailing) > 0 {
+		return pkix.Extension{}, fmt.Errorf("Invalid extension %q: the value is not a single DER element", spec)
+	}
+	return ext, nil
+}
+
+// decodeExtensionValue decodes an extension value given in hex, or otherwise in standard or URL-safe base64
+func decodeExtensionValue(value string) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("the value is empty")
+	}
+	if len(value)%2 == 0 && strings.Trim(value, "0123456789abcdefABCDEF") == "" {
+		return hex.DecodeString(value)
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if der, err := encoding.DecodeString(value); err == nil {
+			return der, nil
+		}
+	}
+	return nil, fmt.Errorf("the value is neither hex nor base64")
+}
+
+// mergeExtensions returns the extensions certforge writes itself followed by custom ones, which replace those of
+// crypto/x509 with the same OID. Two extensions with one OID are refused, as RFC 5280 forbids them.
+func mergeExtensions(own, custom []pkix.Extension) ([]pkix.Extension, error) {
+	merged := append([]pkix.Extension(nil), own...)
+	for _, ext := range custom {
+		for _, other := range merged {
+			if ext.Id.Equal(other.Id) {
+				return nil, fmt.Errorf("Extension %s is given more than once", ext.Id)
+			}
+		}
+		merged = append(merged, ext)
+	}
+	return merged, nil
+}
+
+// oidExtBasicConstraints is the basic constraints extension (RFC 5280, section 4.2.1.9)
+var oidExtBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+
+// basicConstraintsExtension returns a basic constraints extension without a path length limit. CA:FALSE is the
+// default of cA, which DER leaves out, so it is encoded as an empty sequence, as OpenSSL and crypto/x509 do.
+func basicConstraintsExtension(isCA, critical bool) pkix.Extension {
+	der, _ := asn1.Marshal(struct {
+		IsCA bool `asn1:"optional"`
+	}{isCA})
+	return pkix.Extension{Id: oidExtBasicConstraints, Critical: critical, Value: der}
+}
+
+// oidExtTLSFeature is the TLS Feature extension (RFC 7633)
+var oidExtTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// tlsFeatureStatusRequest is the status_request TLS extension, which a TLS Feature extension lists for OCSP
+// Must-Staple
+const tlsFeatureStatusRequest = 5
+
+// MustStapleExtension returns the TLS Feature extension requiring status_request, known as OCSP Must-Staple: clients
+// that support it reject the certificate unless the server staples a valid OCSP response to the handshake
+func MustStapleExtension() pkix.Extension {
+	der, _ := asn1.Marshal([]int{tlsFeatureStatusRequest})
+	return pkix.Extension{Id: oidExtTLSFeature, Value: der}
+}
+
+// HasMustStaple reports whether exts, of a certificate or CSR, include a TLS Feature extension requiring
+// status_request
+func HasMustStaple(exts []pkix.Extension) bool {
+	for _, ext := range exts {
+		if !ext.Id.Equal(oidExtTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+		for _, feature := range features {
+			if feature == tlsFeatureStatusRequest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mustStapleExtensions returns the Must-Staple extension when a CSR with exts asks for it, so that the certificate
+// signed for the CSR keeps it
+func mustStapleExtensions(exts []pkix.Extension) []pkix.Extension {
+	if HasMustStaple(exts) {
+		return []pkix.Extension{MustStapleExtension()}
+	}
+	return nil
+}
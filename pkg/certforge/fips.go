@@ -0,0 +1,96 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/fips140"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrNotFIPSApproved is wrapped by the errors of everything FIPS mode refuses
+var ErrNotFIPSApproved = errors.New("Not allowed in FIPS mode")
+
+// FIPSMinRSABits is the smallest RSA key FIPS mode generates or signs with
+const FIPSMinRSABits = 2048
+
+// fipsMode is set by SetFIPS
+var fipsMode atomic.Bool
+
+// SetFIPS turns FIPS mode on or off for the whole process. In FIPS mode keys must be RSA of at least 2048 bits or
+// ECDSA on P-256, P-384, or P-521; GenerateKey, CreateCSR, SelfSign, NewCA, and CA signing refuse anything else with
+// an error wrapping ErrNotFIPSApproved. Certificates and CSRs are signed with SHA-256 or stronger either way. It only
+// restricts the algorithms: run with GODEBUG=fips140=on, or build with GOEXPERIMENT=boringcrypto, for a validated
+// cryptographic module.
+func SetFIPS(on bool) {
+	fipsMode.Store(on)
+}
+
+// FIPS reports whether FIPS mode is on, through SetFIPS or because the Go FIPS 140-3 module is enabled
+func FIPS() bool {
+	return fipsMode.Load() || fips140.Enabled()
+}
+
+// notFIPSApproved returns an error wrapping ErrNotFIPSApproved for what was refused
+func notFIPSApproved(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrNotFIPSApproved, fmt.Sprintf(format, args...))
+}
+
+// CheckFIPSKeyType returns an error in FIPS mode when a key of this type and size may not be generated. Zero bits
+// stands for the GenerateKey default.
+func CheckFIPSKeyType(keyType KeyType, bits int) error {
+	if !FIPS() {
+		return nil
+	}
+	switch keyType {
+	case RSA:
+		if bits != 0 && bits < FIPSMinRSABits {
+			return notFIPSApproved("RSA keys of %d bits (use at least %d)", bits, FIPSMinRSABits)
+		}
+	case ECDSA:
+		switch bits {
+		case 0, 256, 384, 521:
+		default:
+			return notFIPSApproved("ECDSA keys of %d bits (use 256, 384, or 521)", bits)
+		}
+	case Ed25519:
+		return notFIPSApproved("Ed25519 keys (use RSA or ECDSA)")
+	default:
+		return notFIPSApproved("%s keys (use RSA or ECDSA)", keyType)
+	}
+	return nil
+}
+
+// CheckFIPSKey returns an error in FIPS mode when a public key, or the public half of a private key, is not an
+// approved algorithm and size
+func CheckFIPSKey(key crypto.PublicKey) error {
+	if !FIPS() {
+		return nil
+	}
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if bits := k.N.BitLen(); bits < FIPSMinRSABits {
+			return notFIPSApproved("RSA keys of %d bits (use at least %d)", bits, FIPSMinRSABits)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().Name {
+		case "P-256", "P-384", "P-521":
+			return nil
+		}
+		return notFIPSApproved("ECDSA keys on %s (use P-256, P-384, or P-521)", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return notFIPSApproved("Ed25519 keys (use RSA or ECDSA)")
+	}
+	return notFIPSApproved("%T keys (use RSA or ECDSA)", key)
+}
@@ -0,0 +1,101 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Formatter writes artifacts in one output format. Programs built on this package add formats, such as keystores,
+// cloud manifests, or internal formats, by registering a Formatter with RegisterFormat.
+type Formatter interface {
+	// Name is the name the format is chosen by, like "pem"
+	Name() string
+	// Extensions lists the file extensions of the format, the preferred one first
+	Extensions() []string
+	// Write writes the artifacts to w, failing when the format needs one that is missing
+	Write(w io.Writer, artifacts *Artifacts) error
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]Formatter{}
+)
+
+func init() {
+	RegisterFormat(pemFormat{})
+	RegisterFormat(derFormat{})
+}
+
+// RegisterFormat makes a format available by its name; names must be unique
+func RegisterFormat(f Formatter) error {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	name := f.Name()
+	if name == "" {
+		return fmt.Errorf("A format needs a name")
+	}
+	if _, exists := formats[name]; exists {
+		return fmt.Errorf("Format %q is already registered", name)
+	}
+	formats[name] = f
+	return nil
+}
+
+// LookupFormat returns the registered format with the given name
+func LookupFormat(name string) (Formatter, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	f, ok := formats[name]
+	return f, ok
+}
+
+// Formats returns the names of the registered formats, sorted
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pemFormat writes the certificate, its chain, and the key, when there is one, as one PEM file, the form HAProxy
+// and many other servers take
+type pemFormat struct{}
+
+func (pemFormat) Name() string         { return "pem" }
+func (pemFormat) Extensions() []string { return []string{".pem"} }
+
+func (pemFormat) Write(w io.Writer, artifacts *Artifacts) error {
+	if len(artifacts.Certificate) == 0 {
+		return fmt.Errorf("The pem format needs a certificate")
+	}
+	if err := WriteChain(w, append([][]byte{artifacts.Certificate}, artifacts.Chain...)); err != nil {
+		return err
+	}
+	if len(artifacts.KeyPEM) == 0 {
+		return nil
+	}
+	return write(w, artifacts.KeyPEM)
+}
+
+// derFormat writes the certificate alone in DER
+type derFormat struct{}
+
+func (derFormat) Name() string         { return "der" }
+func (derFormat) Extensions() []string { return []string{".der", ".cer"} }
+
+func (derFormat) Write(w io.Writer, artifacts *Artifacts) error {
+	if len(artifacts.Certificate) == 0 {
+		return fmt.Errorf("The der format needs a certificate")
+	}
+	return write(w, artifacts.Certificate)
+}
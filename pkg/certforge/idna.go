@@ -0,0 +1,52 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// acePrefix starts the labels that are punycode encoded
+const acePrefix = "xn--"
+
+// idnaProfile maps names as idna.Lookup does, case folding and normalizing them to NFC by UTS #46, but keeps the
+// wildcard and underscore labels that CheckDNSName accepts and refuses labels longer than 63 characters
+var idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false), idna.VerifyDNSLength(true))
+
+// ToASCII converts an internationalized domain name to the ASCII form certificates must hold (RFC 5280, section
+// 7.2), encoding each label with non-ASCII characters as punycode, such as bücher.example to xn--bcher-kva.example.
+// Names are lowercased and normalized by UTS #46, so decomposed and composed input give the same name.
+func ToASCII(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	// The profile would replace invalid UTF-8 with U+FFFD and encode that
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("Invalid domain name %q: not UTF-8", name)
+	}
+	ascii, err := idnaProfile.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("Invalid domain name %q: %v", name, err)
+	}
+	return ascii, nil
+}
+
+// ToUnicode converts the punycode labels of a domain name back to Unicode, for display. Labels that do not decode
+// are kept as they are.
+func ToUnicode(name string) string {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if len(label) > len(acePrefix) && strings.EqualFold(label[:len(acePrefix)], acePrefix) {
+			if decoded, err := idnaProfile.ToUnicode(label); err == nil {
+				labels[i] = decoded
+			}
+		}
+	}
+	return strings.Join(labels, ".")
+}
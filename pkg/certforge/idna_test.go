@@ -0,0 +1,90 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"www.example.com", "www.example.com"},
+		{"WWW.Example.COM", "www.example.com"},
+		{"", ""},
+		// A-labels from RFC 3492, section 7.1, and registries
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+		{"ελληνικά.gr", "xn--hxargifdar.gr"},
+		{"faß.de", "xn--fa-hia.de"},
+		// Mixed case and decomposed input map to the same name
+		{"BÜCHER.Example", "xn--bcher-kva.example"},
+		{"bu\u0308cher.example", "xn--bcher-kva.example"},
+		{"BU\u0308CHER.EXAMPLE", "xn--bcher-kva.example"},
+		// Wildcards, underscores, and A-labels pass through
+		{"*.bücher.example", "*.xn--bcher-kva.example"},
+		{"_acme-challenge.example.com", "_acme-challenge.example.com"},
+		{"XN--BCHER-KVA.example", "xn--bcher-kva.example"},
+		{"bücher.example.", "xn--bcher-kva.example."},
+	}
+	for _, tt := range tests {
+		got, err := ToASCII(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ToASCII(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestToASCIIInvalid(t *testing.T) {
+	tests := []string{
+		"xn--zz.example",
+		"a..example.com",
+		"-bücher.example",
+		"bücher-.example",
+		"ab--cd.example",
+		"ex\u200dample.com",
+		strings.Repeat("ü", 60) + ".example",
+		"\xff.example",
+	}
+	for _, name := range tests {
+		if got, err := ToASCII(name); err == nil {
+			t.Errorf("ToASCII(%q) = %q, want an error", name, got)
+		}
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"xn--bcher-kva.example", "bücher.example"},
+		{"XN--BCHER-KVA.example", "bücher.example"},
+		{"*.xn--bcher-kva.example", "*.bücher.example"},
+		{"www.example.com", "www.example.com"},
+		// Labels that do not decode are kept
+		{"xn--zz.xn--mnchen-3ya.de", "xn--zz.münchen.de"},
+	}
+	for _, tt := range tests {
+		if got := ToUnicode(tt.name); got != tt.want {
+			t.Errorf("ToUnicode(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// ToUnicode reverses ToASCII
+	for _, name := range []string{"bücher.example", "例え.テスト", "ελληνικά.gr", "faß.de", "*.münchen.de"} {
+		ascii, err := ToASCII(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ToUnicode(ascii); got != name {
+			t.Errorf("ToUnicode(ToASCII(%q)) = %q", name, got)
+		}
+	}
+}
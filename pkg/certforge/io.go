@@ -0,0 +1,121 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+)
+
+// maxInput limits how much the Read functions read, far more than any key, CSR, or certificate bundle needs
+const maxInput = 16 << 20
+
+// readAll reads r up to maxInput bytes
+func readAll(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxInput+1))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading input: %v", err)
+	}
+	if len(data) > maxInput {
+		return nil, fmt.Errorf("Input is larger than %d bytes", maxInput)
+	}
+	return data, nil
+}
+
+// ReadCertificates reads r to the end and parses it like ParseCertificates
+func ReadCertificates(r io.Reader) ([]*x509.Certificate, error) {
+	data, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCertificates(data)
+}
+
+// ReadCSR reads r to the end and parses it like ParseCSR
+func ReadCSR(r io.Reader) (*x509.CertificateRequest, error) {
+	data, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCSR(data)
+}
+
+// ReadPrivateKey reads r to the end and parses it like ParsePrivateKey
+func ReadPrivateKey(r io.Reader) (crypto.Signer, error) {
+	data, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(data)
+}
+
+// ReadCA reads a CA certificate and its key from two readers, like LoadCA
+func ReadCA(certReader, keyReader io.Reader) (*CA, error) {
+	certPEM, err := readAll(certReader)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := readAll(keyReader)
+	if err != nil {
+		return nil, err
+	}
+	return LoadCA(certPEM, keyPEM)
+}
+
+// EncodeChain encodes DER certificates, such as the chain CA.Sign returns, as a PEM bundle
+func EncodeChain(chain [][]byte) []byte {
+	var out []byte
+	for _, der := range chain {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return out
+}
+
+// WriteChain writes DER certificates to w as a PEM bundle
+func WriteChain(w io.Writer, chain [][]byte) error {
+	return write(w, EncodeChain(chain))
+}
+
+// WriteCertificates writes certificates to w as a PEM bundle
+func WriteCertificates(w io.Writer, certs ...*x509.Certificate) error {
+	return write(w, EncodeCertificates(certs...))
+}
+
+// WritePrivateKey writes a key to w as PEM, in the form MarshalPrivateKey chooses, and wipes the encoding
+func WritePrivateKey(w io.Writer, key crypto.PrivateKey) error {
+	data, err := MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	defer Zeroize(data)
+	return write(w, data)
+}
+
+// Write writes the PEM key, CSR, and certificate to their writers, skipping nil writers and missing artifacts
+func (a *Artifacts) Write(key, csr, cert io.Writer) error {
+	for _, out := range []struct {
+		w    io.Writer
+		data []byte
+	}{{key, a.KeyPEM}, {csr, a.CSRPEM}, {cert, a.CertificatePEM}} {
+		if out.w == nil || len(out.data) == 0 {
+			continue
+		}
+		if err := write(out.w, out.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes data to w
+func write(w io.Writer, data []byte) error {
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("Error writing output: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+// zeroReader reads zeros forever
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestWriteAndReadCertificates(t *testing.T) {
+	first, _ := testCertificate(t, "first.example.com")
+	second, _ := testCertificate(t, "second.example.com")
+	var buf bytes.Buffer
+	if err := WriteCertificates(&buf, first, second); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ReadCertificates(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || !certs[0].Equal(first) || !certs[1].Equal(second) {
+		t.Errorf("read %d certificates back", len(certs))
+	}
+}
+
+func TestWriteAndReadPrivateKey(t *testing.T) {
+	key := testKey(t)
+	var buf bytes.Buffer
+	if err := WritePrivateKey(&buf, key); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadPrivateKey(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SamePublicKey(got.Public(), key.Public()) {
+		t.Error("read a different key back")
+	}
+}
+
+func TestReadCA(t *testing.T) {
+	caCert, caKey := testCA(t, 30*24*time.Hour)
+	var certBuf, keyBuf bytes.Buffer
+	if err := WriteCertificates(&certBuf, caCert); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePrivateKey(&keyBuf, caKey); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ReadCA(&certBuf, &keyBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := ca.Sign(testCSR(t, "www.example.com", "www.example.com"), ServerProfile(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := WriteChain(&out, chain); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ReadCertificates(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || certs[0].CheckSignatureFrom(caCert) != nil {
+		t.Error("the written chain does not hold the signed certificate")
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	_, err := ReadCertificates(io.LimitReader(zeroReader{}, maxInput+1))
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("got error %v for input over the limit", err)
+	}
+}
+
+func TestArtifactsWrite(t *testing.T) {
+	r, err := NewRequest(WithCN("www.example.com"), WithKey(testKey(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifacts, err := r.CSR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key, csr, cert bytes.Buffer
+	if err := artifacts.Write(&key, &csr, &cert); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key.Bytes(), artifacts.KeyPEM) || !bytes.Equal(csr.Bytes(), artifacts.CSRPEM) {
+		t.Error("Write did not write the key and CSR")
+	}
+	// A CSR has no certificate to write
+	if cert.Len() != 0 {
+		t.Errorf("wrote %d bytes of certificate", cert.Len())
+	}
+	if err := artifacts.Write(nil, failingWriter{}, nil); err == nil {
+		t.Error("Write ignored a failing writer")
+	}
+}
+
+func TestWriteCertificatesError(t *testing.T) {
+	cert, _ := testCertificate(t, "www.example.com")
+	if err := WriteCertificates(failingWriter{}, cert); err == nil {
+		t.Error("WriteCertificates ignored a failing writer")
+	}
+}
@@ -0,0 +1,266 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeyType names a kind of key GenerateKey can create
+type KeyType string
+
+// Key types
+const (
+	RSA     KeyType = "rsa"
+	ECDSA   KeyType = "ecdsa"
+	Ed25519 KeyType = "ed25519"
+)
+
+// ErrEncryptedKey is returned when a private key is encrypted; the command decrypts such keys with a passphrase
+var ErrEncryptedKey = errors.New("Private key is encrypted")
+
+// GenerateKey generates a private key. bits is the RSA modulus size, at least 2048, or the ECDSA curve size, 256,
+// 384, or 521; zero selects 2048 for RSA and P-256 for ECDSA, and Ed25519 ignores it.
+func GenerateKey(keyType KeyType, bits int) (crypto.Signer, error) {
+	switch KeyType(strings.ToLower(string(keyType))) {
+	case RSA:
+		if bits == 0 {
+			bits = 2048
+		}
+		if bits < 2048 || bits > 16384 {
+			return nil, fmt.Errorf("Invalid RSA key size %d (use 2048 or more)", bits)
+		}
+		if err := CheckKeyType(RSA, bits); err != nil {
+			return nil, err
+		}
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, fmt.Errorf("Error generating private key: %v", err)
+		}
+		return key, nil
+	case ECDSA:
+		var curve elliptic.Curve
+		switch bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Invalid ECDSA key size %d (use 256, 384, or 521)", bits)
+		}
+		if err := CheckKeyType(ECDSA, curve.Params().BitSize); err != nil {
+			return nil, err
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("Error generating private key: %v", err)
+		}
+		return key, nil
+	case Ed25519:
+		if err := CheckKeyType(Ed25519, bits); err != nil {
+			return nil, err
+		}
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("Error generating private key: %v", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unsupported key type %q (use rsa, ecdsa, or ed25519)", keyType)
+}
+
+// GenerateKeyContext generates a private key like GenerateKey, but returns ctx.Err() as soon as ctx is done. Large
+// RSA keys can take seconds; the abandoned generation finishes in the background and is discarded.
+func GenerateKeyContext(ctx context.Context, keyType KeyType, bits int) (crypto.Signer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		key crypto.Signer
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		key, err := GenerateKey(keyType, bits)
+		done <- result{key, err}
+	}()
+	select {
+	case r := <-done:
+		return r.key, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// MarshalPrivateKey encodes a key as PEM: RSA and ECDSA keys in their traditional PKCS#1 and SEC 1 forms, which
+// every TLS server reads, and other keys as PKCS#8. Pass the result to Zeroize once it has been written.
+func MarshalPrivateKey(key crypto.PrivateKey) ([]byte, error) {
+	var block *pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode private key: %v", err)
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode private key: %v", err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	// Only the PEM encoding is returned, so the DER encoding of the key is wiped
+	defer Zeroize(block.Bytes)
+	return pem.EncodeToMemory(block), nil
+}
+
+// ParsePrivateKey returns the first private key in PEM data, or the key in DER data, in PKCS#1, SEC 1, or PKCS#8
+// form. It returns ErrEncryptedKey for an encrypted PKCS#8 key.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	found := false
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		found = true
+		switch block.Type {
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, ErrEncryptedKey
+		case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY":
+			// The parsed key holds its own copy, so the decoded DER is wiped
+			key, err := ParsePrivateKeyBlock(block)
+			Zeroize(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return asSigner(key)
+		}
+	}
+	if found {
+		return nil, fmt.Errorf("No private key found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(data); err == nil {
+		return asSigner(key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(data); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(data); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("No private key found")
+}
+
+// ParsePrivateKeyBlock parses an unencrypted PKCS#1, SEC 1, or PKCS#8 private key
+func ParsePrivateKeyBlock(block *pem.Block) (crypto.PrivateKey, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("Unsupported PEM block type: %s", block.Type)
+}
+
+// asSigner returns key as a crypto.Signer, which every key that can sign certificates is
+func asSigner(key crypto.PrivateKey) (crypto.Signer, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// ParsePublicKey returns the first public key in PEM data, or the key in DER data, in PKIX (PUBLIC KEY) or PKCS#1
+// (RSA PUBLIC KEY) form, and checks it with CheckKey
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	der, pkcs1 := data, false
+	for rest := data; ; {
+		block, next := pem.Decode(rest)
+		if block == nil {
+			// PEM data whose blocks hold no public key is not tried as DER
+			if len(rest) < len(data) {
+				return nil, fmt.Errorf("No public key found")
+			}
+			break
+		}
+		if block.Type == "PUBLIC KEY" || block.Type == "RSA PUBLIC KEY" {
+			der, pkcs1 = block.Bytes, block.Type == "RSA PUBLIC KEY"
+			break
+		}
+		rest = next
+	}
+
+	var pub crypto.PublicKey
+	var err error
+	if pkcs1 {
+		pub, err = x509.ParsePKCS1PublicKey(der)
+	} else if pub, err = x509.ParsePKIXPublicKey(der); err != nil {
+		if rsaKey, rsaErr := x509.ParsePKCS1PublicKey(der); rsaErr == nil {
+			pub, err = rsaKey, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key: %v", err)
+	}
+	if err := CheckKey(pub); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// SamePublicKey reports whether two public keys are equal
+func SamePublicKey(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// PublicKeyDescription returns a short description of a public key such as "RSA 2048"
+func PublicKeyDescription(pub crypto.PublicKey) string {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s", key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return fmt.Sprintf("%T", pub)
+}
+
+// PrivateKeyDescription returns a short description of a private key's algorithm and size, such as "RSA (2048 bits)"
+func PrivateKeyDescription(key crypto.PrivateKey) string {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return fmt.Sprintf("RSA (%d bits)", k.N.BitLen())
+	case *ecdsa.PrivateKey:
+		return fmt.Sprintf("ECDSA (%s)", k.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return "Ed25519"
+	case crypto.Signer:
+		return PublicKeyDescription(k.Public())
+	}
+	return fmt.Sprintf("%T", key)
+}
@@ -0,0 +1,97 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"fmt"
+	"strings"
+)
+
+// registrySuffixes are common public suffixes of more than one label, below which anyone can register a domain, so a
+// wildcard directly below one would cover unrelated sites. Single-label top-level domains are always refused.
+var registrySuffixes = map[string]bool{
+	"co.uk": true, "org.uk": true, "me.uk": true, "ltd.uk": true, "plc.uk": true, "ac.uk": true, "gov.uk": true,
+	"com.au": true, "net.au": true, "org.au": true, "edu.au": true, "gov.au": true,
+	"co.nz": true, "org.nz": true, "co.jp": true, "ne.jp": true, "or.jp": true, "co.kr": true, "or.kr": true,
+	"com.br": true, "net.br": true, "com.cn": true, "net.cn": true, "org.cn": true, "com.hk": true, "com.tw": true,
+	"co.in": true, "net.in": true, "org.in": true, "co.za": true, "com.mx": true, "com.ar": true, "com.tr": true,
+	"co.il": true, "com.sg": true, "com.my": true, "co.id": true, "com.ua": true, "com.pl": true,
+	"github.io": true, "herokuapp.com": true, "azurewebsites.net": true, "cloudfront.net": true,
+	"appspot.com": true, "blogspot.com": true, "netlify.app": true, "vercel.app": true, "pages.dev": true,
+}
+
+// CheckDNSName checks that a DNS name for a certificate is well formed: ASCII labels (convert others with ToASCII)
+// of letters, digits, hyphens, and underscores, and at most one wildcard, as the entire leftmost label of a name that
+// is not a top-level domain or a well-known public suffix such as co.uk. A name like *.*.example.com or
+// www*.example.com is refused rather than encoded, as clients would not match it.
+func CheckDNSName(name string) error {
+	if name == "" || len(name) > 253 {
+		return fmt.Errorf("Invalid DNS name %q: empty or longer than 253 characters", name)
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i, label := range labels {
+		if label == "*" && i == 0 {
+			continue
+		}
+		if strings.Contains(label, "*") {
+			return fmt.Errorf("Invalid wildcard %q: only the entire leftmost label may be *, as in *.example.com", name)
+		}
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("Invalid DNS name %q: empty label or label longer than 63 characters", name)
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("Invalid DNS name %q: label %q starts or ends with a hyphen", name, label)
+		}
+		for _, r := range label {
+			if r == ':' {
+				return fmt.Errorf("Invalid DNS name %q: prefix URIs with uri:, as in uri:%s", name, name)
+			}
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return fmt.Errorf("Invalid DNS name %q: character %q", name, r)
+			}
+		}
+	}
+	if labels[0] == "*" {
+		base := strings.ToLower(strings.Join(labels[1:], "."))
+		if len(labels) < 3 || registrySuffixes[base] {
+			return fmt.Errorf("Invalid wildcard %q: %s is a public suffix, so the wildcard would cover domains of others", name, base)
+		}
+	}
+	return nil
+}
+
+// WildcardCovers reports whether a wildcard like *.example.com matches name, which it does for exactly one more label
+func WildcardCovers(wildcard, name string) bool {
+	base, ok := strings.CutPrefix(strings.ToLower(wildcard), "*.")
+	if !ok {
+		return false
+	}
+	label, rest, ok := strings.Cut(strings.ToLower(name), ".")
+	return ok && label != "*" && label != "" && rest == base
+}
+
+// CoveredNames maps each of dnsNames that a wildcard among them already matches to that wildcard, such as
+// www.example.com to *.example.com. Listing both is allowed but redundant.
+func CoveredNames(dnsNames []string) map[string]string {
+	covered := map[string]string{}
+	for _, wildcard := range dnsNames {
+		for _, name := range dnsNames {
+			if WildcardCovers(wildcard, name) {
+				covered[name] = wildcard
+			}
+		}
+	}
+	return covered
+}
+
+// checkDNSNames checks each DNS name with CheckDNSName
+func checkDNSNames(names []string) error {
+	for _, name := range names {
+		if err := CheckDNSName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseOID parses an object identifier in dotted form, such as 1.3.6.1.4.1.99999.1
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("Invalid OID %q: at least two arcs are required", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		arc, err := strconv.Atoi(part)
+		if err != nil || arc < 0 || part != strconv.Itoa(arc) {
+			return nil, fmt.Errorf("Invalid OID %q: arc %q is not a number", s, part)
+		}
+		oid[i] = arc
+	}
+	// The first two arcs are encoded together, so the second is limited below the joint-iso-itu-t arc
+	if oid[0] > 2 || oid[0] < 2 && oid[1] > 39 {
+		return nil, fmt.Errorf("Invalid OID %q: no such top-level arcs", s)
+	}
+	return oid, nil
+}
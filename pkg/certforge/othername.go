@@ -0,0 +1,128 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"unicode/utf8"
+)
+
+var (
+	// oidExtSubjectAltName is the subject alternative name extension
+	oidExtSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	// oidUserPrincipalName is the otherName type of Microsoft user principal names, which Windows smart card logon
+	// and 802.1X machine authentication map to accounts
+	oidUserPrincipalName = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+)
+
+// GeneralName tags of the names certforge writes (RFC 5280, section 4.2.1.6)
+const (
+	generalNameOther = 0
+	generalNameEmail = 1
+	generalNameDNS   = 2
+	generalNameURI   = 6
+	generalNameIP    = 7
+)
+
+// subjectAltNameExtension encodes sans as a subject alternative name extension. crypto/x509 cannot write otherName
+// entries, so certforge writes the extension itself when there are user principal names, and crypto/x509 leaves
+// its own out. As crypto/x509 does, the extension is critical when the subject is empty.
+func subjectAltNameExtension(subject pkix.Name, sans SubjectAltNames) (pkix.Extension, error) {
+	var names []asn1.RawValue
+	ia5 := func(tag int, value string) error {
+		for i := 0; i < len(value); i++ {
+			if value[i] >= utf8.RuneSelf {
+				return fmt.Errorf("Invalid subject alternative name %q: not ASCII", value)
+			}
+		}
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, Bytes: []byte(value)})
+		return nil
+	}
+	for _, name := range sans.DNSNames {
+		if err := ia5(generalNameDNS, name); err != nil {
+			return pkix.Extension{}, err
+		}
+	}
+	for _, address := range sans.EmailAddresses {
+		if err := ia5(generalNameEmail, address); err != nil {
+			return pkix.Extension{}, err
+		}
+	}
+	for _, ip := range sans.IPAddresses {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: generalNameIP, Bytes: ip})
+	}
+	for _, uri := range sans.URIs {
+		if err := ia5(generalNameURI, uri.String()); err != nil {
+			return pkix.Extension{}, err
+		}
+	}
+	for _, upn := range sans.UPNs {
+		// otherName ::= SEQUENCE { type-id OBJECT IDENTIFIER, value [0] EXPLICIT UTF8String }
+		typeID, err := asn1.Marshal(oidUserPrincipalName)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		value, err := asn1.MarshalWithParams(upn, "utf8,explicit,tag:0")
+		if err != nil {
+			return pkix.Extension{}, fmt.Errorf("Invalid user principal name %q: %v", upn, err)
+		}
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: generalNameOther, IsCompound: true, Bytes: append(typeID, value...)})
+	}
+
+	der, err := asn1.Marshal(names)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("Failed to encode subject alternative names: %v", err)
+	}
+	return pkix.Extension{Id: oidExtSubjectAltName, Critical: len(subject.ToRDNSequence()) == 0, Value: der}, nil
+}
+
+// extraSANExtensions returns the subject alternative name extension to add to a certificate or CSR for sans, or nil
+// when crypto/x509 can write all of them itself
+func extraSANExtensions(subject pkix.Name, sans SubjectAltNames) ([]pkix.Extension, error) {
+	if len(sans.UPNs) == 0 {
+		return nil, nil
+	}
+	ext, err := subjectAltNameExtension(subject, sans)
+	if err != nil {
+		return nil, err
+	}
+	return []pkix.Extension{ext}, nil
+}
+
+// parseUPNs returns the user principal names in the subject alternative name extension among exts
+func parseUPNs(exts []pkix.Extension) []string {
+	var upns []string
+	for _, ext := range exts {
+		if !ext.Id.Equal(oidExtSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return nil
+		}
+		for _, name := range names {
+			if name.Class != asn1.ClassContextSpecific || name.Tag != generalNameOther {
+				continue
+			}
+			var other struct {
+				ID    asn1.ObjectIdentifier
+				Value asn1.RawValue `asn1:"explicit,tag:0"`
+			}
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &other, "tag:0"); err != nil || !other.ID.Equal(oidUserPrincipalName) {
+				continue
+			}
+			var upn string
+			if _, err := asn1.UnmarshalWithParams(other.Value.Bytes, &upn, "utf8"); err == nil {
+				upns = append(upns, upn)
+			}
+		}
+	}
+	return upns
+}
@@ -0,0 +1,216 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPolicy is wrapped by the errors of everything the policy set with SetPolicy refuses
+var ErrPolicy = errors.New("Not allowed by policy")
+
+// Algorithms names the algorithms a Policy may forbid: key types, and the hashes and ciphers some formats and
+// protocols fall back to
+var Algorithms = []string{"rsa", "ecdsa", "ed25519", "sha1", "3des", "rc2"}
+
+// fipsForbidden are the Algorithms FIPS mode refuses
+var fipsForbidden = []string{"ed25519", "sha1", "3des", "rc2"}
+
+// Policy is a site's minimum standard for the keys and certificates created on a host. Zero fields set no limit.
+type Policy struct {
+	MinRSABits   int
+	MinECDSABits int
+	// MaxValidity limits the validity of signed certificates
+	MaxValidity time.Duration
+	// Forbidden lists names from Algorithms
+	Forbidden []string
+}
+
+// policy is set by SetPolicy
+var policy atomic.Pointer[Policy]
+
+// SetPolicy enforces p for the whole process, or no policy for nil. GenerateKey, CreateCSR, SelfSign, NewCA, and CA
+// signing then refuse keys, validities, and algorithms outside it with an error wrapping ErrPolicy.
+func SetPolicy(p *Policy) {
+	policy.Store(p)
+}
+
+// ActivePolicy returns the policy set with SetPolicy, or nil
+func ActivePolicy() *Policy {
+	return policy.Load()
+}
+
+// Forbids reports whether the policy forbids the named algorithm
+func (p *Policy) Forbids(algorithm string) bool {
+	if p == nil {
+		return false
+	}
+	for _, name := range p.Forbidden {
+		if strings.EqualFold(name, algorithm) {
+			return true
+		}
+	}
+	return false
+}
+
+// notAllowed returns an error wrapping ErrPolicy for what was refused
+func notAllowed(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrPolicy, fmt.Sprintf(format, args...))
+}
+
+// CheckKeyType returns an error when FIPS mode or the policy refuses to generate a key of this type and size. Zero
+// bits stands for the GenerateKey default.
+func CheckKeyType(keyType KeyType, bits int) error {
+	keyType = KeyType(strings.ToLower(string(keyType)))
+	if err := CheckFIPSKeyType(keyType, bits); err != nil {
+		return err
+	}
+	p := ActivePolicy()
+	if p == nil {
+		return nil
+	}
+	if p.Forbids(string(keyType)) {
+		return notAllowed("%s keys", keyTypeName(keyType))
+	}
+	switch keyType {
+	case RSA:
+		if bits == 0 {
+			bits = 2048
+		}
+		if bits < p.MinRSABits {
+			return notAllowed("RSA keys of %d bits (use at least %d)", bits, p.MinRSABits)
+		}
+	case ECDSA:
+		if bits == 0 {
+			bits = 256
+		}
+		if bits < p.MinECDSABits {
+			return notAllowed("ECDSA keys of %d bits (use at least %d)", bits, p.MinECDSABits)
+		}
+	}
+	return nil
+}
+
+// CheckKey returns an error when FIPS mode or the policy refuses a public key, or the public half of a private key
+func CheckKey(key crypto.PublicKey) error {
+	if err := CheckFIPSKey(key); err != nil {
+		return err
+	}
+	p := ActivePolicy()
+	if p == nil {
+		return nil
+	}
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return CheckKeyType(RSA, k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return CheckKeyType(ECDSA, k.Curve.Params().BitSize)
+	case ed25519.PublicKey:
+		return CheckKeyType(Ed25519, 0)
+	}
+	return nil
+}
+
+// CheckValidity returns an error when the policy limits certificates to less than validity
+func CheckValidity(validity time.Duration) error {
+	p := ActivePolicy()
+	if p == nil || p.MaxValidity == 0 || validity <= p.MaxValidity {
+		return nil
+	}
+	return notAllowed("Validity of %s (use at most %s)", validityText(validity), validityText(p.MaxValidity))
+}
+
+// CheckAlgorithms returns an error when FIPS mode or the policy forbids any of the named algorithms, which are what
+// the refused operation, described by what, would use
+func CheckAlgorithms(what string, algorithms ...string) error {
+	for _, algorithm := range algorithms {
+		if FIPS() && slices.Contains(fipsForbidden, algorithm) {
+			return notFIPSApproved("%s", what)
+		}
+	}
+	for _, algorithm := range algorithms {
+		if ActivePolicy().Forbids(algorithm) {
+			return notAllowed("%s", what)
+		}
+	}
+	return nil
+}
+
+// MinSignedRSABits and MinSignedECDSABits are the smallest keys a CA signs when the policy sets no larger minimum
+const (
+	MinSignedRSABits   = 2048
+	MinSignedECDSABits = 256
+)
+
+// CheckSignedKey returns an error when a CA should refuse to sign a certificate for pub: when FIPS mode or the
+// policy refuses it, or when it is smaller than MinSignedRSABits or MinSignedECDSABits
+func CheckSignedKey(pub crypto.PublicKey) error {
+	if err := CheckKey(pub); err != nil {
+		return err
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if bits := k.N.BitLen(); bits < MinSignedRSABits {
+			return notAllowed("RSA keys of %d bits (use at least %d)", bits, MinSignedRSABits)
+		}
+	case *ecdsa.PublicKey:
+		if bits := k.Curve.Params().BitSize; bits < MinSignedECDSABits {
+			return notAllowed("ECDSA keys of %d bits (use at least %d)", bits, MinSignedECDSABits)
+		}
+	}
+	return nil
+}
+
+// IsWeakSignature reports whether alg relies on MD2, MD5, or SHA-1, whose signatures can be forged
+func IsWeakSignature(alg x509.SignatureAlgorithm) bool {
+	switch alg {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return true
+	}
+	return false
+}
+
+// CheckSignatureAlgorithm returns an error for the weak signature algorithms, which certforge never signs with.
+// The zero algorithm lets crypto/x509 choose, which it does among SHA-256 and stronger.
+func CheckSignatureAlgorithm(alg x509.SignatureAlgorithm) error {
+	if IsWeakSignature(alg) {
+		return fmt.Errorf("Refusing to sign with %s, which is insecure (use SHA-256 or stronger)", alg)
+	}
+	return nil
+}
+
+// validityText returns a validity in days, or as a duration when it is not whole days
+func validityText(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// keyTypeName returns the usual spelling of a key type
+func keyTypeName(keyType KeyType) string {
+	switch keyType {
+	case RSA:
+		return "RSA"
+	case ECDSA:
+		return "ECDSA"
+	case Ed25519:
+		return "Ed25519"
+	}
+	return string(keyType)
+}
@@ -0,0 +1,393 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Request describes a key, a CSR, and a self-signed certificate to build; create one with NewRequest
+type Request struct {
+	Subject  pkix.Name
+	SANs     SubjectAltNames
+	Validity time.Duration
+	KeyType  KeyType
+	KeyBits  int
+	// Key signs the CSR and certificate; when nil, the first CSR or SelfSign call generates a KeyType key and
+	// keeps it, so both of them use the same key
+	Key crypto.Signer
+	// Extensions are added to the CSR and certificate, replacing those crypto/x509 would write with the same OID
+	Extensions []pkix.Extension
+	// IsCA makes the self-signed certificate a CA certificate that may sign certificates; set it with
+	// WithBasicConstraints
+	IsCA bool
+	// Serial is the serial number of the self-signed certificate; nil uses a random 128-bit one
+	Serial *big.Int
+}
+
+// Option sets a field of a Request
+type Option func(*Request) error
+
+// Artifacts are what a Request built, in DER and PEM; Certificate is empty for CSR. Chain holds the DER CA
+// certificates above a CA-signed Certificate, for callers that have them.
+type Artifacts struct {
+	Key            crypto.Signer
+	KeyPEM         []byte
+	CSR            []byte
+	CSRPEM         []byte
+	Certificate    []byte
+	CertificatePEM []byte
+	Chain          [][]byte
+}
+
+// NewRequest returns a request for a 2048-bit RSA key valid for 365 days, changed by opts. It needs a common name
+// or at least one subject alternative name, and its DNS names must pass CheckDNSName.
+func NewRequest(opts ...Option) (*Request, error) {
+	r := &Request{
+		Validity: 365 * 24 * time.Hour,
+		KeyType:  RSA,
+	}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.Subject.CommonName == "" && len(r.SANs.DNSNames)+len(r.SANs.IPAddresses)+len(r.SANs.EmailAddresses)+len(r.SANs.URIs)+len(r.SANs.UPNs) == 0 {
+		return nil, fmt.Errorf("A common name or subject alternative name is required")
+	}
+	if err := checkDNSNames(r.SANs.DNSNames); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// WithCN sets the common name of the subject
+func WithCN(cn string) Option {
+	return func(r *Request) error {
+		r.Subject.CommonName = cn
+		return nil
+	}
+}
+
+// WithSubject replaces the subject, including its common name
+func WithSubject(subject pkix.Name) Option {
+	return func(r *Request) error {
+		r.Subject = subject
+		return nil
+	}
+}
+
+// WithOrganization sets the organization and, if given, organizational units of the subject
+func WithOrganization(organization string, units ...string) Option {
+	return func(r *Request) error {
+		r.Subject.Organization = []string{organization}
+		r.Subject.OrganizationalUnit = units
+		return nil
+	}
+}
+
+// WithDNS adds DNS names, converting internationalized names to punycode with ToASCII
+func WithDNS(names ...string) Option {
+	return func(r *Request) error {
+		for _, name := range names {
+			if name == "" || strings.ContainsAny(name, " @/") {
+				return fmt.Errorf("Invalid DNS name %q", name)
+			}
+			ascii, err := ToASCII(name)
+			if err != nil {
+				return err
+			}
+			r.SANs.DNSNames = append(r.SANs.DNSNames, ascii)
+		}
+		return nil
+	}
+}
+
+// WithIP adds IP addresses
+func WithIP(ips ...net.IP) Option {
+	return func(r *Request) error {
+		for _, ip := range ips {
+			if ip == nil {
+				return fmt.Errorf("Invalid IP address")
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			r.SANs.IPAddresses = append(r.SANs.IPAddresses, ip)
+		}
+		return nil
+	}
+}
+
+// WithEmail adds email addresses
+func WithEmail(addresses ...string) Option {
+	return func(r *Request) error {
+		for _, address := range addresses {
+			if !strings.Contains(address, "@") {
+				return fmt.Errorf("Invalid email address %q", address)
+			}
+			r.SANs.EmailAddresses = append(r.SANs.EmailAddresses, address)
+		}
+		return nil
+	}
+}
+
+// oidEmailAddress is the PKCS #9 emailAddress attribute, the legacy place of email addresses in subjects
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// WithEmailInSubject adds an email address to the subject as the legacy emailAddress attribute, for software that
+// still looks for it there; RFC 5280 places email addresses in the SANs with WithEmail. Apply it after WithSubject,
+// which replaces the subject.
+func WithEmailInSubject(address string) Option {
+	return func(r *Request) error {
+		if local, domain, ok := strings.Cut(address, "@"); !ok || local == "" || domain == "" {
+			return fmt.Errorf("Invalid email address %q", address)
+		}
+		// emailAddress is an IA5String, which Go does not choose for strings on its own
+		r.Subject.ExtraNames = append(r.Subject.ExtraNames, pkix.AttributeTypeAndValue{
+			Type:  oidEmailAddress,
+			Value: asn1.RawValue{Tag: asn1.TagIA5String, Bytes: []byte(address)},
+		})
+		return nil
+	}
+}
+
+// OIDs of subject attributes that pkix.Name keeps only among its Names and ExtraNames
+var (
+	oidSurname   = asn1.ObjectIdentifier{2, 5, 4, 4}
+	oidTitle     = asn1.ObjectIdentifier{2, 5, 4, 12}
+	oidGivenName = asn1.ObjectIdentifier{2, 5, 4, 42}
+)
+
+// extraNameAttributes are the subject attributes outside pkix.Name's fields that FormatName displays by short name
+var extraNameAttributes = []struct {
+	name string
+	oid  asn1.ObjectIdentifier
+}{
+	{"title", oidTitle},
+	{"GN", oidGivenName},
+	{"SN", oidSurname},
+	{"initials", asn1.ObjectIdentifier{2, 5, 4, 43}},
+	{"dnQualifier", asn1.ObjectIdentifier{2, 5, 4, 46}},
+	{"pseudonym", asn1.ObjectIdentifier{2, 5, 4, 65}},
+	{"UID", asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}},
+	{"DC", asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}},
+	{"emailAddress", oidEmailAddress},
+}
+
+// nameFieldAttributes are the OIDs of the attributes pkix.Name parses into its fields
+var nameFieldAttributes = []asn1.ObjectIdentifier{
+	{2, 5, 4, 3}, {2, 5, 4, 5}, {2, 5, 4, 6}, {2, 5, 4, 7}, {2, 5, 4, 8}, {2, 5, 4, 9}, {2, 5, 4, 10}, {2, 5, 4, 11},
+	{2, 5, 4, 17},
+}
+
+// extraNameLabel returns how FormatName labels a subject attribute outside pkix.Name's fields: by its short name when
+// it has one, and otherwise by its dotted OID, such as a proprietary device identifier
+func extraNameLabel(oid asn1.ObjectIdentifier) (string, bool) {
+	for _, field := range nameFieldAttributes {
+		if oid.Equal(field) {
+			return "", false
+		}
+	}
+	for _, known := range extraNameAttributes {
+		if oid.Equal(known.oid) {
+			return known.name, true
+		}
+	}
+	return oid.String(), true
+}
+
+// WithSubjectAttribute adds an attribute to the subject by OID, such as title (2.5.4.12), givenName (2.5.4.42),
+// surname (2.5.4.4), or a proprietary identifier parsed with ParseOID, which pkix.Name has no fields for. Apply it
+// after WithSubject, which replaces the subject.
+func WithSubjectAttribute(oid asn1.ObjectIdentifier, value string) Option {
+	return func(r *Request) error {
+		if len(oid) < 2 || value == "" {
+			return fmt.Errorf("Invalid subject attribute %s=%q: an OID and a value are required", oid, value)
+		}
+		r.Subject.ExtraNames = append(r.Subject.ExtraNames, pkix.AttributeTypeAndValue{Type: oid, Value: value})
+		return nil
+	}
+}
+
+// WithURI adds URIs, such as SPIFFE IDs
+func WithURI(uris ...string) Option {
+	return func(r *Request) error {
+		for _, uri := range uris {
+			u, err := url.Parse(uri)
+			if err != nil || u.Scheme == "" || u.Opaque == "" && u.Host == "" && u.Path == "" {
+				return fmt.Errorf("Invalid URI %q", uri)
+			}
+			r.SANs.URIs = append(r.SANs.URIs, u)
+		}
+		return nil
+	}
+}
+
+// WithNames adds names of any type, sorted as ParseNames does
+func WithNames(names ...string) Option {
+	return func(r *Request) error {
+		sans, err := ParseNames(names)
+		if err != nil {
+			return err
+		}
+		r.SANs.DNSNames = append(r.SANs.DNSNames, sans.DNSNames...)
+		r.SANs.IPAddresses = append(r.SANs.IPAddresses, sans.IPAddresses...)
+		r.SANs.EmailAddresses = append(r.SANs.EmailAddresses, sans.EmailAddresses...)
+		r.SANs.URIs = append(r.SANs.URIs, sans.URIs...)
+		r.SANs.UPNs = append(r.SANs.UPNs, sans.UPNs...)
+		return nil
+	}
+}
+
+// WithExtension adds extensions to the CSR and certificate, such as a vendor extension parsed with ParseExtension
+func WithExtension(exts ...pkix.Extension) Option {
+	return func(r *Request) error {
+		extensions, err := mergeExtensions(r.Extensions, exts)
+		if err != nil {
+			return err
+		}
+		r.Extensions = extensions
+		return nil
+	}
+}
+
+// WithBasicConstraints writes the basic constraints extension explicitly to the CSR as well as the certificate, with
+// CA:FALSE for end-entity certificates, which strict validators require, or CA:TRUE to make the self-signed
+// certificate a CA. crypto/x509 always marks the extension critical; critical false leaves that out, which RFC 5280
+// only allows for end-entity certificates.
+func WithBasicConstraints(isCA, critical bool) Option {
+	return func(r *Request) error {
+		if isCA && !critical {
+			return fmt.Errorf("Basic constraints must be critical in CA certificates (RFC 5280, section 4.2.1.9)")
+		}
+		if err := WithExtension(basicConstraintsExtension(isCA, critical))(r); err != nil {
+			return err
+		}
+		r.IsCA = isCA
+		return nil
+	}
+}
+
+// WithExtKeyUsage sets the extended key usages of the CSR and certificate, parsed with ParseExtKeyUsage, instead of
+// serverAuth, such as clientAuth for a client certificate
+func WithExtKeyUsage(usages ...string) Option {
+	return func(r *Request) error {
+		ext, err := ExtKeyUsageExtension(usages...)
+		if err != nil {
+			return err
+		}
+		return WithExtension(ext)(r)
+	}
+}
+
+// WithMustStaple adds the TLS Feature extension requiring OCSP stapling, MustStapleExtension, to the CSR and
+// certificate
+func WithMustStaple() Option {
+	return WithExtension(MustStapleExtension())
+}
+
+// WithSerial sets the serial number of the self-signed certificate, such as one from ParseSerial, instead of a random
+// one
+func WithSerial(serial *big.Int) Option {
+	return func(r *Request) error {
+		if err := CheckSerial(serial); err != nil {
+			return err
+		}
+		r.Serial = serial
+		return nil
+	}
+}
+
+// WithValidity sets how long a self-signed certificate is valid
+func WithValidity(validity time.Duration) Option {
+	return func(r *Request) error {
+		if validity <= 0 {
+			return fmt.Errorf("Invalid validity %s", validity)
+		}
+		r.Validity = validity
+		return nil
+	}
+}
+
+// WithKeyType sets the type and size of the key to generate, as GenerateKey takes them
+func WithKeyType(keyType KeyType, bits int) Option {
+	return func(r *Request) error {
+		r.KeyType = keyType
+		r.KeyBits = bits
+		return nil
+	}
+}
+
+// WithKey uses an existing key instead of generating one
+func WithKey(key crypto.Signer) Option {
+	return func(r *Request) error {
+		if key == nil {
+			return fmt.Errorf("A key is required")
+		}
+		r.Key = key
+		return nil
+	}
+}
+
+// CSR builds the key, unless the request has one, and the CSR
+func (r *Request) CSR() (*Artifacts, error) {
+	return r.CSRContext(context.Background())
+}
+
+// CSRContext is CSR with a context that cancels the key generation
+func (r *Request) CSRContext(ctx context.Context) (*Artifacts, error) {
+	if r.Key == nil {
+		key, err := GenerateKeyContext(ctx, r.KeyType, r.KeyBits)
+		if err != nil {
+			return nil, err
+		}
+		r.Key = key
+	}
+	keyPEM, err := MarshalPrivateKey(r.Key)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := createCSR(r.Key, r.Subject, r.SANs, r.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	return &Artifacts{
+		Key:    r.Key,
+		KeyPEM: keyPEM,
+		CSR:    csr,
+		CSRPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}),
+	}, nil
+}
+
+// SelfSign builds the key, unless the request has one, the CSR, and a self-signed server certificate for them
+func (r *Request) SelfSign() (*Artifacts, error) {
+	return r.SelfSignContext(context.Background())
+}
+
+// SelfSignContext is SelfSign with a context that cancels the key generation
+func (r *Request) SelfSignContext(ctx context.Context) (*Artifacts, error) {
+	artifacts, err := r.CSRContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := selfSign(r.Key, r.Subject, r.SANs, r.Extensions, r.IsCA, r.Serial, r.Validity)
+	if err != nil {
+		return nil, err
+	}
+	artifacts.Certificate = cert
+	artifacts.CertificatePEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	return artifacts, nil
+}
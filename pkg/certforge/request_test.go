@@ -0,0 +1,108 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{"common name", []Option{WithCN("www.example.com")}, false},
+		{"SAN only", []Option{WithDNS("www.example.com")}, false},
+		{"IP only", []Option{WithIP(net.ParseIP("192.0.2.1"))}, false},
+		{"no name", nil, true},
+		{"invalid DNS name", []Option{WithDNS("www example com")}, true},
+		{"invalid email", []Option{WithCN("user"), WithEmail("user")}, true},
+		{"invalid URI", []Option{WithURI("not a uri")}, true},
+		{"nil IP", []Option{WithIP(nil)}, true},
+		{"zero validity", []Option{WithCN("www.example.com"), WithValidity(0)}, true},
+		{"nil key", []Option{WithCN("www.example.com"), WithKey(nil)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRequest(tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestSelfSign(t *testing.T) {
+	key := testKey(t)
+	r, err := NewRequest(
+		WithCN("www.example.com"),
+		WithOrganization("Example", "Web"),
+		WithDNS("www.example.com", "bücher.example"),
+		WithIP(net.ParseIP("192.0.2.1")),
+		WithValidity(48*time.Hour),
+		WithKey(key),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifacts, err := r.SelfSign()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if artifacts.Key != key {
+		t.Error("SelfSign did not use the request's key")
+	}
+
+	csr, err := ParseCSR(artifacts.CSRPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Errorf("CSR signature: %v", err)
+	}
+	cert, err := x509.ParseCertificate(artifacts.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SamePublicKey(cert.PublicKey, key.Public()) || !SamePublicKey(csr.PublicKey, key.Public()) {
+		t.Error("CSR or certificate is not for the request's key")
+	}
+	for _, got := range [][]string{csr.DNSNames, cert.DNSNames} {
+		if len(got) != 2 || got[0] != "www.example.com" || got[1] != "xn--bcher-kva.example" {
+			t.Errorf("got DNS names %v", got)
+		}
+	}
+	if cert.Subject.CommonName != "www.example.com" || len(cert.Subject.Organization) != 1 || len(cert.IPAddresses) != 1 {
+		t.Errorf("got subject %s and IP addresses %v", cert.Subject, cert.IPAddresses)
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity != 48*time.Hour {
+		t.Errorf("got validity %s, want 48h", validity)
+	}
+}
+
+func TestRequestGeneratesKeyOnce(t *testing.T) {
+	r, err := NewRequest(WithCN("www.example.com"), WithKeyType(ECDSA, 256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := r.CSR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := r.SelfSign()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if csr.Key == nil || csr.Key != cert.Key {
+		t.Error("CSR and SelfSign generated different keys")
+	}
+	if _, err := ParsePrivateKey(csr.KeyPEM); err != nil {
+		t.Errorf("KeyPEM: %v", err)
+	}
+}
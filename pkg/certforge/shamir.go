@@ -0,0 +1,204 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+)
+
+// KeySharePEMType is the PEM type of the key shares written by SplitKey
+const KeySharePEMType = "CERTFORGE KEY SHARE"
+
+// MaxShares is the most shares a secret can be split into, one for each nonzero element of GF(256)
+const MaxShares = 255
+
+// SplitSecret splits a secret into n shares with Shamir's secret sharing, so that any k of them recover it with
+// CombineSecret and fewer reveal nothing about it. Each share is its x coordinate, 1 to n, followed by one byte for
+// each byte of the secret.
+func SplitSecret(secret []byte, n, k int) ([][]byte, error) {
+	if k < 2 || k > n || n > MaxShares {
+		return nil, fmt.Errorf("Invalid threshold %d of %d shares (use 2 <= threshold <= shares <= %d)", k, n, MaxShares)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("Empty secret")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+	// Each byte of the secret is the constant term of its own random polynomial of degree k-1
+	coefficients := make([]byte, k)
+	defer Zeroize(coefficients)
+	for b, value := range secret {
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("Failed to generate shares: %v", err)
+		}
+		coefficients[0] = value
+		for _, share := range shares {
+			x, y := share[0], byte(0)
+			for c := k - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			share[b+1] = y
+		}
+	}
+	return shares, nil
+}
+
+// CombineSecret recovers a secret from at least the threshold of the shares SplitSecret made of it. Shares that are
+// too few, or from another split, yield a wrong secret rather than an error; SplitKey records what is needed to tell.
+func CombineSecret(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("At least 2 shares are required")
+	}
+	size := len(shares[0])
+	seen := map[byte]bool{}
+	for _, share := range shares {
+		if len(share) != size || size < 2 {
+			return nil, fmt.Errorf("Shares have different lengths")
+		}
+		if share[0] == 0 || seen[share[0]] {
+			return nil, fmt.Errorf("Duplicate or invalid share %d", share[0])
+		}
+		seen[share[0]] = true
+	}
+
+	// Lagrange interpolation at x = 0, where subtraction in GF(256) is XOR
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gfMul(basis, gfMul(other[0], gfInv(other[0]^share[0])))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(share[b+1], basis)
+		}
+	}
+	return secret, nil
+}
+
+// gfMul multiplies in GF(256) with the AES polynomial, without branches or tables that depend on the secret
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the multiplicative inverse of a nonzero element, a^254
+func gfInv(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 7; i++ {
+		a = gfMul(a, a)
+		result = gfMul(result, a)
+	}
+	return result
+}
+
+// SplitKey splits a private key into n PEM encoded shares, any k of which reassemble it with CombineKey. The shares
+// record the threshold, an identifier of the split, and the fingerprint of the public key, so CombineKey can refuse
+// shares of different splits and check what it reassembled.
+func SplitKey(key crypto.PrivateKey, n, k int) ([]*pem.Block, error) {
+	signer, err := asSigner(key)
+	if err != nil {
+		return nil, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode public key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode private key: %v", err)
+	}
+	defer Zeroize(der)
+
+	shares, err := SplitSecret(der, n, k)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("Failed to generate shares: %v", err)
+	}
+	blocks := make([]*pem.Block, n)
+	for i, share := range shares {
+		blocks[i] = &pem.Block{
+			Type: KeySharePEMType,
+			Headers: map[string]string{
+				"Split":      hex.EncodeToString(id),
+				"Share":      strconv.Itoa(int(share[0])),
+				"Shares":     strconv.Itoa(n),
+				"Threshold":  strconv.Itoa(k),
+				"Public-Key": Fingerprint(spki),
+			},
+			Bytes: share,
+		}
+	}
+	return blocks, nil
+}
+
+// CombineKey reassembles a private key from at least the threshold of the shares SplitKey made of it. Wipe the key
+// with ZeroizeKey once it has signed what it was reassembled for.
+func CombineKey(shares []*pem.Block) (crypto.Signer, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("No key shares given")
+	}
+	first := shares[0].Headers
+	threshold, err := strconv.Atoi(first["Threshold"])
+	if err != nil || threshold < 2 {
+		return nil, fmt.Errorf("Invalid key share: missing threshold")
+	}
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("%d of %s key shares given; the threshold is %d", len(shares), first["Shares"], threshold)
+	}
+
+	secrets := make([][]byte, len(shares))
+	for i, block := range shares {
+		if block.Type != KeySharePEMType {
+			return nil, fmt.Errorf("Not a key share: %s", block.Type)
+		}
+		if block.Headers["Split"] != first["Split"] || block.Headers["Public-Key"] != first["Public-Key"] {
+			return nil, fmt.Errorf("Key shares are from different splits")
+		}
+		if len(block.Bytes) < 2 || strconv.Itoa(int(block.Bytes[0])) != block.Headers["Share"] {
+			return nil, fmt.Errorf("Key share %s is damaged", block.Headers["Share"])
+		}
+		secrets[i] = block.Bytes
+	}
+
+	der, err := CombineSecret(secrets)
+	if err != nil {
+		return nil, err
+	}
+	defer Zeroize(der)
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("Key shares do not reassemble a key; one of them is damaged")
+	}
+	signer, err := asSigner(key)
+	if err != nil {
+		return nil, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil || Fingerprint(spki) != first["Public-Key"] {
+		ZeroizeKey(signer)
+		return nil, fmt.Errorf("Key shares do not reassemble the key they were split from; one of them is damaged")
+	}
+	return signer, nil
+}
@@ -0,0 +1,215 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"bytes"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestGFMul(t *testing.T) {
+	// Known products in the AES field, from FIPS 197, section 4.2
+	tests := []struct{ a, b, want byte }{
+		{0x57, 0x83, 0xc1},
+		{0x57, 0x13, 0xfe},
+		{0x57, 0x02, 0xae},
+		{0x57, 0x01, 0x57},
+		{0x57, 0x00, 0x00},
+	}
+	for _, tt := range tests {
+		if got := gfMul(tt.a, tt.b); got != tt.want {
+			t.Errorf("gfMul(%#x, %#x) = %#x, want %#x", tt.a, tt.b, got, tt.want)
+		}
+		if got := gfMul(tt.b, tt.a); got != tt.want {
+			t.Errorf("gfMul(%#x, %#x) = %#x, want %#x", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestGFInv(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := gfMul(byte(a), gfInv(byte(a))); got != 1 {
+			t.Errorf("%#x * gfInv(%#x) = %#x, want 1", a, a, got)
+		}
+	}
+}
+
+// subsets returns every subset of k of the indexes 0 to n-1
+func subsets(n, k int) [][]int {
+	if k == 0 {
+		return [][]int{nil}
+	}
+	var result [][]int
+	for first := 0; first <= n-k; first++ {
+		for _, rest := range subsets(n-first-1, k-1) {
+			subset := []int{first}
+			for _, i := range rest {
+				subset = append(subset, first+1+i)
+			}
+			result = append(result, subset)
+		}
+	}
+	return result
+}
+
+func TestSplitSecret(t *testing.T) {
+	secret := []byte("the root of all trust")
+	tests := []struct{ n, k int }{
+		{2, 2},
+		{3, 2},
+		{5, 3},
+		{5, 5},
+	}
+	for _, tt := range tests {
+		shares, err := SplitSecret(secret, tt.n, tt.k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Any k shares, or more, recover the secret
+		for k := tt.k; k <= tt.n; k++ {
+			for _, subset := range subsets(tt.n, k) {
+				var chosen [][]byte
+				for _, i := range subset {
+					chosen = append(chosen, shares[i])
+				}
+				got, err := CombineSecret(chosen)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, secret) {
+					t.Errorf("%d of %d: shares %v recovered %q", tt.k, tt.n, subset, got)
+				}
+			}
+		}
+		// k-1 shares recover something else
+		if tt.k > 2 {
+			for _, subset := range subsets(tt.n, tt.k-1) {
+				var chosen [][]byte
+				for _, i := range subset {
+					chosen = append(chosen, shares[i])
+				}
+				if got, err := CombineSecret(chosen); err == nil && bytes.Equal(got, secret) {
+					t.Errorf("%d of %d: shares %v recovered the secret below the threshold", tt.k, tt.n, subset)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitSecretErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret []byte
+		n, k   int
+	}{
+		{"threshold of 1", []byte("secret"), 3, 1},
+		{"threshold above shares", []byte("secret"), 3, 4},
+		{"too many shares", []byte("secret"), MaxShares + 1, 2},
+		{"empty secret", nil, 3, 2},
+	}
+	for _, tt := range tests {
+		if _, err := SplitSecret(tt.secret, tt.n, tt.k); err == nil {
+			t.Errorf("%s: split the secret", tt.name)
+		}
+	}
+
+	shares, err := SplitSecret([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, chosen := range map[string][][]byte{
+		"one share":        {shares[0]},
+		"duplicate shares": {shares[0], shares[0]},
+		"short share":      {shares[0], shares[1][:3]},
+		"share zero":       {shares[0], append([]byte{0}, shares[1][1:]...)},
+	} {
+		if _, err := CombineSecret(chosen); err == nil {
+			t.Errorf("%s: combined the shares", name)
+		}
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	key := testKey(t)
+	shares, err := SplitKey(key, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("got %d shares, want 5", len(shares))
+	}
+	for _, subset := range subsets(5, 3) {
+		var chosen []*pem.Block
+		for _, i := range subset {
+			chosen = append(chosen, shares[i])
+		}
+		got, err := CombineKey(chosen)
+		if err != nil {
+			t.Fatalf("shares %v: %v", subset, err)
+		}
+		if !SamePublicKey(got.Public(), key.Public()) {
+			t.Errorf("shares %v reassembled a different key", subset)
+		}
+	}
+	// The shares round trip through PEM
+	var chosen []*pem.Block
+	for _, share := range shares[2:] {
+		block, _ := pem.Decode(pem.EncodeToMemory(share))
+		chosen = append(chosen, block)
+	}
+	if got, err := CombineKey(chosen); err != nil || !SamePublicKey(got.Public(), key.Public()) {
+		t.Errorf("shares decoded from PEM: %v", err)
+	}
+}
+
+func TestCombineKeyErrors(t *testing.T) {
+	key := testKey(t)
+	shares, err := SplitKey(key, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another split of the same key has the same public key but another identifier
+	again, err := SplitKey(key, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := SplitKey(testKey(t), 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damaged := *shares[2]
+	damaged.Bytes = bytes.Clone(damaged.Bytes)
+	damaged.Bytes[5] ^= 0xff
+	renumbered := *shares[2]
+	renumbered.Headers = map[string]string{}
+	for name, value := range shares[2].Headers {
+		renumbered.Headers[name] = value
+	}
+	renumbered.Headers["Share"] = "4"
+
+	tests := []struct {
+		name   string
+		shares []*pem.Block
+		want   string
+	}{
+		{"none", nil, "No key shares"},
+		{"below the threshold", shares[:2], "the threshold is 3"},
+		{"same key, another split", []*pem.Block{shares[0], shares[1], again[2]}, "different splits"},
+		{"another key", []*pem.Block{shares[0], shares[1], other[2]}, "different splits"},
+		{"damaged share", []*pem.Block{shares[0], shares[1], &damaged}, "damaged"},
+		{"renumbered share", []*pem.Block{shares[0], shares[1], &renumbered}, "damaged"},
+		{"not a share", []*pem.Block{shares[0], shares[1], {Type: "PRIVATE KEY", Headers: shares[2].Headers, Bytes: shares[2].Bytes}}, "Not a key share"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CombineKey(tt.shares)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
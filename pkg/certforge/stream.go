@@ -0,0 +1,109 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io"
+)
+
+// maxOutsideLine is how much of a line outside a PEM block is kept; only its start can begin a block
+const maxOutsideLine = 1024
+
+// PEMScanner reads the PEM blocks of a stream one at a time, so CA bundles and CT dumps of any size are parsed with
+// only one block in memory. Text between blocks is skipped, as pem.Decode skips it.
+//
+//	scanner := certforge.NewPEMScanner(f)
+//	for scanner.Scan() {
+//		artifact, err := certforge.ParseBlock(scanner.Block())
+//	}
+//	if err := scanner.Err(); err != nil {
+type PEMScanner struct {
+	r     *bufio.Reader
+	block *pem.Block
+	err   error
+	buf   []byte
+}
+
+// NewPEMScanner returns a scanner reading PEM blocks from r
+func NewPEMScanner(r io.Reader) *PEMScanner {
+	return &PEMScanner{r: bufio.NewReader(r)}
+}
+
+// Scan advances to the next block, returning false at the end of the input or on an error
+func (s *PEMScanner) Scan() bool {
+	s.block = nil
+	if s.err != nil {
+		return false
+	}
+	inBlock := false
+	for {
+		line, err := s.readLine(inBlock)
+		if err != nil && err != io.EOF {
+			s.err = err
+			return false
+		}
+
+		trimmed := bytes.TrimSpace(line)
+		switch {
+		case bytes.HasPrefix(trimmed, []byte("-----BEGIN ")):
+			// A new block also restarts an unterminated one, which pem.Decode would skip too
+			inBlock = true
+			s.buf = append(s.buf[:0], line...)
+			s.buf = append(s.buf, '\n')
+		case inBlock:
+			s.buf = append(s.buf, line...)
+			s.buf = append(s.buf, '\n')
+			if len(s.buf) > maxInput {
+				s.err = fmt.Errorf("PEM block larger than %d bytes", maxInput)
+				return false
+			}
+			if bytes.HasPrefix(trimmed, []byte("-----END ")) {
+				inBlock = false
+				if block, _ := pem.Decode(s.buf); block != nil {
+					s.block = block
+					return true
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return false
+		}
+	}
+}
+
+// readLine returns the next line without its line ending. Outside a block only the start of long lines is kept.
+func (s *PEMScanner) readLine(inBlock bool) ([]byte, error) {
+	var line []byte
+	for {
+		fragment, isPrefix, err := s.r.ReadLine()
+		if err != nil {
+			return line, err
+		}
+		if inBlock || len(line) < maxOutsideLine {
+			line = append(line, fragment...)
+		}
+		if inBlock && len(line) > maxInput {
+			return nil, fmt.Errorf("PEM block larger than %d bytes", maxInput)
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// Block returns the block read by the last Scan
+func (s *PEMScanner) Block() *pem.Block {
+	return s.block
+}
+
+// Err returns the error that stopped the scanner, or nil at the end of the input
+func (s *PEMScanner) Err() error {
+	return s.err
+}
@@ -0,0 +1,109 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// TemplateFuncs returns functions for text/template reports over certificate material. They take the values
+// ParseAny returns and the x509 values they wrap alike:
+//
+//	fingerprint  SHA-256 fingerprint of a certificate, CSR, CRL, or public key, in lowercase hex
+//	daysLeft     whole days until a certificate expires, a CRL is due for update, or a time.Time
+//	formatDN     a distinguished name as "CN=..., O=..."
+//	pemEncode    a certificate, CSR, CRL, public key, or private key as PEM
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"fingerprint": templateFingerprint,
+		"daysLeft":    templateDaysLeft,
+		"formatDN":    FormatName,
+		"pemEncode":   templatePEMEncode,
+	}
+}
+
+// templateFingerprint fingerprints the DER encoding of v, or the SubjectPublicKeyInfo of a public key
+func templateFingerprint(v any) (string, error) {
+	switch x := v.(type) {
+	case *Certificate:
+		return x.Fingerprint, nil
+	case *x509.Certificate:
+		return Fingerprint(x.Raw), nil
+	case *CSR:
+		return Fingerprint(x.Raw), nil
+	case *x509.CertificateRequest:
+		return Fingerprint(x.Raw), nil
+	case *CRL:
+		return Fingerprint(x.Raw), nil
+	case *x509.RevocationList:
+		return Fingerprint(x.Raw), nil
+	case *Key:
+		return x.PublicKeyInfo.Fingerprint, nil
+	case PublicKeyInfo:
+		return x.Fingerprint, nil
+	case []byte:
+		return Fingerprint(x), nil
+	}
+	if info := NewPublicKeyInfo(v); info.Fingerprint != "" {
+		return info.Fingerprint, nil
+	}
+	return "", fmt.Errorf("Cannot fingerprint %T", v)
+}
+
+// templateDaysLeft counts the days until a certificate's NotAfter, a CRL's NextUpdate, or a time
+func templateDaysLeft(v any) (int, error) {
+	var t time.Time
+	switch x := v.(type) {
+	case *Certificate:
+		t = x.NotAfter
+	case *x509.Certificate:
+		t = x.NotAfter
+	case *CRL:
+		t = x.NextUpdate
+	case *x509.RevocationList:
+		t = x.NextUpdate
+	case time.Time:
+		t = x
+	default:
+		return 0, fmt.Errorf("Cannot count days left for %T", v)
+	}
+	return DaysUntil(t, time.Now()), nil
+}
+
+// templatePEMEncode encodes v as a PEM block of the matching type
+func templatePEMEncode(v any) (string, error) {
+	var block *pem.Block
+	switch x := v.(type) {
+	case *Certificate:
+		block = &pem.Block{Type: "CERTIFICATE", Bytes: x.Raw}
+	case *x509.Certificate:
+		block = &pem.Block{Type: "CERTIFICATE", Bytes: x.Raw}
+	case *CSR:
+		block = &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: x.Raw}
+	case *x509.CertificateRequest:
+		block = &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: x.Raw}
+	case *CRL:
+		block = &pem.Block{Type: "X509 CRL", Bytes: x.Raw}
+	case *x509.RevocationList:
+		block = &pem.Block{Type: "X509 CRL", Bytes: x.Raw}
+	case *Key:
+		keyPEM, err := MarshalPrivateKey(x.PrivateKey)
+		return string(keyPEM), err
+	case PublicKeyInfo:
+		return templatePEMEncode(x.PublicKey)
+	default:
+		// Anything else must be a public key
+		der, err := x509.MarshalPKIXPublicKey(x)
+		if err != nil {
+			return "", fmt.Errorf("Cannot PEM encode %T", v)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
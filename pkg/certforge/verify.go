@@ -0,0 +1,36 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// VerifyChain builds the chains from cert to roots through intermediates, for any key usage. With useSystemRoots,
+// the roots are added to the system's trust store instead of replacing it.
+func VerifyChain(cert *x509.Certificate, roots, intermediates []*x509.Certificate, useSystemRoots bool) ([][]*x509.Certificate, error) {
+	rootPool := x509.NewCertPool()
+	if useSystemRoots {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load system root pool: %v", err)
+		}
+		rootPool = pool
+	}
+	for _, root := range roots {
+		rootPool.AddCert(root)
+	}
+	intermediatePool := x509.NewCertPool()
+	for _, intermediate := range intermediates {
+		intermediatePool.AddCert(intermediate)
+	}
+
+	return cert.Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediatePool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+}
@@ -0,0 +1,63 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package certforge
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"math/big"
+)
+
+// Zeroize overwrites a buffer that held private key material, such as a PEM or DER encoded key, once it has been
+// written. Copies made while the buffer was built, and strings converted from it, are not reached, so keep key
+// material in byte slices rather than strings.
+func Zeroize(b []byte) {
+	clear(b)
+}
+
+// ZeroizeKey overwrites the private values of an RSA, ECDSA, or Ed25519 key that is no longer needed; the key must
+// not be used afterwards. Values the crypto packages derive and keep internally, such as an RSA key's precomputed
+// form, are out of reach, so this narrows how long a key stays in memory rather than guaranteeing it is gone.
+func ZeroizeKey(key crypto.PrivateKey) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		zeroizeInt(k.D)
+		for _, prime := range k.Primes {
+			zeroizeInt(prime)
+		}
+		zeroizeInt(k.Precomputed.Dp)
+		zeroizeInt(k.Precomputed.Dq)
+		zeroizeInt(k.Precomputed.Qinv)
+		for _, crt := range k.Precomputed.CRTValues {
+			zeroizeInt(crt.Exp)
+			zeroizeInt(crt.Coeff)
+			zeroizeInt(crt.R)
+		}
+	case *ecdsa.PrivateKey:
+		zeroizeInt(k.D)
+	case ed25519.PrivateKey:
+		clear(k)
+	case *ed25519.PrivateKey:
+		clear(*k)
+	}
+}
+
+// zeroizeInt overwrites the words of n and sets it to zero
+func zeroizeInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+	clear(n.Bits())
+	n.SetInt64(0)
+}
+
+// ZeroizeKey wipes the key and its PEM encoding once they have been written. The request that built the artifacts
+// shares the key, so neither may sign anything afterwards.
+func (a *Artifacts) ZeroizeKey() {
+	Zeroize(a.KeyPEM)
+	ZeroizeKey(a.Key)
+}
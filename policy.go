@@ -0,0 +1,81 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// policyPath is the system-wide policy file. It has no flag or environment variable, so users cannot opt out of it;
+// packagers may move it with -ldflags "-X main.policyPath=<path>".
+var policyPath = "/etc/certforge/policy.yaml"
+
+// policyFile is the policy file an administrator drops on a host
+type policyFile struct {
+	MinRSABits          int      `yaml:"min_rsa_bits"`
+	MinECDSABits        int      `yaml:"min_ecdsa_bits"`
+	MaxValidity         string   `yaml:"max_validity"`
+	ForbiddenAlgorithms []string `yaml:"forbidden_algorithms"`
+}
+
+// enforcePolicy loads the policy file, when there is one, and enforces it for the rest of the process
+func enforcePolicy() error {
+	policy, err := loadPolicy(policyPath)
+	if err != nil || policy == nil {
+		return err
+	}
+	certforge.SetPolicy(policy)
+	return nil
+}
+
+// loadPolicy reads and validates a policy file, returning nil when it does not exist. Any other problem is an error,
+// so a broken policy stops every command rather than being skipped.
+func loadPolicy(path string) (*certforge.Policy, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading policy %s: %v", path, err)
+	}
+	file := &policyFile{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(file); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("Failed to parse policy %s: %v", path, err)
+	}
+
+	policy := &certforge.Policy{MinRSABits: file.MinRSABits, MinECDSABits: file.MinECDSABits}
+	if file.MinRSABits < 0 {
+		return nil, fmt.Errorf("Invalid min_rsa_bits %d in %s", file.MinRSABits, path)
+	}
+	switch file.MinECDSABits {
+	case 0, 256, 384, 521:
+	default:
+		return nil, fmt.Errorf("Invalid min_ecdsa_bits %d in %s (use 256, 384, or 521)", file.MinECDSABits, path)
+	}
+	if file.MaxValidity != "" {
+		if policy.MaxValidity, err = parseThreshold(file.MaxValidity); err != nil || policy.MaxValidity <= 0 {
+			return nil, fmt.Errorf("Invalid max_validity %q in %s: use a duration like 398d", file.MaxValidity, path)
+		}
+	}
+	for _, algorithm := range file.ForbiddenAlgorithms {
+		algorithm = strings.ToLower(algorithm)
+		if !contains(certforge.Algorithms, algorithm) {
+			return nil, fmt.Errorf("Unknown algorithm %q in %s (use %s)", algorithm, path, strings.Join(certforge.Algorithms, ", "))
+		}
+		policy.Forbidden = append(policy.Forbidden, algorithm)
+	}
+	return policy, nil
+}
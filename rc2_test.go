@@ -0,0 +1,48 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestRC2 checks the test vectors of RFC 2268, section 5
+func TestRC2(t *testing.T) {
+	tests := []struct {
+		key           string
+		effectiveBits int
+		plaintext     string
+		ciphertext    string
+	}{
+		{"0000000000000000", 63, "0000000000000000", "ebb773f993278eff"},
+		{"ffffffffffffffff", 64, "ffffffffffffffff", "278b27e42e2f0d49"},
+		{"3000000000000000", 64, "1000000000000001", "30649edf9be7d2c2"},
+		{"88", 64, "0000000000000000", "61a8a244adacccf0"},
+		{"88bca90e90875a", 64, "0000000000000000", "6ccf4308974c267f"},
+		{"88bca90e90875a7f0f79c384627bafb2", 64, "0000000000000000", "1a807d272bbe5db1"},
+		{"88bca90e90875a7f0f79c384627bafb2", 128, "0000000000000000", "2269552ab0f85ca6"},
+		{"88bca90e90875a7f0f79c384627bafb216f80a6f85920584c42fceb0be255daf1e", 129, "0000000000000000", "5b78d3a43dfff1f1"},
+	}
+	for _, tt := range tests {
+		key, _ := hex.DecodeString(tt.key)
+		plaintext, _ := hex.DecodeString(tt.plaintext)
+		ciphertext, _ := hex.DecodeString(tt.ciphertext)
+		c, err := newRC2Cipher(key, tt.effectiveBits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, rc2BlockSize)
+		c.Encrypt(got, plaintext)
+		if !bytes.Equal(got, ciphertext) {
+			t.Errorf("key %s, %d bits: encrypted to %x, want %s", tt.key, tt.effectiveBits, got, tt.ciphertext)
+		}
+		c.Decrypt(got, ciphertext)
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("key %s, %d bits: decrypted to %x, want %s", tt.key, tt.effectiveBits, got, tt.plaintext)
+		}
+	}
+}
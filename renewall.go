@@ -0,0 +1,59 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// runRenewAll implements the renew-all command, a single renewal pass for running from cron
+func runRenewAll(args []string) error {
+	fs := flag.NewFlagSet("renew-all", flag.ExitOnError)
+	configFlag := fs.String("config", "/etc/certforge/renewals.yaml", "Renewal config listing the managed certificates")
+	minRemainingFlag := fs.String("min-remaining", "", "Renew certificates expiring within this long, like 30d (default: renew_before of each certificate)")
+	verboseFlag := fs.Bool("verbose", false, "Also report certificates that are not due")
+	parallelFlag := fs.Int("parallel", 1, "Number of local CA certificates to renew at once")
+	shredOldFlag := fs.Bool("shred-old", false, "Overwrite and remove the key files that renewals with new keys replace")
+	parseArgs(fs, args)
+
+	if *parallelFlag < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+
+	cfg, err := loadRenewalConfig(*configFlag)
+	if err != nil {
+		return err
+	}
+	if *minRemainingFlag != "" {
+		minRemaining, err := parseThreshold(*minRemainingFlag)
+		if err != nil {
+			return fmt.Errorf("Invalid --min-remaining: %v", err)
+		}
+		for i := range cfg.Certificates {
+			mc := &cfg.Certificates[i]
+			// A threshold beyond the lifetime of new certificates would renew them at every run
+			if mc.CA != nil && time.Duration(mc.CA.Days)*24*time.Hour <= minRemaining {
+				return fmt.Errorf("--min-remaining %s is not shorter than the %d days %s is issued for", *minRemainingFlag, mc.CA.Days, mc.Name)
+			}
+			mc.renewBefore = minRemaining
+		}
+	}
+
+	// Nothing is printed unless something is renewed or fails, so cron only mails about changes
+	events := newEventEmitter(false)
+	ctx, cancel := commandContext(0)
+	defer cancel()
+	_, failed := runRenewalPass(ctx, cfg, renewalOptions{Quiet: !*verboseFlag, Events: events, Parallel: *parallelFlag, ShredOld: *shredOldFlag})
+	events.wait()
+	if ctx.Err() != nil {
+		return fmt.Errorf("Interrupted")
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to renew %d of %d certificates", failed, len(cfg.Certificates))
+	}
+	return nil
+}
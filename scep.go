@@ -0,0 +1,679 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// OIDs of the SCEP (RFC 8894) message attributes, and of the challengePassword CSR attribute
+var (
+	oidSCEPMessageType    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidSCEPPKIStatus      = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidSCEPFailInfo       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSCEPSenderNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidSCEPRecipientNonce = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidSCEPTransactionID  = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+	oidChallengePassword  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+)
+
+// SCEP message types and statuses, sent as decimal strings
+const (
+	scepCertRep  = "3"
+	scepPKCSReq  = "19"
+	scepCertPoll = "20"
+
+	scepSuccess = "0"
+	scepFailure = "2"
+	scepPending = "3"
+)
+
+// scepFailInfo describes the failInfo values of a rejected request
+var scepFailInfo = map[string]string{
+	"0": "badAlg: unrecognized or unsupported algorithm",
+	"1": "badMessageCheck: integrity check failed",
+	"2": "badRequest: transaction not permitted or supported",
+	"3": "badTime: signingTime was not close enough to the server's time",
+	"4": "badCertId: no certificate could be identified matching the criteria",
+}
+
+// scepCommands maps the subcommands of scep to their implementations
+var scepCommands = map[string]func(args []string) error{
+	"cacert": runSCEPCACert,
+	"enroll": runSCEPEnroll,
+}
+
+// scepClient talks to the SCEP endpoint of a CA or RA, such as Microsoft NDES
+type scepClient struct {
+	URL   string
+	Ident string
+	Caps  []string
+	http  *http.Client
+}
+
+// runSCEP implements the scep command, which dispatches on the subcommand
+func runSCEP(args []string) error {
+	var names []string
+	for name := range scepCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return fmt.Errorf("scep requires a subcommand: %s", strings.Join(names, ", "))
+	}
+	command, ok := scepCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown scep subcommand %q (use %s)", args[0], strings.Join(names, ", "))
+	}
+	return command(args[1:])
+}
+
+// runSCEPCACert implements scep cacert, which shows and saves the CA and RA certificates of a SCEP server
+func runSCEPCACert(args []string) error {
+	fs := flag.NewFlagSet("scep cacert", flag.ExitOnError)
+	urlFlag := fs.String("url", "", "SCEP endpoint, like http://ndes.example.com/certsrv/mscep/mscep.dll")
+	identFlag := fs.String("ca-ident", "", "CA identifier, for servers hosting several CAs")
+	outFlag := fs.String("out", "", "Save the certificates as PEM to this file")
+	parseArgs(fs, args)
+
+	ctx, cancel := commandContext(0)
+	defer cancel()
+	client, err := newSCEPClient(ctx, *urlFlag, *identFlag)
+	if err != nil {
+		return err
+	}
+	// Servers without these capabilities are sent 3DES envelopes signed with SHA-1
+	if !client.hasCap("AES") {
+		if err := certforge.CheckAlgorithms("3DES encryption, since the SCEP server does not advertise AES", "3des"); err != nil {
+			return err
+		}
+	}
+	if !client.hasCap("SHA-256") {
+		if err := certforge.CheckAlgorithms("SHA-1 signatures, since the SCEP server does not advertise SHA-256", "sha1"); err != nil {
+			return err
+		}
+	}
+	certs, err := client.getCACert(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("=== SCEP Server ===")
+	fmt.Printf("URL: %s\n", client.URL)
+	if len(client.Caps) > 0 {
+		fmt.Printf("Capabilities: %s\n", strings.Join(client.Caps, ", "))
+	} else {
+		fmt.Println("Capabilities: none advertised")
+	}
+	for i, cert := range certs {
+		role := "CA"
+		if !cert.IsCA {
+			role = "RA"
+		}
+		fmt.Printf("\n--- Certificate %d of %d (%s) ---\n", i+1, len(certs), role)
+		fmt.Printf("Subject: %s\n", certforge.FormatName(cert.Subject))
+		fmt.Printf("Issuer: %s\n", certforge.FormatName(cert.Issuer))
+		fmt.Printf("Valid until: %s\n", cert.NotAfter.Format("2006-01-02"))
+		fmt.Printf("Fingerprint (SHA-256): %X\n", sha256.Sum256(cert.Raw))
+		fmt.Printf("Fingerprint (SHA-1): %X\n", sha1.Sum(cert.Raw))
+	}
+
+	if *outFlag != "" {
+		var buf bytes.Buffer
+		for _, cert := range certs {
+			pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+		if err := os.WriteFile(*outFlag, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+		}
+		fmt.Printf("\nCertificates saved to: %s\n", *outFlag)
+	}
+	return nil
+}
+
+// runSCEPEnroll implements scep enroll, which requests a certificate with PKCSReq, polling while it is pending
+func runSCEPEnroll(args []string) error {
+	fs := flag.NewFlagSet("scep enroll", flag.ExitOnError)
+	urlFlag := fs.String("url", "", "SCEP endpoint, like http://ndes.example.com/certsrv/mscep/mscep.dll")
+	identFlag := fs.String("ca-ident", "", "CA identifier, for servers hosting several CAs")
+	fingerprintFlag := fs.String("ca-fingerprint", "", "SHA-256 or SHA-1 fingerprint the CA certificate must have")
+	cnFlag := fs.String("cn", "", "Common name of the certificate (default: the first domain)")
+	domainFlag := fs.String("domain", "", "Comma separated DNS names and IP addresses for the subject alternative names")
+	passwordFlag := fs.String("challenge-password", "", "Enrollment challenge password: pass:, env:, file:, or stdin")
+	keyFlag := fs.String("key", "", "Existing RSA private key to certify (default: generate a new one)")
+	keySizeFlag := fs.Int("key-size", 2048, "Size of a new RSA key: 2048, 3072, or 4096")
+	outFlag := fs.String("out", "", "Output file prefix (default: the common name)")
+	outputDirFlag := fs.String("o", "", "Output directory for generated files (default: current directory)")
+	pollFlag := fs.Duration("poll-interval", 30*time.Second, "How often to ask about a request the CA holds for approval")
+	timeoutFlag := fs.Duration("timeout", 10*time.Minute, "Give up when the certificate is not issued after this long")
+	parseArgs(fs, args)
+
+	var names []string
+	for _, name := range strings.Split(*domainFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" && !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	cn := *cnFlag
+	if cn == "" && len(names) > 0 {
+		cn = names[0]
+	}
+	if cn == "" {
+		return fmt.Errorf("scep enroll requires --cn or --domain")
+	}
+	var password string
+	if *passwordFlag != "" {
+		var err error
+		if password, err = readPassphrase(*passwordFlag); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := commandContext(0)
+	defer cancel()
+
+	// SCEP encrypts the response to the requester's key, so only RSA keys can enroll
+	var key scepKey
+	if *keyFlag != "" {
+		existing, err := readPrivateKey(*keyFlag, "")
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if key, ok = existing.(scepKey); !ok || !isRSAKey(key) {
+			return fmt.Errorf("SCEP requires an RSA key, %s holds %s", *keyFlag, certforge.PrivateKeyDescription(existing))
+		}
+		if err := certforge.CheckKey(key); err != nil {
+			return fmt.Errorf("%s: %w", *keyFlag, err)
+		}
+	} else {
+		signer, err := generateACMEKey(ctx, "rsa", *keySizeFlag)
+		if err != nil {
+			return err
+		}
+		key = signer.(scepKey)
+	}
+
+	client, err := newSCEPClient(ctx, *urlFlag, *identFlag)
+	if err != nil {
+		return err
+	}
+	certs, err := client.getCACert(ctx)
+	if err != nil {
+		return err
+	}
+	if *fingerprintFlag != "" {
+		if err := checkSCEPFingerprint(certs, *fingerprintFlag); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println("Warning: the CA certificate is not pinned; pass --ca-fingerprint to check it")
+	}
+	recipient, ca := scepRecipient(certs)
+	fmt.Printf("Enrolling with %s via %s\n", certforge.FormatName(ca.Subject), client.URL)
+
+	csrDER, err := scepCSR(key, cn, names, password)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return fmt.Errorf("Failed to parse CSR: %v", err)
+	}
+	signer, err := scepSignerCertificate(key, csr)
+	if err != nil {
+		return err
+	}
+
+	// The transaction ID identifies the request across polls; a hash of the key keeps it stable across retries
+	sum := sha256.Sum256(csr.RawSubjectPublicKeyInfo)
+	txID := hex.EncodeToString(sum[:])
+
+	messageType, messageData := scepPKCSReq, csrDER
+	deadline := time.Now().Add(*timeoutFlag)
+	var issued []*x509.Certificate
+	for {
+		status, result, err := client.transact(ctx, messageType, messageData, txID, recipient, certs, signer, key)
+		if err != nil {
+			return err
+		}
+		if status == scepSuccess {
+			issued = result
+			break
+		}
+		if time.Now().Add(*pollFlag).After(deadline) {
+			return fmt.Errorf("The request is still pending approval after %s (transaction %s)", *timeoutFlag, txID)
+		}
+		fmt.Printf("Request pending approval, checking again in %s...\n", *pollFlag)
+		select {
+		case <-time.After(*pollFlag):
+		case <-ctx.Done():
+			return fmt.Errorf("Stopped waiting for approval (transaction %s): %v", txID, ctx.Err())
+		}
+
+		// CertPoll identifies the request by the CA and the requested subject
+		if messageData, err = asn1.Marshal(struct {
+			Issuer  asn1.RawValue
+			Subject asn1.RawValue
+		}{asn1.RawValue{FullBytes: ca.RawSubject}, asn1.RawValue{FullBytes: csr.RawSubject}}); err != nil {
+			return err
+		}
+		messageType = scepCertPoll
+	}
+
+	var leaf *x509.Certificate
+	for _, cert := range issued {
+		if certforge.SamePublicKey(cert.PublicKey, key.Public()) {
+			leaf = cert
+		}
+	}
+	if leaf == nil {
+		return fmt.Errorf("The SCEP response holds no certificate for the requested key")
+	}
+	chain := [][]byte{leaf.Raw}
+	for _, cert := range append(issued, certs...) {
+		if cert.IsCA && !certforge.IsSelfSigned(cert) && !scepContainsCert(chain, cert) {
+			chain = append(chain, cert.Raw)
+		}
+	}
+
+	prefix := *outFlag
+	if prefix == "" {
+		prefix = cn
+	}
+	paths, err := writeIssuedFiles(layoutFlat, *outputDirFlag, prefix, key, csrDER, chain, *keyFlag == "")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\nSuccess!")
+	if *keyFlag == "" {
+		fmt.Printf("Private key saved to: %s\n", paths.Key)
+	}
+	fmt.Printf("CSR saved to: %s\n", paths.CSR)
+	fmt.Printf("Certificate saved to: %s\n", paths.Cert)
+	fmt.Printf("Chain saved to: %s\n", paths.Chain)
+	fmt.Printf("Full chain saved to: %s\n", paths.FullChain)
+	fmt.Printf("Issued by %s, valid until %s\n", certforge.FormatName(leaf.Issuer), leaf.NotAfter.Format("2006-01-02"))
+	return nil
+}
+
+// scepKey is the key of a SCEP client, which signs its requests and decrypts the CA's responses. Any RSA key that
+// does both will do, including keys held in an HSM.
+type scepKey interface {
+	crypto.Signer
+	crypto.Decrypter
+}
+
+// isRSAKey reports whether key has an RSA public key
+func isRSAKey(key crypto.Signer) bool {
+	_, ok := key.Public().(*rsa.PublicKey)
+	return ok
+}
+
+// newSCEPClient checks the endpoint URL and fetches the server's capabilities
+func newSCEPClient(ctx context.Context, endpoint, ident string) (*scepClient, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("scep requires --url")
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid --url %q: use an http or https URL", endpoint)
+	}
+	client := &scepClient{URL: endpoint, Ident: ident, http: &http.Client{Timeout: time.Minute}}
+
+	// Servers without GetCACaps are SCEP drafts predating it, and get the oldest options
+	if body, _, err := client.get(ctx, "GetCACaps", ident); err == nil {
+		for _, line := range strings.Split(string(body), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				client.Caps = append(client.Caps, line)
+			}
+		}
+	}
+	return client, nil
+}
+
+// hasCap reports whether the server advertised a capability, which are case-insensitive
+func (c *scepClient) hasCap(name string) bool {
+	for _, capability := range c.Caps {
+		if strings.EqualFold(capability, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// get performs a SCEP GET operation, returning the body and its content type
+func (c *scepClient) get(ctx context.Context, operation, message string) ([]byte, string, error) {
+	query := url.Values{"operation": {operation}}
+	if message != "" {
+		query.Set("message", message)
+	}
+	endpoint := c.URL + "?" + query.Encode()
+	if strings.Contains(c.URL, "?") {
+		endpoint = c.URL + "&" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s failed: %v", operation, err)
+	}
+	return readSCEPResponse(operation, resp)
+}
+
+// readSCEPResponse reads a response body, failing on non-2xx statuses
+func readSCEPResponse(operation string, resp *http.Response) ([]byte, string, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, "", fmt.Errorf("%s failed: %v", operation, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, "", fmt.Errorf("%s failed: server returned %s", operation, resp.Status)
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// getCACert fetches the CA certificate, or the CA and RA certificates
+func (c *scepClient) getCACert(ctx context.Context) ([]*x509.Certificate, error) {
+	body, contentType, err := c.get(ctx, "GetCACert", c.Ident)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(contentType, "application/x-x509-ca-ra-cert") {
+		der, err := berToDER(body)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse GetCACert response: %v", err)
+		}
+		bundle, err := parsePKCS7(der)
+		if err != nil {
+			return nil, err
+		}
+		if len(bundle.Certificates) == 0 {
+			return nil, fmt.Errorf("GetCACert returned no certificates")
+		}
+		return bundle.Certificates, nil
+	}
+	cert, err := x509.ParseCertificate(body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse GetCACert response (%s): %v", contentType, err)
+	}
+	return []*x509.Certificate{cert}, nil
+}
+
+// pkiOperation sends a PKI message, by POST when the server supports it
+func (c *scepClient) pkiOperation(ctx context.Context, message []byte) ([]byte, error) {
+	if !c.hasCap("POSTPKIOperation") {
+		body, _, err := c.get(ctx, "PKIOperation", base64.StdEncoding.EncodeToString(message))
+		return body, err
+	}
+	endpoint := c.URL + "?operation=PKIOperation"
+	if strings.Contains(c.URL, "?") {
+		endpoint = c.URL + "&operation=PKIOperation"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-pki-message")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("PKIOperation failed: %v", err)
+	}
+	body, _, err := readSCEPResponse("PKIOperation", resp)
+	return body, err
+}
+
+// transact sends a signed and encrypted request and checks the CertRep reply, returning its status and,
+// on success, the certificates it carries
+func (c *scepClient) transact(ctx context.Context, messageType string, messageData []byte, txID string, recipient *x509.Certificate, caCerts []*x509.Certificate, signer *x509.Certificate, key scepKey) (string, []*x509.Certificate, error) {
+	envelope, err := envelopePKCS7(messageData, recipient, c.hasCap("AES"))
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	var attrs []pkcs7Attribute
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidSCEPMessageType, asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(messageType)}},
+		{oidSCEPTransactionID, asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(txID)}},
+		{oidSCEPSenderNonce, nonce},
+	} {
+		encoded, err := newPKCS7Attribute(attr.oid, attr.value)
+		if err != nil {
+			return "", nil, err
+		}
+		attrs = append(attrs, encoded)
+	}
+	digest := oidDigestSHA1
+	if c.hasCap("SHA-256") {
+		digest = oidDigestSHA256
+	}
+	message, err := signPKCS7(envelope, signer, key, digest, attrs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	reply, err := c.pkiOperation(ctx, message)
+	if err != nil {
+		return "", nil, err
+	}
+	content, replyAttrs, err := verifyPKCS7(reply, caCerts)
+	if err != nil {
+		return "", nil, fmt.Errorf("Invalid SCEP response: %v", err)
+	}
+	var replyType, status, replyTxID string
+	var recipientNonce []byte
+	if err := pkcs7AttributeValue(replyAttrs, oidSCEPMessageType, &replyType); err != nil || replyType != scepCertRep {
+		return "", nil, fmt.Errorf("Invalid SCEP response: not a CertRep message")
+	}
+	if err := pkcs7AttributeValue(replyAttrs, oidSCEPTransactionID, &replyTxID); err != nil || replyTxID != txID {
+		return "", nil, fmt.Errorf("Invalid SCEP response: transaction ID does not match the request")
+	}
+	if err := pkcs7AttributeValue(replyAttrs, oidSCEPRecipientNonce, &recipientNonce); err != nil || !bytes.Equal(recipientNonce, nonce) {
+		return "", nil, fmt.Errorf("Invalid SCEP response: nonce does not match the request")
+	}
+	if err := pkcs7AttributeValue(replyAttrs, oidSCEPPKIStatus, &status); err != nil {
+		return "", nil, fmt.Errorf("Invalid SCEP response: %v", err)
+	}
+
+	switch status {
+	case scepSuccess:
+		degenerate, err := decryptPKCS7(content, signer, key)
+		if err != nil {
+			return "", nil, err
+		}
+		if degenerate, err = berToDER(degenerate); err != nil {
+			return "", nil, fmt.Errorf("Failed to parse issued certificates: %v", err)
+		}
+		bundle, err := parsePKCS7(degenerate)
+		if err != nil {
+			return "", nil, err
+		}
+		return status, bundle.Certificates, nil
+	case scepPending:
+		return status, nil, nil
+	case scepFailure:
+		var failInfo string
+		pkcs7AttributeValue(replyAttrs, oidSCEPFailInfo, &failInfo)
+		reason, ok := scepFailInfo[failInfo]
+		if !ok {
+			reason = "no reason given"
+		}
+		return "", nil, fmt.Errorf("The CA rejected the request: %s", reason)
+	}
+	return "", nil, fmt.Errorf("Invalid SCEP response: unknown status %q", status)
+}
+
+// scepRecipient picks the certificate requests are encrypted to, an RA's when there is one, and the issuing CA
+func scepRecipient(certs []*x509.Certificate) (*x509.Certificate, *x509.Certificate) {
+	ca := certs[0]
+	for _, cert := range certs {
+		if cert.IsCA {
+			ca = cert
+			break
+		}
+	}
+	for _, cert := range certs {
+		if cert.IsCA {
+			continue
+		}
+		// RAs such as NDES have separate signing and encryption certificates
+		if cert.KeyUsage == 0 || cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+			for _, issuer := range certs {
+				if issuer.IsCA && cert.CheckSignatureFrom(issuer) == nil {
+					ca = issuer
+				}
+			}
+			return cert, ca
+		}
+	}
+	return ca, ca
+}
+
+// checkSCEPFingerprint checks that one of the CA certificates has the given SHA-256 or SHA-1 fingerprint
+func checkSCEPFingerprint(certs []*x509.Certificate, fingerprint string) error {
+	want, err := hex.DecodeString(strings.NewReplacer(":", "", " ", "").Replace(fingerprint))
+	if err != nil || (len(want) != sha256.Size && len(want) != sha1.Size) {
+		return fmt.Errorf("Invalid --ca-fingerprint: use the hex SHA-256 or SHA-1 fingerprint")
+	}
+	for _, cert := range certs {
+		sha256Sum, sha1Sum := sha256.Sum256(cert.Raw), sha1.Sum(cert.Raw)
+		if cert.IsCA && (bytes.Equal(want, sha256Sum[:]) || bytes.Equal(want, sha1Sum[:])) {
+			return nil
+		}
+	}
+	return fmt.Errorf("No CA certificate from the server matches --ca-fingerprint")
+}
+
+// scepCSR creates the CSR of a request, adding the challenge password the CA authorizes enrollment with
+func scepCSR(key crypto.Signer, cn string, names []string, password string) ([]byte, error) {
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating CSR: %v", err)
+	}
+	if password == "" {
+		return csrDER, nil
+	}
+
+	// crypto/x509 cannot encode challengePassword, so it is added to the request info, which is signed again
+	var csr struct {
+		Info      asn1.RawValue
+		Algorithm pkix.AlgorithmIdentifier
+		Signature asn1.BitString
+	}
+	var info struct {
+		Version    int
+		Subject    asn1.RawValue
+		PublicKey  asn1.RawValue
+		Attributes asn1.RawValue `asn1:"optional,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(csrDER, &csr); err != nil {
+		return nil, fmt.Errorf("Error creating CSR: %v", err)
+	}
+	if _, err := asn1.Unmarshal(csr.Info.FullBytes, &info); err != nil {
+		return nil, fmt.Errorf("Error creating CSR: %v", err)
+	}
+	attrs := []pkcs7Attribute{}
+	for rest := info.Attributes.Bytes; len(rest) > 0; {
+		var attr pkcs7Attribute
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return nil, fmt.Errorf("Error creating CSR: %v", err)
+		}
+		attrs = append(attrs, attr)
+	}
+	challenge, err := newPKCS7Attribute(oidChallengePassword, password)
+	if err != nil {
+		return nil, err
+	}
+	set, err := pkcs7AttributeSet(append(attrs, challenge))
+	if err != nil {
+		return nil, err
+	}
+	info.Attributes = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: set}
+	infoDER, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(infoDER)
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("Error signing CSR: %v", err)
+	}
+	csr.Info = asn1.RawValue{FullBytes: infoDER}
+	csr.Signature = asn1.BitString{Bytes: signature, BitLength: len(signature) * 8}
+	return asn1.Marshal(csr)
+}
+
+// scepSignerCertificate creates the short-lived self-signed certificate a new client signs its requests with
+func scepSignerCertificate(key crypto.Signer, csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      csr.Subject,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}, &x509.Certificate{Subject: csr.Subject}, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating signer certificate: %v", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// scepContainsCert reports whether chain already holds cert
+func scepContainsCert(chain [][]byte, cert *x509.Certificate) bool {
+	for _, der := range chain {
+		if bytes.Equal(der, cert.Raw) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,306 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// signedCertificateTimestamp is a single SCT as defined in RFC 6962 section 3.2
+type signedCertificateTimestamp struct {
+	Version    uint8
+	LogID      []byte
+	Timestamp  time.Time
+	Extensions []byte
+	HashAlg    uint8
+	SigAlg     uint8
+	Signature  []byte
+}
+
+// ctLog is a Certificate Transparency log known from a log list file
+type ctLog struct {
+	Description string
+	Key         crypto.PublicKey
+}
+
+// ctLogList is the subset of the v3 log_list.json schema that certforge reads
+type ctLogList struct {
+	Operators []struct {
+		Name string `json:"name"`
+		Logs []struct {
+			Description string `json:"description"`
+			LogID       string `json:"log_id"`
+			Key         string `json:"key"`
+		} `json:"logs"`
+	} `json:"operators"`
+}
+
+// loadCTLogs reads a log_list.json file and indexes the logs by base64 log ID
+func loadCTLogs(path string) (map[string]ctLog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading CT log list: %v", err)
+	}
+
+	var list ctLogList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("Failed to parse CT log list: %v", err)
+	}
+
+	logs := make(map[string]ctLog)
+	for _, op := range list.Operators {
+		for _, l := range op.Logs {
+			der, err := base64.StdEncoding.DecodeString(l.Key)
+			if err != nil {
+				continue
+			}
+			key, err := x509.ParsePKIXPublicKey(der)
+			if err != nil {
+				continue
+			}
+			logs[l.LogID] = ctLog{Description: l.Description, Key: key}
+		}
+	}
+	return logs, nil
+}
+
+// parseSCTList decodes the value of the embedded SCT list extension
+func parseSCTList(extValue []byte) ([]signedCertificateTimestamp, error) {
+	var list []byte
+	if _, err := asn1.Unmarshal(extValue, &list); err != nil {
+		return nil, fmt.Errorf("Failed to parse SCT list: %v", err)
+	}
+
+	r := bytes.NewReader(list)
+	var total uint16
+	if err := binary.Read(r, binary.BigEndian, &total); err != nil || int(total) != r.Len() {
+		return nil, fmt.Errorf("Invalid SCT list length")
+	}
+
+	var scts []signedCertificateTimestamp
+	for r.Len() > 0 {
+		raw, err := readTLSVector(r, 2)
+		if err != nil {
+			return nil, err
+		}
+		sct, err := parseSCT(raw)
+		if err != nil {
+			return nil, err
+		}
+		scts = append(scts, sct)
+	}
+	return scts, nil
+}
+
+// parseSCT decodes a single serialized SCT
+func parseSCT(raw []byte) (signedCertificateTimestamp, error) {
+	var sct signedCertificateTimestamp
+	if len(raw) < 1+32+8 {
+		return sct, fmt.Errorf("SCT is truncated")
+	}
+
+	r := bytes.NewReader(raw)
+	sct.Version, _ = r.ReadByte()
+	sct.LogID = make([]byte, 32)
+	r.Read(sct.LogID)
+	var ms uint64
+	binary.Read(r, binary.BigEndian, &ms)
+	sct.Timestamp = time.UnixMilli(int64(ms)).UTC()
+
+	var err error
+	if sct.Extensions, err = readTLSVector(r, 2); err != nil {
+		return sct, err
+	}
+	if sct.HashAlg, err = r.ReadByte(); err != nil {
+		return sct, fmt.Errorf("SCT is truncated")
+	}
+	if sct.SigAlg, err = r.ReadByte(); err != nil {
+		return sct, fmt.Errorf("SCT is truncated")
+	}
+	if sct.Signature, err = readTLSVector(r, 2); err != nil {
+		return sct, err
+	}
+	return sct, nil
+}
+
+// readTLSVector reads a TLS style variable length vector with a lenBytes length prefix
+func readTLSVector(r *bytes.Reader, lenBytes int) ([]byte, error) {
+	length := 0
+	for i := 0; i < lenBytes; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("Truncated length prefix")
+		}
+		length = length<<8 | int(b)
+	}
+	if length > r.Len() {
+		return nil, fmt.Errorf("Vector length %d exceeds remaining data", length)
+	}
+	data := make([]byte, length)
+	r.Read(data)
+	return data, nil
+}
+
+// verifySCT checks an embedded SCT signature using the log key and the issuer certificate
+func verifySCT(sct signedCertificateTimestamp, cert, issuer *x509.Certificate, logKey crypto.PublicKey) error {
+	tbs, err := removeExtension(cert.RawTBSCertificate, oidExtSCTList)
+	if err != nil {
+		return err
+	}
+
+	// digitally-signed content for a precert_entry (RFC 6962 section 3.2)
+	var buf bytes.Buffer
+	buf.WriteByte(sct.Version)
+	buf.WriteByte(0) // certificate_timestamp
+	binary.Write(&buf, binary.BigEndian, uint64(sct.Timestamp.UnixMilli()))
+	binary.Write(&buf, binary.BigEndian, uint16(1)) // precert_entry
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	buf.Write(issuerKeyHash[:])
+	buf.Write([]byte{byte(len(tbs) >> 16), byte(len(tbs) >> 8), byte(len(tbs))})
+	buf.Write(tbs)
+	binary.Write(&buf, binary.BigEndian, uint16(len(sct.Extensions)))
+	buf.Write(sct.Extensions)
+
+	if sct.HashAlg != 4 {
+		return fmt.Errorf("Unsupported SCT hash algorithm %d", sct.HashAlg)
+	}
+	digest := sha256.Sum256(buf.Bytes())
+
+	switch key := logKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], sct.Signature) {
+			return fmt.Errorf("ECDSA signature mismatch")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sct.Signature)
+	}
+	return fmt.Errorf("Unsupported log key type %T", logKey)
+}
+
+// removeExtension re-encodes a TBSCertificate without the extension with the given OID
+func removeExtension(rawTBS []byte, oid asn1.ObjectIdentifier) ([]byte, error) {
+	var tbs asn1.RawValue
+	if _, err := asn1.Unmarshal(rawTBS, &tbs); err != nil {
+		return nil, err
+	}
+
+	var fields []asn1.RawValue
+	for rest := tbs.Bytes; len(rest) > 0; {
+		var field asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+
+	var content []byte
+	for _, field := range fields {
+		if field.Class != asn1.ClassContextSpecific || field.Tag != 3 {
+			content = append(content, field.FullBytes...)
+			continue
+		}
+
+		// [3] EXPLICIT SEQUENCE OF Extension
+		var exts []asn1.RawValue
+		if _, err := asn1.Unmarshal(field.Bytes, &exts); err != nil {
+			return nil, err
+		}
+		var kept []asn1.RawValue
+		for _, ext := range exts {
+			var id asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(ext.Bytes, &id); err == nil && id.Equal(oid) {
+				continue
+			}
+			kept = append(kept, ext)
+		}
+		seq, err := asn1.Marshal(kept)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: seq})
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, wrapped...)
+	}
+
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: content})
+}
+
+// sctSignatureName returns a readable name for an SCT hash and signature algorithm pair
+func sctSignatureName(hashAlg, sigAlg uint8) string {
+	hashName := map[uint8]string{4: "SHA256", 5: "SHA384", 6: "SHA512"}[hashAlg]
+	sigName := map[uint8]string{1: "RSA", 3: "ECDSA"}[sigAlg]
+	if hashName == "" || sigName == "" {
+		return fmt.Sprintf("unknown (hash %d, signature %d)", hashAlg, sigAlg)
+	}
+	return sigName + "-" + hashName
+}
+
+// printSCTs displays the embedded Signed Certificate Timestamps of a certificate
+func printSCTs(cert *x509.Certificate, opts decodeOptions) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtSCTList) {
+			continue
+		}
+
+		scts, err := parseSCTList(ext.Value)
+		if err != nil {
+			fmt.Printf("\nSigned Certificate Timestamps: %v\n", err)
+			return
+		}
+
+		issuer := findIssuer(cert, opts.Chain)
+		fmt.Printf("\nSigned Certificate Timestamps (%d):\n", len(scts))
+		for i, sct := range scts {
+			logID := base64.StdEncoding.EncodeToString(sct.LogID)
+			fmt.Printf("  [%d] Log ID: %s\n", i+1, logID)
+			log, known := opts.CTLogs[logID]
+			if known {
+				fmt.Printf("      Log: %s\n", log.Description)
+			}
+			fmt.Printf("      Version: v%d\n", sct.Version+1)
+			fmt.Printf("      Timestamp: %s\n", sct.Timestamp.Format(time.RFC3339))
+			fmt.Printf("      Signature Algorithm: %s\n", sctSignatureName(sct.HashAlg, sct.SigAlg))
+
+			switch {
+			case !known:
+				fmt.Println("      Signature: not verified (log not in --ct-logs list)")
+			case issuer == nil:
+				fmt.Println("      Signature: not verified (issuer certificate not available)")
+			default:
+				if err := verifySCT(sct, cert, issuer, log.Key); err != nil {
+					fmt.Printf("      Signature: INVALID (%v)\n", err)
+				} else {
+					fmt.Println("      Signature: valid")
+				}
+			}
+		}
+	}
+}
+
+// findIssuer returns the certificate from candidates that signed cert, if any
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if bytes.Equal(candidate.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
@@ -0,0 +1,770 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// Actions a serve client may be allowed
+const (
+	serveActionIssue  = "issue"
+	serveActionRead   = "read"
+	serveActionRevoke = "revoke"
+)
+
+// serveClientsConfig is the file listing who may use the REST API
+type serveClientsConfig struct {
+	Clients []*serveClient `yaml:"clients"`
+}
+
+// serveClient is an API client, authenticated by a bearer token
+type serveClient struct {
+	Name        string   `yaml:"name"`
+	Token       string   `yaml:"token"`
+	TokenSHA256 string   `yaml:"token_sha256"`
+	Allow       []string `yaml:"allow"`
+	Domains     []string `yaml:"domains"`
+	MaxDays     int      `yaml:"max_days"`
+
+	tokenHash []byte
+}
+
+// caServer is a small CA issuing certificates over a REST API and keeping an inventory of them
+type caServer struct {
+	mu        sync.Mutex
+	ca        *certforge.CA
+	clients   []*serveClient
+	days      int
+	maxDays   int
+	inventory string
+	records   map[string]*issuedRecord
+	crlNumber int64
+	// crl is the last CRL signed, served until a revocation or until it nears its next update; nil before the first
+	crl           []byte
+	crlNextUpdate time.Time
+	// nextSerial is the next sequential serial number, or zero before the first one
+	nextSerial int64
+	events     *eventsConfig
+	emitter    *eventEmitter
+}
+
+// issuedRecord is a certificate in the inventory
+type issuedRecord struct {
+	Serial        string    `json:"serial"`
+	Subject       string    `json:"subject"`
+	Names         []string  `json:"names"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	Requester     string    `json:"requester"`
+	IssuedAt      time.Time `json:"issued_at"`
+	RevokedAt     time.Time `json:"revoked_at,omitempty"`
+	RevokedBy     string    `json:"revoked_by,omitempty"`
+	RevokedReason string    `json:"revoked_reason,omitempty"`
+	Certificate   string    `json:"certificate"`
+}
+
+// serveInventory is the file the inventory is persisted to
+type serveInventory struct {
+	CRLNumber    int64           `json:"crl_number"`
+	NextSerial   int64           `json:"next_serial,omitempty"`
+	Certificates []*issuedRecord `json:"certificates"`
+}
+
+// runServe implements the serve command, which runs a CA service with REST endpoints to issue, list, and revoke certificates
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listenFlag := fs.String("listen", ":8443", "Address to serve the API on")
+	caFlag := fs.String("ca", "", "CA certificate that signs issued certificates, followed by any chain")
+	caKeyFlag := fs.String("ca-key", "", "Private key of the CA, or a comma separated list of its shares (default: --ca with a .key extension)")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted CA key (pass:, env:, file:, or stdin)")
+	clientsFlag := fs.String("clients", "", "YAML file listing the API clients, their tokens, and what they may do")
+	dataFlag := fs.String("data", "certforge-ca", "Directory the inventory of issued certificates is kept in")
+	daysFlag := fs.Int("days", 90, "Default validity of issued certificates in days")
+	maxDaysFlag := fs.Int("max-days", 397, "Longest validity a client may request in days")
+	tlsCertFlag := fs.String("tls-cert", "", "Certificate of the API (default: issue one from the CA for --hostname)")
+	tlsKeyFlag := fs.String("tls-key", "", "Private key of --tls-cert")
+	hostnameFlag := fs.String("hostname", "localhost,127.0.0.1,::1", "Comma separated names of the API's own certificate when --tls-cert is not given")
+	plainFlag := fs.Bool("http", false, "Serve plain HTTP, for running behind a TLS-terminating proxy")
+	eventsFlag := fs.String("events", "", "Events config; issued, revoked, and expiring certificates are posted to its webhooks")
+	insecureFlag := fs.Bool("insecure-allow", false, "Sign CSRs for RSA keys under 2048 bits and ECDSA keys under 256 bits, which are refused by default")
+	ctLogFlag := fs.String("ct-log", "", "Comma separated URLs of CT logs to submit precertificates to, embedding their SCTs in issued certificates")
+	ctMinSCTsFlag := fs.Int("ct-min-scts", 0, "How many CT logs must return an SCT for a certificate to be issued (default: all of them)")
+	sequentialFlag := fs.Bool("sequential-serials", false, "Number certificates 1, 2, 3, and so on, counting in the inventory, instead of using random 128-bit serials")
+	parseArgs(fs, args)
+
+	if *caFlag == "" || *clientsFlag == "" {
+		return fmt.Errorf("serve requires --ca and --clients")
+	}
+	caKey := *caKeyFlag
+	if caKey == "" {
+		caKey = strings.TrimSuffix(*caFlag, ".crt") + ".key"
+	}
+	if *daysFlag < 1 || *maxDaysFlag < *daysFlag {
+		return fmt.Errorf("Invalid --days %d: use at least 1 and at most --max-days", *daysFlag)
+	}
+	if (*tlsCertFlag == "") != (*tlsKeyFlag == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+	var ctLogs []string
+	for _, log := range strings.Split(*ctLogFlag, ",") {
+		if log = strings.TrimSpace(log); log != "" {
+			ctLogs = append(ctLogs, log)
+		}
+	}
+	if err := checkCTLogs(ctLogs, *ctMinSCTsFlag); err != nil {
+		return err
+	}
+
+	ca, err := loadLocalCA(&managedCA{Cert: *caFlag, Key: caKey, Passin: *passinFlag})
+	if err != nil {
+		return err
+	}
+	ca.AllowInsecure = *insecureFlag
+	clients, err := loadServeClients(*clientsFlag)
+	if err != nil {
+		return err
+	}
+	server := &caServer{
+		ca:        ca,
+		clients:   clients,
+		days:      *daysFlag,
+		maxDays:   *maxDaysFlag,
+		inventory: filepath.Join(*dataFlag, "inventory.json"),
+		emitter:   newEventEmitter(true),
+	}
+	if *eventsFlag != "" {
+		if server.events, err = loadEventsConfig(*eventsFlag); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(*dataFlag, 0700); err != nil {
+		return fmt.Errorf("Error creating data directory: %v", err)
+	}
+	if err := server.load(); err != nil {
+		return err
+	}
+	if *sequentialFlag {
+		ca.NextSerial = server.assignSerial
+	}
+
+	listener, err := net.Listen("tcp", *listenFlag)
+	if err != nil {
+		return fmt.Errorf("Failed to listen on %s: %v", *listenFlag, err)
+	}
+	scheme := "http"
+	if !*plainFlag {
+		var cert tls.Certificate
+		if *tlsCertFlag != "" {
+			if cert, err = tls.LoadX509KeyPair(*tlsCertFlag, *tlsKeyFlag); err != nil {
+				return fmt.Errorf("Failed to load --tls-cert: %v", err)
+			}
+		} else {
+			var hostnames []string
+			for _, name := range strings.Split(*hostnameFlag, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					hostnames = append(hostnames, name)
+				}
+			}
+			if len(hostnames) == 0 {
+				return fmt.Errorf("serve requires a --hostname or --tls-cert")
+			}
+			tlsKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				return fmt.Errorf("Error generating private key: %v", err)
+			}
+			auditLog(auditLogKey(tlsKey.Public()))
+			chain, err := ca.IssueServer(tlsKey.Public(), hostnames, time.Duration(*daysFlag)*24*time.Hour)
+			if err != nil {
+				return err
+			}
+			if leaf, err := x509.ParseCertificate(chain[0]); err == nil {
+				entry := auditLogCertificate(auditLogCertSigned, leaf)
+				entry.Inputs = auditLogInputs(*caFlag)
+				auditLog(entry)
+			}
+			cert = tls.Certificate{Certificate: chain, PrivateKey: tlsKey}
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+		scheme = "https"
+	}
+
+	// Certificates are logged from here on, so the API's own certificate is not
+	if len(ctLogs) > 0 {
+		ca.CT = &certforge.CTSubmission{Logs: ctLogs, MinSCTs: *ctMinSCTsFlag}
+	}
+
+	fmt.Println("=== Certificate Service ===")
+	fmt.Printf("API: %s://%s/v1\n", scheme, listener.Addr())
+	fmt.Printf("Issuing CA: %s\n", certforge.FormatName(ca.Certificates[0].Subject))
+	if ca.CT != nil {
+		fmt.Printf("CT Logs: %s\n", strings.Join(ca.CT.Logs, ", "))
+	}
+	if ca.NextSerial != nil {
+		fmt.Printf("Serials: sequential, next %s\n", formatSerial(big.NewInt(max(server.nextSerial, 1))))
+	}
+	fmt.Printf("Clients: %d\n", len(clients))
+	fmt.Printf("Inventory: %s (%d certificates)\n", server.inventory, len(server.records))
+	if server.events != nil {
+		fmt.Printf("Events: %d webhooks\n", len(server.events.Webhooks))
+		go server.watchExpiry()
+	}
+	fmt.Println()
+	return http.Serve(listener, server.handler())
+}
+
+// loadServeClients reads and validates the clients file
+func loadServeClients(path string) ([]*serveClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	cfg := &serveClientsConfig{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
+	if len(cfg.Clients) == 0 {
+		return nil, fmt.Errorf("%s lists no clients", path)
+	}
+
+	var names []string
+	for _, client := range cfg.Clients {
+		if client.Name == "" || contains(names, client.Name) {
+			return nil, fmt.Errorf("%s: every client needs a unique name", path)
+		}
+		names = append(names, client.Name)
+		switch {
+		case client.Token != "" && client.TokenSHA256 != "":
+			return nil, fmt.Errorf("%s: client %s has both token and token_sha256", path, client.Name)
+		case client.Token != "":
+			// Tokens are read like passphrases, so the file need not hold the secret itself
+			token, err := readPassphrase(client.Token)
+			if err != nil {
+				return nil, fmt.Errorf("%s: client %s: %v", path, client.Name, err)
+			}
+			if len(token) < 16 {
+				return nil, fmt.Errorf("%s: the token of client %s is shorter than 16 characters", path, client.Name)
+			}
+			sum := sha256.Sum256([]byte(token))
+			client.tokenHash = sum[:]
+		case client.TokenSHA256 != "":
+			if client.tokenHash, err = hex.DecodeString(client.TokenSHA256); err != nil || len(client.tokenHash) != sha256.Size {
+				return nil, fmt.Errorf("%s: the token_sha256 of client %s is not a hex SHA-256 digest", path, client.Name)
+			}
+		default:
+			return nil, fmt.Errorf("%s: client %s needs a token or token_sha256", path, client.Name)
+		}
+		if len(client.Allow) == 0 {
+			return nil, fmt.Errorf("%s: client %s allows nothing (use issue, read, revoke)", path, client.Name)
+		}
+		for _, action := range client.Allow {
+			if action != serveActionIssue && action != serveActionRead && action != serveActionRevoke {
+				return nil, fmt.Errorf("%s: client %s allows unknown action %q (use issue, read, revoke)", path, client.Name, action)
+			}
+		}
+		if len(client.Domains) == 0 && (contains(client.Allow, serveActionIssue) || contains(client.Allow, serveActionRevoke)) {
+			return nil, fmt.Errorf("%s: client %s may issue or revoke but lists no domains (use \"*\" for every name)", path, client.Name)
+		}
+		for i, domain := range client.Domains {
+			client.Domains[i] = strings.ToLower(domain)
+		}
+	}
+	return cfg.Clients, nil
+}
+
+// load reads the inventory, which is empty on the first start
+func (s *caServer) load() error {
+	s.records = map[string]*issuedRecord{}
+	data, err := os.ReadFile(s.inventory)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading file: %v", err)
+	}
+	var inv serveInventory
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return fmt.Errorf("Failed to parse %s: %v", s.inventory, err)
+	}
+	s.crlNumber = inv.CRLNumber
+	s.nextSerial = inv.NextSerial
+	for _, record := range inv.Certificates {
+		s.records[record.Serial] = record
+	}
+	return nil
+}
+
+// save writes the inventory atomically; s.mu must be held
+func (s *caServer) save() error {
+	inv := serveInventory{CRLNumber: s.crlNumber, NextSerial: s.nextSerial}
+	for _, record := range s.records {
+		inv.Certificates = append(inv.Certificates, record)
+	}
+	sort.Slice(inv.Certificates, func(i, j int) bool { return inv.Certificates[i].IssuedAt.Before(inv.Certificates[j].IssuedAt) })
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.inventory + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, s.inventory); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", s.inventory, err)
+	}
+	return nil
+}
+
+// assignSerial returns the next sequential serial number, skipping any already in the inventory, and saves the
+// inventory with the counter advanced before the serial is used, so no serial is issued twice, even after a restart
+func (s *caServer) assignSerial() (*big.Int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := max(s.nextSerial, 1)
+	for s.records[big.NewInt(next).Text(16)] != nil {
+		next++
+	}
+	previous := s.nextSerial
+	s.nextSerial = next + 1
+	if err := s.save(); err != nil {
+		s.nextSerial = previous
+		return nil, err
+	}
+	return big.NewInt(next), nil
+}
+
+// handler routes the REST API
+func (s *caServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok\n") })
+	mux.HandleFunc("GET /v1/ca", s.handleCA)
+	mux.HandleFunc("GET /v1/crl", s.handleCRL)
+	mux.HandleFunc("POST /v1/certificates", s.authorized(serveActionIssue, s.handleIssue))
+	mux.HandleFunc("GET /v1/certificates", s.authorized(serveActionRead, s.handleList))
+	mux.HandleFunc("GET /v1/certificates/{serial}", s.authorized(serveActionRead, s.handleGet))
+	mux.HandleFunc("POST /v1/certificates/{serial}/revoke", s.authorized(serveActionRevoke, s.handleRevoke))
+	return mux
+}
+
+// authorized wraps a handler so it runs only for clients with a valid bearer token allowed the action
+func (s *caServer) authorized(action string, next func(http.ResponseWriter, *http.Request, *serveClient)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="certforge"`)
+			writeServeError(w, http.StatusUnauthorized, "Missing bearer token")
+			return
+		}
+		sum := sha256.Sum256([]byte(token))
+		var client *serveClient
+		for _, c := range s.clients {
+			if subtle.ConstantTimeCompare(sum[:], c.tokenHash) == 1 {
+				client = c
+			}
+		}
+		if client == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="certforge", error="invalid_token"`)
+			writeServeError(w, http.StatusUnauthorized, "Invalid bearer token")
+			return
+		}
+		if !contains(client.Allow, action) {
+			daemonLogf("Client %s denied %s %s", client.Name, r.Method, r.URL.Path)
+			writeServeError(w, http.StatusForbidden, fmt.Sprintf("Client %s may not %s", client.Name, action))
+			return
+		}
+		next(w, r, client)
+	}
+}
+
+// handleCA serves the CA certificate and its chain
+func (s *caServer) handleCA(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	for _, cert := range s.ca.Certificates {
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+}
+
+// crlValidity is how long a CRL of serve is valid, and crlRefresh how long before its next update a new one is signed
+const (
+	crlValidity = 24 * time.Hour
+	crlRefresh  = 12 * time.Hour
+)
+
+// handleCRL serves a CRL of the revoked certificates that have not expired. The endpoint needs no token, so the CRL
+// is signed only after a revocation or when the last one nears its next update, not for every request.
+func (s *caServer) handleCRL(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.crl == nil || now.After(s.crlNextUpdate.Add(-crlRefresh)) {
+		template := &x509.RevocationList{ThisUpdate: now, NextUpdate: now.Add(crlValidity)}
+		for _, record := range s.records {
+			if record.RevokedAt.IsZero() || now.After(record.NotAfter) {
+				continue
+			}
+			serial, _ := new(big.Int).SetString(record.Serial, 16)
+			template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+				SerialNumber:   serial,
+				RevocationTime: record.RevokedAt,
+				ReasonCode:     crlReasonCode(record.RevokedReason),
+			})
+		}
+		s.crlNumber++
+		template.Number = big.NewInt(s.crlNumber)
+		der, err := x509.CreateRevocationList(rand.Reader, template, s.ca.Certificates[0], s.ca.Key)
+		if err != nil {
+			writeServeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create CRL: %v", err))
+			return
+		}
+		if err := s.save(); err != nil {
+			daemonLogf("%v", err)
+		}
+		s.crl, s.crlNextUpdate = der, template.NextUpdate
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(s.crl)
+}
+
+// handleIssue signs the CSR of a request for the names it asks for
+func (s *caServer) handleIssue(w http.ResponseWriter, r *http.Request, client *serveClient) {
+	var req struct {
+		CSR  string `json:"csr"`
+		Days int    `json:"days"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeServeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	block, _ := pem.Decode([]byte(req.CSR))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+		writeServeError(w, http.StatusBadRequest, "csr must be a PEM encoded CERTIFICATE REQUEST")
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		writeServeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid CSR: %v", err))
+		return
+	}
+
+	days := req.Days
+	if days == 0 {
+		days = s.days
+	}
+	maxDays := s.maxDays
+	if client.MaxDays > 0 && client.MaxDays < maxDays {
+		maxDays = client.MaxDays
+	}
+	if days < 1 || days > maxDays {
+		writeServeError(w, http.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d", maxDays))
+		return
+	}
+
+	// The common name comes first, so it stays the subject, followed by the other names of the CSR
+	var names []string
+	if cn := strings.ToLower(csr.Subject.CommonName); cn != "" {
+		names = append(names, cn)
+	}
+	for _, name := range csr.DNSNames {
+		if name = strings.ToLower(name); !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if !contains(names, ip.String()) {
+			names = append(names, ip.String())
+		}
+	}
+	if len(names) == 0 {
+		writeServeError(w, http.StatusBadRequest, "The CSR names no common name, DNS name, or IP address")
+		return
+	}
+	for _, name := range names {
+		if net.ParseIP(name) == nil && !acmeDNSName.MatchString(name) {
+			writeServeError(w, http.StatusBadRequest, fmt.Sprintf("%q is not a DNS name or IP address", name))
+			return
+		}
+		if !clientMayRequest(client, name) {
+			daemonLogf("Client %s denied a certificate for %s", client.Name, name)
+			writeServeError(w, http.StatusForbidden, fmt.Sprintf("Client %s may not request %s", client.Name, name))
+			return
+		}
+	}
+
+	chain, err := s.ca.IssueServer(csr.PublicKey, names, time.Duration(days)*24*time.Hour, csrMustStaple(csr)...)
+	if err != nil {
+		writeServeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cert, _ := x509.ParseCertificate(chain[0])
+	record := &issuedRecord{
+		Serial:      cert.SerialNumber.Text(16),
+		Subject:     certforge.FormatName(cert.Subject),
+		Names:       names,
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		Requester:   client.Name,
+		IssuedAt:    time.Now().UTC(),
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[0]})),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.Serial] = record
+	if err := s.save(); err != nil {
+		// A certificate missing from the inventory could never be revoked, so it is not handed out
+		delete(s.records, record.Serial)
+		writeServeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	daemonLogf("Client %s issued %s for %s, valid until %s", client.Name, record.Serial, strings.Join(names, ", "), cert.NotAfter.Format("2006-01-02"))
+	entry := auditLogCertificate(auditLogCertSigned, cert)
+	entry.Actor, entry.Inputs = client.Name, []auditLogInput{auditLogData("CSR", block.Bytes)}
+	auditLog(entry)
+	event := newCertEvent("certificate_issued", "serve", "", cert)
+	event.Client = client.Name
+	s.emitter.emit(s.events, event)
+	writeServeJSON(w, http.StatusCreated, s.recordJSON(record, chain))
+}
+
+// handleList returns the inventory, optionally filtered by ?status=valid|revoked|expired and ?name=
+func (s *caServer) handleList(w http.ResponseWriter, r *http.Request, client *serveClient) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "valid" && status != "revoked" && status != "expired" {
+		writeServeError(w, http.StatusBadRequest, "status must be valid, revoked, or expired")
+		return
+	}
+	name := strings.ToLower(r.URL.Query().Get("name"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []map[string]interface{}{}
+	var records []*issuedRecord
+	for _, record := range s.records {
+		if (status == "" || recordStatus(record) == status) && (name == "" || contains(record.Names, name)) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].IssuedAt.Before(records[j].IssuedAt) })
+	for _, record := range records {
+		entry := s.recordJSON(record, nil)
+		delete(entry, "certificate")
+		list = append(list, entry)
+	}
+	writeServeJSON(w, http.StatusOK, map[string]interface{}{"certificates": list})
+}
+
+// handleGet returns one certificate of the inventory, as JSON or, for Accept: application/x-pem-file, as PEM
+func (s *caServer) handleGet(w http.ResponseWriter, r *http.Request, client *serveClient) {
+	s.mu.Lock()
+	record, ok := s.records[strings.ToLower(r.PathValue("serial"))]
+	s.mu.Unlock()
+	if !ok {
+		writeServeError(w, http.StatusNotFound, "No such certificate")
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/x-pem-file") {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		io.WriteString(w, record.Certificate)
+		return
+	}
+	writeServeJSON(w, http.StatusOK, s.recordJSON(record, nil))
+}
+
+// handleRevoke revokes a certificate with an optional CRL reason
+func (s *caServer) handleRevoke(w http.ResponseWriter, r *http.Request, client *serveClient) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil {
+			writeServeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "unspecified"
+	}
+	if crlReasonCode(req.Reason) < 0 || req.Reason == "removeFromCRL" {
+		writeServeError(w, http.StatusBadRequest, fmt.Sprintf("Unknown reason %q, like keyCompromise or superseded", req.Reason))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[strings.ToLower(r.PathValue("serial"))]
+	if !ok {
+		writeServeError(w, http.StatusNotFound, "No such certificate")
+		return
+	}
+	for _, name := range record.Names {
+		if !clientMayRequest(client, name) {
+			writeServeError(w, http.StatusForbidden, fmt.Sprintf("Client %s may not revoke certificates for %s", client.Name, name))
+			return
+		}
+	}
+	if !record.RevokedAt.IsZero() {
+		writeServeError(w, http.StatusConflict, "The certificate is already revoked")
+		return
+	}
+	record.RevokedAt, record.RevokedBy, record.RevokedReason = time.Now().UTC(), client.Name, req.Reason
+	if err := s.save(); err != nil {
+		record.RevokedAt, record.RevokedBy, record.RevokedReason = time.Time{}, "", ""
+		writeServeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// The next request for the CRL signs one listing the certificate
+	s.crl = nil
+	daemonLogf("Client %s revoked %s for %s (%s)", client.Name, record.Serial, strings.Join(record.Names, ", "), req.Reason)
+	if cert, err := record.parse(); err == nil {
+		entry := auditLogCertificate(auditLogCertRevoked, cert)
+		entry.Actor, entry.Reason = client.Name, req.Reason
+		auditLog(entry)
+		event := newCertEvent("certificate_revoked", "serve", "", cert)
+		event.Client, event.Reason = client.Name, req.Reason
+		s.emitter.emit(s.events, event)
+	}
+	writeServeJSON(w, http.StatusOK, s.recordJSON(record, nil))
+}
+
+// watchExpiry reports the valid certificates of the inventory as they cross the expiry thresholds, checking hourly
+func (s *caServer) watchExpiry() {
+	for {
+		s.mu.Lock()
+		var certs []*x509.Certificate
+		for _, record := range s.records {
+			// Certificates expired long ago are not reported again after a restart
+			if !record.RevokedAt.IsZero() || time.Since(record.NotAfter) > 24*time.Hour {
+				continue
+			}
+			if cert, err := record.parse(); err == nil {
+				certs = append(certs, cert)
+			}
+		}
+		s.mu.Unlock()
+
+		now := time.Now()
+		for _, cert := range certs {
+			s.emitter.checkExpiry(s.events, "serve", "", "", cert, now)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// parse returns the certificate of a record
+func (record *issuedRecord) parse() (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(record.Certificate))
+	if block == nil {
+		return nil, fmt.Errorf("No certificate in the record of %s", record.Serial)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// recordJSON is the API view of a record, with the chain when it was just issued
+func (s *caServer) recordJSON(record *issuedRecord, chain [][]byte) map[string]interface{} {
+	value := map[string]interface{}{
+		"serial":      record.Serial,
+		"subject":     record.Subject,
+		"names":       record.Names,
+		"not_before":  record.NotBefore,
+		"not_after":   record.NotAfter,
+		"requester":   record.Requester,
+		"issued_at":   record.IssuedAt,
+		"status":      recordStatus(record),
+		"certificate": record.Certificate,
+	}
+	if !record.RevokedAt.IsZero() {
+		value["revoked_at"] = record.RevokedAt
+		value["revoked_by"] = record.RevokedBy
+		value["revoked_reason"] = record.RevokedReason
+	}
+	if chain != nil {
+		var buf bytes.Buffer
+		for _, der := range chain[1:] {
+			pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		}
+		value["chain"] = buf.String()
+	}
+	return value
+}
+
+// recordStatus is valid, revoked, or expired
+func recordStatus(record *issuedRecord) string {
+	switch {
+	case !record.RevokedAt.IsZero():
+		return "revoked"
+	case time.Now().After(record.NotAfter):
+		return "expired"
+	default:
+		return "valid"
+	}
+}
+
+// clientMayRequest checks a name against the domains of a client; *.example.com covers every name below example.com,
+// and * every name. A client without domains may request no name.
+func clientMayRequest(client *serveClient, name string) bool {
+	for _, domain := range client.Domains {
+		if name == domain {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(domain, "*"); ok && strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// crlReasonCode returns the code of a CRL reason name, or -1
+func crlReasonCode(name string) int {
+	for code, reason := range crlReasonNames {
+		if reason == name {
+			return code
+		}
+	}
+	return -1
+}
+
+// writeServeJSON sends a JSON response
+func writeServeJSON(w http.ResponseWriter, status int, value interface{}) {
+	body, _ := json.MarshalIndent(value, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// writeServeError sends a JSON error
+func writeServeError(w http.ResponseWriter, status int, message string) {
+	writeServeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,162 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// newTestCA returns a new local CA with an ECDSA P-256 key
+func newTestCA(t *testing.T) *certforge.CA {
+	t.Helper()
+	r, err := certforge.NewRequest(certforge.WithCN("Test CA"), certforge.WithKeyType(certforge.ECDSA, 256),
+		certforge.WithBasicConstraints(true, true), certforge.WithValidity(30*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifacts, err := r.SelfSign()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(artifacts.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := certforge.NewCA([]*x509.Certificate{cert}, artifacts.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// newTestCAServer returns a serve CA with an empty inventory in a temporary directory
+func newTestCAServer(t *testing.T) *caServer {
+	t.Helper()
+	t.Setenv(auditLogEnv, "off")
+	s := &caServer{
+		ca:        newTestCA(t),
+		days:      30,
+		maxDays:   30,
+		inventory: filepath.Join(t.TempDir(), "inventory.json"),
+		emitter:   newEventEmitter(false),
+	}
+	if err := s.load(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// getCRL fetches and parses the CRL of the server
+func getCRL(t *testing.T, handler http.Handler) *x509.RevocationList {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/crl", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /v1/crl: %d %s", w.Code, w.Body)
+	}
+	crl, err := x509.ParseRevocationList(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crl
+}
+
+func TestServeCRLCached(t *testing.T) {
+	s := newTestCAServer(t)
+	client := &serveClient{Name: "admin", Allow: []string{serveActionRevoke}, Domains: []string{"*"}}
+	handler := s.handler()
+
+	first := getCRL(t, handler)
+	info, err := os.Stat(s.inventory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if crl := getCRL(t, handler); crl.Number.Cmp(first.Number) != 0 {
+			t.Fatalf("request %d signed CRL %s, want the cached CRL %s", i+2, crl.Number, first.Number)
+		}
+	}
+	if again, err := os.Stat(s.inventory); err != nil || !again.ModTime().Equal(info.ModTime()) {
+		t.Error("serving the cached CRL wrote the inventory")
+	}
+
+	// A revocation signs a new CRL listing the certificate
+	s.records["1f"] = &issuedRecord{Serial: "1f", Names: []string{"www.example.com"}, NotAfter: time.Now().Add(time.Hour)}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/v1/certificates/1f/revoke", strings.NewReader(`{"reason": "keyCompromise"}`))
+	r.SetPathValue("serial", "1f")
+	s.handleRevoke(w, r, client)
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body)
+	}
+	revoked := getCRL(t, handler)
+	if revoked.Number.Cmp(first.Number) <= 0 || len(revoked.RevokedCertificateEntries) != 1 {
+		t.Errorf("got CRL %s with %d entries after a revocation", revoked.Number, len(revoked.RevokedCertificateEntries))
+	}
+
+	// A CRL nearing its next update is replaced
+	s.crlNextUpdate = time.Now().Add(crlRefresh - time.Minute)
+	if crl := getCRL(t, handler); crl.Number.Cmp(revoked.Number) <= 0 {
+		t.Errorf("got CRL %s, want one after %s", crl.Number, revoked.Number)
+	}
+}
+
+func TestClientMayRequest(t *testing.T) {
+	tests := []struct {
+		domains []string
+		name    string
+		want    bool
+	}{
+		{nil, "www.example.com", false},
+		{[]string{"*"}, "www.example.com", true},
+		{[]string{"www.example.com"}, "www.example.com", true},
+		{[]string{"www.example.com"}, "api.example.com", false},
+		{[]string{"*.example.com"}, "api.example.com", true},
+		{[]string{"*.example.com"}, "a.b.example.com", true},
+		{[]string{"*.example.com"}, "example.com", false},
+		{[]string{"*.example.com"}, "badexample.com", false},
+	}
+	for _, tt := range tests {
+		if got := clientMayRequest(&serveClient{Domains: tt.domains}, tt.name); got != tt.want {
+			t.Errorf("domains %v, name %s: got %t, want %t", tt.domains, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadServeClientsDomains(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   string
+		domains string
+		wantErr bool
+	}{
+		{"issue without domains", "[issue]", "", true},
+		{"revoke without domains", "[read, revoke]", "", true},
+		{"read without domains", "[read]", "", false},
+		{"issue for every name", "[issue]", `domains: ["*"]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "clients.yaml")
+			config := "clients:\n  - name: bot\n    token: pass:0123456789abcdef\n    allow: " + tt.allow + "\n    " + tt.domains + "\n"
+			if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadServeClients(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
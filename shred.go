@@ -0,0 +1,146 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// defaultShredPasses is how many times shred and --shred-old overwrite a file
+const defaultShredPasses = 3
+
+// shredCaveat is printed after shredding, as overwriting a file is only as good as the storage beneath it
+const shredCaveat = "Note: SSDs, copy-on-write filesystems (btrfs, ZFS, APFS), journals, snapshots, and backups may keep copies that overwriting cannot reach; use full-disk encryption to be sure old keys are unrecoverable."
+
+// runShred implements the shred command, which overwrites and removes private key files
+func runShred(args []string) error {
+	fs := flag.NewFlagSet("shred", flag.ExitOnError)
+	passesFlag := fs.Int("passes", defaultShredPasses, "Number of times to overwrite each file with random data")
+	forceFlag := fs.Bool("force", false, "Also shred files that do not contain a PEM private key")
+	files := parseArgs(fs, args)
+
+	if len(files) == 0 {
+		return fmt.Errorf("shred requires at least one file")
+	}
+	if *passesFlag < 1 {
+		return fmt.Errorf("--passes must be at least 1")
+	}
+	// Every file is checked before any is touched, so a typo does not leave the list half shredded
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("Error reading file: %v", err)
+		}
+		isKey := bytes.Contains(data, []byte("PRIVATE KEY-----"))
+		certforge.Zeroize(data)
+		if !isKey && !*forceFlag {
+			return fmt.Errorf("%s does not contain a PEM private key; use --force to shred it anyway", file)
+		}
+	}
+
+	for _, file := range files {
+		if err := shredFile(file, *passesFlag); err != nil {
+			return err
+		}
+		fmt.Printf("Shredded %s\n", file)
+	}
+	fmt.Println(shredCaveat)
+	return nil
+}
+
+// shredFile overwrites a file with random data, syncing each pass to disk, then truncates, renames, and removes it.
+// Symlinks are refused rather than followed, so only the named file is destroyed.
+func shredFile(path string, passes int) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("Failed to shred %s: %v", path, err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, _ := filepath.EvalSymlinks(path)
+		return fmt.Errorf("Failed to shred %s: it is a symlink to %s; shred that file instead", path, target)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("Failed to shred %s: not a regular file", path)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("Failed to shred %s: %v", path, err)
+	}
+	for i := 0; i < passes; i++ {
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			break
+		}
+		if _, err = io.CopyN(f, rand.Reader, info.Size()); err != nil {
+			break
+		}
+		if err = f.Sync(); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		if err = f.Truncate(0); err == nil {
+			err = f.Sync()
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to shred %s: %v", path, err)
+	}
+
+	// A random name keeps the old one out of the directory entry that is left behind
+	random := make([]byte, 8)
+	rand.Read(random)
+	renamed := filepath.Join(filepath.Dir(path), "."+hex.EncodeToString(random))
+	if os.Rename(path, renamed) == nil {
+		path = renamed
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("Failed to remove %s: %v", path, err)
+	}
+	return nil
+}
+
+// setAsideKey prepares the key file a renewal is about to replace for shredding, returning the file to shred once the
+// new files are written, or "" when there is no key yet. A flat key is moved aside, since writing the new key over it
+// would free its blocks without overwriting them; a certbot key stays in its archive version.
+func setAsideKey(layout, path string) (string, error) {
+	if layout == layoutCertbot {
+		target, err := filepath.EvalSymlinks(path)
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("Failed to resolve %s: %v", path, err)
+		}
+		return target, nil
+	}
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return "", nil
+	}
+	aside := path + ".old"
+	if err := os.Rename(path, aside); err != nil {
+		return "", fmt.Errorf("Failed to set aside %s: %v", path, err)
+	}
+	return aside, nil
+}
+
+// restoreKey puts a key set aside by setAsideKey back when its replacement could not be written
+func restoreKey(layout, path, aside string) {
+	if layout != layoutCertbot && aside != "" {
+		os.Rename(aside, path)
+	}
+}
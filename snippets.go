@@ -0,0 +1,123 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// snippetServers lists the web servers configuration snippets can be generated for, in output order
+var snippetServers = []string{"nginx", "apache", "haproxy", "caddy"}
+
+// snippetPaths are the files a configuration snippet refers to
+type snippetPaths struct {
+	Cert     string
+	Key      string
+	Combined string
+}
+
+// parseSnippetServers validates a comma separated list of servers, where "all" selects every one
+func parseSnippetServers(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var servers []string
+	for _, name := range strings.Split(strings.ToLower(list), ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			return snippetServers, nil
+		}
+		if !contains(snippetServers, name) {
+			return nil, fmt.Errorf("Unknown server %q for --snippets (use %s, or all)", name, strings.Join(snippetServers, ", "))
+		}
+		if !contains(servers, name) {
+			servers = append(servers, name)
+		}
+	}
+	return servers, nil
+}
+
+// writeCombinedPEM writes the certificate followed by its key into one file, the layout HAProxy expects
+func writeCombinedPEM(path, certPath, keyPath string) error {
+	cert, err := os.ReadFile(certPath)
+	if err != nil {
+		return fmt.Errorf("Error reading file: %v", err)
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("Error reading file: %v", err)
+	}
+	if err := os.WriteFile(path, append(cert, key...), 0600); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// printServerSnippets prints ready-to-paste TLS configuration for each server
+func printServerSnippets(servers []string, names []string, paths snippetPaths) {
+	// Absolute paths keep the snippets valid wherever the configuration lives
+	abs := func(path string) string {
+		if p, err := filepath.Abs(path); err == nil {
+			return p
+		}
+		return path
+	}
+	cert, key, combined := abs(paths.Cert), abs(paths.Key), abs(paths.Combined)
+
+	for _, server := range servers {
+		fmt.Printf("\n=== %s Configuration ===\n\n", snippetTitle(server))
+		switch server {
+		case "nginx":
+			fmt.Println("server {")
+			fmt.Println("    listen 443 ssl;")
+			fmt.Println("    listen [::]:443 ssl;")
+			fmt.Printf("    server_name %s;\n", strings.Join(names, " "))
+			fmt.Println()
+			fmt.Printf("    ssl_certificate     %s;\n", cert)
+			fmt.Printf("    ssl_certificate_key %s;\n", key)
+			fmt.Println("    ssl_protocols       TLSv1.2 TLSv1.3;")
+			fmt.Println("}")
+
+		case "apache":
+			fmt.Println("<VirtualHost *:443>")
+			fmt.Printf("    ServerName %s\n", names[0])
+			if len(names) > 1 {
+				fmt.Printf("    ServerAlias %s\n", strings.Join(names[1:], " "))
+			}
+			fmt.Println()
+			fmt.Println("    SSLEngine on")
+			fmt.Printf("    SSLCertificateFile    %s\n", cert)
+			fmt.Printf("    SSLCertificateKeyFile %s\n", key)
+			fmt.Println("    SSLProtocol           -all +TLSv1.2 +TLSv1.3")
+			fmt.Println("</VirtualHost>")
+
+		case "haproxy":
+			// HAProxy reads the certificate and key from a single file
+			fmt.Println("frontend https")
+			fmt.Printf("    bind :443 ssl crt %s ssl-min-ver TLSv1.2\n", combined)
+			fmt.Println("    mode http")
+			fmt.Println("    default_backend app")
+
+		case "caddy":
+			fmt.Printf("%s {\n", strings.Join(names, ", "))
+			fmt.Printf("    tls %s %s\n", cert, key)
+			fmt.Println("}")
+		}
+	}
+}
+
+// snippetTitle returns the display name of a server
+func snippetTitle(server string) string {
+	switch server {
+	case "nginx":
+		return "nginx"
+	case "haproxy":
+		return "HAProxy"
+	}
+	return strings.ToUpper(server[:1]) + server[1:]
+}
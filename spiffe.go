@@ -0,0 +1,309 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// spiffeCommands maps the subcommands of the spiffe command to their implementations
+var spiffeCommands = map[string]func(args []string) error{
+	"ca":   runSPIFFECA,
+	"svid": runSPIFFESVID,
+}
+
+// runSPIFFE implements the spiffe command, which dispatches on the subcommand
+func runSPIFFE(args []string) error {
+	var names []string
+	for name := range spiffeCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return fmt.Errorf("spiffe requires a subcommand: %s", strings.Join(names, ", "))
+	}
+	command, ok := spiffeCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown spiffe subcommand %q (use %s)", args[0], strings.Join(names, ", "))
+	}
+	return command(args[1:])
+}
+
+// runSPIFFECA implements spiffe ca, which creates the root CA of a trust domain
+func runSPIFFECA(args []string) error {
+	fs := flag.NewFlagSet("spiffe ca", flag.ExitOnError)
+	trustDomainFlag := fs.String("trust-domain", "", "Trust domain, like example.org")
+	daysFlag := fs.Int("days", 365, "Validity period of the CA in days")
+	outFlag := fs.String("out", "ca", "Output file prefix for <out>.crt and <out>.key")
+	parseArgs(fs, args)
+
+	if *trustDomainFlag == "" {
+		return fmt.Errorf("spiffe ca requires --trust-domain")
+	}
+	id, err := parseSPIFFEID("spiffe://" + strings.TrimPrefix(*trustDomainFlag, "spiffe://"))
+	if err != nil {
+		return err
+	}
+	if id.Path != "" {
+		return fmt.Errorf("Invalid trust domain %q: must not have a path", *trustDomainFlag)
+	}
+	if *daysFlag <= 0 {
+		return fmt.Errorf("Invalid --days %d", *daysFlag)
+	}
+	if err := certforge.CheckValidity(time.Duration(*daysFlag) * 24 * time.Hour); err != nil {
+		return err
+	}
+	if err := certforge.CheckKeyType(certforge.ECDSA, 256); err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("Failed to generate private key: %v", err)
+	}
+	serial, err := certforge.NewSerial()
+	if err != nil {
+		return err
+	}
+
+	// A signing certificate may carry the SPIFFE ID of its trust domain (X509-SVID section 4.1)
+	notBefore := time.Now().Add(-time.Minute)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"SPIFFE"}, CommonName: id.Host},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(time.Duration(*daysFlag) * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		URIs:                  []*url.URL{id},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return fmt.Errorf("Failed to create certificate: %v", err)
+	}
+
+	if err := writeSPIFFEFiles(*outFlag, [][]byte{der}, key); err != nil {
+		return err
+	}
+	auditLog(auditLogKey(key.Public(), *outFlag+".key"))
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		entry := auditLogCertificate(auditLogCertSigned, cert)
+		entry.Outputs = []string{*outFlag + ".crt"}
+		auditLog(entry)
+	}
+	fmt.Printf("Trust domain CA for %s saved to: %s.crt\n", id, *outFlag)
+	fmt.Printf("Private key saved to: %s.key\n", *outFlag)
+	fmt.Printf("CA is valid for %d days (until %s)\n", *daysFlag, template.NotAfter.Format("2006-01-02"))
+	fmt.Printf("\nDistribute %s.crt to workloads as the trust bundle of %s.\n", *outFlag, id.Host)
+	return nil
+}
+
+// runSPIFFESVID implements spiffe svid, which issues an X509-SVID for a workload
+func runSPIFFESVID(args []string) error {
+	fs := flag.NewFlagSet("spiffe svid", flag.ExitOnError)
+	idFlag := fs.String("id", "", "SPIFFE ID of the workload, like spiffe://example.org/ns/default/sa/web")
+	caFlag := fs.String("ca", "", "CA certificate of the trust domain, followed by any intermediates")
+	caKeyFlag := fs.String("ca-key", "", "Private key of the CA certificate, or a comma separated list of its shares")
+	ttlFlag := fs.Duration("ttl", time.Hour, "Lifetime of the SVID, like 15m or 1h")
+	dnsFlag := fs.String("dns", "", "Comma separated DNS names to add for clients that do not check SPIFFE IDs")
+	outFlag := fs.String("out", "svid", "Output file prefix for <out>.crt and <out>.key")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted CA key (pass:, env:, file:, or stdin)")
+	parseArgs(fs, args)
+
+	if *idFlag == "" || *caFlag == "" || *caKeyFlag == "" {
+		return fmt.Errorf("spiffe svid requires --id, --ca, and --ca-key")
+	}
+	id, err := parseSPIFFEID(*idFlag)
+	if err != nil {
+		return err
+	}
+	// An SVID identifies a workload, which the trust domain's own ID does not
+	if id.Path == "" {
+		return fmt.Errorf("Invalid SPIFFE ID %q: a workload ID needs a path", *idFlag)
+	}
+	if *ttlFlag <= 0 {
+		return fmt.Errorf("Invalid --ttl %s", *ttlFlag)
+	}
+
+	caCerts, err := readCertificates(*caFlag)
+	if err != nil {
+		return err
+	}
+	caCert := caCerts[0]
+	if !caCert.IsCA || caCert.KeyUsage != 0 && caCert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return fmt.Errorf("%s is not a CA certificate that may sign certificates", *caFlag)
+	}
+	for _, uri := range caCert.URIs {
+		if uri.Scheme == "spiffe" && uri.Host != id.Host {
+			return fmt.Errorf("CA belongs to trust domain %s, not %s", uri.Host, id.Host)
+		}
+	}
+
+	var password string
+	if *passinFlag != "" {
+		if password, err = readPassphrase(*passinFlag); err != nil {
+			return err
+		}
+	}
+	caKey, err := readCAKey(*caKeyFlag, password)
+	if err != nil {
+		return err
+	}
+	defer certforge.ZeroizeKey(caKey)
+	signer, ok := caKey.(crypto.Signer)
+	if !ok || !certforge.SamePublicKey(caCert.PublicKey, signer.Public()) {
+		return fmt.Errorf("%s is not the private key of %s", *caKeyFlag, *caFlag)
+	}
+	if err := certforge.CheckKey(signer); err != nil {
+		return fmt.Errorf("%s: %w", *caKeyFlag, err)
+	}
+
+	notBefore := time.Now().Add(-time.Minute)
+	notAfter := notBefore.Add(*ttlFlag)
+	if notAfter.After(caCert.NotAfter) {
+		return fmt.Errorf("SVID would outlive its CA, which expires %s", caCert.NotAfter.Format(time.RFC3339))
+	}
+	if err := certforge.CheckValidity(*ttlFlag); err != nil {
+		return err
+	}
+	if err := certforge.CheckKeyType(certforge.ECDSA, 256); err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("Failed to generate private key: %v", err)
+	}
+	serial, err := certforge.NewSerial()
+	if err != nil {
+		return err
+	}
+
+	// X509-SVID section 4: exactly one URI SAN, not a CA, and digitalSignature.
+	// The subject is left empty, which makes the SAN extension critical.
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		URIs:                  []*url.URL{id},
+	}
+	for _, name := range strings.Split(*dnsFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), signer)
+	if err != nil {
+		return fmt.Errorf("Failed to create certificate: %v", err)
+	}
+
+	// The SVID is followed by the intermediates, but not the root, which is the trust bundle
+	chain := [][]byte{der}
+	for _, cert := range caCerts {
+		if !certforge.IsSelfSigned(cert) {
+			chain = append(chain, cert.Raw)
+		}
+	}
+	if err := writeSPIFFEFiles(*outFlag, chain, key); err != nil {
+		return err
+	}
+	auditLog(auditLogKey(key.Public(), *outFlag+".key"))
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		entry := auditLogCertificate(auditLogCertSigned, cert)
+		entry.Inputs, entry.Outputs = auditLogInputs(*caFlag), []string{*outFlag + ".crt"}
+		auditLog(entry)
+	}
+	fmt.Printf("SVID for %s saved to: %s.crt\n", id, *outFlag)
+	fmt.Printf("Private key saved to: %s.key\n", *outFlag)
+	fmt.Printf("SVID is valid for %s (until %s)\n", *ttlFlag, notAfter.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// parseSPIFFEID validates a SPIFFE ID against the SPIFFE ID specification
+func parseSPIFFEID(value string) (*url.URL, error) {
+	id, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SPIFFE ID %q: %v", value, err)
+	}
+	invalid := func(reason string) error {
+		return fmt.Errorf("Invalid SPIFFE ID %q: %s", value, reason)
+	}
+
+	switch {
+	case id.Scheme != "spiffe":
+		return nil, invalid("the scheme must be spiffe://")
+	case id.Host == "":
+		return nil, invalid("missing trust domain")
+	case id.User != nil || id.Port() != "":
+		return nil, invalid("the trust domain must not have a user or port")
+	case id.RawQuery != "" || id.ForceQuery || id.Fragment != "" || strings.Contains(value, "#"):
+		return nil, invalid("query and fragment are not allowed")
+	}
+	for _, r := range id.Host {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return nil, invalid("the trust domain may only contain lowercase letters, digits, '.', '-', and '_'")
+		}
+	}
+
+	if id.Path == "/" {
+		return nil, invalid("the path must not end with '/'")
+	}
+	if id.Path != "" {
+		for _, segment := range strings.Split(id.Path[1:], "/") {
+			switch segment {
+			case "":
+				return nil, invalid("the path must not have empty segments or end with '/'")
+			case ".", "..":
+				return nil, invalid("the path must not have '.' or '..' segments")
+			}
+			for _, r := range segment {
+				if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+					return nil, invalid("the path may only contain letters, digits, '.', '-', and '_'")
+				}
+			}
+		}
+	}
+	return id, nil
+}
+
+// writeSPIFFEFiles writes certificates to <prefix>.crt and the PKCS#8 private key to <prefix>.key
+func writeSPIFFEFiles(prefix string, certs [][]byte, key crypto.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("Failed to encode private key: %v", err)
+	}
+	if err := os.WriteFile(prefix+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("Failed to write %s.key: %v", prefix, err)
+	}
+
+	var out []byte
+	for _, der := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	if err := os.WriteFile(prefix+".crt", out, 0644); err != nil {
+		return fmt.Errorf("Failed to write %s.crt: %v", prefix, err)
+	}
+	return nil
+}
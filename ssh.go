@@ -0,0 +1,240 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// looksLikeSSHPublicKey reports whether data starts like an authorized_keys style line
+func looksLikeSSHPublicKey(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	for _, prefix := range []string{"ssh-", "ecdsa-sha2-", "sk-ssh-", "sk-ecdsa-"} {
+		if bytes.HasPrefix(trimmed, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeSSHPublicKeys decodes every OpenSSH public key or certificate in authorized_keys format
+func decodeSSHPublicKeys(data []byte, opts decodeOptions) error {
+	count := 0
+	for rest := data; len(bytes.TrimSpace(rest)) > 0; {
+		key, comment, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			if count == 0 {
+				return fmt.Errorf("Failed to parse SSH public key: %v", err)
+			}
+			break
+		}
+		rest = next
+
+		if count > 0 {
+			fmt.Println()
+		}
+		count++
+
+		if cert, ok := key.(*ssh.Certificate); ok {
+			printSSHCertificateInfo(cert, comment, opts)
+		} else {
+			printSSHPublicKeyInfo(key, comment)
+		}
+	}
+	return nil
+}
+
+// decodeSSHPrivateKey decodes an OpenSSH format private key, using the passphrase if it is encrypted
+func decodeSSHPrivateKey(block *pem.Block, opts decodeOptions) error {
+	data := pem.EncodeToMemory(block)
+
+	key, err := ssh.ParseRawPrivateKey(data)
+	encrypted := false
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		encrypted = true
+		if opts.Password == "" {
+			fmt.Println("=== OpenSSH Private Key Information ===")
+			fmt.Println()
+			fmt.Println("Encrypted: yes (use --passin to decrypt)")
+			printSSHKeyDetails(missing.PublicKey)
+			return nil
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, []byte(opts.Password))
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to parse OpenSSH private key: %v", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return fmt.Errorf("Unsupported OpenSSH private key: %v", err)
+	}
+
+	fmt.Println("=== OpenSSH Private Key Information ===")
+	fmt.Println()
+	if encrypted {
+		fmt.Println("Encrypted: yes")
+	} else {
+		fmt.Println("Encrypted: no")
+	}
+	printSSHKeyDetails(signer.PublicKey())
+	return nil
+}
+
+// printSSHPublicKeyInfo displays information about an OpenSSH public key
+func printSSHPublicKeyInfo(key ssh.PublicKey, comment string) {
+	fmt.Println("=== OpenSSH Public Key Information ===")
+	fmt.Println()
+	printSSHKeyDetails(key)
+	if comment != "" {
+		fmt.Printf("Comment: %s\n", comment)
+	}
+}
+
+// printSSHKeyDetails displays the type, size, and fingerprints of an SSH public key
+func printSSHKeyDetails(key ssh.PublicKey) {
+	fmt.Printf("Key Type: %s\n", key.Type())
+	if size := sshKeySize(key); size != "" {
+		fmt.Printf("Key Size: %s\n", size)
+	}
+	fmt.Printf("Fingerprint (SHA-256): %s\n", ssh.FingerprintSHA256(key))
+	fmt.Printf("Fingerprint (MD5): %s\n", ssh.FingerprintLegacyMD5(key))
+}
+
+// sshKeySize describes the size or curve of an SSH public key
+func sshKeySize(key ssh.PublicKey) string {
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return ""
+	}
+	switch k := cryptoKey.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("%d bits", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("%d bits (%s)", k.Curve.Params().BitSize, k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "256 bits"
+	}
+	return ""
+}
+
+// printSSHCertificateInfo displays information about an OpenSSH certificate
+func printSSHCertificateInfo(cert *ssh.Certificate, comment string, opts decodeOptions) {
+	fmt.Println("=== OpenSSH Certificate Information ===")
+	fmt.Println()
+
+	certType := "user"
+	if cert.CertType == ssh.HostCert {
+		certType = "host"
+	}
+	fmt.Printf("Type: %s certificate (%s)\n", certType, cert.Type())
+	fmt.Printf("Key ID: %q\n", cert.KeyId)
+	fmt.Printf("Serial: %d\n", cert.Serial)
+	if comment != "" {
+		fmt.Printf("Comment: %s\n", comment)
+	}
+
+	fmt.Println("\nPublic Key:")
+	fmt.Printf("  Type: %s\n", cert.Key.Type())
+	fmt.Printf("  Fingerprint (SHA-256): %s\n", ssh.FingerprintSHA256(cert.Key))
+
+	fmt.Println("\nSigning CA:")
+	fmt.Printf("  Type: %s\n", cert.SignatureKey.Type())
+	fmt.Printf("  Fingerprint (SHA-256): %s\n", ssh.FingerprintSHA256(cert.SignatureKey))
+	if err := verifySSHCertificateSignature(cert); err != nil {
+		fmt.Printf("  Signature: INVALID (%v)\n", err)
+	} else {
+		fmt.Println("  Signature: valid")
+	}
+
+	fmt.Println("\nValidity:")
+	if cert.ValidAfter == 0 && cert.ValidBefore == ssh.CertTimeInfinity {
+		fmt.Println("  forever")
+	} else {
+		validAfter := time.Unix(int64(cert.ValidAfter), 0).UTC()
+		fmt.Printf("  Valid After: %s\n", validAfter.Format(time.RFC3339))
+		if cert.ValidBefore == ssh.CertTimeInfinity {
+			fmt.Println("  Valid Before: forever")
+		} else {
+			validBefore := time.Unix(int64(cert.ValidBefore), 0).UTC()
+			fmt.Printf("  Valid Before: %s\n", validBefore.Format(time.RFC3339))
+			now := time.Now()
+			switch {
+			case now.After(validBefore):
+				fmt.Printf("  Status: %s\n", colorize(fmt.Sprintf("EXPIRED %d days ago", -certforge.DaysUntil(validBefore, now)), expiryExpired))
+			case now.Before(validAfter):
+				fmt.Printf("  Status: %s\n", colorize("NOT YET VALID", expiryWarning))
+			default:
+				days := certforge.DaysUntil(validBefore, now)
+				level := expiryOK
+				if days < opts.WarnDays {
+					level = expiryWarning
+				}
+				fmt.Printf("  Status: %s\n", colorize(fmt.Sprintf("expires in %d days", days), level))
+			}
+		}
+	}
+
+	fmt.Println("\nPrincipals:")
+	if len(cert.ValidPrincipals) == 0 {
+		fmt.Println("  (none - valid for any principal)")
+	}
+	for _, principal := range cert.ValidPrincipals {
+		fmt.Printf("  %s\n", principal)
+	}
+
+	fmt.Println("\nCritical Options:")
+	printSSHOptions(cert.CriticalOptions)
+
+	fmt.Println("\nExtensions:")
+	printSSHOptions(cert.Extensions)
+}
+
+// printSSHOptions prints certificate options or extensions in sorted order
+func printSSHOptions(options map[string]string) {
+	if len(options) == 0 {
+		fmt.Println("  (none)")
+		return
+	}
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if options[name] == "" {
+			fmt.Printf("  %s\n", name)
+		} else {
+			fmt.Printf("  %s: %s\n", name, options[name])
+		}
+	}
+}
+
+// verifySSHCertificateSignature checks the CA signature over the certificate contents
+func verifySSHCertificateSignature(cert *ssh.Certificate) error {
+	if cert.Signature == nil {
+		return fmt.Errorf("certificate is not signed")
+	}
+	// The signed data is the marshaled certificate without its trailing signature field
+	marshaled := cert.Marshal()
+	sigLen := 4 + len(ssh.Marshal(cert.Signature))
+	if sigLen > len(marshaled) {
+		return fmt.Errorf("malformed certificate")
+	}
+	return cert.SignatureKey.Verify(marshaled[:len(marshaled)-sigLen], cert.Signature)
+}
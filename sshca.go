@@ -0,0 +1,474 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// sshCommands maps the subcommands of the ssh command to their implementations
+var sshCommands = map[string]func(args []string) error{
+	"sign":   runSSHSign,
+	"keygen": runSSHKeygen,
+}
+
+// sshUserExtensions are the extensions ssh-keygen grants user certificates by default
+var sshUserExtensions = []string{
+	"permit-X11-forwarding",
+	"permit-agent-forwarding",
+	"permit-port-forwarding",
+	"permit-pty",
+	"permit-user-rc",
+}
+
+// runSSH implements the ssh command, which dispatches on the subcommand
+func runSSH(args []string) error {
+	var names []string
+	for name := range sshCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return fmt.Errorf("ssh requires a subcommand: %s", strings.Join(names, ", "))
+	}
+	command, ok := sshCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown ssh subcommand %q (use %s)", args[0], strings.Join(names, ", "))
+	}
+	return command(args[1:])
+}
+
+// runSSHSign implements ssh sign, which issues an OpenSSH certificate for a public key
+func runSSHSign(args []string) error {
+	fs := flag.NewFlagSet("ssh sign", flag.ExitOnError)
+	caFlag := fs.String("ca", "", "CA private key used to sign")
+	keyFlag := fs.String("key", "", "Public key to certify")
+	outFlag := fs.String("out", "", "Certificate file to write (default: <key>-cert.pub)")
+	hostFlag := fs.Bool("host", false, "Issue a host certificate instead of a user certificate")
+	idFlag := fs.String("id", "", "Key ID logged by the server (default: the key's comment or file name)")
+	principalsFlag := fs.String("principals", "", "Comma separated user names, or host names with --host")
+	validityFlag := fs.String("validity", "52w", "How long the certificate is valid, like 12h, 30d, or 52w, or forever")
+	validFromFlag := fs.String("valid-from", "", "Start of validity as YYYY-MM-DD or RFC 3339 (default: now)")
+	serialFlag := fs.Uint64("serial", 0, "Serial number (default: random)")
+	forceCommandFlag := fs.String("force-command", "", "Critical option: command run instead of the user's")
+	sourceAddressFlag := fs.String("source-address", "", "Critical option: comma separated addresses or CIDR ranges allowed to use the certificate")
+	verifyRequiredFlag := fs.Bool("verify-required", false, "Critical option: require user verification on FIDO keys")
+	extensionsFlag := fs.String("extensions", "", "Comma separated extensions, or none (default: the permit-* set for user certificates)")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted CA key (pass:, env:, file:, or stdin)")
+	parseArgs(fs, args)
+
+	if *caFlag == "" || *keyFlag == "" {
+		return fmt.Errorf("ssh sign requires --ca and --key")
+	}
+
+	signer, err := readSSHSigner(*caFlag, *passinFlag)
+	if err != nil {
+		return err
+	}
+	if err := checkSSHKey(signer.PublicKey()); err != nil {
+		return fmt.Errorf("CA key %s: %w", *caFlag, err)
+	}
+
+	data, err := os.ReadFile(*keyFlag)
+	if err != nil {
+		return fmt.Errorf("Error reading file: %v", err)
+	}
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return fmt.Errorf("Failed to parse OpenSSH public key: %v", err)
+	}
+	if _, ok := pub.(*ssh.Certificate); ok {
+		return fmt.Errorf("%s is already a certificate; sign the plain public key", *keyFlag)
+	}
+	if err := checkSSHKey(pub); err != nil {
+		return fmt.Errorf("%s: %w", *keyFlag, err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:      pub,
+		Serial:   *serialFlag,
+		CertType: ssh.UserCert,
+		KeyId:    *idFlag,
+		Permissions: ssh.Permissions{
+			CriticalOptions: map[string]string{},
+			Extensions:      map[string]string{},
+		},
+	}
+	if *hostFlag {
+		cert.CertType = ssh.HostCert
+	}
+	if cert.KeyId == "" {
+		cert.KeyId = comment
+	}
+	if cert.KeyId == "" {
+		cert.KeyId = strings.TrimSuffix(filepath.Base(*keyFlag), ".pub")
+	}
+	if cert.Serial == 0 {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return fmt.Errorf("Failed to generate serial number: %v", err)
+		}
+		cert.Serial = binary.BigEndian.Uint64(b[:]) >> 1
+	}
+
+	for _, principal := range strings.Split(*principalsFlag, ",") {
+		if principal = strings.TrimSpace(principal); principal != "" {
+			cert.ValidPrincipals = append(cert.ValidPrincipals, principal)
+		}
+	}
+	// A certificate without principals is accepted for any user or host
+	if len(cert.ValidPrincipals) == 0 {
+		return fmt.Errorf("ssh sign requires --principals")
+	}
+
+	validAfter, validBefore, err := sshValidity(*validFromFlag, *validityFlag)
+	if err != nil {
+		return err
+	}
+	cert.ValidAfter, cert.ValidBefore = validAfter, validBefore
+
+	// Critical options and extensions only apply to user certificates
+	if *forceCommandFlag != "" || *sourceAddressFlag != "" || *verifyRequiredFlag {
+		if *hostFlag {
+			return fmt.Errorf("Critical options are only valid for user certificates")
+		}
+		if *forceCommandFlag != "" {
+			cert.CriticalOptions["force-command"] = *forceCommandFlag
+		}
+		if *sourceAddressFlag != "" {
+			cert.CriticalOptions["source-address"] = strings.ReplaceAll(*sourceAddressFlag, " ", "")
+		}
+		if *verifyRequiredFlag {
+			cert.CriticalOptions["verify-required"] = ""
+		}
+	}
+	extensions := sshUserExtensions
+	if *extensionsFlag != "" {
+		extensions = nil
+		if *extensionsFlag != "none" {
+			for _, ext := range strings.Split(*extensionsFlag, ",") {
+				if ext = strings.TrimSpace(ext); ext != "" {
+					extensions = append(extensions, ext)
+				}
+			}
+		}
+		if *hostFlag && len(extensions) > 0 {
+			return fmt.Errorf("Extensions are only valid for user certificates")
+		}
+	}
+	if !*hostFlag {
+		for _, ext := range extensions {
+			cert.Extensions[ext] = ""
+		}
+	}
+
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return fmt.Errorf("Failed to sign certificate: %v", err)
+	}
+
+	out := *outFlag
+	if out == "" {
+		out = strings.TrimSuffix(*keyFlag, ".pub") + "-cert.pub"
+	}
+	line := ssh.MarshalAuthorizedKey(cert)
+	if comment != "" {
+		line = append(line[:len(line)-1], []byte(" "+comment+"\n")...)
+	}
+	if err := os.WriteFile(out, line, 0644); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", out, err)
+	}
+	auditLog(auditLogSSHCertificate(cert, out, *keyFlag))
+
+	certType := "user"
+	if *hostFlag {
+		certType = "host"
+	}
+	fmt.Printf("Wrote %s certificate %s\n", certType, out)
+	fmt.Printf("  Key ID: %q\n", cert.KeyId)
+	fmt.Printf("  Serial: %d\n", cert.Serial)
+	fmt.Printf("  Principals: %s\n", strings.Join(cert.ValidPrincipals, ", "))
+	if cert.ValidBefore == ssh.CertTimeInfinity {
+		fmt.Println("  Valid: forever")
+	} else {
+		fmt.Printf("  Valid: %s to %s\n",
+			time.Unix(int64(cert.ValidAfter), 0).UTC().Format(time.RFC3339),
+			time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC3339))
+	}
+	fmt.Printf("  Signed by: %s %s\n", signer.PublicKey().Type(), ssh.FingerprintSHA256(signer.PublicKey()))
+	return nil
+}
+
+// auditLogSSHCertificate describes an SSH certificate written to out for an audit entry, with the public key
+// files it certifies
+func auditLogSSHCertificate(cert *ssh.Certificate, out string, inputs ...string) auditLogEntry {
+	entry := auditLogEntry{
+		Operation:      auditLogSSHCertSigned,
+		Subject:        cert.KeyId,
+		Issuer:         ssh.FingerprintSHA256(cert.SignatureKey),
+		Names:          cert.ValidPrincipals,
+		Serial:         fmt.Sprintf("%d", cert.Serial),
+		Fingerprint:    ssh.FingerprintSHA256(cert),
+		KeyType:        cert.Key.Type(),
+		KeyFingerprint: ssh.FingerprintSHA256(cert.Key),
+		Inputs:         auditLogInputs(inputs...),
+		Outputs:        []string{out},
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		notAfter := time.Unix(int64(cert.ValidBefore), 0).UTC()
+		entry.NotAfter = &notAfter
+	}
+	return entry
+}
+
+// readSSHSigner loads a CA private key in OpenSSH, PKCS#1, PKCS#8, or SEC 1 format
+func readSSHSigner(path, passin string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+
+	key, err := ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passin == "" {
+			return nil, fmt.Errorf("%s is encrypted (use --passin to supply the passphrase)", path)
+		}
+		password, perr := readPassphrase(passin)
+		if perr != nil {
+			return nil, perr
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, []byte(password))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse CA private key: %v", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Unsupported CA private key: %v", err)
+	}
+	// RSA CAs sign with SHA-512, since OpenSSH rejects SHA-1 signatures by default
+	if signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		if algSigner, ok := signer.(ssh.AlgorithmSigner); ok {
+			return ssh.NewSignerWithAlgorithms(algSigner, []string{ssh.KeyAlgoRSASHA512})
+		}
+	}
+	return signer, nil
+}
+
+// sshValidity converts a start time and duration into the validity window of a certificate
+func sshValidity(from, validity string) (uint64, uint64, error) {
+	if validity == "forever" {
+		if from != "" {
+			return 0, 0, fmt.Errorf("--valid-from cannot be combined with --validity forever")
+		}
+		if p := certforge.ActivePolicy(); p != nil && p.MaxValidity != 0 {
+			return 0, 0, fmt.Errorf("%w: --validity forever", certforge.ErrPolicy)
+		}
+		return 0, ssh.CertTimeInfinity, nil
+	}
+
+	// Backdate slightly so clocks running behind the CA accept a new certificate
+	start := time.Now().Add(-5 * time.Minute)
+	if from != "" {
+		var err error
+		if start, err = time.Parse(time.RFC3339, from); err != nil {
+			if start, err = time.Parse("2006-01-02", from); err != nil {
+				return 0, 0, fmt.Errorf("Invalid --valid-from %q (use YYYY-MM-DD or RFC 3339)", from)
+			}
+		}
+	}
+	duration, err := parseThreshold(validity)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid --validity: %v", err)
+	}
+	if duration == 0 {
+		return 0, 0, fmt.Errorf("Invalid --validity: must be longer than zero")
+	}
+	if err := certforge.CheckValidity(duration); err != nil {
+		return 0, 0, err
+	}
+	return uint64(start.Unix()), uint64(start.Add(duration).Unix()), nil
+}
+
+// runSSHKeygen implements ssh keygen, which generates an OpenSSH key pair
+func runSSHKeygen(args []string) error {
+	fs := flag.NewFlagSet("ssh keygen", flag.ExitOnError)
+	// FIPS mode does not allow Ed25519, so it generates ECDSA keys unless told otherwise
+	defaultType := "ed25519"
+	if certforge.FIPS() {
+		defaultType = "ecdsa"
+	}
+	typeFlag := fs.String("type", defaultType, "Key type: ed25519, ecdsa, or rsa")
+	bitsFlag := fs.Int("bits", 0, "Key size: 256, 384, or 521 for ecdsa, 2048 to 8192 for rsa (default: 256 or 3072)")
+	outFlag := fs.String("out", "", "Private key file; the public key is written to <out>.pub (default: id_<type>)")
+	commentFlag := fs.String("comment", "", "Comment stored with the key (default: user@host)")
+	passoutFlag := fs.String("passout", "", "Passphrase source to encrypt the private key (pass:, env:, file:, stdin, generate, or generate:<file>)")
+	allowWeakFlag := fs.Bool("allow-weak-pass", false, "Accept a --passout passphrase that is easy to guess")
+	parseArgs(fs, args)
+
+	var key crypto.Signer
+	var err error
+	keyType := strings.ToLower(*typeFlag)
+	switch keyType {
+	case "ed25519":
+		if *bitsFlag != 0 {
+			return fmt.Errorf("Ed25519 keys have a fixed size; omit --bits")
+		}
+		if err := certforge.CheckKeyType(certforge.Ed25519, 0); err != nil {
+			return err
+		}
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "ecdsa":
+		var curve elliptic.Curve
+		switch *bitsFlag {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return fmt.Errorf("Invalid ECDSA key size %d (use 256, 384, or 521)", *bitsFlag)
+		}
+		if err := certforge.CheckKeyType(certforge.ECDSA, curve.Params().BitSize); err != nil {
+			return err
+		}
+		key, err = ecdsa.GenerateKey(curve, rand.Reader)
+	case "rsa":
+		bits := *bitsFlag
+		if bits == 0 {
+			bits = 3072
+		}
+		if bits < 2048 || bits > 8192 {
+			return fmt.Errorf("Invalid RSA key size %d (use 2048 to 8192)", bits)
+		}
+		if err := certforge.CheckKeyType(certforge.RSA, bits); err != nil {
+			return err
+		}
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	default:
+		return fmt.Errorf("Unknown key type %q (use ed25519, ecdsa, or rsa)", *typeFlag)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to generate private key: %v", err)
+	}
+
+	out := *outFlag
+	if out == "" {
+		out = "id_" + keyType
+	}
+	// Never replace an existing key, which may already be trusted somewhere
+	for _, path := range []string{out, out + ".pub"} {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists; remove it or choose another --out", path)
+		}
+	}
+
+	comment := *commentFlag
+	if comment == "" {
+		comment = sshDefaultComment()
+	}
+
+	var block *pem.Block
+	if *passoutFlag != "" {
+		password, err := readNewPassphrase(*passoutFlag, *allowWeakFlag)
+		if err != nil {
+			return err
+		}
+		if password == "" {
+			return fmt.Errorf("Empty passphrase; omit --passout to write an unencrypted key")
+		}
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, comment, []byte(password))
+		if err != nil {
+			return fmt.Errorf("Failed to encode private key: %v", err)
+		}
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, comment)
+		if err != nil {
+			return fmt.Errorf("Failed to encode private key: %v", err)
+		}
+	}
+
+	pub, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return fmt.Errorf("Failed to encode public key: %v", err)
+	}
+	line := ssh.MarshalAuthorizedKey(pub)
+	if comment != "" {
+		line = append(line[:len(line)-1], []byte(" "+comment+"\n")...)
+	}
+
+	if err := os.WriteFile(out, pem.EncodeToMemory(block), 0600); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", out, err)
+	}
+	if err := os.WriteFile(out+".pub", line, 0644); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", out+".pub", err)
+	}
+	entry := auditLogKey(key.Public(), out, out+".pub")
+	entry.KeyFingerprint = ssh.FingerprintSHA256(pub)
+	auditLog(entry)
+
+	fmt.Printf("Wrote private key %s\n", out)
+	fmt.Printf("Wrote public key %s.pub\n", out)
+	fmt.Printf("  Type: %s\n", pub.Type())
+	fmt.Printf("  Fingerprint (SHA-256): %s\n", ssh.FingerprintSHA256(pub))
+	fmt.Printf("  Comment: %s\n", comment)
+	if *passoutFlag != "" {
+		fmt.Println("  Encrypted: yes")
+	} else {
+		fmt.Println("  Encrypted: no")
+	}
+	return nil
+}
+
+// sshDefaultComment returns user@host, the comment ssh-keygen gives new keys
+func sshDefaultComment() string {
+	name := os.Getenv("USER")
+	if name == "" {
+		name = os.Getenv("USERNAME")
+	}
+	host, _ := os.Hostname()
+	if name == "" || host == "" {
+		return name + host
+	}
+	return name + "@" + host
+}
+
+// checkSSHKey checks the key of an OpenSSH public key or certificate against FIPS mode and the policy
+func checkSSHKey(key ssh.PublicKey) error {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		// Security key types such as sk-ssh-ed25519 carry no key the crypto packages know
+		if certforge.FIPS() {
+			return fmt.Errorf("%w: %s keys (use RSA or ECDSA)", certforge.ErrNotFIPSApproved, key.Type())
+		}
+		return nil
+	}
+	return certforge.CheckKey(cryptoKey.CryptoPublicKey())
+}
@@ -0,0 +1,994 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// stepCACommands maps the subcommands of step-ca to their implementations
+var stepCACommands = map[string]func(args []string) error{
+	"root":         runStepCARoot,
+	"provisioners": runStepCAProvisioners,
+	"certificate":  runStepCACertificate,
+}
+
+// stepCATokenLifetime is how long a one-time token signed for a JWK provisioner is valid, as step-ca expects
+const stepCATokenLifetime = 5 * time.Minute
+
+// stepCAClient talks to the API of a smallstep step-ca instance
+type stepCAClient struct {
+	URL   string
+	Roots []*x509.Certificate
+	http  *http.Client
+}
+
+// stepCAFlags are the flags locating and trusting the CA, shared by the step-ca subcommands
+type stepCAFlags struct {
+	caURL       *string
+	root        *string
+	fingerprint *string
+}
+
+// stepCAProvisioner is a provisioner as listed by the CA; JWK provisioners carry their key, encrypted with the
+// provisioner password, and OIDC provisioners the identity provider to log in with
+type stepCAProvisioner struct {
+	Type                  string      `json:"type"`
+	Name                  string      `json:"name"`
+	Key                   *jsonWebKey `json:"key,omitempty"`
+	EncryptedKey          string      `json:"encryptedKey,omitempty"`
+	ClientID              string      `json:"clientID,omitempty"`
+	ClientSecret          string      `json:"clientSecret,omitempty"`
+	ConfigurationEndpoint string      `json:"configurationEndpoint,omitempty"`
+	ListenAddress         string      `json:"listenAddress,omitempty"`
+	Admins                []string    `json:"admins,omitempty"`
+	Domains               []string    `json:"domains,omitempty"`
+}
+
+// stepCAClaims are the claims of a one-time token authorizing a sign request
+type stepCAClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  string   `json:"aud"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+	Expiry    int64    `json:"exp"`
+	ID        string   `json:"jti"`
+	SANs      []string `json:"sans"`
+	SHA       string   `json:"sha,omitempty"`
+}
+
+// runStepCA implements the step-ca command, which dispatches on the subcommand
+func runStepCA(args []string) error {
+	var names []string
+	for name := range stepCACommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return fmt.Errorf("step-ca requires a subcommand: %s", strings.Join(names, ", "))
+	}
+	command, ok := stepCACommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown step-ca subcommand %q (use %s)", args[0], strings.Join(names, ", "))
+	}
+	return command(args[1:])
+}
+
+// addStepCAFlags registers the flags shared by the step-ca subcommands
+func addStepCAFlags(fs *flag.FlagSet) stepCAFlags {
+	return stepCAFlags{
+		caURL:       fs.String("ca-url", "", "URL of the step-ca instance, like https://ca.internal:9000"),
+		root:        fs.String("root", "", "Root certificate of the CA, such as root_ca.crt"),
+		fingerprint: fs.String("fingerprint", "", "SHA-256 fingerprint of the root, to download it from the CA instead of --root"),
+	}
+}
+
+// runStepCARoot implements step-ca root, which downloads and saves the root certificates of the CA
+func runStepCARoot(args []string) error {
+	fs := flag.NewFlagSet("step-ca root", flag.ExitOnError)
+	ca := addStepCAFlags(fs)
+	outFlag := fs.String("out", "", "Save the root certificates as PEM to this file")
+	parseArgs(fs, args)
+
+	ctx, cancel := commandContext(0)
+	defer cancel()
+	client, err := ca.client(ctx)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Certificates []string `json:"crts"`
+	}
+	if err := client.do(ctx, http.MethodGet, "/roots", nil, &resp); err != nil {
+		return err
+	}
+
+	fmt.Println("=== step-ca Roots ===")
+	fmt.Printf("URL: %s\n", client.URL)
+	var buf bytes.Buffer
+	for i, data := range resp.Certificates {
+		certs, err := stepCACertificates(data)
+		if err != nil {
+			return err
+		}
+		for _, cert := range certs {
+			fmt.Printf("\n--- Root %d of %d ---\n", i+1, len(resp.Certificates))
+			fmt.Printf("Subject: %s\n", certforge.FormatName(cert.Subject))
+			fmt.Printf("Valid until: %s\n", cert.NotAfter.Format("2006-01-02"))
+			fmt.Printf("Fingerprint (SHA-256): %x\n", sha256.Sum256(cert.Raw))
+			pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+	}
+
+	if *outFlag != "" {
+		if err := os.WriteFile(*outFlag, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+		}
+		fmt.Printf("\nRoots saved to: %s\n", *outFlag)
+	}
+	return nil
+}
+
+// runStepCAProvisioners implements step-ca provisioners, which lists the provisioners of the CA
+func runStepCAProvisioners(args []string) error {
+	fs := flag.NewFlagSet("step-ca provisioners", flag.ExitOnError)
+	ca := addStepCAFlags(fs)
+	parseArgs(fs, args)
+
+	ctx, cancel := commandContext(0)
+	defer cancel()
+	client, err := ca.client(ctx)
+	if err != nil {
+		return err
+	}
+	provisioners, err := client.provisioners(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("=== step-ca Provisioners ===")
+	fmt.Printf("URL: %s\n\n", client.URL)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tDETAILS")
+	for _, p := range provisioners {
+		var details string
+		switch {
+		case p.Type == "JWK" && p.Key != nil:
+			details = "key " + p.Key.Kid
+			if p.EncryptedKey == "" {
+				details += " (no encrypted key; pass --token)"
+			}
+		case p.Type == "OIDC":
+			details = "client " + p.ClientID
+			if u, err := url.Parse(p.ConfigurationEndpoint); err == nil {
+				details += " at " + u.Host
+			}
+		default:
+			details = "not supported by certforge"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name, p.Type, details)
+	}
+	w.Flush()
+	return nil
+}
+
+// runStepCACertificate implements step-ca certificate, which signs a CSR through a JWK or OIDC provisioner
+func runStepCACertificate(args []string) error {
+	fs := flag.NewFlagSet("step-ca certificate", flag.ExitOnError)
+	ca := addStepCAFlags(fs)
+	provisionerFlag := fs.String("provisioner", "", "Provisioner to authorize with (default: the only JWK or OIDC provisioner)")
+	passwordFlag := fs.String("provisioner-password", "", "Password of a JWK provisioner: pass:, env:, file:, or stdin")
+	tokenFlag := fs.String("token", "", "One-time token or OIDC ID token to use instead of the provisioner: pass:, env:, file:, or stdin")
+	cnFlag := fs.String("cn", "", "Common name of the certificate (default: the first name, or the email of an OIDC login)")
+	domainFlag := fs.String("domain", "", "Comma separated DNS names, IP addresses, emails, and URIs for the subject alternative names")
+	notAfterFlag := fs.String("not-after", "", "Validity requested, like 24h, or an RFC 3339 time (default: the provisioner's)")
+	keyFlag := fs.String("key", "", "Existing private key to certify (default: generate a new one)")
+	keyTypeFlag := fs.String("key-type", "ecdsa", "Type of a new key: rsa, ecdsa, or tpm (ECDSA P-256 kept in the TPM)")
+	keySizeFlag := fs.Int("key-size", 2048, "Size of a new RSA key: 2048, 3072, or 4096")
+	outFlag := fs.String("out", "", "Output file prefix (default: the common name)")
+	outputDirFlag := fs.String("o", "", "Output directory for generated files (default: current directory)")
+	parseArgs(fs, args)
+
+	var names []string
+	for _, name := range strings.Split(*domainFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" && !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	cn := *cnFlag
+	if cn == "" && len(names) > 0 {
+		cn = names[0]
+	}
+	if *notAfterFlag != "" {
+		if _, err := time.ParseDuration(*notAfterFlag); err != nil {
+			if _, err := time.Parse(time.RFC3339, *notAfterFlag); err != nil {
+				return fmt.Errorf("Invalid --not-after %q: use a duration like 24h or an RFC 3339 time", *notAfterFlag)
+			}
+		}
+	}
+
+	ctx, cancel := commandContext(0)
+	defer cancel()
+	client, err := ca.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	var token string
+	if *tokenFlag != "" {
+		if token, err = readPassphrase(*tokenFlag); err != nil {
+			return err
+		}
+	} else {
+		provisioner, err := client.provisioner(ctx, *provisionerFlag)
+		if err != nil {
+			return err
+		}
+		switch provisioner.Type {
+		case "JWK":
+			if cn == "" {
+				return fmt.Errorf("step-ca certificate requires --cn or --domain")
+			}
+			if *passwordFlag == "" {
+				return fmt.Errorf("The JWK provisioner %s requires --provisioner-password", provisioner.Name)
+			}
+			password, err := readPassphrase(*passwordFlag)
+			if err != nil {
+				return err
+			}
+			if token, err = client.jwkToken(provisioner, password, cn, names); err != nil {
+				return err
+			}
+		case "OIDC":
+			if token, err = oidcIDToken(ctx, provisioner); err != nil {
+				return err
+			}
+		}
+	}
+
+	// An OIDC login certifies the user's email unless other names are asked for, which only admins may do
+	if cn == "" {
+		if email := stepCATokenEmail(token); email != "" {
+			cn, names = email, []string{email}
+		} else {
+			return fmt.Errorf("step-ca certificate requires --cn or --domain")
+		}
+	}
+	if len(names) == 0 {
+		names = []string{cn}
+	}
+
+	var key crypto.Signer
+	if *keyFlag != "" {
+		existing, err := readPrivateKey(*keyFlag, "")
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if key, ok = existing.(crypto.Signer); !ok {
+			return fmt.Errorf("Unsupported private key in %s", *keyFlag)
+		}
+	} else if key, err = generateACMEKey(ctx, *keyTypeFlag, *keySizeFlag); err != nil {
+		return err
+	}
+	defer closeTPMKey(key)
+
+	csrDER, err := certforge.CreateCSR(key, pkix.Name{CommonName: cn}, names)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Requesting a certificate for %s from %s\n", strings.Join(names, ", "), client.URL)
+	chain, err := client.sign(ctx, csrDER, token, *notAfterFlag)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return fmt.Errorf("Failed to parse certificate: %v", err)
+	}
+
+	prefix := *outFlag
+	if prefix == "" {
+		prefix = strings.TrimPrefix(cn, "*.")
+	}
+	paths, err := writeIssuedFiles(layoutFlat, *outputDirFlag, prefix, key, csrDER, chain, *keyFlag == "")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\nSuccess!")
+	if *keyFlag == "" {
+		fmt.Printf("Private key saved to: %s\n", paths.Key)
+	}
+	fmt.Printf("CSR saved to: %s\n", paths.CSR)
+	fmt.Printf("Certificate saved to: %s\n", paths.Cert)
+	fmt.Printf("Chain saved to: %s\n", paths.Chain)
+	fmt.Printf("Full chain saved to: %s\n", paths.FullChain)
+	fmt.Printf("Issued by %s, valid until %s\n", certforge.FormatName(leaf.Issuer), leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// client checks the CA URL and trusts the root given, or the root downloaded from the CA that has the given fingerprint
+func (f stepCAFlags) client(ctx context.Context) (*stepCAClient, error) {
+	if *f.caURL == "" {
+		return nil, fmt.Errorf("step-ca requires --ca-url")
+	}
+	if u, err := url.Parse(*f.caURL); err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid --ca-url %q: use an https URL", *f.caURL)
+	}
+	client := &stepCAClient{URL: strings.TrimRight(*f.caURL, "/")}
+
+	switch {
+	case *f.root != "" && *f.fingerprint != "":
+		return nil, fmt.Errorf("Use either --root or --fingerprint")
+	case *f.root != "":
+		certs, err := readCertificates(*f.root)
+		if err != nil {
+			return nil, err
+		}
+		client.Roots = certs
+	case *f.fingerprint != "":
+		root, err := client.bootstrap(ctx, *f.fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		client.Roots = []*x509.Certificate{root}
+	default:
+		return nil, fmt.Errorf("step-ca requires --root or --fingerprint to trust the CA")
+	}
+
+	pool := x509.NewCertPool()
+	for _, cert := range client.Roots {
+		pool.AddCert(cert)
+	}
+	client.http = &http.Client{
+		Timeout:   time.Minute,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	return client, nil
+}
+
+// bootstrap downloads the root with the given fingerprint; the connection cannot be verified before the root is
+// known, so the fingerprint is what makes the root trustworthy
+func (c *stepCAClient) bootstrap(ctx context.Context, fingerprint string) (*x509.Certificate, error) {
+	fingerprint = strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+	if b, err := hex.DecodeString(fingerprint); err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("Invalid --fingerprint: expected a SHA-256 fingerprint in hex")
+	}
+	c.http = &http.Client{
+		Timeout:   time.Minute,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	var resp struct {
+		CA string `json:"ca"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/root/"+fingerprint, nil, &resp); err != nil {
+		return nil, err
+	}
+	certs, err := stepCACertificates(resp.CA)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(certs[0].Raw)
+	if hex.EncodeToString(sum[:]) != fingerprint {
+		return nil, fmt.Errorf("The root sent by %s does not have fingerprint %s", c.URL, fingerprint)
+	}
+	return certs[0], nil
+}
+
+// provisioners lists every provisioner of the CA, following the pages of the listing
+func (c *stepCAClient) provisioners(ctx context.Context) ([]*stepCAProvisioner, error) {
+	var all []*stepCAProvisioner
+	cursor := ""
+	for {
+		var page struct {
+			Provisioners []*stepCAProvisioner `json:"provisioners"`
+			NextCursor   string               `json:"nextCursor"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/provisioners?cursor="+url.QueryEscape(cursor), nil, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Provisioners...)
+		if page.NextCursor == "" || len(page.Provisioners) == 0 {
+			return all, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// provisioner finds the named provisioner, or the only JWK or OIDC provisioner when no name is given
+func (c *stepCAClient) provisioner(ctx context.Context, name string) (*stepCAProvisioner, error) {
+	provisioners, err := c.provisioners(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var usable []*stepCAProvisioner
+	for _, p := range provisioners {
+		if name != "" && p.Name == name {
+			if p.Type != "JWK" && p.Type != "OIDC" {
+				return nil, fmt.Errorf("Provisioner %s is of type %s; certforge supports JWK and OIDC provisioners", name, p.Type)
+			}
+			return p, nil
+		}
+		if p.Type == "JWK" || p.Type == "OIDC" {
+			usable = append(usable, p)
+		}
+	}
+	if name != "" {
+		return nil, fmt.Errorf("The CA has no provisioner named %s", name)
+	}
+	if len(usable) != 1 {
+		var names []string
+		for _, p := range usable {
+			names = append(names, p.Name)
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("The CA has no JWK or OIDC provisioner")
+		}
+		return nil, fmt.Errorf("Choose a provisioner with --provisioner: %s", strings.Join(names, ", "))
+	}
+	return usable[0], nil
+}
+
+// jwkToken decrypts the key of a JWK provisioner and signs a one-time token authorizing a certificate for the names
+func (c *stepCAClient) jwkToken(p *stepCAProvisioner, password, subject string, names []string) (string, error) {
+	if p.EncryptedKey == "" {
+		return "", fmt.Errorf("The JWK provisioner %s does not publish its encrypted key; pass a token with --token", p.Name)
+	}
+	key, err := decryptStepCAKey(p.EncryptedKey, []byte(password))
+	if err != nil {
+		return "", fmt.Errorf("Failed to decrypt the key of provisioner %s: %v", p.Name, err)
+	}
+	if key.Kid == "" && p.Key != nil {
+		key.Kid = p.Key.Kid
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	now := time.Now()
+	claims := stepCAClaims{
+		Issuer:    p.Name,
+		Subject:   subject,
+		Audience:  c.URL + "/1.0/sign",
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+		Expiry:    now.Add(stepCATokenLifetime).Unix(),
+		ID:        hex.EncodeToString(id),
+		SANs:      names,
+	}
+	if len(names) == 0 {
+		claims.SANs = []string{subject}
+	}
+	if len(c.Roots) > 0 {
+		sum := sha256.Sum256(c.Roots[0].Raw)
+		claims.SHA = hex.EncodeToString(sum[:])
+	}
+	return signJWT(key, claims)
+}
+
+// sign submits a CSR with a token, and returns the certificate followed by its intermediates
+func (c *stepCAClient) sign(ctx context.Context, csrDER []byte, token, notAfter string) ([][]byte, error) {
+	req := map[string]string{
+		"csr": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})),
+		"ott": token,
+	}
+	if notAfter != "" {
+		req["notAfter"] = notAfter
+	}
+	var resp struct {
+		Certificate  string   `json:"crt"`
+		CA           string   `json:"ca"`
+		CertChain    []string `json:"certChain"`
+		Certificates []string `json:"certificates"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/1.0/sign", req, &resp); err != nil {
+		return nil, err
+	}
+
+	// Older CAs only return the certificate and its issuer
+	pems := resp.CertChain
+	if len(pems) == 0 {
+		pems = resp.Certificates
+	}
+	if len(pems) == 0 {
+		pems = []string{resp.Certificate, resp.CA}
+	}
+	var chain [][]byte
+	for _, data := range pems {
+		if data == "" {
+			continue
+		}
+		certs, err := stepCACertificates(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			if !certforge.IsSelfSigned(cert) {
+				chain = append(chain, cert.Raw)
+			}
+		}
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("The CA returned no certificate")
+	}
+	return chain, nil
+}
+
+// do sends a request to the CA and decodes its JSON response, turning error responses into errors
+func (c *stepCAClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "certforge/"+version)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("Request to %s failed: %v", c.URL, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return fmt.Errorf("Failed to read response from %s: %v", c.URL, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		var problem struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &problem) == nil && problem.Message != "" {
+			return fmt.Errorf("%s %s: %s (%s)", method, path, problem.Message, resp.Status)
+		}
+		return fmt.Errorf("%s %s returned %s", method, path, resp.Status)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("Invalid response from %s%s: %v", c.URL, path, err)
+	}
+	return nil
+}
+
+// stepCACertificates parses the PEM certificates of an API response
+func stepCACertificates(data string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse certificate from the CA: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("The CA returned no certificate")
+	}
+	return certs, nil
+}
+
+// stepCATokenEmail returns the email claim of a token, without verifying it; the CA does that
+func stepCATokenEmail(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Email string `json:"email"`
+	}
+	json.Unmarshal(payload, &claims)
+	return claims.Email
+}
+
+// signJWT signs claims as a compact JWS with a private JWK
+func signJWT(key jsonWebKey, claims interface{}) (string, error) {
+	_, priv, err := jwkKeys(key)
+	if err != nil {
+		return "", err
+	}
+	if priv == nil {
+		return "", fmt.Errorf("The key has no private part")
+	}
+	alg := key.Alg
+	if alg == "" {
+		alg = jwkSigningAlgorithm(key)
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": key.Kid, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	switch k := priv.(type) {
+	case *ecdsa.PrivateKey:
+		var h hash.Hash
+		switch {
+		case alg == "ES256" && k.Curve == elliptic.P256():
+			h = sha256.New()
+		case alg == "ES384" && k.Curve == elliptic.P384():
+			h = sha512.New384()
+		case alg == "ES512" && k.Curve == elliptic.P521():
+			h = sha512.New()
+		default:
+			return "", fmt.Errorf("Unsupported JWS algorithm %s for an ECDSA key", alg)
+		}
+		h.Write([]byte(input))
+		r, s, err := ecdsa.Sign(rand.Reader, k, h.Sum(nil))
+		if err != nil {
+			return "", err
+		}
+		// JWS ECDSA signatures are r and s as fixed-size big-endian integers, not ASN.1
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	case *rsa.PrivateKey:
+		if alg != "RS256" {
+			return "", fmt.Errorf("Unsupported JWS algorithm %s for an RSA key", alg)
+		}
+		digest := sha256.Sum256([]byte(input))
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	case ed25519.PrivateKey:
+		if err := certforge.CheckKey(k); err != nil {
+			return "", err
+		}
+		sig = ed25519.Sign(k, []byte(input))
+	default:
+		return "", fmt.Errorf("Unsupported signing key: %T", priv)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// decryptStepCAKey decrypts the encrypted key of a JWK provisioner: a compact JWE (RFC 7516) whose content key is
+// wrapped with a key derived from the password (PBES2, RFC 7518 section 4.8)
+func decryptStepCAKey(jwe string, password []byte) (jsonWebKey, error) {
+	parts := strings.Split(jwe, ".")
+	if len(parts) != 5 {
+		return jsonWebKey{}, fmt.Errorf("The encrypted key is not a compact JWE")
+	}
+	var fields [5][]byte
+	for i, part := range parts {
+		var err error
+		if fields[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return jsonWebKey{}, fmt.Errorf("The encrypted key is not a compact JWE: %v", err)
+		}
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Enc string `json:"enc"`
+		P2S string `json:"p2s"`
+		P2C int    `json:"p2c"`
+	}
+	if err := json.Unmarshal(fields[0], &header); err != nil {
+		return jsonWebKey{}, fmt.Errorf("Invalid JWE header: %v", err)
+	}
+
+	var prf func() hash.Hash
+	var kekSize int
+	switch header.Alg {
+	case "PBES2-HS256+A128KW":
+		prf, kekSize = sha256.New, 16
+	case "PBES2-HS384+A192KW":
+		prf, kekSize = sha512.New384, 24
+	case "PBES2-HS512+A256KW":
+		prf, kekSize = sha512.New, 32
+	default:
+		return jsonWebKey{}, fmt.Errorf("Unsupported JWE key algorithm %q", header.Alg)
+	}
+	if header.P2C < 1 || header.P2C > 10000000 {
+		return jsonWebKey{}, fmt.Errorf("Invalid PBES2 iteration count %d", header.P2C)
+	}
+	p2s, err := base64.RawURLEncoding.DecodeString(header.P2S)
+	if err != nil {
+		return jsonWebKey{}, fmt.Errorf("Invalid PBES2 salt: %v", err)
+	}
+	// The salt is the algorithm name, a zero byte, and the salt input of the header
+	salt := append(append([]byte(header.Alg), 0), p2s...)
+	kek, err := pbkdf2.Key(prf, string(password), salt, header.P2C, kekSize)
+	if err != nil {
+		return jsonWebKey{}, err
+	}
+	cek, err := aesKeyUnwrap(kek, fields[1])
+	if err != nil {
+		return jsonWebKey{}, fmt.Errorf("Wrong provisioner password")
+	}
+
+	plaintext, err := jweDecrypt(header.Enc, cek, fields[2], fields[3], fields[4], []byte(parts[0]))
+	if err != nil {
+		return jsonWebKey{}, err
+	}
+	var key jsonWebKey
+	if err := json.Unmarshal(plaintext, &key); err != nil {
+		return jsonWebKey{}, fmt.Errorf("The decrypted key is not a JWK: %v", err)
+	}
+	return key, nil
+}
+
+// jweDecrypt decrypts and authenticates JWE content with AES-GCM or AES-CBC with HMAC (RFC 7518 section 5)
+func jweDecrypt(enc string, cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	switch enc {
+	case "A128GCM", "A192GCM", "A256GCM":
+		if len(cek)*8 != map[string]int{"A128GCM": 128, "A192GCM": 192, "A256GCM": 256}[enc] {
+			return nil, fmt.Errorf("Content key has the wrong size for %s", enc)
+		}
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := gcm.Open(nil, iv, append(append([]byte{}, ciphertext...), tag...), aad)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decrypt the encrypted key: %v", err)
+		}
+		return plaintext, nil
+
+	case "A128CBC-HS256", "A192CBC-HS384", "A256CBC-HS512":
+		newHash := map[string]func() hash.Hash{"A128CBC-HS256": sha256.New, "A192CBC-HS384": sha512.New384, "A256CBC-HS512": sha512.New}[enc]
+		size := newHash().Size()
+		if len(cek) != size {
+			return nil, fmt.Errorf("Content key has the wrong size for %s", enc)
+		}
+		macKey, encKey := cek[:size/2], cek[size/2:]
+		mac := hmac.New(newHash, macKey)
+		mac.Write(aad)
+		mac.Write(iv)
+		mac.Write(ciphertext)
+		binary.Write(mac, binary.BigEndian, uint64(len(aad))*8)
+		if !hmac.Equal(mac.Sum(nil)[:size/2], tag) {
+			return nil, fmt.Errorf("Failed to decrypt the encrypted key: authentication failed")
+		}
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != block.BlockSize() || len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+			return nil, fmt.Errorf("Invalid JWE ciphertext")
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+		pad := int(plaintext[len(plaintext)-1])
+		if pad == 0 || pad > block.BlockSize() {
+			return nil, fmt.Errorf("Invalid JWE padding")
+		}
+		return plaintext[:len(plaintext)-pad], nil
+	}
+	return nil, fmt.Errorf("Unsupported JWE content encryption %q", enc)
+}
+
+// aesKeyUnwrap unwraps a key wrapped with the AES Key Wrap algorithm (RFC 3394)
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, fmt.Errorf("Invalid wrapped key length %d", len(wrapped))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	a := binary.BigEndian.Uint64(wrapped[:8])
+	r := append([]byte{}, wrapped[8:]...)
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(buf[:8], a^uint64(n*j+i))
+			copy(buf[8:], r[(i-1)*8:i*8])
+			block.Decrypt(buf, buf)
+			a = binary.BigEndian.Uint64(buf[:8])
+			copy(r[(i-1)*8:i*8], buf[8:])
+		}
+	}
+	if a != 0xA6A6A6A6A6A6A6A6 {
+		return nil, fmt.Errorf("Key unwrap integrity check failed")
+	}
+	return r, nil
+}
+
+// oidcIDToken logs in with the identity provider of an OIDC provisioner, using the authorization code flow with
+// PKCE and a loopback redirect, and returns the ID token the CA accepts as a one-time token
+func oidcIDToken(ctx context.Context, p *stepCAProvisioner) (string, error) {
+	if p.ConfigurationEndpoint == "" || p.ClientID == "" {
+		return "", fmt.Errorf("The OIDC provisioner %s has no client ID or configuration endpoint", p.Name)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	var config struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+	}
+	if err := getJSON(ctx, p.ConfigurationEndpoint, &config); err != nil {
+		return "", fmt.Errorf("Failed to read the OpenID configuration: %v", err)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" {
+		return "", fmt.Errorf("The OpenID configuration at %s has no authorization or token endpoint", p.ConfigurationEndpoint)
+	}
+
+	// The provider redirects the browser back to a server on this machine, on the provisioner's address when it
+	// names one, as providers may only accept registered redirect URIs
+	listen := "127.0.0.1:0"
+	if p.ListenAddress != "" {
+		listen = p.ListenAddress
+		if strings.HasPrefix(listen, ":") {
+			listen = "127.0.0.1" + listen
+		}
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return "", fmt.Errorf("Failed to listen for the login redirect: %v", err)
+	}
+	defer listener.Close()
+	redirect := "http://" + listener.Addr().String()
+
+	random := func() string {
+		b := make([]byte, 32)
+		rand.Read(b)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	state, nonce, verifier := random(), random(), random()
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirect},
+		"response_type":         {"code"},
+		"scope":                 {"openid email"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	authURL := config.AuthorizationEndpoint
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + query.Encode()
+	} else {
+		authURL += "?" + query.Encode()
+	}
+
+	codes := make(chan string, 1)
+	failures := make(chan error, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "Unexpected login response", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			failures <- fmt.Errorf("Login failed: %s %s", q.Get("error"), q.Get("error_description"))
+			http.Error(w, "Login failed, see the terminal", http.StatusForbidden)
+			return
+		}
+		codes <- q.Get("code")
+		fmt.Fprintln(w, "Logged in; you can close this window and return to certforge.")
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Printf("Log in to provisioner %s by opening this URL in a browser:\n\n  %s\n\n", p.Name, authURL)
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-failures:
+		return "", err
+	case <-ctx.Done():
+		return "", fmt.Errorf("Timed out waiting for the login")
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirect},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to redeem the login code: %v", err)
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("Invalid response from %s: %v", config.TokenEndpoint, err)
+	}
+	if tokens.Error != "" {
+		return "", fmt.Errorf("Failed to redeem the login code: %s %s", tokens.Error, tokens.Description)
+	}
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("The identity provider returned no ID token")
+	}
+	return tokens.IDToken, nil
+}
+
+// getJSON fetches and decodes a JSON document
+func getJSON(ctx context.Context, target string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
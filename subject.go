@@ -0,0 +1,102 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// OIDs of the subject attributes pkix.Name has no fields for
+var (
+	oidAttrSurname   = asn1.ObjectIdentifier{2, 5, 4, 4}
+	oidAttrTitle     = asn1.ObjectIdentifier{2, 5, 4, 12}
+	oidAttrGivenName = asn1.ObjectIdentifier{2, 5, 4, 42}
+)
+
+// subjectAttributes are the subject attributes of a generated certificate beyond those always asked for, which
+// enterprise and EV-style profiles require
+type subjectAttributes struct {
+	SerialNumber string
+	Street       string
+	PostalCode   string
+	Title        string
+	GivenName    string
+	Surname      string
+}
+
+// empty reports whether no attribute is set
+func (a *subjectAttributes) empty() bool {
+	return *a == subjectAttributes{}
+}
+
+// prompt asks for each attribute, leaving those answered with a blank line unset
+func (a *subjectAttributes) prompt(reader *bufio.Reader) {
+	for _, field := range []struct {
+		label string
+		value *string
+	}{
+		{"Serial Number (e.g. a registration or device number)", &a.SerialNumber},
+		{"Street Address (e.g. 1 Market St)", &a.Street},
+		{"Postal Code (e.g. 94105)", &a.PostalCode},
+		{"Title (e.g. Engineer)", &a.Title},
+		{"Given Name (e.g. Jane)", &a.GivenName},
+		{"Surname (e.g. Doe)", &a.Surname},
+	} {
+		fmt.Printf("%s: ", field.label)
+		answer, _ := reader.ReadString('\n')
+		*field.value = strings.TrimSpace(answer)
+	}
+}
+
+// apply adds the attributes that are set to a subject
+func (a *subjectAttributes) apply(name *pkix.Name) {
+	if a.SerialNumber != "" {
+		name.SerialNumber = a.SerialNumber
+	}
+	if a.Street != "" {
+		name.StreetAddress = []string{a.Street}
+	}
+	if a.PostalCode != "" {
+		name.PostalCode = []string{a.PostalCode}
+	}
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value string
+	}{
+		{oidAttrTitle, a.Title},
+		{oidAttrGivenName, a.GivenName},
+		{oidAttrSurname, a.Surname},
+	} {
+		if attr.value != "" {
+			name.ExtraNames = append(name.ExtraNames, pkix.AttributeTypeAndValue{Type: attr.oid, Value: attr.value})
+		}
+	}
+}
+
+// parseSubjectOIDs parses a comma-separated list of oid=value subject attributes into request options
+func parseSubjectOIDs(list string) ([]certforge.Option, error) {
+	var opts []certforge.Option
+	if list == "" {
+		return nil, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		dotted, value, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("Invalid subject attribute %q: use oid=value, as in 1.3.6.1.4.1.99999.1=DeviceID-1234", item)
+		}
+		oid, err := certforge.ParseOID(dotted)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, certforge.WithSubjectAttribute(oid, strings.TrimSpace(value)))
+	}
+	return opts, nil
+}
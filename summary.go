@@ -0,0 +1,251 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// fileSummary is the one-line description of a file in the multi-file decode table
+type fileSummary struct {
+	Path    string
+	Type    string
+	Subject string
+	Expires string
+	Status  string
+	Level   expiryLevel
+}
+
+// expandDecodeArgs expands glob patterns into the list of files to decode
+func expandDecodeArgs(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		if !strings.ContainsAny(arg, "*?[") {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q: %v", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No files match %q", arg)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// decodeFiles prints a summary table of several files, followed by their full details if requested
+func decodeFiles(files []string, opts decodeOptions, details bool) error {
+	now := time.Now()
+	summaries := make([]fileSummary, 0, len(files))
+	for _, file := range files {
+		summaries = append(summaries, summarizeFile(file, now, opts.WarnDays))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tTYPE\tSUBJECT\tEXPIRES\tSTATUS")
+	for _, s := range summaries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Path, s.Type, s.Subject, s.Expires, s.Status)
+	}
+	w.Flush()
+
+	// Colors are applied after alignment since escape codes would skew the column widths
+	problems := 0
+	for _, s := range summaries {
+		if s.Level != expiryOK {
+			problems++
+		}
+	}
+	fmt.Printf("\n%d files, %s\n", len(files), colorize(fmt.Sprintf("%d need attention", problems), worstLevel(summaries)))
+
+	if !details {
+		return nil
+	}
+	for _, file := range files {
+		fmt.Printf("\n=== %s ===\n\n", file)
+		if err := decodeFile(file, opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// worstLevel returns the most urgent level among the summaries
+func worstLevel(summaries []fileSummary) expiryLevel {
+	worst := expiryOK
+	for _, s := range summaries {
+		if s.Level > worst {
+			worst = s.Level
+		}
+	}
+	return worst
+}
+
+// summarizeFile identifies the main object in a file and describes it in one line
+func summarizeFile(path string, now time.Time, warnDays int) fileSummary {
+	summary := fileSummary{Path: path, Subject: "-", Expires: "-", Status: "-"}
+	if info, err := os.Stat(path); err == nil && info.Size() > maxDecodeSize {
+		return summarizeStream(summary, now, warnDays)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		summary.Type = "unreadable"
+		summary.Status = "ERROR"
+		summary.Level = expiryExpired
+		return summary
+	}
+
+	var blocks []*pem.Block
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		if block.Type == "CERTIFICATE" {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				certs = append(certs, cert)
+			}
+		}
+	}
+	if len(blocks) == 0 {
+		if cert, err := x509.ParseCertificate(data); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+
+	switch {
+	case len(certs) > 0:
+		summary.Type = "certificate"
+		if len(certs) > 1 {
+			summary.Type = fmt.Sprintf("chain (%d certs)", len(certs))
+		}
+		cert := certs[0]
+		summary.Subject = certforge.FormatName(cert.Subject)
+		summary.Expires = cert.NotAfter.Format("2006-01-02")
+		summary.Status, summary.Level = describeExpiry(cert, now, warnDays)
+	case len(blocks) > 0:
+		summary.Type = strings.ToLower(blocks[0].Type)
+		if blocks[0].Type == "PKCS7" {
+			summary.Type = "PKCS#7"
+		}
+		if blocks[0].Type == "CERTIFICATE REQUEST" {
+			summary.Type = "CSR"
+			if csr, err := x509.ParseCertificateRequest(blocks[0].Bytes); err == nil {
+				summary.Subject = certforge.FormatName(csr.Subject)
+			}
+		}
+		if blocks[0].Type == "X509 CRL" {
+			summary.Type = "CRL"
+			if crl, err := x509.ParseRevocationList(blocks[0].Bytes); err == nil {
+				summary.Subject = certforge.FormatName(crl.Issuer)
+				summarizeCRLUpdate(&summary, crl, now)
+			}
+		}
+	case looksLikeSSHPublicKey(data):
+		summary.Type = "SSH public key"
+	case looksLikeJSON(data):
+		var jwk struct {
+			Kty  string            `json:"kty"`
+			Keys []json.RawMessage `json:"keys"`
+		}
+		summary.Type = "JSON"
+		if json.Unmarshal(data, &jwk) == nil {
+			switch {
+			case jwk.Keys != nil:
+				summary.Type = fmt.Sprintf("JWK Set (%d keys)", len(jwk.Keys))
+			case jwk.Kty != "":
+				summary.Type = "JWK (" + jwk.Kty + ")"
+			}
+		}
+	default:
+		if csr, err := x509.ParseCertificateRequest(data); err == nil {
+			summary.Type = "CSR"
+			summary.Subject = certforge.FormatName(csr.Subject)
+		} else if crl, err := x509.ParseRevocationList(data); err == nil {
+			summary.Type = "CRL"
+			summary.Subject = certforge.FormatName(crl.Issuer)
+			summarizeCRLUpdate(&summary, crl, now)
+		} else if _, err := parsePKCS7(data); err == nil {
+			summary.Type = "PKCS#7"
+		} else if _, err := decodePKCS12(data, ""); err == nil || err == errPKCS12Password {
+			summary.Type = "PKCS#12"
+		} else {
+			summary.Type = "unknown"
+		}
+	}
+	return summary
+}
+
+// summarizeStream summarizes a PEM bundle too large to read at once by its first certificate and block count
+func summarizeStream(summary fileSummary, now time.Time, warnDays int) fileSummary {
+	summary.Type = "too large"
+	file, err := os.Open(summary.Path)
+	if err != nil {
+		summary.Type = "unreadable"
+		summary.Status = "ERROR"
+		summary.Level = expiryExpired
+		return summary
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	if !looksLikePEM(reader) {
+		return summary
+	}
+
+	var first *x509.Certificate
+	blocks, certs := 0, 0
+	scanner := certforge.NewPEMScanner(reader)
+	for scanner.Scan() {
+		blocks++
+		if block := scanner.Block(); block.Type == "CERTIFICATE" {
+			certs++
+			if first == nil {
+				first, _ = x509.ParseCertificate(block.Bytes)
+			}
+		}
+	}
+	if scanner.Err() != nil {
+		summary.Status = "ERROR"
+		summary.Level = expiryExpired
+	}
+	summary.Type = fmt.Sprintf("PEM bundle (%d blocks, %d certs)", blocks, certs)
+	if first != nil && scanner.Err() == nil {
+		summary.Subject = certforge.FormatName(first.Subject)
+		summary.Expires = first.NotAfter.Format("2006-01-02")
+		summary.Status, summary.Level = describeExpiry(first, now, warnDays)
+	}
+	return summary
+}
+
+// summarizeCRLUpdate fills in the next update of a CRL as its expiry
+func summarizeCRLUpdate(summary *fileSummary, crl *x509.RevocationList, now time.Time) {
+	if crl.NextUpdate.IsZero() {
+		return
+	}
+	summary.Expires = crl.NextUpdate.Format("2006-01-02")
+	summary.Status = "current"
+	if now.After(crl.NextUpdate) {
+		summary.Status, summary.Level = "STALE", expiryExpired
+	}
+}
@@ -0,0 +1,198 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// systemdHardening are the sandboxing options of the renewal service; ReadWritePaths opens the files it writes
+var systemdHardening = []string{
+	"ProtectSystem=strict",
+	"ProtectHome=read-only",
+	"PrivateTmp=yes",
+	"PrivateDevices=yes",
+	"ProtectKernelTunables=yes",
+	"ProtectKernelModules=yes",
+	"ProtectKernelLogs=yes",
+	"ProtectControlGroups=yes",
+	"ProtectClock=yes",
+	"ProtectHostname=yes",
+	"RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6",
+	"RestrictNamespaces=yes",
+	"RestrictRealtime=yes",
+	"RestrictSUIDSGID=yes",
+	"LockPersonality=yes",
+	"MemoryDenyWriteExecute=yes",
+	"SystemCallArchitectures=native",
+	"UMask=0022",
+}
+
+// runSystemd implements the systemd command, which writes a service and timer running renew-all
+func runSystemd(args []string) error {
+	fs := flag.NewFlagSet("systemd", flag.ExitOnError)
+	configFlag := fs.String("config", "/etc/certforge/renewals.yaml", "Renewal config the service renews")
+	nameFlag := fs.String("name", "certforge-renew", "Name of the unit files, <name>.service and <name>.timer")
+	outDirFlag := fs.String("out-dir", ".", "Directory to write the units to, like /etc/systemd/system")
+	onCalendarFlag := fs.String("on-calendar", "*-*-* 00,12:00:00", "When the timer runs, in systemd calendar syntax")
+	userFlag := fs.String("user", "root", "User the service runs as")
+	binaryFlag := fs.String("binary", "", "Path of the certforge binary (default: this binary)")
+	minRemainingFlag := fs.String("min-remaining", "", "Passed to renew-all --min-remaining")
+	shredOldFlag := fs.Bool("shred-old", false, "Passed to renew-all --shred-old")
+	readWriteFlag := fs.String("read-write-paths", "", "Comma separated paths hooks write to, besides the certificate directories")
+	parseArgs(fs, args)
+
+	configPath, err := filepath.Abs(*configFlag)
+	if err != nil {
+		return fmt.Errorf("Invalid --config: %v", err)
+	}
+	cfg, err := loadRenewalConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if *minRemainingFlag != "" {
+		if _, err := parseThreshold(*minRemainingFlag); err != nil {
+			return fmt.Errorf("Invalid --min-remaining: %v", err)
+		}
+	}
+
+	binary := *binaryFlag
+	if binary == "" {
+		if binary, err = os.Executable(); err != nil {
+			return fmt.Errorf("Cannot find the certforge binary (use --binary): %v", err)
+		}
+		if resolved, err := filepath.EvalSymlinks(binary); err == nil {
+			binary = resolved
+		}
+	}
+	if !filepath.IsAbs(binary) {
+		return fmt.Errorf("--binary must be an absolute path")
+	}
+
+	writable, bindsPorts, err := systemdWritablePaths(cfg, *userFlag)
+	if err != nil {
+		return err
+	}
+	for _, path := range strings.Split(*readWriteFlag, ",") {
+		if path = strings.TrimSpace(path); path != "" && !contains(writable, path) {
+			writable = append(writable, path)
+		}
+	}
+	sort.Strings(writable)
+
+	command := []string{systemdQuote(binary), "renew-all", "--config", systemdQuote(configPath)}
+	if *minRemainingFlag != "" {
+		command = append(command, "--min-remaining", *minRemainingFlag)
+	}
+	if *shredOldFlag {
+		command = append(command, "--shred-old")
+	}
+
+	var service strings.Builder
+	service.WriteString("# Generated by CertForge\n")
+	service.WriteString("[Unit]\n")
+	service.WriteString("Description=Renew certificates managed by certforge\n")
+	service.WriteString("Wants=network-online.target\n")
+	service.WriteString("After=network-online.target\n\n")
+	service.WriteString("[Service]\n")
+	service.WriteString("Type=oneshot\n")
+	fmt.Fprintf(&service, "ExecStart=%s\n", strings.Join(command, " "))
+	if *userFlag != "root" {
+		fmt.Fprintf(&service, "User=%s\n", *userFlag)
+		// Answering HTTP-01 and TLS-ALPN-01 challenges binds ports 80 and 443
+		if bindsPorts {
+			service.WriteString("AmbientCapabilities=CAP_NET_BIND_SERVICE\n")
+		}
+	}
+	for _, option := range systemdHardening {
+		service.WriteString(option + "\n")
+	}
+	for _, path := range writable {
+		fmt.Fprintf(&service, "ReadWritePaths=%s\n", systemdQuote("-"+path))
+	}
+
+	var timer strings.Builder
+	timer.WriteString("# Generated by CertForge\n")
+	timer.WriteString("[Unit]\n")
+	timer.WriteString("Description=Renew certificates managed by certforge when due\n\n")
+	timer.WriteString("[Timer]\n")
+	fmt.Fprintf(&timer, "OnCalendar=%s\n", *onCalendarFlag)
+	// Spread renewals, as ACME CAs ask, and catch up on runs missed while powered off
+	timer.WriteString("RandomizedDelaySec=1h\n")
+	timer.WriteString("Persistent=true\n\n")
+	timer.WriteString("[Install]\n")
+	timer.WriteString("WantedBy=timers.target\n")
+
+	if err := os.MkdirAll(*outDirFlag, 0755); err != nil {
+		return fmt.Errorf("Error creating output directory: %v", err)
+	}
+	servicePath := filepath.Join(*outDirFlag, *nameFlag+".service")
+	timerPath := filepath.Join(*outDirFlag, *nameFlag+".timer")
+	if err := os.WriteFile(servicePath, []byte(service.String()), 0644); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", servicePath, err)
+	}
+	if err := os.WriteFile(timerPath, []byte(timer.String()), 0644); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", timerPath, err)
+	}
+
+	fmt.Printf("Service saved to: %s\n", servicePath)
+	fmt.Printf("Timer saved to: %s\n", timerPath)
+	fmt.Println("\nTo enable:")
+	if abs, _ := filepath.Abs(*outDirFlag); abs != "/etc/systemd/system" {
+		fmt.Printf("  sudo cp %s %s /etc/systemd/system/\n", servicePath, timerPath)
+	}
+	fmt.Println("  sudo systemctl daemon-reload")
+	fmt.Printf("  sudo systemctl enable --now %s.timer\n", *nameFlag)
+	fmt.Printf("\nTo renew now: sudo systemctl start %s.service\n", *nameFlag)
+	return nil
+}
+
+// systemdWritablePaths returns the directories renewals write to, and whether a challenge server binds a port
+func systemdWritablePaths(cfg *renewalConfig, username string) ([]string, bool, error) {
+	var paths []string
+	add := func(path string) {
+		if path != "" && !contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+
+	bindsPorts := false
+	for _, mc := range cfg.Certificates {
+		add(mc.OutDir)
+		if mc.ACME == nil {
+			continue
+		}
+		add(mc.ACME.Webroot)
+		if mc.ACME.Webroot == "" {
+			bindsPorts = true
+		}
+		if mc.ACME.AccountKey != "" {
+			add(filepath.Dir(mc.ACME.AccountKey))
+			continue
+		}
+
+		// Default account keys are kept in the config directory of the service user
+		account, err := user.Lookup(username)
+		if err != nil {
+			return nil, false, fmt.Errorf("Unknown --user %q: %v", username, err)
+		}
+		add(filepath.Join(account.HomeDir, ".config", "certforge"))
+	}
+	return paths, bindsPorts, nil
+}
+
+// systemdQuote quotes a path for a unit file when it contains spaces
+func systemdQuote(value string) string {
+	if strings.ContainsAny(value, " \t\"") {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
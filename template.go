@@ -0,0 +1,52 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// decodeTemplateData is what a decode --template is executed with, once per file. A file that could not be parsed
+// has Error set and an empty Kind.
+type decodeTemplateData struct {
+	Path  string
+	Error string
+	*certforge.Artifact
+}
+
+// loadTemplate parses a --template file with the certforge template functions
+func loadTemplate(path string) (*template.Template, error) {
+	tmpl, err := template.New(filepath.Base(path)).Funcs(certforge.TemplateFuncs()).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// decodeTemplate executes tmpl for each file in place of the usual display
+func decodeTemplate(tmpl *template.Template, files []string) error {
+	for _, file := range files {
+		data := decodeTemplateData{Path: file, Artifact: &certforge.Artifact{}}
+		raw, err := os.ReadFile(file)
+		if err == nil {
+			var artifact *certforge.Artifact
+			if artifact, err = certforge.ParseAny(raw); err == nil {
+				data.Artifact = artifact
+			}
+		}
+		if err != nil {
+			data.Error = err.Error()
+		}
+		if err := tmpl.Execute(os.Stdout, data); err != nil {
+			return fmt.Errorf("Failed to execute template for %s: %v", file, err)
+		}
+	}
+	return nil
+}
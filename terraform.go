@@ -0,0 +1,242 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// terraformIdentifier matches the names Terraform allows for variables
+var terraformIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// terraformVariable is one value passed to Terraform, named after the hashicorp/tls attribute it replaces
+type terraformVariable struct {
+	Name        string
+	Description string
+	Value       string
+	Sensitive   bool
+}
+
+// runExportTerraform implements export terraform
+func runExportTerraform(args []string) error {
+	fs := flag.NewFlagSet("export terraform", flag.ExitOnError)
+	certFlag := fs.String("cert", "", "Certificate to export")
+	keyFlag := fs.String("key", "", "Private key to export")
+	chainFlag := fs.String("chain", "", "Intermediate and root certificates, exported as ca_cert_pem")
+	csrFlag := fs.String("csr", "", "Certificate signing request to export")
+	nameFlag := fs.String("name", "tls", "Prefix of the variable names and files")
+	formatFlag := fs.String("format", "tfvars", "Format of the values: tfvars or json")
+	outDirFlag := fs.String("out-dir", ".", "Directory of the Terraform configuration")
+	passinFlag := fs.String("passin", "", "Passphrase source for an encrypted private key (pass:, env:, file:, or stdin)")
+	parseArgs(fs, args)
+
+	if *certFlag == "" && *keyFlag == "" && *csrFlag == "" {
+		return fmt.Errorf("export terraform requires --cert, --key, or --csr")
+	}
+	if *chainFlag != "" && *certFlag == "" {
+		return fmt.Errorf("--chain requires --cert")
+	}
+	if !terraformIdentifier.MatchString(*nameFlag) {
+		return fmt.Errorf("Invalid --name %q: use letters, digits, '_', and '-', starting with a letter or '_'", *nameFlag)
+	}
+	format := strings.ToLower(*formatFlag)
+	if format != "tfvars" && format != "json" {
+		return fmt.Errorf("Invalid --format %q (use tfvars or json)", *formatFlag)
+	}
+
+	var variables []terraformVariable
+	add := func(attribute, description, value string, sensitive bool) {
+		variables = append(variables, terraformVariable{*nameFlag + "_" + attribute, description, value, sensitive})
+	}
+
+	var key crypto.PrivateKey
+	if *keyFlag != "" {
+		var password string
+		if *passinFlag != "" {
+			var err error
+			if password, err = readPassphrase(*passinFlag); err != nil {
+				return err
+			}
+		}
+		var err error
+		if key, err = readPrivateKey(*keyFlag, password); err != nil {
+			return err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("Unsupported private key in %s", *keyFlag)
+		}
+
+		// tls_private_key writes RSA and ECDSA keys in their traditional encodings
+		var block *pem.Block
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+		case *ecdsa.PrivateKey:
+			der, err := x509.MarshalECPrivateKey(k)
+			if err != nil {
+				return fmt.Errorf("Failed to encode private key: %v", err)
+			}
+			block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+		}
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("Failed to encode private key: %v", err)
+		}
+		pkcs8PEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+		if block != nil {
+			add("private_key_pem", "Private key in PEM format", string(pem.EncodeToMemory(block)), true)
+		} else {
+			add("private_key_pem", "Private key in PEM format", pkcs8PEM, true)
+		}
+		add("private_key_pem_pkcs8", "Private key in PKCS#8 PEM format", pkcs8PEM, true)
+
+		spki, err := x509.MarshalPKIXPublicKey(signer.Public())
+		if err != nil {
+			return fmt.Errorf("Failed to encode public key: %v", err)
+		}
+		add("public_key_pem", "Public key in PEM format", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: spki})), false)
+		if sshKey, err := ssh.NewPublicKey(signer.Public()); err == nil {
+			add("public_key_openssh", "Public key in OpenSSH authorized_keys format", string(ssh.MarshalAuthorizedKey(sshKey)), false)
+			add("public_key_fingerprint_sha256", "SHA-256 fingerprint of the public key in OpenSSH format", ssh.FingerprintSHA256(sshKey), false)
+		}
+	}
+
+	if *certFlag != "" {
+		certs, err := readCertificates(*certFlag)
+		if err != nil {
+			return err
+		}
+		cert := certs[0]
+		if signer, ok := key.(crypto.Signer); ok && !certforge.SamePublicKey(cert.PublicKey, signer.Public()) {
+			return fmt.Errorf("Private key %s does not match certificate %s", *keyFlag, *certFlag)
+		}
+		chain := certs[1:]
+		if *chainFlag != "" {
+			extra, err := readCertificates(*chainFlag)
+			if err != nil {
+				return err
+			}
+			for _, c := range extra {
+				if !c.Equal(cert) && !containsCertificate(chain, c) {
+					chain = append(chain, c)
+				}
+			}
+		}
+		if chain, err = orderIssuerChain(cert, chain); err != nil {
+			return err
+		}
+
+		add("cert_pem", "Certificate in PEM format", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})), false)
+		if len(chain) > 0 {
+			var buf bytes.Buffer
+			for _, c := range chain {
+				pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+			}
+			add("ca_cert_pem", "Issuer chain of the certificate in PEM format, from the issuer up to the root", buf.String(), false)
+		}
+		add("validity_start_time", "Start of the certificate's validity in RFC 3339 format", cert.NotBefore.UTC().Format(time.RFC3339), false)
+		add("validity_end_time", "End of the certificate's validity in RFC 3339 format", cert.NotAfter.UTC().Format(time.RFC3339), false)
+	}
+
+	if *csrFlag != "" {
+		csr, err := readCSR(*csrFlag)
+		if err != nil {
+			return err
+		}
+		add("cert_request_pem", "Certificate signing request in PEM format", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})), false)
+	}
+
+	if err := os.MkdirAll(*outDirFlag, 0755); err != nil {
+		return fmt.Errorf("Error creating output directory: %v", err)
+	}
+
+	// Variable declarations go in a .tf file, and the values in a file Terraform loads automatically
+	declPath := filepath.Join(*outDirFlag, *nameFlag+"_variables.tf")
+	if err := os.WriteFile(declPath, terraformDeclarations(variables), 0644); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", declPath, err)
+	}
+	valuesPath := filepath.Join(*outDirFlag, *nameFlag+".auto.tfvars")
+	var values []byte
+	if format == "json" {
+		valuesPath += ".json"
+		object := make(map[string]string)
+		for _, v := range variables {
+			object[v.Name] = v.Value
+		}
+		data, err := json.MarshalIndent(object, "", "  ")
+		if err != nil {
+			return err
+		}
+		values = append(data, '\n')
+	} else {
+		values = terraformValues(variables)
+	}
+	mode := os.FileMode(0644)
+	if key != nil {
+		mode = 0600
+	}
+	if err := os.WriteFile(valuesPath, values, mode); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", valuesPath, err)
+	}
+
+	fmt.Printf("Wrote %s\n", declPath)
+	fmt.Printf("Wrote %s\n", valuesPath)
+	for _, v := range variables {
+		fmt.Printf("  var.%s\n", v.Name)
+	}
+	if key != nil {
+		fmt.Printf("\nNote: %s holds the private key; keep it out of version control.\n", valuesPath)
+	}
+	return nil
+}
+
+// terraformDeclarations returns variable blocks declaring variables
+func terraformDeclarations(variables []terraformVariable) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by CertForge\n")
+	for _, v := range variables {
+		fmt.Fprintf(&buf, "\nvariable %q {\n", v.Name)
+		fmt.Fprintf(&buf, "  description = %q\n", v.Description)
+		buf.WriteString("  type        = string\n")
+		if v.Sensitive {
+			buf.WriteString("  sensitive   = true\n")
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.Bytes()
+}
+
+// terraformValues returns a .tfvars file assigning variables, with multi-line values as heredocs
+func terraformValues(variables []terraformVariable) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by CertForge\n\n")
+	for _, v := range variables {
+		if strings.Contains(v.Value, "\n") {
+			// A plain heredoc keeps the value byte for byte, including its final newline
+			fmt.Fprintf(&buf, "%s = <<EOT\n%sEOT\n", v.Name, v.Value)
+		} else {
+			fmt.Fprintf(&buf, "%s = %q\n", v.Name, v.Value)
+		}
+	}
+	return buf.Bytes()
+}
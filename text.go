@@ -0,0 +1,181 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// signatureAlgorithmNames maps signature algorithms to the names OpenSSL prints
+var signatureAlgorithmNames = map[x509.SignatureAlgorithm]string{
+	x509.MD2WithRSA:       "md2WithRSAEncryption",
+	x509.MD5WithRSA:       "md5WithRSAEncryption",
+	x509.SHA1WithRSA:      "sha1WithRSAEncryption",
+	x509.SHA256WithRSA:    "sha256WithRSAEncryption",
+	x509.SHA384WithRSA:    "sha384WithRSAEncryption",
+	x509.SHA512WithRSA:    "sha512WithRSAEncryption",
+	x509.DSAWithSHA1:      "dsaWithSHA1",
+	x509.DSAWithSHA256:    "dsa_with_SHA256",
+	x509.ECDSAWithSHA1:    "ecdsa-with-SHA1",
+	x509.ECDSAWithSHA256:  "ecdsa-with-SHA256",
+	x509.ECDSAWithSHA384:  "ecdsa-with-SHA384",
+	x509.ECDSAWithSHA512:  "ecdsa-with-SHA512",
+	x509.SHA256WithRSAPSS: "rsassaPss (SHA-256)",
+	x509.SHA384WithRSAPSS: "rsassaPss (SHA-384)",
+	x509.SHA512WithRSAPSS: "rsassaPss (SHA-512)",
+	x509.PureEd25519:      "ED25519",
+}
+
+// textTimeLayout is the date format used by OpenSSL's text output
+const textTimeLayout = "Jan _2 15:04:05 2006 GMT"
+
+// printCertificateText displays a certificate in a layout comparable to `openssl x509 -text`
+func printCertificateText(cert *x509.Certificate) {
+	fmt.Println("Certificate:")
+	fmt.Println("    Data:")
+	fmt.Printf("        Version: %d (0x%x)\n", cert.Version, cert.Version-1)
+	printSerialText(cert.SerialNumber)
+	fmt.Printf("        Signature Algorithm: %s\n", signatureAlgorithmName(cert.SignatureAlgorithm))
+	fmt.Printf("        Issuer: %s\n", formatFullName(cert.Issuer))
+	fmt.Println("        Validity")
+	fmt.Printf("            Not Before: %s\n", cert.NotBefore.UTC().Format(textTimeLayout))
+	fmt.Printf("            Not After : %s\n", cert.NotAfter.UTC().Format(textTimeLayout))
+	fmt.Printf("        Subject: %s\n", formatFullName(cert.Subject))
+	printPublicKeyText(cert.PublicKey, "        ")
+
+	if len(cert.Extensions) > 0 {
+		fmt.Println("        X509v3 extensions:")
+		printExtensionsText(cert.Extensions, "            ")
+	}
+
+	printSignatureText(cert.SignatureAlgorithm, cert.Signature)
+}
+
+// printCSRText displays a CSR in a layout comparable to `openssl req -text`
+func printCSRText(csr *x509.CertificateRequest) {
+	fmt.Println("Certificate Request:")
+	fmt.Println("    Data:")
+	fmt.Printf("        Version: %d (0x%x)\n", csr.Version+1, csr.Version)
+	fmt.Printf("        Subject: %s\n", formatFullName(csr.Subject))
+	printPublicKeyText(csr.PublicKey, "        ")
+
+	fmt.Println("        Attributes:")
+	if len(csr.Extensions) > 0 {
+		fmt.Println("            Requested Extensions:")
+		printExtensionsText(csr.Extensions, "                ")
+	} else {
+		fmt.Println("            (none)")
+	}
+
+	printSignatureText(csr.SignatureAlgorithm, csr.Signature)
+}
+
+// printSerialText prints a serial number as decimal and hex when small, otherwise as colon hex
+func printSerialText(serial *big.Int) {
+	if serial.Sign() >= 0 && serial.BitLen() < 64 {
+		fmt.Printf("        Serial Number: %s (0x%x)\n", serial, serial)
+		return
+	}
+	fmt.Println("        Serial Number:")
+	fmt.Printf("            %s\n", strings.ToLower(colonHex(serial.Bytes())))
+}
+
+// printPublicKeyText prints the Subject Public Key Info block
+func printPublicKeyText(pub any, indent string) {
+	fmt.Printf("%sSubject Public Key Info:\n", indent)
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		fmt.Printf("%s    Public Key Algorithm: rsaEncryption\n", indent)
+		fmt.Printf("%s        Public-Key: (%d bit)\n", indent, key.N.BitLen())
+		fmt.Printf("%s        Modulus:\n", indent)
+		// OpenSSL prefixes a zero byte when the high bit is set
+		modulus := key.N.Bytes()
+		if len(modulus) > 0 && modulus[0]&0x80 != 0 {
+			modulus = append([]byte{0}, modulus...)
+		}
+		for _, line := range hexDumpLines(modulus, 15) {
+			fmt.Printf("%s            %s\n", indent, line)
+		}
+		fmt.Printf("%s        Exponent: %d (0x%x)\n", indent, key.E, key.E)
+
+	case *ecdsa.PublicKey:
+		fmt.Printf("%s    Public Key Algorithm: id-ecPublicKey\n", indent)
+		fmt.Printf("%s        Public-Key: (%d bit)\n", indent, key.Curve.Params().BitSize)
+		fmt.Printf("%s        pub:\n", indent)
+		if ecdhKey, err := key.ECDH(); err == nil {
+			for _, line := range hexDumpLines(ecdhKey.Bytes(), 15) {
+				fmt.Printf("%s            %s\n", indent, line)
+			}
+		}
+		fmt.Printf("%s        ASN1 OID: %s\n", indent, curveOpenSSLName(key.Curve))
+		fmt.Printf("%s        NIST CURVE: %s\n", indent, key.Curve.Params().Name)
+
+	case ed25519.PublicKey:
+		fmt.Printf("%s    Public Key Algorithm: ED25519\n", indent)
+		fmt.Printf("%s        ED25519 Public-Key:\n", indent)
+		fmt.Printf("%s        pub:\n", indent)
+		for _, line := range hexDumpLines(key, 15) {
+			fmt.Printf("%s            %s\n", indent, line)
+		}
+
+	default:
+		fmt.Printf("%s    Public Key Algorithm: %T\n", indent, pub)
+	}
+}
+
+// printExtensionsText prints every extension with its criticality and decoded value
+func printExtensionsText(exts []pkix.Extension, indent string) {
+	for _, ext := range exts {
+		critical := ""
+		if ext.Critical {
+			critical = " critical"
+		}
+		fmt.Printf("%s%s:%s\n", indent, extensionName(ext.Id), critical)
+		lines, _ := describeExtension(ext)
+		for _, line := range lines {
+			fmt.Printf("%s    %s\n", indent, line)
+		}
+	}
+}
+
+// printSignatureText prints the outer signature algorithm and value
+func printSignatureText(alg x509.SignatureAlgorithm, signature []byte) {
+	fmt.Printf("    Signature Algorithm: %s\n", signatureAlgorithmName(alg))
+	fmt.Println("    Signature Value:")
+	for _, line := range hexDumpLines(signature, 18) {
+		fmt.Printf("        %s\n", line)
+	}
+}
+
+// signatureAlgorithmName returns the OpenSSL name of a signature algorithm
+func signatureAlgorithmName(alg x509.SignatureAlgorithm) string {
+	if name, ok := signatureAlgorithmNames[alg]; ok {
+		return name
+	}
+	return alg.String()
+}
+
+// curveOpenSSLName returns the ASN.1 name OpenSSL uses for a named curve
+func curveOpenSSLName(curve elliptic.Curve) string {
+	switch curve {
+	case elliptic.P224():
+		return "secp224r1"
+	case elliptic.P256():
+		return "prime256v1"
+	case elliptic.P384():
+		return "secp384r1"
+	case elliptic.P521():
+		return "secp521r1"
+	}
+	return curve.Params().Name
+}
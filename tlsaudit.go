@@ -0,0 +1,169 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// tlsProbeTarget describes where and how the TLS audit connects
+type tlsProbeTarget struct {
+	Address    string
+	ServerName string
+	Timeout    time.Duration
+}
+
+// probe performs a handshake with the given settings and returns the negotiated state
+func (t tlsProbeTarget) probe(ctx context.Context, config *tls.Config) (tls.ConnectionState, error) {
+	config.ServerName = t.ServerName
+	config.InsecureSkipVerify = true
+
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: t.Timeout}, Config: config}
+	netConn, err := dialer.DialContext(ctx, "tcp", t.Address)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	conn := netConn.(*tls.Conn)
+	defer conn.Close()
+
+	// TLS 1.3 session tickets arrive after the handshake, so read briefly to receive them
+	if config.ClientSessionCache != nil {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		conn.Read(make([]byte, 1))
+	}
+	return conn.ConnectionState(), nil
+}
+
+// auditedVersions lists the protocol versions probed, oldest first
+var auditedVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// runTLSAudit probes the protocol versions, cipher suites, and session resumption of a server, returning an error
+// if ctx ended the audit before it was complete
+func runTLSAudit(ctx context.Context, target tlsProbeTarget) error {
+	fmt.Println("=== TLS Configuration Audit ===")
+	fmt.Println()
+
+	var findings []string
+
+	fmt.Println("Protocols:")
+	supported := make(map[uint16]bool)
+	for _, version := range auditedVersions {
+		_, err := target.probe(ctx, &tls.Config{MinVersion: version, MaxVersion: version})
+		supported[version] = err == nil
+
+		status := "not supported"
+		if supported[version] {
+			status = "supported"
+		}
+		if version < tls.VersionTLS12 && supported[version] {
+			status = colorize("SUPPORTED (deprecated)", expiryExpired)
+			findings = append(findings, fmt.Sprintf("%s is enabled but deprecated (RFC 8996)", tls.VersionName(version)))
+		}
+		fmt.Printf("  %s: %s\n", tls.VersionName(version), status)
+	}
+	if !supported[tls.VersionTLS13] {
+		findings = append(findings, "TLS 1.3 is not supported")
+	}
+
+	fmt.Println("\nCipher Suites:")
+	for _, version := range auditedVersions {
+		if !supported[version] {
+			continue
+		}
+		fmt.Printf("  %s:\n", tls.VersionName(version))
+
+		// TLS 1.3 suites cannot be restricted by the client, so only the negotiated one is known
+		if version == tls.VersionTLS13 {
+			if state, err := target.probe(ctx, &tls.Config{MinVersion: version, MaxVersion: version}); err == nil {
+				fmt.Printf("    %s (negotiated)\n", tls.CipherSuiteName(state.CipherSuite))
+			}
+			continue
+		}
+
+		for _, suite := range auditedCipherSuites(version) {
+			_, err := target.probe(ctx, &tls.Config{MinVersion: version, MaxVersion: version, CipherSuites: []uint16{suite.ID}})
+			if err != nil {
+				continue
+			}
+			problem := cipherSuiteProblem(suite)
+			if problem == "" {
+				fmt.Printf("    %s\n", suite.Name)
+				continue
+			}
+			fmt.Printf("    %s %s\n", suite.Name, colorize("("+problem+")", expiryWarning))
+			findings = append(findings, fmt.Sprintf("%s accepts %s (%s)", tls.VersionName(version), suite.Name, problem))
+		}
+	}
+
+	fmt.Print("\nSession Resumption: ")
+	if tlsResumes(ctx, target) {
+		fmt.Println("supported")
+	} else {
+		fmt.Println(colorize("not supported", expiryWarning))
+		findings = append(findings, "Session resumption is not supported")
+	}
+	// Probes cut short look like unsupported settings, so an interrupted audit reports no findings
+	if ctx.Err() != nil {
+		return fmt.Errorf("TLS audit interrupted, results are incomplete: %v", ctx.Err())
+	}
+
+	fmt.Println()
+	if len(findings) == 0 {
+		fmt.Println(colorize("No TLS configuration problems found", expiryOK))
+	} else {
+		fmt.Printf("Findings (%d):\n", len(findings))
+		for _, finding := range findings {
+			fmt.Printf("  - %s\n", finding)
+		}
+	}
+	fmt.Println("\nNote: only cipher suites implemented by Go's crypto/tls can be probed.")
+	return nil
+}
+
+// auditedCipherSuites returns every secure and insecure suite Go can offer for version
+func auditedCipherSuites(version uint16) []*tls.CipherSuite {
+	var suites []*tls.CipherSuite
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		for _, v := range suite.SupportedVersions {
+			if v == version {
+				suites = append(suites, suite)
+				break
+			}
+		}
+	}
+	return suites
+}
+
+// cipherSuiteProblem describes why a cipher suite is weak, or returns "" if it is acceptable
+func cipherSuiteProblem(suite *tls.CipherSuite) string {
+	switch {
+	case strings.Contains(suite.Name, "_RC4_"):
+		return "weak: RC4"
+	case strings.Contains(suite.Name, "_3DES_"):
+		return "weak: 3DES"
+	case strings.HasPrefix(suite.Name, "TLS_RSA_"):
+		return "no forward secrecy"
+	case strings.Contains(suite.Name, "_CBC_"):
+		return "CBC mode"
+	case suite.Insecure:
+		return "insecure"
+	}
+	return ""
+}
+
+// tlsResumes reports whether a second connection can resume the first one's session
+func tlsResumes(ctx context.Context, target tlsProbeTarget) bool {
+	cache := tls.NewLRUClientSessionCache(1)
+	if _, err := target.probe(ctx, &tls.Config{ClientSessionCache: cache}); err != nil {
+		return false
+	}
+	state, err := target.probe(ctx, &tls.Config{ClientSessionCache: cache})
+	return err == nil && state.DidResume
+}
@@ -0,0 +1,434 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// tpmKeyPEMType is the PEM type of TPM key files, which hold the key wrapped by the TPM rather than the key itself
+const tpmKeyPEMType = "TSS2 PRIVATE KEY"
+
+// tpmLoadableKeyOID marks a TPM key file holding a key to load under its parent
+var tpmLoadableKeyOID = asn1.ObjectIdentifier{2, 23, 133, 10, 1, 3}
+
+// tpmKeyFile is the ASN.1 content of a TPM key file. Keys with policies or imported secrets are not supported.
+type tpmKeyFile struct {
+	Type       asn1.ObjectIdentifier
+	EmptyAuth  bool `asn1:"explicit,tag:0,optional"`
+	Parent     int64
+	PublicKey  []byte
+	PrivateKey []byte
+}
+
+// tpmKey is a private key resident in the TPM. The file holding it is encrypted by the TPM's storage key, so the
+// private half never leaves the TPM in the clear and the key cannot be used on another machine.
+type tpmKey struct {
+	public crypto.PublicKey
+	pub    tpm2.TPM2BPublic
+	priv   tpm2.TPM2BPrivate
+
+	// mu guards the TPM connection. The first signature opens the TPM, creates the storage key, and loads the key
+	// under it; all three are kept for later signatures until Close.
+	mu     sync.Mutex
+	tpm    transport.TPMCloser
+	parent tpm2.NamedHandle
+	handle tpm2.NamedHandle
+}
+
+// openTPM opens the TPM; tests replace it with a simulator
+var openTPM = openTPMDevice
+
+// tpmCommands are the subcommands of the tpm command
+var tpmCommands = map[string]func(args []string) error{
+	"keygen": runTPMKeygen,
+}
+
+// runTPM implements the tpm command, which dispatches on the subcommand
+func runTPM(args []string) error {
+	var names []string
+	for name := range tpmCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return fmt.Errorf("tpm requires a subcommand: %s", strings.Join(names, ", "))
+	}
+	command, ok := tpmCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown tpm subcommand %q (use %s)", args[0], strings.Join(names, ", "))
+	}
+	return command(args[1:])
+}
+
+// runTPMKeygen implements tpm keygen, which creates a non-exportable key in the TPM
+func runTPMKeygen(args []string) error {
+	fs := flag.NewFlagSet("tpm keygen", flag.ExitOnError)
+	typeFlag := fs.String("type", "ecdsa", "Key type: ecdsa (P-256) or rsa (2048 bits)")
+	outFlag := fs.String("out", "tpm.key", "TPM key file to write")
+	parseArgs(fs, args)
+
+	if _, err := os.Stat(*outFlag); err == nil {
+		return fmt.Errorf("%s already exists; remove it or choose another --out", *outFlag)
+	}
+	key, err := generateTPMKey(certforge.KeyType(strings.ToLower(*typeFlag)))
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	block, err := key.pemBlock()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*outFlag, pem.EncodeToMemory(block), 0600); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", *outFlag, err)
+	}
+	auditLog(auditLogKey(key.Public(), *outFlag))
+
+	spki, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return fmt.Errorf("Failed to encode public key: %v", err)
+	}
+	fmt.Println("=== TPM Key ===")
+	fmt.Printf("Key file: %s\n", *outFlag)
+	fmt.Printf("Key: %s, resident in the TPM\n", certforge.PublicKeyDescription(key.Public()))
+	fmt.Printf("Public key SHA-256: %s\n", certforge.Fingerprint(spki))
+	fmt.Println("The file only works with this machine's TPM. Request a certificate for it with --key, for example:")
+	fmt.Printf("  certforge acme --key %s --domain <name> --email <addr> --agree-tos\n", *outFlag)
+	return nil
+}
+
+// generateTPMKey creates an ECDSA P-256 or RSA 2048 signing key in the TPM. The key keeps the TPM open and stays
+// loaded for signing until Close.
+func generateTPMKey(keyType certforge.KeyType) (*tpmKey, error) {
+	var template tpm2.TPMTPublic
+	switch keyType {
+	case certforge.ECDSA:
+		template = tpmECCSigningTemplate
+	case certforge.RSA:
+		template = tpmRSASigningTemplate
+	default:
+		return nil, fmt.Errorf("Invalid TPM key type %q (use ecdsa or rsa)", keyType)
+	}
+	bits := 256
+	if keyType == certforge.RSA {
+		bits = 2048
+	}
+	if err := certforge.CheckKeyType(keyType, bits); err != nil {
+		return nil, err
+	}
+
+	k := &tpmKey{}
+	if err := k.openLocked(); err != nil {
+		return nil, err
+	}
+	created, err := tpm2.Create{
+		ParentHandle: tpm2.AuthHandle{Handle: k.parent.Handle, Name: k.parent.Name, Auth: tpm2.PasswordAuth(nil)},
+		InPublic:     tpm2.New2B(template),
+	}.Execute(k.tpm)
+	if err != nil {
+		k.Close()
+		return nil, tpmError("Create", err)
+	}
+	if k.public, err = parseTPMPublic(created.OutPublic); err != nil {
+		k.Close()
+		return nil, err
+	}
+	k.pub, k.priv = created.OutPublic, created.OutPrivate
+	if err := k.loadLocked(); err != nil {
+		k.Close()
+		return nil, err
+	}
+	return k, nil
+}
+
+// parseTPMKey parses the DER content of a TPM key file. The TPM is opened when the key first signs.
+func parseTPMKey(der []byte) (*tpmKey, error) {
+	var file tpmKeyFile
+	if rest, err := asn1.Unmarshal(der, &file); err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("Unsupported TPM key file: only keys without policies or secrets can be used")
+	}
+	if !file.Type.Equal(tpmLoadableKeyOID) {
+		return nil, fmt.Errorf("Unsupported TPM key file type %s", file.Type)
+	}
+	if file.Parent != int64(tpm2.TPMRHOwner) {
+		return nil, fmt.Errorf("Unsupported TPM key parent 0x%x: only keys created under the owner hierarchy can be used", file.Parent)
+	}
+	// Unmarshal ignores trailing bytes, so the blobs are checked to be exactly one TPM2B each
+	pub, err := tpm2.Unmarshal[tpm2.TPM2BPublic](file.PublicKey)
+	if err == nil && len(tpm2.Marshal(*pub)) != len(file.PublicKey) {
+		err = fmt.Errorf("%d bytes of trailing data", len(file.PublicKey)-len(tpm2.Marshal(*pub)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TPM public key: %v", err)
+	}
+	priv, err := tpm2.Unmarshal[tpm2.TPM2BPrivate](file.PrivateKey)
+	if err == nil && len(tpm2.Marshal(*priv)) != len(file.PrivateKey) {
+		err = fmt.Errorf("%d bytes of trailing data", len(file.PrivateKey)-len(tpm2.Marshal(*priv)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TPM private key: %v", err)
+	}
+	public, err := parseTPMPublic(*pub)
+	if err != nil {
+		return nil, err
+	}
+	return &tpmKey{public: public, pub: *pub, priv: *priv}, nil
+}
+
+// pemBlock encodes the key as a TPM key file
+func (k *tpmKey) pemBlock() (*pem.Block, error) {
+	der, err := asn1.Marshal(tpmKeyFile{
+		Type:       tpmLoadableKeyOID,
+		EmptyAuth:  true,
+		Parent:     int64(tpm2.TPMRHOwner),
+		PublicKey:  tpm2.Marshal(k.pub),
+		PrivateKey: tpm2.Marshal(k.priv),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode TPM key: %v", err)
+	}
+	return &pem.Block{Type: tpmKeyPEMType, Bytes: der}, nil
+}
+
+// Public returns the public half of the key
+func (k *tpmKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign has the TPM sign a digest: ECDSA keys with ECDSA, and RSA keys with PKCS #1 v1.5
+func (k *tpmKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var hashAlg tpm2.TPMIAlgHash
+	switch opts.HashFunc() {
+	case crypto.SHA256:
+		hashAlg = tpm2.TPMAlgSHA256
+	case crypto.SHA384:
+		hashAlg = tpm2.TPMAlgSHA384
+	case crypto.SHA512:
+		hashAlg = tpm2.TPMAlgSHA512
+	default:
+		return nil, fmt.Errorf("TPM keys sign SHA-256, SHA-384, or SHA-512 digests, not %v", opts.HashFunc())
+	}
+	scheme := tpm2.TPMTSigScheme{
+		Scheme:  tpm2.TPMAlgECDSA,
+		Details: tpm2.NewTPMUSigScheme(tpm2.TPMAlgECDSA, &tpm2.TPMSSchemeHash{HashAlg: hashAlg}),
+	}
+	if _, ok := k.public.(*rsa.PublicKey); ok {
+		if _, pss := opts.(*rsa.PSSOptions); pss {
+			return nil, fmt.Errorf("TPM RSA keys sign with PKCS #1 v1.5, not PSS")
+		}
+		scheme = tpm2.TPMTSigScheme{
+			Scheme:  tpm2.TPMAlgRSASSA,
+			Details: tpm2.NewTPMUSigScheme(tpm2.TPMAlgRSASSA, &tpm2.TPMSSchemeHash{HashAlg: hashAlg}),
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.tpm == nil {
+		if err := k.openLocked(); err != nil {
+			return nil, err
+		}
+		if err := k.loadLocked(); err != nil {
+			k.closeLocked()
+			return nil, err
+		}
+	}
+	signed, err := tpm2.Sign{
+		KeyHandle: tpm2.AuthHandle{Handle: k.handle.Handle, Name: k.handle.Name, Auth: tpm2.PasswordAuth(nil)},
+		Digest:    tpm2.TPM2BDigest{Buffer: digest},
+		InScheme:  scheme,
+		// An unrestricted key signs any digest, so the validation ticket is the null ticket
+		Validation: tpm2.TPMTTKHashCheck{Tag: tpm2.TPMSTHashCheck, Hierarchy: tpm2.TPMRHNull},
+	}.Execute(k.tpm)
+	if err != nil {
+		return nil, tpmError("Sign", err)
+	}
+
+	switch signed.Signature.SigAlg {
+	case tpm2.TPMAlgECDSA:
+		sig, err := signed.Signature.Signature.ECDSA()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid TPM2_Sign response: %v", err)
+		}
+		r := new(big.Int).SetBytes(sig.SignatureR.Buffer)
+		s := new(big.Int).SetBytes(sig.SignatureS.Buffer)
+		return asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	case tpm2.TPMAlgRSASSA:
+		sig, err := signed.Signature.Signature.RSASSA()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid TPM2_Sign response: %v", err)
+		}
+		return sig.Sig.Buffer, nil
+	}
+	return nil, fmt.Errorf("Invalid TPM2_Sign response: signature algorithm 0x%x", signed.Signature.SigAlg)
+}
+
+// Close unloads the key and the storage key and closes the TPM. A later signature opens it again.
+func (k *tpmKey) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.closeLocked()
+}
+
+// openLocked opens the TPM and creates the storage key that wraps certforge's keys. It is derived from the owner
+// hierarchy's seed with the ECC P-256 SRK template of the TCG provisioning guidance, so the same key is created
+// every time and nothing needs to be persisted in the TPM.
+func (k *tpmKey) openLocked() error {
+	tpm, err := openTPM()
+	if err != nil {
+		return err
+	}
+	primary, err := tpm2.CreatePrimary{
+		PrimaryHandle: tpm2.AuthHandle{Handle: tpm2.TPMRHOwner, Auth: tpm2.PasswordAuth(nil)},
+		InPublic:      tpm2.New2B(tpm2.ECCSRKTemplate),
+	}.Execute(tpm)
+	if err != nil {
+		tpm.Close()
+		return tpmError("CreatePrimary", err)
+	}
+	k.tpm = tpm
+	k.parent = tpm2.NamedHandle{Handle: primary.ObjectHandle, Name: primary.Name}
+	return nil
+}
+
+// loadLocked loads the key under the storage key
+func (k *tpmKey) loadLocked() error {
+	loaded, err := tpm2.Load{
+		ParentHandle: tpm2.AuthHandle{Handle: k.parent.Handle, Name: k.parent.Name, Auth: tpm2.PasswordAuth(nil)},
+		InPrivate:    k.priv,
+		InPublic:     k.pub,
+	}.Execute(k.tpm)
+	if err != nil {
+		return tpmError("Load", err)
+	}
+	k.handle = tpm2.NamedHandle{Handle: loaded.ObjectHandle, Name: loaded.Name}
+	return nil
+}
+
+// closeLocked flushes the transient objects the key loaded and closes the TPM
+func (k *tpmKey) closeLocked() error {
+	if k.tpm == nil {
+		return nil
+	}
+	for _, handle := range []tpm2.NamedHandle{k.handle, k.parent} {
+		if handle.Handle != 0 {
+			tpm2.FlushContext{FlushHandle: handle.Handle}.Execute(k.tpm)
+		}
+	}
+	err := k.tpm.Close()
+	k.tpm, k.parent, k.handle = nil, tpm2.NamedHandle{}, tpm2.NamedHandle{}
+	return err
+}
+
+// closeTPMKey closes the TPM of a TPM key, flushing the objects it loaded, and ignores other keys. Commands defer it
+// once they hold a key from --key or --key-type tpm.
+func closeTPMKey(key crypto.PrivateKey) {
+	if k, ok := key.(*tpmKey); ok {
+		k.Close()
+	}
+}
+
+// releaseKey closes the TPM of a TPM key, or wipes a software key, once a command is done signing with it
+func releaseKey(key crypto.PrivateKey) {
+	if k, ok := key.(*tpmKey); ok {
+		k.Close()
+		return
+	}
+	certforge.ZeroizeKey(key)
+}
+
+// tpmError describes a failed TPM command, naming the errors an administrator can act on
+func tpmError(command string, err error) error {
+	var reason string
+	switch {
+	case errors.Is(err, tpm2.TPMRCLockout):
+		reason = "the TPM is locked out after too many failed authorizations (TPM_RC_LOCKOUT)"
+	case errors.Is(err, tpm2.TPMRCAuthFail), errors.Is(err, tpm2.TPMRCBadAuth):
+		reason = "the owner hierarchy has a password, which certforge does not use"
+	case errors.Is(err, tpm2.TPMRCObjectMemory):
+		reason = "the TPM is out of object memory (TPM_RC_OBJECT_MEMORY)"
+	case errors.Is(err, tpm2.TPMRCValue), errors.Is(err, tpm2.TPMRCCurve), errors.Is(err, tpm2.TPMRCKeySize):
+		reason = fmt.Sprintf("the TPM does not support this key (%v)", err)
+	default:
+		reason = err.Error()
+	}
+	return fmt.Errorf("TPM2_%s failed: %s", command, reason)
+}
+
+// tpmSigningAttributes are the attributes of certforge's keys: created in and bound to this TPM, usable without a
+// password, and only for signing
+var tpmSigningAttributes = tpm2.TPMAObject{
+	FixedTPM:            true,
+	FixedParent:         true,
+	SensitiveDataOrigin: true,
+	UserWithAuth:        true,
+	NoDA:                true,
+	SignEncrypt:         true,
+}
+
+// tpmECCSigningTemplate is the template of an ECDSA P-256 key; the hash is chosen when signing
+var tpmECCSigningTemplate = tpm2.TPMTPublic{
+	Type:             tpm2.TPMAlgECC,
+	NameAlg:          tpm2.TPMAlgSHA256,
+	ObjectAttributes: tpmSigningAttributes,
+	Parameters:       tpm2.NewTPMUPublicParms(tpm2.TPMAlgECC, &tpm2.TPMSECCParms{CurveID: tpm2.TPMECCNistP256}),
+	Unique:           tpm2.NewTPMUPublicID(tpm2.TPMAlgECC, &tpm2.TPMSECCPoint{}),
+}
+
+// tpmRSASigningTemplate is the template of an RSA 2048 key with the default exponent
+var tpmRSASigningTemplate = tpm2.TPMTPublic{
+	Type:             tpm2.TPMAlgRSA,
+	NameAlg:          tpm2.TPMAlgSHA256,
+	ObjectAttributes: tpmSigningAttributes,
+	Parameters:       tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA, &tpm2.TPMSRSAParms{KeyBits: 2048}),
+	Unique:           tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{}),
+}
+
+// parseTPMPublic returns the public key of a TPM2B_PUBLIC
+func parseTPMPublic(blob tpm2.TPM2BPublic) (crypto.PublicKey, error) {
+	public, err := blob.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TPM public key: %v", err)
+	}
+	if public.Type == tpm2.TPMAlgECC {
+		params, err := public.Parameters.ECCDetail()
+		if err != nil || params.CurveID != tpm2.TPMECCNistP256 {
+			return nil, fmt.Errorf("Unsupported TPM key: only ECDSA P-256 and RSA keys can be used")
+		}
+	} else if public.Type != tpm2.TPMAlgRSA {
+		return nil, fmt.Errorf("Unsupported TPM key: only ECDSA P-256 and RSA keys can be used")
+	}
+	key, err := tpm2.Pub(*public)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TPM public key: %v", err)
+	}
+	if ecKey, ok := key.(*ecdsa.PublicKey); ok {
+		// ECDSAPub does not check the point is on the curve
+		if _, err := ecKey.ECDH(); err != nil {
+			return nil, fmt.Errorf("Invalid TPM public key: %v", err)
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,33 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpm2/transport/linuxtpm"
+)
+
+// openTPMDevice opens the kernel's TPM resource manager, which lets several processes use the TPM, or the TPM itself
+func openTPMDevice() (transport.TPMCloser, error) {
+	var err error
+	for _, path := range []string{"/dev/tpmrm0", "/dev/tpm0"} {
+		var tpm transport.TPMCloser
+		if tpm, err = linuxtpm.Open(path); err == nil {
+			return tpm, nil
+		}
+		if os.IsPermission(err) {
+			return nil, fmt.Errorf("Failed to open TPM: %v (add the user to the tss group)", err)
+		}
+	}
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("No TPM found: neither /dev/tpmrm0 nor /dev/tpm0 exists")
+	}
+	return nil, fmt.Errorf("Failed to open TPM: %v", err)
+}
@@ -0,0 +1,18 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !linux && !windows
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// openTPMDevice fails, as TPM keys are only supported where certforge can reach the TPM
+func openTPMDevice() (transport.TPMCloser, error) {
+	return nil, fmt.Errorf("TPM keys are supported on Linux and Windows")
+}
@@ -0,0 +1,254 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build cgo
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"strings"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpm2/transport/simulator"
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// simulatedTPM is a connection to the simulator that survives Close, so a key can be closed and loaded again under
+// the same owner seed. It counts closes.
+type simulatedTPM struct {
+	transport.TPM
+	closes *int
+}
+
+func (s simulatedTPM) Close() error {
+	*s.closes++
+	return nil
+}
+
+// useSimulator points openTPM at a TPM simulator for the rest of the test and returns the number of opens and closes
+func useSimulator(t *testing.T) (opens, closes *int) {
+	t.Helper()
+	sim, err := simulator.OpenSimulator()
+	if err != nil {
+		t.Skipf("No TPM simulator: %v", err)
+	}
+	opens, closes = new(int), new(int)
+	saved := openTPM
+	openTPM = func() (transport.TPMCloser, error) {
+		*opens++
+		return simulatedTPM{TPM: sim, closes: closes}, nil
+	}
+	t.Cleanup(func() {
+		openTPM = saved
+		sim.Close()
+	})
+	return opens, closes
+}
+
+// loadedObjects returns the number of transient objects loaded in the TPM
+func loadedObjects(t *testing.T, tpm transport.TPM) int {
+	t.Helper()
+	caps, err := tpm2.GetCapability{
+		Capability:    tpm2.TPMCapHandles,
+		Property:      uint32(tpm2.TPMHTTransient) << 24,
+		PropertyCount: 64,
+	}.Execute(tpm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handles, err := caps.CapabilityData.Data.Handles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(handles.Handle)
+}
+
+// verifyTPMSignature signs a digest with the key and checks the signature against its public key
+func verifyTPMSignature(t *testing.T, key crypto.Signer) {
+	t.Helper()
+	digest := sha256.Sum256([]byte("certforge"))
+	sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch pub := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			t.Error("the ECDSA signature does not verify")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			t.Errorf("the RSA signature does not verify: %v", err)
+		}
+	default:
+		t.Fatalf("unexpected public key %T", pub)
+	}
+}
+
+func TestTPMKey(t *testing.T) {
+	useSimulator(t)
+	tests := []struct {
+		keyType certforge.KeyType
+		want    string
+	}{
+		{certforge.ECDSA, "ECDSA P-256"},
+		{certforge.RSA, "RSA 2048"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.keyType), func(t *testing.T) {
+			key, err := generateTPMKey(tt.keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer key.Close()
+			if got := certforge.PublicKeyDescription(key.Public()); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("got a %s key, want %s", got, tt.want)
+			}
+			verifyTPMSignature(t, key)
+
+			// The key file loads under a fresh connection and signs with the same key
+			block, err := key.pemBlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block.Type != tpmKeyPEMType {
+				t.Errorf("got PEM type %s", block.Type)
+			}
+			key.Close()
+			parsed, err := parseTPMKey(block.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer parsed.Close()
+			if !certforge.SamePublicKey(parsed.Public(), key.Public()) {
+				t.Error("the key file holds a different key")
+			}
+			verifyTPMSignature(t, parsed)
+		})
+	}
+}
+
+func TestTPMKeySignReusesHandle(t *testing.T) {
+	opens, closes := useSimulator(t)
+	key, err := generateTPMKey(certforge.ECDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		verifyTPMSignature(t, key)
+	}
+	if *opens != 1 {
+		t.Errorf("opened the TPM %d times for 5 signatures", *opens)
+	}
+	// The storage key and the key stay loaded until Close
+	if n := loadedObjects(t, key.tpm); n != 2 {
+		t.Errorf("%d objects are loaded, want 2", n)
+	}
+	tpm := key.tpm
+	if err := key.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if *closes != 1 {
+		t.Errorf("closed the TPM %d times", *closes)
+	}
+	if n := loadedObjects(t, tpm); n != 0 {
+		t.Errorf("%d objects are still loaded after Close", n)
+	}
+
+	// A signature after Close opens the TPM again
+	verifyTPMSignature(t, key)
+	if *opens != 2 {
+		t.Errorf("opened the TPM %d times", *opens)
+	}
+	key.Close()
+}
+
+func TestTPMKeySignErrors(t *testing.T) {
+	useSimulator(t)
+	key, err := generateTPMKey(certforge.RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Close()
+	digest := sha256.Sum256([]byte("certforge"))
+	if _, err := key.Sign(rand.Reader, digest[:], &rsa.PSSOptions{Hash: crypto.SHA256}); err == nil {
+		t.Error("signed with PSS")
+	}
+	if _, err := key.Sign(rand.Reader, digest[:20], crypto.SHA1); err == nil {
+		t.Error("signed a SHA-1 digest")
+	}
+}
+
+func TestParseTPMKeyErrors(t *testing.T) {
+	useSimulator(t)
+	// The simulator, like most TPMs, has room for only a few loaded objects, so one key is closed before the next
+	rsaKey, err := generateTPMKey(certforge.RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey.Close()
+	key, err := generateTPMKey(certforge.ECDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Close()
+	good, err := key.pemBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := func(edit func(*tpmKeyFile)) []byte {
+		file := tpmKeyFile{
+			Type:       tpmLoadableKeyOID,
+			EmptyAuth:  true,
+			Parent:     int64(tpm2.TPMRHOwner),
+			PublicKey:  tpm2.Marshal(key.pub),
+			PrivateKey: tpm2.Marshal(key.priv),
+		}
+		edit(&file)
+		der, err := asn1.Marshal(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	tests := []struct {
+		name string
+		der  []byte
+	}{
+		{"garbage", []byte("not a key")},
+		{"truncated", good.Bytes[:len(good.Bytes)-1]},
+		{"trailing data", append(append([]byte{}, good.Bytes...), 0)},
+		{"importable key", keyFile(func(f *tpmKeyFile) { f.Type = asn1.ObjectIdentifier{2, 23, 133, 10, 1, 4} })},
+		{"endorsement parent", keyFile(func(f *tpmKeyFile) { f.Parent = int64(tpm2.TPMRHEndorsement) })},
+		{"broken public key", keyFile(func(f *tpmKeyFile) { f.PublicKey = f.PublicKey[:10] })},
+		{"broken private key", keyFile(func(f *tpmKeyFile) { f.PrivateKey = []byte{0xff} })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseTPMKey(tt.der); err == nil {
+				t.Error("parsed an invalid key file")
+			}
+		})
+	}
+
+	// A private part wrapped for another key fails to load when the key first signs
+	mixed, err := parseTPMKey(keyFile(func(f *tpmKeyFile) { f.PrivateKey = tpm2.Marshal(rsaKey.priv) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mixed.Close()
+	key.Close()
+	digest := sha256.Sum256([]byte("certforge"))
+	if _, err := mixed.Sign(rand.Reader, digest[:], crypto.SHA256); err == nil || !strings.Contains(err.Error(), "TPM2_Load") {
+		t.Errorf("got error %v for a mismatched private part", err)
+	}
+}
@@ -0,0 +1,23 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpm2/transport/windowstpm"
+)
+
+// openTPMDevice opens a context of the TPM Base Services, which share the TPM between processes
+func openTPMDevice() (transport.TPMCloser, error) {
+	tpm, err := windowstpm.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open TPM: %v", err)
+	}
+	return tpm, nil
+}
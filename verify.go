@@ -0,0 +1,305 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// runVerify implements the verify command
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	csrFlag := fs.String("csr", "", "CSR the certificate was requested with")
+	certFlag := fs.String("cert", "", "Certificate to verify")
+	caFlag := fs.String("ca", "", "Bundle of trusted root certificates")
+	intermediatesFlag := fs.String("intermediates", "", "Bundle of intermediate certificates")
+	systemRootsFlag := fs.Bool("system-roots", false, "Trust the system root certificate pool")
+	fs.Parse(args)
+
+	if *certFlag == "" {
+		return fmt.Errorf("verify requires --cert")
+	}
+	if *csrFlag == "" && *caFlag == "" && !*systemRootsFlag {
+		return fmt.Errorf("verify requires --csr, --ca, or --system-roots")
+	}
+
+	certs, err := readCertificates(*certFlag)
+	if err != nil {
+		return err
+	}
+
+	if *csrFlag != "" {
+		csr, err := readCSR(*csrFlag)
+		if err != nil {
+			return err
+		}
+		if err := verifyAgainstCSR(certs[0], csr); err != nil {
+			return err
+		}
+	}
+
+	if *caFlag == "" && !*systemRootsFlag {
+		return nil
+	}
+	if *csrFlag != "" {
+		fmt.Println()
+	}
+
+	var roots []*x509.Certificate
+	if *caFlag != "" {
+		if roots, err = readCertificates(*caFlag); err != nil {
+			return err
+		}
+	}
+	// Certificates following the leaf in its own file are treated as intermediates
+	intermediates := certs[1:]
+	if *intermediatesFlag != "" {
+		extra, err := readCertificates(*intermediatesFlag)
+		if err != nil {
+			return err
+		}
+		intermediates = append(intermediates, extra...)
+	}
+	return verifyChain(certs[0], roots, intermediates, *systemRootsFlag)
+}
+
+// readCertificates loads every certificate from a PEM or DER file
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	certs, err := certforge.ParseCertificates(data)
+	if err == certforge.ErrNoCertificates {
+		return nil, fmt.Errorf("No certificates found in %s", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("%v in %s", err, path)
+	}
+	return certs, nil
+}
+
+// readCSR loads a certificate signing request from a PEM or DER file
+func readCSR(path string) (*x509.CertificateRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	return certforge.ParseCSR(data)
+}
+
+// verifyAgainstCSR reports whether cert was issued for exactly what csr requested
+func verifyAgainstCSR(cert *x509.Certificate, csr *x509.CertificateRequest) error {
+	fmt.Println("=== Certificate vs CSR ===")
+	fmt.Println()
+
+	problems := 0
+
+	if bytes.Equal(cert.RawSubjectPublicKeyInfo, csr.RawSubjectPublicKeyInfo) {
+		fmt.Println("Public Key: match")
+	} else {
+		fmt.Println("Public Key: MISMATCH (the certificate was not issued for this CSR's key)")
+		problems++
+	}
+
+	if bytes.Equal(cert.RawSubject, csr.RawSubject) {
+		fmt.Println("Subject: match")
+	} else {
+		fmt.Println("Subject: MISMATCH")
+		fmt.Printf("  Requested: %s\n", formatFullName(csr.Subject))
+		fmt.Printf("  Issued:    %s\n", formatFullName(cert.Subject))
+		problems++
+	}
+
+	// Compare every requested SAN, then list any the CA added
+	requested := subjectAltNameList(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs)
+	issued := subjectAltNameList(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs)
+	if len(requested)+len(issued) > 0 {
+		fmt.Println("\nSubject Alternative Names:")
+	}
+	for _, name := range requested {
+		if contains(issued, name) {
+			fmt.Printf("  %s: honored\n", name)
+		} else {
+			fmt.Printf("  %s: DROPPED\n", name)
+			problems++
+		}
+	}
+	for _, name := range issued {
+		if !contains(requested, name) {
+			fmt.Printf("  %s: added by CA\n", name)
+		}
+	}
+
+	// Requested extensions other than the SANs compared above
+	var printedHeader bool
+	for _, ext := range csr.Extensions {
+		if ext.Id.Equal(oidExtSubjectAltName) {
+			continue
+		}
+		if !printedHeader {
+			fmt.Println("\nRequested Extensions:")
+			printedHeader = true
+		}
+		status := "dropped"
+		for _, issuedExt := range cert.Extensions {
+			if !issuedExt.Id.Equal(ext.Id) {
+				continue
+			}
+			if bytes.Equal(issuedExt.Value, ext.Value) && issuedExt.Critical == ext.Critical {
+				status = "honored"
+			} else {
+				status = "honored with changes"
+			}
+			break
+		}
+		fmt.Printf("  %s: %s\n", extensionName(ext.Id), status)
+	}
+
+	fmt.Println()
+	if problems > 0 {
+		return fmt.Errorf("Certificate does not match the CSR")
+	}
+	fmt.Println("Result: the certificate matches the CSR")
+	return nil
+}
+
+// subjectAltNameList flattens SANs into "TYPE:value" strings for comparison
+func subjectAltNameList(dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) []string {
+	var names []string
+	for _, name := range dnsNames {
+		names = append(names, "DNS:"+name)
+	}
+	for _, ip := range ips {
+		names = append(names, "IP:"+ip.String())
+	}
+	for _, email := range emails {
+		names = append(names, "Email:"+email)
+	}
+	for _, uri := range uris {
+		names = append(names, "URI:"+uri.String())
+	}
+	return names
+}
+
+// verifyChain builds and verifies the chain from cert to a trusted root
+func verifyChain(cert *x509.Certificate, roots, intermediates []*x509.Certificate, useSystemRoots bool) error {
+	fmt.Println("=== Chain Verification ===")
+	fmt.Println()
+
+	chains, err := certforge.VerifyChain(cert, roots, intermediates, useSystemRoots)
+	if err == nil {
+		for i, chain := range chains {
+			fmt.Printf("Chain %d:\n", i+1)
+			for depth, link := range chain {
+				role := "intermediate"
+				switch {
+				case depth == 0:
+					role = "leaf"
+				case depth == len(chain)-1:
+					role = "trust anchor"
+				}
+				fmt.Printf("  [%d] %s (%s, expires %s)\n", depth, certforge.FormatName(link.Subject), role, link.NotAfter.Format("2006-01-02"))
+			}
+		}
+		fmt.Println()
+		fmt.Println("Result: the chain is valid")
+		return nil
+	}
+
+	// Walk the chain by hand to show exactly which link fails
+	diagnoseChain(cert, roots, intermediates, useSystemRoots)
+	fmt.Println()
+	return fmt.Errorf("Chain verification failed: %v", err)
+}
+
+// diagnoseChain prints each link from cert towards a root with the checks that pass or fail
+func diagnoseChain(cert *x509.Certificate, roots, intermediates []*x509.Certificate, useSystemRoots bool) {
+	now := time.Now()
+	current := cert
+	for depth := 0; depth < 10; depth++ {
+		fmt.Printf("[%d] %s\n", depth, certforge.FormatName(current.Subject))
+		if now.Before(current.NotBefore) {
+			fmt.Printf("    Validity: NOT YET VALID (starts %s)\n", current.NotBefore.Format(time.RFC3339))
+		} else if now.After(current.NotAfter) {
+			fmt.Printf("    Validity: EXPIRED (ended %s)\n", current.NotAfter.Format(time.RFC3339))
+		} else {
+			fmt.Println("    Validity: ok")
+		}
+
+		if containsCertificate(roots, current) {
+			fmt.Println("    Trusted: yes (in --ca)")
+			return
+		}
+		if certforge.IsSelfSigned(current) {
+			fmt.Println("    Trusted: NO (self-signed certificate is not in the trusted roots)")
+			return
+		}
+
+		fmt.Printf("    Issuer: %s\n", certforge.FormatName(current.Issuer))
+		issuer, err := findChainIssuer(current, append(intermediates, roots...))
+		if issuer == nil {
+			if useSystemRoots {
+				fmt.Println("    Issuer certificate: not provided (not found in --intermediates or --ca, and the system roots do not accept it)")
+			} else {
+				fmt.Println("    Issuer certificate: NOT FOUND in --intermediates or --ca")
+			}
+			return
+		}
+		if err != nil && certforge.IsWeakSignature(current.SignatureAlgorithm) {
+			fmt.Printf("    Signature: INSECURE (signed with %s, which can be forged and is not accepted)\n", current.SignatureAlgorithm)
+			return
+		}
+		if err != nil {
+			fmt.Printf("    Signature: INVALID (%v)\n", err)
+			return
+		}
+		fmt.Println("    Signature: valid")
+		if !containsCertificate(roots, issuer) && (!issuer.BasicConstraintsValid || !issuer.IsCA) {
+			fmt.Printf("    Issuer CA flag: MISSING (%s lacks basicConstraints CA:TRUE)\n", certforge.FormatName(issuer.Subject))
+			return
+		}
+		current = issuer
+	}
+	fmt.Println("Chain is too long or contains a loop")
+}
+
+// findChainIssuer returns the candidate whose subject matches cert's issuer, preferring one
+// whose signature verifies. The error explains why the best match did not verify.
+func findChainIssuer(cert *x509.Certificate, candidates []*x509.Certificate) (*x509.Certificate, error) {
+	var match *x509.Certificate
+	var matchErr error
+	for _, candidate := range candidates {
+		if !bytes.Equal(candidate.RawSubject, cert.RawIssuer) {
+			continue
+		}
+		err := cert.CheckSignatureFrom(candidate)
+		if err == nil {
+			return candidate, nil
+		}
+		if match == nil {
+			match, matchErr = candidate, err
+		}
+	}
+	return match, matchErr
+}
+
+// containsCertificate reports whether cert is one of certs
+func containsCertificate(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/osage-io/certforge/pkg/certforge"
+)
+
+// zoneHostTypes are the record types whose owner names are offered as SANs
+var zoneHostTypes = map[string]bool{"A": true, "AAAA": true, "CNAME": true}
+
+// zoneClasses are the DNS classes a record may name before its type
+var zoneClasses = map[string]bool{"IN": true, "CH": true, "HS": true, "CS": true}
+
+// readZoneNames returns the owner names of the A, AAAA, and CNAME records of a BIND zone file, lowercased, without
+// the trailing dot, and in the order they first appear. Relative names are completed with $ORIGIN, or before one
+// with the file name without a .zone or .db extension or db. prefix, as in example.com.zone.
+func readZoneNames(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	defer f.Close()
+
+	base := filepath.Base(path)
+	origin := strings.TrimPrefix(strings.TrimSuffix(strings.TrimSuffix(base, ".zone"), ".db"), "db.") + "."
+	var names []string
+	seen := map[string]bool{}
+	owner := ""
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for {
+		fields, start, continued, err := nextZoneEntry(scanner, &lineNumber)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, start, err)
+		}
+		if fields == nil {
+			break
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%s:%d: $ORIGIN needs a domain", path, start)
+			}
+			origin = zoneAbsolute(fields[1], origin)
+			continue
+		case "$TTL":
+			continue
+		case "$INCLUDE", "$GENERATE":
+			return nil, fmt.Errorf("%s:%d: %s is not supported; give the included or generated records directly", path, start, fields[0])
+		}
+
+		// An entry starting with whitespace belongs to the owner of the previous one
+		if !continued {
+			owner = zoneAbsolute(fields[0], origin)
+			fields = fields[1:]
+		}
+		if owner == "" {
+			return nil, fmt.Errorf("%s:%d: a record without an owner name", path, start)
+		}
+		recordType := ""
+		for _, field := range fields {
+			upper := strings.ToUpper(field)
+			if zoneClasses[upper] || isZoneTTL(field) {
+				continue
+			}
+			recordType = upper
+			break
+		}
+		if !zoneHostTypes[recordType] {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(owner, "."))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	return names, nil
+}
+
+// nextZoneEntry reads the fields of the next entry of a zone file, joining the lines of an entry in parentheses,
+// without comments. It returns the line the entry starts on, whether it starts with whitespace, and nil fields at
+// the end of the file.
+func nextZoneEntry(scanner *bufio.Scanner, lineNumber *int) ([]string, int, bool, error) {
+	var fields []string
+	start, continued, depth := 0, false, 0
+	for scanner.Scan() {
+		*lineNumber++
+		line := scanner.Text()
+		if start == 0 {
+			start = *lineNumber
+			continued = line != "" && unicode.IsSpace(rune(line[0]))
+		}
+		lineFields, open, err := zoneFields(line)
+		if err != nil {
+			return nil, start, false, err
+		}
+		fields = append(fields, lineFields...)
+		if depth += open; depth < 0 {
+			return nil, start, false, fmt.Errorf("unbalanced parentheses")
+		}
+		if depth == 0 {
+			if fields == nil {
+				fields = []string{}
+			}
+			return fields, start, continued, nil
+		}
+	}
+	if depth > 0 {
+		return nil, start, false, fmt.Errorf("unbalanced parentheses")
+	}
+	return nil, start, false, nil
+}
+
+// zoneFields splits a zone file line into fields, dropping its comment, and returns how many more parentheses it
+// opens than it closes. Quoted strings are kept whole, quotes included.
+func zoneFields(line string) ([]string, int, error) {
+	var fields []string
+	var field strings.Builder
+	open, quoted, escaped := 0, false, false
+	flush := func() {
+		if field.Len() > 0 {
+			fields = append(fields, field.String())
+			field.Reset()
+		}
+	}
+	for _, r := range line {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			field.WriteRune(r)
+			escaped = true
+		case r == '"':
+			field.WriteRune(r)
+			quoted = !quoted
+		case quoted:
+			field.WriteRune(r)
+		case r == ';':
+			flush()
+			return fields, open, nil
+		case r == '(' || r == ')':
+			flush()
+			if r == '(' {
+				open++
+			} else {
+				open--
+			}
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			field.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, 0, fmt.Errorf("unterminated quoted string")
+	}
+	flush()
+	return fields, open, nil
+}
+
+// zoneAbsolute returns a name of a zone file as an absolute name with a trailing dot, completing @ and relative
+// names with origin
+func zoneAbsolute(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return name
+	case origin == ".":
+		return name + "."
+	}
+	return name + "." + origin
+}
+
+// isZoneTTL reports whether a field is a TTL, in seconds or in BIND's units, as in 3600 or 1h30m
+func isZoneTTL(field string) bool {
+	if _, err := strconv.ParseUint(field, 10, 32); err == nil {
+		return true
+	}
+	if field == "" || !unicode.IsDigit(rune(field[0])) {
+		return false
+	}
+	return strings.Trim(strings.ToLower(field), "0123456789smhdw") == ""
+}
+
+// selectZoneNames lists the names of a zone file that are valid SANs and asks which of them to add: all, none, or
+// a list of their numbers and ranges, as in 1,3-5. All of them are added when p is not interactive.
+func selectZoneNames(p *prompter, path string, names []string) []string {
+	var valid []string
+	for _, name := range names {
+		if certforge.CheckDNSName(name) == nil {
+			valid = append(valid, name)
+		}
+	}
+	if skipped := len(names) - len(valid); skipped > 0 {
+		fmt.Printf("\nSkipping %d names of %s that are not valid in certificates\n", skipped, path)
+	}
+	if len(valid) == 0 {
+		fmt.Printf("\nNo host names found in %s\n", path)
+		return nil
+	}
+	if !p.interactive {
+		fmt.Printf("Adding %d host names from %s: %s\n", len(valid), path, strings.Join(valid, ", "))
+		return valid
+	}
+
+	fmt.Printf("\nHost names in %s:\n", path)
+	for i, name := range valid {
+		fmt.Printf("  %d. %s\n", i+1, name)
+	}
+	for {
+		fmt.Print("Names to add as SANs (all, none, or numbers like 1,3-5) [default: all]: ")
+		answer, _ := p.reader.ReadString('\n')
+		selected, err := parseZoneSelection(strings.TrimSpace(answer), valid)
+		if err == nil {
+			return selected
+		}
+		fmt.Printf("%v\n", err)
+	}
+}
+
+// parseZoneSelection returns the names picked by a selection of selectZoneNames
+func parseZoneSelection(answer string, names []string) ([]string, error) {
+	switch strings.ToLower(answer) {
+	case "", "all", "a", "y", "yes":
+		return names, nil
+	case "none", "n", "no":
+		return nil, nil
+	}
+	var selected []string
+	picked := map[int]bool{}
+	for _, part := range strings.Split(answer, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		first, err := strconv.Atoi(strings.TrimSpace(from))
+		last := first
+		if err == nil && isRange {
+			last, err = strconv.Atoi(strings.TrimSpace(to))
+		}
+		if err != nil || first < 1 || last > len(names) || first > last {
+			return nil, fmt.Errorf("Invalid selection %q: use numbers from 1 to %d", part, len(names))
+		}
+		for i := first; i <= last; i++ {
+			if !picked[i] {
+				picked[i] = true
+				selected = append(selected, names[i-1])
+			}
+		}
+	}
+	return selected, nil
+}